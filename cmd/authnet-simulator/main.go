@@ -0,0 +1,36 @@
+// cmd/authnet-simulator - Sobe o simulador local da API da Authorize.net
+//
+// Uso:
+//   go run ./cmd/authnet-simulator -addr :8089
+//   AUTHNET_ENDPOINT=http://localhost:8089/xml/v1/request.api go run .
+package main
+
+import (
+    "flag"
+    "log"
+    "net/http"
+
+    "prosecure-payment-api/services/payment/authorizenet/simulator"
+)
+
+func main() {
+    addr := flag.String("addr", ":8089", "listen address")
+    bom := flag.Bool("bom", true, "prefix responses with a byte order mark, like the real API")
+    loginID := flag.String("login-id", "", "require this API login ID (optional)")
+    transactionKey := flag.String("transaction-key", "", "require this transaction key (optional)")
+    flag.Parse()
+
+    sim := simulator.New()
+    sim.SetBOM(*bom)
+    if *loginID != "" {
+        sim.RequireCredentials(*loginID, *transactionKey)
+    }
+
+    mux := http.NewServeMux()
+    mux.Handle("/xml/v1/request.api", sim)
+
+    log.Printf("Authorize.net simulator listening on %s (endpoint: http://localhost%s/xml/v1/request.api)", *addr, *addr)
+    if err := http.ListenAndServe(*addr, mux); err != nil {
+        log.Fatalf("Simulator stopped: %v", err)
+    }
+}
@@ -0,0 +1,95 @@
+// cmd/authnet-webhooks - Gerencia os webhooks registrados na Authorize.net
+//
+// Uso:
+//   go run ./cmd/authnet-webhooks list
+//   go run ./cmd/authnet-webhooks register -url https://api.prosecurelsp.com/api/authorize-net/webhook/events
+//   go run ./cmd/authnet-webhooks delete -id <webhookId>
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+
+    "prosecure-payment-api/config"
+    "prosecure-payment-api/services/payment"
+)
+
+const defaultWebhookURL = "https://api.prosecurelsp.com/api/authorize-net/webhook/events"
+
+func usage() {
+    fmt.Fprintf(os.Stderr, "Usage: %s <list|register|delete> [flags]\n", os.Args[0])
+    fmt.Fprintf(os.Stderr, "  register -url <url> [-name <name>] [-events <type1,type2>]\n")
+    fmt.Fprintf(os.Stderr, "  delete -id <webhookId>\n")
+    os.Exit(2)
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+    }
+
+    cfg := config.Load()
+    paymentService := payment.NewPaymentService(
+        cfg.AuthNet.APILoginID,
+        cfg.AuthNet.TransactionKey,
+        cfg.AuthNet.MerchantID,
+        cfg.AuthNet.Environment,
+        cfg.AuthNet.Endpoint,
+    )
+
+    switch os.Args[1] {
+    case "list":
+        webhooks, err := paymentService.ListWebhooks()
+        if err != nil {
+            log.Fatalf("Failed to list webhooks: %v", err)
+        }
+        if len(webhooks) == 0 {
+            fmt.Println("No webhooks registered")
+            return
+        }
+        for _, webhook := range webhooks {
+            fmt.Printf("%s  %-8s  %s\n", webhook.WebhookID, webhook.Status, webhook.URL)
+            for _, eventType := range webhook.EventTypes {
+                fmt.Printf("    - %s\n", eventType)
+            }
+        }
+
+    case "register":
+        fs := flag.NewFlagSet("register", flag.ExitOnError)
+        url := fs.String("url", defaultWebhookURL, "endpoint that will receive the events")
+        name := fs.String("name", "prosecure-payment-api", "webhook name")
+        events := fs.String("events", "", "comma separated event types (default: all handled events)")
+        fs.Parse(os.Args[2:])
+
+        var eventTypes []string
+        if *events != "" {
+            for _, eventType := range strings.Split(*events, ",") {
+                if eventType = strings.TrimSpace(eventType); eventType != "" {
+                    eventTypes = append(eventTypes, eventType)
+                }
+            }
+        }
+
+        webhook, err := paymentService.RegisterWebhook(*name, *url, eventTypes)
+        if err != nil {
+            log.Fatalf("Failed to register webhook: %v", err)
+        }
+        fmt.Printf("Registered webhook %s (%s) for %d event types\n", webhook.WebhookID, webhook.URL, len(webhook.EventTypes))
+
+    case "delete":
+        fs := flag.NewFlagSet("delete", flag.ExitOnError)
+        id := fs.String("id", "", "webhook ID to delete")
+        fs.Parse(os.Args[2:])
+
+        if err := paymentService.DeleteWebhook(*id); err != nil {
+            log.Fatalf("Failed to delete webhook: %v", err)
+        }
+        fmt.Printf("Deleted webhook %s\n", *id)
+
+    default:
+        usage()
+    }
+}
@@ -5,9 +5,15 @@ import (
     "log"
     "os"
     "strconv"
+    "strings"
+    "time"
     "github.com/joho/godotenv"
     "prosecure-payment-api/database"
+    "prosecure-payment-api/services/cardvault"
+    "prosecure-payment-api/services/dunning"
     "prosecure-payment-api/services/email"
+    "prosecure-payment-api/services/tax"
+    "prosecure-payment-api/services/threeds"
 )
 
 type Config struct {
@@ -17,14 +23,28 @@ type Config struct {
     Server   ServerConfig
     Session  SessionConfig
     Redis    RedisConfig
+    CardData cardvault.Config
+    ThreeDS  threeds.Config
+    Dunning  dunning.Config
+    Tax      tax.Config
+    Admin    AdminConfig
 }
 
 type AuthNetConfig struct {
+    Gateway         string // "authorizenet" (padrão) ou "fake" para rodar offline
     APILoginID      string
     TransactionKey  string
     MerchantID      string
     SignatureKey    string
+    MD5HashValue    string
     Environment     string
+    Endpoint        string // opcional: sobrescreve o endpoint request.api (ex.: simulador local)
+}
+
+// AdminConfig lista os operadores com acesso aos endpoints /admin. Ser conta master
+// não basta: todo cliente tem uma.
+type AdminConfig struct {
+    Usernames []string
 }
 
 type ServerConfig struct {
@@ -63,6 +83,18 @@ func Load() *Config {
         maxAge = 2400 // Default to 2400 if not set
     }
     workerConcurrency := 4
+    cardDataTTL := cardvault.DefaultTTL
+    if ttlMinutes, err := strconv.Atoi(os.Getenv("CARD_DATA_TTL_MINUTES")); err == nil && ttlMinutes > 0 {
+        cardDataTTL = time.Duration(ttlMinutes) * time.Minute
+    }
+    dunningRetryDays := dunning.DefaultRetryDays
+    if value := os.Getenv("DUNNING_RETRY_DAYS"); value != "" {
+        if days, err := dunning.ParseRetryDays(value); err != nil {
+            log.Printf("Warning: %v, using default dunning schedule %v", err, dunningRetryDays)
+        } else {
+            dunningRetryDays = days
+        }
+    }
     cfg := &Config{
         Database: database.DatabaseConfig{
             Host:     os.Getenv("DB_HOST"),
@@ -78,11 +110,14 @@ func Load() *Config {
             HttpOnly: httpOnly,
         },
         AuthNet: AuthNetConfig{
+            Gateway:        os.Getenv("PAYMENT_GATEWAY"),
             APILoginID:     os.Getenv("AUTHNET_API_LOGIN_ID"),
             TransactionKey: os.Getenv("AUTHNET_TRANSACTION_KEY"),
             MerchantID:     os.Getenv("AUTHNET_MERCHANT_ID"),
             SignatureKey:   os.Getenv("AUTHNET_SIGNATURE_KEY"),
+            MD5HashValue:   os.Getenv("AUTHNET_MD5_HASH_VALUE"),
             Environment:    os.Getenv("AUTHNET_ENVIRONMENT"),
+            Endpoint:       os.Getenv("AUTHNET_ENDPOINT"),
         },
         SMTP: email.SMTPConfig{
             Host:     os.Getenv("SMTP_HOST"),
@@ -97,11 +132,63 @@ func Load() *Config {
             URL: os.Getenv("REDIS_URL"),
             WorkerConcurrency: workerConcurrency,
         },
+        CardData: cardvault.Config{
+            Keys:        os.Getenv("CARD_DATA_KEYS"),
+            ActiveKeyID: os.Getenv("CARD_DATA_KEY_ID"),
+            TTL:         cardDataTTL,
+        },
+        ThreeDS: threeds.Config{
+            Mode:            os.Getenv("THREEDS_MODE"),
+            ServerURL:       os.Getenv("THREEDS_SERVER_URL"),
+            APIKey:          os.Getenv("THREEDS_API_KEY"),
+            NotificationURL: os.Getenv("THREEDS_NOTIFICATION_URL"),
+        },
+        Dunning: dunning.Config{
+            RetryDays: dunningRetryDays,
+        },
+        Tax: tax.Config{
+            RatesFile: os.Getenv("TAX_RATES_FILE"),
+        },
+        Admin: AdminConfig{
+            Usernames: parseList(os.Getenv("ADMIN_USERNAMES")),
+        },
     }
     if cfg.Redis.URL == "" {
         cfg.Redis.URL = "redis://localhost:6379/0"
         log.Printf("Warning: REDIS_URL not set, using default: %s", cfg.Redis.URL)
     }
+    if cfg.AuthNet.Gateway == "fake" {
+        log.Printf("Warning: PAYMENT_GATEWAY=fake, payments will be processed by the in-memory gateway")
+    }
+    if cfg.AuthNet.Endpoint != "" && cfg.AuthNet.Gateway != "fake" {
+        log.Printf("Warning: AUTHNET_ENDPOINT set, Authorize.net requests will be sent to %s", cfg.AuthNet.Endpoint)
+    }
+    if cfg.ThreeDS.Mode == "" {
+        cfg.ThreeDS.Mode = threeds.ModeOptional
+    }
+    if cfg.ThreeDS.Mode != threeds.ModeOff && cfg.ThreeDS.ServerURL == "" && cfg.AuthNet.Gateway != "fake" {
+        log.Printf("Warning: THREEDS_SERVER_URL not set, 3-D Secure authentication is disabled")
+        cfg.ThreeDS.Mode = threeds.ModeOff
+    }
+    if cfg.Tax.RatesFile == "" {
+        log.Printf("Warning: TAX_RATES_FILE not set, no sales tax will be charged")
+    }
+    if cfg.AuthNet.SignatureKey == "" {
+        log.Printf("Warning: AUTHNET_SIGNATURE_KEY not set, Authorize.net notifications will be rejected")
+    }
+    if len(cfg.Admin.Usernames) == 0 {
+        log.Printf("Warning: ADMIN_USERNAMES not set, admin endpoints are disabled")
+    }
     log.Printf("Session config loaded: %+v", cfg.Session)
     return cfg
-}
\ No newline at end of file
+}
+// parseList separa uma lista de valores por vírgula, ignorando entradas vazias
+func parseList(value string) []string {
+    var items []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
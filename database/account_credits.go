@@ -0,0 +1,209 @@
+// database/account_credits.go - Créditos de conta abatidos na próxima cobrança da assinatura
+//
+// O crédito é aplicado reduzindo o valor da assinatura ARB no ciclo de cycle_date.
+// Depois que esse ciclo é cobrado o worker marca o crédito como aplicado e devolve a ARB
+// ao valor cheio (menos os créditos do ciclo seguinte, se houver).
+//
+// Esquema esperado:
+//
+//   CREATE TABLE account_credits (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       master_reference VARCHAR(36) NOT NULL,
+//       amount DECIMAL(10,2) NOT NULL,
+//       reason VARCHAR(64) NOT NULL,                  -- ex.: plan_removal
+//       status VARCHAR(16) NOT NULL,                  -- scheduled, applied, transferred
+//       cycle_date DATE NOT NULL,                     -- cobrança em que o crédito é abatido
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       applied_at TIMESTAMP NULL,
+//       KEY idx_account_credits_master (master_reference, status, cycle_date),
+//       KEY idx_account_credits_due (status, cycle_date)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// Status dos créditos de conta
+const (
+    AccountCreditStatusScheduled   = "scheduled"
+    AccountCreditStatusApplied     = "applied"
+    AccountCreditStatusTransferred = "transferred" // somado ao pro-rata de uma troca de intervalo
+)
+
+// AccountCredit é uma linha de account_credits
+type AccountCredit struct {
+    ID              int64     `json:"id"`
+    MasterReference string    `json:"master_reference"`
+    Amount          float64   `json:"amount"`
+    Reason          string    `json:"reason"`
+    Status          string    `json:"status"`
+    CycleDate       time.Time `json:"cycle_date"`
+}
+
+// CreateAccountCredits registra os créditos de uma conta (um por ciclo de cobrança)
+func (c *Connection) CreateAccountCredits(credits []AccountCredit) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    if err := insertAccountCredits(ctx, tx, credits); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing account credits: %v", err)
+    }
+
+    return nil
+}
+
+func insertAccountCredits(ctx context.Context, tx *sql.Tx, credits []AccountCredit) error {
+    for i := range credits {
+        result, err := tx.ExecContext(ctx, `
+            INSERT INTO account_credits (master_reference, amount, reason, status, cycle_date, created_at)
+            VALUES (?, ?, ?, ?, ?, NOW())`,
+            credits[i].MasterReference, credits[i].Amount, credits[i].Reason,
+            AccountCreditStatusScheduled, credits[i].CycleDate.Format("2006-01-02"))
+        if err != nil {
+            return fmt.Errorf("error creating account credit: %v", err)
+        }
+
+        if credits[i].ID, err = result.LastInsertId(); err != nil {
+            return fmt.Errorf("error getting account credit ID: %v", err)
+        }
+        credits[i].Status = AccountCreditStatusScheduled
+    }
+
+    return nil
+}
+
+// GetScheduledCreditTotal soma os créditos agendados para o ciclo de cycleDate
+func (c *Connection) GetScheduledCreditTotal(masterRef string, cycleDate time.Time) (float64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var total float64
+    err := c.db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(amount), 0) FROM account_credits
+        WHERE master_reference = ? AND status = ? AND cycle_date = ?`,
+        masterRef, AccountCreditStatusScheduled, cycleDate.Format("2006-01-02")).Scan(&total)
+    if err != nil {
+        return 0, fmt.Errorf("error getting account credits for %s: %v", masterRef, err)
+    }
+
+    return total, nil
+}
+
+// GetPendingCreditTotal soma todos os créditos ainda agendados da conta
+func (c *Connection) GetPendingCreditTotal(masterRef string) (float64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var total float64
+    err := c.db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(amount), 0) FROM account_credits
+        WHERE master_reference = ? AND status = ?`,
+        masterRef, AccountCreditStatusScheduled).Scan(&total)
+    if err != nil {
+        return 0, fmt.Errorf("error getting pending account credits for %s: %v", masterRef, err)
+    }
+
+    return total, nil
+}
+
+// GetUpcomingCreditTotal soma os créditos agendados para o próximo ciclo ainda não cobrado
+// (o crédito que está abatido do valor atual da ARB)
+func (c *Connection) GetUpcomingCreditTotal(masterRef string) (float64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var total float64
+    err := c.db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(amount), 0) FROM account_credits
+        WHERE master_reference = ? AND status = ? AND cycle_date = (
+            SELECT MIN(cycle_date) FROM account_credits
+            WHERE master_reference = ? AND status = ? AND cycle_date >= CURDATE())`,
+        masterRef, AccountCreditStatusScheduled, masterRef, AccountCreditStatusScheduled).Scan(&total)
+    if err != nil {
+        return 0, fmt.Errorf("error getting upcoming account credits for %s: %v", masterRef, err)
+    }
+
+    return total, nil
+}
+
+// GetAccountsWithAppliedCycles lista as contas com créditos agendados para ciclos já
+// cobrados (cycle_date anterior a before)
+func (c *Connection) GetAccountsWithAppliedCycles(before time.Time, limit int) ([]string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT DISTINCT master_reference FROM account_credits
+        WHERE status = ? AND cycle_date < ?
+        LIMIT ?`,
+        AccountCreditStatusScheduled, before.Format("2006-01-02"), limit)
+    if err != nil {
+        return nil, fmt.Errorf("error listing due account credits: %v", err)
+    }
+    defer rows.Close()
+
+    var masterRefs []string
+    for rows.Next() {
+        var masterRef string
+        if err := rows.Scan(&masterRef); err != nil {
+            return nil, fmt.Errorf("error scanning account credit: %v", err)
+        }
+        masterRefs = append(masterRefs, masterRef)
+    }
+
+    return masterRefs, rows.Err()
+}
+
+// MarkAccountCreditsApplied marca como aplicados os créditos da conta de ciclos já cobrados
+func (c *Connection) MarkAccountCreditsApplied(masterRef string, before time.Time) (int64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE account_credits SET status = ?, applied_at = NOW()
+        WHERE master_reference = ? AND status = ? AND cycle_date < ?`,
+        AccountCreditStatusApplied, masterRef, AccountCreditStatusScheduled, before.Format("2006-01-02"))
+    if err != nil {
+        return 0, fmt.Errorf("error applying account credits for %s: %v", masterRef, err)
+    }
+
+    return result.RowsAffected()
+}
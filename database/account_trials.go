@@ -0,0 +1,266 @@
+// database/account_trials.go - Ciclo de vida do trial: aviso de fim, conversão e churn
+//
+// O trial termina em master_accounts.renew_date, quando a ARB faz a primeira cobrança.
+// A duração vem de plans.trial_days (NULL = um mês). Aprovada a primeira cobrança, o trial
+// é convertido; recusada, a conta é marcada como churned (e entra em dunning). Nos dois
+// casos is_trial volta a 0.
+//
+// Esquema esperado:
+//
+//   ALTER TABLE plans ADD COLUMN trial_days INT NULL;
+//
+//   CREATE TABLE account_trials (
+//       master_reference VARCHAR(36) PRIMARY KEY,
+//       started_at DATETIME NOT NULL,
+//       ends_at DATETIME NOT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- trialing, converted, churned
+//       reminder_sent_at TIMESTAMP NULL,
+//       first_charge_amount DECIMAL(10,2) NULL,
+//       first_charge_transaction_id VARCHAR(32) NULL,
+//       converted_at TIMESTAMP NULL,
+//       churned_at TIMESTAMP NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_account_trials_ends (status, ends_at)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+)
+
+// Status dos trials
+const (
+    TrialStatusTrialing  = "trialing"
+    TrialStatusConverted = "converted"
+    TrialStatusChurned   = "churned"
+)
+
+// TrialReminder é uma conta em trial cujo fim está próximo
+type TrialReminder struct {
+    MasterReference string
+    Email           string
+    Username        string
+    Name            string
+    IsAnnually      bool
+    TotalPrice      float64
+    Currency        string
+    EndsAt          time.Time
+}
+
+// TrialConversionStats resume os trials encerrados no período
+type TrialConversionStats struct {
+    Total          int     `json:"total"`
+    Trialing       int     `json:"trialing"`
+    Converted      int     `json:"converted"`
+    Churned        int     `json:"churned"`
+    ConversionRate float64 `json:"conversion_rate"` // converted / (converted + churned)
+    Revenue        float64 `json:"revenue"`         // soma das primeiras cobranças
+}
+
+// SaveAccountTrial registra o início do trial de uma conta recém-criada
+func (t *Transaction) SaveAccountTrial(masterRef string, startedAt, endsAt time.Time) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := t.tx.ExecContext(ctx, `
+        INSERT INTO account_trials (master_reference, started_at, ends_at, status, created_at)
+        VALUES (?, ?, ?, ?, NOW())`,
+        masterRef, startedAt, endsAt, TrialStatusTrialing)
+    if err != nil {
+        log.Printf("Error saving account trial: %v", err)
+        return fmt.Errorf("failed to save account trial: %v", err)
+    }
+
+    return nil
+}
+
+// GetTrialsNeedingReminder lista as contas em trial que terminam dentro de within e
+// ainda não receberam o aviso. Contas anteriores a account_trials também são incluídas.
+func (c *Connection) GetTrialsNeedingReminder(within time.Duration, limit int) ([]TrialReminder, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT ma.reference_uuid, ma.email, ma.username, ma.name,
+               COALESCE(ma.is_annually, 0), ma.total_price, COALESCE(ma.currency, 'USD'), ma.renew_date
+        FROM master_accounts ma
+        LEFT JOIN account_trials t ON t.master_reference = ma.reference_uuid
+        WHERE ma.is_trial = 1
+          AND ma.renew_date > NOW() AND ma.renew_date <= ?
+          AND t.reminder_sent_at IS NULL
+          AND (t.status IS NULL OR t.status = ?)
+        ORDER BY ma.renew_date ASC
+        LIMIT ?`,
+        time.Now().Add(within), TrialStatusTrialing, limit)
+    if err != nil {
+        return nil, fmt.Errorf("error listing trials ending soon: %v", err)
+    }
+    defer rows.Close()
+
+    var reminders []TrialReminder
+    for rows.Next() {
+        var reminder TrialReminder
+        var isAnnually int
+        if err := rows.Scan(&reminder.MasterReference, &reminder.Email, &reminder.Username, &reminder.Name,
+            &isAnnually, &reminder.TotalPrice, &reminder.Currency, &reminder.EndsAt); err != nil {
+            return nil, fmt.Errorf("error scanning trial: %v", err)
+        }
+        reminder.IsAnnually = isAnnually == 1
+        reminders = append(reminders, reminder)
+    }
+
+    return reminders, rows.Err()
+}
+
+// MarkTrialReminderSent grava o envio do aviso de fim do trial
+func (c *Connection) MarkTrialReminderSent(masterRef string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        INSERT INTO account_trials (master_reference, started_at, ends_at, status, reminder_sent_at, created_at)
+        SELECT reference_uuid, created_at, renew_date, ?, NOW(), NOW()
+        FROM master_accounts WHERE reference_uuid = ?
+        ON DUPLICATE KEY UPDATE reminder_sent_at = NOW()`,
+        TrialStatusTrialing, masterRef)
+    if err != nil {
+        return fmt.Errorf("error marking trial reminder for %s: %v", masterRef, err)
+    }
+
+    return nil
+}
+
+// ConvertTrial encerra o trial da conta como convertido depois da primeira cobrança
+// aprovada. Também vale para trials marcados como churned que se recuperaram no dunning.
+// Retorna false se a conta não estava em trial.
+func (c *Connection) ConvertTrial(masterRef, transactionID string, amount float64) (bool, error) {
+    return c.endTrial(masterRef, TrialStatusConverted, transactionID, amount)
+}
+
+// ChurnTrial encerra o trial da conta como churned depois de a primeira cobrança ser
+// recusada. Retorna false se a conta não estava em trial.
+func (c *Connection) ChurnTrial(masterRef string) (bool, error) {
+    return c.endTrial(masterRef, TrialStatusChurned, "", 0)
+}
+
+func (c *Connection) endTrial(masterRef, status, transactionID string, amount float64) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx,
+        "UPDATE master_accounts SET is_trial = 0 WHERE reference_uuid = ? AND is_trial = 1",
+        masterRef)
+    if err != nil {
+        return false, fmt.Errorf("error ending trial: %v", err)
+    }
+    flipped, _ := result.RowsAffected()
+
+    var amountValue, transactionValue interface{}
+    if status == TrialStatusConverted {
+        amountValue = amount
+        if transactionID != "" {
+            transactionValue = transactionID
+        }
+    }
+
+    // Só um trial em andamento vira churned; converted também aceita um churned
+    fromStatuses := []interface{}{TrialStatusTrialing, TrialStatusTrialing}
+    if status == TrialStatusConverted {
+        fromStatuses[1] = TrialStatusChurned
+    }
+
+    result, err = tx.ExecContext(ctx, `
+        UPDATE account_trials
+        SET status = ?,
+            first_charge_amount = COALESCE(?, first_charge_amount),
+            first_charge_transaction_id = COALESCE(?, first_charge_transaction_id),
+            converted_at = CASE WHEN ? = ? THEN NOW() ELSE converted_at END,
+            churned_at = CASE WHEN ? = ? THEN NOW() ELSE churned_at END
+        WHERE master_reference = ? AND status IN (?, ?)`,
+        status, amountValue, transactionValue,
+        status, TrialStatusConverted, status, TrialStatusChurned,
+        masterRef, fromStatuses[0], fromStatuses[1])
+    if err != nil {
+        return false, fmt.Errorf("error updating account trial: %v", err)
+    }
+    updated, _ := result.RowsAffected()
+
+    if flipped > 0 && updated == 0 {
+        // Conta criada antes de account_trials
+        _, err = tx.ExecContext(ctx, `
+            INSERT IGNORE INTO account_trials (master_reference, started_at, ends_at, status,
+                first_charge_amount, first_charge_transaction_id, converted_at, churned_at, created_at)
+            SELECT reference_uuid, created_at, NOW(), ?, ?, ?,
+                   CASE WHEN ? = ? THEN NOW() END, CASE WHEN ? = ? THEN NOW() END, NOW()
+            FROM master_accounts WHERE reference_uuid = ?`,
+            status, amountValue, transactionValue,
+            status, TrialStatusConverted, status, TrialStatusChurned, masterRef)
+        if err != nil {
+            return false, fmt.Errorf("error saving account trial: %v", err)
+        }
+    }
+
+    if flipped == 0 && updated == 0 {
+        return false, nil
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, fmt.Errorf("error committing trial end: %v", err)
+    }
+
+    log.Printf("Trial of %s ended as %s", masterRef, status)
+    return true, nil
+}
+
+// GetTrialConversionStats resume os trials com fim no período
+func (c *Connection) GetTrialConversionStats(from, to time.Time) (*TrialConversionStats, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var stats TrialConversionStats
+    err := c.db.QueryRowContext(ctx, `
+        SELECT COUNT(*),
+               COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+               COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+               COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+               COALESCE(SUM(CASE WHEN status = ? THEN first_charge_amount ELSE 0 END), 0)
+        FROM account_trials
+        WHERE ends_at >= ? AND ends_at < ?`,
+        TrialStatusTrialing, TrialStatusConverted, TrialStatusChurned, TrialStatusConverted,
+        from, to).Scan(&stats.Total, &stats.Trialing, &stats.Converted, &stats.Churned, &stats.Revenue)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, fmt.Errorf("error getting trial stats: %v", err)
+    }
+
+    if ended := stats.Converted + stats.Churned; ended > 0 {
+        stats.ConversionRate = float64(stats.Converted) / float64(ended)
+    }
+
+    return &stats, nil
+}
@@ -8,6 +8,7 @@ import (
     "log"
     "time"
     "prosecure-payment-api/models"
+    "prosecure-payment-api/services/tax"
     "prosecure-payment-api/utils"
 )
 
@@ -19,7 +20,8 @@ type DatabaseConfig struct {
 }
 
 type Connection struct {
-    db *sql.DB
+    db  *sql.DB
+    tax tax.Engine // nil = sem imposto (ver SetTaxEngine)
 }
 
 func NewConnection(config DatabaseConfig) (*Connection, error) {
@@ -141,7 +143,10 @@ func (c *Connection) GetCheckoutData(checkoutID string) (*models.CheckoutData, e
 					ch.additional, 
 					ch.plans_json, 
 					ch.username, 
-					ch.passphrase
+					ch.passphrase,
+					COALESCE(ch.coupon_code, ''),
+					COALESCE(ch.country, ''),
+					COALESCE(ch.currency, '')
 			FROM checkout_historics ch
 			WHERE ch.checkout_id = ?
 	`
@@ -162,6 +167,9 @@ func (c *Connection) GetCheckoutData(checkoutID string) (*models.CheckoutData, e
 			&plansJSON,
 			&data.Username,
 			&data.Passphrase,
+			&data.CouponCode,
+			&data.Country,
+			&data.Currency,
 	)
 	if err != nil {
 			log.Printf("Error getting checkout data: %v", err)
@@ -181,27 +189,43 @@ func (c *Connection) GetCheckoutData(checkoutID string) (*models.CheckoutData, e
 
 	totalItems := len(plansData)
 
+	if data.Currency == "" {
+			data.Currency = models.DefaultCurrency
+	}
+
 	var plans []models.Plan
 	for planID, quantity := range planQuantities {
 			var planPrice float64
 			var discountJSON string
 			var planName string
+			var trialDays int
 
 			err := c.db.QueryRow(`
-					SELECT name, price, single_discount 
+					SELECT name, price, single_discount, COALESCE(trial_days, 0)
 					FROM plans 
-					WHERE id = ?`, planID).Scan(&planName, &planPrice, &discountJSON)
+					WHERE id = ?`, planID).Scan(&planName, &planPrice, &discountJSON, &trialDays)
 			if err != nil {
 					log.Printf("Error getting plan details for ID %d: %v", planID, err)
 					continue
 			}
 
+			// Preço na moeda do checkout (plans guarda o preço em USD)
+			planPrice, err = c.PlanPrice(planID, data.Currency, planPrice)
+			if err != nil {
+					return nil, err
+			}
+
 			discountRules, err := utils.ParseDiscountRules(discountJSON)
 			if err != nil {
 					log.Printf("Warning: Error parsing discount rules for plan %d: %v", planID, err)
 					discountRules = []utils.DiscountRule{}
 			}
 
+			// A conta inteira renova na mesma data: vale o menor trial entre os planos
+			if trialDays > 0 && (data.TrialDays == 0 || trialDays < data.TrialDays) {
+					data.TrialDays = trialDays
+			}
+
 			discount, discountPercent := utils.CalculateDiscount(planPrice, totalItems, discountRules)
 			finalPrice := utils.Round(planPrice - discount)
 
@@ -215,6 +239,7 @@ func (c *Connection) GetCheckoutData(checkoutID string) (*models.CheckoutData, e
 							Username:  data.Username,
 							Email:     data.Email,
 							Quantity:  quantity,
+							TrialDays: trialDays,
 					}
 					plans = append(plans, plan)
 			}
@@ -234,6 +259,29 @@ func (c *Connection) GetCheckoutData(checkoutID string) (*models.CheckoutData, e
 	data.Discount = utils.Round(totalDiscount)
 	data.Total = utils.Round(subtotal - totalDiscount)
 
+	// Cupom aplicado ao checkout: os limites já foram conferidos ao aplicar e são
+	// conferidos de novo no resgate, junto com a criação da conta
+	if data.CouponCode != "" {
+			coupon, err := c.GetCouponByCode(data.CouponCode)
+			if err != nil {
+					log.Printf("Warning: Coupon %s of checkout %s not loaded: %v", data.CouponCode, checkoutID, err)
+					data.CouponCode = ""
+			} else if discount := coupon.Discount(data.CouponLines()); discount > 0 {
+					data.Coupon = coupon
+					data.CouponDiscount = discount
+					data.Discount = utils.Round(data.Discount + discount)
+					data.Total = utils.Round(data.Total - discount)
+			}
+	}
+
+	// Imposto pelo endereço de cobrança, sobre o valor já com desconto
+	rate := c.TaxRate(tax.Address{State: data.State, City: data.City, ZipCode: data.ZipCode})
+	if rate.Percent > 0 {
+			data.TaxRate = rate.Percent
+			data.Tax = rate.Amount(data.Total)
+			data.Total = utils.Round(data.Total + data.Tax)
+	}
+
 	log.Printf("Successfully fetched checkout data with pricing: %+v", data)
 	return &data, nil
 }
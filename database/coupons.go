@@ -0,0 +1,693 @@
+// database/coupons.go - Cupons/códigos promocionais e seus resgates
+//
+// O desconto da primeira cobrança entra direto no valor cobrado (valor inicial da ARB no
+// checkout, pro-rata no add-plans). As cobranças seguintes de um cupom recorrente viram
+// créditos de conta (reason = coupon), abatidos pela ARB no ciclo de cada um.
+//
+// Quando o checkout é cobrado antes de a conta existir, o resgate é reservado antes da
+// cobrança (sem master_reference nem créditos) e completado na criação da conta.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE coupons (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       code VARCHAR(32) NOT NULL,
+//       description VARCHAR(255) NULL,
+//       discount_type VARCHAR(16) NOT NULL,           -- percent, fixed
+//       discount_value DECIMAL(10,2) NOT NULL,
+//       cycles INT NOT NULL DEFAULT 1,                -- cobranças com desconto (1 = só a primeira)
+//       plan_ids JSON NULL,                           -- NULL/vazio = todos os planos
+//       expires_at TIMESTAMP NULL,
+//       max_redemptions INT NOT NULL DEFAULT 0,       -- 0 = ilimitado
+//       max_per_customer INT NOT NULL DEFAULT 0,      -- 0 = ilimitado
+//       times_redeemed INT NOT NULL DEFAULT 0,
+//       is_active TINYINT(1) NOT NULL DEFAULT 1,
+//       created_by VARCHAR(255) NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       UNIQUE KEY uniq_coupons_code (code)
+//   )
+//
+//   CREATE TABLE coupon_redemptions (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       coupon_id BIGINT NOT NULL,
+//       master_reference VARCHAR(36) NOT NULL,        -- vazio enquanto o resgate do checkout está reservado
+//       email VARCHAR(255) NOT NULL,
+//       context VARCHAR(16) NOT NULL,                 -- checkout, add_plans
+//       checkout_id VARCHAR(64) NULL,
+//       discount_amount DECIMAL(10,2) NOT NULL,       -- desconto da primeira cobrança
+//       total_discount DECIMAL(10,2) NOT NULL,        -- somando os créditos dos ciclos seguintes
+//       cycles INT NOT NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_coupon_redemptions_coupon (coupon_id, created_at),
+//       KEY idx_coupon_redemptions_email (coupon_id, email)
+//   )
+//
+//   ALTER TABLE checkout_historics ADD COLUMN coupon_code VARCHAR(32) NULL;
+package database
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "time"
+
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/utils"
+)
+
+// AccountCreditReasonCoupon identifica os créditos gerados por cupons recorrentes
+const AccountCreditReasonCoupon = "coupon"
+
+// CouponRedemption é uma linha de coupon_redemptions
+type CouponRedemption struct {
+    ID              int64     `json:"id"`
+    CouponID        int64     `json:"coupon_id"`
+    Code            string    `json:"code,omitempty"`
+    MasterReference string    `json:"master_reference"`
+    Email           string    `json:"email"`
+    Context         string    `json:"context"`
+    CheckoutID      string    `json:"checkout_id,omitempty"`
+    DiscountAmount  float64   `json:"discount_amount"`
+    TotalDiscount   float64   `json:"total_discount"`
+    Cycles          int       `json:"cycles"`
+    CreatedAt       time.Time `json:"created_at"`
+}
+
+// CouponRedemptionStats resume os resgates de um cupom (ou de todos) em um período
+type CouponRedemptionStats struct {
+    Redemptions   int     `json:"redemptions"`
+    Customers     int     `json:"customers"`
+    FirstDiscount float64 `json:"first_discount"`
+    TotalDiscount float64 `json:"total_discount"`
+}
+
+// CouponCredits monta os créditos de um cupom recorrente: um por ciclo, a partir de
+// firstCycle e a cada intervalMonths
+func CouponCredits(masterRef string, amount float64, cycles int, firstCycle time.Time, intervalMonths int) []AccountCredit {
+    credits := make([]AccountCredit, 0, cycles)
+    for i := 0; i < cycles; i++ {
+        credits = append(credits, AccountCredit{
+            MasterReference: masterRef,
+            Amount:          amount,
+            Reason:          AccountCreditReasonCoupon,
+            CycleDate:       firstCycle.AddDate(0, i*intervalMonths, 0),
+        })
+    }
+    return credits
+}
+
+const couponColumns = `
+    id, code, COALESCE(description, ''), discount_type, discount_value, cycles,
+    COALESCE(plan_ids, ''), expires_at, max_redemptions, max_per_customer, times_redeemed,
+    is_active, COALESCE(created_by, ''), created_at, updated_at`
+
+func scanCoupon(scanner interface{ Scan(...interface{}) error }) (*models.Coupon, error) {
+    var coupon models.Coupon
+    var planIDs string
+    var expiresAt sql.NullTime
+    var isActive int
+
+    if err := scanner.Scan(&coupon.ID, &coupon.Code, &coupon.Description, &coupon.DiscountType,
+        &coupon.DiscountValue, &coupon.Cycles, &planIDs, &expiresAt, &coupon.MaxRedemptions,
+        &coupon.MaxPerCustomer, &coupon.TimesRedeemed, &isActive, &coupon.CreatedBy,
+        &coupon.CreatedAt, &coupon.UpdatedAt); err != nil {
+        return nil, err
+    }
+
+    if planIDs != "" {
+        if err := json.Unmarshal([]byte(planIDs), &coupon.PlanIDs); err != nil {
+            return nil, fmt.Errorf("error parsing plan_ids of coupon %s: %v", coupon.Code, err)
+        }
+    }
+    if expiresAt.Valid {
+        coupon.ExpiresAt = &expiresAt.Time
+    }
+    coupon.IsActive = isActive == 1
+
+    return &coupon, nil
+}
+
+func couponPlanIDs(coupon *models.Coupon) (interface{}, error) {
+    if len(coupon.PlanIDs) == 0 {
+        return nil, nil
+    }
+    data, err := json.Marshal(coupon.PlanIDs)
+    if err != nil {
+        return nil, fmt.Errorf("error marshaling plan_ids: %v", err)
+    }
+    return string(data), nil
+}
+
+// CreateCoupon cadastra um cupom. O código deve estar normalizado.
+func (c *Connection) CreateCoupon(coupon *models.Coupon) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    planIDs, err := couponPlanIDs(coupon)
+    if err != nil {
+        return err
+    }
+
+    result, err := c.db.ExecContext(ctx, `
+        INSERT INTO coupons
+            (code, description, discount_type, discount_value, cycles, plan_ids, expires_at,
+             max_redemptions, max_per_customer, times_redeemed, is_active, created_by, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 1, ?, NOW(), NOW())`,
+        coupon.Code, coupon.Description, coupon.DiscountType, coupon.DiscountValue, coupon.Cycles,
+        planIDs, coupon.ExpiresAt, coupon.MaxRedemptions, coupon.MaxPerCustomer, coupon.CreatedBy)
+    if err != nil {
+        return fmt.Errorf("error creating coupon %s: %v", coupon.Code, err)
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return fmt.Errorf("error getting coupon ID: %v", err)
+    }
+
+    coupon.ID = id
+    coupon.IsActive = true
+    return nil
+}
+
+// UpdateCoupon altera as regras de um cupom. O código e o contador de resgates não mudam;
+// resgates já feitos mantêm o desconto calculado na época.
+func (c *Connection) UpdateCoupon(coupon *models.Coupon) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    planIDs, err := couponPlanIDs(coupon)
+    if err != nil {
+        return err
+    }
+
+    isActive := 0
+    if coupon.IsActive {
+        isActive = 1
+    }
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE coupons
+        SET description = ?, discount_type = ?, discount_value = ?, cycles = ?, plan_ids = ?,
+            expires_at = ?, max_redemptions = ?, max_per_customer = ?, is_active = ?, updated_at = NOW()
+        WHERE id = ?`,
+        coupon.Description, coupon.DiscountType, coupon.DiscountValue, coupon.Cycles, planIDs,
+        coupon.ExpiresAt, coupon.MaxRedemptions, coupon.MaxPerCustomer, isActive, coupon.ID)
+    if err != nil {
+        return fmt.Errorf("error updating coupon %d: %v", coupon.ID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        if _, err := c.GetCoupon(coupon.ID); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// DeactivateCoupon desativa um cupom. Cupons não são apagados para manter o histórico de resgates.
+func (c *Connection) DeactivateCoupon(id int64) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx,
+        "UPDATE coupons SET is_active = 0, updated_at = NOW() WHERE id = ?", id)
+    if err != nil {
+        return fmt.Errorf("error deactivating coupon %d: %v", id, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        if _, err := c.GetCoupon(id); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// GetCoupon busca um cupom pelo ID. Retorna sql.ErrNoRows se não existir.
+func (c *Connection) GetCoupon(id int64) (*models.Coupon, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    coupon, err := scanCoupon(c.db.QueryRowContext(ctx,
+        "SELECT "+couponColumns+" FROM coupons WHERE id = ?", id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting coupon %d: %v", id, err)
+    }
+
+    return coupon, nil
+}
+
+// GetCouponByCode busca um cupom pelo código normalizado. Retorna sql.ErrNoRows se não existir.
+func (c *Connection) GetCouponByCode(code string) (*models.Coupon, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    coupon, err := scanCoupon(c.db.QueryRowContext(ctx,
+        "SELECT "+couponColumns+" FROM coupons WHERE code = ?", code))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting coupon %s: %v", code, err)
+    }
+
+    return coupon, nil
+}
+
+// ListCoupons lista os cupons, mais recentes primeiro
+func (c *Connection) ListCoupons(activeOnly bool, limit, offset int) ([]models.Coupon, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    query := "SELECT " + couponColumns + " FROM coupons"
+    if activeOnly {
+        query += " WHERE is_active = 1"
+    }
+    query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+
+    rows, err := c.db.QueryContext(ctx, query, limit, offset)
+    if err != nil {
+        return nil, fmt.Errorf("error listing coupons: %v", err)
+    }
+    defer rows.Close()
+
+    coupons := []models.Coupon{}
+    for rows.Next() {
+        coupon, err := scanCoupon(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning coupon: %v", err)
+        }
+        coupons = append(coupons, *coupon)
+    }
+
+    return coupons, rows.Err()
+}
+
+// CountCustomerRedemptions conta os resgates do cupom feitos pelo email
+func (c *Connection) CountCustomerRedemptions(couponID int64, email string) (int, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var count int
+    err := c.db.QueryRowContext(ctx,
+        "SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND email = ?",
+        couponID, email).Scan(&count)
+    if err != nil {
+        return 0, fmt.Errorf("error counting redemptions of coupon %d: %v", couponID, err)
+    }
+
+    return count, nil
+}
+
+// SetCheckoutCoupon grava (ou limpa, com code vazio) o cupom aplicado ao checkout
+func (c *Connection) SetCheckoutCoupon(checkoutID, code string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var value interface{}
+    if code != "" {
+        value = code
+    }
+
+    result, err := c.db.ExecContext(ctx,
+        "UPDATE checkout_historics SET coupon_code = ? WHERE checkout_id = ?", value, checkoutID)
+    if err != nil {
+        return fmt.Errorf("error setting coupon of checkout %s: %v", checkoutID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        var exists int
+        if err := c.db.QueryRowContext(ctx,
+            "SELECT 1 FROM checkout_historics WHERE checkout_id = ?", checkoutID).Scan(&exists); err != nil {
+            if err == sql.ErrNoRows {
+                return err
+            }
+            return fmt.Errorf("error getting checkout %s: %v", checkoutID, err)
+        }
+    }
+
+    return nil
+}
+
+// redeemCoupon trava o cupom, confere os limites e registra o resgate com os créditos dos
+// ciclos seguintes. Retorna false (sem gravar nada) se o cupom não puder mais ser usado.
+func redeemCoupon(ctx context.Context, tx *sql.Tx, redemption *CouponRedemption, credits []AccountCredit) (bool, error) {
+    var isActive, maxRedemptions, maxPerCustomer, timesRedeemed int
+    var expiresAt sql.NullTime
+    err := tx.QueryRowContext(ctx, `
+        SELECT is_active, expires_at, max_redemptions, max_per_customer, times_redeemed
+        FROM coupons WHERE id = ? FOR UPDATE`, redemption.CouponID).Scan(
+        &isActive, &expiresAt, &maxRedemptions, &maxPerCustomer, &timesRedeemed)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return false, nil
+        }
+        return false, fmt.Errorf("error locking coupon %d: %v", redemption.CouponID, err)
+    }
+
+    if isActive != 1 || (expiresAt.Valid && !time.Now().Before(expiresAt.Time)) ||
+        (maxRedemptions > 0 && timesRedeemed >= maxRedemptions) {
+        return false, nil
+    }
+
+    if maxPerCustomer > 0 {
+        var used int
+        if err := tx.QueryRowContext(ctx,
+            "SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND email = ?",
+            redemption.CouponID, redemption.Email).Scan(&used); err != nil {
+            return false, fmt.Errorf("error counting redemptions of coupon %d: %v", redemption.CouponID, err)
+        }
+        if used >= maxPerCustomer {
+            return false, nil
+        }
+    }
+
+    if _, err := tx.ExecContext(ctx,
+        "UPDATE coupons SET times_redeemed = times_redeemed + 1, updated_at = NOW() WHERE id = ?",
+        redemption.CouponID); err != nil {
+        return false, fmt.Errorf("error updating coupon %d: %v", redemption.CouponID, err)
+    }
+
+    var checkoutID interface{}
+    if redemption.CheckoutID != "" {
+        checkoutID = redemption.CheckoutID
+    }
+
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO coupon_redemptions
+            (coupon_id, master_reference, email, context, checkout_id, discount_amount, total_discount, cycles, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())`,
+        redemption.CouponID, redemption.MasterReference, redemption.Email, redemption.Context,
+        checkoutID, redemption.DiscountAmount, redemption.TotalDiscount, redemption.Cycles)
+    if err != nil {
+        return false, fmt.Errorf("error recording redemption of coupon %d: %v", redemption.CouponID, err)
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return false, fmt.Errorf("error getting coupon redemption ID: %v", err)
+    }
+    redemption.ID = id
+
+    if err := insertAccountCredits(ctx, tx, credits); err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
+
+// RedeemCoupon registra o resgate de um cupom na transação de criação da conta
+func (t *Transaction) RedeemCoupon(redemption *CouponRedemption, credits []AccountCredit) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    return redeemCoupon(ctx, t.tx, redemption, credits)
+}
+
+// checkoutRedemption monta o resgate do cupom do checkout
+func checkoutRedemption(checkout *models.CheckoutData, masterRef string) *CouponRedemption {
+    coupon := checkout.Coupon
+    return &CouponRedemption{
+        CouponID:        coupon.ID,
+        MasterReference: masterRef,
+        Email:           checkout.Email,
+        Context:         models.CouponContextCheckout,
+        CheckoutID:      checkout.ID,
+        DiscountAmount:  checkout.CouponDiscount,
+        TotalDiscount:   utils.Round(checkout.CouponDiscount * float64(coupon.Cycles)),
+        Cycles:          coupon.Cycles,
+    }
+}
+
+// RedeemCheckoutCoupon registra o resgate do cupom do checkout na criação da conta. O
+// desconto de cada ciclo vira um crédito a partir de firstCycle; o do primeiro ciclo já está
+// abatido do valor inicial da ARB e o crédito dele faz o worker devolver a ARB ao valor
+// cheio (ou ao do ciclo seguinte) depois da cobrança. Um resgate reservado por
+// ReserveCheckoutCoupon é completado sem conferir os limites de novo: o cliente já foi
+// cobrado com o desconto.
+func (t *Transaction) RedeemCheckoutCoupon(checkout *models.CheckoutData, masterRef string, firstCycle time.Time) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    coupon := checkout.Coupon
+    credits := CouponCredits(masterRef, checkout.CouponDiscount, coupon.Cycles, firstCycle, checkout.BillingIntervalMonths())
+
+    result, err := t.tx.ExecContext(ctx, `
+        UPDATE coupon_redemptions SET master_reference = ?
+        WHERE coupon_id = ? AND checkout_id = ? AND master_reference = ''`,
+        masterRef, coupon.ID, checkout.ID)
+    if err != nil {
+        return false, fmt.Errorf("error completing redemption of coupon %d: %v", coupon.ID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows > 0 {
+        if err := insertAccountCredits(ctx, t.tx, credits); err != nil {
+            return false, err
+        }
+        return true, nil
+    }
+
+    return redeemCoupon(ctx, t.tx, checkoutRedemption(checkout, masterRef), credits)
+}
+
+// ReserveCheckoutCoupon reserva o resgate do cupom do checkout antes da cobrança, quando a
+// conta ainda não existe. Reservar de novo o mesmo checkout não conta outro resgate.
+// Retorna false se o cupom não puder mais ser usado.
+func (c *Connection) ReserveCheckoutCoupon(checkout *models.CheckoutData) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    var id int64
+    err = tx.QueryRowContext(ctx,
+        "SELECT id FROM coupon_redemptions WHERE coupon_id = ? AND checkout_id = ? LIMIT 1",
+        checkout.Coupon.ID, checkout.ID).Scan(&id)
+    if err == nil {
+        return true, nil
+    } else if err != sql.ErrNoRows {
+        return false, fmt.Errorf("error checking redemption of checkout %s: %v", checkout.ID, err)
+    }
+
+    redeemed, err := redeemCoupon(ctx, tx, checkoutRedemption(checkout, ""), nil)
+    if err != nil || !redeemed {
+        return false, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, fmt.Errorf("error committing coupon reservation: %v", err)
+    }
+
+    return true, nil
+}
+
+// ReleaseCheckoutCoupon desfaz a reserva de ReserveCheckoutCoupon quando a cobrança do
+// checkout falha. Resgates já completados na criação da conta não são afetados.
+func (c *Connection) ReleaseCheckoutCoupon(checkoutID string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var redemption CouponRedemption
+    err := c.db.QueryRowContext(ctx,
+        "SELECT id, coupon_id FROM coupon_redemptions WHERE checkout_id = ? AND master_reference = '' LIMIT 1",
+        checkoutID).Scan(&redemption.ID, &redemption.CouponID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil
+        }
+        return fmt.Errorf("error getting coupon reservation of checkout %s: %v", checkoutID, err)
+    }
+
+    return c.ReleaseCouponRedemption(&redemption)
+}
+
+// RedeemCoupon registra o resgate de um cupom e os créditos dos ciclos seguintes.
+// Retorna false se o cupom não puder mais ser usado.
+func (c *Connection) RedeemCoupon(redemption *CouponRedemption, credits []AccountCredit) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    redeemed, err := redeemCoupon(ctx, tx, redemption, credits)
+    if err != nil || !redeemed {
+        return false, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, fmt.Errorf("error committing coupon redemption: %v", err)
+    }
+
+    log.Printf("Coupon %d redeemed by %s (%s): $%.2f over %d cycle(s)", redemption.CouponID,
+        redemption.MasterReference, redemption.Context, redemption.TotalDiscount, redemption.Cycles)
+    return true, nil
+}
+
+// ReleaseCouponRedemption desfaz um resgate cuja cobrança não foi concluída. Só vale para
+// resgates registrados sem créditos (os créditos são criados depois da cobrança).
+func (c *Connection) ReleaseCouponRedemption(redemption *CouponRedemption) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, "DELETE FROM coupon_redemptions WHERE id = ?", redemption.ID)
+    if err != nil {
+        return fmt.Errorf("error deleting coupon redemption %d: %v", redemption.ID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return nil
+    }
+
+    if _, err := tx.ExecContext(ctx, `
+        UPDATE coupons SET times_redeemed = GREATEST(times_redeemed - 1, 0), updated_at = NOW()
+        WHERE id = ?`, redemption.CouponID); err != nil {
+        return fmt.Errorf("error updating coupon %d: %v", redemption.CouponID, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing coupon redemption release: %v", err)
+    }
+
+    return nil
+}
+
+// couponRedemptionFilter monta o WHERE dos relatórios de resgate (couponID 0 = todos)
+func couponRedemptionFilter(couponID int64, from, to time.Time) (string, []interface{}) {
+    where := " WHERE r.created_at >= ? AND r.created_at < ?"
+    args := []interface{}{from, to}
+    if couponID > 0 {
+        where += " AND r.coupon_id = ?"
+        args = append(args, couponID)
+    }
+    return where, args
+}
+
+// ListCouponRedemptions lista os resgates do período, mais recentes primeiro
+func (c *Connection) ListCouponRedemptions(couponID int64, from, to time.Time, limit, offset int) ([]CouponRedemption, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    where, args := couponRedemptionFilter(couponID, from, to)
+    args = append(args, limit, offset)
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT r.id, r.coupon_id, c.code, r.master_reference, r.email, r.context,
+               COALESCE(r.checkout_id, ''), r.discount_amount, r.total_discount, r.cycles, r.created_at
+        FROM coupon_redemptions r
+        JOIN coupons c ON c.id = r.coupon_id`+where+`
+        ORDER BY r.created_at DESC LIMIT ? OFFSET ?`, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing coupon redemptions: %v", err)
+    }
+    defer rows.Close()
+
+    redemptions := []CouponRedemption{}
+    for rows.Next() {
+        var r CouponRedemption
+        if err := rows.Scan(&r.ID, &r.CouponID, &r.Code, &r.MasterReference, &r.Email, &r.Context,
+            &r.CheckoutID, &r.DiscountAmount, &r.TotalDiscount, &r.Cycles, &r.CreatedAt); err != nil {
+            return nil, fmt.Errorf("error scanning coupon redemption: %v", err)
+        }
+        redemptions = append(redemptions, r)
+    }
+
+    return redemptions, rows.Err()
+}
+
+// GetCouponRedemptionStats soma os resgates do período
+func (c *Connection) GetCouponRedemptionStats(couponID int64, from, to time.Time) (*CouponRedemptionStats, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    where, args := couponRedemptionFilter(couponID, from, to)
+
+    var stats CouponRedemptionStats
+    err := c.db.QueryRowContext(ctx, `
+        SELECT COUNT(*), COUNT(DISTINCT r.email),
+               COALESCE(SUM(r.discount_amount), 0), COALESCE(SUM(r.total_discount), 0)
+        FROM coupon_redemptions r`+where, args...).Scan(
+        &stats.Redemptions, &stats.Customers, &stats.FirstDiscount, &stats.TotalDiscount)
+    if err != nil {
+        return nil, fmt.Errorf("error getting coupon redemption stats: %v", err)
+    }
+
+    return &stats, nil
+}
@@ -0,0 +1,105 @@
+// database/currency.go - Preços dos planos por moeda
+//
+// O preço de plans é em USD. As demais moedas têm tabela de preços própria: um plano sem
+// preço na moeda não pode ser vendido nela. A moeda escolhida no checkout passa para a
+// conta e fica registrada em cada transação e fatura.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE plan_prices (
+//       plan_id INT NOT NULL,
+//       currency CHAR(3) NOT NULL,                      -- ISO 4217 (ex.: CAD, EUR)
+//       price DECIMAL(10,2) NOT NULL,
+//       PRIMARY KEY (plan_id, currency)
+//   );
+//
+//   ALTER TABLE checkout_historics
+//       ADD COLUMN country CHAR(2) NULL,                -- ISO 3166 alfa-2
+//       ADD COLUMN currency CHAR(3) NULL;
+//
+//   ALTER TABLE master_accounts ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+//   ALTER TABLE transactions ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+//   ALTER TABLE invoices ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+
+    "prosecure-payment-api/models"
+)
+
+// accountCurrencySQL é a moeda da conta para INSERTs em transactions e invoices; recebe
+// o master_reference como parâmetro
+const accountCurrencySQL = "COALESCE((SELECT currency FROM master_accounts WHERE reference_uuid = ?), 'USD')"
+
+// PlanPrice retorna o preço do plano na moeda; usdPrice é o preço de plans
+func (c *Connection) PlanPrice(planID int, currency string, usdPrice float64) (float64, error) {
+    currency = models.NormalizeCurrency(currency)
+    if currency == "" || currency == models.DefaultCurrency {
+        return usdPrice, nil
+    }
+
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var price float64
+    err := c.db.QueryRowContext(ctx,
+        "SELECT price FROM plan_prices WHERE plan_id = ? AND currency = ?", planID, currency).Scan(&price)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return 0, fmt.Errorf("plan %d is not available in %s", planID, currency)
+        }
+        return 0, fmt.Errorf("error getting %s price of plan %d: %v", currency, planID, err)
+    }
+
+    return price, nil
+}
+
+// ApplyPriceBook troca o preço dos planos pelo preço na moeda
+func (c *Connection) ApplyPriceBook(plans []models.PlanCart, currency string) error {
+    for i := range plans {
+        price, err := c.PlanPrice(plans[i].ID, currency, plans[i].Price)
+        if err != nil {
+            return err
+        }
+        plans[i].Price = price
+    }
+    return nil
+}
+
+// SetCheckoutCurrency grava o país e a moeda escolhidos no checkout
+func (c *Connection) SetCheckoutCurrency(checkoutID, country, currency string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx,
+        "UPDATE checkout_historics SET country = ?, currency = ? WHERE checkout_id = ?",
+        country, currency, checkoutID)
+    if err != nil {
+        return fmt.Errorf("error setting currency of checkout %s: %v", checkoutID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        var exists int
+        if err := c.db.QueryRowContext(ctx,
+            "SELECT 1 FROM checkout_historics WHERE checkout_id = ?", checkoutID).Scan(&exists); err != nil {
+            if err == sql.ErrNoRows {
+                return err
+            }
+            return fmt.Errorf("error getting checkout %s: %v", checkoutID, err)
+        }
+    }
+
+    return nil
+}
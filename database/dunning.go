@@ -0,0 +1,828 @@
+// database/dunning.go - Cobrança de recuperação (dunning) das recorrências recusadas
+//
+// Quando a Authorize.net avisa que uma cobrança da ARB falhou, um caso de dunning é aberto
+// para a conta. O worker tenta cobrar de novo o customer profile nos dias configurados a
+// partir da falha; cada tentativa (inclusive a falha original da ARB) fica em
+// dunning_attempts. Depois da última tentativa recusada a conta é suspensa.
+//
+// A retentativa é gravada como pending antes da cobrança e passa a captured assim que a
+// Authorize.net aprova, cada passo no seu próprio commit. Um caso cuja reserva venceu com
+// uma tentativa pending ou captured pode já ter sido cobrado: ele vai para manual_review
+// em vez de ser cobrado de novo.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE dunning_cases (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       master_reference VARCHAR(36) NOT NULL,
+//       subscription_id VARCHAR(32) NOT NULL,          -- assinatura ARB cuja cobrança falhou
+//       invoice_id BIGINT NULL,                        -- fatura vencida do ciclo recusado
+//       amount DECIMAL(10,2) NOT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- open, suspended, recovered, manual_review
+//       attempts INT NOT NULL DEFAULT 0,               -- retentativas já feitas
+//       next_attempt_at DATETIME NULL,
+//       claimed_at DATETIME NULL,                      -- retentativa reservada por um worker e ainda não gravada
+//       last_error TEXT NULL,
+//       resumed_subscription_id VARCHAR(32) NULL,      -- nova assinatura ARB ao recuperar uma conta suspensa
+//       started_at DATETIME NOT NULL,
+//       suspended_at TIMESTAMP NULL,
+//       resolved_at TIMESTAMP NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_dunning_cases_master (master_reference, status),
+//       KEY idx_dunning_cases_due (status, next_attempt_at)
+//   )
+//
+//   CREATE TABLE dunning_attempts (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       case_id BIGINT NOT NULL,
+//       attempt_number INT NOT NULL,                   -- 0 = cobrança original da ARB
+//       source VARCHAR(16) NOT NULL,                   -- arb, retry
+//       amount DECIMAL(10,2) NOT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- pending, captured, failed, succeeded
+//       transaction_id VARCHAR(32) NULL,
+//       error TEXT NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_dunning_attempts_case (case_id)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/models"
+)
+
+// Status dos casos de dunning
+const (
+    DunningStatusOpen         = "open"
+    DunningStatusSuspended    = "suspended"
+    DunningStatusRecovered    = "recovered"
+    DunningStatusManualReview = "manual_review"
+)
+
+// Origem e resultado das tentativas de cobrança
+const (
+    DunningAttemptSourceARB   = "arb"
+    DunningAttemptSourceRetry = "retry"
+
+    DunningAttemptPending   = "pending"
+    DunningAttemptCaptured  = "captured"
+    DunningAttemptFailed    = "failed"
+    DunningAttemptSucceeded = "succeeded"
+)
+
+// DunningCase é uma linha de dunning_cases
+type DunningCase struct {
+    ID                    int64            `json:"id"`
+    MasterReference       string           `json:"master_reference"`
+    SubscriptionID        string           `json:"subscription_id"`
+    InvoiceID             int64            `json:"invoice_id,omitempty"`
+    Amount                float64          `json:"amount"`
+    Status                string           `json:"status"`
+    Attempts              int              `json:"attempts"`
+    NextAttemptAt         *time.Time       `json:"next_attempt_at,omitempty"`
+    LastError             string           `json:"last_error,omitempty"`
+    ResumedSubscriptionID string           `json:"resumed_subscription_id,omitempty"`
+    StartedAt             time.Time        `json:"started_at"`
+    SuspendedAt           *time.Time       `json:"suspended_at,omitempty"`
+    ResolvedAt            *time.Time       `json:"resolved_at,omitempty"`
+    History               []DunningAttempt `json:"history,omitempty"`
+}
+
+// DunningAttempt é uma linha de dunning_attempts
+type DunningAttempt struct {
+    ID            int64     `json:"id"`
+    CaseID        int64     `json:"case_id"`
+    AttemptNumber int       `json:"attempt_number"`
+    Source        string    `json:"source"`
+    Amount        float64   `json:"amount"`
+    Status        string    `json:"status"`
+    TransactionID string    `json:"transaction_id,omitempty"`
+    Error         string    `json:"error,omitempty"`
+    CreatedAt     time.Time `json:"created_at"`
+}
+
+// DunningAccount traz os dados da conta usados nas retentativas e nos emails
+type DunningAccount struct {
+    Email      string
+    Username   string
+    Name       string
+    IsAnnually bool
+    TotalPrice float64
+    Currency   string
+}
+
+const dunningCaseColumns = `id, master_reference, subscription_id, invoice_id, amount, status,
+    attempts, next_attempt_at, last_error, resumed_subscription_id, started_at, suspended_at, resolved_at`
+
+func scanDunningCase(row rowScanner) (*DunningCase, error) {
+    var dc DunningCase
+    var invoiceID sql.NullInt64
+    var lastError, resumedSubscriptionID sql.NullString
+    var nextAttemptAt, suspendedAt, resolvedAt sql.NullTime
+
+    err := row.Scan(&dc.ID, &dc.MasterReference, &dc.SubscriptionID, &invoiceID, &dc.Amount, &dc.Status,
+        &dc.Attempts, &nextAttemptAt, &lastError, &resumedSubscriptionID, &dc.StartedAt, &suspendedAt, &resolvedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    dc.InvoiceID = invoiceID.Int64
+    dc.LastError = lastError.String
+    dc.ResumedSubscriptionID = resumedSubscriptionID.String
+    if nextAttemptAt.Valid {
+        dc.NextAttemptAt = &nextAttemptAt.Time
+    }
+    if suspendedAt.Valid {
+        dc.SuspendedAt = &suspendedAt.Time
+    }
+    if resolvedAt.Valid {
+        dc.ResolvedAt = &resolvedAt.Time
+    }
+
+    return &dc, nil
+}
+
+// GetDunningAccount retorna os dados da conta master usados pelo dunning
+func (c *Connection) GetDunningAccount(masterRef string) (*DunningAccount, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var account DunningAccount
+    var isAnnually int
+    err := c.db.QueryRowContext(ctx, `
+        SELECT email, username, name, COALESCE(is_annually, 0), total_price, COALESCE(currency, 'USD')
+        FROM master_accounts WHERE reference_uuid = ? LIMIT 1`,
+        masterRef).Scan(&account.Email, &account.Username, &account.Name, &isAnnually, &account.TotalPrice, &account.Currency)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting account %s: %v", masterRef, err)
+    }
+    account.IsAnnually = isAnnually == 1
+
+    return &account, nil
+}
+
+// GetMasterReferenceBySubscription retorna a conta dona da assinatura ARB
+func (c *Connection) GetMasterReferenceBySubscription(subscriptionID string) (string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var masterRef string
+    err := c.db.QueryRowContext(ctx,
+        "SELECT master_reference FROM subscriptions WHERE subscription_id = ? LIMIT 1",
+        subscriptionID).Scan(&masterRef)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return "", err
+        }
+        return "", fmt.Errorf("error getting account for subscription %s: %v", subscriptionID, err)
+    }
+
+    return masterRef, nil
+}
+
+// OpenDunningCase abre o caso de dunning da conta, registra a falha original da ARB e
+// coloca o usuário master em erro de pagamento. Se a conta já tem um caso em andamento
+// ele é carregado em dc e nada é criado (as notificações da ARB chegam duplicadas pelo
+// silent post e pela Webhooks API). O valor vem da fatura vencida do ciclo, quando existe;
+// senão é usado o dc.Amount informado.
+func (c *Connection) OpenDunningCase(dc *DunningCase, failure string) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    // Serializa a abertura de casos da mesma conta
+    var masterRef string
+    err = tx.QueryRowContext(ctx,
+        "SELECT reference_uuid FROM master_accounts WHERE reference_uuid = ? FOR UPDATE",
+        dc.MasterReference).Scan(&masterRef)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return false, err
+        }
+        return false, fmt.Errorf("error locking master account: %v", err)
+    }
+
+    existing, err := scanDunningCase(tx.QueryRowContext(ctx,
+        "SELECT "+dunningCaseColumns+" FROM dunning_cases WHERE master_reference = ? AND status IN (?, ?, ?) LIMIT 1",
+        dc.MasterReference, DunningStatusOpen, DunningStatusSuspended, DunningStatusManualReview))
+    if err == nil {
+        *dc = *existing
+        return false, nil
+    } else if err != sql.ErrNoRows {
+        return false, fmt.Errorf("error checking dunning case: %v", err)
+    }
+
+    var invoiceID int64
+    var invoiceTotal float64
+    err = tx.QueryRowContext(ctx, `
+        SELECT id, total FROM invoices
+        WHERE master_reference = ? AND is_paid = 0 AND due_date <= NOW()
+        ORDER BY due_date DESC LIMIT 1`,
+        dc.MasterReference).Scan(&invoiceID, &invoiceTotal)
+    if err == nil {
+        dc.InvoiceID = invoiceID
+        if invoiceTotal > 0 {
+            dc.Amount = invoiceTotal
+        }
+    } else if err != sql.ErrNoRows {
+        return false, fmt.Errorf("error getting overdue invoice: %v", err)
+    }
+
+    if dc.Amount <= 0 {
+        return false, fmt.Errorf("invalid dunning amount: %.2f", dc.Amount)
+    }
+
+    var invoiceRef interface{}
+    if dc.InvoiceID > 0 {
+        invoiceRef = dc.InvoiceID
+    }
+
+    dc.Status = DunningStatusOpen
+    dc.LastError = failure
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO dunning_cases (master_reference, subscription_id, invoice_id, amount, status,
+                                   attempts, next_attempt_at, last_error, started_at, created_at)
+        VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, NOW())`,
+        dc.MasterReference, dc.SubscriptionID, invoiceRef, dc.Amount, dc.Status,
+        dc.NextAttemptAt, dc.LastError, dc.StartedAt)
+    if err != nil {
+        return false, fmt.Errorf("error creating dunning case: %v", err)
+    }
+
+    dc.ID, err = result.LastInsertId()
+    if err != nil {
+        return false, fmt.Errorf("error getting dunning case ID: %v", err)
+    }
+
+    if err := insertDunningAttempt(ctx, tx, &DunningAttempt{
+        CaseID:        dc.ID,
+        AttemptNumber: 0,
+        Source:        DunningAttemptSourceARB,
+        Amount:        dc.Amount,
+        Status:        DunningAttemptFailed,
+        Error:         failure,
+    }); err != nil {
+        return false, err
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE users SET payment_status = ? WHERE master_reference = ? AND is_master = 1",
+        int(models.PaymentStatusFailed), dc.MasterReference)
+    if err != nil {
+        return false, fmt.Errorf("error setting payment status: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, fmt.Errorf("error committing dunning case: %v", err)
+    }
+
+    log.Printf("Opened dunning case %d for %s (subscription %s, $%.2f)",
+        dc.ID, dc.MasterReference, dc.SubscriptionID, dc.Amount)
+    return true, nil
+}
+
+func insertDunningAttempt(ctx context.Context, tx *sql.Tx, attempt *DunningAttempt) error {
+    var transactionID, attemptError interface{}
+    if attempt.TransactionID != "" {
+        transactionID = attempt.TransactionID
+    }
+    if attempt.Error != "" {
+        attemptError = attempt.Error
+    }
+
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO dunning_attempts (case_id, attempt_number, source, amount, status, transaction_id, error, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, NOW())`,
+        attempt.CaseID, attempt.AttemptNumber, attempt.Source, attempt.Amount, attempt.Status,
+        transactionID, attemptError)
+    if err != nil {
+        return fmt.Errorf("error saving dunning attempt: %v", err)
+    }
+
+    attempt.ID, err = result.LastInsertId()
+    if err != nil {
+        return fmt.Errorf("error getting dunning attempt ID: %v", err)
+    }
+
+    return nil
+}
+
+// BeginDunningAttempt grava a retentativa como pending antes da cobrança. O registro é
+// confirmado na hora, para que uma reserva vencida nunca cobre de novo um caso cuja
+// cobrança pode ter passado.
+func (c *Connection) BeginDunningAttempt(dc *DunningCase, attempt *DunningAttempt) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    attempt.CaseID = dc.ID
+    attempt.Status = DunningAttemptPending
+    if err := insertDunningAttempt(ctx, tx, attempt); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing dunning attempt: %v", err)
+    }
+
+    return nil
+}
+
+// RecordDunningCharge marca a retentativa pending como captured com a transação aprovada.
+// É gravado antes e fora da transação que encerra o caso.
+func (c *Connection) RecordDunningCharge(attempt *DunningAttempt) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx,
+        "UPDATE dunning_attempts SET status = ?, transaction_id = ? WHERE id = ? AND status = ?",
+        DunningAttemptCaptured, attempt.TransactionID, attempt.ID, DunningAttemptPending)
+    if err != nil {
+        return fmt.Errorf("error recording dunning charge %s: %v", attempt.TransactionID, err)
+    }
+
+    attempt.Status = DunningAttemptCaptured
+    return nil
+}
+
+func finishDunningAttempt(ctx context.Context, tx *sql.Tx, attempt *DunningAttempt) error {
+    var transactionID, attemptError interface{}
+    if attempt.TransactionID != "" {
+        transactionID = attempt.TransactionID
+    }
+    if attempt.Error != "" {
+        attemptError = attempt.Error
+    }
+
+    _, err := tx.ExecContext(ctx,
+        "UPDATE dunning_attempts SET status = ?, transaction_id = ?, error = ? WHERE id = ?",
+        attempt.Status, transactionID, attemptError, attempt.ID)
+    if err != nil {
+        return fmt.Errorf("error saving dunning attempt: %v", err)
+    }
+
+    return nil
+}
+
+// SaveDunningAttempt grava o resultado da retentativa aberta por BeginDunningAttempt e o
+// novo estado do caso em uma transação.
+// Quando o caso passa a recovered, a cobrança é registrada, a fatura vencida é quitada e
+// os usuários voltam ao normal. Quando passa a suspended, os usuários da conta ficam com
+// erro de pagamento e is_active = 9 e a assinatura é marcada como suspensa. Nas demais
+// falhas o usuário master volta para erro de pagamento.
+func (c *Connection) SaveDunningAttempt(dc *DunningCase, attempt *DunningAttempt) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    if err := finishDunningAttempt(ctx, tx, attempt); err != nil {
+        return err
+    }
+
+    var resumedSubscriptionID interface{}
+    if dc.ResumedSubscriptionID != "" {
+        resumedSubscriptionID = dc.ResumedSubscriptionID
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE dunning_cases
+        SET status = ?, attempts = ?, next_attempt_at = ?, claimed_at = NULL, last_error = ?, resumed_subscription_id = ?,
+            suspended_at = CASE WHEN ? = ? THEN COALESCE(suspended_at, NOW()) ELSE suspended_at END,
+            resolved_at = CASE WHEN ? = ? THEN NOW() ELSE resolved_at END
+        WHERE id = ?`,
+        dc.Status, dc.Attempts, dc.NextAttemptAt, dc.LastError, resumedSubscriptionID,
+        dc.Status, DunningStatusSuspended, dc.Status, DunningStatusRecovered, dc.ID)
+    if err != nil {
+        return fmt.Errorf("error updating dunning case: %v", err)
+    }
+
+    switch dc.Status {
+    case DunningStatusRecovered:
+        err = recoverDunningAccount(ctx, tx, dc, attempt)
+    case DunningStatusSuspended:
+        err = suspendDunningAccount(ctx, tx, dc)
+    default:
+        // O cartão pode ter sido trocado desde a abertura do caso
+        _, err = tx.ExecContext(ctx,
+            "UPDATE users SET payment_status = ? WHERE master_reference = ? AND is_master = 1",
+            int(models.PaymentStatusFailed), dc.MasterReference)
+        if err != nil {
+            err = fmt.Errorf("error setting payment status: %v", err)
+        }
+    }
+    if err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing dunning attempt: %v", err)
+    }
+
+    return nil
+}
+
+func recoverDunningAccount(ctx context.Context, tx *sql.Tx, dc *DunningCase, attempt *DunningAttempt) error {
+    _, err := tx.ExecContext(ctx, `
+        INSERT INTO transactions (id, master_reference, checkout_id, amount, currency, status, transaction_id, created_at)
+        VALUES (UUID(), ?, 'DUNNING', ?, `+accountCurrencySQL+`, 'captured', ?, NOW())`,
+        dc.MasterReference, attempt.Amount, dc.MasterReference, attempt.TransactionID)
+    if err != nil {
+        return fmt.Errorf("error saving transaction: %v", err)
+    }
+
+    var rows int64
+    if dc.InvoiceID > 0 {
+        result, err := tx.ExecContext(ctx,
+            "UPDATE invoices SET is_paid = 1 WHERE id = ? AND master_reference = ?",
+            dc.InvoiceID, dc.MasterReference)
+        if err != nil {
+            return fmt.Errorf("error marking invoice paid: %v", err)
+        }
+        rows, _ = result.RowsAffected()
+    }
+
+    if rows == 0 {
+        _, err = tx.ExecContext(ctx, `
+            INSERT INTO invoices (master_reference, is_trial, total, currency, due_date, is_paid, created_at)
+            VALUES (?, 0, ?, `+accountCurrencySQL+`, NOW(), 1, NOW())`,
+            dc.MasterReference, attempt.Amount, dc.MasterReference)
+        if err != nil {
+            return fmt.Errorf("error creating invoice: %v", err)
+        }
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE users SET payment_status = ? WHERE master_reference = ? AND payment_status = ?",
+        int(models.PaymentStatusSuccess), dc.MasterReference, int(models.PaymentStatusFailed))
+    if err != nil {
+        return fmt.Errorf("error setting payment status: %v", err)
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE users SET is_active = 1 WHERE master_reference = ? AND is_active = 9",
+        dc.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error reactivating users: %v", err)
+    }
+
+    if dc.ResumedSubscriptionID != "" {
+        // A cobrança da recuperação abre um novo período a partir de hoje
+        _, err = tx.ExecContext(ctx, `
+            UPDATE master_accounts
+            SET renew_date = DATE_ADD(CURDATE(), INTERVAL IF(is_annually = 1, 12, 1) MONTH)
+            WHERE reference_uuid = ?`,
+            dc.MasterReference)
+        if err != nil {
+            return fmt.Errorf("error updating renew date: %v", err)
+        }
+
+        _, err = tx.ExecContext(ctx, `
+            UPDATE subscriptions s
+            JOIN master_accounts ma ON ma.reference_uuid = s.master_reference
+            SET s.subscription_id = ?, s.status = 'active', s.next_billing_date = ma.renew_date, s.updated_at = NOW()
+            WHERE s.master_reference = ? AND s.subscription_id = ?`,
+            dc.ResumedSubscriptionID, dc.MasterReference, dc.SubscriptionID)
+    } else {
+        _, err = tx.ExecContext(ctx,
+            "UPDATE subscriptions SET status = 'active', updated_at = NOW() WHERE master_reference = ? AND subscription_id = ?",
+            dc.MasterReference, dc.SubscriptionID)
+    }
+    if err != nil {
+        return fmt.Errorf("error updating subscription: %v", err)
+    }
+
+    return nil
+}
+
+func suspendDunningAccount(ctx context.Context, tx *sql.Tx, dc *DunningCase) error {
+    _, err := tx.ExecContext(ctx,
+        "UPDATE users SET payment_status = ? WHERE master_reference = ?",
+        int(models.PaymentStatusFailed), dc.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error setting payment status: %v", err)
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE users SET is_active = 9 WHERE master_reference = ? AND is_active = 1",
+        dc.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error suspending users: %v", err)
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE subscriptions SET status = 'suspended', updated_at = NOW() WHERE master_reference = ? AND subscription_id = ?",
+        dc.MasterReference, dc.SubscriptionID)
+    if err != nil {
+        return fmt.Errorf("error suspending subscription: %v", err)
+    }
+
+    return nil
+}
+
+// GetActiveDunningCase retorna o caso em andamento (open, suspended ou manual_review) da
+// conta. Retorna sql.ErrNoRows se não houver.
+func (c *Connection) GetActiveDunningCase(masterRef string) (*DunningCase, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    dc, err := scanDunningCase(c.db.QueryRowContext(ctx,
+        "SELECT "+dunningCaseColumns+" FROM dunning_cases WHERE master_reference = ? AND status IN (?, ?, ?) LIMIT 1",
+        masterRef, DunningStatusOpen, DunningStatusSuspended, DunningStatusManualReview))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting dunning case for %s: %v", masterRef, err)
+    }
+
+    return dc, nil
+}
+
+// DunningClaimTimeout é o tempo depois do qual uma retentativa reservada e não gravada
+// (worker morreu no meio) é considerada abandonada e volta a ficar vencida
+const DunningClaimTimeout = 15 * time.Minute
+
+// unresolvedDunningAttempt indica que o caso tem uma cobrança que pode ter passado e
+// ainda não foi encerrada
+const unresolvedDunningAttempt = `EXISTS (
+        SELECT 1 FROM dunning_attempts a
+        WHERE a.case_id = dunning_cases.id AND a.status IN ('` + DunningAttemptPending + `', '` + DunningAttemptCaptured + `'))`
+
+// dueDunningCondition seleciona os casos com retentativa vencida ou com reserva abandonada.
+// Casos com cobrança não resolvida nunca são cobrados de novo.
+const dueDunningCondition = `status IN (?, ?) AND NOT ` + unresolvedDunningAttempt + ` AND (
+        (next_attempt_at IS NOT NULL AND next_attempt_at <= NOW())
+        OR (next_attempt_at IS NULL AND claimed_at < NOW() - INTERVAL ? SECOND))`
+
+// GetDueDunningCases lista os casos com retentativa vencida
+func (c *Connection) GetDueDunningCases(limit int) ([]DunningCase, error) {
+    return c.listDunningCases(`
+        SELECT `+dunningCaseColumns+` FROM dunning_cases
+        WHERE `+dueDunningCondition+`
+        ORDER BY COALESCE(next_attempt_at, claimed_at) ASC
+        LIMIT ?`,
+        DunningStatusOpen, DunningStatusSuspended, int(DunningClaimTimeout.Seconds()), limit)
+}
+
+// ClaimDunningCase reserva a retentativa vencida do caso limpando next_attempt_at, para
+// que duas instâncias do worker não cobrem o cliente duas vezes. Retorna false se outra
+// instância já reservou o caso. A reserva vence em DunningClaimTimeout se a tentativa não
+// for gravada por SaveDunningAttempt.
+func (c *Connection) ClaimDunningCase(id int64) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE dunning_cases SET next_attempt_at = NULL, claimed_at = NOW()
+        WHERE id = ? AND `+dueDunningCondition,
+        id, DunningStatusOpen, DunningStatusSuspended, int(DunningClaimTimeout.Seconds()))
+    if err != nil {
+        return false, fmt.Errorf("error claiming dunning case %d: %v", id, err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return false, fmt.Errorf("error getting rows affected: %v", err)
+    }
+
+    return rows == 1, nil
+}
+
+// HoldUnresolvedDunningCases move para manual_review os casos com reserva vencida e uma
+// tentativa pending ou captured: o worker morreu entre a cobrança e o encerramento do caso
+// e só um operador pode conferir na Authorize.net se o cliente foi cobrado.
+func (c *Connection) HoldUnresolvedDunningCases() ([]DunningCase, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    rows, err := tx.QueryContext(ctx, `
+        SELECT `+dunningCaseColumns+` FROM dunning_cases
+        WHERE status IN (?, ?) AND next_attempt_at IS NULL AND claimed_at < NOW() - INTERVAL ? SECOND
+          AND `+unresolvedDunningAttempt+`
+        FOR UPDATE`,
+        DunningStatusOpen, DunningStatusSuspended, int(DunningClaimTimeout.Seconds()))
+    if err != nil {
+        return nil, fmt.Errorf("error listing unresolved dunning cases: %v", err)
+    }
+
+    var cases []DunningCase
+    for rows.Next() {
+        dc, err := scanDunningCase(rows)
+        if err != nil {
+            rows.Close()
+            return nil, fmt.Errorf("error scanning dunning case: %v", err)
+        }
+        cases = append(cases, *dc)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating dunning cases: %v", err)
+    }
+
+    for i := range cases {
+        cases[i].Status = DunningStatusManualReview
+        cases[i].LastError = "dunning charge may have been captured but was not recorded"
+        _, err := tx.ExecContext(ctx,
+            "UPDATE dunning_cases SET status = ?, claimed_at = NULL, last_error = ? WHERE id = ?",
+            cases[i].Status, cases[i].LastError, cases[i].ID)
+        if err != nil {
+            return nil, fmt.Errorf("error holding dunning case %d: %v", cases[i].ID, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("error committing dunning cases: %v", err)
+    }
+
+    return cases, nil
+}
+
+// ListDunningCases lista os casos de dunning para o painel admin, do mais recente para o
+// mais antigo. status e masterRef são filtros opcionais.
+func (c *Connection) ListDunningCases(status, masterRef string, limit, offset int) ([]DunningCase, error) {
+    var conditions []string
+    var args []interface{}
+
+    if status != "" {
+        conditions = append(conditions, "status = ?")
+        args = append(args, status)
+    }
+    if masterRef != "" {
+        conditions = append(conditions, "master_reference = ?")
+        args = append(args, masterRef)
+    }
+
+    query := "SELECT " + dunningCaseColumns + " FROM dunning_cases"
+    if len(conditions) > 0 {
+        query += " WHERE " + strings.Join(conditions, " AND ")
+    }
+    query += " ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?"
+    args = append(args, limit, offset)
+
+    return c.listDunningCases(query, args...)
+}
+
+func (c *Connection) listDunningCases(query string, args ...interface{}) ([]DunningCase, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing dunning cases: %v", err)
+    }
+    defer rows.Close()
+
+    var cases []DunningCase
+    for rows.Next() {
+        dc, err := scanDunningCase(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning dunning case: %v", err)
+        }
+        cases = append(cases, *dc)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating dunning cases: %v", err)
+    }
+
+    return cases, nil
+}
+
+// GetDunningCase retorna o caso com o histórico de tentativas
+func (c *Connection) GetDunningCase(id int64) (*DunningCase, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    dc, err := scanDunningCase(c.db.QueryRowContext(ctx,
+        "SELECT "+dunningCaseColumns+" FROM dunning_cases WHERE id = ?", id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting dunning case %d: %v", id, err)
+    }
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT id, case_id, attempt_number, source, amount, status,
+               COALESCE(transaction_id, ''), COALESCE(error, ''), created_at
+        FROM dunning_attempts WHERE case_id = ?
+        ORDER BY attempt_number ASC, id ASC`, id)
+    if err != nil {
+        return nil, fmt.Errorf("error getting dunning attempts: %v", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var attempt DunningAttempt
+        if err := rows.Scan(&attempt.ID, &attempt.CaseID, &attempt.AttemptNumber, &attempt.Source,
+            &attempt.Amount, &attempt.Status, &attempt.TransactionID, &attempt.Error, &attempt.CreatedAt); err != nil {
+            return nil, fmt.Errorf("error scanning dunning attempt: %v", err)
+        }
+        dc.History = append(dc.History, attempt)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating dunning attempts: %v", err)
+    }
+
+    return dc, nil
+}
+
+// RequestDunningRetry antecipa a próxima retentativa do caso em andamento da conta (ex.:
+// logo depois de o cliente trocar o cartão). Retorna false se a conta não está em dunning.
+func (c *Connection) RequestDunningRetry(masterRef string) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx,
+        "UPDATE dunning_cases SET next_attempt_at = NOW() WHERE master_reference = ? AND status IN (?, ?)",
+        masterRef, DunningStatusOpen, DunningStatusSuspended)
+    if err != nil {
+        return false, fmt.Errorf("error scheduling dunning retry for %s: %v", masterRef, err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return false, fmt.Errorf("error getting rows affected: %v", err)
+    }
+
+    return rows > 0, nil
+}
@@ -0,0 +1,235 @@
+// database/jobs.go - Estado dos jobs da fila de processamento
+//
+// A fila (Redis) só guarda o job enquanto ele anda; cada mudança de etapa (queued, delayed,
+// processing, succeeded, failed) é gravada aqui para que o job possa ser consultado
+// depois, pelo ID ou pelo checkout.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE queue_jobs (
+//       id CHAR(36) PRIMARY KEY,                       -- ID (UUID) do job na fila
+//       job_type VARCHAR(40) NOT NULL,
+//       state VARCHAR(16) NOT NULL,                    -- queued, delayed, processing, succeeded, failed
+//       attempts INT NOT NULL DEFAULT 0,               -- execuções iniciadas
+//       last_error TEXT NULL,
+//       checkout_id VARCHAR(64) NULL,
+//       request_id VARCHAR(64) NULL,
+//       idempotency_key VARCHAR(128) NULL,
+//       run_at DATETIME NULL,                          -- quando o job fica disponível (queued/delayed)
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       started_at DATETIME NULL,                      -- início da última execução
+//       finished_at DATETIME NULL,
+//       updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+//       KEY idx_queue_jobs_type_state (job_type, state),
+//       KEY idx_queue_jobs_checkout (checkout_id),
+//       KEY idx_queue_jobs_request (request_id)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/queue"
+)
+
+// JobRecord é o estado registrado de um job
+type JobRecord struct {
+    ID             string     `json:"id"`
+    Type           string     `json:"type"`
+    State          string     `json:"state"`
+    Attempts       int        `json:"attempts"`
+    LastError      string     `json:"last_error,omitempty"`
+    CheckoutID     string     `json:"checkout_id,omitempty"`
+    RequestID      string     `json:"request_id,omitempty"`
+    IdempotencyKey string     `json:"idempotency_key,omitempty"`
+    RunAt          *time.Time `json:"run_at,omitempty"`
+    CreatedAt      time.Time  `json:"created_at"`
+    StartedAt      *time.Time `json:"started_at,omitempty"`
+    FinishedAt     *time.Time `json:"finished_at,omitempty"`
+    UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// JobRecordFilter filtra a listagem de jobs; campos vazios não filtram
+type JobRecordFilter struct {
+    Type       string
+    State      string
+    CheckoutID string
+}
+
+// IsPending indica se o job ainda vai (ou está para) rodar
+func (j *JobRecord) IsPending() bool {
+    switch queue.JobState(j.State) {
+    case queue.JobStateQueued, queue.JobStateDelayed, queue.JobStateProcessing:
+        return true
+    }
+    return false
+}
+
+// SaveJobStatus grava a etapa atual do job (implementa queue.JobStore)
+func (c *Connection) SaveJobStatus(status *queue.JobStatus) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        INSERT INTO queue_jobs
+            (id, job_type, state, attempts, last_error, checkout_id, request_id, idempotency_key,
+             run_at, created_at, started_at, finished_at, updated_at)
+        VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?, NOW(),
+                IF(? = 'processing', NOW(), NULL), IF(? IN ('succeeded', 'failed'), NOW(), NULL), NOW())
+        ON DUPLICATE KEY UPDATE
+            state = VALUES(state),
+            attempts = VALUES(attempts),
+            last_error = COALESCE(VALUES(last_error), last_error),
+            run_at = VALUES(run_at),
+            started_at = COALESCE(VALUES(started_at), started_at),
+            finished_at = VALUES(finished_at),
+            updated_at = NOW()`,
+        status.JobID, string(status.Type), string(status.State), status.Attempts, status.LastError,
+        status.CheckoutID, status.RequestID, status.IdempotencyKey, status.RunAt,
+        string(status.State), string(status.State))
+    if err != nil {
+        return fmt.Errorf("error saving state of job %s: %v", status.JobID, err)
+    }
+
+    return nil
+}
+
+const jobRecordColumns = `id, job_type, state, attempts, last_error, checkout_id, request_id,
+    idempotency_key, run_at, created_at, started_at, finished_at, updated_at`
+
+func scanJobRecord(row rowScanner) (*JobRecord, error) {
+    var job JobRecord
+    var lastError, checkoutID, requestID, idempotencyKey sql.NullString
+    var runAt, startedAt, finishedAt sql.NullTime
+
+    err := row.Scan(&job.ID, &job.Type, &job.State, &job.Attempts, &lastError, &checkoutID, &requestID,
+        &idempotencyKey, &runAt, &job.CreatedAt, &startedAt, &finishedAt, &job.UpdatedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    job.LastError = lastError.String
+    job.CheckoutID = checkoutID.String
+    job.RequestID = requestID.String
+    job.IdempotencyKey = idempotencyKey.String
+    if runAt.Valid {
+        job.RunAt = &runAt.Time
+    }
+    if startedAt.Valid {
+        job.StartedAt = &startedAt.Time
+    }
+    if finishedAt.Valid {
+        job.FinishedAt = &finishedAt.Time
+    }
+
+    return &job, nil
+}
+
+// GetJobRecord retorna o job pelo ID. Retorna sql.ErrNoRows se ele não existir.
+func (c *Connection) GetJobRecord(id string) (*JobRecord, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    job, err := scanJobRecord(c.db.QueryRowContext(ctx,
+        "SELECT "+jobRecordColumns+" FROM queue_jobs WHERE id = ?", id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting job %s: %v", id, err)
+    }
+
+    return job, nil
+}
+
+// GetPaymentJobRecord retorna o job de pagamento mais recente da requisição de checkout.
+// Retorna sql.ErrNoRows se não houver.
+func (c *Connection) GetPaymentJobRecord(requestID string) (*JobRecord, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    job, err := scanJobRecord(c.db.QueryRowContext(ctx, `
+        SELECT `+jobRecordColumns+` FROM queue_jobs
+        WHERE request_id = ? AND job_type IN (?, ?)
+        ORDER BY created_at DESC
+        LIMIT 1`,
+        requestID, string(queue.JobTypeDelayedPayment), string(queue.JobTypeProcessPayment)))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting payment job of request %s: %v", requestID, err)
+    }
+
+    return job, nil
+}
+
+// ListJobRecords lista os jobs mais recentes que atendem ao filtro
+func (c *Connection) ListJobRecords(filter JobRecordFilter, limit, offset int) ([]JobRecord, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    var conditions []string
+    var args []interface{}
+
+    if filter.Type != "" {
+        conditions = append(conditions, "job_type = ?")
+        args = append(args, filter.Type)
+    }
+    if filter.State != "" {
+        conditions = append(conditions, "state = ?")
+        args = append(args, filter.State)
+    }
+    if filter.CheckoutID != "" {
+        conditions = append(conditions, "checkout_id = ?")
+        args = append(args, filter.CheckoutID)
+    }
+
+    query := "SELECT " + jobRecordColumns + " FROM queue_jobs"
+    if len(conditions) > 0 {
+        query += " WHERE " + strings.Join(conditions, " AND ")
+    }
+    query += " ORDER BY created_at DESC, id LIMIT ? OFFSET ?"
+    args = append(args, limit, offset)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing jobs: %v", err)
+    }
+    defer rows.Close()
+
+    var jobs []JobRecord
+    for rows.Next() {
+        job, err := scanJobRecord(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning job: %v", err)
+        }
+        jobs = append(jobs, *job)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating jobs: %v", err)
+    }
+
+    return jobs, nil
+}
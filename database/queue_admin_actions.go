@@ -0,0 +1,111 @@
+// database/queue_admin_actions.go - Auditoria das ações administrativas na fila de jobs
+//
+// Toda intervenção manual na fila de falhas (retry, descarte, edição dos dados de um job)
+// fica registrada com o admin, o motivo e o estado do job antes da ação.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE queue_admin_actions (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       action VARCHAR(16) NOT NULL,                   -- retry, discard, edit
+//       job_id CHAR(36) NOT NULL,
+//       job_type VARCHAR(40) NOT NULL,
+//       reason VARCHAR(255) NULL,
+//       details TEXT NULL,                             -- JSON com os dados do job antes (e depois) da ação
+//       performed_by VARCHAR(255) NOT NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_queue_admin_actions_job (job_id)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// Ações administrativas na fila
+const (
+    QueueActionRetry   = "retry"
+    QueueActionDiscard = "discard"
+    QueueActionEdit    = "edit"
+)
+
+// QueueAdminAction é uma ação administrativa registrada
+type QueueAdminAction struct {
+    ID          int64     `json:"id"`
+    Action      string    `json:"action"`
+    JobID       string    `json:"job_id"`
+    JobType     string    `json:"job_type"`
+    Reason      string    `json:"reason,omitempty"`
+    Details     string    `json:"details,omitempty"`
+    PerformedBy string    `json:"performed_by"`
+    CreatedAt   time.Time `json:"created_at"`
+}
+
+// SaveQueueAdminAction registra a ação administrativa
+func (c *Connection) SaveQueueAdminAction(action *QueueAdminAction) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        INSERT INTO queue_admin_actions (action, job_id, job_type, reason, details, performed_by, created_at)
+        VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, NOW())`,
+        action.Action, action.JobID, action.JobType, action.Reason, action.Details, action.PerformedBy)
+    if err != nil {
+        return fmt.Errorf("error saving queue admin action: %v", err)
+    }
+
+    action.ID, _ = result.LastInsertId()
+    return nil
+}
+
+// ListQueueAdminActions lista as ações mais recentes, opcionalmente de um job
+func (c *Connection) ListQueueAdminActions(jobID string, limit, offset int) ([]QueueAdminAction, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    query := `SELECT id, action, job_id, job_type, reason, details, performed_by, created_at
+        FROM queue_admin_actions`
+    var args []interface{}
+    if jobID != "" {
+        query += " WHERE job_id = ?"
+        args = append(args, jobID)
+    }
+    query += " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+    args = append(args, limit, offset)
+
+    rows, err := c.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing queue admin actions: %v", err)
+    }
+    defer rows.Close()
+
+    var actions []QueueAdminAction
+    for rows.Next() {
+        var action QueueAdminAction
+        var reason, details sql.NullString
+        if err := rows.Scan(&action.ID, &action.Action, &action.JobID, &action.JobType, &reason, &details,
+            &action.PerformedBy, &action.CreatedAt); err != nil {
+            return nil, fmt.Errorf("error scanning queue admin action: %v", err)
+        }
+        action.Reason = reason.String
+        action.Details = details.String
+        actions = append(actions, action)
+    }
+
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error iterating queue admin actions: %v", err)
+    }
+
+    return actions, nil
+}
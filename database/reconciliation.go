@@ -0,0 +1,504 @@
+// database/reconciliation.go - Relatórios de conciliação dos lotes liquidados
+//
+// Um relatório por dia de liquidação; só as transações que não conferem com o registro
+// local viram itens. claimed_at marca a execução em andamento para que uma execução
+// abandonada possa ser retomada.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE reconciliation_reports (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       report_date DATE NOT NULL,                     -- dia de liquidação conciliado
+//       status VARCHAR(16) NOT NULL,                   -- pending, running, completed, failed
+//       batch_count INT NOT NULL DEFAULT 0,
+//       settled_count INT NOT NULL DEFAULT 0,
+//       matched_count INT NOT NULL DEFAULT 0,
+//       mismatch_count INT NOT NULL DEFAULT 0,
+//       settled_total DECIMAL(12,2) NOT NULL DEFAULT 0,
+//       refunded_total DECIMAL(12,2) NOT NULL DEFAULT 0,
+//       error TEXT NULL,
+//       claimed_at DATETIME NULL,                      -- início da execução em andamento
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       completed_at DATETIME NULL,
+//       UNIQUE KEY uk_reconciliation_reports_date (report_date)
+//   )
+//
+//   CREATE TABLE reconciliation_items (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       report_id BIGINT NOT NULL,
+//       batch_id VARCHAR(32) NOT NULL,
+//       transaction_id VARCHAR(32) NOT NULL,
+//       issue VARCHAR(32) NOT NULL,                    -- missing_locally, amount_mismatch, status_mismatch, unexpectedly_voided
+//       settled_status VARCHAR(40) NOT NULL,           -- status na Authorize.net
+//       settled_amount DECIMAL(10,2) NOT NULL,
+//       local_status VARCHAR(32) NULL,
+//       local_amount DECIMAL(10,2) NULL,
+//       submitted_at VARCHAR(40) NOT NULL,
+//       details TEXT NULL,
+//       KEY idx_reconciliation_items_report (report_id)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// Status dos relatórios de conciliação
+const (
+    ReconciliationPending   = "pending"
+    ReconciliationRunning   = "running"
+    ReconciliationCompleted = "completed"
+    ReconciliationFailed    = "failed"
+)
+
+// Divergências registradas em reconciliation_items
+const (
+    ReconciliationMissingLocally     = "missing_locally"
+    ReconciliationAmountMismatch     = "amount_mismatch"
+    ReconciliationStatusMismatch     = "status_mismatch"
+    ReconciliationUnexpectedlyVoided = "unexpectedly_voided"
+)
+
+// ReconciliationReport resume a conciliação de um dia de liquidação
+type ReconciliationReport struct {
+    ID             int64      `json:"id"`
+    ReportDate     string     `json:"report_date"`
+    Status         string     `json:"status"`
+    BatchCount     int        `json:"batch_count"`
+    SettledCount   int        `json:"settled_count"`
+    MatchedCount   int        `json:"matched_count"`
+    MismatchCount  int        `json:"mismatch_count"`
+    SettledTotal   float64    `json:"settled_total"`
+    RefundedTotal  float64    `json:"refunded_total"`
+    Error          string     `json:"error,omitempty"`
+    CreatedAt      time.Time  `json:"created_at"`
+    CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// ReconciliationItem é uma transação liquidada que não confere com o registro local
+type ReconciliationItem struct {
+    ID            int64    `json:"id"`
+    ReportID      int64    `json:"report_id"`
+    BatchID       string   `json:"batch_id"`
+    TransactionID string   `json:"transaction_id"`
+    Issue         string   `json:"issue"`
+    SettledStatus string   `json:"settled_status"`
+    SettledAmount float64  `json:"settled_amount"`
+    LocalStatus   string   `json:"local_status,omitempty"`
+    LocalAmount   *float64 `json:"local_amount,omitempty"`
+    SubmittedAt   string   `json:"submitted_at"`
+    Details       string   `json:"details,omitempty"`
+}
+
+// LocalTransaction é o registro local usado para conferir uma transação liquidada
+type LocalTransaction struct {
+    TransactionID string
+    Amount        float64
+    Status        string
+}
+
+const reconciliationReportColumns = `id, DATE_FORMAT(report_date, '%Y-%m-%d'), status, batch_count, settled_count,
+    matched_count, mismatch_count, settled_total, refunded_total, COALESCE(error, ''), created_at, completed_at`
+
+func scanReconciliationReport(row interface{ Scan(...interface{}) error }) (*ReconciliationReport, error) {
+    var report ReconciliationReport
+    var completedAt sql.NullTime
+
+    if err := row.Scan(&report.ID, &report.ReportDate, &report.Status, &report.BatchCount, &report.SettledCount,
+        &report.MatchedCount, &report.MismatchCount, &report.SettledTotal, &report.RefundedTotal,
+        &report.Error, &report.CreatedAt, &completedAt); err != nil {
+        return nil, err
+    }
+
+    if completedAt.Valid {
+        report.CompletedAt = &completedAt.Time
+    }
+
+    return &report, nil
+}
+
+// CreateReconciliationReport cria o relatório do dia informado. Se já existir, retorna o
+// existente com isNew = false (um relatório por dia de liquidação).
+func (c *Connection) CreateReconciliationReport(reportDate time.Time) (*ReconciliationReport, bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    date := reportDate.Format("2006-01-02")
+
+    result, err := c.db.ExecContext(ctx, `
+        INSERT IGNORE INTO reconciliation_reports (report_date, status, created_at)
+        VALUES (?, ?, NOW())`,
+        date, ReconciliationPending)
+    if err != nil {
+        return nil, false, fmt.Errorf("error creating reconciliation report: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return nil, false, fmt.Errorf("error checking reconciliation report insert: %v", err)
+    }
+
+    report, err := c.GetReconciliationReportByDate(date)
+    if err != nil {
+        return nil, false, err
+    }
+
+    return report, rows > 0, nil
+}
+
+// GetReconciliationReport busca um relatório pelo ID
+func (c *Connection) GetReconciliationReport(id int64) (*ReconciliationReport, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    report, err := scanReconciliationReport(c.db.QueryRowContext(ctx,
+        "SELECT "+reconciliationReportColumns+" FROM reconciliation_reports WHERE id = ?", id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting reconciliation report %d: %v", id, err)
+    }
+
+    return report, nil
+}
+
+// GetReconciliationReportByDate busca um relatório pela data (YYYY-MM-DD)
+func (c *Connection) GetReconciliationReportByDate(date string) (*ReconciliationReport, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    report, err := scanReconciliationReport(c.db.QueryRowContext(ctx,
+        "SELECT "+reconciliationReportColumns+" FROM reconciliation_reports WHERE report_date = ?", date))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting reconciliation report for %s: %v", date, err)
+    }
+
+    return report, nil
+}
+
+// ListReconciliationReports lista os relatórios, mais recentes primeiro
+func (c *Connection) ListReconciliationReports(limit, offset int) ([]ReconciliationReport, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx,
+        "SELECT "+reconciliationReportColumns+" FROM reconciliation_reports ORDER BY report_date DESC LIMIT ? OFFSET ?",
+        limit, offset)
+    if err != nil {
+        return nil, fmt.Errorf("error listing reconciliation reports: %v", err)
+    }
+    defer rows.Close()
+
+    reports := []ReconciliationReport{}
+    for rows.Next() {
+        report, err := scanReconciliationReport(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning reconciliation report: %v", err)
+        }
+        reports = append(reports, *report)
+    }
+
+    return reports, rows.Err()
+}
+
+// ReconciliationClaimTimeout é o tempo depois do qual um relatório em execução é
+// considerado abandonado (worker morreu) e pode ser gerado de novo
+const ReconciliationClaimTimeout = 30 * time.Minute
+
+// ClaimReconciliationReport marca o relatório como em execução, se estiver pendente, falho
+// ou em execução há mais de ReconciliationClaimTimeout. Retorna false se outro worker já o
+// estiver processando ou se já tiver sido concluído.
+func (c *Connection) ClaimReconciliationReport(id int64) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE reconciliation_reports SET status = ?, error = NULL, claimed_at = NOW()
+        WHERE id = ? AND (status IN (?, ?)
+            OR (status = ? AND (claimed_at IS NULL OR claimed_at < NOW() - INTERVAL ? SECOND)))`,
+        ReconciliationRunning, id, ReconciliationPending, ReconciliationFailed,
+        ReconciliationRunning, int(ReconciliationClaimTimeout.Seconds()))
+    if err != nil {
+        return false, fmt.Errorf("error claiming reconciliation report %d: %v", id, err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return rows > 0, nil
+}
+
+// ResetReconciliationReport volta um relatório para pending para ser gerado novamente.
+// Relatórios em execução só voltam se a execução tiver sido abandonada; retorna false
+// se o relatório ainda estiver sendo gerado.
+func (c *Connection) ResetReconciliationReport(id int64) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE reconciliation_reports SET status = ?, error = NULL, claimed_at = NULL
+        WHERE id = ? AND (status <> ? OR claimed_at IS NULL OR claimed_at < NOW() - INTERVAL ? SECOND)`,
+        ReconciliationPending, id, ReconciliationRunning, int(ReconciliationClaimTimeout.Seconds()))
+    if err != nil {
+        return false, fmt.Errorf("error resetting reconciliation report %d: %v", id, err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return rows > 0, nil
+}
+
+// CompleteReconciliationReport grava as divergências e o resumo do relatório, substituindo
+// os itens de uma execução anterior
+func (c *Connection) CompleteReconciliationReport(report *ReconciliationReport, items []ReconciliationItem) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(ctx, "DELETE FROM reconciliation_items WHERE report_id = ?", report.ID); err != nil {
+        return fmt.Errorf("error clearing reconciliation items: %v", err)
+    }
+
+    for _, item := range items {
+        var localAmount interface{}
+        if item.LocalAmount != nil {
+            localAmount = *item.LocalAmount
+        }
+
+        if _, err := tx.ExecContext(ctx, `
+            INSERT INTO reconciliation_items
+            (report_id, batch_id, transaction_id, issue, settled_status, settled_amount,
+             local_status, local_amount, submitted_at, details)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+            report.ID, item.BatchID, item.TransactionID, item.Issue, item.SettledStatus, item.SettledAmount,
+            item.LocalStatus, localAmount, item.SubmittedAt, item.Details); err != nil {
+            return fmt.Errorf("error saving reconciliation item %s: %v", item.TransactionID, err)
+        }
+    }
+
+    if _, err := tx.ExecContext(ctx, `
+        UPDATE reconciliation_reports
+        SET status = ?, batch_count = ?, settled_count = ?, matched_count = ?, mismatch_count = ?,
+            settled_total = ?, refunded_total = ?, error = NULL, completed_at = NOW(), claimed_at = NULL
+        WHERE id = ?`,
+        ReconciliationCompleted, report.BatchCount, report.SettledCount, report.MatchedCount,
+        len(items), report.SettledTotal, report.RefundedTotal, report.ID); err != nil {
+        return fmt.Errorf("error completing reconciliation report %d: %v", report.ID, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing reconciliation report %d: %v", report.ID, err)
+    }
+
+    return nil
+}
+
+// FailReconciliationReport marca o relatório como falho, guardando o erro
+func (c *Connection) FailReconciliationReport(id int64, errorMsg string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    if _, err := c.db.ExecContext(ctx,
+        "UPDATE reconciliation_reports SET status = ?, error = ? WHERE id = ?",
+        ReconciliationFailed, errorMsg, id); err != nil {
+        return fmt.Errorf("error failing reconciliation report %d: %v", id, err)
+    }
+
+    return nil
+}
+
+// GetReconciliationItems retorna as divergências de um relatório
+func (c *Connection) GetReconciliationItems(reportID int64) ([]ReconciliationItem, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT id, report_id, batch_id, transaction_id, issue, settled_status, settled_amount,
+               COALESCE(local_status, ''), local_amount, COALESCE(submitted_at, ''), COALESCE(details, '')
+        FROM reconciliation_items
+        WHERE report_id = ?
+        ORDER BY batch_id, transaction_id`,
+        reportID)
+    if err != nil {
+        return nil, fmt.Errorf("error getting reconciliation items: %v", err)
+    }
+    defer rows.Close()
+
+    items := []ReconciliationItem{}
+    for rows.Next() {
+        var item ReconciliationItem
+        var localAmount sql.NullFloat64
+        if err := rows.Scan(&item.ID, &item.ReportID, &item.BatchID, &item.TransactionID, &item.Issue,
+            &item.SettledStatus, &item.SettledAmount, &item.LocalStatus, &localAmount,
+            &item.SubmittedAt, &item.Details); err != nil {
+            return nil, fmt.Errorf("error scanning reconciliation item: %v", err)
+        }
+        if localAmount.Valid {
+            item.LocalAmount = &localAmount.Float64
+        }
+        items = append(items, item)
+    }
+
+    return items, rows.Err()
+}
+
+// GetTransactionsByIDs busca as transações locais pelos IDs da Authorize.net
+func (c *Connection) GetTransactionsByIDs(transactionIDs []string) (map[string]LocalTransaction, error) {
+    return c.getLocalTransactions(
+        "SELECT transaction_id, amount, status FROM transactions WHERE transaction_id IN (%s)",
+        transactionIDs)
+}
+
+// GetRefundsByTransactionIDs busca os estornos locais pelos IDs das transações de estorno
+func (c *Connection) GetRefundsByTransactionIDs(refundTransactionIDs []string) (map[string]LocalTransaction, error) {
+    return c.getLocalTransactions(
+        "SELECT refund_transaction_id, amount, status FROM refunds WHERE refund_transaction_id IN (%s)",
+        refundTransactionIDs)
+}
+
+func (c *Connection) getLocalTransactions(queryFormat string, ids []string) (map[string]LocalTransaction, error) {
+    result := make(map[string]LocalTransaction)
+    if len(ids) == 0 {
+        return result, nil
+    }
+
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    // Consultar em blocos para não estourar o limite de placeholders
+    const chunkSize = 500
+    for start := 0; start < len(ids); start += chunkSize {
+        end := start + chunkSize
+        if end > len(ids) {
+            end = len(ids)
+        }
+        chunk := ids[start:end]
+
+        placeholders := strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",")
+        args := make([]interface{}, len(chunk))
+        for i, id := range chunk {
+            args[i] = id
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        rows, err := c.db.QueryContext(ctx, fmt.Sprintf(queryFormat, placeholders), args...)
+        if err != nil {
+            cancel()
+            log.Printf("Error loading local transactions: %v", err)
+            return nil, fmt.Errorf("error loading local transactions: %v", err)
+        }
+
+        for rows.Next() {
+            var tx LocalTransaction
+            if err := rows.Scan(&tx.TransactionID, &tx.Amount, &tx.Status); err != nil {
+                rows.Close()
+                cancel()
+                return nil, fmt.Errorf("error scanning local transaction: %v", err)
+            }
+            result[tx.TransactionID] = tx
+        }
+        err = rows.Err()
+        rows.Close()
+        cancel()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return result, nil
+}
+
+// GetKnownSubscriptionIDs retorna quais dos IDs de assinatura ARB existem localmente
+func (c *Connection) GetKnownSubscriptionIDs(subscriptionIDs []string) (map[string]bool, error) {
+    known := make(map[string]bool)
+    if len(subscriptionIDs) == 0 {
+        return known, nil
+    }
+
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    placeholders := strings.TrimSuffix(strings.Repeat("?,", len(subscriptionIDs)), ",")
+    args := make([]interface{}, len(subscriptionIDs))
+    for i, id := range subscriptionIDs {
+        args[i] = id
+    }
+
+    rows, err := c.db.QueryContext(ctx,
+        fmt.Sprintf("SELECT subscription_id FROM subscriptions WHERE subscription_id IN (%s)", placeholders),
+        args...)
+    if err != nil {
+        return nil, fmt.Errorf("error loading subscriptions: %v", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil {
+            return nil, fmt.Errorf("error scanning subscription: %v", err)
+        }
+        known[id] = true
+    }
+
+    return known, rows.Err()
+}
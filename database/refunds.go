@@ -0,0 +1,417 @@
+// database/refunds.go - Estornos vinculados às transações originais
+//
+// O estorno é gravado como pending antes da chamada ao gateway e conta no saldo da
+// transação desde então; vira approved (com o ID da transação de estorno) ou failed.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE refunds (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       original_transaction_id VARCHAR(32) NOT NULL,  -- transactions.transaction_id estornada
+//       refund_transaction_id VARCHAR(32) NULL,        -- transação de estorno na Authorize.net
+//       master_reference VARCHAR(36) NOT NULL,
+//       amount DECIMAL(10,2) NOT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- pending, approved, failed
+//       reason VARCHAR(255) NULL,
+//       requested_by VARCHAR(255) NOT NULL,
+//       error TEXT NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_refunds_original (original_transaction_id, status),
+//       KEY idx_refunds_refund_transaction (refund_transaction_id)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "strings"
+    "time"
+)
+
+// Status dos registros de estorno
+const (
+    RefundStatusPending  = "pending"
+    RefundStatusApproved = "approved"
+    RefundStatusFailed   = "failed"
+)
+
+// Erros de ReserveRefund
+var (
+    ErrTransactionNotRefundable = errors.New("transaction cannot be refunded")
+    ErrRefundExceedsBalance     = errors.New("refund amount exceeds refundable balance")
+)
+
+// refundedAmountQuery soma os estornos que consomem o saldo da transação: os aprovados e
+// os pendentes (em andamento no gateway)
+const refundedAmountQuery = `COALESCE((SELECT SUM(r.amount) FROM refunds r
+    WHERE r.original_transaction_id = t.transaction_id AND r.status IN ('pending', 'approved')), 0)`
+
+// RefundableTransaction é a transação original com o total já estornado (ou em estorno)
+type RefundableTransaction struct {
+    ID              string  `json:"id"`
+    MasterReference string  `json:"master_reference"`
+    CheckoutID      string  `json:"checkout_id"`
+    TransactionID   string  `json:"transaction_id"`
+    Amount          float64 `json:"amount"`
+    Currency        string  `json:"currency"`
+    Status          string  `json:"status"`
+    RefundedAmount  float64 `json:"refunded_amount"`
+}
+
+// RemainingAmount retorna o valor que ainda pode ser estornado
+func (t *RefundableTransaction) RemainingAmount() float64 {
+    remaining := t.Amount - t.RefundedAmount
+    if remaining < 0 {
+        return 0
+    }
+    return remaining
+}
+
+// Refund representa um estorno registrado na tabela refunds
+type Refund struct {
+    ID                    int64     `json:"id"`
+    OriginalTransactionID string    `json:"original_transaction_id"`
+    RefundTransactionID   string    `json:"refund_transaction_id,omitempty"`
+    MasterReference       string    `json:"master_reference"`
+    Amount                float64   `json:"amount"`
+    Status                string    `json:"status"`
+    Reason                string    `json:"reason,omitempty"`
+    RequestedBy           string    `json:"requested_by"`
+    Error                 string    `json:"error,omitempty"`
+    CreatedAt             time.Time `json:"created_at"`
+    UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// GetLatestRefundableTransaction busca a cobrança capturada mais recente da conta com saldo
+// estornável de pelo menos minAmount. Retorna sql.ErrNoRows se não houver.
+func (c *Connection) GetLatestRefundableTransaction(masterRef string, minAmount float64) (*RefundableTransaction, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var tx RefundableTransaction
+    err := c.db.QueryRowContext(ctx, `
+        SELECT id, master_reference, checkout_id, transaction_id, amount, currency, status, refunded
+        FROM (
+            SELECT t.id, t.master_reference, t.checkout_id, t.transaction_id, t.amount,
+                   COALESCE(t.currency, 'USD') AS currency, t.status, t.created_at,
+                   `+refundedAmountQuery+` AS refunded
+            FROM transactions t
+            WHERE t.master_reference = ? AND t.status IN ('captured', 'partially_refunded')
+        ) refundable
+        WHERE amount - refunded >= ?
+        ORDER BY created_at DESC
+        LIMIT 1`,
+        masterRef, minAmount).Scan(
+        &tx.ID, &tx.MasterReference, &tx.CheckoutID, &tx.TransactionID,
+        &tx.Amount, &tx.Currency, &tx.Status, &tx.RefundedAmount)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting refundable transaction for %s: %v", masterRef, err)
+    }
+
+    return &tx, nil
+}
+
+// GetCardLastFour retorna os 4 últimos dígitos do cartão salvo em billing_infos
+func (c *Connection) GetCardLastFour(masterRef string) (string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var maskedCard string
+    err := c.db.QueryRowContext(ctx,
+        "SELECT card FROM billing_infos WHERE master_reference = ? LIMIT 1",
+        masterRef).Scan(&maskedCard)
+    if err != nil {
+        return "", fmt.Errorf("error getting card for %s: %v", masterRef, err)
+    }
+
+    // Cartão é armazenado mascarado: "XXXX XXXX XXXX 1234"
+    digits := strings.ReplaceAll(maskedCard, " ", "")
+    if len(digits) < 4 {
+        return "", fmt.Errorf("invalid masked card for %s", masterRef)
+    }
+
+    return digits[len(digits)-4:], nil
+}
+
+// ReserveRefund valida o saldo estornável e registra o estorno como pendente, antes da
+// chamada ao gateway. A transação original fica travada (FOR UPDATE) durante a verificação,
+// então estornos simultâneos da mesma transação não passam juntos pelo saldo. Amount zero
+// estorna o saldo restante. Retorna sql.ErrNoRows se a transação não existir e
+// ErrTransactionNotRefundable / ErrRefundExceedsBalance (com a transação) se não puder
+// ser estornada.
+func (c *Connection) ReserveRefund(refund *Refund) (*RefundableTransaction, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    original, err := reserveRefund(ctx, tx, refund)
+    if err != nil {
+        return original, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("error committing refund reservation: %v", err)
+    }
+
+    return original, nil
+}
+
+// reserveRefund faz a reserva de ReserveRefund dentro de tx, para quem precisa gravá-la
+// junto com outras alterações
+func reserveRefund(ctx context.Context, tx *sql.Tx, refund *Refund) (*RefundableTransaction, error) {
+    var original RefundableTransaction
+    err := tx.QueryRowContext(ctx, `
+        SELECT t.id, t.master_reference, t.checkout_id, t.transaction_id, t.amount,
+               COALESCE(t.currency, 'USD'), t.status
+        FROM transactions t
+        WHERE t.transaction_id = ?
+        LIMIT 1
+        FOR UPDATE`,
+        refund.OriginalTransactionID).Scan(
+        &original.ID, &original.MasterReference, &original.CheckoutID, &original.TransactionID,
+        &original.Amount, &original.Currency, &original.Status)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error locking transaction %s: %v", refund.OriginalTransactionID, err)
+    }
+
+    err = tx.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(amount), 0) FROM refunds
+        WHERE original_transaction_id = ? AND status IN (?, ?)`,
+        original.TransactionID, RefundStatusPending, RefundStatusApproved).Scan(&original.RefundedAmount)
+    if err != nil {
+        return nil, fmt.Errorf("error summing refunds of transaction %s: %v", original.TransactionID, err)
+    }
+
+    // Só transações capturadas (ou já parcialmente estornadas) podem ser estornadas.
+    // Transações apenas autorizadas devem ser anuladas (void).
+    if original.Status != "captured" && original.Status != "partially_refunded" {
+        return &original, ErrTransactionNotRefundable
+    }
+
+    remaining := original.RemainingAmount()
+    if refund.Amount == 0 {
+        refund.Amount = remaining
+    }
+    refund.Amount = math.Round(refund.Amount*100) / 100
+
+    // Comparar em centavos para evitar erros de ponto flutuante
+    if refund.Amount <= 0 || math.Round(refund.Amount*100) > math.Round(remaining*100) {
+        return &original, ErrRefundExceedsBalance
+    }
+
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO refunds
+        (original_transaction_id, master_reference, amount, status, reason, requested_by, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())`,
+        original.TransactionID, original.MasterReference, refund.Amount,
+        RefundStatusPending, refund.Reason, refund.RequestedBy)
+    if err != nil {
+        log.Printf("Error creating refund for transaction %s: %v", original.TransactionID, err)
+        return nil, fmt.Errorf("error creating refund: %v", err)
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return nil, fmt.Errorf("error getting refund ID: %v", err)
+    }
+
+    refund.ID = id
+    refund.MasterReference = original.MasterReference
+    refund.Status = RefundStatusPending
+    return &original, nil
+}
+
+// CompleteRefund marca o estorno como aprovado e atualiza o status da transação original
+// para refunded (total) ou partially_refunded, na mesma transação. O status sai da soma
+// dos estornos aprovados; retorna o saldo que ainda pode ser estornado (descontados os
+// pendentes) e se a transação ficou totalmente estornada.
+func (c *Connection) CompleteRefund(refundID int64, refundTransactionID, originalTransactionID string) (float64, bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, false, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    var amount float64
+    if err := tx.QueryRowContext(ctx,
+        `SELECT amount FROM transactions WHERE transaction_id = ? LIMIT 1 FOR UPDATE`,
+        originalTransactionID).Scan(&amount); err != nil {
+        return 0, false, fmt.Errorf("error locking transaction %s: %v", originalTransactionID, err)
+    }
+
+    if _, err := tx.ExecContext(ctx, `
+        UPDATE refunds SET status = ?, refund_transaction_id = ?, updated_at = NOW()
+        WHERE id = ?`,
+        RefundStatusApproved, refundTransactionID, refundID); err != nil {
+        return 0, false, fmt.Errorf("error updating refund %d: %v", refundID, err)
+    }
+
+    var approved, reserved float64
+    if err := tx.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(CASE WHEN status = ? THEN amount ELSE 0 END), 0),
+               COALESCE(SUM(amount), 0)
+        FROM refunds
+        WHERE original_transaction_id = ? AND status IN (?, ?)`,
+        RefundStatusApproved, originalTransactionID, RefundStatusPending, RefundStatusApproved).Scan(
+        &approved, &reserved); err != nil {
+        return 0, false, fmt.Errorf("error summing refunds of transaction %s: %v", originalTransactionID, err)
+    }
+
+    fullyRefunded := math.Round(approved*100) >= math.Round(amount*100)
+    status := "partially_refunded"
+    if fullyRefunded {
+        status = "refunded"
+    }
+
+    if _, err := tx.ExecContext(ctx,
+        `UPDATE transactions SET status = ?, updated_at = NOW() WHERE transaction_id = ?`,
+        status, originalTransactionID); err != nil {
+        return 0, false, fmt.Errorf("error updating transaction %s: %v", originalTransactionID, err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, false, fmt.Errorf("error committing refund %d: %v", refundID, err)
+    }
+
+    remaining := math.Max(math.Round((amount-reserved)*100)/100, 0)
+    return remaining, fullyRefunded, nil
+}
+
+// FailRefund marca o estorno como recusado, guardando o erro do gateway
+func (c *Connection) FailRefund(refundID int64, errorMsg string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    if _, err := c.db.ExecContext(ctx, `
+        UPDATE refunds SET status = ?, error = ?, updated_at = NOW()
+        WHERE id = ?`,
+        RefundStatusFailed, errorMsg, refundID); err != nil {
+        return fmt.Errorf("error updating refund %d: %v", refundID, err)
+    }
+
+    return nil
+}
+
+// ConfirmRefundByTransaction confirma um estorno a partir do ID da transação de estorno
+// (evento net.authorize.payment.refund.created). Retorna as linhas afetadas.
+func (c *Connection) ConfirmRefundByTransaction(refundTransactionID string) (int64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE refunds SET status = ?, updated_at = NOW()
+        WHERE refund_transaction_id = ? AND status <> ?`,
+        RefundStatusApproved, refundTransactionID, RefundStatusApproved)
+    if err != nil {
+        return 0, fmt.Errorf("error confirming refund %s: %v", refundTransactionID, err)
+    }
+
+    return result.RowsAffected()
+}
+
+// ListRefunds lista os estornos de uma transação original (ou todos, se vazio)
+func (c *Connection) ListRefunds(originalTransactionID string, limit, offset int) ([]Refund, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    query := `
+        SELECT id, original_transaction_id, COALESCE(refund_transaction_id, ''), master_reference,
+               amount, status, COALESCE(reason, ''), requested_by, COALESCE(error, ''),
+               created_at, updated_at
+        FROM refunds`
+    args := []interface{}{}
+
+    if originalTransactionID != "" {
+        query += " WHERE original_transaction_id = ?"
+        args = append(args, originalTransactionID)
+    }
+
+    query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+    args = append(args, limit, offset)
+
+    rows, err := c.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing refunds: %v", err)
+    }
+    defer rows.Close()
+
+    refunds := []Refund{}
+    for rows.Next() {
+        var r Refund
+        if err := rows.Scan(&r.ID, &r.OriginalTransactionID, &r.RefundTransactionID, &r.MasterReference,
+            &r.Amount, &r.Status, &r.Reason, &r.RequestedBy, &r.Error,
+            &r.CreatedAt, &r.UpdatedAt); err != nil {
+            return nil, fmt.Errorf("error scanning refund: %v", err)
+        }
+        refunds = append(refunds, r)
+    }
+
+    return refunds, rows.Err()
+}
+
+// GetMasterUserByReference retorna email e username da conta master
+func (c *Connection) GetMasterUserByReference(masterRef string) (string, string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var email, username string
+    err := c.db.QueryRowContext(ctx,
+        "SELECT email, username FROM master_accounts WHERE reference_uuid = ? LIMIT 1",
+        masterRef).Scan(&email, &username)
+    if err != nil {
+        return "", "", err
+    }
+
+    return email, username, nil
+}
@@ -0,0 +1,330 @@
+// database/subscription_pauses.go - Pausas da assinatura pedidas pelo cliente (férias)
+//
+// Ao pausar, a ARB é recriada com início na nova renew_date (fim do período pago somado à
+// duração da pausa), de modo que nenhuma cobrança ocorre durante a pausa. Os usuários da
+// conta ficam com is_active = 3 (paused) até a retomada.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE subscription_pauses (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       master_reference VARCHAR(36) NOT NULL,
+//       previous_subscription_id VARCHAR(32) NOT NULL, -- assinatura ARB cancelada na pausa
+//       subscription_id VARCHAR(32) NOT NULL,          -- assinatura ARB que começa em renew_date
+//       requested_by VARCHAR(255) NOT NULL,
+//       months INT NOT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- paused, resumed
+//       paused_at DATETIME NOT NULL,
+//       resume_at DATETIME NOT NULL,                   -- retomada automática
+//       original_renew_date DATETIME NOT NULL,         -- fim do período pago antes da pausa
+//       renew_date DATETIME NOT NULL,                  -- primeira cobrança depois da pausa
+//       reminder_sent_at TIMESTAMP NULL,
+//       resumed_at TIMESTAMP NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_subscription_pauses_master (master_reference, status),
+//       KEY idx_subscription_pauses_due (status, resume_at)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+
+    "prosecure-payment-api/models"
+)
+
+// Status das pausas de assinatura
+const (
+    PauseStatusPaused  = "paused"
+    PauseStatusResumed = "resumed"
+)
+
+// SubscriptionPause é uma linha de subscription_pauses
+type SubscriptionPause struct {
+    ID                     int64      `json:"id"`
+    MasterReference        string     `json:"master_reference"`
+    PreviousSubscriptionID string     `json:"previous_subscription_id"`
+    SubscriptionID         string     `json:"subscription_id"`
+    RequestedBy            string     `json:"requested_by"`
+    Months                 int        `json:"months"`
+    Status                 string     `json:"status"`
+    PausedAt               time.Time  `json:"paused_at"`
+    ResumeAt               time.Time  `json:"resume_at"`
+    OriginalRenewDate      time.Time  `json:"original_renew_date"`
+    RenewDate              time.Time  `json:"renew_date"`
+    ReminderSentAt         *time.Time `json:"reminder_sent_at,omitempty"`
+    ResumedAt              *time.Time `json:"resumed_at,omitempty"`
+}
+
+const subscriptionPauseColumns = `id, master_reference, previous_subscription_id, subscription_id,
+    requested_by, months, status, paused_at, resume_at, original_renew_date, renew_date,
+    reminder_sent_at, resumed_at`
+
+func scanSubscriptionPause(row rowScanner) (*SubscriptionPause, error) {
+    var pause SubscriptionPause
+    var reminderSentAt, resumedAt sql.NullTime
+
+    err := row.Scan(&pause.ID, &pause.MasterReference, &pause.PreviousSubscriptionID, &pause.SubscriptionID,
+        &pause.RequestedBy, &pause.Months, &pause.Status, &pause.PausedAt, &pause.ResumeAt,
+        &pause.OriginalRenewDate, &pause.RenewDate, &reminderSentAt, &resumedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    if reminderSentAt.Valid {
+        pause.ReminderSentAt = &reminderSentAt.Time
+    }
+    if resumedAt.Valid {
+        pause.ResumedAt = &resumedAt.Time
+    }
+
+    return &pause, nil
+}
+
+// CreateSubscriptionPause registra a pausa e, na mesma transação, estende renew_date,
+// troca a assinatura ARB, adia os créditos agendados e marca os usuários como pausados
+func (c *Connection) CreateSubscriptionPause(pause *SubscriptionPause) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO subscription_pauses
+        (master_reference, previous_subscription_id, subscription_id, requested_by, months, status,
+         paused_at, resume_at, original_renew_date, renew_date, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW())`,
+        pause.MasterReference, pause.PreviousSubscriptionID, pause.SubscriptionID, pause.RequestedBy,
+        pause.Months, PauseStatusPaused, pause.PausedAt, pause.ResumeAt, pause.OriginalRenewDate, pause.RenewDate)
+    if err != nil {
+        return fmt.Errorf("error creating subscription pause: %v", err)
+    }
+
+    if pause.ID, err = result.LastInsertId(); err != nil {
+        return fmt.Errorf("error getting subscription pause ID: %v", err)
+    }
+
+    if err := moveSubscription(ctx, tx, pause.MasterReference, pause.PreviousSubscriptionID,
+        pause.SubscriptionID, pause.OriginalRenewDate, pause.RenewDate); err != nil {
+        return err
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE users SET is_active = ? WHERE master_reference = ? AND is_active = 1",
+        models.AccountStatusPaused, pause.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error pausing users: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing subscription pause: %v", err)
+    }
+
+    pause.Status = PauseStatusPaused
+    return nil
+}
+
+// GetActivePause retorna a pausa em andamento da conta.
+// Retorna sql.ErrNoRows se a conta não estiver pausada.
+func (c *Connection) GetActivePause(masterRef string) (*SubscriptionPause, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    row := c.db.QueryRowContext(ctx, `
+        SELECT `+subscriptionPauseColumns+`
+        FROM subscription_pauses
+        WHERE master_reference = ? AND status = ?
+        ORDER BY id DESC LIMIT 1`,
+        masterRef, PauseStatusPaused)
+
+    pause, err := scanSubscriptionPause(row)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting subscription pause for %s: %v", masterRef, err)
+    }
+
+    return pause, nil
+}
+
+// ResumeSubscriptionPause encerra a pausa e reativa os usuários. Na retomada antecipada
+// newSubscriptionID é a ARB que substitui a da pausa e renewDate a nova primeira cobrança;
+// na retomada automática newSubscriptionID é vazio e nada muda na assinatura.
+func (c *Connection) ResumeSubscriptionPause(pause *SubscriptionPause, newSubscriptionID string, renewDate time.Time) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    subscriptionID := pause.SubscriptionID
+    if newSubscriptionID != "" {
+        subscriptionID = newSubscriptionID
+    } else {
+        renewDate = pause.RenewDate
+    }
+
+    result, err := tx.ExecContext(ctx, `
+        UPDATE subscription_pauses
+        SET status = ?, subscription_id = ?, renew_date = ?, resumed_at = NOW()
+        WHERE id = ? AND status = ?`,
+        PauseStatusResumed, subscriptionID, renewDate, pause.ID, PauseStatusPaused)
+    if err != nil {
+        return fmt.Errorf("error resuming subscription pause %d: %v", pause.ID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return fmt.Errorf("subscription pause %d is no longer active", pause.ID)
+    }
+
+    if newSubscriptionID != "" {
+        if err := moveSubscription(ctx, tx, pause.MasterReference, pause.SubscriptionID,
+            newSubscriptionID, pause.RenewDate, renewDate); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE users SET is_active = 1 WHERE master_reference = ? AND is_active = ?",
+        pause.MasterReference, models.AccountStatusPaused)
+    if err != nil {
+        return fmt.Errorf("error resuming users: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing subscription resume: %v", err)
+    }
+
+    pause.Status = PauseStatusResumed
+    pause.SubscriptionID = subscriptionID
+    pause.RenewDate = renewDate
+    return nil
+}
+
+// moveSubscription troca a assinatura ARB da conta e leva renew_date, a fatura futura e os
+// créditos agendados de fromDate para toDate
+func moveSubscription(ctx context.Context, tx *sql.Tx, masterRef, oldSubscriptionID, newSubscriptionID string, fromDate, toDate time.Time) error {
+    _, err := tx.ExecContext(ctx, `
+        UPDATE subscriptions
+        SET subscription_id = ?, next_billing_date = ?, updated_at = NOW()
+        WHERE master_reference = ? AND subscription_id = ?`,
+        newSubscriptionID, toDate, masterRef, oldSubscriptionID)
+    if err != nil {
+        return fmt.Errorf("error updating subscription: %v", err)
+    }
+
+    _, err = tx.ExecContext(ctx,
+        "UPDATE master_accounts SET renew_date = ? WHERE reference_uuid = ?",
+        toDate, masterRef)
+    if err != nil {
+        return fmt.Errorf("error updating renew date: %v", err)
+    }
+
+    days := int(toDate.Sub(fromDate).Hours() / 24)
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE invoices SET due_date = DATE_ADD(due_date, INTERVAL ? DAY)
+        WHERE master_reference = ? AND is_paid = 0 AND due_date > NOW()`,
+        days, masterRef)
+    if err != nil {
+        return fmt.Errorf("error moving future invoice: %v", err)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE account_credits SET cycle_date = DATE_ADD(cycle_date, INTERVAL ? DAY)
+        WHERE master_reference = ? AND status = ?`,
+        days, masterRef, AccountCreditStatusScheduled)
+    if err != nil {
+        return fmt.Errorf("error moving account credits: %v", err)
+    }
+
+    return nil
+}
+
+// GetDuePauses lista as pausas cuja data de retomada já chegou
+func (c *Connection) GetDuePauses(limit int) ([]SubscriptionPause, error) {
+    return c.listSubscriptionPauses(`
+        SELECT `+subscriptionPauseColumns+`
+        FROM subscription_pauses
+        WHERE status = ? AND resume_at <= NOW()
+        ORDER BY resume_at ASC LIMIT ?`,
+        PauseStatusPaused, limit)
+}
+
+// GetPausesNeedingReminder lista as pausas que retomam dentro de within e ainda não
+// tiveram o aviso enviado
+func (c *Connection) GetPausesNeedingReminder(within time.Duration, limit int) ([]SubscriptionPause, error) {
+    return c.listSubscriptionPauses(`
+        SELECT `+subscriptionPauseColumns+`
+        FROM subscription_pauses
+        WHERE status = ? AND reminder_sent_at IS NULL AND resume_at <= ?
+        ORDER BY resume_at ASC LIMIT ?`,
+        PauseStatusPaused, time.Now().Add(within), limit)
+}
+
+func (c *Connection) listSubscriptionPauses(query string, args ...interface{}) ([]SubscriptionPause, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing subscription pauses: %v", err)
+    }
+    defer rows.Close()
+
+    var pauses []SubscriptionPause
+    for rows.Next() {
+        pause, err := scanSubscriptionPause(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning subscription pause: %v", err)
+        }
+        pauses = append(pauses, *pause)
+    }
+
+    return pauses, rows.Err()
+}
+
+// MarkPauseReminderSent registra o envio do aviso de retomada
+func (c *Connection) MarkPauseReminderSent(pauseID int64) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx,
+        "UPDATE subscription_pauses SET reminder_sent_at = NOW() WHERE id = ?",
+        pauseID)
+    if err != nil {
+        return fmt.Errorf("error marking pause reminder %d: %v", pauseID, err)
+    }
+
+    return nil
+}
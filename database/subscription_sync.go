@@ -0,0 +1,165 @@
+// database/subscription_sync.go - Conferência do status das assinaturas com a ARB da Authorize.net
+//
+// O status local de subscriptions é atualizado pelas notificações da ARB. Quando uma delas
+// se perde (ou a assinatura é alterada direto no painel da Authorize.net), a cópia local
+// diverge do gateway; a conferência periódica corrige o status e registra a divergência.
+//
+// Esquema esperado:
+//
+//   ALTER TABLE subscriptions
+//       ADD COLUMN gateway_status VARCHAR(16) NULL,     -- último status lido da ARB
+//       ADD COLUMN gateway_checked_at TIMESTAMP NULL;
+//
+//   CREATE TABLE subscription_status_drifts (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       subscription_id VARCHAR(32) NOT NULL,
+//       master_reference VARCHAR(36) NOT NULL,
+//       local_status VARCHAR(20) NOT NULL,             -- status local antes da correção
+//       gateway_status VARCHAR(16) NOT NULL,
+//       corrected_status VARCHAR(20) NOT NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_subscription_status_drifts_subscription (subscription_id)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+)
+
+// SubscriptionSyncItem é uma assinatura a conferir com o gateway
+type SubscriptionSyncItem struct {
+    SubscriptionID  string
+    MasterReference string
+    Status          string
+}
+
+// GetSubscriptionsToSync lista as assinaturas ainda vivas (não canceladas, encerradas ou
+// expiradas localmente), começando pelas conferidas há mais tempo
+func (c *Connection) GetSubscriptionsToSync(limit int) ([]SubscriptionSyncItem, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT subscription_id, master_reference, status
+        FROM subscriptions
+        WHERE subscription_id IS NOT NULL AND subscription_id <> ''
+          AND status NOT IN ('cancelled', 'terminated', 'expired')
+        ORDER BY gateway_checked_at IS NOT NULL, gateway_checked_at ASC
+        LIMIT ?`, limit)
+    if err != nil {
+        return nil, fmt.Errorf("error listing subscriptions to sync: %v", err)
+    }
+    defer rows.Close()
+
+    var items []SubscriptionSyncItem
+    for rows.Next() {
+        var item SubscriptionSyncItem
+        if err := rows.Scan(&item.SubscriptionID, &item.MasterReference, &item.Status); err != nil {
+            return nil, fmt.Errorf("error scanning subscription: %v", err)
+        }
+        items = append(items, item)
+    }
+
+    return items, rows.Err()
+}
+
+// RecordSubscriptionGatewayStatus grava o status lido da ARB sem alterar o status local
+func (c *Connection) RecordSubscriptionGatewayStatus(subscriptionID, gatewayStatus string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx,
+        "UPDATE subscriptions SET gateway_status = ?, gateway_checked_at = NOW() WHERE subscription_id = ?",
+        gatewayStatus, subscriptionID)
+    if err != nil {
+        return fmt.Errorf("error recording gateway status of %s: %v", subscriptionID, err)
+    }
+
+    return nil
+}
+
+// CorrectSubscriptionStatus aplica o status do gateway à assinatura e registra a divergência.
+// A correção só acontece se o status local ainda for o lido em item (uma notificação
+// processada no meio do caminho tem precedência). Retorna false se nada mudou.
+func (c *Connection) CorrectSubscriptionStatus(item *SubscriptionSyncItem, gatewayStatus, correctedStatus string) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, `
+        UPDATE subscriptions
+        SET status = ?, gateway_status = ?, gateway_checked_at = NOW(), updated_at = NOW()
+        WHERE subscription_id = ? AND status = ?`,
+        correctedStatus, gatewayStatus, item.SubscriptionID, item.Status)
+    if err != nil {
+        return false, fmt.Errorf("error correcting subscription status: %v", err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return false, nil
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        INSERT INTO subscription_status_drifts
+            (subscription_id, master_reference, local_status, gateway_status, corrected_status, created_at)
+        VALUES (?, ?, ?, ?, ?, NOW())`,
+        item.SubscriptionID, item.MasterReference, item.Status, gatewayStatus, correctedStatus)
+    if err != nil {
+        return false, fmt.Errorf("error recording subscription status drift: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, fmt.Errorf("error committing subscription status correction: %v", err)
+    }
+
+    log.Printf("Subscription %s status corrected from %s to %s (gateway: %s)",
+        item.SubscriptionID, item.Status, correctedStatus, gatewayStatus)
+    return true, nil
+}
+
+// GetLatestSubscriptionID retorna a assinatura ARB mais recente da conta, em qualquer
+// status. Retorna sql.ErrNoRows se a conta não tiver assinatura.
+func (c *Connection) GetLatestSubscriptionID(masterRef string) (string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var subscriptionID string
+    err := c.db.QueryRowContext(ctx, `
+        SELECT subscription_id FROM subscriptions
+        WHERE master_reference = ? AND subscription_id IS NOT NULL AND subscription_id <> ''
+        ORDER BY status = 'active' DESC, updated_at DESC
+        LIMIT 1`, masterRef).Scan(&subscriptionID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return "", err
+        }
+        return "", fmt.Errorf("error getting subscription of %s: %v", masterRef, err)
+    }
+
+    return subscriptionID, nil
+}
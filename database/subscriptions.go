@@ -0,0 +1,666 @@
+// database/subscriptions.go - Assinaturas das contas: remoção de planos, troca entre mensal e
+// anual e cancelamentos pelo cliente
+//
+// Esquema esperado:
+//
+//   CREATE TABLE subscription_cancellations (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       master_reference VARCHAR(36) NOT NULL,
+//       subscription_id VARCHAR(32) NOT NULL,          -- assinatura ARB cancelada
+//       requested_by VARCHAR(255) NOT NULL,
+//       reason VARCHAR(32) NOT NULL,                   -- motivo do churn (código)
+//       comment TEXT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- scheduled, undone, completed
+//       effective_at DATETIME NOT NULL,                -- renew_date: fim do período pago
+//       resumed_subscription_id VARCHAR(32) NULL,      -- nova assinatura ARB ao desfazer
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       undone_at TIMESTAMP NULL,
+//       completed_at TIMESTAMP NULL,
+//       KEY idx_subscription_cancellations_master (master_reference, status),
+//       KEY idx_subscription_cancellations_due (status, effective_at)
+//   )
+//
+//   CREATE TABLE account_locks (
+//       master_reference VARCHAR(36) PRIMARY KEY,      -- conta com uma mudança de assinatura em andamento
+//       locked_at DATETIME NOT NULL
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+)
+
+// Status dos cancelamentos de assinatura
+const (
+    CancellationStatusScheduled = "scheduled"
+    CancellationStatusUndone    = "undone"
+    CancellationStatusCompleted = "completed"
+)
+
+// SubscriptionCancellation é uma linha de subscription_cancellations
+type SubscriptionCancellation struct {
+    ID                    int64      `json:"id"`
+    MasterReference       string     `json:"master_reference"`
+    SubscriptionID        string     `json:"subscription_id"`
+    RequestedBy           string     `json:"requested_by"`
+    Reason                string     `json:"reason"`
+    Comment               string     `json:"comment,omitempty"`
+    Status                string     `json:"status"`
+    EffectiveAt           time.Time  `json:"effective_at"`
+    ResumedSubscriptionID string     `json:"resumed_subscription_id,omitempty"`
+    CreatedAt             time.Time  `json:"created_at"`
+    UndoneAt              *time.Time `json:"undone_at,omitempty"`
+    CompletedAt           *time.Time `json:"completed_at,omitempty"`
+}
+
+// CancellationReasonCount é o total de cancelamentos de um motivo no período
+type CancellationReasonCount struct {
+    Reason    string `json:"reason"`
+    Total     int    `json:"total"`
+    Undone    int    `json:"undone"`
+    Completed int    `json:"completed"`
+}
+
+// ErrAccountChanged indica que a conta mudou entre o cálculo e a gravação de uma mudança
+// de assinatura (ex.: dois envios da mesma troca)
+var ErrAccountChanged = errors.New("account changed by another request")
+
+// LockAccount reserva a conta para uma mudança de assinatura que cobra o cliente, como
+// LockCheckout faz com o checkout. Retorna false se outra requisição já tem a reserva; uma
+// reserva esquecida vence em 5 minutos.
+func (c *Connection) LockAccount(masterRef string) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        INSERT INTO account_locks (master_reference, locked_at)
+        VALUES (?, NOW())
+        ON DUPLICATE KEY UPDATE
+        locked_at = IF(locked_at < NOW() - INTERVAL 5 MINUTE, NOW(), locked_at)
+    `, masterRef)
+    if err != nil {
+        return false, fmt.Errorf("error acquiring account lock: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return rows > 0, nil
+}
+
+// ReleaseAccountLock libera a reserva de LockAccount
+func (c *Connection) ReleaseAccountLock(masterRef string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, "DELETE FROM account_locks WHERE master_reference = ?", masterRef)
+    if err != nil {
+        return fmt.Errorf("error releasing account lock: %v", err)
+    }
+
+    return nil
+}
+
+// GetActiveSubscriptionID retorna o ID da assinatura ARB ativa da conta.
+// Retorna sql.ErrNoRows se a conta não tiver assinatura ativa.
+func (c *Connection) GetActiveSubscriptionID(masterRef string) (string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var subscriptionID string
+    err := c.db.QueryRowContext(ctx,
+        "SELECT subscription_id FROM subscriptions WHERE master_reference = ? AND status = 'active' LIMIT 1",
+        masterRef).Scan(&subscriptionID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return "", err
+        }
+        return "", fmt.Errorf("error getting subscription for %s: %v", masterRef, err)
+    }
+
+    return subscriptionID, nil
+}
+
+// GetAccountTotalPrice retorna o valor cheio da assinatura da conta (master_accounts.total_price)
+func (c *Connection) GetAccountTotalPrice(masterRef string) (float64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var totalPrice float64
+    err := c.db.QueryRowContext(ctx,
+        "SELECT total_price FROM master_accounts WHERE reference_uuid = ?",
+        masterRef).Scan(&totalPrice)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return 0, err
+        }
+        return 0, fmt.Errorf("error getting total price for %s: %v", masterRef, err)
+    }
+
+    return totalPrice, nil
+}
+
+// PlanRemoval é a remoção de planos de uma conta
+type PlanRemoval struct {
+    MasterReference string
+    PreviousPlans   string          // purchased_plans lido no cálculo da remoção
+    PurchasedPlans  string          // planos restantes
+    TotalPrice      float64         // novo valor cheio por ciclo
+    Decrease        float64         // redução do valor por ciclo
+    DecreaseTax     float64         // imposto correspondente a Decrease
+    RemovedPlans    int
+    RemovedUsers    []string
+    Credits         []AccountCredit // pro-rata como crédito de conta, distribuído pelos ciclos
+    Refund          *Refund         // pro-rata como estorno; nil = crédito de conta
+}
+
+// ApplyPlanRemoval grava os planos restantes da conta, o novo valor da assinatura e
+// desativa os sub-usuários removidos. A fatura futura pendente é reduzida em Decrease mais
+// o imposto (DecreaseTax). Na mesma transação o pro-rata é reservado: o estorno fica
+// pending (Refund.ID preenchido) para a chamada ao gateway ou, sem Refund ou sem saldo
+// para estornar, os Credits são agendados. Retorna ErrAccountChanged se os planos da conta
+// não são mais PreviousPlans.
+func (c *Connection) ApplyPlanRemoval(removal *PlanRemoval) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, `
+        UPDATE master_accounts
+        SET purchased_plans = ?, total_price = ?, simultaneus_users = GREATEST(simultaneus_users - ?, 1)
+        WHERE reference_uuid = ? AND purchased_plans = ?`,
+        removal.PurchasedPlans, removal.TotalPrice, removal.RemovedPlans, removal.MasterReference, removal.PreviousPlans)
+    if err != nil {
+        return fmt.Errorf("error updating master account: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("error getting rows affected: %v", err)
+    }
+    if rows == 0 {
+        return ErrAccountChanged
+    }
+
+    for _, username := range removal.RemovedUsers {
+        _, err = tx.ExecContext(ctx,
+            "UPDATE users SET is_active = 0 WHERE master_reference = ? AND username = ? AND is_master = 0",
+            removal.MasterReference, username)
+        if err != nil {
+            return fmt.Errorf("error deactivating user %s: %v", username, err)
+        }
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE invoices SET total = GREATEST(total - ?, 0), tax = GREATEST(tax - ?, 0)
+        WHERE master_reference = ? AND is_paid = 0 AND due_date > NOW()
+        ORDER BY due_date ASC LIMIT 1`,
+        removal.Decrease+removal.DecreaseTax, removal.DecreaseTax, removal.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error updating future invoice: %v", err)
+    }
+
+    refunded := false
+    if removal.Refund != nil {
+        _, err := reserveRefund(ctx, tx, removal.Refund)
+        switch err {
+        case nil:
+            refunded = true
+        case sql.ErrNoRows, ErrTransactionNotRefundable, ErrRefundExceedsBalance:
+            // O saldo mudou desde a busca: o valor vira crédito de conta
+            log.Printf("Refund of $%.2f for %s not possible, keeping as account credit: %v",
+                removal.Refund.Amount, removal.MasterReference, err)
+        default:
+            return err
+        }
+    }
+
+    if !refunded {
+        if err := insertAccountCredits(ctx, tx, removal.Credits); err != nil {
+            return err
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing plan removal: %v", err)
+    }
+
+    log.Printf("Removed %d plans from %s (new total: $%.2f, deactivated users: %d)",
+        removal.RemovedPlans, removal.MasterReference, removal.TotalPrice, len(removal.RemovedUsers))
+    return nil
+}
+
+// BillingIntervalSwitch é a troca de uma conta entre cobrança mensal e anual
+type BillingIntervalSwitch struct {
+    MasterReference   string
+    PreviousPlans     string          // purchased_plans lido no cálculo da troca
+    WasAnnually       bool
+    PurchasedPlans    string          // purchased_plans com os flags "anually" reescritos
+    IsAnnually        bool
+    IsTrial           bool
+    TotalPrice        float64         // novo valor cheio por ciclo
+    RenewDate         time.Time       // início do primeiro ciclo no novo intervalo
+    NextCharge        float64         // primeira cobrança da ARB (valor cheio menos crédito, com imposto)
+    NextChargeTax     float64         // imposto incluído em NextCharge
+    OldSubscriptionID string          // assinatura ARB substituída; vazio se não havia
+    NewSubscriptionID string
+    TransactionID     string          // cobrança do pro-rata; vazio se não houve
+    ChargedAmount     float64
+    ChargedTax        float64         // imposto incluído em ChargedAmount
+    Credits           []AccountCredit // saldo a favor distribuído pelos ciclos do novo intervalo
+}
+
+// ApplyBillingIntervalSwitch grava a troca de intervalo atomicamente: conta e planos,
+// assinatura, cobrança do pro-rata e fatura futura. Créditos ainda agendados no intervalo
+// antigo são marcados como transferidos (o valor já entrou no pro-rata). Retorna
+// ErrAccountChanged se a conta não está mais como PreviousPlans e WasAnnually.
+func (c *Connection) ApplyBillingIntervalSwitch(change *BillingIntervalSwitch) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    isAnnually, wasAnnually := 0, 0
+    if change.IsAnnually {
+        isAnnually = 1
+    }
+    if change.WasAnnually {
+        wasAnnually = 1
+    }
+
+    result, err := tx.ExecContext(ctx, `
+        UPDATE master_accounts
+        SET purchased_plans = ?, is_annually = ?, total_price = ?, renew_date = ?
+        WHERE reference_uuid = ? AND purchased_plans = ? AND is_annually = ?`,
+        change.PurchasedPlans, isAnnually, change.TotalPrice, change.RenewDate, change.MasterReference,
+        change.PreviousPlans, wasAnnually)
+    if err != nil {
+        return fmt.Errorf("error updating master account: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("error getting rows affected: %v", err)
+    }
+    if rows == 0 {
+        return ErrAccountChanged
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE account_credits SET status = ?, applied_at = NOW()
+        WHERE master_reference = ? AND status = ?`,
+        AccountCreditStatusTransferred, change.MasterReference, AccountCreditStatusScheduled)
+    if err != nil {
+        return fmt.Errorf("error transferring account credits: %v", err)
+    }
+
+    if err := insertAccountCredits(ctx, tx, change.Credits); err != nil {
+        return err
+    }
+
+    if change.NewSubscriptionID != "" {
+        _, err = tx.ExecContext(ctx, `
+            UPDATE subscriptions
+            SET subscription_id = ?, next_billing_date = ?, updated_at = NOW()
+            WHERE master_reference = ? AND subscription_id = ?`,
+            change.NewSubscriptionID, change.RenewDate, change.MasterReference, change.OldSubscriptionID)
+        if err != nil {
+            return fmt.Errorf("error updating subscription: %v", err)
+        }
+    }
+
+    if change.ChargedAmount > 0 {
+        _, err = tx.ExecContext(ctx, `
+            INSERT INTO transactions (id, master_reference, checkout_id, amount, currency, status, transaction_id, created_at)
+            VALUES (UUID(), ?, 'BILLING_SWITCH', ?, `+accountCurrencySQL+`, 'captured', ?, NOW())`,
+            change.MasterReference, change.ChargedAmount, change.MasterReference, change.TransactionID)
+        if err != nil {
+            return fmt.Errorf("error saving transaction: %v", err)
+        }
+
+        _, err = tx.ExecContext(ctx, `
+            INSERT INTO invoices (master_reference, is_trial, total, tax, currency, due_date, is_paid, created_at)
+            VALUES (?, 0, ?, ?, `+accountCurrencySQL+`, NOW(), 1, NOW())`,
+            change.MasterReference, change.ChargedAmount, change.ChargedTax, change.MasterReference)
+        if err != nil {
+            return fmt.Errorf("error creating prorata invoice: %v", err)
+        }
+    }
+
+    // A fatura futura passa para a data e o valor do primeiro ciclo no novo intervalo
+    result, err = tx.ExecContext(ctx, `
+        UPDATE invoices SET total = ?, tax = ?, due_date = ?
+        WHERE master_reference = ? AND is_paid = 0 AND due_date > NOW()
+        ORDER BY due_date ASC LIMIT 1`,
+        change.NextCharge, change.NextChargeTax, change.RenewDate, change.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error updating future invoice: %v", err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        isTrial := 0
+        if change.IsTrial {
+            isTrial = 1
+        }
+        _, err = tx.ExecContext(ctx, `
+            INSERT INTO invoices (master_reference, is_trial, total, tax, currency, due_date, is_paid, created_at)
+            VALUES (?, ?, ?, ?, `+accountCurrencySQL+`, ?, 0, NOW())`,
+            change.MasterReference, isTrial, change.NextCharge, change.NextChargeTax, change.MasterReference, change.RenewDate)
+        if err != nil {
+            return fmt.Errorf("error creating future invoice: %v", err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing billing interval switch: %v", err)
+    }
+
+    log.Printf("Switched %s to %s billing (total: $%.2f, renews %s, subscription %s)",
+        change.MasterReference, map[bool]string{true: "annual", false: "monthly"}[change.IsAnnually],
+        change.TotalPrice, change.RenewDate.Format("2006-01-02"), change.NewSubscriptionID)
+    return nil
+}
+
+// CreateSubscriptionCancellation registra um cancelamento agendado para o fim do período
+// e marca a assinatura como cancelada
+func (c *Connection) CreateSubscriptionCancellation(cancellation *SubscriptionCancellation) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, `
+        INSERT INTO subscription_cancellations
+        (master_reference, subscription_id, requested_by, reason, comment, status, effective_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, NOW())`,
+        cancellation.MasterReference, cancellation.SubscriptionID, cancellation.RequestedBy,
+        cancellation.Reason, nullString(cancellation.Comment), CancellationStatusScheduled,
+        cancellation.EffectiveAt)
+    if err != nil {
+        return fmt.Errorf("error creating subscription cancellation: %v", err)
+    }
+
+    id, err := result.LastInsertId()
+    if err != nil {
+        return fmt.Errorf("error getting cancellation ID: %v", err)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE subscriptions SET status = 'cancelled', updated_at = NOW()
+        WHERE master_reference = ? AND subscription_id = ?`,
+        cancellation.MasterReference, cancellation.SubscriptionID)
+    if err != nil {
+        return fmt.Errorf("error updating subscription status: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing subscription cancellation: %v", err)
+    }
+
+    cancellation.ID = id
+    cancellation.Status = CancellationStatusScheduled
+    return nil
+}
+
+// GetScheduledCancellation busca o cancelamento agendado (ainda no período pago) da conta.
+// Retorna sql.ErrNoRows se não houver.
+func (c *Connection) GetScheduledCancellation(masterRef string) (*SubscriptionCancellation, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    row := c.db.QueryRowContext(ctx, `
+        SELECT id, master_reference, subscription_id, requested_by, reason, comment, status,
+               effective_at, resumed_subscription_id, created_at, undone_at, completed_at
+        FROM subscription_cancellations
+        WHERE master_reference = ? AND status = ?
+        ORDER BY created_at DESC LIMIT 1`,
+        masterRef, CancellationStatusScheduled)
+
+    cancellation, err := scanSubscriptionCancellation(row)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting cancellation for %s: %v", masterRef, err)
+    }
+
+    return cancellation, nil
+}
+
+// UndoSubscriptionCancellation desfaz um cancelamento agendado: grava a nova assinatura
+// ARB e a reativa na tabela subscriptions
+func (c *Connection) UndoSubscriptionCancellation(cancellation *SubscriptionCancellation, newSubscriptionID string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, `
+        UPDATE subscription_cancellations
+        SET status = ?, resumed_subscription_id = ?, undone_at = NOW()
+        WHERE id = ? AND status = ?`,
+        CancellationStatusUndone, newSubscriptionID, cancellation.ID, CancellationStatusScheduled)
+    if err != nil {
+        return fmt.Errorf("error undoing cancellation %d: %v", cancellation.ID, err)
+    }
+
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return fmt.Errorf("cancellation %d is no longer scheduled", cancellation.ID)
+    }
+
+    _, err = tx.ExecContext(ctx, `
+        UPDATE subscriptions
+        SET subscription_id = ?, status = 'active', updated_at = NOW()
+        WHERE master_reference = ? AND subscription_id = ?`,
+        newSubscriptionID, cancellation.MasterReference, cancellation.SubscriptionID)
+    if err != nil {
+        return fmt.Errorf("error reactivating subscription: %v", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error committing cancellation undo: %v", err)
+    }
+
+    cancellation.Status = CancellationStatusUndone
+    cancellation.ResumedSubscriptionID = newSubscriptionID
+    return nil
+}
+
+// GetDueCancellations lista os cancelamentos agendados cujo período pago já terminou
+func (c *Connection) GetDueCancellations(limit int) ([]SubscriptionCancellation, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT id, master_reference, subscription_id, requested_by, reason, comment, status,
+               effective_at, resumed_subscription_id, created_at, undone_at, completed_at
+        FROM subscription_cancellations
+        WHERE status = ? AND effective_at <= NOW()
+        ORDER BY effective_at
+        LIMIT ?`,
+        CancellationStatusScheduled, limit)
+    if err != nil {
+        return nil, fmt.Errorf("error listing due cancellations: %v", err)
+    }
+    defer rows.Close()
+
+    var cancellations []SubscriptionCancellation
+    for rows.Next() {
+        cancellation, err := scanSubscriptionCancellation(rows)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning cancellation: %v", err)
+        }
+        cancellations = append(cancellations, *cancellation)
+    }
+
+    return cancellations, rows.Err()
+}
+
+// CompleteSubscriptionCancellation encerra o cancelamento: desativa todos os usuários da
+// conta (is_active = 0) e marca o cancelamento como concluído
+func (c *Connection) CompleteSubscriptionCancellation(cancellation *SubscriptionCancellation) (int64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    tx, err := c.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, `
+        UPDATE subscription_cancellations
+        SET status = ?, completed_at = NOW()
+        WHERE id = ? AND status = ?`,
+        CancellationStatusCompleted, cancellation.ID, CancellationStatusScheduled)
+    if err != nil {
+        return 0, fmt.Errorf("error completing cancellation %d: %v", cancellation.ID, err)
+    }
+
+    // Desfeito (ou concluído por outra instância) entre a listagem e agora
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return 0, nil
+    }
+
+    result, err = tx.ExecContext(ctx,
+        "UPDATE users SET is_active = 0 WHERE master_reference = ?",
+        cancellation.MasterReference)
+    if err != nil {
+        return 0, fmt.Errorf("error deactivating users for %s: %v", cancellation.MasterReference, err)
+    }
+
+    deactivated, _ := result.RowsAffected()
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("error committing cancellation %d: %v", cancellation.ID, err)
+    }
+
+    log.Printf("Cancellation %d completed: %d users deactivated for %s",
+        cancellation.ID, deactivated, cancellation.MasterReference)
+    return deactivated, nil
+}
+
+// GetCancellationReasonStats agrupa os cancelamentos do período por motivo
+func (c *Connection) GetCancellationReasonStats(from, to time.Time) ([]CancellationReasonCount, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    rows, err := c.db.QueryContext(ctx, `
+        SELECT reason, COUNT(*),
+               SUM(CASE WHEN status = ? THEN 1 ELSE 0 END),
+               SUM(CASE WHEN status = ? THEN 1 ELSE 0 END)
+        FROM subscription_cancellations
+        WHERE created_at >= ? AND created_at < ?
+        GROUP BY reason
+        ORDER BY COUNT(*) DESC`,
+        CancellationStatusUndone, CancellationStatusCompleted, from, to)
+    if err != nil {
+        return nil, fmt.Errorf("error getting cancellation stats: %v", err)
+    }
+    defer rows.Close()
+
+    var stats []CancellationReasonCount
+    for rows.Next() {
+        var count CancellationReasonCount
+        if err := rows.Scan(&count.Reason, &count.Total, &count.Undone, &count.Completed); err != nil {
+            return nil, fmt.Errorf("error scanning cancellation stats: %v", err)
+        }
+        stats = append(stats, count)
+    }
+
+    return stats, rows.Err()
+}
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanSubscriptionCancellation(row rowScanner) (*SubscriptionCancellation, error) {
+    var cancellation SubscriptionCancellation
+    var comment, resumedSubscriptionID sql.NullString
+    var undoneAt, completedAt sql.NullTime
+
+    err := row.Scan(
+        &cancellation.ID, &cancellation.MasterReference, &cancellation.SubscriptionID,
+        &cancellation.RequestedBy, &cancellation.Reason, &comment, &cancellation.Status,
+        &cancellation.EffectiveAt, &resumedSubscriptionID, &cancellation.CreatedAt,
+        &undoneAt, &completedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    cancellation.Comment = comment.String
+    cancellation.ResumedSubscriptionID = resumedSubscriptionID.String
+    if undoneAt.Valid {
+        cancellation.UndoneAt = &undoneAt.Time
+    }
+    if completedAt.Valid {
+        cancellation.CompletedAt = &completedAt.Time
+    }
+
+    return &cancellation, nil
+}
@@ -0,0 +1,66 @@
+// database/tax.go - Imposto sobre vendas pelo endereço de cobrança
+//
+// A alíquota vem do motor configurado em SetTaxEngine e é escolhida pelo endereço do
+// checkout (na compra) ou da conta (nas cobranças seguintes). As faturas guardam o
+// imposto separado do total, que já o inclui.
+//
+// Esquema esperado:
+//
+//   ALTER TABLE invoices
+//       ADD COLUMN tax DECIMAL(10,2) NOT NULL DEFAULT 0;  -- parte de total que é imposto
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/services/tax"
+)
+
+// SetTaxEngine define o motor de impostos usado nos cálculos; sem ele nenhum imposto é cobrado
+func (c *Connection) SetTaxEngine(engine tax.Engine) {
+    c.tax = engine
+}
+
+// TaxRate retorna a alíquota do endereço
+func (c *Connection) TaxRate(addr tax.Address) tax.Rate {
+    if c.tax == nil {
+        return tax.Rate{}
+    }
+    return c.tax.Lookup(addr)
+}
+
+// AccountTaxRate retorna a alíquota do endereço de cobrança de uma conta já carregada
+func (c *Connection) AccountTaxRate(account *models.MasterAccount) tax.Rate {
+    return c.TaxRate(tax.Address{State: account.State, City: account.City, ZipCode: account.ZipCode})
+}
+
+// GetAccountTaxRate retorna a alíquota do endereço de cobrança da conta
+func (c *Connection) GetAccountTaxRate(masterRef string) (tax.Rate, error) {
+    if c.tax == nil {
+        return tax.Rate{}, nil
+    }
+
+    if err := c.ensureConnection(); err != nil {
+        return tax.Rate{}, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var addr tax.Address
+    err := c.db.QueryRowContext(ctx,
+        "SELECT COALESCE(state, ''), COALESCE(city, ''), COALESCE(zip_code, '') FROM master_accounts WHERE reference_uuid = ?",
+        masterRef).Scan(&addr.State, &addr.City, &addr.ZipCode)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return tax.Rate{}, err
+        }
+        return tax.Rate{}, fmt.Errorf("error getting billing address of %s: %v", masterRef, err)
+    }
+
+    return c.tax.Lookup(addr), nil
+}
@@ -0,0 +1,214 @@
+// database/temp_payment_data.go - Dados de cartão temporários (cifrados) entre o checkout e o worker
+//
+// Esquema esperado (colunas cifradas guardam base64 de nonce+ciphertext):
+//
+//   CREATE TABLE temp_payment_data (
+//       checkout_id VARCHAR(36) PRIMARY KEY,
+//       key_id VARCHAR(32) NOT NULL,
+//       card_number VARCHAR(255) NOT NULL,
+//       card_expiry VARCHAR(255) NOT NULL,
+//       card_cvv VARCHAR(255) NULL,
+//       card_name VARCHAR(255) NOT NULL,
+//       opaque_descriptor VARCHAR(64) NULL,
+//       opaque_value TEXT NULL,
+//       customer_profile_id VARCHAR(32) NULL,
+//       payment_profile_id VARCHAR(32) NULL,
+//       account_number VARCHAR(32) NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_temp_payment_data_created_at (created_at)
+//   )
+//
+// Checkouts com Accept.js gravam o nonce (opaque_value, cifrado) e deixam card_number e
+// card_expiry vazios. Quando o nonce é consumido, opaque_value é apagado e o customer
+// profile criado a partir dele (e o número mascarado do cartão) ficam registrados.
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// EncryptedPaymentData é uma linha de temp_payment_data. CardNumber, CardExpiry, CardCVV
+// e OpaqueValue são ciphertexts produzidos pelo cardvault com a chave KeyID; CardCVV e
+// OpaqueValue ficam vazios após o primeiro uso.
+type EncryptedPaymentData struct {
+    CheckoutID        string
+    KeyID             string
+    CardNumber        string
+    CardExpiry        string
+    CardCVV           string
+    CardName          string
+    OpaqueDescriptor  string
+    OpaqueValue       string
+    CustomerProfileID string
+    PaymentProfileID  string
+    AccountNumber     string
+    CreatedAt         time.Time
+}
+
+// SaveTempPaymentData grava (ou substitui) os dados cifrados de um checkout
+func (c *Connection) SaveTempPaymentData(data *EncryptedPaymentData) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        INSERT INTO temp_payment_data
+        (checkout_id, key_id, card_number, card_expiry, card_cvv, card_name,
+         opaque_descriptor, opaque_value, customer_profile_id, payment_profile_id, account_number, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL, NULL, NULL, NOW())
+        ON DUPLICATE KEY UPDATE
+        key_id = VALUES(key_id),
+        card_number = VALUES(card_number),
+        card_expiry = VALUES(card_expiry),
+        card_cvv = VALUES(card_cvv),
+        card_name = VALUES(card_name),
+        opaque_descriptor = VALUES(opaque_descriptor),
+        opaque_value = VALUES(opaque_value),
+        customer_profile_id = NULL,
+        payment_profile_id = NULL,
+        account_number = NULL,
+        created_at = NOW()`,
+        data.CheckoutID, data.KeyID, data.CardNumber, data.CardExpiry, nullString(data.CardCVV), data.CardName,
+        nullString(data.OpaqueDescriptor), nullString(data.OpaqueValue))
+    if err != nil {
+        return fmt.Errorf("error storing temporary payment data: %v", err)
+    }
+
+    return nil
+}
+
+// GetTempPaymentData busca os dados cifrados de um checkout gravados há menos de maxAge.
+// Retorna sql.ErrNoRows se não existirem ou já tiverem expirado.
+func (c *Connection) GetTempPaymentData(checkoutID string, maxAge time.Duration) (*EncryptedPaymentData, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var data EncryptedPaymentData
+    var cardCVV, opaqueDescriptor, opaqueValue, customerProfileID, paymentProfileID, accountNumber sql.NullString
+    err := c.db.QueryRowContext(ctx, `
+        SELECT checkout_id, key_id, card_number, card_expiry, card_cvv, card_name,
+               opaque_descriptor, opaque_value, customer_profile_id, payment_profile_id, account_number, created_at
+        FROM temp_payment_data
+        WHERE checkout_id = ?
+        AND created_at > NOW() - INTERVAL ? SECOND`,
+        checkoutID, int64(maxAge.Seconds())).Scan(
+        &data.CheckoutID, &data.KeyID, &data.CardNumber, &data.CardExpiry, &cardCVV, &data.CardName,
+        &opaqueDescriptor, &opaqueValue, &customerProfileID, &paymentProfileID, &accountNumber, &data.CreatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting temporary payment data: %v", err)
+    }
+
+    data.CardCVV = cardCVV.String
+    data.OpaqueDescriptor = opaqueDescriptor.String
+    data.OpaqueValue = opaqueValue.String
+    data.CustomerProfileID = customerProfileID.String
+    data.PaymentProfileID = paymentProfileID.String
+    data.AccountNumber = accountNumber.String
+    return &data, nil
+}
+
+// ClearTempPaymentCVV apaga o CVV de um checkout, mantendo os demais dados para as
+// etapas seguintes (customer profile e ARB)
+func (c *Connection) ClearTempPaymentCVV(checkoutID string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx,
+        "UPDATE temp_payment_data SET card_cvv = NULL WHERE checkout_id = ?",
+        checkoutID)
+    if err != nil {
+        return fmt.Errorf("error clearing temporary CVV: %v", err)
+    }
+
+    return nil
+}
+
+// SaveTempPaymentProfile registra o customer profile criado a partir do nonce do
+// Accept.js e apaga o nonce, que não pode ser reutilizado
+func (c *Connection) SaveTempPaymentProfile(checkoutID, customerProfileID, paymentProfileID, accountNumber string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        UPDATE temp_payment_data
+        SET opaque_value = NULL,
+            customer_profile_id = ?,
+            payment_profile_id = ?,
+            account_number = ?
+        WHERE checkout_id = ?`,
+        nullString(customerProfileID), nullString(paymentProfileID), nullString(accountNumber), checkoutID)
+    if err != nil {
+        return fmt.Errorf("error saving temporary payment profile: %v", err)
+    }
+
+    return nil
+}
+
+// DeleteTempPaymentData remove os dados temporários de um checkout
+func (c *Connection) DeleteTempPaymentData(checkoutID string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx,
+        "DELETE FROM temp_payment_data WHERE checkout_id = ?",
+        checkoutID)
+    if err != nil {
+        return fmt.Errorf("error deleting temporary payment data: %v", err)
+    }
+
+    return nil
+}
+
+// DeleteExpiredTempPaymentData remove definitivamente as linhas mais antigas que maxAge
+func (c *Connection) DeleteExpiredTempPaymentData(maxAge time.Duration) (int64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx,
+        "DELETE FROM temp_payment_data WHERE created_at <= NOW() - INTERVAL ? SECOND",
+        int64(maxAge.Seconds()))
+    if err != nil {
+        return 0, fmt.Errorf("error deleting expired temporary payment data: %v", err)
+    }
+
+    deleted, err := result.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("error checking expired temporary payment data deletion: %v", err)
+    }
+
+    return deleted, nil
+}
+
+// nullString grava strings vazias como NULL
+func nullString(value string) sql.NullString {
+    return sql.NullString{String: value, Valid: value != ""}
+}
@@ -0,0 +1,139 @@
+// database/threeds.go - Autenticações 3-D Secure dos checkouts
+//
+// Esquema esperado:
+//
+//   CREATE TABLE threeds_authentications (
+//       trans_id VARCHAR(64) PRIMARY KEY,          -- threeDSServerTransID
+//       checkout_id VARCHAR(36) NOT NULL,
+//       request_id VARCHAR(36) NOT NULL,
+//       status CHAR(1) NOT NULL,                    -- transStatus (Y, A, C, N, U, R)
+//       eci VARCHAR(2) NULL,
+//       cavv VARCHAR(64) NULL,
+//       ds_trans_id VARCHAR(64) NULL,
+//       version VARCHAR(16) NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       updated_at TIMESTAMP NULL,
+//       KEY idx_threeds_authentications_checkout (checkout_id)
+//   )
+//
+//   ALTER TABLE transactions
+//       ADD COLUMN three_ds_status CHAR(1) NULL,
+//       ADD COLUMN three_ds_eci VARCHAR(2) NULL,
+//       ADD COLUMN three_ds_cavv VARCHAR(64) NULL;
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+)
+
+// ThreeDSAuthentication é uma linha de threeds_authentications
+type ThreeDSAuthentication struct {
+    TransID    string    `json:"trans_id"`
+    CheckoutID string    `json:"checkout_id"`
+    RequestID  string    `json:"request_id"`
+    Status     string    `json:"status"`
+    ECI        string    `json:"eci,omitempty"`
+    CAVV       string    `json:"cavv,omitempty"`
+    DSTransID  string    `json:"ds_trans_id,omitempty"`
+    Version    string    `json:"version,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+}
+
+// SaveThreeDSAuthentication grava (ou atualiza) o resultado de uma autenticação 3DS
+func (c *Connection) SaveThreeDSAuthentication(auth *ThreeDSAuthentication) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        INSERT INTO threeds_authentications
+        (trans_id, checkout_id, request_id, status, eci, cavv, ds_trans_id, version, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())
+        ON DUPLICATE KEY UPDATE
+        status = VALUES(status),
+        eci = VALUES(eci),
+        cavv = VALUES(cavv),
+        ds_trans_id = VALUES(ds_trans_id),
+        version = VALUES(version),
+        updated_at = NOW()`,
+        auth.TransID, auth.CheckoutID, auth.RequestID, auth.Status,
+        nullString(auth.ECI), nullString(auth.CAVV), nullString(auth.DSTransID), nullString(auth.Version))
+    if err != nil {
+        return fmt.Errorf("error saving 3DS authentication: %v", err)
+    }
+
+    return nil
+}
+
+// GetThreeDSAuthentication busca uma autenticação pelo threeDSServerTransID.
+// Retorna sql.ErrNoRows se não existir.
+func (c *Connection) GetThreeDSAuthentication(transID string) (*ThreeDSAuthentication, error) {
+    return c.queryThreeDSAuthentication(`
+        SELECT trans_id, checkout_id, request_id, status, eci, cavv, ds_trans_id, version, created_at
+        FROM threeds_authentications
+        WHERE trans_id = ?`, transID)
+}
+
+// GetCheckoutThreeDSAuthentication busca a autenticação bem-sucedida (Y ou A) mais
+// recente de um checkout. Retorna sql.ErrNoRows se o checkout não passou pelo 3DS.
+func (c *Connection) GetCheckoutThreeDSAuthentication(checkoutID string) (*ThreeDSAuthentication, error) {
+    return c.queryThreeDSAuthentication(`
+        SELECT trans_id, checkout_id, request_id, status, eci, cavv, ds_trans_id, version, created_at
+        FROM threeds_authentications
+        WHERE checkout_id = ? AND status IN ('Y', 'A')
+        ORDER BY created_at DESC LIMIT 1`, checkoutID)
+}
+
+func (c *Connection) queryThreeDSAuthentication(query string, arg string) (*ThreeDSAuthentication, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var auth ThreeDSAuthentication
+    var eci, cavv, dsTransID, version sql.NullString
+    err := c.db.QueryRowContext(ctx, query, arg).Scan(
+        &auth.TransID, &auth.CheckoutID, &auth.RequestID, &auth.Status,
+        &eci, &cavv, &dsTransID, &version, &auth.CreatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, err
+        }
+        return nil, fmt.Errorf("error getting 3DS authentication: %v", err)
+    }
+
+    auth.ECI = eci.String
+    auth.CAVV = cavv.String
+    auth.DSTransID = dsTransID.String
+    auth.Version = version.String
+    return &auth, nil
+}
+
+// RecordTransactionThreeDS registra na transação o resultado do 3DS usado na autorização
+func (c *Connection) RecordTransactionThreeDS(masterRef, transactionID string, auth *ThreeDSAuthentication) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        UPDATE transactions
+        SET three_ds_status = ?, three_ds_eci = ?, three_ds_cavv = ?, updated_at = NOW()
+        WHERE master_reference = ? AND transaction_id = ?`,
+        auth.Status, nullString(auth.ECI), nullString(auth.CAVV), masterRef, transactionID)
+    if err != nil {
+        return fmt.Errorf("error recording 3DS result on transaction: %v", err)
+    }
+
+    return nil
+}
@@ -32,7 +32,9 @@ func (t *Transaction) SavePaymentMethod(masterRef string, card *models.CardData)
         VALUES (?, ?, ?)
     `
     
-    maskedCard := "XXXX XXXX XXXX " + card.Number[len(card.Number)-4:]
+    // Checkouts com Accept.js ainda não têm o cartão: o final é gravado depois da
+    // autorização (UpdatePaymentMethodCard)
+    maskedCard := MaskCardNumber(card.Number)
     
     _, err := t.tx.ExecContext(ctx, query, masterRef, maskedCard, card.Expiry)
     if err != nil {
@@ -44,6 +46,40 @@ func (t *Transaction) SavePaymentMethod(masterRef string, card *models.CardData)
     return nil
 }
 
+// MaskCardNumber mascara um número de cartão (completo ou já mascarado pelo gateway,
+// ex.: XXXX1111) no formato de billing_infos. Retorna vazio se o número é desconhecido.
+func MaskCardNumber(number string) string {
+    if len(number) < 4 {
+        return ""
+    }
+    return "XXXX XXXX XXXX " + number[len(number)-4:]
+}
+
+// UpdatePaymentMethodCard grava o cartão mascarado devolvido pelo gateway em
+// billing_infos (checkouts com Accept.js só o conhecem após a autorização)
+func (c *Connection) UpdatePaymentMethodCard(masterRef, accountNumber string) error {
+    maskedCard := MaskCardNumber(accountNumber)
+    if maskedCard == "" {
+        return fmt.Errorf("invalid account number for %s", masterRef)
+    }
+
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx,
+        "UPDATE billing_infos SET card = ? WHERE master_reference = ?",
+        maskedCard, masterRef)
+    if err != nil {
+        return fmt.Errorf("failed to update payment method card: %v", err)
+    }
+
+    return nil
+}
+
 func (t *Transaction) SaveMasterAccount(account *models.MasterAccount) error {
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
@@ -57,10 +93,10 @@ func (t *Transaction) SaveMasterAccount(account *models.MasterAccount) error {
             simultaneus_users, phone_number, mfa_is_enable,
             renew_date, created_at, total_price,
             reference_uuid, state, city, street,
-            zip_code, additional_info
+            zip_code, additional_info, currency
         ) VALUES (
             ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
-            1, ?, NOW(), ?, ?, ?, ?, ?, ?, ?
+            1, ?, NOW(), ?, ?, ?, ?, ?, ?, ?, ?
         )
     `
     
@@ -85,6 +121,7 @@ func (t *Transaction) SaveMasterAccount(account *models.MasterAccount) error {
         account.Street,
         account.ZipCode,
         account.AdditionalInfo,
+        accountCurrency(account),
     )
 
     if err != nil {
@@ -138,9 +175,9 @@ func (t *Transaction) SaveInvoice(invoice *models.Invoice) error {
 
     query := `
         INSERT INTO invoices (
-            master_reference, is_trial, total,
+            master_reference, is_trial, total, tax, currency,
             due_date, is_paid, created_at
-        ) VALUES (?, ?, ?, ?, ?, NOW())
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, NOW())
     `
     
     _, err := t.tx.ExecContext(
@@ -149,6 +186,8 @@ func (t *Transaction) SaveInvoice(invoice *models.Invoice) error {
         invoice.MasterReference,
         invoice.IsTrial,
         invoice.Total,
+        invoice.Tax,
+        invoiceCurrency(invoice),
         invoice.DueDate,
         invoice.IsPaid,
     )
@@ -162,17 +201,34 @@ func (t *Transaction) SaveInvoice(invoice *models.Invoice) error {
     return nil
 }
 
+// accountCurrency retorna a moeda da conta (USD se não informada)
+func accountCurrency(account *models.MasterAccount) string {
+    if account.Currency == "" {
+        return models.DefaultCurrency
+    }
+    return account.Currency
+}
+
+// invoiceCurrency retorna a moeda da fatura (USD se não informada)
+func invoiceCurrency(invoice *models.Invoice) string {
+    if invoice.Currency == "" {
+        return models.DefaultCurrency
+    }
+    return invoice.Currency
+}
+
 func (t *Transaction) SaveTransaction(masterRef string, checkoutID string, amount float64, status string, transactionID string) error {
     log.Printf("Attempting to save transaction: masterRef=%s, checkoutID=%s, amount=%.2f, status=%s, transactionID=%s", 
         masterRef, checkoutID, amount, status, transactionID)
 
+    // A moeda é a da conta (salva antes na mesma transação)
     query := `
         INSERT INTO transactions (
-            id, master_reference, checkout_id, amount, status, transaction_id, created_at
-        ) VALUES (UUID(), ?, ?, ?, ?, ?, NOW())
+            id, master_reference, checkout_id, amount, currency, status, transaction_id, created_at
+        ) VALUES (UUID(), ?, ?, ?, ` + accountCurrencySQL + `, ?, ?, NOW())
     `
     
-    _, err := t.tx.Exec(query, masterRef, checkoutID, amount, status, transactionID)
+    _, err := t.tx.Exec(query, masterRef, checkoutID, amount, masterRef, status, transactionID)
     if err != nil {
         log.Printf("Error saving transaction: %v", err)
         return fmt.Errorf("failed to save transaction: %v", err)
@@ -0,0 +1,408 @@
+// database/webhooks.go - Registro de notificações da Authorize.net
+//
+// Toda notificação autenticada entra na caixa de entrada (webhook_events) antes de ser
+// processada pelo worker; a event_key (ID da notificação ou hash do corpo) descarta as
+// entregas duplicadas. Notificações com assinatura ausente ou inválida ficam em
+// webhook_rejections.
+//
+// Esquema esperado:
+//
+//   CREATE TABLE webhook_events (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       event_key VARCHAR(128) NOT NULL,               -- notificationId ou sha256:<hash do corpo>
+//       source VARCHAR(32) NOT NULL,                   -- webhook_event, silent_post, relay_response, subscription_notification
+//       event_type VARCHAR(128) NOT NULL,
+//       payload MEDIUMTEXT NOT NULL,
+//       status VARCHAR(16) NOT NULL,                   -- pending, processing, processed, failed
+//       attempts INT NOT NULL DEFAULT 0,
+//       last_error TEXT NULL,
+//       received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       claimed_at DATETIME NULL,                      -- início do processamento em andamento
+//       processed_at TIMESTAMP NULL,
+//       updated_at TIMESTAMP NULL,
+//       UNIQUE KEY uniq_webhook_events_key (event_key),
+//       KEY idx_webhook_events_status (status, received_at)
+//   )
+//
+//   CREATE TABLE webhook_rejections (
+//       id BIGINT AUTO_INCREMENT PRIMARY KEY,
+//       source VARCHAR(32) NOT NULL,
+//       reason VARCHAR(255) NOT NULL,
+//       remote_addr VARCHAR(64) NULL,
+//       payload MEDIUMTEXT NULL,
+//       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+//       KEY idx_webhook_rejections_created (created_at)
+//   )
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+)
+
+// SaveWebhookRejection registra uma notificação rejeitada por assinatura ausente ou inválida
+func (c *Connection) SaveWebhookRejection(source, reason, remoteAddr, payload string) error {
+    if err := c.ensureConnection(); err != nil {
+        return fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    query := `
+        INSERT INTO webhook_rejections (source, reason, remote_addr, payload, created_at)
+        VALUES (?, ?, ?, ?, NOW())
+    `
+
+    if _, err := c.db.ExecContext(ctx, query, source, reason, remoteAddr, payload); err != nil {
+        log.Printf("Error saving webhook rejection: %v", err)
+        return fmt.Errorf("error saving webhook rejection: %v", err)
+    }
+
+    return nil
+}
+
+// UpdateTransactionStatus atualiza o status de uma transação pelo ID da Authorize.net
+func (c *Connection) UpdateTransactionStatus(transactionID, status string) (int64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx,
+        `UPDATE transactions SET status = ?, updated_at = NOW() WHERE transaction_id = ?`,
+        status, transactionID)
+    if err != nil {
+        return 0, fmt.Errorf("error updating transaction status: %v", err)
+    }
+
+    return result.RowsAffected()
+}
+
+// UpdateSubscriptionStatus atualiza o status de uma assinatura pelo ID da ARB
+func (c *Connection) UpdateSubscriptionStatus(subscriptionID, status string) (int64, error) {
+    if err := c.ensureConnection(); err != nil {
+        return 0, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx,
+        `UPDATE subscriptions SET status = ?, updated_at = NOW() WHERE subscription_id = ?`,
+        status, subscriptionID)
+    if err != nil {
+        return 0, fmt.Errorf("error updating subscription status: %v", err)
+    }
+
+    return result.RowsAffected()
+}
+
+// GetMasterUserByTransaction retorna email e username da conta master dona da transação
+func (c *Connection) GetMasterUserByTransaction(transactionID string) (string, string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var email, username string
+    err := c.db.QueryRowContext(ctx, `
+        SELECT ma.email, ma.username
+        FROM master_accounts ma
+        JOIN transactions t ON t.master_reference = ma.reference_uuid
+        WHERE t.transaction_id = ?
+        LIMIT 1`,
+        transactionID).Scan(&email, &username)
+    if err != nil {
+        return "", "", err
+    }
+
+    return email, username, nil
+}
+
+// GetMasterUserBySubscription retorna email e username da conta master dona da assinatura
+func (c *Connection) GetMasterUserBySubscription(subscriptionID string) (string, string, error) {
+    if err := c.ensureConnection(); err != nil {
+        return "", "", fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    var email, username string
+    err := c.db.QueryRowContext(ctx, `
+        SELECT ma.email, ma.username
+        FROM master_accounts ma
+        JOIN subscriptions s ON s.master_reference = ma.reference_uuid
+        WHERE s.subscription_id = ?
+        LIMIT 1`,
+        subscriptionID).Scan(&email, &username)
+    if err != nil {
+        return "", "", err
+    }
+
+    return email, username, nil
+}
+
+// WebhookEvent representa uma notificação da Authorize.net gravada na caixa de entrada (webhook_events)
+type WebhookEvent struct {
+    ID          int64      `json:"id"`
+    EventKey    string     `json:"event_key"`
+    Source      string     `json:"source"`
+    EventType   string     `json:"event_type"`
+    Payload     string     `json:"payload"`
+    Status      string     `json:"status"`
+    Attempts    int        `json:"attempts"`
+    LastError   string     `json:"last_error,omitempty"`
+    ReceivedAt  time.Time  `json:"received_at"`
+    ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// Status possíveis de um evento na caixa de entrada
+const (
+    WebhookEventPending    = "pending"
+    WebhookEventProcessing = "processing"
+    WebhookEventProcessed  = "processed"
+    WebhookEventFailed     = "failed"
+)
+
+// SaveWebhookEvent grava o evento na caixa de entrada de forma idempotente pela event_key.
+// Retorna o evento gravado e se ele foi inserido agora (false = entrega duplicada).
+func (c *Connection) SaveWebhookEvent(eventKey, source, eventType, payload string) (*WebhookEvent, bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        INSERT IGNORE INTO webhook_events (event_key, source, event_type, payload, status, attempts, received_at)
+        VALUES (?, ?, ?, ?, ?, 0, NOW())`,
+        eventKey, source, eventType, payload, WebhookEventPending)
+    if err != nil {
+        log.Printf("Error saving webhook event: %v", err)
+        return nil, false, fmt.Errorf("error saving webhook event: %v", err)
+    }
+
+    inserted, err := result.RowsAffected()
+    if err != nil {
+        return nil, false, fmt.Errorf("error getting rows affected: %v", err)
+    }
+
+    event, err := c.GetWebhookEventByKey(eventKey)
+    if err != nil {
+        return nil, false, err
+    }
+
+    return event, inserted > 0, nil
+}
+
+const webhookEventColumns = `id, event_key, source, event_type, payload, status, attempts,
+        COALESCE(last_error, ''), received_at, processed_at`
+
+func scanWebhookEvent(scanner interface{ Scan(...interface{}) error }) (*WebhookEvent, error) {
+    var event WebhookEvent
+    var processedAt sql.NullTime
+
+    err := scanner.Scan(
+        &event.ID, &event.EventKey, &event.Source, &event.EventType, &event.Payload,
+        &event.Status, &event.Attempts, &event.LastError, &event.ReceivedAt, &processedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    if processedAt.Valid {
+        event.ProcessedAt = &processedAt.Time
+    }
+
+    return &event, nil
+}
+
+// GetWebhookEvent busca um evento pelo ID
+func (c *Connection) GetWebhookEvent(id int64) (*WebhookEvent, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    row := c.db.QueryRowContext(ctx,
+        `SELECT `+webhookEventColumns+` FROM webhook_events WHERE id = ?`, id)
+
+    event, err := scanWebhookEvent(row)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("webhook event %d not found", id)
+        }
+        return nil, fmt.Errorf("error getting webhook event: %v", err)
+    }
+
+    return event, nil
+}
+
+// GetWebhookEventByKey busca um evento pela chave de idempotência
+func (c *Connection) GetWebhookEventByKey(eventKey string) (*WebhookEvent, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    row := c.db.QueryRowContext(ctx,
+        `SELECT `+webhookEventColumns+` FROM webhook_events WHERE event_key = ?`, eventKey)
+
+    event, err := scanWebhookEvent(row)
+    if err != nil {
+        return nil, fmt.Errorf("error getting webhook event by key: %v", err)
+    }
+
+    return event, nil
+}
+
+// WebhookEventClaimTimeout é o tempo depois do qual um evento em processamento é
+// considerado abandonado (worker morreu) e pode ser reivindicado de novo
+const WebhookEventClaimTimeout = 5 * time.Minute
+
+// ClaimWebhookEvent marca o evento como em processamento. Só um worker consegue
+// reivindicar o evento; retorna false se ele já foi processado ou está em andamento.
+// Eventos em processamento há mais de WebhookEventClaimTimeout são reivindicados de novo.
+func (c *Connection) ClaimWebhookEvent(id int64) (bool, error) {
+    if err := c.ensureConnection(); err != nil {
+        return false, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE webhook_events
+        SET status = ?, attempts = attempts + 1, claimed_at = NOW(), updated_at = NOW()
+        WHERE id = ? AND (status IN (?, ?)
+            OR (status = ? AND (claimed_at IS NULL OR claimed_at < NOW() - INTERVAL ? SECOND)))`,
+        WebhookEventProcessing, id, WebhookEventPending, WebhookEventFailed,
+        WebhookEventProcessing, int(WebhookEventClaimTimeout.Seconds()))
+    if err != nil {
+        return false, fmt.Errorf("error claiming webhook event: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return false, fmt.Errorf("error getting rows affected: %v", err)
+    }
+
+    return rows > 0, nil
+}
+
+// MarkWebhookEventProcessed finaliza o evento com sucesso
+func (c *Connection) MarkWebhookEventProcessed(id int64) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        UPDATE webhook_events
+        SET status = ?, last_error = NULL, processed_at = NOW(), updated_at = NOW()
+        WHERE id = ?`,
+        WebhookEventProcessed, id)
+    if err != nil {
+        return fmt.Errorf("error marking webhook event as processed: %v", err)
+    }
+
+    return nil
+}
+
+// MarkWebhookEventFailed registra a falha do processamento para nova tentativa
+func (c *Connection) MarkWebhookEventFailed(id int64, errorMsg string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    _, err := c.db.ExecContext(ctx, `
+        UPDATE webhook_events
+        SET status = ?, last_error = ?, updated_at = NOW()
+        WHERE id = ?`,
+        WebhookEventFailed, errorMsg, id)
+    if err != nil {
+        return fmt.Errorf("error marking webhook event as failed: %v", err)
+    }
+
+    return nil
+}
+
+// ResetWebhookEvent volta o evento para pending para ser reprocessado (replay administrativo)
+func (c *Connection) ResetWebhookEvent(id int64) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    result, err := c.db.ExecContext(ctx, `
+        UPDATE webhook_events
+        SET status = ?, processed_at = NULL, updated_at = NOW()
+        WHERE id = ?`,
+        WebhookEventPending, id)
+    if err != nil {
+        return fmt.Errorf("error resetting webhook event: %v", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("error getting rows affected: %v", err)
+    }
+
+    if rows == 0 {
+        return fmt.Errorf("webhook event %d not found", id)
+    }
+
+    return nil
+}
+
+// ListWebhookEvents lista eventos da caixa de entrada, com filtros opcionais por status e tipo
+func (c *Connection) ListWebhookEvents(status, eventType string, limit, offset int) ([]WebhookEvent, error) {
+    if err := c.ensureConnection(); err != nil {
+        return nil, fmt.Errorf("database connection check failed: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    query := `SELECT ` + webhookEventColumns + ` FROM webhook_events WHERE 1 = 1`
+    var args []interface{}
+
+    if status != "" {
+        query += ` AND status = ?`
+        args = append(args, status)
+    }
+    if eventType != "" {
+        query += ` AND event_type = ?`
+        args = append(args, eventType)
+    }
+
+    query += ` ORDER BY received_at DESC LIMIT ? OFFSET ?`
+    args = append(args, limit, offset)
+
+    rows, err := c.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("error listing webhook events: %v", err)
+    }
+    defer rows.Close()
+
+    var events []WebhookEvent
+    for rows.Next() {
+        event, err := scanWebhookEvent(rows)
+        if err != nil {
+            log.Printf("Error scanning webhook event: %v", err)
+            continue
+        }
+        events = append(events, *event)
+    }
+
+    return events, rows.Err()
+}
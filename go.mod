@@ -4,12 +4,13 @@ go 1.20
 
 require (
 	github.com/go-sql-driver/mysql v1.7.1
-	github.com/google/uuid v1.4.0
 	github.com/golang-jwt/jwt/v5 v5.2.0
+	github.com/google/uuid v1.4.0
 	github.com/gorilla/mux v1.8.1
 )
 
 require (
+	github.com/go-redis/redis/v8 v8.11.5
 	github.com/gorilla/sessions v1.2.1
 	github.com/joho/godotenv v1.5.1
 )
@@ -17,6 +18,5 @@ require (
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-redis/redis/v8 v8.11.5 // indirect
 	github.com/gorilla/securecookie v1.1.2 // indirect
 )
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+    "log"
+    "net/http"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/services/coupons"
+    "prosecure-payment-api/utils"
+)
+
+// addPlansCoupon é o desconto de um cupom na adição de planos. Fora do trial a cobrança
+// pro-rata conta como o primeiro ciclo do cupom; no trial nada é cobrado agora e todos
+// os ciclos ficam para as próximas cobranças.
+type addPlansCoupon struct {
+    coupon         *models.Coupon
+    chargeDiscount float64 // abatido do pro-rata cobrado agora
+    cycleDiscount  float64 // abatido em cada uma das próximas cobranças
+    futureCycles   int
+}
+
+// priceAddPlansCoupon valida o cupom para a conta e calcula o desconto dos planos adicionados
+func (h *AddPlansHandler) priceAddPlansCoupon(code string, account *models.MasterAccount, planCalculations []PlanCalculation, isTrial bool) (*addPlansCoupon, error) {
+    planIDs := make([]int, 0, len(planCalculations))
+    proRataLines := make([]models.CouponLine, 0, len(planCalculations))
+    cycleLines := make([]models.CouponLine, 0, len(planCalculations))
+    for _, calc := range planCalculations {
+        planIDs = append(planIDs, calc.PlanID)
+        proRataLines = append(proRataLines, models.CouponLine{PlanID: calc.PlanID, Amount: calc.TotalProRata})
+        cycleLines = append(cycleLines, models.CouponLine{PlanID: calc.PlanID, Amount: calc.TotalMonthly})
+    }
+
+    coupon, err := h.coupons.Check(code, account.Email, planIDs)
+    if err != nil {
+        return nil, err
+    }
+
+    priced := &addPlansCoupon{
+        coupon:        coupon,
+        cycleDiscount: coupon.Discount(cycleLines),
+        futureCycles:  coupon.Cycles,
+    }
+    if !isTrial {
+        priced.chargeDiscount = coupon.Discount(proRataLines)
+        priced.futureCycles--
+    }
+
+    return priced, nil
+}
+
+// redemption monta o resgate do cupom (os créditos são criados depois da cobrança)
+func (p *addPlansCoupon) redemption(account *models.MasterAccount) *database.CouponRedemption {
+    return &database.CouponRedemption{
+        CouponID:        p.coupon.ID,
+        MasterReference: account.ReferenceUUID,
+        Email:           account.Email,
+        Context:         models.CouponContextAddPlans,
+        DiscountAmount:  p.chargeDiscount,
+        TotalDiscount:   utils.Round(p.chargeDiscount + p.cycleDiscount*float64(p.futureCycles)),
+        Cycles:          p.coupon.Cycles,
+    }
+}
+
+// credits monta os créditos das próximas cobranças, a partir da data de renovação
+func (p *addPlansCoupon) credits(account *models.MasterAccount, isAnnualUser bool) []database.AccountCredit {
+    if p.futureCycles <= 0 || p.cycleDiscount <= 0 {
+        return nil
+    }
+
+    intervalMonths := 1
+    if isAnnualUser {
+        intervalMonths = 12
+    }
+
+    return database.CouponCredits(account.ReferenceUUID, p.cycleDiscount, p.futureCycles, account.RenewDate, intervalMonths)
+}
+
+// describe preenche os campos do cupom na resposta
+func (p *addPlansCoupon) describe(response *AddPlansResponse) {
+    response.CouponCode = p.coupon.Code
+    response.CouponDiscount = p.chargeDiscount
+    if p.futureCycles > 0 {
+        response.CouponCycleDiscount = p.cycleDiscount
+        response.CouponCycles = p.futureCycles
+    }
+}
+
+// sendCouponError responde a recusa do cupom (400) ou a falha ao validá-lo (500)
+func sendCouponError(w http.ResponseWriter, code string, err error) {
+    if coupons.IsRejection(err) {
+        utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    log.Printf("Error checking coupon %s: %v", code, err)
+    utils.SendErrorResponse(w, http.StatusInternalServerError, "Error validating coupon")
+}
+
+// releaseCouponRedemption desfaz o resgate reservado quando a cobrança não acontece
+func (h *AddPlansHandler) releaseCouponRedemption(redemption *database.CouponRedemption) {
+    if redemption == nil {
+        return
+    }
+    if err := h.db.ReleaseCouponRedemption(redemption); err != nil {
+        log.Printf("Error releasing coupon redemption %d: %v", redemption.ID, err)
+    }
+}
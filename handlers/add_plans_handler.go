@@ -11,8 +11,11 @@ import (
     "prosecure-payment-api/database"
     "prosecure-payment-api/middleware"
     "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/services/coupons"
     "prosecure-payment-api/services/payment"
     "prosecure-payment-api/services/email"
+    "prosecure-payment-api/services/tax"
     "prosecure-payment-api/utils"
 )
 
@@ -20,11 +23,16 @@ type AddPlansHandler struct {
     db             *database.Connection
     paymentService *payment.Service
     emailService   *email.SMTPService
+    coupons        *coupons.Service
+    queue          *queue.Queue
 }
 
 type AddPlansRequest struct {
     Cart []CartPlan `json:"cart"`
     CVV  string     `json:"cvv"`
+    // Nonce do Accept.js: cobra um novo cartão em vez de confirmar o CVV do perfil
+    OpaqueData *models.OpaqueData `json:"opaqueData,omitempty"`
+    CouponCode string             `json:"coupon_code,omitempty"`
 }
 
 type CartPlan struct {
@@ -54,6 +62,12 @@ type AddPlansResponse struct {
     UserType         string             `json:"user_type"`
     IsTrial          bool               `json:"is_trial"`
     TrialMessage     string             `json:"trial_message,omitempty"`
+    CouponCode          string          `json:"coupon_code,omitempty"`
+    CouponDiscount      float64         `json:"coupon_discount,omitempty"`       // abatido do pro-rata
+    CouponCycleDiscount float64         `json:"coupon_cycle_discount,omitempty"` // abatido em cada próxima cobrança
+    CouponCycles        int             `json:"coupon_cycles,omitempty"`
+    Tax                 float64         `json:"tax"`      // imposto incluído em ProRataCharged
+    TaxRate             float64         `json:"tax_rate"` // alíquota também aplicada às próximas cobranças
 }
 
 type PurchasedPlan struct {
@@ -65,11 +79,13 @@ type PurchasedPlan struct {
     IsMaster int    `json:"is_master"`
 }
 
-func NewAddPlansHandler(db *database.Connection, ps *payment.Service, es *email.SMTPService) *AddPlansHandler {
+func NewAddPlansHandler(db *database.Connection, ps *payment.Service, es *email.SMTPService, q *queue.Queue) *AddPlansHandler {
     return &AddPlansHandler{
         db:             db,
         paymentService: ps,
         emailService:   es,
+        coupons:        coupons.NewService(db),
+        queue:          q,
     }
 }
 
@@ -112,6 +128,10 @@ func (h *AddPlansHandler) PreviewAddPlans(w http.ResponseWriter, r *http.Request
         return
     }
 
+    if !h.subscriptionChangeAllowed(w, masterAccount.ReferenceUUID) {
+        return
+    }
+
     // CORREÇÃO: Verificar trial ANTES de calcular custos
     isTrial := masterAccount.IsTrial == 1
     log.Printf("PREVIEW DEBUG: User %s - IsTrial from DB: %d, isTrial bool: %t", 
@@ -126,13 +146,22 @@ func (h *AddPlansHandler) PreviewAddPlans(w http.ResponseWriter, r *http.Request
     }
 
     // Calcular custos sem processar pagamento
-    planCalculations, totalProRata, totalMonthlyIncrease, err := h.calculatePlansFromDatabase(req.Cart, isAnnualUser, masterAccount.RenewDate)
+    planCalculations, totalProRata, totalMonthlyIncrease, err := h.calculatePlansFromDatabase(req.Cart, isAnnualUser, masterAccount.RenewDate, masterAccount.Currency)
     if err != nil {
         log.Printf("Error calculating plans for user %s: %v", user.Username, err)
         utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
         return
     }
 
+    var coupon *addPlansCoupon
+    if req.CouponCode != "" {
+        coupon, err = h.priceAddPlansCoupon(req.CouponCode, masterAccount, planCalculations, isTrial)
+        if err != nil {
+            sendCouponError(w, req.CouponCode, err)
+            return
+        }
+    }
+
     userType := "monthly"
     if isTrial {
         userType = "trial"
@@ -162,6 +191,19 @@ func (h *AddPlansHandler) PreviewAddPlans(w http.ResponseWriter, r *http.Request
         log.Printf("PREVIEW DEBUG: Non-trial user %s - ProRataCharged: %.2f", user.Username, totalProRata)
     }
 
+    if coupon != nil {
+        coupon.describe(&response)
+        response.ProRataCharged = utils.Round(response.ProRataCharged - coupon.chargeDiscount)
+    }
+
+    // Imposto pelo endereço de cobrança, sobre o pro-rata já com desconto
+    rate := h.db.AccountTaxRate(masterAccount)
+    response.TaxRate = rate.Percent
+    if !isTrial {
+        response.Tax = rate.Amount(response.ProRataCharged)
+        response.ProRataCharged = utils.Round(response.ProRataCharged + response.Tax)
+    }
+
     utils.SendSuccessResponse(w, models.APIResponse{
         Status: "success",
         Data:   response,
@@ -201,6 +243,10 @@ func (h *AddPlansHandler) AddPlans(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if !h.subscriptionChangeAllowed(w, masterAccount.ReferenceUUID) {
+        return
+    }
+
     // 2. Verificar se é trial PRIMEIRO
     isTrial := masterAccount.IsTrial == 1
     log.Printf("ADD PLANS DEBUG: User %s - IsTrial from DB: %d, isTrial bool: %t", 
@@ -214,51 +260,96 @@ func (h *AddPlansHandler) AddPlans(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // 4. VALIDAÇÃO CVV: Só obrigatório para não-trial
-    if !isTrial && (req.CVV == "" || len(req.CVV) < 3 || len(req.CVV) > 4) {
+    // 4. VALIDAÇÃO CVV: Só obrigatório para não-trial sem nonce do Accept.js
+    if req.OpaqueData != nil && !req.OpaqueData.Valid() {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "opaqueData requires dataDescriptor and dataValue")
+        return
+    }
+    if !isTrial && req.OpaqueData == nil && (req.CVV == "" || len(req.CVV) < 3 || len(req.CVV) > 4) {
         log.Printf("CVV validation failed for non-trial user %s: CVV=%s", user.Username, req.CVV)
         utils.SendErrorResponse(w, http.StatusBadRequest, "Valid CVV is required")
         return
     }
 
     // 5. Buscar planos do banco de dados e calcular custos
-    planCalculations, totalProRata, totalMonthlyIncrease, err := h.calculatePlansFromDatabase(req.Cart, isAnnualUser, masterAccount.RenewDate)
+    planCalculations, totalProRata, totalMonthlyIncrease, err := h.calculatePlansFromDatabase(req.Cart, isAnnualUser, masterAccount.RenewDate, masterAccount.Currency)
     if err != nil {
         log.Printf("Error calculating plans: %v", err)
         utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
         return
     }
 
-    var transactionID string
-    var chargedAmount float64
+    if !isTrial && totalProRata <= 0 {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "No charges calculated")
+        return
+    }
 
-    // 6. COBRANÇA: Só para não-trial
-    if !isTrial {
-        if totalProRata <= 0 {
-            utils.SendErrorResponse(w, http.StatusBadRequest, "No charges calculated")
+    // Cupom: o resgate é reservado antes da cobrança (com os limites conferidos com o
+    // cupom travado) e desfeito se a cobrança falhar
+    var coupon *addPlansCoupon
+    var redemption *database.CouponRedemption
+    amountToCharge := totalProRata
+    if req.CouponCode != "" {
+        coupon, err = h.priceAddPlansCoupon(req.CouponCode, masterAccount, planCalculations, isTrial)
+        if err != nil {
+            sendCouponError(w, req.CouponCode, err)
             return
         }
 
-        log.Printf("Processing payment for non-trial user %s - Amount: %.2f", user.Username, totalProRata)
-
-        // Buscar Customer Profile
-        customerProfile, err := h.db.GetCustomerProfile(masterAccount.ReferenceUUID)
+        redemption = coupon.redemption(masterAccount)
+        redeemed, err := h.db.RedeemCoupon(redemption, nil)
         if err != nil {
-            log.Printf("Error getting customer profile: %v", err)
-            utils.SendErrorResponse(w, http.StatusNotFound, "Customer profile not found")
+            sendCouponError(w, req.CouponCode, err)
+            return
+        }
+        if !redeemed {
+            sendCouponError(w, req.CouponCode, coupons.ErrNoLongerUsable)
             return
         }
+        amountToCharge = utils.Round(totalProRata - coupon.chargeDiscount)
+    }
+
+    // Imposto pelo endereço de cobrança: vai na cobrança como item separado e entra
+    // também no novo valor da ARB
+    rate := h.db.AccountTaxRate(masterAccount)
+    var taxAmount float64
+    if !isTrial {
+        taxAmount = rate.Amount(amountToCharge)
+        amountToCharge = utils.Round(amountToCharge + taxAmount)
+    }
 
-        // Fazer cobrança pro-rata usando Customer Profile COM CVV
-        transactionID, err = h.chargeCustomerProfile(customerProfile.AuthorizeCustomerProfileID, 
-            customerProfile.AuthorizePaymentProfileID, totalProRata, masterAccount, req.CVV)
+    var transactionID string
+    var chargedAmount float64
+
+    // 6. COBRANÇA: Só para não-trial
+    if !isTrial {
+        log.Printf("Processing payment for non-trial user %s - Amount: %.2f", user.Username, amountToCharge)
+
+        if req.OpaqueData != nil {
+            // Cobrança pro-rata com o nonce do Accept.js
+            transactionID, err = h.paymentService.ChargeOpaqueData(req.OpaqueData, amountToCharge, taxAmount, masterAccount.Currency, "Additional Plans Charge")
+        } else {
+            // Buscar Customer Profile
+            customerProfile, profileErr := h.db.GetCustomerProfile(masterAccount.ReferenceUUID)
+            if profileErr != nil {
+                log.Printf("Error getting customer profile: %v", profileErr)
+                h.releaseCouponRedemption(redemption)
+                utils.SendErrorResponse(w, http.StatusNotFound, "Customer profile not found")
+                return
+            }
+
+            // Fazer cobrança pro-rata usando Customer Profile COM CVV
+            transactionID, err = h.chargeCustomerProfile(customerProfile.AuthorizeCustomerProfileID, 
+                customerProfile.AuthorizePaymentProfileID, amountToCharge, taxAmount, masterAccount, req.CVV)
+        }
         if err != nil {
             log.Printf("Error charging customer profile: %v", err)
+            h.releaseCouponRedemption(redemption)
             utils.SendErrorResponse(w, http.StatusPaymentRequired, fmt.Sprintf("Payment failed: %v", err))
             return
         }
-        chargedAmount = totalProRata
-        log.Printf("Payment successful for user %s - Amount: %.2f, Transaction: %s", user.Username, totalProRata, transactionID)
+        chargedAmount = amountToCharge
+        log.Printf("Payment successful for user %s - Amount: %.2f, Transaction: %s", user.Username, amountToCharge, transactionID)
     } else {
         // TRIAL: Sem cobrança
         transactionID = fmt.Sprintf("TRIAL-ADD-%d", time.Now().Unix())
@@ -267,17 +358,26 @@ func (h *AddPlansHandler) AddPlans(w http.ResponseWriter, r *http.Request) {
     }
 
     // 7. Atualizar dados no banco de dados
-    err = h.updateAccountWithNewPlans(masterAccount, req.Cart, planCalculations, totalMonthlyIncrease, transactionID, isAnnualUser, chargedAmount, isTrial)
+    err = h.updateAccountWithNewPlans(masterAccount, req.Cart, planCalculations, totalMonthlyIncrease, transactionID, isAnnualUser, chargedAmount, taxAmount, rate, isTrial)
     if err != nil {
         log.Printf("Error updating account: %v", err)
         utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update account")
         return
     }
 
+    // Créditos dos próximos ciclos do cupom, antes da ARB para o primeiro já sair abatido
+    if coupon != nil {
+        if credits := coupon.credits(masterAccount, isAnnualUser); len(credits) > 0 {
+            if err := h.db.CreateAccountCredits(credits); err != nil {
+                log.Printf("Error creating coupon credits for %s: %v", masterAccount.ReferenceUUID, err)
+            }
+        }
+    }
+
     // 8. Atualizar ARB subscription com novo valor (somente se não for trial)
     newMonthlyTotal := masterAccount.TotalPrice + totalMonthlyIncrease
     if !isTrial {
-        err = h.updateARBSubscription(masterAccount.ReferenceUUID, newMonthlyTotal)
+        err = h.updateARBSubscription(masterAccount.ReferenceUUID, newMonthlyTotal, rate)
         if err != nil {
             log.Printf("Warning: Failed to update ARB subscription: %v", err)
         }
@@ -302,6 +402,12 @@ func (h *AddPlansHandler) AddPlans(w http.ResponseWriter, r *http.Request) {
         PlanDetails:      planCalculations,
         UserType:         userType,
         IsTrial:          isTrial,
+        Tax:              taxAmount,
+        TaxRate:          rate.Percent,
+    }
+
+    if coupon != nil {
+        coupon.describe(&response)
     }
 
     if isTrial {
@@ -340,7 +446,7 @@ func (h *AddPlansHandler) isAnnualUser(purchasedPlansJSON string) (bool, error)
     return isAnnual, nil
 }
 
-func (h *AddPlansHandler) calculatePlansFromDatabase(cart []CartPlan, isAnnualUser bool, renewDate time.Time) ([]PlanCalculation, float64, float64, error) {
+func (h *AddPlansHandler) calculatePlansFromDatabase(cart []CartPlan, isAnnualUser bool, renewDate time.Time, currency string) ([]PlanCalculation, float64, float64, error) {
     var planCalculations []PlanCalculation
     var totalProRata, totalMonthlyIncrease float64
 
@@ -384,7 +490,11 @@ func (h *AddPlansHandler) calculatePlansFromDatabase(cart []CartPlan, isAnnualUs
             return nil, 0, 0, fmt.Errorf("plan %d not found", item.PlanID)
         }
 
-        basePrice := plan.Price
+        // Preço na moeda da conta
+        basePrice, err := h.db.PlanPrice(item.PlanID, currency, plan.Price)
+        if err != nil {
+            return nil, 0, 0, err
+        }
         var monthlyPrice, proRataPerUnit float64
 
         if isAnnualUser {
@@ -427,7 +537,7 @@ func (h *AddPlansHandler) getMasterAccountData(username, email string) (*models.
         SELECT reference_uuid, name, lname, email, username, phone_number,
                state, city, street, zip_code, additional_info, total_price,
                is_annually, plan, purchased_plans, simultaneus_users, renew_date,
-               COALESCE(is_trial, 0) as is_trial
+               COALESCE(is_trial, 0) as is_trial, COALESCE(currency, 'USD')
         FROM master_accounts 
         WHERE username = ? AND email = ?
     `
@@ -440,7 +550,7 @@ func (h *AddPlansHandler) getMasterAccountData(username, email string) (*models.
         &account.State, &account.City, &account.Street,
         &account.ZipCode, &account.AdditionalInfo, &account.TotalPrice,
         &account.IsAnnually, &account.Plan, &account.PurchasedPlans,
-        &account.SimultaneousUsers, &account.RenewDate, &isTrial,
+        &account.SimultaneousUsers, &account.RenewDate, &isTrial, &account.Currency,
     )
     
     if err != nil {
@@ -457,15 +567,31 @@ func (h *AddPlansHandler) getMasterAccountData(username, email string) (*models.
     return &account, nil
 }
 
+// subscriptionChangeAllowed recusa mudanças de plano quando a assinatura tem cancelamento
+// agendado ou está pausada (não há ARB cobrando no valor atual). Em caso de recusa já respondeu.
+func (h *AddPlansHandler) subscriptionChangeAllowed(w http.ResponseWriter, masterReference string) bool {
+    if _, err := h.db.GetScheduledCancellation(masterReference); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscription cancellation is scheduled, undo it before changing plans")
+        return false
+    }
+
+    if _, err := h.db.GetActivePause(masterReference); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscription is paused, resume it before changing plans")
+        return false
+    }
+
+    return true
+}
+
 // CORRIGIDO: Incluir CVV no método de cobrança
-func (h *AddPlansHandler) chargeCustomerProfile(customerProfileID, paymentProfileID string, amount float64, account *models.MasterAccount, cvv string) (string, error) {
+func (h *AddPlansHandler) chargeCustomerProfile(customerProfileID, paymentProfileID string, amount, taxAmount float64, account *models.MasterAccount, cvv string) (string, error) {
     log.Printf("Charging customer profile %s/%s amount: $%.2f with CVV validation", customerProfileID, paymentProfileID, amount)
 
     // CRÍTICO: Passar CVV para validação na Authorize.net
-    return h.paymentService.ChargeCustomerProfile(customerProfileID, paymentProfileID, amount, cvv)
+    return h.paymentService.ChargeCustomerProfile(customerProfileID, paymentProfileID, amount, taxAmount, account.Currency, cvv)
 }
 
-func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccount, cart []CartPlan, planCalculations []PlanCalculation, monthlyIncrease float64, transactionID string, isAnnualUser bool, chargedAmount float64, isTrial bool) error {
+func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccount, cart []CartPlan, planCalculations []PlanCalculation, monthlyIncrease float64, transactionID string, isAnnualUser bool, chargedAmount, chargedTax float64, rate tax.Rate, isTrial bool) error {
     tx, err := h.db.BeginTransaction()
     if err != nil {
         return fmt.Errorf("failed to begin transaction: %v", err)
@@ -541,9 +667,9 @@ func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccoun
     defer cancel()
     
     _, err = h.db.GetDB().ExecContext(ctx, `
-        INSERT INTO transactions (id, master_reference, checkout_id, amount, status, transaction_id, created_at)
-        VALUES (UUID(), ?, 'ADD_PLANS', ?, 'captured', ?, NOW())`,
-        account.ReferenceUUID, utils.Round(chargedAmount), transactionID)
+        INSERT INTO transactions (id, master_reference, checkout_id, amount, currency, status, transaction_id, created_at)
+        VALUES (UUID(), ?, 'ADD_PLANS', ?, ?, 'captured', ?, NOW())`,
+        account.ReferenceUUID, utils.Round(chargedAmount), account.Currency, transactionID)
     
     if err != nil {
         return fmt.Errorf("failed to save transaction: %v", err)
@@ -553,9 +679,9 @@ func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccoun
     if !isTrial && chargedAmount > 0 {
         // CRIAR INVOICE DO PRO-RATA (PAGA) - só para não-trial
         _, err = h.db.GetDB().ExecContext(ctx, `
-            INSERT INTO invoices (master_reference, is_trial, total, due_date, is_paid, created_at)
-            VALUES (?, 0, ?, NOW(), 1, NOW())`,
-            account.ReferenceUUID, utils.Round(chargedAmount))
+            INSERT INTO invoices (master_reference, is_trial, total, tax, currency, due_date, is_paid, created_at)
+            VALUES (?, 0, ?, ?, ?, NOW(), 1, NOW())`,
+            account.ReferenceUUID, utils.Round(chargedAmount), chargedTax, account.Currency)
         
         if err != nil {
             return fmt.Errorf("failed to create prorata invoice: %v", err)
@@ -568,6 +694,9 @@ func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccoun
         dueDate = time.Now().AddDate(1, 0, 0)
     }
 
+    // A fatura futura inclui o imposto sobre os planos adicionados
+    increaseTax := rate.Amount(monthlyIncrease)
+
     // Verificar se já existe invoice future pendente
     var existingInvoiceTotal float64
     var existingInvoiceID string
@@ -579,10 +708,10 @@ func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccoun
 
     if err == nil {
         // Atualizar invoice existente
-        newFutureTotal := existingInvoiceTotal + monthlyIncrease
+        newFutureTotal := existingInvoiceTotal + monthlyIncrease + increaseTax
         _, err = h.db.GetDB().ExecContext(ctx, `
-            UPDATE invoices SET total = ? WHERE id = ?`,
-            utils.Round(newFutureTotal), existingInvoiceID)
+            UPDATE invoices SET total = ?, tax = tax + ? WHERE id = ?`,
+            utils.Round(newFutureTotal), increaseTax, existingInvoiceID)
         
         if err != nil {
             return fmt.Errorf("failed to update future invoice: %v", err)
@@ -595,9 +724,9 @@ func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccoun
             isTrialFlag = 1
         }
         _, err = h.db.GetDB().ExecContext(ctx, `
-            INSERT INTO invoices (master_reference, is_trial, total, due_date, is_paid, created_at)
-            VALUES (?, ?, ?, ?, 0, NOW())`,
-            account.ReferenceUUID, isTrialFlag, utils.Round(monthlyIncrease), dueDate)
+            INSERT INTO invoices (master_reference, is_trial, total, tax, currency, due_date, is_paid, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, 0, NOW())`,
+            account.ReferenceUUID, isTrialFlag, utils.Round(monthlyIncrease+increaseTax), increaseTax, account.Currency, dueDate)
         
         if err != nil {
             return fmt.Errorf("failed to create future invoice: %v", err)
@@ -619,7 +748,7 @@ func (h *AddPlansHandler) updateAccountWithNewPlans(account *models.MasterAccoun
                 log.Printf("Trial addition email sent successfully to %s", account.Email)
             }
         } else if chargedAmount > 0 {
-            err := h.sendProRataInvoiceEmail(account, planCalculations, chargedAmount)
+            err := h.sendProRataInvoiceEmail(account, planCalculations, chargedAmount, chargedTax, rate)
             if err != nil {
                 log.Printf("Warning: Failed to send prorata invoice email: %v", err)
             } else {
@@ -648,11 +777,11 @@ func (h *AddPlansHandler) sendTrialAdditionEmail(account *models.MasterAccount,
             <tr>
                 <td>%s</td>
                 <td>%d</td>
-                <td>$%.2f</td>
+                <td>%s</td>
             </tr>`, 
             plan.PlanName,
             plan.Quantity,
-            plan.MonthlyPrice,
+            models.FormatMoney(plan.MonthlyPrice, account.Currency),
         )
     }
     plansTable += `</tbody></table>`
@@ -662,10 +791,10 @@ func (h *AddPlansHandler) sendTrialAdditionEmail(account *models.MasterAccount,
         <p>Hello %s!</p>
         <p>Great news! We've added new plans to your account at no cost since you're in your 30-day free trial period.</p>
         %s
-        <p><strong>Added to your monthly bill:</strong> $%.2f</p>
-        <p><strong>Immediate charge:</strong> $0.00 (Free trial benefit)</p>
+        <p><strong>Added to your monthly bill:</strong> %s</p>
+        <p><strong>Immediate charge:</strong> %s (Free trial benefit)</p>
         <p>Your billing will automatically begin after your trial expires. Enjoy exploring your new plans!</p>
-    `, account.Name, plansTable, monthlyIncrease)
+    `, account.Name, plansTable, models.FormatMoney(monthlyIncrease, account.Currency), models.FormatMoney(0, account.Currency))
 
     return h.emailService.SendEmail(
         account.Email,
@@ -674,7 +803,7 @@ func (h *AddPlansHandler) sendTrialAdditionEmail(account *models.MasterAccount,
     )
 }
 
-func (h *AddPlansHandler) sendProRataInvoiceEmail(account *models.MasterAccount, planCalculations []PlanCalculation, totalProRata float64) error {
+func (h *AddPlansHandler) sendProRataInvoiceEmail(account *models.MasterAccount, planCalculations []PlanCalculation, totalProRata, taxAmount float64, rate tax.Rate) error {
     // Gerar tabela de planos adicionados
     plansTable := `<table class="plans-table">
         <thead>
@@ -692,22 +821,29 @@ func (h *AddPlansHandler) sendProRataInvoiceEmail(account *models.MasterAccount,
             <tr>
                 <td>%s</td>
                 <td>%d</td>
-                <td>$%.2f</td>
-                <td>$%.2f</td>
+                <td>%s</td>
+                <td>%s</td>
             </tr>`, 
             plan.PlanName,
             plan.Quantity,
-            plan.ProRata,
-            plan.TotalProRata,
+            models.FormatMoney(plan.ProRata, account.Currency),
+            models.FormatMoney(plan.TotalProRata, account.Currency),
         )
     }
     plansTable += `</tbody></table>`
 
     // Seção de totais
     totalsSection := fmt.Sprintf(`
-        <p><strong>Pro-rata Amount:</strong> $%.2f</p>
-        <p style="font-size: 18px; font-weight: bold; color: #28a745;"><strong>Total Paid:</strong> $%.2f</p>
-    `, totalProRata, totalProRata)
+        <p><strong>Pro-rata Amount:</strong> %s</p>
+    `, models.FormatMoney(totalProRata-taxAmount, account.Currency))
+    if taxAmount > 0 {
+        totalsSection += fmt.Sprintf(`
+        <p><strong>Sales Tax (%.3g%%):</strong> %s</p>
+    `, rate.Percent, models.FormatMoney(taxAmount, account.Currency))
+    }
+    totalsSection += fmt.Sprintf(`
+        <p style="font-size: 18px; font-weight: bold; color: #28a745;"><strong>Total Paid:</strong> %s</p>
+    `, models.FormatMoney(totalProRata, account.Currency))
 
     footer := fmt.Sprintf(
         "Thank you %s for adding plans to your ProSecureLSP account. Your new services are now active.",
@@ -733,7 +869,7 @@ func (h *AddPlansHandler) sendProRataInvoiceEmail(account *models.MasterAccount,
     )
 }
 
-func (h *AddPlansHandler) updateARBSubscription(masterReference string, newMonthlyTotal float64) error {
+func (h *AddPlansHandler) updateARBSubscription(masterReference string, newMonthlyTotal float64, rate tax.Rate) error {
     // Buscar subscription ID
     var subscriptionID string
     err := h.db.GetDB().QueryRow(
@@ -744,6 +880,12 @@ func (h *AddPlansHandler) updateARBSubscription(masterReference string, newMonth
         return fmt.Errorf("subscription not found: %v", err)
     }
 
-    // Atualizar subscription na Authorize.net
-    return h.paymentService.UpdateSubscriptionAmount(subscriptionID, newMonthlyTotal)
+    // Crédito de conta agendado para o próximo ciclo continua abatido
+    credit, err := h.db.GetUpcomingCreditTotal(masterReference)
+    if err != nil {
+        return err
+    }
+
+    // Atualizar subscription na Authorize.net (a ARB não tem campo de imposto: vai no valor)
+    return h.paymentService.UpdateSubscriptionAmount(subscriptionID, rate.Gross(newMonthlyTotal-credit))
 }
\ No newline at end of file
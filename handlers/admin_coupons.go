@@ -0,0 +1,337 @@
+// handlers/admin_coupons.go - Cadastro de cupons/códigos promocionais e relatório de resgates
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/services/coupons"
+    "prosecure-payment-api/utils"
+)
+
+type AdminCouponHandler struct {
+    db *database.Connection
+}
+
+// NewAdminCouponHandler cria um novo handler de cupons
+func NewAdminCouponHandler(db *database.Connection) *AdminCouponHandler {
+    return &AdminCouponHandler{
+        db: db,
+    }
+}
+
+// CouponRequest cria ou altera um cupom. Na alteração o código é ignorado e IsActive
+// permite reativar um cupom desativado.
+type CouponRequest struct {
+    Code           string     `json:"code"`
+    Description    string     `json:"description"`
+    DiscountType   string     `json:"discount_type"`
+    DiscountValue  float64    `json:"discount_value"`
+    Cycles         int        `json:"cycles"`
+    PlanIDs        []int      `json:"plan_ids"`
+    ExpiresAt      *time.Time `json:"expires_at"`
+    MaxRedemptions int        `json:"max_redemptions"`
+    MaxPerCustomer int        `json:"max_per_customer"`
+    IsActive       *bool      `json:"is_active,omitempty"`
+}
+
+func (req *CouponRequest) apply(coupon *models.Coupon) {
+    coupon.Description = req.Description
+    coupon.DiscountType = req.DiscountType
+    coupon.DiscountValue = req.DiscountValue
+    coupon.Cycles = req.Cycles
+    if coupon.Cycles == 0 {
+        coupon.Cycles = 1
+    }
+    coupon.PlanIDs = req.PlanIDs
+    coupon.ExpiresAt = req.ExpiresAt
+    coupon.MaxRedemptions = req.MaxRedemptions
+    coupon.MaxPerCustomer = req.MaxPerCustomer
+    if req.IsActive != nil {
+        coupon.IsActive = *req.IsActive
+    }
+}
+
+// ListCoupons lista os cupons (?active=true só os ativos)
+func (h *AdminCouponHandler) ListCoupons(w http.ResponseWriter, r *http.Request) {
+    activeOnly := r.URL.Query().Get("active") == "true"
+
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    list, err := h.db.ListCoupons(activeOnly, limit, offset)
+    if err != nil {
+        log.Printf("Error listing coupons: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve coupons")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Coupons retrieved successfully",
+        Data: map[string]interface{}{
+            "coupons": list,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(list),
+            },
+        },
+    })
+}
+
+// CreateCoupon cadastra um cupom
+func (h *AdminCouponHandler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    var req CouponRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    coupon := &models.Coupon{
+        Code:      models.NormalizeCouponCode(req.Code),
+        IsActive:  true,
+        CreatedBy: user.Username,
+    }
+    req.apply(coupon)
+
+    if err := coupons.Validate(coupon); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if _, err := h.db.GetCouponByCode(coupon.Code); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "A coupon with this code already exists")
+        return
+    } else if err != sql.ErrNoRows {
+        log.Printf("Error checking coupon %s: %v", coupon.Code, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to create coupon")
+        return
+    }
+
+    if err := h.db.CreateCoupon(coupon); err != nil {
+        log.Printf("Error creating coupon %s: %v", coupon.Code, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to create coupon")
+        return
+    }
+
+    log.Printf("Coupon %s created by %s", coupon.Code, user.Username)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Coupon created successfully",
+        Data:    coupon,
+    })
+}
+
+// GetCoupon retorna um cupom (?id=) com o resumo de todos os seus resgates
+func (h *AdminCouponHandler) GetCoupon(w http.ResponseWriter, r *http.Request) {
+    coupon, ok := h.couponFromQuery(w, r)
+    if !ok {
+        return
+    }
+
+    stats, err := h.db.GetCouponRedemptionStats(coupon.ID, coupon.CreatedAt.AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+    if err != nil {
+        log.Printf("Error getting redemption stats of coupon %d: %v", coupon.ID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve coupon")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Coupon retrieved successfully",
+        Data: map[string]interface{}{
+            "coupon":      coupon,
+            "redemptions": stats,
+        },
+    })
+}
+
+// UpdateCoupon altera as regras de um cupom (?id=). Resgates já feitos não mudam.
+func (h *AdminCouponHandler) UpdateCoupon(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    coupon, ok := h.couponFromQuery(w, r)
+    if !ok {
+        return
+    }
+
+    var req CouponRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    req.apply(coupon)
+
+    if err := coupons.Validate(coupon); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if err := h.db.UpdateCoupon(coupon); err != nil {
+        log.Printf("Error updating coupon %d: %v", coupon.ID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update coupon")
+        return
+    }
+
+    log.Printf("Coupon %s updated by %s", coupon.Code, user.Username)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Coupon updated successfully",
+        Data:    coupon,
+    })
+}
+
+// DeactivateCoupon desativa um cupom (?id=). Checkouts com o cupom aplicado deixam de aceitá-lo.
+func (h *AdminCouponHandler) DeactivateCoupon(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    coupon, ok := h.couponFromQuery(w, r)
+    if !ok {
+        return
+    }
+
+    if err := h.db.DeactivateCoupon(coupon.ID); err != nil {
+        log.Printf("Error deactivating coupon %d: %v", coupon.ID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to deactivate coupon")
+        return
+    }
+
+    log.Printf("Coupon %s deactivated by %s", coupon.Code, user.Username)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Coupon deactivated successfully",
+    })
+}
+
+// ListRedemptions lista os resgates do período (?coupon_id= opcional) com o resumo
+func (h *AdminCouponHandler) ListRedemptions(w http.ResponseWriter, r *http.Request) {
+    var couponID int64
+    if idStr := r.URL.Query().Get("coupon_id"); idStr != "" {
+        parsed, err := strconv.ParseInt(idStr, 10, 64)
+        if err != nil || parsed <= 0 {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid coupon_id")
+            return
+        }
+        couponID = parsed
+    }
+
+    // Padrão: últimos 30 dias
+    to := time.Now().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+    from := to.AddDate(0, 0, -30)
+
+    if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+        parsed, err := time.Parse("2006-01-02", fromStr)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+            return
+        }
+        from = parsed
+    }
+
+    if toStr := r.URL.Query().Get("to"); toStr != "" {
+        parsed, err := time.Parse("2006-01-02", toStr)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+            return
+        }
+        to = parsed.AddDate(0, 0, 1)
+    }
+
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    redemptions, err := h.db.ListCouponRedemptions(couponID, from, to, limit, offset)
+    if err != nil {
+        log.Printf("Error listing coupon redemptions: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve coupon redemptions")
+        return
+    }
+
+    stats, err := h.db.GetCouponRedemptionStats(couponID, from, to)
+    if err != nil {
+        log.Printf("Error getting coupon redemption stats: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve coupon redemptions")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Coupon redemptions retrieved successfully",
+        Data: map[string]interface{}{
+            "from":        from.Format("2006-01-02"),
+            "to":          to.AddDate(0, 0, -1).Format("2006-01-02"),
+            "summary":     stats,
+            "redemptions": redemptions,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(redemptions),
+            },
+        },
+    })
+}
+
+// couponFromQuery busca o cupom do parâmetro ?id=. Em caso de erro já respondeu.
+func (h *AdminCouponHandler) couponFromQuery(w http.ResponseWriter, r *http.Request) (*models.Coupon, bool) {
+    couponID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+    if err != nil || couponID <= 0 {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Valid id parameter required")
+        return nil, false
+    }
+
+    coupon, err := h.db.GetCoupon(couponID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusNotFound, "Coupon not found")
+            return nil, false
+        }
+        log.Printf("Error getting coupon %d: %v", couponID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve coupon")
+        return nil, false
+    }
+
+    return coupon, true
+}
@@ -0,0 +1,139 @@
+// handlers/admin_dunning.go - Consulta dos casos de dunning (retentativas de cobrança recorrente)
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/utils"
+)
+
+type AdminDunningHandler struct {
+    db *database.Connection
+}
+
+// NewAdminDunningHandler cria um novo handler de casos de dunning
+func NewAdminDunningHandler(db *database.Connection) *AdminDunningHandler {
+    return &AdminDunningHandler{
+        db: db,
+    }
+}
+
+// RetryDunningRequest antecipa a retentativa do caso em andamento da conta
+type RetryDunningRequest struct {
+    MasterReference string `json:"master_reference"`
+}
+
+// ListDunningCases lista os casos de dunning, filtrando opcionalmente por status e conta
+func (h *AdminDunningHandler) ListDunningCases(w http.ResponseWriter, r *http.Request) {
+    status := r.URL.Query().Get("status")
+    switch status {
+    case "", database.DunningStatusOpen, database.DunningStatusSuspended, database.DunningStatusRecovered,
+        database.DunningStatusManualReview:
+    default:
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid status filter")
+        return
+    }
+    masterRef := r.URL.Query().Get("master_reference")
+
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    cases, err := h.db.ListDunningCases(status, masterRef, limit, offset)
+    if err != nil {
+        log.Printf("Error listing dunning cases: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve dunning cases")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Dunning cases retrieved successfully",
+        Data: map[string]interface{}{
+            "cases": cases,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(cases),
+            },
+        },
+    })
+}
+
+// GetDunningCase retorna um caso (?id=) com todas as tentativas de cobrança
+func (h *AdminDunningHandler) GetDunningCase(w http.ResponseWriter, r *http.Request) {
+    caseID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+    if err != nil || caseID <= 0 {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Valid id parameter required")
+        return
+    }
+
+    dc, err := h.db.GetDunningCase(caseID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusNotFound, "Dunning case not found")
+            return
+        }
+        log.Printf("Error getting dunning case %d: %v", caseID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve dunning case")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Dunning case retrieved successfully",
+        Data:    dc,
+    })
+}
+
+// RetryDunningCase agenda a retentativa do caso em andamento da conta para a próxima
+// execução do worker (ex.: depois de o cliente confirmar o cartão por telefone)
+func (h *AdminDunningHandler) RetryDunningCase(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    var req RetryDunningRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MasterReference == "" {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "master_reference is required")
+        return
+    }
+
+    scheduled, err := h.db.RequestDunningRetry(req.MasterReference)
+    if err != nil {
+        log.Printf("Error scheduling dunning retry for %s: %v", req.MasterReference, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to schedule dunning retry")
+        return
+    }
+
+    if !scheduled {
+        utils.SendErrorResponse(w, http.StatusNotFound, "No open dunning case for this account")
+        return
+    }
+
+    log.Printf("Admin %s scheduled a dunning retry for %s", user.Username, req.MasterReference)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Dunning retry scheduled",
+    })
+}
@@ -0,0 +1,106 @@
+// handlers/admin_jobs.go - Consulta do estado dos jobs da fila de processamento
+package handlers
+
+import (
+    "database/sql"
+    "log"
+    "net/http"
+    "strconv"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/utils"
+)
+
+type AdminJobHandler struct {
+    db *database.Connection
+}
+
+// NewAdminJobHandler cria um novo handler de consulta de jobs
+func NewAdminJobHandler(db *database.Connection) *AdminJobHandler {
+    return &AdminJobHandler{
+        db: db,
+    }
+}
+
+// ListJobs lista os jobs, filtrando opcionalmente por tipo, estado e checkout_id
+func (h *AdminJobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+    filter := database.JobRecordFilter{
+        Type:       r.URL.Query().Get("type"),
+        State:      r.URL.Query().Get("state"),
+        CheckoutID: r.URL.Query().Get("checkout_id"),
+    }
+
+    switch queue.JobState(filter.State) {
+    case "", queue.JobStateQueued, queue.JobStateDelayed, queue.JobStateProcessing,
+        queue.JobStateSucceeded, queue.JobStateFailed:
+    default:
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid state filter")
+        return
+    }
+
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    jobs, err := h.db.ListJobRecords(filter, limit, offset)
+    if err != nil {
+        log.Printf("Error listing jobs: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve jobs")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Jobs retrieved successfully",
+        Data: map[string]interface{}{
+            "jobs": jobs,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(jobs),
+            },
+        },
+    })
+}
+
+// GetJob retorna o estado de um job (?id=)
+func (h *AdminJobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+    jobID := r.URL.Query().Get("id")
+    if jobID == "" {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "id parameter required")
+        return
+    }
+
+    job, err := h.db.GetJobRecord(jobID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusNotFound, "Job not found")
+            return
+        }
+        log.Printf("Error getting job %s: %v", jobID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve job")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Job retrieved successfully",
+        Data:    job,
+    })
+}
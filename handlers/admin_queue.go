@@ -0,0 +1,353 @@
+// handlers/admin_queue.go - Gestão administrativa da fila de jobs e da fila de falhas
+//
+// Substitui os scripts monitor-queues.sh, retry-failed.sh e clear-queues.sh. Toda ação
+// que altera a fila de falhas fica registrada em queue_admin_actions.
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/utils"
+)
+
+type AdminQueueHandler struct {
+    db    *database.Connection
+    queue *queue.Queue
+}
+
+// NewAdminQueueHandler cria um novo handler administrativo da fila
+func NewAdminQueueHandler(db *database.Connection, q *queue.Queue) *AdminQueueHandler {
+    return &AdminQueueHandler{
+        db:    db,
+        queue: q,
+    }
+}
+
+// FailedJobsRequest seleciona jobs da fila de falhas: os IDs informados ou, com All, todos
+type FailedJobsRequest struct {
+    JobIDs []string `json:"job_ids"`
+    All    bool     `json:"all"`
+    Reason string   `json:"reason"`
+}
+
+// EditFailedJobRequest troca os dados de um job da fila de falhas
+type EditFailedJobRequest struct {
+    JobID  string                 `json:"job_id"`
+    Data   map[string]interface{} `json:"data"`
+    Reason string                 `json:"reason"`
+}
+
+// FailedJobResult é o resultado da ação em um job
+type FailedJobResult struct {
+    JobID string `json:"job_id"`
+    Done  bool   `json:"done"`
+    Error string `json:"error,omitempty"`
+}
+
+// GetQueueStats retorna a profundidade de cada lista da fila
+func (h *AdminQueueHandler) GetQueueStats(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+    defer cancel()
+
+    stats, err := h.queue.Stats(ctx)
+    if err != nil {
+        log.Printf("Error getting queue stats: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve queue stats")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Queue stats retrieved successfully",
+        Data:    stats,
+    })
+}
+
+// ListFailedJobs pagina a fila de falhas com o erro de cada job
+func (h *AdminQueueHandler) ListFailedJobs(w http.ResponseWriter, r *http.Request) {
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+    defer cancel()
+
+    jobs, total, err := h.queue.ListFailed(ctx, offset, limit)
+    if err != nil {
+        log.Printf("Error listing failed jobs: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve failed jobs")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Failed jobs retrieved successfully",
+        Data: map[string]interface{}{
+            "jobs": jobs,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(jobs),
+                "total":  total,
+            },
+        },
+    })
+}
+
+// selectFailedJobs resolve os IDs do pedido (todos os da fila de falhas com All)
+func (h *AdminQueueHandler) selectFailedJobs(ctx context.Context, req *FailedJobsRequest) ([]string, error) {
+    if req.All {
+        return h.queue.FailedJobIDs(ctx)
+    }
+    return req.JobIDs, nil
+}
+
+// RetryFailedJobs devolve para a fila principal os jobs selecionados da fila de falhas
+func (h *AdminQueueHandler) RetryFailedJobs(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    var req FailedJobsRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (!req.All && len(req.JobIDs) == 0) {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "job_ids or all is required")
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    jobIDs, err := h.selectFailedJobs(ctx, &req)
+    if err != nil {
+        log.Printf("Error listing failed jobs: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve failed jobs")
+        return
+    }
+
+    results := make([]FailedJobResult, 0, len(jobIDs))
+    retried := 0
+    for _, jobID := range jobIDs {
+        result := FailedJobResult{JobID: jobID}
+
+        job, err := h.queue.GetFailed(ctx, jobID)
+        if err == nil {
+            err = h.queue.RetryJob(ctx, jobID)
+        }
+        if err != nil {
+            // Chave com outro job: o job continua na fila de falhas e o motivo vai no resultado
+            if err != queue.ErrJobNotFound && !errors.Is(err, queue.ErrIdempotencyKeyHeld) {
+                log.Printf("Error retrying failed job %s: %v", jobID, err)
+            }
+            result.Error = err.Error()
+            results = append(results, result)
+            continue
+        }
+
+        result.Done = true
+        retried++
+        results = append(results, result)
+        h.audit(user.Username, database.QueueActionRetry, job, req.Reason, map[string]interface{}{
+            "data": job.Data,
+        })
+    }
+
+    log.Printf("Admin %s retried %d of %d failed jobs", user.Username, retried, len(jobIDs))
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Failed jobs queued for retry",
+        Data: map[string]interface{}{
+            "retried": retried,
+            "results": results,
+        },
+    })
+}
+
+// DiscardFailedJobs remove os jobs selecionados da fila de falhas sem processá-los
+func (h *AdminQueueHandler) DiscardFailedJobs(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    var req FailedJobsRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (!req.All && len(req.JobIDs) == 0) {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "job_ids or all is required")
+        return
+    }
+
+    req.Reason = strings.TrimSpace(req.Reason)
+    if req.Reason == "" {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "reason is required to discard jobs")
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+    defer cancel()
+
+    jobIDs, err := h.selectFailedJobs(ctx, &req)
+    if err != nil {
+        log.Printf("Error listing failed jobs: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve failed jobs")
+        return
+    }
+
+    results := make([]FailedJobResult, 0, len(jobIDs))
+    discarded := 0
+    for _, jobID := range jobIDs {
+        result := FailedJobResult{JobID: jobID}
+
+        job, err := h.queue.DiscardFailed(ctx, jobID)
+        if err != nil {
+            if err != queue.ErrJobNotFound {
+                log.Printf("Error discarding failed job %s: %v", jobID, err)
+            }
+            result.Error = err.Error()
+            results = append(results, result)
+            continue
+        }
+
+        result.Done = true
+        discarded++
+        results = append(results, result)
+        h.audit(user.Username, database.QueueActionDiscard, job, req.Reason, map[string]interface{}{
+            "data":        job.Data,
+            "retry_count": job.RetryCount,
+        })
+    }
+
+    log.Printf("Admin %s discarded %d of %d failed jobs (reason: %s)", user.Username, discarded, len(jobIDs), req.Reason)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Failed jobs discarded",
+        Data: map[string]interface{}{
+            "discarded": discarded,
+            "results":   results,
+        },
+    })
+}
+
+// EditFailedJob troca os dados de um job da fila de falhas antes do retry
+func (h *AdminQueueHandler) EditFailedJob(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    var req EditFailedJobRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JobID == "" || req.Data == nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "job_id and data are required")
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+    defer cancel()
+
+    previous, err := h.queue.UpdateFailedJobData(ctx, req.JobID, req.Data)
+    if err != nil {
+        switch err {
+        case queue.ErrJobNotFound:
+            utils.SendErrorResponse(w, http.StatusNotFound, "Job not found in failed queue")
+        case queue.ErrJobChanged:
+            utils.SendErrorResponse(w, http.StatusConflict, err.Error())
+        default:
+            log.Printf("Error editing failed job %s: %v", req.JobID, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit job")
+        }
+        return
+    }
+
+    h.audit(user.Username, database.QueueActionEdit, previous, req.Reason, map[string]interface{}{
+        "before": previous.Data,
+        "after":  req.Data,
+    })
+
+    log.Printf("Admin %s edited data of failed job %s (%s)", user.Username, previous.ID, previous.Type)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Failed job updated, retry it to process with the new data",
+        Data: map[string]interface{}{
+            "job_id": previous.ID,
+            "data":   req.Data,
+        },
+    })
+}
+
+// ListQueueActions lista a auditoria das ações na fila, opcionalmente de um job (?job_id=)
+func (h *AdminQueueHandler) ListQueueActions(w http.ResponseWriter, r *http.Request) {
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    actions, err := h.db.ListQueueAdminActions(r.URL.Query().Get("job_id"), limit, offset)
+    if err != nil {
+        log.Printf("Error listing queue admin actions: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve queue actions")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Queue actions retrieved successfully",
+        Data: map[string]interface{}{
+            "actions": actions,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(actions),
+            },
+        },
+    })
+}
+
+// audit registra a ação feita no job. A ação já aconteceu: uma falha aqui só é logada,
+// com os detalhes, para não se perder.
+func (h *AdminQueueHandler) audit(username, action string, job *queue.Job, reason string, details map[string]interface{}) {
+    detailsJSON, _ := json.Marshal(details)
+
+    err := h.db.SaveQueueAdminAction(&database.QueueAdminAction{
+        Action:      action,
+        JobID:       job.ID,
+        JobType:     string(job.Type),
+        Reason:      reason,
+        Details:     string(detailsJSON),
+        PerformedBy: username,
+    })
+    if err != nil {
+        log.Printf("CRITICAL: Queue action %s on job %s by %s not audited (reason: %s, details: %s): %v",
+            action, job.ID, username, reason, detailsJSON, err)
+    }
+}
@@ -0,0 +1,219 @@
+// handlers/admin_reconciliation.go - Relatórios de conciliação para o financeiro
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/csv"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/utils"
+)
+
+type AdminReconciliationHandler struct {
+    db    *database.Connection
+    queue *queue.Queue
+}
+
+// NewAdminReconciliationHandler cria um novo handler de relatórios de conciliação
+func NewAdminReconciliationHandler(db *database.Connection, q *queue.Queue) *AdminReconciliationHandler {
+    return &AdminReconciliationHandler{
+        db:    db,
+        queue: q,
+    }
+}
+
+// ListReports lista os relatórios de conciliação, mais recentes primeiro
+func (h *AdminReconciliationHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    reports, err := h.db.ListReconciliationReports(limit, offset)
+    if err != nil {
+        log.Printf("Error listing reconciliation reports: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve reconciliation reports")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Reconciliation reports retrieved successfully",
+        Data: map[string]interface{}{
+            "reports": reports,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(reports),
+            },
+        },
+    })
+}
+
+// GetReport retorna um relatório (por ?id= ou ?date=YYYY-MM-DD) com as divergências,
+// em JSON ou, com ?format=csv, como planilha
+func (h *AdminReconciliationHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+    var report *database.ReconciliationReport
+    var err error
+
+    if idStr := r.URL.Query().Get("id"); idStr != "" {
+        reportID, parseErr := strconv.ParseInt(idStr, 10, 64)
+        if parseErr != nil || reportID <= 0 {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Valid id parameter required")
+            return
+        }
+        report, err = h.db.GetReconciliationReport(reportID)
+    } else if date := r.URL.Query().Get("date"); date != "" {
+        if _, parseErr := time.Parse("2006-01-02", date); parseErr != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+            return
+        }
+        report, err = h.db.GetReconciliationReportByDate(date)
+    } else {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "id or date parameter required")
+        return
+    }
+
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusNotFound, "Reconciliation report not found")
+            return
+        }
+        log.Printf("Error getting reconciliation report: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve reconciliation report")
+        return
+    }
+
+    items, err := h.db.GetReconciliationItems(report.ID)
+    if err != nil {
+        log.Printf("Error getting reconciliation items for report %d: %v", report.ID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve reconciliation report")
+        return
+    }
+
+    if r.URL.Query().Get("format") == "csv" {
+        h.writeReportCSV(w, report, items)
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Reconciliation report retrieved successfully",
+        Data: map[string]interface{}{
+            "report":     report,
+            "mismatches": items,
+        },
+    })
+}
+
+func (h *AdminReconciliationHandler) writeReportCSV(w http.ResponseWriter, report *database.ReconciliationReport, items []database.ReconciliationItem) {
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition",
+        fmt.Sprintf("attachment; filename=\"reconciliation-%s.csv\"", report.ReportDate))
+
+    writer := csv.NewWriter(w)
+    writer.Write([]string{
+        "report_date", "batch_id", "transaction_id", "issue", "settled_status", "settled_amount",
+        "local_status", "local_amount", "submitted_at", "details",
+    })
+
+    for _, item := range items {
+        localAmount := ""
+        if item.LocalAmount != nil {
+            localAmount = fmt.Sprintf("%.2f", *item.LocalAmount)
+        }
+        writer.Write([]string{
+            report.ReportDate, item.BatchID, item.TransactionID, item.Issue, item.SettledStatus,
+            fmt.Sprintf("%.2f", item.SettledAmount), item.LocalStatus, localAmount, item.SubmittedAt, item.Details,
+        })
+    }
+
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        log.Printf("Error writing reconciliation CSV for report %d: %v", report.ID, err)
+    }
+}
+
+// RunReport (re)gera o relatório de um dia (?date=YYYY-MM-DD, padrão: ontem)
+func (h *AdminReconciliationHandler) RunReport(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    day := time.Now().UTC().AddDate(0, 0, -1)
+    if date := r.URL.Query().Get("date"); date != "" {
+        parsed, err := time.Parse("2006-01-02", date)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+            return
+        }
+        day = parsed
+    }
+
+    if !day.Before(time.Now().UTC().Truncate(24 * time.Hour)) {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Only past days can be reconciled")
+        return
+    }
+
+    report, isNew, err := h.db.CreateReconciliationReport(day)
+    if err != nil {
+        log.Printf("Error creating reconciliation report: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to create reconciliation report")
+        return
+    }
+
+    if !isNew {
+        reset, err := h.db.ResetReconciliationReport(report.ID)
+        if err != nil {
+            log.Printf("Error resetting reconciliation report %d: %v", report.ID, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reset reconciliation report")
+            return
+        }
+        if !reset {
+            utils.SendErrorResponse(w, http.StatusConflict, "Reconciliation report is currently being generated")
+            return
+        }
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+    defer cancel()
+
+    if err := h.queue.Enqueue(ctx, queue.JobTypeReconcileSettlement, map[string]interface{}{
+        "report_id": report.ID,
+    }); err != nil {
+        log.Printf("Error enqueueing reconciliation report %d: %v", report.ID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to enqueue reconciliation")
+        return
+    }
+
+    log.Printf("Admin %s queued reconciliation for %s (report %d)", user.Username, report.ReportDate, report.ID)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Reconciliation queued",
+        Data: map[string]interface{}{
+            "report_id":   report.ID,
+            "report_date": report.ReportDate,
+        },
+    })
+}
@@ -0,0 +1,224 @@
+// handlers/admin_refunds.go - Estornos totais e parciais de transações liquidadas
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/services/email"
+    "prosecure-payment-api/services/payment"
+    "prosecure-payment-api/utils"
+)
+
+type AdminRefundHandler struct {
+    db             *database.Connection
+    paymentService *payment.Service
+    emailService   *email.SMTPService
+}
+
+type RefundRequest struct {
+    TransactionID string  `json:"transaction_id"`
+    Amount        float64 `json:"amount"`         // Opcional: vazio/0 estorna o saldo restante
+    Reason        string  `json:"reason"`
+    CardLastFour  string  `json:"card_last_four"` // Opcional: padrão é o cartão em billing_infos
+}
+
+// NewAdminRefundHandler cria um novo handler administrativo de estornos
+func NewAdminRefundHandler(db *database.Connection, ps *payment.Service, es *email.SMTPService) *AdminRefundHandler {
+    return &AdminRefundHandler{
+        db:             db,
+        paymentService: ps,
+        emailService:   es,
+    }
+}
+
+// CreateRefund estorna total ou parcialmente uma transação capturada
+func (h *AdminRefundHandler) CreateRefund(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    var req RefundRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request format")
+        return
+    }
+
+    if req.TransactionID == "" {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "transaction_id is required")
+        return
+    }
+
+    if req.Amount < 0 {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid refund amount")
+        return
+    }
+
+    cardLastFour := req.CardLastFour
+    refund := &database.Refund{
+        OriginalTransactionID: req.TransactionID,
+        Amount:                req.Amount,
+        Reason:                req.Reason,
+        RequestedBy:           user.Username,
+    }
+
+    // O saldo é verificado e o estorno fica pendente antes da chamada ao gateway, com a
+    // transação travada: estornos simultâneos não passam juntos pelo saldo
+    original, err := h.db.ReserveRefund(refund)
+    if err != nil {
+        switch err {
+        case sql.ErrNoRows:
+            utils.SendErrorResponse(w, http.StatusNotFound, "Transaction not found")
+        case database.ErrTransactionNotRefundable:
+            utils.SendErrorResponse(w, http.StatusConflict,
+                fmt.Sprintf("Transaction cannot be refunded (status: %s)", original.Status))
+        case database.ErrRefundExceedsBalance:
+            utils.SendErrorResponse(w, http.StatusBadRequest,
+                fmt.Sprintf("Refund amount exceeds refundable balance of $%.2f", original.RemainingAmount()))
+        default:
+            log.Printf("Error recording refund for transaction %s: %v", req.TransactionID, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to record refund")
+        }
+        return
+    }
+
+    amount := refund.Amount
+    remaining := original.RemainingAmount()
+
+    if cardLastFour == "" {
+        cardLastFour, err = h.db.GetCardLastFour(original.MasterReference)
+        if err != nil {
+            log.Printf("Error getting card for refund of transaction %s: %v", req.TransactionID, err)
+            if failErr := h.db.FailRefund(refund.ID, "card on file not found"); failErr != nil {
+                log.Printf("Error marking refund %d as failed: %v", refund.ID, failErr)
+            }
+            utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "Card on file not found, card_last_four is required")
+            return
+        }
+    }
+
+    log.Printf("Admin %s refunding $%.2f of transaction %s (remaining: $%.2f, reason: %s)",
+        user.Username, amount, req.TransactionID, remaining, req.Reason)
+
+    refundTransID, err := h.paymentService.RefundTransaction(original.TransactionID, amount, cardLastFour)
+    if err != nil {
+        log.Printf("Refund %d for transaction %s failed: %v", refund.ID, req.TransactionID, err)
+        if failErr := h.db.FailRefund(refund.ID, err.Error()); failErr != nil {
+            log.Printf("Error marking refund %d as failed: %v", refund.ID, failErr)
+        }
+        utils.SendErrorResponse(w, http.StatusPaymentRequired, fmt.Sprintf("Refund failed: %v", err))
+        return
+    }
+
+    remaining, fullyRefunded, err := h.db.CompleteRefund(refund.ID, refundTransID, original.TransactionID)
+    if err != nil {
+        // O estorno já foi feito no gateway; não retornar erro para evitar estorno duplicado.
+        // O registro continua pendente e segue contando no saldo.
+        log.Printf("CRITICAL: Refund %s for transaction %s succeeded but was not recorded: %v",
+            refundTransID, req.TransactionID, err)
+        remaining = math.Round((original.RemainingAmount()-amount)*100) / 100
+        fullyRefunded = remaining <= 0
+    }
+
+    refund.RefundTransactionID = refundTransID
+    refund.Status = database.RefundStatusApproved
+
+    // Enviar recibo do estorno (assíncrono)
+    go func() {
+        if emailErr := h.sendRefundReceiptEmail(original, refund, cardLastFour); emailErr != nil {
+            log.Printf("Warning: Failed to send refund receipt for transaction %s: %v", original.TransactionID, emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Refund processed successfully",
+        Data: map[string]interface{}{
+            "refund":           refund,
+            "fully_refunded":   fullyRefunded,
+            "remaining_amount": remaining,
+        },
+    })
+}
+
+// ListRefunds lista os estornos, opcionalmente filtrando pela transação original
+func (h *AdminRefundHandler) ListRefunds(w http.ResponseWriter, r *http.Request) {
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    transactionID := r.URL.Query().Get("transaction_id")
+
+    refunds, err := h.db.ListRefunds(transactionID, limit, offset)
+    if err != nil {
+        log.Printf("Error listing refunds: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve refunds")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Refunds retrieved successfully",
+        Data: map[string]interface{}{
+            "refunds": refunds,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(refunds),
+            },
+        },
+    })
+}
+
+func (h *AdminRefundHandler) sendRefundReceiptEmail(original *database.RefundableTransaction, refund *database.Refund, cardLastFour string) error {
+    emailAddr, username, err := h.db.GetMasterUserByReference(original.MasterReference)
+    if err != nil {
+        return fmt.Errorf("error getting account for %s: %v", original.MasterReference, err)
+    }
+
+    subject := "Refund Receipt - ProSecureLSP"
+    body := fmt.Sprintf(`
+        <div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+            <img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+            <h1 style="color:#fff">Your Refund Has Been Processed</h1>
+            <div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+                Hi %s,<br><br>
+                We have issued a refund to your card ending in %s.<br><br>
+                <strong>Refund Amount:</strong> %s<br>
+                <strong>Original Charge:</strong> %s (Transaction %s)<br>
+                <strong>Refund Reference:</strong> %s<br>
+                <strong>Date:</strong> %s<br><br>
+                Refunds usually appear on your statement within 5-10 business days.
+            </div>
+            <a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+               href="https://prosecurelsp.com/users">
+                <strong>Login to Your Account</strong>
+            </a>
+        </div>
+    `, username, cardLastFour, models.FormatMoney(refund.Amount, original.Currency),
+        models.FormatMoney(original.Amount, original.Currency), original.TransactionID,
+        refund.RefundTransactionID, time.Now().Format("January 2, 2006"))
+
+    return h.emailService.SendEmail(emailAddr, subject, body)
+}
@@ -0,0 +1,132 @@
+// handlers/admin_webhook_events.go - Handler administrativo da caixa de entrada de webhooks
+package handlers
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/utils"
+)
+
+type AdminWebhookEventHandler struct {
+    db    *database.Connection
+    queue *queue.Queue
+}
+
+// NewAdminWebhookEventHandler cria um novo handler administrativo de eventos de webhook
+func NewAdminWebhookEventHandler(db *database.Connection, q *queue.Queue) *AdminWebhookEventHandler {
+    return &AdminWebhookEventHandler{
+        db:    db,
+        queue: q,
+    }
+}
+
+// ListWebhookEvents lista os eventos recebidos, com filtros opcionais por status e tipo
+func (h *AdminWebhookEventHandler) ListWebhookEvents(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    // Parâmetros de paginação
+    limitStr := r.URL.Query().Get("limit")
+    offsetStr := r.URL.Query().Get("offset")
+
+    limit := 50 // Padrão
+    if limitStr != "" {
+        if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 1000 {
+            limit = parsedLimit
+        }
+    }
+
+    offset := 0 // Padrão
+    if offsetStr != "" {
+        if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+            offset = parsedOffset
+        }
+    }
+
+    status := r.URL.Query().Get("status")
+    eventType := r.URL.Query().Get("event_type")
+
+    log.Printf("Admin %s listing webhook events (status: %s, type: %s, limit: %d, offset: %d)",
+        user.Username, status, eventType, limit, offset)
+
+    events, err := h.db.ListWebhookEvents(status, eventType, limit, offset)
+    if err != nil {
+        log.Printf("Error listing webhook events: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve webhook events")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Webhook events retrieved successfully",
+        Data: map[string]interface{}{
+            "events": events,
+            "pagination": map[string]interface{}{
+                "limit":  limit,
+                "offset": offset,
+                "count":  len(events),
+            },
+        },
+    })
+}
+
+// ReplayWebhookEvent volta um evento para pending e o enfileira novamente
+func (h *AdminWebhookEventHandler) ReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+
+    eventID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+    if err != nil || eventID <= 0 {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Valid id parameter required")
+        return
+    }
+
+    event, err := h.db.GetWebhookEvent(eventID)
+    if err != nil {
+        log.Printf("Error getting webhook event %d: %v", eventID, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Webhook event not found")
+        return
+    }
+
+    if event.Status == database.WebhookEventProcessing {
+        utils.SendErrorResponse(w, http.StatusConflict, "Webhook event is currently being processed")
+        return
+    }
+
+    if err := h.db.ResetWebhookEvent(eventID); err != nil {
+        log.Printf("Error resetting webhook event %d: %v", eventID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reset webhook event")
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+    defer cancel()
+
+    if err := h.queue.Enqueue(ctx, queue.JobTypeWebhookEvent, map[string]interface{}{
+        "event_id": event.ID,
+        "source":   event.Source,
+        "replay":   true,
+    }); err != nil {
+        log.Printf("Error enqueueing replay of webhook event %d: %v", eventID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to enqueue webhook event")
+        return
+    }
+
+    log.Printf("Admin %s replayed webhook event %d (%s, previous status: %s)",
+        user.Username, event.ID, event.EventType, event.Status)
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Webhook event queued for replay",
+        Data: map[string]interface{}{
+            "event_id":        event.ID,
+            "previous_status": event.Status,
+        },
+    })
+}
@@ -1,35 +1,131 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
-	"database/sql"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
 	
+	"prosecure-payment-api/config"
 	"prosecure-payment-api/database"
 	"prosecure-payment-api/models"
 	"prosecure-payment-api/queue"
+	"prosecure-payment-api/services/cardvault"
 	"prosecure-payment-api/services/payment"
+	"prosecure-payment-api/services/payment/authorizenet"
+	"prosecure-payment-api/services/webhook"
 	"prosecure-payment-api/utils"
 )
 
+// Limite de tamanho do corpo aceito nas notificações da Authorize.net
+const maxWebhookBodySize = 1 << 20
+
 type WebhookHandler struct {
 	db             *database.Connection
 	queue          *queue.Queue
 	paymentService *payment.Service
+	cardVault      *cardvault.Vault
+	authNet        config.AuthNetConfig
 }
 
-func NewWebhookHandler(db *database.Connection, q *queue.Queue, ps *payment.Service) *WebhookHandler {
+func NewWebhookHandler(db *database.Connection, q *queue.Queue, ps *payment.Service, cv *cardvault.Vault, cfg *config.Config) *WebhookHandler {
 	return &WebhookHandler{
 		db:             db,
 		queue:          q,
 		paymentService: ps,
+		cardVault:      cv,
+		authNet:        cfg.AuthNet,
+	}
+}
+
+// authenticateNotification valida a assinatura de uma notificação da Authorize.net.
+// Eventos da Webhooks API trazem X-ANET-Signature; Silent Post / Relay Response trazem
+// x_SHA2_Hash (ou x_MD5_Hash legado). Notificações sem assinatura ou adulteradas são
+// registradas em webhook_rejections e recebem 401.
+func (h *WebhookHandler) authenticateNotification(w http.ResponseWriter, r *http.Request, source string) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		log.Printf("Error reading %s notification body: %v", source, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
 	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var verifyErr error
+	if signature := r.Header.Get(authorizenet.WebhookSignatureHeader); signature != "" {
+		verifyErr = authorizenet.VerifyWebhookSignature(h.authNet.SignatureKey, body, signature)
+	} else {
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Error parsing %s notification form: %v", source, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, false
+		}
+		verifyErr = authorizenet.VerifySilentPostHash(h.authNet.SignatureKey, h.authNet.MD5HashValue, h.authNet.APILoginID, r.PostForm)
+	}
+
+	if verifyErr != nil {
+		log.Printf("SECURITY: Rejected %s notification from %s: %v", source, r.RemoteAddr, verifyErr)
+		if err := h.db.SaveWebhookRejection(source, verifyErr.Error(), r.RemoteAddr, string(body)); err != nil {
+			log.Printf("Error recording rejected %s notification: %v", source, err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// recordNotification grava a notificação na caixa de entrada (webhook_events) e enfileira
+// o processamento. Entregas duplicadas (mesma event_key) de eventos já processados não
+// são enfileiradas de novo; as de eventos em processamento são, para o caso de o worker
+// ter morrido (a chave de idempotência evita job duplicado enquanto o atual existir).
+// Retorna false se a notificação não pôde ser persistida, para que a Authorize.net tente
+// entregar novamente.
+func (h *WebhookHandler) recordNotification(source, eventKey, eventType string, payload []byte) bool {
+	if eventKey == "" {
+		sum := sha256.Sum256(payload)
+		eventKey = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	event, isNew, err := h.db.SaveWebhookEvent(eventKey, source, eventType, string(payload))
+	if err != nil {
+		log.Printf("Error recording %s notification: %v", source, err)
+		return false
+	}
+
+	if !isNew {
+		log.Printf("Duplicate %s notification %s (event %d, status=%s)", source, eventKey, event.ID, event.Status)
+		if event.Status == database.WebhookEventProcessed {
+			return true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.queue.Enqueue(ctx, queue.JobTypeWebhookEvent, map[string]interface{}{
+		"event_id": event.ID,
+		"source":   source,
+	}, queue.WithIdempotencyKey(fmt.Sprintf("webhook_event:%d", event.ID))); err != nil {
+		log.Printf("Error enqueueing webhook event %d: %v", event.ID, err)
+		return false
+	}
+
+	return true
 }
 
 // HandleSilentPost processa as notificações da Authorize.net via Silent Post
 func (h *WebhookHandler) HandleSilentPost(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.authenticateNotification(w, r, webhook.SourceSilentPost)
+	if !ok {
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
 		log.Printf("Error parsing silent post form: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -51,15 +147,22 @@ func (h *WebhookHandler) HandleSilentPost(w http.ResponseWriter, r *http.Request
 	log.Printf("Received Silent Post notification: tx_id=%s, code=%s, reason=%s, text=%s, invoice=%s, amount=%s, checkout_id=%s",
 		transactionID, responseCode, responseReasonCode, responseReasonText, invoiceNum, amount, checkoutID)
 
-	// Enviar uma resposta 200 OK imediatamente
+	// Gravar na caixa de entrada antes de confirmar o recebimento
+	if !h.recordNotification(webhook.SourceSilentPost, "", "silent_post", body) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	
-	// Processar o resultado em background
-	go h.processNotification(transactionID, responseCode, checkoutID)
 }
 
 // HandleRelayResponse processa os redirecionamentos da Authorize.net via Relay Response
 func (h *WebhookHandler) HandleRelayResponse(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.authenticateNotification(w, r, webhook.SourceRelayResponse)
+	if !ok {
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
 		log.Printf("Error parsing relay response form: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -77,6 +180,11 @@ func (h *WebhookHandler) HandleRelayResponse(w http.ResponseWriter, r *http.Requ
 	log.Printf("Received Relay Response for transaction %s: code=%s, checkout_id=%s", 
 	    transactionID, responseCode, checkoutID)
 
+	// Gravar na caixa de entrada; o cliente é redirecionado mesmo se a gravação falhar
+	if !h.recordNotification(webhook.SourceRelayResponse, "", "relay_response", body) {
+		log.Printf("Warning: Relay Response for transaction %s was not recorded", transactionID)
+	}
+
 	// Responder com HTML que será exibido ao cliente (pode redirecionar para sua página de sucesso/erro)
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(`
@@ -91,13 +199,15 @@ func (h *WebhookHandler) HandleRelayResponse(w http.ResponseWriter, r *http.Requ
 		</body>
 		</html>
 	`))
-	
-	// Processar o resultado em background
-	go h.processNotification(transactionID, responseCode, checkoutID)
 }
 
 // HandleSubscriptionNotification processa notificações relacionadas a assinaturas
 func (h *WebhookHandler) HandleSubscriptionNotification(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.authenticateNotification(w, r, webhook.SourceSubscriptionNotification)
+	if !ok {
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
 		log.Printf("Error parsing subscription notification form: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -114,234 +224,13 @@ func (h *WebhookHandler) HandleSubscriptionNotification(w http.ResponseWriter, r
 	log.Printf("Received subscription notification for subscription %s: event=%s", 
 		subscriptionID, eventType)
 	
-	// Responder imediatamente com 200 OK
-	w.WriteHeader(http.StatusOK)
-	
-	// Processar a notificação de assinatura em background
-	go h.processSubscriptionNotification(subscriptionID, eventType)
-}
-
-// processNotification processa as notificações e enfileira jobs conforme necessário
-func (h *WebhookHandler) processNotification(transactionID, responseCode, checkoutID string) {
-	// Apenas processa se a transação foi aprovada (código 1)
-	if responseCode != "1" {
-		log.Printf("Transaction %s not approved (response code %s). No background jobs will be queued.", 
-			transactionID, responseCode)
-		return
-	}
-
-	// Criar um contexto com timeout para as operações
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Se o checkoutID não foi fornecido, tenta buscar do banco de dados
-	if checkoutID == "" {
-		err := h.db.GetDB().QueryRowContext(ctx, 
-			"SELECT checkout_id FROM transactions WHERE transaction_id = ? LIMIT 1", 
-			transactionID).Scan(&checkoutID)
-		
-		if err != nil {
-			if err == sql.ErrNoRows {
-				log.Printf("Transaction %s not found in database", transactionID)
-			} else {
-				log.Printf("Error finding checkout ID for transaction %s: %v", transactionID, err)
-			}
-			return
-		}
-	}
-
-	// Verificar se a transação já existe no banco de dados
-	var status string
-	var exists bool
-	err := h.db.GetDB().QueryRowContext(ctx, 
-		"SELECT EXISTS(SELECT 1 FROM transactions WHERE transaction_id = ?)", 
-		transactionID).Scan(&exists)
-	
-	if err != nil {
-		log.Printf("Error checking transaction existence: %v", err)
+	// Gravar na caixa de entrada antes de confirmar o recebimento
+	if !h.recordNotification(webhook.SourceSubscriptionNotification, "", eventType, body) {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	
-	if !exists {
-		log.Printf("Transaction %s not found in database. Adding as new transaction.", transactionID)
-		
-		// Se a transação não existir no banco, buscar as informações do checkout
-		checkout, err := h.db.GetCheckoutData(checkoutID)
-		if err != nil {
-			log.Printf("Error retrieving checkout data for ID %s: %v", checkoutID, err)
-			return
-		}
-		
-		// Buscar o master_reference associado ao checkout (se existir)
-		var masterRef string
-		err = h.db.GetDB().QueryRowContext(ctx, 
-			`SELECT reference_uuid FROM master_accounts 
-			 WHERE username = ? AND email = ? LIMIT 1`,
-			checkout.Username, checkout.Email).Scan(&masterRef)
-		
-		if err != nil {
-			if err == sql.ErrNoRows {
-				log.Printf("No master account found for checkout %s", checkoutID)
-				return
-			}
-			log.Printf("Error retrieving master reference: %v", err)
-			return
-		}
-		
-		// Registrar a transação no banco de dados
-		_, err = h.db.GetDB().ExecContext(ctx,
-			`INSERT INTO transactions (id, master_reference, checkout_id, amount, status, transaction_id, created_at)
-			 VALUES (UUID(), ?, ?, 1.00, 'authorized', ?, NOW())`,
-			masterRef, checkoutID, transactionID)
-			
-		if err != nil {
-			log.Printf("Error registering transaction in database: %v", err)
-			return
-		}
-		
-		log.Printf("Successfully registered transaction %s for checkout %s", transactionID, checkoutID)
-	} else {
-		// Se a transação já existe, verificar o status
-		err := h.db.GetDB().QueryRowContext(ctx, 
-			"SELECT status FROM transactions WHERE transaction_id = ?", 
-			transactionID).Scan(&status)
-		
-		if err != nil {
-			log.Printf("Error checking transaction status: %v", err)
-			return
-		}
-		
-		// Se a transação já foi processada (void ou falha), não processa novamente
-		if status != "authorized" {
-			log.Printf("Transaction %s has already been processed (status=%s). Skipping.", 
-				transactionID, status)
-			return
-		}
-	}
-
-	log.Printf("Processing notification for transaction %s, checkout %s", transactionID, checkoutID)
-	
-	// Enfileirar job para anular a transação (void)
-	err = h.queue.Enqueue(ctx, queue.JobTypeVoidTransaction, map[string]interface{}{
-		"transaction_id": transactionID,
-		"checkout_id":    checkoutID,
-	})
-	
-	if err != nil {
-		log.Printf("Error enqueueing void transaction job: %v", err)
-	} else {
-		log.Printf("Successfully queued void job for transaction %s", transactionID)
-	}
-
-	// Buscar os dados necessários para configurar a assinatura recorrente
-	checkout, err := h.db.GetCheckoutData(checkoutID)
-	if err != nil {
-		log.Printf("Error retrieving checkout data for ID %s: %v", checkoutID, err)
-		return
-	}
-	
-	// Buscar informações de pagamento salvas temporariamente
-	var cardNumber, cardExpiry, cardCVV, cardName string
-	err = h.db.GetDB().QueryRowContext(ctx, 
-		`SELECT card_number, card_expiry, card_cvv, card_name 
-		 FROM temp_payment_data 
-		 WHERE checkout_id = ? 
-		 AND created_at > NOW() - INTERVAL 1 HOUR`,
-		checkoutID).Scan(&cardNumber, &cardExpiry, &cardCVV, &cardName)
-	
-	if err != nil {
-		log.Printf("Error retrieving payment data for checkout %s: %v", checkoutID, err)
-		return
-	}
-	
-	// Enfileirar job para configurar assinatura recorrente com todos os dados necessários
-	err = h.queue.Enqueue(ctx, queue.JobTypeCreateSubscription, map[string]interface{}{
-		"checkout_id":    checkoutID,
-		"transaction_id": transactionID,
-		"card_number":    cardNumber,
-		"card_expiry":    cardExpiry,
-		"card_cvv":       cardCVV,
-		"card_name":      cardName,
-		"email":          checkout.Email,
-	})
-	
-	if err != nil {
-		log.Printf("Error enqueueing subscription job: %v", err)
-	} else {
-		log.Printf("Successfully queued subscription job for checkout %s", checkoutID)
-	}
-	
-	// Limpar dados de pagamento temporários após processamento
-	_, err = h.db.GetDB().ExecContext(ctx,
-		"DELETE FROM temp_payment_data WHERE checkout_id = ?",
-		checkoutID)
-	
-	if err != nil {
-		log.Printf("Warning: Failed to clean up temporary payment data: %v", err)
-	}
-}
 
-// processSubscriptionNotification processa notificações relacionadas a assinaturas
-func (h *WebhookHandler) processSubscriptionNotification(subscriptionID, eventType string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	log.Printf("Processing subscription notification for %s: %s", subscriptionID, eventType)
-	
-	// Atualizar o status da assinatura no banco de dados
-	var status string
-	switch eventType {
-	case "subscription_cancelled":
-		status = "cancelled"
-	case "subscription_suspended":
-		status = "suspended"
-	case "subscription_terminated":
-		status = "terminated"
-	case "subscription_expired":
-		status = "expired"
-	case "subscription_failed":
-		status = "failed"
-	case "subscription_successful":
-		status = "active"
-	default:
-		log.Printf("Unknown subscription event type: %s", eventType)
-		return
-	}
-	
-	// Atualizar o status no banco de dados
-	_, err := h.db.GetDB().ExecContext(ctx,
-		`UPDATE subscriptions 
-		 SET status = ?, updated_at = NOW() 
-		 WHERE subscription_id = ?`,
-		status, subscriptionID)
-	
-	if err != nil {
-		log.Printf("Error updating subscription status: %v", err)
-		return
-	}
-	
-	log.Printf("Successfully updated subscription %s status to %s", subscriptionID, status)
-	
-	// Para falhas de pagamento, podemos registrar e possivelmente notificar o cliente
-	if eventType == "subscription_failed" {
-		log.Printf("Subscription payment failed for subscription %s. Consider sending a notification.", subscriptionID)
-		
-		// Buscar email do usuário para envio de notificação
-		var email string
-		err := h.db.GetDB().QueryRowContext(ctx, 
-			`SELECT ma.email FROM master_accounts ma 
-			 JOIN subscriptions s ON s.master_reference = ma.reference_uuid 
-			 WHERE s.subscription_id = ?`, 
-			subscriptionID).Scan(&email)
-		
-		if err != nil {
-			log.Printf("Error retrieving email for subscription %s: %v", subscriptionID, err)
-			return
-		}
-		
-		// Aqui poderia ser implementado o envio de email de notificação
-		log.Printf("Should send notification email to %s regarding subscription failure", email)
-	}
+	w.WriteHeader(http.StatusOK)
 }
 
 // StoreTemporaryPaymentData armazena temporariamente os dados do cartão de forma segura
@@ -364,21 +253,15 @@ func (h *WebhookHandler) StoreTemporaryPaymentData(w http.ResponseWriter, r *htt
 		return
 	}
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	// Inserir ou atualizar os dados de pagamento temporários
-	_, err := h.db.GetDB().ExecContext(ctx,
-		`INSERT INTO temp_payment_data
-		 (checkout_id, card_number, card_expiry, card_cvv, card_name, created_at)
-		 VALUES (?, ?, ?, ?, ?, NOW())
-		 ON DUPLICATE KEY UPDATE
-		 card_number = VALUES(card_number),
-		 card_expiry = VALUES(card_expiry),
-		 card_cvv = VALUES(card_cvv),
-		 card_name = VALUES(card_name),
-		 created_at = NOW()`,
-		checkoutID, cardNumber, cardExpiry, cardCVV, cardName)
+	// Inserir ou atualizar os dados de pagamento temporários (cifrados). A limpeza
+	// fica a cargo do sweeper do worker, que apaga as linhas após o TTL.
+	err := h.cardVault.Store(&models.PaymentDataStorage{
+		CheckoutID: checkoutID,
+		CardNumber: cardNumber,
+		CardExpiry: cardExpiry,
+		CardCVV:    cardCVV,
+		CardName:   cardName,
+	})
 	
 	if err != nil {
 		log.Printf("Error storing temporary payment data: %v", err)
@@ -386,21 +269,6 @@ func (h *WebhookHandler) StoreTemporaryPaymentData(w http.ResponseWriter, r *htt
 		return
 	}
 	
-	// Configurar uma limpeza programada após algumas horas por segurança
-	go func() {
-		time.Sleep(1 * time.Hour)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		
-		_, err := h.db.GetDB().ExecContext(ctx,
-			"DELETE FROM temp_payment_data WHERE checkout_id = ?",
-			checkoutID)
-		
-		if err != nil {
-			log.Printf("Error cleaning up temporary payment data: %v", err)
-		}
-	}()
-	
 	utils.SendSuccessResponse(w, models.APIResponse{
 		Status:  "success",
 		Message: "Payment data stored temporarily",
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"prosecure-payment-api/services/payment/authorizenet"
+	"prosecure-payment-api/services/webhook"
+)
+
+// HandleWebhookEvent recebe eventos JSON da Webhooks API da Authorize.net (net.authorize.*)
+func (h *WebhookHandler) HandleWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.authenticateNotification(w, r, webhook.SourceWebhookEvent)
+	if !ok {
+		return
+	}
+
+	var event authorizenet.WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Printf("Error decoding webhook event: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Received webhook event %s: type=%s, entity=%s, id=%s",
+		event.NotificationID, event.EventType, event.Payload.EntityName, event.Payload.ID)
+
+	// notificationId identifica o evento entre reentregas da Authorize.net
+	var eventKey string
+	if event.NotificationID != "" {
+		eventKey = "notification:" + event.NotificationID
+	}
+
+	// Gravar na caixa de entrada antes de confirmar o recebimento
+	if !h.recordNotification(webhook.SourceWebhookEvent, eventKey, event.EventType, body) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
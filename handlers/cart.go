@@ -15,6 +15,9 @@ import (
     "prosecure-payment-api/database"
     "prosecure-payment-api/models"
     "prosecure-payment-api/config"
+    "prosecure-payment-api/services/coupons"
+    "prosecure-payment-api/services/tax"
+    "prosecure-payment-api/utils"
 )
 
 func init() {
@@ -22,8 +25,9 @@ func init() {
 }
 
 type CartHandler struct {
-    db    *database.Connection
-    store *sessions.CookieStore
+    db      *database.Connection
+    store   *sessions.CookieStore
+    coupons *coupons.Service
 }
 
 func NewCartHandler(db *database.Connection, cfg *config.Config) *CartHandler {
@@ -36,7 +40,7 @@ func NewCartHandler(db *database.Connection, cfg *config.Config) *CartHandler {
         HttpOnly: true,                  
         SameSite: http.SameSiteLaxMode, 
     }
-    return &CartHandler{db: db, store: store}
+    return &CartHandler{db: db, store: store, coupons: coupons.NewService(db)}
 }
 
 func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
@@ -194,7 +198,23 @@ func (h *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
 
     log.Printf("Cart contents: %+v", cart)
 
-    plans, err := h.db.GetPlans()
+    // Moeda escolhida (?currency=) ou do país (?country=); sem elas vale a já escolhida
+    currency, _ := session.Values["currency"].(string)
+    if query := r.URL.Query(); query.Get("currency") != "" || query.Get("country") != "" {
+        currency, err = models.SelectCurrency(query.Get("country"), query.Get("currency"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        session.Values["currency"] = currency
+        if err := session.Save(r, w); err != nil {
+            log.Printf("Error saving session: %v", err)
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+
+    plans, err := h.pricedPlans(currency)
     if err != nil {
         log.Printf("Error getting plans: %v", err)
         http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -202,6 +222,18 @@ func (h *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
     }
 
     response := h.calculateCartDetails(cart, plans)
+    response.Currency = currencyOrDefault(currency)
+    if code, ok := session.Values["coupon"].(string); ok && code != "" {
+        if _, err := h.applyCoupon(&response, code); err != nil {
+            log.Printf("Error applying cart coupon %s: %v", code, err)
+        }
+    }
+
+    // Estimativa do imposto quando o endereço de cobrança já é conhecido (?state=&zipcode=)
+    h.applyTax(&response, tax.Address{
+        State:   r.URL.Query().Get("state"),
+        ZipCode: r.URL.Query().Get("zipcode"),
+    })
     
     w.Header().Set("Content-Type", "application/json")
     responseJSON, _ := json.Marshal(response)
@@ -209,6 +241,138 @@ func (h *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
     w.Write(responseJSON)
 }
 
+// ApplyCoupon aplica um cupom ao carrinho; code vazio remove o cupom aplicado.
+// O limite por cliente só é conferido no checkout, quando o email é conhecido.
+func (h *CartHandler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+    session, err := h.store.Get(r, "cart-session")
+    if err != nil {
+        log.Printf("Error getting session: %v", err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    var request struct {
+        Code string `json:"code"`
+    }
+    if r.Method != http.MethodDelete {
+        if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+            log.Printf("Error decoding request body: %v", err)
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    code := models.NormalizeCouponCode(request.Code)
+
+    cart, ok := session.Values["cart"].([]models.CartItem)
+    if !ok {
+        cart = []models.CartItem{}
+    }
+
+    currency, _ := session.Values["currency"].(string)
+    plans, err := h.pricedPlans(currency)
+    if err != nil {
+        log.Printf("Error getting plans: %v", err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    response := h.calculateCartDetails(cart, plans)
+    response.Currency = currencyOrDefault(currency)
+    if code != "" {
+        applied, err := h.applyCoupon(&response, code)
+        if err != nil {
+            log.Printf("Error applying cart coupon %s: %v", code, err)
+            http.Error(w, "Error validating coupon", http.StatusInternalServerError)
+            return
+        }
+        if !applied {
+            http.Error(w, response.CouponMessage, http.StatusBadRequest)
+            return
+        }
+    }
+
+    session.Values["coupon"] = code
+    if err := session.Save(r, w); err != nil {
+        log.Printf("Error saving session: %v", err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
+
+// pricedPlans retorna os planos com o preço na moeda do carrinho
+func (h *CartHandler) pricedPlans(currency string) ([]models.PlanCart, error) {
+    plans, err := h.db.GetPlans()
+    if err != nil {
+        return nil, err
+    }
+
+    if err := h.db.ApplyPriceBook(plans, currency); err != nil {
+        return nil, err
+    }
+    return plans, nil
+}
+
+// currencyOrDefault retorna a moeda escolhida ou USD
+func currencyOrDefault(currency string) string {
+    if currency == "" {
+        return models.DefaultCurrency
+    }
+    return currency
+}
+
+// applyCoupon valida o cupom contra os planos do carrinho e abate o desconto do total.
+// Um cupom recusado fica na resposta com o motivo em CouponMessage e retorna false.
+func (h *CartHandler) applyCoupon(response *models.CartResponse, code string) (bool, error) {
+    response.CouponCode = code
+
+    planIDs := make([]int, 0, len(response.Items))
+    for _, item := range response.Items {
+        planIDs = append(planIDs, item.PlanID)
+    }
+
+    coupon, err := h.coupons.Check(code, "", planIDs)
+    if err != nil {
+        if coupons.IsRejection(err) {
+            response.CouponMessage = err.Error()
+            return false, nil
+        }
+        return false, err
+    }
+
+    // O cupom incide sobre o preço já com o desconto por quantidade
+    factor := 1.0
+    if response.CartSubtotal > 0 {
+        factor = (response.CartSubtotal - response.CartDiscount) / response.CartSubtotal
+    }
+
+    lines := make([]models.CouponLine, 0, len(response.Items))
+    for _, item := range response.Items {
+        lines = append(lines, models.CouponLine{
+            PlanID: item.PlanID,
+            Amount: item.Price * float64(item.PlanQuantity) * factor,
+        })
+    }
+
+    response.CouponDiscount = coupon.Discount(lines)
+    response.CartTotal = utils.Round(response.CartTotal - response.CouponDiscount)
+    return true, nil
+}
+
+// applyTax soma ao total o imposto do endereço, sobre o valor já com os descontos
+func (h *CartHandler) applyTax(response *models.CartResponse, addr tax.Address) {
+    if addr.State == "" && addr.ZipCode == "" {
+        return
+    }
+
+    rate := h.db.TaxRate(addr)
+    response.TaxRate = rate.Percent
+    response.Tax = rate.Amount(response.CartTotal)
+    response.CartTotal = utils.Round(response.CartTotal + response.Tax)
+}
+
 func (h *CartHandler) calculateCartDetails(cart []models.CartItem, plans []models.PlanCart) models.CartResponse {
     var response models.CartResponse
     var subtotal float64
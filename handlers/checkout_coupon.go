@@ -0,0 +1,84 @@
+package handlers
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/services/coupons"
+    "prosecure-payment-api/utils"
+)
+
+// ApplyCoupon aplica um cupom ao checkout (code vazio remove) e retorna os valores
+// recalculados. O resgate só acontece na criação da conta, quando os limites são
+// conferidos de novo.
+func (h *CheckoutHandler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        CheckoutID string `json:"checkout_id"`
+        Code       string `json:"code"`
+    }
+
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+
+    if req.CheckoutID == "" {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "checkout_id is required")
+        return
+    }
+
+    checkout, err := h.db.GetCheckoutData(req.CheckoutID)
+    if err != nil {
+        log.Printf("Error getting checkout %s: %v", req.CheckoutID, err)
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid checkout ID")
+        return
+    }
+
+    code := models.NormalizeCouponCode(req.Code)
+    if code != "" {
+        if _, err := h.coupons.Check(code, checkout.Email, checkout.PlanIDs()); err != nil {
+            if coupons.IsRejection(err) {
+                utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+                return
+            }
+            log.Printf("Error checking coupon %s for checkout %s: %v", code, req.CheckoutID, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Error validating coupon")
+            return
+        }
+    }
+
+    if err := h.db.SetCheckoutCoupon(req.CheckoutID, code); err != nil {
+        log.Printf("Error setting coupon of checkout %s: %v", req.CheckoutID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Database error")
+        return
+    }
+
+    checkout, err = h.db.GetCheckoutData(req.CheckoutID)
+    if err != nil {
+        log.Printf("Error reloading checkout %s: %v", req.CheckoutID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Database error")
+        return
+    }
+
+    message := "Coupon applied"
+    if code == "" {
+        message = "Coupon removed"
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: message,
+        Data: map[string]interface{}{
+            "checkout_id":     checkout.ID,
+            "coupon_code":     checkout.CouponCode,
+            "subtotal":        checkout.Subtotal,
+            "discount":        checkout.Discount,
+            "coupon_discount": checkout.CouponDiscount,
+            "tax":             checkout.Tax,
+            "tax_rate":        checkout.TaxRate,
+            "total":           checkout.Total,
+        },
+    })
+}
@@ -7,18 +7,21 @@ import (
     "log"
     "net/http"
     "database/sql"
+    "strings"
     
     "prosecure-payment-api/database"
     "prosecure-payment-api/models"
+    "prosecure-payment-api/services/coupons"
     "prosecure-payment-api/utils"
 )
 
 type CheckoutHandler struct {
-    db *database.Connection
+    db      *database.Connection
+    coupons *coupons.Service
 }
 
 func NewCheckoutHandler(db *database.Connection) *CheckoutHandler {
-    return &CheckoutHandler{db: db}
+    return &CheckoutHandler{db: db, coupons: coupons.NewService(db)}
 }
 
 // UpdateCheckout handles updates to the checkout data
@@ -35,6 +38,8 @@ func (h *CheckoutHandler) UpdateCheckout(w http.ResponseWriter, r *http.Request)
         Additional   *string `json:"additional"` // Tornando optional com ponteiro
         Username     string  `json:"username"`
         Passphrase   string  `json:"passphrase"`
+        Country      string  `json:"country"`  // ISO 3166 alfa-2; define a moeda se currency vier vazio
+        Currency     string  `json:"currency"` // escolha explícita da moeda
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -49,6 +54,17 @@ func (h *CheckoutHandler) UpdateCheckout(w http.ResponseWriter, r *http.Request)
         return
     }
 
+    // Moeda pelo país ou pela escolha do cliente
+    var currency string
+    if req.Country != "" || req.Currency != "" {
+        selected, err := models.SelectCurrency(req.Country, req.Currency)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        currency = selected
+    }
+
     // Hash da passphrase usando SHA256 se ela estiver presente
     var hashedPassphrase string
     if req.Passphrase != "" {
@@ -124,6 +140,15 @@ func (h *CheckoutHandler) UpdateCheckout(w http.ResponseWriter, r *http.Request)
         return
     }
 
+    if currency != "" {
+        country := strings.ToUpper(strings.TrimSpace(req.Country))
+        if err := h.db.SetCheckoutCurrency(req.CheckoutID, country, currency); err != nil {
+            log.Printf("Error setting checkout currency: %v", err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Database error")
+            return
+        }
+    }
+
     utils.SendSuccessResponse(w, models.APIResponse{
         Status:  "success",
         Message: "Checkout data saved successfully",
@@ -143,7 +168,8 @@ func (h *CheckoutHandler) GetCheckout(w http.ResponseWriter, r *http.Request) {
 
     query := `
         SELECT name, email, phoneNumber, zipcode, state, city, street, 
-               additional, username, passphrase, COALESCE(status, 'pending') as status
+               additional, username, passphrase, COALESCE(status, 'pending') as status,
+               COALESCE(country, ''), COALESCE(currency, 'USD')
         FROM checkout_historics 
         WHERE checkout_id = ?
     `
@@ -162,6 +188,8 @@ func (h *CheckoutHandler) GetCheckout(w http.ResponseWriter, r *http.Request) {
         &checkout.Username,
         &checkout.Passphrase,
         &checkout.Status,
+        &checkout.Country,
+        &checkout.Currency,
     )
 
     if err == sql.ErrNoRows {
@@ -37,6 +37,8 @@ type DashboardUpdateCardRequest struct {
     CardNumber string `json:"card_number" binding:"required"`
     Expiry     string `json:"expiry" binding:"required"`
     CVV        string `json:"cvv" binding:"required"`
+    // Nonce do Accept.js, alternativo a card_number/expiry/cvv
+    OpaqueData *models.OpaqueData `json:"opaqueData,omitempty"`
 }
 
 type DashboardUpdateCardResponse struct {
@@ -66,7 +68,16 @@ func (h *DashboardUpdateCardHandler) UpdateCard(w http.ResponseWriter, r *http.R
     }
 
     // Validar dados obrigatórios
-    if req.CardName == "" || req.CardNumber == "" || req.Expiry == "" || req.CVV == "" {
+    if req.OpaqueData != nil {
+        if !req.OpaqueData.Valid() {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "opaqueData requires dataDescriptor and dataValue")
+            return
+        }
+        if req.CardNumber != "" || req.CVV != "" {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Send either card data or opaqueData, not both")
+            return
+        }
+    } else if req.CardName == "" || req.CardNumber == "" || req.Expiry == "" || req.CVV == "" {
         utils.SendErrorResponse(w, http.StatusBadRequest, "All card fields are required")
         return
     }
@@ -106,6 +117,7 @@ func (h *DashboardUpdateCardHandler) UpdateCard(w http.ResponseWriter, r *http.R
         CardNumber: req.CardNumber,
         CVV:        req.CVV,
         Expiry:     req.Expiry,
+        OpaqueData: req.OpaqueData,
     }
 
     // Validar cartão usando o service
@@ -157,14 +169,25 @@ func (h *DashboardUpdateCardHandler) UpdateCard(w http.ResponseWriter, r *http.R
         return
     }
 
-    // Atualizar billing_infos no banco
-		maskedCard, err := h.updateBillingInfo(masterAccount.ReferenceUUID, req.CardNumber, req.Expiry, req.CardName)
+    // Atualizar billing_infos no banco (com Accept.js, o número mascarado vem do gateway)
+		cardNumber := req.CardNumber
+		if cardNumber == "" {
+				cardNumber = paymentReq.AccountNumber
+		}
+		maskedCard, err := h.updateBillingInfo(masterAccount.ReferenceUUID, cardNumber, req.Expiry, req.CardName)
 		if err != nil {
 				log.Printf("Error updating billing info: %v", err)
 				utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update billing information")
 				return
 		}
 
+    // Conta em dunning: a próxima retentativa usa o cartão novo imediatamente
+    if scheduled, err := h.db.RequestDunningRetry(masterAccount.ReferenceUUID); err != nil {
+        log.Printf("Warning: Failed to schedule dunning retry for %s: %v", masterAccount.ReferenceUUID, err)
+    } else if scheduled {
+        log.Printf("Dunning retry scheduled with the new card for %s", masterAccount.ReferenceUUID)
+    }
+
     // Se o usuário tinha erro de pagamento, reativar a conta
     if user.AccountType == "payment_error" {
         err = h.reactivateAccount(user.Username, user.Email)
@@ -223,14 +246,19 @@ func (h *DashboardUpdateCardHandler) getMasterAccountData(username, email string
 
 func (h *DashboardUpdateCardHandler) updateBillingInfo(masterRef, cardNumber, expiry, holderName string) (string, error) {
 	// Mascarar cartão
-	maskedCard := "XXXX XXXX XXXX " + cardNumber[len(cardNumber)-4:]
+	maskedCard := database.MaskCardNumber(cardNumber)
+	if maskedCard == "" {
+		return "", fmt.Errorf("card number unavailable for %s", masterRef)
+	}
 	
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	
+	// Accept.js não expõe a validade nem sempre o nome: manter os valores atuais
 	query := `
 			UPDATE billing_infos 
-			SET card = ?, expiry = ?, holder_name = ?, updated_at = NOW()
+			SET card = ?, expiry = COALESCE(NULLIF(?, ''), expiry),
+			    holder_name = COALESCE(NULLIF(?, ''), holder_name), updated_at = NOW()
 			WHERE master_reference = ?
 	`
 	
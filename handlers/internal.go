@@ -226,6 +226,8 @@ func (h *InternalHandler) determineAccountType(isMaster bool, isActive int) stri
         return "normal"
     case 2:
         return "dea"
+    case models.AccountStatusPaused:
+        return "paused"
     case 9:
         return "payment_error"
     default:
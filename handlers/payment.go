@@ -14,7 +14,10 @@ import (
     "github.com/google/uuid"
     "prosecure-payment-api/models"
     "prosecure-payment-api/services/payment"
+    "prosecure-payment-api/services/cardvault"
+    "prosecure-payment-api/services/coupons"
     "prosecure-payment-api/services/email"
+    "prosecure-payment-api/services/threeds"
     "prosecure-payment-api/database"
     "prosecure-payment-api/utils"
     "prosecure-payment-api/queue"
@@ -31,10 +34,14 @@ type PaymentHandler struct {
     paymentService *payment.Service
     emailService   *email.SMTPService
     queue          *queue.Queue
+    cardVault      *cardvault.Vault
+    coupons        *coupons.Service
+    threeDS        threeds.Provider // nil com o 3-D Secure desligado
+    threeDSMode    string
     checkoutCache  map[string]checkoutCache // Changed from sync.Map to regular map
 }
 
-func NewPaymentHandler(db *database.Connection, ps *payment.Service, es *email.SMTPService, q *queue.Queue) (*PaymentHandler, error) {
+func NewPaymentHandler(db *database.Connection, ps *payment.Service, es *email.SMTPService, q *queue.Queue, cv *cardvault.Vault, tds threeds.Provider, threeDSMode string) (*PaymentHandler, error) {
     if db == nil {
         return nil, fmt.Errorf("database connection is required")
     }
@@ -47,12 +54,19 @@ func NewPaymentHandler(db *database.Connection, ps *payment.Service, es *email.S
     if q == nil {
         return nil, fmt.Errorf("queue is required")
     }
+    if cv == nil {
+        return nil, fmt.Errorf("card data vault is required")
+    }
 
     return &PaymentHandler{
         db:             db,
         paymentService: ps,
         emailService:   es,
         queue:          q,
+        cardVault:      cv,
+        coupons:        coupons.NewService(db),
+        threeDS:        tds,
+        threeDSMode:    threeDSMode,
         checkoutCache:  make(map[string]checkoutCache),
     }, nil
 }
@@ -86,6 +100,13 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
 
     log.Printf("[RequestID: %s] Processing payment for checkout ID: %s", requestID, req.CheckoutID)
 
+    // Accept.js: o navegador envia só o nonce, nunca o cartão junto
+    if req.OpaqueData != nil && (req.CardNumber != "" || req.CVV != "") {
+        log.Printf("[RequestID: %s] Request has both card data and opaque data", requestID)
+        sendErrorResponse(w, http.StatusBadRequest, "Envie os dados do cartão ou o opaqueData do Accept.js, não ambos")
+        return
+    }
+
     // Verificar se o checkout já foi processado
     processed, err := h.db.IsCheckoutProcessed(req.CheckoutID)
     if err != nil {
@@ -143,6 +164,21 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
         }
     }
 
+    // Cupom aplicado ao checkout: os limites podem ter sido atingidos depois de aplicado
+    if checkout.Coupon != nil {
+        if _, err := h.coupons.Check(checkout.CouponCode, checkout.Email, checkout.PlanIDs()); err != nil {
+            if coupons.IsRejection(err) {
+                log.Printf("[RequestID: %s] Coupon %s rejected: %v", requestID, checkout.CouponCode, err)
+                delete(h.checkoutCache, req.CheckoutID) // o cliente vai trocar ou remover o cupom
+                sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Coupon %s: %v", checkout.CouponCode, err))
+                return
+            }
+            log.Printf("[RequestID: %s] Error checking coupon: %v", requestID, err)
+            sendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+            return
+        }
+    }
+
     // Adicionar informações de billing ao request
     req.CustomerEmail = checkout.Email
     req.BillingInfo = &types.BillingInfoType{
@@ -152,9 +188,10 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
         City:        checkout.City,
         State:       checkout.State,
         Zip:         checkout.ZipCode,
-        Country:     "US",
+        Country:     checkout.BillingCountry(),
         PhoneNumber: checkout.PhoneNumber,
     }
+    req.Currency = checkout.Currency
 
     // Validar dados do cartão
     if !h.paymentService.ValidateCard(&req) {
@@ -163,21 +200,23 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
         return
     }
 
-    // Salvar dados de pagamento temporários
-    ctxTemp, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-    
-    _, err = h.db.GetDB().ExecContext(ctxTemp,
-        `INSERT INTO temp_payment_data
-         (checkout_id, card_number, card_expiry, card_cvv, card_name, created_at)
-         VALUES (?, ?, ?, ?, ?, NOW())
-         ON DUPLICATE KEY UPDATE
-         card_number = VALUES(card_number),
-         card_expiry = VALUES(card_expiry),
-         card_cvv = VALUES(card_cvv),
-         card_name = VALUES(card_name),
-         created_at = NOW()`,
-        checkout.ID, req.CardNumber, req.Expiry, req.CVV, req.CardName)
+    // 3-D Secure: o lookup precisa do número do cartão, que o Accept.js não entrega ao servidor
+    requireThreeDS := h.threeDS != nil && threeds.Required(h.threeDSMode, req.ThreeDSData)
+    if requireThreeDS && req.OpaqueData != nil {
+        log.Printf("[RequestID: %s] 3-D Secure required but request uses opaque data", requestID)
+        sendErrorResponse(w, http.StatusBadRequest, "A autenticação 3-D Secure requer os dados do cartão, não o opaqueData do Accept.js")
+        return
+    }
+
+    // Salvar dados de pagamento temporários (cifrados; removidos pelo sweeper após o TTL)
+    err = h.cardVault.Store(&models.PaymentDataStorage{
+        CheckoutID: checkout.ID,
+        CardNumber: req.CardNumber,
+        CardExpiry: req.Expiry,
+        CardCVV:    req.CVV,
+        CardName:   req.CardName,
+        OpaqueData: req.OpaqueData,
+    })
     
     if err != nil {
         log.Printf("[RequestID: %s] Failed to store temporary payment data: %v", requestID, err)
@@ -185,11 +224,27 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
         return
     }
 
+    // Autenticar o portador antes de criar a conta: no desafio o checkout continua
+    // em ThreeDSCallback, depois que o ACS devolver o resultado
+    if requireThreeDS && !h.authenticateThreeDS(w, requestID, checkout, &req) {
+        return
+    }
+
+    h.schedulePayment(w, requestID, checkout, &req)
+}
+
+// schedulePayment cria a conta e agenda o job de pagamento de um checkout cujos dados
+// de cartão já estão no vault (e, se exigido, já autenticado pelo 3-D Secure)
+func (h *PaymentHandler) schedulePayment(w http.ResponseWriter, requestID string, checkout *models.CheckoutData, req *models.PaymentRequest) {
+    ctxTemp, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
     // Registrar status inicial do pagamento
-    _, err = h.db.GetDB().ExecContext(ctxTemp,
+    _, err := h.db.GetDB().ExecContext(ctxTemp,
         `INSERT INTO payment_results 
          (request_id, checkout_id, status, created_at)
-         VALUES (?, ?, 'scheduled', NOW())`,
+         VALUES (?, ?, 'scheduled', NOW())
+         ON DUPLICATE KEY UPDATE status = 'scheduled'`,
         requestID, checkout.ID)
     
     if err != nil {
@@ -199,7 +254,7 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
     // CRIAR CONTA IMEDIATAMENTE para uso do cliente
     log.Printf("[RequestID: %s] Creating user account immediately", requestID)
     
-    err = h.createAccountsAndNotify(checkout, &req, "PENDING")
+    err = h.createAccountsAndNotify(checkout, req, "PENDING")
     if err != nil {
         log.Printf("[RequestID: %s] Error creating account: %v", requestID, err)
         sendErrorResponse(w, http.StatusInternalServerError, "Falha ao criar conta")
@@ -223,7 +278,7 @@ func (h *PaymentHandler) ProcessPayment(w http.ResponseWriter, r *http.Request)
     err = h.queue.EnqueueDelayed(ctx, queue.JobTypeDelayedPayment, map[string]interface{}{
         "checkout_id": checkout.ID,
         "request_id":  requestID,
-    }, paymentDelay)
+    }, paymentDelay, queue.WithIdempotencyKey("delayed_payment:"+checkout.ID))
     
     if err != nil {
         log.Printf("[RequestID: %s] Error enqueueing delayed payment job: %v", requestID, err)
@@ -266,6 +321,12 @@ func (h *PaymentHandler) CheckPaymentStatus(w http.ResponseWriter, r *http.Reque
     var createdAt time.Time
     var checkoutID string
     
+    // O job de pagamento diz se ainda há tentativas pela frente
+    job, jobErr := h.db.GetPaymentJobRecord(requestID)
+    if jobErr != nil && jobErr != sql.ErrNoRows {
+        log.Printf("Warning: Could not load payment job of request %s: %v", requestID, jobErr)
+    }
+    
     err := h.db.GetDB().QueryRowContext(ctx,
         `SELECT status, COALESCE(transaction_id, ''), COALESCE(error_message, ''), created_at, checkout_id 
          FROM payment_results 
@@ -275,13 +336,24 @@ func (h *PaymentHandler) CheckPaymentStatus(w http.ResponseWriter, r *http.Reque
         requestID).Scan(&status, &transactionID, &errorMessage, &createdAt, &checkoutID)
     
     if err == sql.ErrNoRows {
-        sendErrorResponse(w, http.StatusNotFound, "Payment request not found")
-        return
+        if job == nil {
+            sendErrorResponse(w, http.StatusNotFound, "Payment request not found")
+            return
+        }
+        // Job ainda não gravou resultado (aguardando a primeira execução)
+        status = "processing"
+        createdAt = job.CreatedAt
+        checkoutID = job.CheckoutID
     } else if err != nil {
         log.Printf("Error checking payment status: %v", err)
         sendErrorResponse(w, http.StatusInternalServerError, "Error checking payment status")
         return
     }
+    
+    // Falha gravada por uma tentativa intermediária: o job ainda vai tentar de novo
+    if job != nil && job.IsPending() && status != "success" {
+        status = "processing"
+    }
 
     var accountCreated bool = false
     if status == "success" && checkoutID != "" {
@@ -309,6 +381,18 @@ func (h *PaymentHandler) CheckPaymentStatus(w http.ResponseWriter, r *http.Reque
         response.Data.(map[string]interface{})["error"] = errorMessage
     }
     
+    if job != nil {
+        jobData := map[string]interface{}{
+            "id":       job.ID,
+            "state":    job.State,
+            "attempts": job.Attempts,
+        }
+        if job.State == string(queue.JobStateDelayed) && job.RunAt != nil {
+            jobData["next_attempt_at"] = job.RunAt
+        }
+        response.Data.(map[string]interface{})["job"] = jobData
+    }
+    
     sendSuccessResponse(w, response)
 }
 
@@ -332,6 +416,10 @@ func (h *PaymentHandler) createAccountsAndNotify(checkout *models.CheckoutData,
     firstName := names[0]
     lastName := strings.Join(names[1:], " ")
 
+    // O trial dura o trial_days dos planos comprados
+    trialStart := time.Now()
+    trialEnd := checkout.TrialEndDate(trialStart)
+
     masterAccount := &models.MasterAccount{
         Name:             firstName,
         LastName:         lastName,
@@ -349,7 +437,8 @@ func (h *PaymentHandler) createAccountsAndNotify(checkout *models.CheckoutData,
         Plan:            checkout.PlanID,
         PurchasedPlans:  checkout.PlansJSON,
         SimultaneousUsers: len(checkout.Plans),
-        RenewDate:       time.Now().AddDate(0, 1, 0),
+        RenewDate:       trialEnd,
+        Currency:        checkout.Currency,
     }
 
     var total float64
@@ -396,6 +485,7 @@ func (h *PaymentHandler) createAccountsAndNotify(checkout *models.CheckoutData,
         MasterReference: masterUUID,
         IsTrial:        1,
         Total:          0,
+        Currency:       checkout.Currency,
         DueDate:        time.Now(),
         IsPaid:         1,
     }
@@ -405,11 +495,13 @@ func (h *PaymentHandler) createAccountsAndNotify(checkout *models.CheckoutData,
         return fmt.Errorf("failed to create trial invoice: %v", err)
     }
 
-    futureDate := time.Now().AddDate(0, 1, 0)
+    futureDate := trialEnd
     futureInvoice := &models.Invoice{
         MasterReference: masterUUID,
         IsTrial:        0,
-        Total:          total,
+        Total:          total - checkout.CouponDiscount + checkout.Tax,
+        Tax:            checkout.Tax,
+        Currency:       checkout.Currency,
         DueDate:        futureDate,
         IsPaid:         0,
     }
@@ -429,6 +521,24 @@ func (h *PaymentHandler) createAccountsAndNotify(checkout *models.CheckoutData,
         return fmt.Errorf("failed to save subscription: %v", err)
     }
 
+    if err := tx.SaveAccountTrial(masterUUID, trialStart, trialEnd); err != nil {
+        tx.Rollback()
+        return fmt.Errorf("failed to save account trial: %v", err)
+    }
+
+    // Cupom do checkout: os limites são conferidos de novo com o cupom travado
+    if checkout.Coupon != nil {
+        redeemed, err := tx.RedeemCheckoutCoupon(checkout, masterUUID, trialEnd)
+        if err != nil {
+            tx.Rollback()
+            return fmt.Errorf("failed to redeem coupon: %v", err)
+        }
+        if !redeemed {
+            tx.Rollback()
+            return fmt.Errorf("coupon %s can no longer be used", checkout.Coupon.Code)
+        }
+    }
+
     if err := tx.Commit(); err != nil {
         return fmt.Errorf("failed to commit transaction: %v", err)
     }
@@ -463,7 +573,7 @@ func (h *PaymentHandler) createAccountsAndNotify(checkout *models.CheckoutData,
         "customer_name":  checkout.Name,
         "activation_url": activationURL,
         "request_id":     fmt.Sprintf("activation-%s", masterUUID),
-    }, activationDelay)
+    }, activationDelay, queue.WithIdempotencyKey("activation_email:"+checkout.Username))
     
     if err != nil {
         log.Printf("Warning: Failed to enqueue activation email job: %v", err)
@@ -536,10 +646,10 @@ func (h *PaymentHandler) generateInvoiceEmail(checkout *models.CheckoutData) str
         plansTable += fmt.Sprintf(`
             <tr>
                 <td>%s</td>
-                <td>$%.2f</td>
+                <td>%s</td>
             </tr>`, 
             plan.PlanName, 
-            planPrice,
+            models.FormatMoney(planPrice, checkout.Currency),
         )
     }
     plansTable += `
@@ -548,10 +658,18 @@ func (h *PaymentHandler) generateInvoiceEmail(checkout *models.CheckoutData) str
 
     // Seção de totais mais clara
     totalsSection := fmt.Sprintf(`
-        <p><strong>Subtotal:</strong> $%.2f</p>
-        <p><strong>Discount:</strong> $%.2f</p>
-        <p style="font-size: 18px; font-weight: bold; color: #28a745;"><strong>Total Paid:</strong> $0.01</p>
-    `, total, total-0.01)
+        <p><strong>Subtotal:</strong> %s</p>
+        <p><strong>Discount:</strong> %s</p>
+        <p style="font-size: 18px; font-weight: bold; color: #28a745;"><strong>Total Paid:</strong> %s</p>
+    `, models.FormatMoney(total, checkout.Currency), models.FormatMoney(total-0.01, checkout.Currency),
+        models.FormatMoney(0.01, checkout.Currency))
+
+    // Imposto sobre vendas: cobrado junto com a primeira cobrança da assinatura
+    if checkout.Tax > 0 {
+        totalsSection += fmt.Sprintf(`
+        <p><strong>Sales Tax (%.3g%%):</strong> %s, included in your first charge of %s</p>
+    `, checkout.TaxRate, models.FormatMoney(checkout.Tax, checkout.Currency), models.FormatMoney(checkout.Total, checkout.Currency))
+    }
 
     footer := fmt.Sprintf(
         "Thank you %s for choosing our services. If you have any questions, please contact our support team.",
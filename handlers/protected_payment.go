@@ -46,6 +46,8 @@ func (h *ProtectedPaymentHandler) UpdatePaymentMethod(w http.ResponseWriter, r *
         CardNumber string `json:"card_number" binding:"required"`
         Expiry     string `json:"expiry" binding:"required"`
         CVV        string `json:"cvv" binding:"required"`
+        // Nonce do Accept.js, alternativo a card_number/expiry/cvv
+        OpaqueData *models.OpaqueData `json:"opaqueData,omitempty"`
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -55,7 +57,12 @@ func (h *ProtectedPaymentHandler) UpdatePaymentMethod(w http.ResponseWriter, r *
     }
 
     // Validar campos obrigatórios
-    if req.CardName == "" || req.CardNumber == "" || req.Expiry == "" || req.CVV == "" {
+    if req.OpaqueData != nil {
+        if req.CardNumber != "" || req.CVV != "" {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Send either card data or opaqueData, not both")
+            return
+        }
+    } else if req.CardName == "" || req.CardNumber == "" || req.Expiry == "" || req.CVV == "" {
         utils.SendErrorResponse(w, http.StatusBadRequest, "All card fields are required")
         return
     }
@@ -76,6 +83,7 @@ func (h *ProtectedPaymentHandler) UpdatePaymentMethod(w http.ResponseWriter, r *
         CardNumber:    req.CardNumber,
         Expiry:        req.Expiry,
         CVV:           req.CVV,
+        OpaqueData:    req.OpaqueData,
         CheckoutID:    masterAccount.ReferenceUUID,
         CustomerEmail: user.Email,
         BillingInfo: &types.BillingInfoType{
@@ -264,6 +272,12 @@ func (h *ProtectedPaymentHandler) GetAccountDetails(w http.ResponseWriter, r *ht
             accountDetails["next_billing"] = nextBillingDate.Time.Format("2006-01-02")
         }
 
+        // Estado real da assinatura na Authorize.net; sem resposta do gateway fica a cópia local
+        if subscription := h.getGatewaySubscription(masterAccountData.ReferenceUUID); subscription != nil {
+            accountDetails["subscription"] = subscription
+            accountDetails["next_billing"] = subscription.NextPaymentDate
+        }
+
         utils.SendSuccessResponse(w, models.APIResponse{
             Status:  "success",
             Message: "Account details retrieved",
@@ -354,6 +368,53 @@ func (h *ProtectedPaymentHandler) GetPaymentHistory(w http.ResponseWriter, r *ht
 
 // Helper methods
 
+// getGatewaySubscription consulta a assinatura mais recente da conta na ARB.
+// Retorna nil se a conta não tiver assinatura ou se o gateway não responder.
+func (h *ProtectedPaymentHandler) getGatewaySubscription(masterRef string) *models.GatewaySubscription {
+    subscriptionID, err := h.db.GetLatestSubscriptionID(masterRef)
+    if err != nil {
+        if err != sql.ErrNoRows {
+            log.Printf("Error getting subscription of %s: %v", masterRef, err)
+        }
+        return nil
+    }
+
+    details, err := h.paymentService.GetSubscription(subscriptionID)
+    if err != nil {
+        log.Printf("Error getting subscription %s from gateway: %v", subscriptionID, err)
+        return nil
+    }
+
+    now := time.Now()
+    subscription := &models.GatewaySubscription{
+        SubscriptionID:  subscriptionID,
+        Status:          details.Status,
+        Amount:          details.Amount,
+        IntervalLength:  details.PaymentSchedule.Interval.Length,
+        IntervalUnit:    details.PaymentSchedule.Interval.Unit,
+        StartDate:       details.PaymentSchedule.StartDate,
+        PastOccurrences: details.PastOccurrences(now),
+        Charges:         []models.GatewaySubscriptionCharge{},
+    }
+
+    if next, ok := details.NextPaymentDate(now); ok {
+        nextDate := next.Format("2006-01-02")
+        subscription.NextPaymentDate = &nextDate
+    }
+
+    for _, charge := range details.ArbTransactions {
+        subscription.Charges = append(subscription.Charges, models.GatewaySubscriptionCharge{
+            TransactionID: charge.TransID,
+            Response:      charge.Response,
+            SubmittedAt:   charge.SubmitTimeUTC,
+            PayNum:        charge.PayNum,
+            AttemptNum:    charge.AttemptNum,
+        })
+    }
+
+    return subscription
+}
+
 func (h *ProtectedPaymentHandler) getMasterAccountByUser(username, email string) (*models.MasterAccount, error) {
     query := `
         SELECT reference_uuid, name, lname, email, username, phone_number,
@@ -398,11 +459,14 @@ func (h *ProtectedPaymentHandler) updateAccountAfterCardUpdate(master *models.Ma
         }
     }()
 
-    // Atualizar método de pagamento
+    // Atualizar método de pagamento (com Accept.js, o número mascarado vem do gateway)
     cardData := &models.CardData{
         Number: payment.CardNumber,
         Expiry: payment.Expiry,
     }
+    if cardData.Number == "" {
+        cardData.Number = payment.AccountNumber
+    }
 
     if err = tx.SavePaymentMethod(master.ReferenceUUID, cardData); err != nil {
         return fmt.Errorf("failed to update payment method: %v", err)
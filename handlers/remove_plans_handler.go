@@ -0,0 +1,602 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/services/tax"
+    "prosecure-payment-api/utils"
+)
+
+// Formas de devolver o pro-rata dos planos removidos
+const (
+    CreditMethodAccount = "credit" // abatido nas próximas cobranças da assinatura
+    CreditMethodRefund  = "refund" // estornado na última cobrança da conta
+)
+
+// Valor mínimo que a ARB continua cobrando em um ciclo com crédito abatido
+const minimumSubscriptionCharge = 1.00
+
+// Limite de ciclos futuros para distribuir um crédito maior que a cobrança
+const maxCreditCycles = 24
+
+type RemovePlansRequest struct {
+    Cart []CartPlan `json:"cart"` // planos e quantidades a remover
+    // Sub-usuários a remover. Vagas ainda não atribuídas ("none") são removidas primeiro;
+    // vagas com usuário só saem se o usuário estiver nesta lista.
+    Usernames    []string `json:"usernames,omitempty"`
+    CreditMethod string   `json:"credit_method,omitempty"` // "credit" (padrão) ou "refund"
+}
+
+type RemovePlansResponse struct {
+    Success              bool              `json:"success"`
+    Message              string            `json:"message"`
+    CreditAmount         float64           `json:"credit_amount"`
+    CreditMethod         string            `json:"credit_method"`
+    RefundTransactionID  string            `json:"refund_transaction_id,omitempty"`
+    MonthlyDecrease      float64           `json:"monthly_decrease"`
+    NewMonthlyTotal      float64           `json:"new_monthly_total"`
+    NextCharge           float64           `json:"next_charge"`
+    PlanDetails          []PlanCalculation `json:"plan_details"`
+    RemovedUsers         []string          `json:"removed_users"`
+    UserType             string            `json:"user_type"`
+    IsTrial              bool              `json:"is_trial"`
+    // A ARB ainda não está no valor de NextCharge: a alteração falhou e será repetida
+    BillingUpdatePending bool              `json:"billing_update_pending,omitempty"`
+}
+
+// planRemoval é o resultado do cálculo de uma remoção, compartilhado pelo preview
+type planRemoval struct {
+    account         *models.MasterAccount
+    isAnnual        bool
+    isTrial         bool
+    remainingPlans  []PurchasedPlan
+    removedCount    int
+    removedUsers    []string
+    calculations    []PlanCalculation
+    credit          float64
+    monthlyDecrease float64
+    newTotal        float64
+}
+
+func (h *AddPlansHandler) PreviewRemovePlans(w http.ResponseWriter, r *http.Request) {
+    removal, req, ok := h.prepareRemoval(w, r)
+    if !ok {
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status: "success",
+        Data: RemovePlansResponse{
+            Success:         true,
+            CreditAmount:    removal.credit,
+            CreditMethod:    req.CreditMethod,
+            MonthlyDecrease: removal.monthlyDecrease,
+            NewMonthlyTotal: removal.newTotal,
+            PlanDetails:     removal.calculations,
+            RemovedUsers:    removal.removedUsers,
+            UserType:        removalUserType(removal),
+            IsTrial:         removal.isTrial,
+        },
+    })
+}
+
+// RemovePlans remove planos (ou reduz quantidades) da conta: o pro-rata do período não
+// usado vira crédito para as próximas cobranças ou é estornado, a ARB passa ao novo valor
+// e os sub-usuários removidos são desativados
+func (h *AddPlansHandler) RemovePlans(w http.ResponseWriter, r *http.Request) {
+    removal, req, ok := h.prepareRemoval(w, r)
+    if !ok {
+        return
+    }
+
+    account := removal.account
+
+    // Um segundo envio da mesma remoção não pode devolver o crédito de novo
+    acquired, err := h.db.LockAccount(account.ReferenceUUID)
+    if err != nil {
+        log.Printf("Error locking account %s for plan removal: %v", account.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+    if !acquired {
+        utils.SendErrorResponse(w, http.StatusConflict, "Another change to this subscription is in progress")
+        return
+    }
+    defer h.db.ReleaseAccountLock(account.ReferenceUUID)
+
+    log.Printf("Removing %d plans from %s (credit: $%.2f via %s, monthly decrease: $%.2f)",
+        removal.removedCount, account.Username, removal.credit, req.CreditMethod, removal.monthlyDecrease)
+
+    subscriptionID, err := h.db.GetActiveSubscriptionID(account.ReferenceUUID)
+    if err != nil && !(removal.isTrial && err == sql.ErrNoRows) {
+        log.Printf("Error getting subscription for %s: %v", account.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusConflict, "No active subscription found")
+        return
+    }
+
+    updatedPlansJSON, err := json.Marshal(removal.remainingPlans)
+    if err != nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update account")
+        return
+    }
+
+    // Crédito do período não usado (trial não pagou nada): o crédito de conta também é o
+    // destino do valor se o estorno não puder ser feito
+    var credits []database.AccountCredit
+    var refund *database.Refund
+    var cardLastFour string
+    if removal.credit > 0 {
+        credits, err = h.removalCredits(removal)
+        if err != nil {
+            log.Printf("Error scheduling credit for %s: %v", account.Username, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update account")
+            return
+        }
+
+        if req.CreditMethod == CreditMethodRefund {
+            refund, cardLastFour, err = h.prepareRemovalRefund(account, removal.credit,
+                middleware.GetUserFromContext(r.Context()).Username)
+            if err != nil {
+                log.Printf("Refund of $%.2f for %s not possible, keeping as account credit: %v",
+                    removal.credit, account.Username, err)
+            }
+        }
+    }
+
+    // 1. Conta, sub-usuários desativados e reserva do crédito ou do estorno numa transação
+    rate := h.db.AccountTaxRate(account)
+    err = h.db.ApplyPlanRemoval(&database.PlanRemoval{
+        MasterReference: account.ReferenceUUID,
+        PreviousPlans:   account.PurchasedPlans,
+        PurchasedPlans:  string(updatedPlansJSON),
+        TotalPrice:      removal.newTotal,
+        Decrease:        removal.monthlyDecrease,
+        DecreaseTax:     rate.Amount(removal.monthlyDecrease),
+        RemovedPlans:    removal.removedCount,
+        RemovedUsers:    removal.removedUsers,
+        Credits:         credits,
+        Refund:          refund,
+    })
+    if err != nil {
+        log.Printf("Error removing plans for %s: %v", account.Username, err)
+        if err == database.ErrAccountChanged {
+            utils.SendErrorResponse(w, http.StatusConflict, "Account changed during the plan removal, please review and try again")
+            return
+        }
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update account")
+        return
+    }
+
+    response := RemovePlansResponse{
+        Success:         true,
+        Message:         "Plans removed successfully",
+        CreditAmount:    removal.credit,
+        CreditMethod:    req.CreditMethod,
+        MonthlyDecrease: removal.monthlyDecrease,
+        NewMonthlyTotal: removal.newTotal,
+        PlanDetails:     removal.calculations,
+        RemovedUsers:    removal.removedUsers,
+        UserType:        removalUserType(removal),
+        IsTrial:         removal.isTrial,
+    }
+
+    // 2. Estorno reservado na transação acima
+    if removal.credit > 0 && req.CreditMethod == CreditMethodRefund {
+        refunded := false
+        if refund != nil && refund.ID > 0 {
+            refundTransID, refundErr := h.refundRemovalCredit(refund, cardLastFour)
+            if refundErr == nil {
+                response.RefundTransactionID = refundTransID
+                refunded = true
+            } else {
+                log.Printf("Refund of $%.2f for %s failed, keeping as account credit: %v",
+                    removal.credit, account.Username, refundErr)
+                if err := h.db.CreateAccountCredits(credits); err != nil {
+                    log.Printf("CRITICAL: Plans removed for %s but credit of $%.2f not recorded: %v",
+                        account.Username, removal.credit, err)
+                }
+            }
+        }
+
+        if !refunded {
+            response.CreditMethod = CreditMethodAccount
+            response.Message = "Plans removed successfully. The refund could not be issued, so the amount was added as credit to your next bills."
+        }
+    }
+
+    // 3. ARB no novo valor, menos o crédito abatido no próximo ciclo. Se o gateway
+    // recusar, a alteração vai para a fila e é repetida até a ARB ficar no valor certo.
+    response.NextCharge = rate.Gross(removal.newTotal)
+    if !removal.isTrial {
+        nextCharge, err := h.updateARBAfterRemoval(account.ReferenceUUID, subscriptionID, removal.newTotal, rate)
+        if nextCharge > 0 {
+            response.NextCharge = nextCharge
+        }
+        if err != nil {
+            log.Printf("Error updating ARB subscription %s after plan removal for %s: %v",
+                subscriptionID, account.Username, err)
+            response.BillingUpdatePending = true
+            response.Message = "Plans removed successfully. Your subscription amount could not be updated yet and will be updated automatically."
+            if err := h.scheduleSubscriptionAmountSync(account.ReferenceUUID); err != nil {
+                log.Printf("CRITICAL: Plans removed for %s but subscription %s still charges the old amount: %v",
+                    account.Username, subscriptionID, err)
+            }
+        }
+    }
+
+    go func() {
+        if emailErr := h.sendPlanRemovalEmail(account, &response); emailErr != nil {
+            log.Printf("Warning: Failed to send plan removal email: %v", emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: response.Message,
+        Data:    response,
+    })
+}
+
+// prepareRemoval valida a requisição e calcula a remoção. Em caso de erro já respondeu.
+func (h *AddPlansHandler) prepareRemoval(w http.ResponseWriter, r *http.Request) (*planRemoval, *RemovePlansRequest, bool) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found")
+        return nil, nil, false
+    }
+
+    if !user.IsMaster {
+        utils.SendErrorResponse(w, http.StatusForbidden, "Only master accounts can remove plans")
+        return nil, nil, false
+    }
+
+    var req RemovePlansRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+        return nil, nil, false
+    }
+
+    if len(req.Cart) == 0 {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "No plans selected for removal")
+        return nil, nil, false
+    }
+
+    if req.CreditMethod == "" {
+        req.CreditMethod = CreditMethodAccount
+    }
+    if req.CreditMethod != CreditMethodAccount && req.CreditMethod != CreditMethodRefund {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "credit_method must be 'credit' or 'refund'")
+        return nil, nil, false
+    }
+
+    account, err := h.getMasterAccountData(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for user %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Account not found")
+        return nil, nil, false
+    }
+
+    // Assinatura cancelada ou pausada não tem ARB para reduzir
+    if !h.subscriptionChangeAllowed(w, account.ReferenceUUID) {
+        return nil, nil, false
+    }
+
+    isAnnual, err := h.isAnnualUser(account.PurchasedPlans)
+    if err != nil {
+        log.Printf("Error determining billing type for user %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Error determining billing type")
+        return nil, nil, false
+    }
+
+    var existingPlans []PurchasedPlan
+    if account.PurchasedPlans != "" {
+        if err := json.Unmarshal([]byte(account.PurchasedPlans), &existingPlans); err != nil {
+            log.Printf("Error parsing purchased plans for %s: %v", user.Username, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read account plans")
+            return nil, nil, false
+        }
+    }
+
+    remaining, removedUsers, err := selectPlansToRemove(existingPlans, req.Cart, req.Usernames)
+    if err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+        return nil, nil, false
+    }
+
+    // Mesma matemática de datas da adição: o pro-rata dos dias restantes é o crédito
+    calculations, credit, decrease, err := h.calculatePlansFromDatabase(req.Cart, isAnnual, account.RenewDate, account.Currency)
+    if err != nil {
+        log.Printf("Error calculating plan removal for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+        return nil, nil, false
+    }
+
+    newTotal := utils.Round(account.TotalPrice - decrease)
+    if newTotal < minimumSubscriptionCharge {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Cannot remove all paid plans, cancel the subscription instead")
+        return nil, nil, false
+    }
+
+    removal := &planRemoval{
+        account:         account,
+        isAnnual:        isAnnual,
+        isTrial:         account.IsTrial == 1,
+        remainingPlans:  remaining,
+        removedCount:    len(existingPlans) - len(remaining),
+        removedUsers:    removedUsers,
+        calculations:    calculations,
+        credit:          credit,
+        monthlyDecrease: decrease,
+        newTotal:        newTotal,
+    }
+    if removal.isTrial {
+        removal.credit = 0
+    }
+
+    return removal, &req, true
+}
+
+// selectPlansToRemove escolhe as entradas de purchased_plans a remover: primeiro os
+// usuários pedidos, depois as vagas não atribuídas. O plano do master nunca é removido.
+func selectPlansToRemove(plans []PurchasedPlan, cart []CartPlan, usernames []string) ([]PurchasedPlan, []string, error) {
+    requested := make(map[string]bool)
+    for _, username := range usernames {
+        if username = strings.TrimSpace(username); username != "" {
+            requested[username] = true
+        }
+    }
+
+    removed := make([]bool, len(plans))
+    var removedUsers []string
+
+    for _, item := range cart {
+        if item.Quantity <= 0 {
+            return nil, nil, fmt.Errorf("invalid quantity for plan %d", item.PlanID)
+        }
+
+        pending := item.Quantity
+
+        // Usuários pedidos explicitamente
+        for i, plan := range plans {
+            if pending == 0 {
+                break
+            }
+            if !removed[i] && plan.PlanID == item.PlanID && plan.IsMaster == 0 && requested[plan.Username] {
+                removed[i] = true
+                removedUsers = append(removedUsers, plan.Username)
+                delete(requested, plan.Username)
+                pending--
+            }
+        }
+
+        // Vagas não atribuídas, das mais recentes para as mais antigas
+        for i := len(plans) - 1; i >= 0 && pending > 0; i-- {
+            if !removed[i] && plans[i].PlanID == item.PlanID && plans[i].IsMaster == 0 && isUnassignedPlan(plans[i]) {
+                removed[i] = true
+                pending--
+            }
+        }
+
+        if pending > 0 {
+            return nil, nil, fmt.Errorf("plan %d: %d more in-use plan(s) must be removed, select which users to remove", item.PlanID, pending)
+        }
+    }
+
+    for username := range requested {
+        return nil, nil, fmt.Errorf("user %s is not on a plan being removed", username)
+    }
+
+    remaining := make([]PurchasedPlan, 0, len(plans))
+    for i, plan := range plans {
+        if !removed[i] {
+            remaining = append(remaining, plan)
+        }
+    }
+
+    return remaining, removedUsers, nil
+}
+
+func isUnassignedPlan(plan PurchasedPlan) bool {
+    return plan.Username == "" || plan.Username == "none"
+}
+
+func removalUserType(removal *planRemoval) string {
+    if removal.isTrial {
+        return "trial"
+    } else if removal.isAnnual {
+        return "annual"
+    }
+    return "monthly"
+}
+
+// nextCycleDate retorna a próxima data de cobrança a partir de renewDate
+func nextCycleDate(renewDate time.Time, isAnnual bool) time.Time {
+    months := 1
+    if isAnnual {
+        months = 12
+    }
+
+    cycle := renewDate
+    for !cycle.After(time.Now()) {
+        cycle = cycle.AddDate(0, months, 0)
+    }
+    return cycle
+}
+
+// removalCredits distribui o crédito pelos próximos ciclos, abatendo em cada um no máximo
+// o valor da cobrança menos minimumSubscriptionCharge
+func (h *AddPlansHandler) removalCredits(removal *planRemoval) ([]database.AccountCredit, error) {
+    months := 1
+    if removal.isAnnual {
+        months = 12
+    }
+
+    cycle := nextCycleDate(removal.account.RenewDate, removal.isAnnual)
+    remaining := removal.credit
+
+    var credits []database.AccountCredit
+    for i := 0; i < maxCreditCycles && remaining > 0; i++ {
+        scheduled, err := h.db.GetScheduledCreditTotal(removal.account.ReferenceUUID, cycle)
+        if err != nil {
+            return nil, err
+        }
+
+        room := utils.Round(removal.newTotal - minimumSubscriptionCharge - scheduled)
+        if room > 0 {
+            portion := math.Min(remaining, room)
+            credits = append(credits, database.AccountCredit{
+                MasterReference: removal.account.ReferenceUUID,
+                Amount:          utils.Round(portion),
+                Reason:          "plan_removal",
+                CycleDate:       cycle,
+            })
+            remaining = utils.Round(remaining - portion)
+        }
+
+        cycle = cycle.AddDate(0, months, 0)
+    }
+
+    if remaining > 0 {
+        log.Printf("Warning: $%.2f of credit for %s could not be scheduled within %d cycles",
+            remaining, removal.account.ReferenceUUID, maxCreditCycles)
+    }
+
+    return credits, nil
+}
+
+// prepareRemovalRefund escolhe a cobrança mais recente com saldo suficiente para o
+// estorno. A reserva é feita por ApplyPlanRemoval, junto com a remoção.
+func (h *AddPlansHandler) prepareRemovalRefund(account *models.MasterAccount, amount float64, requestedBy string) (*database.Refund, string, error) {
+    original, err := h.db.GetLatestRefundableTransaction(account.ReferenceUUID, amount)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, "", fmt.Errorf("no charge with enough refundable balance")
+        }
+        return nil, "", err
+    }
+
+    cardLastFour, err := h.db.GetCardLastFour(account.ReferenceUUID)
+    if err != nil {
+        return nil, "", err
+    }
+
+    return &database.Refund{
+        OriginalTransactionID: original.TransactionID,
+        MasterReference:       account.ReferenceUUID,
+        Amount:                amount,
+        Reason:                "Plan removal prorated credit",
+        RequestedBy:           requestedBy,
+    }, cardLastFour, nil
+}
+
+// refundRemovalCredit faz no gateway o estorno já reservado por ApplyPlanRemoval
+func (h *AddPlansHandler) refundRemovalCredit(refund *database.Refund, cardLastFour string) (string, error) {
+    refundTransID, err := h.paymentService.RefundTransaction(refund.OriginalTransactionID, refund.Amount, cardLastFour)
+    if err != nil {
+        if failErr := h.db.FailRefund(refund.ID, err.Error()); failErr != nil {
+            log.Printf("Error marking refund %d as failed: %v", refund.ID, failErr)
+        }
+        return "", err
+    }
+
+    if _, _, err := h.db.CompleteRefund(refund.ID, refundTransID, refund.OriginalTransactionID); err != nil {
+        // O estorno já foi feito no gateway; não cair para o crédito de conta
+        log.Printf("CRITICAL: Refund %s for transaction %s succeeded but was not recorded: %v",
+            refundTransID, refund.OriginalTransactionID, err)
+    }
+
+    log.Printf("Refunded $%.2f of transaction %s for plan removal (refund %s)",
+        refund.Amount, refund.OriginalTransactionID, refundTransID)
+    return refundTransID, nil
+}
+
+// updateARBAfterRemoval ajusta a ARB ao novo total menos o crédito do próximo ciclo, com
+// imposto, e retorna o valor da próxima cobrança (mesmo quando o gateway recusa a alteração)
+func (h *AddPlansHandler) updateARBAfterRemoval(masterReference, subscriptionID string, newTotal float64, rate tax.Rate) (float64, error) {
+    credit, err := h.db.GetUpcomingCreditTotal(masterReference)
+    if err != nil {
+        return 0, err
+    }
+
+    nextCharge := rate.Gross(newTotal - credit)
+    if err := h.paymentService.UpdateSubscriptionAmount(subscriptionID, nextCharge); err != nil {
+        return nextCharge, err
+    }
+
+    return nextCharge, nil
+}
+
+// scheduleSubscriptionAmountSync enfileira o acerto do valor da ARB quando a alteração
+// falhou na requisição; o worker recalcula o valor a partir da conta
+func (h *AddPlansHandler) scheduleSubscriptionAmountSync(masterReference string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    return h.queue.Enqueue(ctx, queue.JobTypeSyncSubscriptionAmount, map[string]interface{}{
+        "master_reference": masterReference,
+    })
+}
+
+func (h *AddPlansHandler) sendPlanRemovalEmail(account *models.MasterAccount, response *RemovePlansResponse) error {
+    plansTable := `<table class="plans-table">
+        <thead>
+            <tr>
+                <th>Plan Name</th>
+                <th>Quantity Removed</th>
+                <th>Credit</th>
+            </tr>
+        </thead>
+        <tbody>`
+
+    for _, plan := range response.PlanDetails {
+        plansTable += fmt.Sprintf(`
+            <tr>
+                <td>%s</td>
+                <td>%d</td>
+                <td>%s</td>
+            </tr>`,
+            plan.PlanName,
+            plan.Quantity,
+            models.FormatMoney(plan.TotalProRata, account.Currency),
+        )
+    }
+    plansTable += `</tbody></table>`
+
+    creditLine := fmt.Sprintf("<p><strong>Credit:</strong> %s (Free trial)</p>", models.FormatMoney(0, account.Currency))
+    if !response.IsTrial {
+        if response.CreditMethod == CreditMethodRefund {
+            creditLine = fmt.Sprintf("<p><strong>Refunded to your card:</strong> %s</p>", models.FormatMoney(response.CreditAmount, account.Currency))
+        } else {
+            creditLine = fmt.Sprintf("<p><strong>Credit applied to your next bills:</strong> %s</p>", models.FormatMoney(response.CreditAmount, account.Currency))
+        }
+    }
+
+    emailContent := fmt.Sprintf(`
+        <h2>Plans Removed From Your Account</h2>
+        <p>Hello %s!</p>
+        <p>The following plans were removed from your ProSecureLSP account.</p>
+        %s
+        %s
+        <p><strong>New recurring total:</strong> %s</p>
+        <p><strong>Next charge:</strong> %s</p>
+    `, account.Name, plansTable, creditLine, models.FormatMoney(response.NewMonthlyTotal, account.Currency),
+        models.FormatMoney(response.NextCharge, account.Currency))
+
+    return h.emailService.SendEmail(
+        account.Email,
+        "Plans Removed - ProSecureLSP",
+        emailContent,
+    )
+}
@@ -0,0 +1,416 @@
+// handlers/subscription.go - Autoatendimento da assinatura (cancelamento no fim do período)
+// A pausa/retomada fica em subscription_pause.go
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/services/email"
+    "prosecure-payment-api/services/payment"
+    "prosecure-payment-api/utils"
+)
+
+// Motivos de cancelamento aceitos (usados na análise de churn)
+var cancellationReasons = map[string]string{
+    "too_expensive":    "Too expensive",
+    "not_using":        "Not using it enough",
+    "missing_features": "Missing features",
+    "switching":        "Switching to another service",
+    "technical_issues": "Technical issues",
+    "customer_service": "Customer service",
+    "other":            "Other",
+}
+
+const maxCancellationCommentLength = 1000
+
+type SubscriptionHandler struct {
+    db             *database.Connection
+    paymentService *payment.Service
+    emailService   *email.SMTPService
+    queue          *queue.Queue
+}
+
+type CancelSubscriptionRequest struct {
+    Reason  string `json:"reason"`
+    Comment string `json:"comment"`
+}
+
+// NewSubscriptionHandler cria um novo handler de autoatendimento da assinatura
+func NewSubscriptionHandler(db *database.Connection, ps *payment.Service, es *email.SMTPService, q *queue.Queue) *SubscriptionHandler {
+    return &SubscriptionHandler{
+        db:             db,
+        paymentService: ps,
+        emailService:   es,
+        queue:          q,
+    }
+}
+
+// GetCancellation retorna o cancelamento agendado da conta, se houver
+func (h *SubscriptionHandler) GetCancellation(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+        return
+    }
+
+    master, err := h.getMasterAccountByUser(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Master account not found")
+        return
+    }
+
+    cancellation, err := h.db.GetScheduledCancellation(master.ReferenceUUID)
+    if err != nil && err != sql.ErrNoRows {
+        log.Printf("Error getting cancellation for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve cancellation")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Cancellation status retrieved",
+        Data: map[string]interface{}{
+            "cancellation_scheduled": cancellation != nil,
+            "cancellation":           cancellation,
+            "renew_date":             master.RenewDate.Format("2006-01-02"),
+        },
+    })
+}
+
+// CancelSubscription cancela a assinatura ARB. O acesso continua até renew_date, quando
+// o worker desativa os usuários da conta.
+func (h *SubscriptionHandler) CancelSubscription(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+        return
+    }
+
+    var req CancelSubscriptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request format")
+        return
+    }
+
+    req.Reason = strings.TrimSpace(req.Reason)
+    req.Comment = strings.TrimSpace(req.Comment)
+
+    if _, ok := cancellationReasons[req.Reason]; !ok {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "A valid cancellation reason is required")
+        return
+    }
+
+    if len(req.Comment) > maxCancellationCommentLength {
+        utils.SendErrorResponse(w, http.StatusBadRequest,
+            fmt.Sprintf("Comment must be at most %d characters", maxCancellationCommentLength))
+        return
+    }
+
+    master, err := h.getMasterAccountByUser(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Master account not found")
+        return
+    }
+
+    if _, err := h.db.GetScheduledCancellation(master.ReferenceUUID); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscription cancellation is already scheduled")
+        return
+    } else if err != sql.ErrNoRows {
+        log.Printf("Error checking cancellation for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    // Durante a pausa a ARB só começa depois da retomada e os usuários estão suspensos
+    if _, err := h.db.GetActivePause(master.ReferenceUUID); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscription is paused, resume it before cancelling")
+        return
+    }
+
+    subscriptionID, err := h.db.GetActiveSubscriptionID(master.ReferenceUUID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusConflict, "No active subscription found")
+            return
+        }
+        log.Printf("Error getting subscription for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    // O acesso vai até o fim do período já pago
+    effectiveAt := master.RenewDate
+    if effectiveAt.Before(time.Now()) {
+        effectiveAt = time.Now()
+    }
+
+    log.Printf("User %s cancelling subscription %s (reason: %s, access until %s)",
+        user.Username, subscriptionID, req.Reason, effectiveAt.Format("2006-01-02"))
+
+    if err := h.paymentService.CancelSubscription(subscriptionID); err != nil {
+        log.Printf("Error cancelling subscription %s: %v", subscriptionID, err)
+        utils.SendErrorResponse(w, http.StatusBadGateway, "Failed to cancel subscription, please try again")
+        return
+    }
+
+    cancellation := &database.SubscriptionCancellation{
+        MasterReference: master.ReferenceUUID,
+        SubscriptionID:  subscriptionID,
+        RequestedBy:     user.Username,
+        Reason:          req.Reason,
+        Comment:         req.Comment,
+        EffectiveAt:     effectiveAt,
+    }
+
+    if err := h.db.CreateSubscriptionCancellation(cancellation); err != nil {
+        // A ARB já foi cancelada; repetir a requisição é seguro (cancelar de novo não é erro)
+        log.Printf("CRITICAL: Subscription %s cancelled but not recorded: %v", subscriptionID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to record cancellation, please try again")
+        return
+    }
+
+    go func() {
+        if emailErr := h.sendCancellationEmail(master, cancellation); emailErr != nil {
+            log.Printf("Warning: Failed to send cancellation email to %s: %v", master.Email, emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Subscription cancelled. Your access continues until the end of the current billing period.",
+        Data: map[string]interface{}{
+            "cancellation": cancellation,
+            "access_until": effectiveAt.Format("2006-01-02"),
+        },
+    })
+}
+
+// UndoCancellation desfaz um cancelamento antes do fim do período, criando uma nova
+// assinatura ARB sobre o customer profile com primeira cobrança em renew_date
+func (h *SubscriptionHandler) UndoCancellation(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+        return
+    }
+
+    master, err := h.getMasterAccountByUser(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Master account not found")
+        return
+    }
+
+    cancellation, err := h.db.GetScheduledCancellation(master.ReferenceUUID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusNotFound, "No scheduled cancellation found")
+            return
+        }
+        log.Printf("Error getting cancellation for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    // A ARB exige início a partir de hoje; depois do fim do período o cliente assina de novo
+    if !cancellation.EffectiveAt.After(time.Now()) {
+        utils.SendErrorResponse(w, http.StatusConflict, "The billing period has already ended")
+        return
+    }
+
+    profile, err := h.db.GetCustomerProfile(master.ReferenceUUID)
+    if err != nil || profile.AuthorizeCustomerProfileID == "" || profile.AuthorizePaymentProfileID == "" {
+        log.Printf("No customer profile to resume subscription for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "No payment method on file, please update your card")
+        return
+    }
+
+    intervalMonths := 1
+    if master.IsAnnually == 1 {
+        intervalMonths = 12
+    }
+
+    // Créditos agendados (cupons recorrentes, saldo a favor) continuam valendo: a primeira
+    // cobrança sai com o crédito do ciclo abatido, como na ARB cancelada
+    credit, err := h.db.GetUpcomingCreditTotal(master.ReferenceUUID)
+    if err != nil {
+        log.Printf("Error getting upcoming credits for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    // A ARB não tem campo de imposto: o valor recorrente já o inclui
+    amount := h.db.AccountTaxRate(master).Gross(master.TotalPrice - credit)
+
+    log.Printf("User %s undoing cancellation %d: new subscription of $%.2f every %d month(s) from %s",
+        user.Username, cancellation.ID, amount, intervalMonths, cancellation.EffectiveAt.Format("2006-01-02"))
+
+    newSubscriptionID, err := h.paymentService.CreateScheduledSubscription(
+        profile.AuthorizeCustomerProfileID, profile.AuthorizePaymentProfileID,
+        amount, intervalMonths, cancellation.EffectiveAt)
+    if err != nil {
+        log.Printf("Error creating subscription to undo cancellation %d: %v", cancellation.ID, err)
+        utils.SendErrorResponse(w, http.StatusBadGateway, "Failed to reactivate subscription, please try again")
+        return
+    }
+
+    if err := h.db.UndoSubscriptionCancellation(cancellation, newSubscriptionID); err != nil {
+        log.Printf("Error recording undo of cancellation %d: %v", cancellation.ID, err)
+
+        // Não deixar uma assinatura cobrando sem registro local
+        h.cancelSubscriptionOrRetry(master.ReferenceUUID, newSubscriptionID)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reactivate subscription, please try again")
+        return
+    }
+
+    go func() {
+        if emailErr := h.sendReactivationEmail(master, cancellation, amount); emailErr != nil {
+            log.Printf("Warning: Failed to send reactivation email to %s: %v", master.Email, emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Subscription cancellation undone",
+        Data: map[string]interface{}{
+            "subscription_id":   newSubscriptionID,
+            "next_billing_date": cancellation.EffectiveAt.Format("2006-01-02"),
+            "next_charge":       amount,
+        },
+    })
+}
+
+// GetCancellationStats agrupa os cancelamentos por motivo (análise de churn)
+func (h *SubscriptionHandler) GetCancellationStats(w http.ResponseWriter, r *http.Request) {
+    // Padrão: últimos 30 dias
+    to := time.Now().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+    from := to.AddDate(0, 0, -30)
+
+    if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+        parsed, err := time.Parse("2006-01-02", fromStr)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+            return
+        }
+        from = parsed
+    }
+
+    if toStr := r.URL.Query().Get("to"); toStr != "" {
+        parsed, err := time.Parse("2006-01-02", toStr)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+            return
+        }
+        to = parsed.AddDate(0, 0, 1)
+    }
+
+    stats, err := h.db.GetCancellationReasonStats(from, to)
+    if err != nil {
+        log.Printf("Error getting cancellation stats: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve cancellation stats")
+        return
+    }
+
+    total := 0
+    for _, stat := range stats {
+        total += stat.Total
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Cancellation stats retrieved successfully",
+        Data: map[string]interface{}{
+            "from":    from.Format("2006-01-02"),
+            "to":      to.AddDate(0, 0, -1).Format("2006-01-02"),
+            "total":   total,
+            "reasons": stats,
+        },
+    })
+}
+
+func (h *SubscriptionHandler) getMasterAccountByUser(username, email string) (*models.MasterAccount, error) {
+    query := `
+        SELECT reference_uuid, name, lname, email, username, total_price,
+               is_annually, plan, purchased_plans, simultaneus_users, renew_date,
+               COALESCE(is_trial, 0), COALESCE(currency, 'USD')
+        FROM master_accounts
+        WHERE username = ? AND email = ?
+    `
+
+    var account models.MasterAccount
+    err := h.db.GetDB().QueryRow(query, username, email).Scan(
+        &account.ReferenceUUID,
+        &account.Name,
+        &account.LastName,
+        &account.Email,
+        &account.Username,
+        &account.TotalPrice,
+        &account.IsAnnually,
+        &account.Plan,
+        &account.PurchasedPlans,
+        &account.SimultaneousUsers,
+        &account.RenewDate,
+        &account.IsTrial,
+        &account.Currency,
+    )
+
+    return &account, err
+}
+
+func (h *SubscriptionHandler) sendCancellationEmail(master *models.MasterAccount, cancellation *database.SubscriptionCancellation) error {
+    subject := "Your Subscription Has Been Cancelled - ProSecureLSP"
+    body := fmt.Sprintf(`
+        <div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+            <img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+            <h1 style="color:#fff">Your Subscription Has Been Cancelled</h1>
+            <div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+                Hi %s,<br><br>
+                We're sorry to see you go. Your subscription has been cancelled and you will not be charged again.<br><br>
+                <strong>Access Until:</strong> %s<br>
+                <strong>Reason:</strong> %s<br><br>
+                You and your users keep full access until the end of the current billing period.
+                Changed your mind? You can undo the cancellation from your dashboard before that date.
+            </div>
+            <a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+               href="https://prosecurelsp.com/users">
+                <strong>Login to Your Account</strong>
+            </a>
+        </div>
+    `, master.Name, cancellation.EffectiveAt.Format("January 2, 2006"), cancellationReasons[cancellation.Reason])
+
+    return h.emailService.SendEmail(master.Email, subject, body)
+}
+
+func (h *SubscriptionHandler) sendReactivationEmail(master *models.MasterAccount, cancellation *database.SubscriptionCancellation, amount float64) error {
+    subject := "Your Subscription Has Been Reactivated - ProSecureLSP"
+    body := fmt.Sprintf(`
+        <div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+            <img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+            <h1 style="color:#fff">Welcome Back!</h1>
+            <div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+                Hi %s,<br><br>
+                Your subscription cancellation has been undone and your plan continues as before.<br><br>
+                <strong>Next Billing Date:</strong> %s<br>
+                <strong>Amount:</strong> %s
+            </div>
+            <a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+               href="https://prosecurelsp.com/users">
+                <strong>Login to Your Account</strong>
+            </a>
+        </div>
+    `, master.Name, cancellation.EffectiveAt.Format("January 2, 2006"), models.FormatMoney(amount, master.Currency))
+
+    return h.emailService.SendEmail(master.Email, subject, body)
+}
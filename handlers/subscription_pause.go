@@ -0,0 +1,368 @@
+// handlers/subscription_pause.go - Pausa da assinatura pelo cliente (férias) e retomada
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/utils"
+)
+
+// Duração aceita para uma pausa, em meses
+const (
+    minPauseMonths = 1
+    maxPauseMonths = 3
+)
+
+type PauseSubscriptionRequest struct {
+    Months int `json:"months"`
+}
+
+// GetPause retorna a pausa em andamento da conta, se houver
+func (h *SubscriptionHandler) GetPause(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+        return
+    }
+
+    master, err := h.getMasterAccountByUser(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Master account not found")
+        return
+    }
+
+    pause, err := h.db.GetActivePause(master.ReferenceUUID)
+    if err != nil && err != sql.ErrNoRows {
+        log.Printf("Error getting pause for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve pause")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Pause status retrieved",
+        Data: map[string]interface{}{
+            "paused":     pause != nil,
+            "pause":      pause,
+            "renew_date": master.RenewDate.Format("2006-01-02"),
+        },
+    })
+}
+
+// PauseSubscription pausa a assinatura por 1 a 3 meses. A ARB atual é substituída por uma
+// que começa no fim do período pago somado à pausa; os usuários ficam suspensos até a
+// retomada, automática em resume_at ou antecipada pelo cliente.
+func (h *SubscriptionHandler) PauseSubscription(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+        return
+    }
+
+    var req PauseSubscriptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request format")
+        return
+    }
+
+    if req.Months < minPauseMonths || req.Months > maxPauseMonths {
+        utils.SendErrorResponse(w, http.StatusBadRequest,
+            fmt.Sprintf("months must be between %d and %d", minPauseMonths, maxPauseMonths))
+        return
+    }
+
+    master, err := h.getMasterAccountByUser(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Master account not found")
+        return
+    }
+
+    if master.IsTrial == 1 {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscriptions in the free trial cannot be paused")
+        return
+    }
+
+    if _, err := h.db.GetActivePause(master.ReferenceUUID); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscription is already paused")
+        return
+    } else if err != sql.ErrNoRows {
+        log.Printf("Error checking pause for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    if _, err := h.db.GetScheduledCancellation(master.ReferenceUUID); err == nil {
+        utils.SendErrorResponse(w, http.StatusConflict, "Subscription cancellation is scheduled, undo it before pausing")
+        return
+    }
+
+    subscriptionID, err := h.db.GetActiveSubscriptionID(master.ReferenceUUID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusConflict, "No active subscription found")
+            return
+        }
+        log.Printf("Error getting subscription for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    profile, err := h.db.GetCustomerProfile(master.ReferenceUUID)
+    if err != nil || profile.AuthorizeCustomerProfileID == "" || profile.AuthorizePaymentProfileID == "" {
+        log.Printf("No customer profile to pause subscription for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "No payment method on file, please update your card")
+        return
+    }
+
+    isAnnual := master.IsAnnually == 1
+    intervalMonths := 1
+    if isAnnual {
+        intervalMonths = 12
+    }
+
+    // O período já pago continua valendo depois da pausa
+    now := time.Now()
+    periodEnd := nextCycleDate(master.RenewDate, isAnnual)
+    pause := &database.SubscriptionPause{
+        MasterReference:        master.ReferenceUUID,
+        PreviousSubscriptionID: subscriptionID,
+        RequestedBy:            user.Username,
+        Months:                 req.Months,
+        PausedAt:               now,
+        ResumeAt:               now.AddDate(0, req.Months, 0),
+        OriginalRenewDate:      periodEnd,
+        RenewDate:              periodEnd.AddDate(0, req.Months, 0),
+    }
+
+    amount, err := h.nextChargeAmount(master, periodEnd)
+    if err != nil {
+        log.Printf("Error calculating next charge for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    log.Printf("User %s pausing subscription %s for %d month(s): resume %s, next charge $%.2f on %s",
+        user.Username, subscriptionID, req.Months, pause.ResumeAt.Format("2006-01-02"),
+        amount, pause.RenewDate.Format("2006-01-02"))
+
+    // A ARB não suspende cobranças: a nova assinatura só começa depois da pausa
+    pause.SubscriptionID, err = h.paymentService.CreateScheduledSubscription(
+        profile.AuthorizeCustomerProfileID, profile.AuthorizePaymentProfileID,
+        amount, intervalMonths, pause.RenewDate)
+    if err != nil {
+        log.Printf("Error creating subscription to pause %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusBadGateway, "Failed to pause subscription, please try again")
+        return
+    }
+
+    if err := h.db.CreateSubscriptionPause(pause); err != nil {
+        log.Printf("Error recording pause for %s: %v", master.ReferenceUUID, err)
+
+        // Não deixar uma assinatura cobrando sem registro local
+        h.cancelSubscriptionOrRetry(master.ReferenceUUID, pause.SubscriptionID)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to pause subscription, please try again")
+        return
+    }
+
+    // A ARB antiga continuaria cobrando durante a pausa
+    h.cancelSubscriptionOrRetry(master.ReferenceUUID, subscriptionID)
+
+    go func() {
+        if emailErr := h.sendPauseEmail(master, pause); emailErr != nil {
+            log.Printf("Warning: Failed to send pause email to %s: %v", master.Email, emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Subscription paused",
+        Data: map[string]interface{}{
+            "pause":             pause,
+            "resume_date":       pause.ResumeAt.Format("2006-01-02"),
+            "next_billing_date": pause.RenewDate.Format("2006-01-02"),
+        },
+    })
+}
+
+// ResumeSubscription retoma a assinatura antes da data escolhida. renew_date passa a ser o
+// fim do período pago somado apenas aos dias efetivamente pausados.
+func (h *SubscriptionHandler) ResumeSubscription(w http.ResponseWriter, r *http.Request) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+        return
+    }
+
+    master, err := h.getMasterAccountByUser(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Master account not found")
+        return
+    }
+
+    pause, err := h.db.GetActivePause(master.ReferenceUUID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            utils.SendErrorResponse(w, http.StatusNotFound, "Subscription is not paused")
+            return
+        }
+        log.Printf("Error getting pause for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    pausedDays := int(time.Since(pause.PausedAt).Hours() / 24)
+    renewDate := pause.OriginalRenewDate.AddDate(0, 0, pausedDays)
+
+    // A pausa já chegou ao fim: basta concluí-la com a ARB que já existe
+    if !renewDate.Before(pause.RenewDate) {
+        if err := h.db.ResumeSubscriptionPause(pause, "", pause.RenewDate); err != nil {
+            log.Printf("Error resuming pause %d: %v", pause.ID, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resume subscription, please try again")
+            return
+        }
+        h.sendResumeResponse(w, master, pause)
+        return
+    }
+
+    profile, err := h.db.GetCustomerProfile(master.ReferenceUUID)
+    if err != nil || profile.AuthorizeCustomerProfileID == "" || profile.AuthorizePaymentProfileID == "" {
+        log.Printf("No customer profile to resume subscription for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "No payment method on file, please update your card")
+        return
+    }
+
+    intervalMonths := 1
+    if master.IsAnnually == 1 {
+        intervalMonths = 12
+    }
+
+    amount, err := h.nextChargeAmount(master, pause.RenewDate)
+    if err != nil {
+        log.Printf("Error calculating next charge for %s: %v", master.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    log.Printf("User %s resuming pause %d early: next charge $%.2f on %s",
+        user.Username, pause.ID, amount, renewDate.Format("2006-01-02"))
+
+    newSubscriptionID, err := h.paymentService.CreateScheduledSubscription(
+        profile.AuthorizeCustomerProfileID, profile.AuthorizePaymentProfileID,
+        amount, intervalMonths, renewDate)
+    if err != nil {
+        log.Printf("Error creating subscription to resume pause %d: %v", pause.ID, err)
+        utils.SendErrorResponse(w, http.StatusBadGateway, "Failed to resume subscription, please try again")
+        return
+    }
+
+    pausedSubscriptionID := pause.SubscriptionID
+    if err := h.db.ResumeSubscriptionPause(pause, newSubscriptionID, renewDate); err != nil {
+        log.Printf("Error recording resume of pause %d: %v", pause.ID, err)
+
+        h.cancelSubscriptionOrRetry(master.ReferenceUUID, newSubscriptionID)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resume subscription, please try again")
+        return
+    }
+
+    h.cancelSubscriptionOrRetry(master.ReferenceUUID, pausedSubscriptionID)
+
+    h.sendResumeResponse(w, master, pause)
+}
+
+// cancelSubscriptionOrRetry cancela a ARB; se o gateway falhar, o cancelamento vai para
+// a fila e é repetido até a assinatura ficar cancelada
+func (h *SubscriptionHandler) cancelSubscriptionOrRetry(masterReference, subscriptionID string) {
+    err := h.paymentService.CancelSubscription(subscriptionID)
+    if err == nil {
+        return
+    }
+
+    log.Printf("Error cancelling subscription %s of %s, scheduling retry: %v", subscriptionID, masterReference, err)
+    if err := scheduleSubscriptionCancel(h.queue, masterReference, subscriptionID); err != nil {
+        log.Printf("CRITICAL: Subscription %s of %s could not be cancelled: %v", subscriptionID, masterReference, err)
+    }
+}
+
+func (h *SubscriptionHandler) sendResumeResponse(w http.ResponseWriter, master *models.MasterAccount, pause *database.SubscriptionPause) {
+    go func() {
+        if emailErr := h.sendResumeEmail(master, pause); emailErr != nil {
+            log.Printf("Warning: Failed to send resume email to %s: %v", master.Email, emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Subscription resumed",
+        Data: map[string]interface{}{
+            "subscription_id":   pause.SubscriptionID,
+            "next_billing_date": pause.RenewDate.Format("2006-01-02"),
+        },
+    })
+}
+
+// nextChargeAmount é o valor da primeira cobrança em cycleDate, descontados os créditos
+// de conta agendados para esse ciclo
+func (h *SubscriptionHandler) nextChargeAmount(master *models.MasterAccount, cycleDate time.Time) (float64, error) {
+    credit, err := h.db.GetScheduledCreditTotal(master.ReferenceUUID, cycleDate)
+    if err != nil {
+        return 0, err
+    }
+
+    return h.db.AccountTaxRate(master).Gross(master.TotalPrice - credit), nil
+}
+
+func (h *SubscriptionHandler) sendPauseEmail(master *models.MasterAccount, pause *database.SubscriptionPause) error {
+    subject := "Your Subscription Is Paused - ProSecureLSP"
+    body := fmt.Sprintf(`
+        <div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+            <img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+            <h1 style="color:#fff">Your Subscription Is Paused</h1>
+            <div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+                Hi %s,<br><br>
+                Your subscription is paused and you will not be charged while it is on hold.<br><br>
+                <strong>Resumes On:</strong> %s<br>
+                <strong>Next Billing Date:</strong> %s<br><br>
+                The time left in your current billing period is kept for when the service resumes.
+                You can resume earlier at any time from your dashboard.
+            </div>
+            <a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+               href="https://prosecurelsp.com/users">
+                <strong>Login to Your Account</strong>
+            </a>
+        </div>
+    `, master.Name, pause.ResumeAt.Format("January 2, 2006"), pause.RenewDate.Format("January 2, 2006"))
+
+    return h.emailService.SendEmail(master.Email, subject, body)
+}
+
+func (h *SubscriptionHandler) sendResumeEmail(master *models.MasterAccount, pause *database.SubscriptionPause) error {
+    subject := "Your Subscription Has Resumed - ProSecureLSP"
+    body := fmt.Sprintf(`
+        <div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+            <img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+            <h1 style="color:#fff">Welcome Back!</h1>
+            <div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+                Hi %s,<br><br>
+                Your subscription has resumed and your users have their access back.<br><br>
+                <strong>Next Billing Date:</strong> %s
+            </div>
+            <a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+               href="https://prosecurelsp.com/users">
+                <strong>Login to Your Account</strong>
+            </a>
+        </div>
+    `, master.Name, pause.RenewDate.Format("January 2, 2006"))
+
+    return h.emailService.SendEmail(master.Email, subject, body)
+}
@@ -0,0 +1,53 @@
+// handlers/subscription_trial.go - Conversão dos trials (aprovação da primeira cobrança da ARB)
+package handlers
+
+import (
+    "log"
+    "net/http"
+    "time"
+
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/utils"
+)
+
+// GetTrialStats resume a conversão dos trials terminados no período
+func (h *SubscriptionHandler) GetTrialStats(w http.ResponseWriter, r *http.Request) {
+    // Padrão: últimos 30 dias
+    to := time.Now().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+    from := to.AddDate(0, 0, -30)
+
+    if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+        parsed, err := time.Parse("2006-01-02", fromStr)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+            return
+        }
+        from = parsed
+    }
+
+    if toStr := r.URL.Query().Get("to"); toStr != "" {
+        parsed, err := time.Parse("2006-01-02", toStr)
+        if err != nil {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+            return
+        }
+        to = parsed.AddDate(0, 0, 1)
+    }
+
+    stats, err := h.db.GetTrialConversionStats(from, to)
+    if err != nil {
+        log.Printf("Error getting trial stats: %v", err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve trial stats")
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: "Trial stats retrieved successfully",
+        Data: map[string]interface{}{
+            "from":   from.Format("2006-01-02"),
+            "to":     to.AddDate(0, 0, -1).Format("2006-01-02"),
+            "trials": stats,
+        },
+    })
+}
@@ -0,0 +1,517 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/middleware"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/queue"
+    "prosecure-payment-api/services/tax"
+    "prosecure-payment-api/utils"
+)
+
+// Intervalos de cobrança aceitos na troca
+const (
+    BillingIntervalMonthly = "monthly"
+    BillingIntervalAnnual  = "annual"
+)
+
+type SwitchBillingRequest struct {
+    Interval string `json:"interval"` // "monthly" ou "annual"
+    CVV      string `json:"cvv"`
+    // Nonce do Accept.js: cobra um novo cartão em vez de confirmar o CVV do perfil
+    OpaqueData *models.OpaqueData `json:"opaqueData,omitempty"`
+}
+
+type SwitchBillingResponse struct {
+    Success        bool    `json:"success"`
+    Message        string  `json:"message"`
+    Interval       string  `json:"interval"`
+    PreviousTotal  float64 `json:"previous_total"`
+    NewTotal       float64 `json:"new_total"`
+    UnusedCredit   float64 `json:"unused_credit"`  // pro-rata do período atual + créditos pendentes
+    AmountCharged  float64 `json:"amount_charged"` // cobrado agora pelo primeiro ciclo no novo intervalo
+    Tax            float64 `json:"tax"`            // imposto incluído em AmountCharged
+    TaxRate        float64 `json:"tax_rate"`
+    CreditBalance  float64 `json:"credit_balance"` // saldo que sobra para as próximas cobranças
+    NextCharge     float64 `json:"next_charge"`
+    RenewDate      string  `json:"renew_date"`
+    TransactionID  string  `json:"transaction_id,omitempty"`
+    SubscriptionID string  `json:"subscription_id,omitempty"`
+    IsTrial        bool    `json:"is_trial"`
+}
+
+// billingSwitch é o resultado do cálculo de uma troca de intervalo, compartilhado pelo preview
+type billingSwitch struct {
+    account        *models.MasterAccount
+    toAnnual       bool
+    isTrial        bool
+    purchasedPlans string
+    newTotal       float64
+    unusedCredit   float64
+    charge         float64
+    tax            float64 // imposto incluído em charge
+    nextChargeTax  float64 // imposto incluído em nextCharge
+    rate           tax.Rate
+    creditBalance  float64
+    credits        []database.AccountCredit
+    nextCharge     float64
+    renewDate      time.Time
+}
+
+func (s *billingSwitch) intervalMonths() int {
+    if s.toAnnual {
+        return 12
+    }
+    return 1
+}
+
+func (s *billingSwitch) response() SwitchBillingResponse {
+    interval := BillingIntervalMonthly
+    if s.toAnnual {
+        interval = BillingIntervalAnnual
+    }
+
+    return SwitchBillingResponse{
+        Success:       true,
+        Interval:      interval,
+        PreviousTotal: s.account.TotalPrice,
+        NewTotal:      s.newTotal,
+        UnusedCredit:  s.unusedCredit,
+        AmountCharged: s.charge,
+        Tax:           s.tax,
+        TaxRate:       s.rate.Percent,
+        CreditBalance: s.creditBalance,
+        NextCharge:    s.nextCharge,
+        RenewDate:     s.renewDate.Format("2006-01-02"),
+        IsTrial:       s.isTrial,
+    }
+}
+
+// applyTax soma o imposto do endereço de cobrança à cobrança de agora e à próxima
+func (s *billingSwitch) applyTax(rate tax.Rate) {
+    s.rate = rate
+    s.tax = rate.Amount(s.charge)
+    s.charge = utils.Round(s.charge + s.tax)
+    s.nextChargeTax = rate.Amount(s.nextCharge)
+    s.nextCharge = utils.Round(s.nextCharge + s.nextChargeTax)
+}
+
+func (h *AddPlansHandler) PreviewSwitchBilling(w http.ResponseWriter, r *http.Request) {
+    change, _, ok := h.prepareBillingSwitch(w, r)
+    if !ok {
+        return
+    }
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status: "success",
+        Data:   change.response(),
+    })
+}
+
+// SwitchBilling troca a conta entre cobrança mensal e anual no meio do período: o valor
+// não usado do período atual abate o primeiro ciclo no novo intervalo (cobrado agora), a
+// ARB é recriada com o novo intervalo e os flags "anually" de purchased_plans reescritos
+func (h *AddPlansHandler) SwitchBilling(w http.ResponseWriter, r *http.Request) {
+    change, req, ok := h.prepareBillingSwitch(w, r)
+    if !ok {
+        return
+    }
+
+    account := change.account
+
+    // Um segundo envio da mesma troca não pode cobrar de novo
+    acquired, err := h.db.LockAccount(account.ReferenceUUID)
+    if err != nil {
+        log.Printf("Error locking account %s for billing switch: %v", account.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+    if !acquired {
+        utils.SendErrorResponse(w, http.StatusConflict, "Another change to this subscription is in progress")
+        return
+    }
+    defer h.db.ReleaseAccountLock(account.ReferenceUUID)
+
+    if change.charge > 0 {
+        if req.OpaqueData != nil && !req.OpaqueData.Valid() {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "opaqueData requires dataDescriptor and dataValue")
+            return
+        }
+        if req.OpaqueData == nil && (len(req.CVV) < 3 || len(req.CVV) > 4) {
+            utils.SendErrorResponse(w, http.StatusBadRequest, "Valid CVV is required")
+            return
+        }
+    }
+
+    oldSubscriptionID, err := h.db.GetActiveSubscriptionID(account.ReferenceUUID)
+    if err != nil && !(change.isTrial && err == sql.ErrNoRows) {
+        log.Printf("Error getting subscription for %s: %v", account.ReferenceUUID, err)
+        utils.SendErrorResponse(w, http.StatusConflict, "No active subscription found")
+        return
+    }
+
+    // A nova ARB e a cobrança por CVV usam o customer profile
+    var profile *database.CustomerProfileData
+    if oldSubscriptionID != "" || (change.charge > 0 && req.OpaqueData == nil) {
+        profile, err = h.db.GetCustomerProfile(account.ReferenceUUID)
+        if err != nil || profile.AuthorizeCustomerProfileID == "" || profile.AuthorizePaymentProfileID == "" {
+            log.Printf("No customer profile to switch billing for %s: %v", account.ReferenceUUID, err)
+            utils.SendErrorResponse(w, http.StatusUnprocessableEntity, "No payment method on file, please update your card")
+            return
+        }
+    }
+
+    log.Printf("Switching %s to %s billing: new total $%.2f, unused credit $%.2f, charge $%.2f, renews %s",
+        account.Username, change.response().Interval, change.newTotal, change.unusedCredit,
+        change.charge, change.renewDate.Format("2006-01-02"))
+
+    // 1. Cobrança do primeiro ciclo no novo intervalo, descontado o crédito
+    var transactionID string
+    if change.charge > 0 {
+        if req.OpaqueData != nil {
+            transactionID, err = h.paymentService.ChargeOpaqueData(req.OpaqueData, change.charge, change.tax, account.Currency, "Billing Interval Change")
+        } else {
+            transactionID, err = h.chargeCustomerProfile(profile.AuthorizeCustomerProfileID,
+                profile.AuthorizePaymentProfileID, change.charge, change.tax, account, req.CVV)
+        }
+        if err != nil {
+            log.Printf("Error charging billing switch for %s: %v", account.Username, err)
+            utils.SendErrorResponse(w, http.StatusPaymentRequired, fmt.Sprintf("Payment failed: %v", err))
+            return
+        }
+    }
+
+    // 2. Nova ARB: o intervalo de uma assinatura ARB não pode ser alterado
+    var newSubscriptionID string
+    if oldSubscriptionID != "" {
+        newSubscriptionID, err = h.paymentService.CreateScheduledSubscription(
+            profile.AuthorizeCustomerProfileID, profile.AuthorizePaymentProfileID,
+            change.nextCharge, change.intervalMonths(), change.renewDate)
+        if err != nil {
+            log.Printf("Error creating subscription for billing switch of %s: %v", account.Username, err)
+            h.voidSwitchCharge(account.ReferenceUUID, transactionID)
+            utils.SendErrorResponse(w, http.StatusBadGateway, "Failed to update subscription, please try again")
+            return
+        }
+    }
+
+    // 3. Conta, planos, créditos, assinatura e faturas numa transação
+    err = h.db.ApplyBillingIntervalSwitch(&database.BillingIntervalSwitch{
+        MasterReference:   account.ReferenceUUID,
+        PreviousPlans:     account.PurchasedPlans,
+        WasAnnually:       account.IsAnnually == 1,
+        PurchasedPlans:    change.purchasedPlans,
+        IsAnnually:        change.toAnnual,
+        IsTrial:           change.isTrial,
+        TotalPrice:        change.newTotal,
+        RenewDate:         change.renewDate,
+        NextCharge:        change.nextCharge,
+        NextChargeTax:     change.nextChargeTax,
+        OldSubscriptionID: oldSubscriptionID,
+        NewSubscriptionID: newSubscriptionID,
+        TransactionID:     transactionID,
+        ChargedAmount:     change.charge,
+        ChargedTax:        change.tax,
+        Credits:           change.credits,
+    })
+    if err != nil {
+        log.Printf("Error recording billing switch for %s: %v", account.Username, err)
+
+        // Não deixar uma assinatura nova cobrando sem registro local
+        if newSubscriptionID != "" {
+            if cancelErr := h.paymentService.CancelSubscription(newSubscriptionID); cancelErr != nil {
+                log.Printf("Error cancelling unrecorded subscription %s of %s, scheduling retry: %v",
+                    newSubscriptionID, account.ReferenceUUID, cancelErr)
+                if err := scheduleSubscriptionCancel(h.queue, account.ReferenceUUID, newSubscriptionID); err != nil {
+                    log.Printf("CRITICAL: Subscription %s created for %s but not recorded and could not be cancelled: %v",
+                        newSubscriptionID, account.ReferenceUUID, err)
+                }
+            }
+        }
+        h.voidSwitchCharge(account.ReferenceUUID, transactionID)
+        if err == database.ErrAccountChanged {
+            utils.SendErrorResponse(w, http.StatusConflict, "Account changed during the billing switch, please review and try again")
+            return
+        }
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update account")
+        return
+    }
+
+    // 4. Encerrar a ARB antiga. Se o gateway falhar, o cancelamento vai para a fila e é
+    // repetido: as duas ARBs ativas cobrariam o cliente em dobro.
+    if oldSubscriptionID != "" {
+        if err := h.paymentService.CancelSubscription(oldSubscriptionID); err != nil {
+            log.Printf("Error cancelling old subscription %s of %s after billing switch, scheduling retry: %v",
+                oldSubscriptionID, account.ReferenceUUID, err)
+            if err := scheduleSubscriptionCancel(h.queue, account.ReferenceUUID, oldSubscriptionID); err != nil {
+                log.Printf("CRITICAL: Billing switched for %s but old subscription %s could not be cancelled: %v",
+                    account.ReferenceUUID, oldSubscriptionID, err)
+            }
+        }
+    }
+
+    response := change.response()
+    response.Message = fmt.Sprintf("Billing switched to %s", response.Interval)
+    response.TransactionID = transactionID
+    response.SubscriptionID = newSubscriptionID
+
+    go func() {
+        if emailErr := h.sendBillingSwitchEmail(account, &response); emailErr != nil {
+            log.Printf("Warning: Failed to send billing switch email: %v", emailErr)
+        }
+    }()
+
+    utils.SendSuccessResponse(w, models.APIResponse{
+        Status:  "success",
+        Message: response.Message,
+        Data:    response,
+    })
+}
+
+// scheduleSubscriptionCancel enfileira o cancelamento de uma ARB que o gateway não
+// cancelou na requisição; o worker repete até a assinatura ficar cancelada
+func scheduleSubscriptionCancel(q *queue.Queue, masterReference, subscriptionID string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    return q.Enqueue(ctx, queue.JobTypeCancelSubscription, map[string]interface{}{
+        "subscription_id":  subscriptionID,
+        "master_reference": masterReference,
+    }, queue.WithIdempotencyKey("cancel_subscription:"+subscriptionID))
+}
+
+// prepareBillingSwitch valida a requisição e calcula a troca. Em caso de erro já respondeu.
+func (h *AddPlansHandler) prepareBillingSwitch(w http.ResponseWriter, r *http.Request) (*billingSwitch, *SwitchBillingRequest, bool) {
+    user := middleware.GetUserFromContext(r.Context())
+    if user == nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found")
+        return nil, nil, false
+    }
+
+    if !user.IsMaster {
+        utils.SendErrorResponse(w, http.StatusForbidden, "Only master accounts can change billing")
+        return nil, nil, false
+    }
+
+    var req SwitchBillingRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+        return nil, nil, false
+    }
+
+    if req.Interval != BillingIntervalMonthly && req.Interval != BillingIntervalAnnual {
+        utils.SendErrorResponse(w, http.StatusBadRequest, "interval must be 'monthly' or 'annual'")
+        return nil, nil, false
+    }
+    toAnnual := req.Interval == BillingIntervalAnnual
+
+    account, err := h.getMasterAccountData(user.Username, user.Email)
+    if err != nil {
+        log.Printf("Error getting master account for user %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusNotFound, "Account not found")
+        return nil, nil, false
+    }
+
+    if !h.subscriptionChangeAllowed(w, account.ReferenceUUID) {
+        return nil, nil, false
+    }
+
+    isAnnual, err := h.isAnnualUser(account.PurchasedPlans)
+    if err != nil {
+        log.Printf("Error determining billing type for user %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Error determining billing type")
+        return nil, nil, false
+    }
+
+    if isAnnual == toAnnual {
+        utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Account is already billed %s", map[bool]string{true: "annually", false: "monthly"}[isAnnual]))
+        return nil, nil, false
+    }
+
+    // Todos os planos de uma conta têm o mesmo intervalo
+    var plans []PurchasedPlan
+    if account.PurchasedPlans != "" {
+        if err := json.Unmarshal([]byte(account.PurchasedPlans), &plans); err != nil {
+            log.Printf("Error parsing purchased plans for %s: %v", user.Username, err)
+            utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read account plans")
+            return nil, nil, false
+        }
+    }
+
+    annuallyFlag := 0
+    if toAnnual {
+        annuallyFlag = 1
+    }
+    for i := range plans {
+        plans[i].Annually = annuallyFlag
+    }
+
+    plansJSON, err := json.Marshal(plans)
+    if err != nil {
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update account plans")
+        return nil, nil, false
+    }
+
+    change := &billingSwitch{
+        account:        account,
+        toAnnual:       toAnnual,
+        isTrial:        account.IsTrial == 1,
+        purchasedPlans: string(plansJSON),
+    }
+
+    // Anual = 10x o mensal; total_price já inclui os descontos da compra
+    if toAnnual {
+        change.newTotal = utils.CalculateAnnualPrice(account.TotalPrice)
+    } else {
+        change.newTotal = utils.Round(account.TotalPrice / 10)
+    }
+
+    // Trial não pagou nada: só muda o intervalo, a primeira cobrança segue no fim do trial
+    if change.isTrial {
+        change.renewDate = account.RenewDate
+        change.nextCharge = change.newTotal
+        change.applyTax(h.db.AccountTaxRate(account))
+        return change, &req, true
+    }
+
+    pendingCredit, err := h.db.GetPendingCreditTotal(account.ReferenceUUID)
+    if err != nil {
+        log.Printf("Error getting account credits for %s: %v", user.Username, err)
+        utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return nil, nil, false
+    }
+
+    change.unusedCredit = utils.Round(unusedPeriodValue(account.TotalPrice, account.RenewDate, isAnnual) + pendingCredit)
+
+    // O novo intervalo começa hoje
+    now := time.Now()
+    change.renewDate = now.AddDate(0, change.intervalMonths(), 0)
+    change.nextCharge = change.newTotal
+
+    due := utils.Round(change.newTotal - change.unusedCredit)
+    if due > 0 {
+        change.charge = due
+    } else if due < 0 {
+        change.creditBalance = -due
+        change.credits = splitAccountCredit(account.ReferenceUUID, change.creditBalance, change.newTotal,
+            change.renewDate, change.intervalMonths())
+        if len(change.credits) > 0 && change.credits[0].CycleDate.Equal(change.renewDate) {
+            change.nextCharge = utils.Round(change.newTotal - change.credits[0].Amount)
+        }
+    }
+
+    change.applyTax(h.db.AccountTaxRate(account))
+    return change, &req, true
+}
+
+// unusedPeriodValue é o valor proporcional aos dias restantes até o fim do período atual
+func unusedPeriodValue(total float64, renewDate time.Time, isAnnual bool) float64 {
+    months := 1
+    if isAnnual {
+        months = 12
+    }
+
+    periodEnd := nextCycleDate(renewDate, isAnnual)
+    periodStart := periodEnd.AddDate(0, -months, 0)
+
+    remaining := periodEnd.Sub(time.Now()).Hours() / 24
+    length := periodEnd.Sub(periodStart).Hours() / 24
+    if remaining <= 0 || length <= 0 {
+        return 0
+    }
+
+    return total * math.Min(remaining/length, 1)
+}
+
+// splitAccountCredit distribui um saldo pelos ciclos a partir de firstCycle, abatendo em
+// cada um no máximo o valor da cobrança menos minimumSubscriptionCharge
+func splitAccountCredit(masterRef string, amount, recurringTotal float64, firstCycle time.Time, months int) []database.AccountCredit {
+    room := utils.Round(recurringTotal - minimumSubscriptionCharge)
+    if room <= 0 {
+        return nil
+    }
+
+    var credits []database.AccountCredit
+    cycle := firstCycle
+    for i := 0; i < maxCreditCycles && amount > 0; i++ {
+        portion := utils.Round(math.Min(amount, room))
+        credits = append(credits, database.AccountCredit{
+            MasterReference: masterRef,
+            Amount:          portion,
+            Reason:          "billing_switch",
+            CycleDate:       cycle,
+        })
+        amount = utils.Round(amount - portion)
+        cycle = cycle.AddDate(0, months, 0)
+    }
+
+    if amount > 0 {
+        log.Printf("Warning: $%.2f of credit for %s could not be scheduled within %d cycles",
+            amount, masterRef, maxCreditCycles)
+    }
+
+    return credits
+}
+
+// voidSwitchCharge anula a cobrança de uma troca que não pôde ser concluída
+func (h *AddPlansHandler) voidSwitchCharge(masterReference, transactionID string) {
+    if transactionID == "" {
+        return
+    }
+
+    if err := h.paymentService.VoidTransaction(transactionID); err != nil {
+        log.Printf("CRITICAL: Billing switch charge %s for %s could not be voided: %v",
+            transactionID, masterReference, err)
+    }
+}
+
+func (h *AddPlansHandler) sendBillingSwitchEmail(account *models.MasterAccount, response *SwitchBillingResponse) error {
+    period := "month"
+    if response.Interval == BillingIntervalAnnual {
+        period = "year"
+    }
+
+    money := func(amount float64) string {
+        return models.FormatMoney(amount, account.Currency)
+    }
+
+    chargeLine := fmt.Sprintf("<p><strong>Charged today:</strong> %s (Free trial)</p>", money(0))
+    if !response.IsTrial {
+        chargeLine = fmt.Sprintf(`
+        <p><strong>Credit for the unused period:</strong> %s</p>
+        <p><strong>Charged today:</strong> %s</p>`, money(response.UnusedCredit), money(response.AmountCharged))
+        if response.Tax > 0 {
+            chargeLine += fmt.Sprintf(`
+        <p><strong>Sales tax included (%.3g%%):</strong> %s</p>`, response.TaxRate, money(response.Tax))
+        }
+        if response.CreditBalance > 0 {
+            chargeLine += fmt.Sprintf(`
+        <p><strong>Credit applied to your next bills:</strong> %s</p>`, money(response.CreditBalance))
+        }
+    }
+
+    emailContent := fmt.Sprintf(`
+        <h2>Your Billing Has Changed</h2>
+        <p>Hello %s!</p>
+        <p>Your ProSecureLSP subscription is now billed %s.</p>
+        %s
+        <p><strong>New recurring total:</strong> %s per %s</p>
+        <p><strong>Next charge:</strong> %s on %s</p>
+    `, account.Name, map[string]string{BillingIntervalAnnual: "annually", BillingIntervalMonthly: "monthly"}[response.Interval],
+        chargeLine, money(response.NewTotal), period, money(response.NextCharge), response.RenewDate)
+
+    return h.emailService.SendEmail(
+        account.Email,
+        "Billing Updated - ProSecureLSP",
+        emailContent,
+    )
+}
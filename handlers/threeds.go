@@ -0,0 +1,224 @@
+// handlers/threeds.go - Autenticação 3-D Secure do checkout (lookup e callback do desafio)
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/models"
+    "prosecure-payment-api/services/cardvault"
+    "prosecure-payment-api/services/threeds"
+    "prosecure-payment-api/types"
+)
+
+// authenticateThreeDS faz o lookup 3DS do checkout. Retorna true quando a autenticação
+// passou sem desafio; caso contrário já respondeu (desafio, recusa ou erro).
+func (h *PaymentHandler) authenticateThreeDS(w http.ResponseWriter, requestID string, checkout *models.CheckoutData, req *models.PaymentRequest) bool {
+    log.Printf("[RequestID: %s] Starting 3-D Secure authentication for checkout %s", requestID, checkout.ID)
+
+    result, err := h.threeDS.Lookup(&threeds.LookupRequest{
+        CheckoutID:  checkout.ID,
+        CardNumber:  req.CardNumber,
+        Expiry:      req.Expiry,
+        Amount:      checkout.Total,
+        Currency:    models.CurrencyNumericCode(checkout.Currency),
+        Email:       checkout.Email,
+        BillingInfo: req.BillingInfo,
+        Browser:     req.ThreeDSData,
+    })
+    if err != nil {
+        log.Printf("[RequestID: %s] 3-D Secure lookup failed: %v", requestID, err)
+        h.discardPaymentData(requestID, checkout.ID)
+        sendErrorResponse(w, http.StatusBadGateway, "Falha ao iniciar a autenticação 3-D Secure")
+        return false
+    }
+
+    err = h.db.SaveThreeDSAuthentication(&database.ThreeDSAuthentication{
+        TransID:    result.TransID,
+        CheckoutID: checkout.ID,
+        RequestID:  requestID,
+        Status:     result.Status,
+        ECI:        result.ECI,
+        CAVV:       result.CAVV,
+        DSTransID:  result.DSTransID,
+        Version:    result.Version,
+    })
+    if err != nil {
+        log.Printf("[RequestID: %s] Failed to store 3-D Secure authentication: %v", requestID, err)
+        h.discardPaymentData(requestID, checkout.ID)
+        sendErrorResponse(w, http.StatusInternalServerError, "Falha ao registrar a autenticação 3-D Secure")
+        return false
+    }
+
+    switch {
+    case result.Authenticated():
+        log.Printf("[RequestID: %s] 3-D Secure frictionless authentication: transStatus=%s ECI=%s", requestID, result.Status, result.ECI)
+        return true
+
+    case result.Status == threeds.StatusChallenge:
+        log.Printf("[RequestID: %s] 3-D Secure challenge required (3DS Server trans %s)", requestID, result.TransID)
+        h.setPaymentResult(requestID, checkout.ID, "authenticating", "")
+
+        sendSuccessResponse(w, models.APIResponse{
+            Status:  "authentication_required",
+            Message: "Autenticação 3-D Secure necessária",
+            Data: map[string]interface{}{
+                "request_id":   requestID,
+                "checkout_id":  checkout.ID,
+                "three_ds":     result.Challenge,
+                "callback_url": "/api/3ds/callback",
+            },
+        })
+        return false
+    }
+
+    log.Printf("[RequestID: %s] 3-D Secure authentication declined: transStatus=%s reason=%s", requestID, result.Status, result.Reason)
+    h.discardPaymentData(requestID, checkout.ID)
+    h.setPaymentResult(requestID, checkout.ID, "failed", fmt.Sprintf("3-D Secure authentication failed (transStatus %s)", result.Status))
+    sendErrorResponse(w, http.StatusPaymentRequired, "Autenticação 3-D Secure recusada pelo emissor do cartão")
+    return false
+}
+
+// ThreeDSCallback recebe o resultado do desafio 3DS (ThreeDSCallback em JSON ou o POST
+// do ACS com cres/threeDSSessionData), confere no 3DS Server e retoma o checkout
+func (h *PaymentHandler) ThreeDSCallback(w http.ResponseWriter, r *http.Request) {
+    if h.threeDS == nil {
+        sendErrorResponse(w, http.StatusNotFound, "3-D Secure is not enabled")
+        return
+    }
+
+    var callback types.ThreeDSCallback
+    if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+        if err := r.ParseForm(); err != nil {
+            sendErrorResponse(w, http.StatusBadRequest, "Invalid form body")
+            return
+        }
+        callback.TransID = r.PostForm.Get("threeDSSessionData")
+        callback.Payload = r.PostForm.Get("cres")
+    } else if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+        sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+        return
+    }
+
+    if callback.TransID == "" || callback.Payload == "" {
+        sendErrorResponse(w, http.StatusBadRequest, "transId and payload are required")
+        return
+    }
+
+    pending, err := h.db.GetThreeDSAuthentication(callback.TransID)
+    if err == sql.ErrNoRows {
+        sendErrorResponse(w, http.StatusNotFound, "3-D Secure transaction not found")
+        return
+    } else if err != nil {
+        log.Printf("Error getting 3-D Secure transaction %s: %v", callback.TransID, err)
+        sendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    requestID := pending.RequestID
+    log.Printf("[RequestID: %s] 3-D Secure callback for checkout %s (3DS Server trans %s)", requestID, pending.CheckoutID, pending.TransID)
+
+    if pending.Status != threeds.StatusChallenge {
+        log.Printf("[RequestID: %s] 3-D Secure transaction %s already completed with status %s", requestID, pending.TransID, pending.Status)
+        sendErrorResponse(w, http.StatusConflict, "Autenticação 3-D Secure já concluída")
+        return
+    }
+
+    acquired, err := h.db.LockCheckout(pending.CheckoutID)
+    if err != nil {
+        log.Printf("[RequestID: %s] Error acquiring lock: %v", requestID, err)
+        sendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+    if !acquired {
+        sendErrorResponse(w, http.StatusConflict, "Este checkout já está sendo processado")
+        return
+    }
+    defer h.db.ReleaseLock(pending.CheckoutID)
+
+    // O resultado vem do 3DS Server; o status enviado pelo navegador não é confiável
+    result, err := h.threeDS.Authenticate(&callback)
+    if err != nil {
+        log.Printf("[RequestID: %s] 3-D Secure callback verification failed: %v", requestID, err)
+        sendErrorResponse(w, http.StatusBadRequest, "Resposta de autenticação 3-D Secure inválida")
+        return
+    }
+
+    pending.Status = result.Status
+    pending.ECI = result.ECI
+    pending.CAVV = result.CAVV
+    pending.DSTransID = result.DSTransID
+    if result.Version != "" {
+        pending.Version = result.Version
+    }
+    if err := h.db.SaveThreeDSAuthentication(pending); err != nil {
+        log.Printf("[RequestID: %s] Failed to store 3-D Secure result: %v", requestID, err)
+        sendErrorResponse(w, http.StatusInternalServerError, "Falha ao registrar a autenticação 3-D Secure")
+        return
+    }
+
+    if !result.Authenticated() {
+        log.Printf("[RequestID: %s] 3-D Secure challenge failed: transStatus=%s", requestID, result.Status)
+        h.discardPaymentData(requestID, pending.CheckoutID)
+        h.setPaymentResult(requestID, pending.CheckoutID, "failed", fmt.Sprintf("3-D Secure authentication failed (transStatus %s)", result.Status))
+        sendErrorResponse(w, http.StatusPaymentRequired, "Autenticação 3-D Secure recusada pelo emissor do cartão")
+        return
+    }
+
+    log.Printf("[RequestID: %s] 3-D Secure challenge succeeded: transStatus=%s ECI=%s", requestID, result.Status, result.ECI)
+
+    checkout, err := h.db.GetCheckoutData(pending.CheckoutID)
+    if err != nil {
+        log.Printf("[RequestID: %s] Invalid checkout ID: %v", requestID, err)
+        sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid checkout ID: %v", err))
+        return
+    }
+
+    // Os dados do cartão ficaram no vault durante o desafio
+    paymentData, err := h.cardVault.Load(pending.CheckoutID)
+    if err != nil {
+        log.Printf("[RequestID: %s] Failed to load payment data after 3-D Secure challenge: %v", requestID, err)
+        if err == cardvault.ErrNotFound {
+            h.setPaymentResult(requestID, pending.CheckoutID, "failed", "Payment data expired during 3-D Secure authentication")
+            sendErrorResponse(w, http.StatusGone, "Os dados de pagamento expiraram, refaça o checkout")
+            return
+        }
+        sendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+        return
+    }
+
+    req := paymentData.PaymentRequest()
+    req.CustomerEmail = checkout.Email
+
+    h.schedulePayment(w, requestID, checkout, req)
+}
+
+// setPaymentResult grava o status do pagamento consultado em /check-payment-status
+func (h *PaymentHandler) setPaymentResult(requestID, checkoutID, status, errorMessage string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    _, err := h.db.GetDB().ExecContext(ctx,
+        `INSERT INTO payment_results
+         (request_id, checkout_id, status, error_message, created_at)
+         VALUES (?, ?, ?, ?, NOW())
+         ON DUPLICATE KEY UPDATE status = VALUES(status), error_message = VALUES(error_message)`,
+        requestID, checkoutID, status, errorMessage)
+    if err != nil {
+        log.Printf("[RequestID: %s] Warning: Failed to store payment status %s: %v", requestID, status, err)
+    }
+}
+
+// discardPaymentData remove os dados de cartão de um checkout que não seguirá adiante
+func (h *PaymentHandler) discardPaymentData(requestID, checkoutID string) {
+    if err := h.cardVault.Delete(checkoutID); err != nil {
+        log.Printf("[RequestID: %s] Warning: Failed to clean up temporary payment data: %v", requestID, err)
+    }
+}
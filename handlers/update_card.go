@@ -37,6 +37,8 @@ type UpdateCardRequest struct {
     CardNumber string `json:"card_number"`
     Expiry     string `json:"expiry"`
     CVV        string `json:"cvv"`
+    // Nonce do Accept.js, alternativo a card_number/expiry/cvv
+    OpaqueData *models.OpaqueData `json:"opaqueData,omitempty"`
 }
 
 type UpdateCardResponse struct {
@@ -67,27 +69,40 @@ func (h *UpdateCardHandler) UpdateCard(w http.ResponseWriter, r *http.Request) {
     }
 
     // Validar campos obrigatórios RAPIDAMENTE
-    if req.Email == "" || req.Username == "" || req.CardName == "" || 
+    if req.OpaqueData != nil {
+        // Accept.js: o cartão foi tokenizado no navegador
+        if req.Email == "" || req.Username == "" || !req.OpaqueData.Valid() {
+            log.Printf("[UpdateCard %s] Missing required fields (opaque data)", requestID)
+            h.sendErrorResponse(w, http.StatusBadRequest, "Email, username and opaqueData are required")
+            return
+        }
+        if req.CardNumber != "" || req.CVV != "" {
+            h.sendErrorResponse(w, http.StatusBadRequest, "Send either card data or opaqueData, not both")
+            return
+        }
+    } else if req.Email == "" || req.Username == "" || req.CardName == "" || 
        req.CardNumber == "" || req.Expiry == "" || req.CVV == "" {
         log.Printf("[UpdateCard %s] Missing required fields", requestID)
         h.sendErrorResponse(w, http.StatusBadRequest, "All fields are required")
         return
     }
 
-    // Validações básicas RÁPIDAS
-    if len(req.CardName) < 3 {
-        h.sendErrorResponse(w, http.StatusBadRequest, "Please enter a valid cardholder name")
-        return
-    }
-    
-    if len(req.CardNumber) < 13 {
-        h.sendErrorResponse(w, http.StatusBadRequest, "Please enter a valid card number")
-        return
-    }
-    
-    if len(req.CVV) < 3 || len(req.CVV) > 4 {
-        h.sendErrorResponse(w, http.StatusBadRequest, "Please enter a valid CVV")
-        return
+    // Validações básicas RÁPIDAS (com Accept.js o cartão já foi validado no navegador)
+    if req.OpaqueData == nil {
+        if len(req.CardName) < 3 {
+            h.sendErrorResponse(w, http.StatusBadRequest, "Please enter a valid cardholder name")
+            return
+        }
+        
+        if len(req.CardNumber) < 13 {
+            h.sendErrorResponse(w, http.StatusBadRequest, "Please enter a valid card number")
+            return
+        }
+        
+        if len(req.CVV) < 3 || len(req.CVV) > 4 {
+            h.sendErrorResponse(w, http.StatusBadRequest, "Please enter a valid CVV")
+            return
+        }
     }
 
     log.Printf("[UpdateCard %s] Basic validation passed, starting async processing with Customer Profile", requestID)
@@ -184,6 +199,7 @@ func (h *UpdateCardHandler) processCardUpdateWithCustomerProfile(ctx context.Con
         CardNumber:    req.CardNumber,
         Expiry:        req.Expiry,
         CVV:           req.CVV,
+        OpaqueData:    req.OpaqueData,
         CheckoutID:    masterAccount.ReferenceUUID,
         CustomerEmail: req.Email,
         BillingInfo: &types.BillingInfoType{
@@ -224,6 +240,13 @@ func (h *UpdateCardHandler) processCardUpdateWithCustomerProfile(ctx context.Con
         return UpdateCardResponse{}, fmt.Errorf("failed to update account data: %v", err)
     }
 
+    // Conta em dunning: o valor em atraso é cobrado do cartão novo na próxima execução
+    if scheduled, err := h.db.RequestDunningRetry(masterAccount.ReferenceUUID); err != nil {
+        log.Printf("[UpdateCard %s] Warning: Failed to schedule dunning retry: %v", requestID, err)
+    } else if scheduled {
+        log.Printf("[UpdateCard %s] Dunning retry scheduled with the new card", requestID)
+    }
+
     log.Printf("[UpdateCard %s] Card update completed successfully with Customer Profile", requestID)
 
     return UpdateCardResponse{
@@ -322,6 +345,14 @@ func (h *UpdateCardHandler) processPaymentWithCustomerProfile(ctx context.Contex
 func (h *UpdateCardHandler) handleCustomerProfile(ctx context.Context, requestID string, paymentReq *models.PaymentRequest, checkoutData *models.CheckoutData, master *models.MasterAccount) (string, string, error) {
     log.Printf("[UpdateCard %s] Handling Customer Profile for user: %s", requestID, master.Username)
     
+    // Accept.js: o nonce já foi convertido em um novo perfil na autorização e não pode
+    // ser reutilizado para atualizar o perfil antigo, então o novo perfil o substitui
+    if paymentReq.HasStoredProfile() {
+        log.Printf("[UpdateCard %s] Using Customer Profile created from payment nonce: %s/%s", 
+            requestID, paymentReq.CustomerProfileID, paymentReq.PaymentProfileID)
+        return paymentReq.CustomerProfileID, paymentReq.PaymentProfileID, nil
+    }
+    
     // Verificar se já existe Customer Profile para este usuário
     var existingCustomerProfileID, existingPaymentProfileID string
     profileErr := h.db.GetDB().QueryRowContext(ctx,
@@ -415,6 +446,9 @@ func (h *UpdateCardHandler) updateAccountAfterCardUpdateWithProfile(ctx context.
         Number: payment.CardNumber,
         Expiry: payment.Expiry,
     }
+    if cardData.Number == "" {
+        cardData.Number = payment.AccountNumber // Accept.js: número mascarado do gateway
+    }
     
     if err = tx.SavePaymentMethod(master.ReferenceUUID, cardData); err != nil {
         return fmt.Errorf("failed to update payment method: %v", err)
@@ -514,6 +548,12 @@ func (h *UpdateCardHandler) convertMasterAccountToCheckoutData(master *models.Ma
         PlansJSON:   master.PurchasedPlans,
         Total:       master.TotalPrice,
     }
+
+    // A nova ARB cobra o imposto do endereço junto com o valor recorrente
+    rate := h.db.AccountTaxRate(master)
+    checkoutData.TaxRate = rate.Percent
+    checkoutData.Tax = rate.Amount(master.TotalPrice)
+    checkoutData.Total = rate.Gross(master.TotalPrice)
     
     // Parse simplificado dos planos
     plans := []models.Plan{
@@ -22,8 +22,12 @@ import (
     "prosecure-payment-api/middleware"
     "prosecure-payment-api/queue"
     "prosecure-payment-api/services/auth"
+    "prosecure-payment-api/services/cardvault"
     "prosecure-payment-api/services/email"
     "prosecure-payment-api/services/payment"
+    "prosecure-payment-api/services/payment/fake"
+    "prosecure-payment-api/services/tax"
+    "prosecure-payment-api/services/threeds"
     "prosecure-payment-api/worker"
 )
 
@@ -172,17 +176,52 @@ func main() {
         log.Fatalf("Failed to connect to Redis: %v", err)
     }
     defer jobQueue.Close()
+    jobQueue.SetJobStore(db)
     log.Println("Successfully connected to Redis")
 
     // Inicializar serviços
-    paymentService := payment.NewPaymentService(
-        cfg.AuthNet.APILoginID,
-        cfg.AuthNet.TransactionKey,
-        cfg.AuthNet.MerchantID,
-        cfg.AuthNet.Environment,
-    )
+    var paymentService *payment.Service
+    if cfg.AuthNet.Gateway == "fake" {
+        // O gateway fake aprova cobranças sem movimentar dinheiro: nunca em produção
+        if cfg.AuthNet.Environment == "production" {
+            log.Fatalf("PAYMENT_GATEWAY=fake cannot be used with AUTHNET_ENVIRONMENT=production")
+        }
+        paymentService = payment.NewPaymentServiceWithGateway(fake.NewGateway())
+    } else {
+        paymentService = payment.NewPaymentService(
+            cfg.AuthNet.APILoginID,
+            cfg.AuthNet.TransactionKey,
+            cfg.AuthNet.MerchantID,
+            cfg.AuthNet.Environment,
+            cfg.AuthNet.Endpoint,
+        )
+    }
     emailService := email.NewSMTPService(cfg.SMTP)
 
+    // 3-D Secure: 3DS Server externo ou, com o gateway fake, o provider em memória
+    var threeDSProvider threeds.Provider
+    if cfg.ThreeDS.Mode != threeds.ModeOff {
+        if cfg.AuthNet.Gateway == "fake" {
+            threeDSProvider = threeds.NewFake()
+        } else {
+            threeDSProvider = threeds.NewServer(cfg.ThreeDS)
+        }
+        log.Printf("3-D Secure enabled (mode: %s)", cfg.ThreeDS.Mode)
+    }
+
+    // Dados de cartão temporários são cifrados em repouso (AES-GCM)
+    cardVault, err := cardvault.NewVault(db, cfg.CardData)
+    if err != nil {
+        log.Fatalf("Failed to initialize card data vault (check CARD_DATA_KEYS / CARD_DATA_KEY_ID): %v", err)
+    }
+
+    // Imposto sobre vendas pelo endereço de cobrança (checkout, pro-rata e recorrência)
+    taxEngine, err := tax.New(cfg.Tax)
+    if err != nil {
+        log.Fatalf("Failed to load tax rates (check TAX_RATES_FILE): %v", err)
+    }
+    db.SetTaxEngine(taxEngine)
+
     // NOVO: Inicializar serviço JWT
     jwtSecret := os.Getenv("JWT_SECRET")
     if jwtSecret == "" {
@@ -200,7 +239,7 @@ func main() {
         workerConcurrency = 8
     }
     
-    paymentWorker := worker.NewWorker(jobQueue, db, paymentService, emailService)
+    paymentWorker := worker.NewWorker(jobQueue, db, paymentService, emailService, cardVault, cfg.Dunning)
     paymentWorker.Start(workerConcurrency)
     defer paymentWorker.Stop()
     log.Printf("Started payment worker with %d threads", workerConcurrency)
@@ -208,7 +247,7 @@ func main() {
     // Inicializar handlers
     var paymentHandler *handlers.PaymentHandler
     for retries := 0; retries < 3; retries++ {
-        paymentHandler, err = handlers.NewPaymentHandler(db, paymentService, emailService, jobQueue)
+        paymentHandler, err = handlers.NewPaymentHandler(db, paymentService, emailService, jobQueue, cardVault, threeDSProvider, cfg.ThreeDS.Mode)
         if err == nil {
             break
         }
@@ -220,7 +259,7 @@ func main() {
     }
 
     // Inicializar handlers
-    webhookHandler := handlers.NewWebhookHandler(db, jobQueue, paymentService)
+    webhookHandler := handlers.NewWebhookHandler(db, jobQueue, paymentService, cardVault, cfg)
     planHandler := handlers.NewPlanHandler(db)
     cartHandler := handlers.NewCartHandler(db, cfg)
     checkoutHandler := handlers.NewCheckoutHandler(db)
@@ -280,7 +319,7 @@ func main() {
     protectedRouter.Use(middleware.AuthMiddleware(jwtService))
     protectedRouter.Use(middleware.AllowPaymentError()) // Permite payment_error para update de cartão
 
-    addPlansHandler := handlers.NewAddPlansHandler(db, paymentService, emailService)
+    addPlansHandler := handlers.NewAddPlansHandler(db, paymentService, emailService, jobQueue)
     addPlansProtectedPaymentHandler := handlers.NewAddPlansProtectedPaymentHandler(db)
     
     dashboardUpdateCardHandler := handlers.NewDashboardUpdateCardHandler(db, paymentService, emailService)
@@ -288,6 +327,10 @@ func main() {
 
     protectedRouter.HandleFunc("/add-plans", addPlansHandler.AddPlans).Methods("POST", "OPTIONS")
     protectedRouter.HandleFunc("/preview-add-plans", addPlansHandler.PreviewAddPlans).Methods("POST", "OPTIONS")
+    protectedRouter.HandleFunc("/remove-plans", addPlansHandler.RemovePlans).Methods("POST", "OPTIONS")
+    protectedRouter.HandleFunc("/preview-remove-plans", addPlansHandler.PreviewRemovePlans).Methods("POST", "OPTIONS")
+    protectedRouter.HandleFunc("/switch-billing", addPlansHandler.SwitchBilling).Methods("POST", "OPTIONS")
+    protectedRouter.HandleFunc("/preview-switch-billing", addPlansHandler.PreviewSwitchBilling).Methods("POST", "OPTIONS")
     protectedRouter.HandleFunc("/card-info", addPlansProtectedPaymentHandler.GetCardInfo).Methods("GET", "OPTIONS") // NOVA ROTA
     protectedRouter.HandleFunc("/update-payment", protectedPaymentHandler.UpdatePaymentMethod).Methods("POST", "OPTIONS")
     protectedRouter.HandleFunc("/account", protectedPaymentHandler.GetAccountDetails).Methods("GET", "OPTIONS")
@@ -298,6 +341,58 @@ func main() {
     masterOnlyRouter.Use(middleware.RequireMaster())
     masterOnlyRouter.HandleFunc("/add-plan", protectedPaymentHandler.AddPlan).Methods("POST", "OPTIONS")
 
+    subscriptionHandler := handlers.NewSubscriptionHandler(db, paymentService, emailService, jobQueue)
+    masterOnlyRouter.HandleFunc("/subscription/cancellation", subscriptionHandler.GetCancellation).Methods("GET", "OPTIONS")
+    masterOnlyRouter.HandleFunc("/subscription/cancel", subscriptionHandler.CancelSubscription).Methods("POST", "OPTIONS")
+    masterOnlyRouter.HandleFunc("/subscription/cancel/undo", subscriptionHandler.UndoCancellation).Methods("POST", "OPTIONS")
+    masterOnlyRouter.HandleFunc("/subscription/pause", subscriptionHandler.GetPause).Methods("GET", "OPTIONS")
+    masterOnlyRouter.HandleFunc("/subscription/pause", subscriptionHandler.PauseSubscription).Methods("POST", "OPTIONS")
+    masterOnlyRouter.HandleFunc("/subscription/resume", subscriptionHandler.ResumeSubscription).Methods("POST", "OPTIONS")
+
+    // Endpoints administrativos
+    adminWebhookEventHandler := handlers.NewAdminWebhookEventHandler(db, jobQueue)
+    adminRouter := protectedRouter.PathPrefix("/admin").Subrouter()
+    adminRouter.Use(middleware.RequireAdmin(cfg.Admin.Usernames))
+    adminRouter.HandleFunc("/webhook-events", adminWebhookEventHandler.ListWebhookEvents).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/webhook-events/replay", adminWebhookEventHandler.ReplayWebhookEvent).Methods("POST", "OPTIONS")
+
+    adminJobHandler := handlers.NewAdminJobHandler(db)
+    adminRouter.HandleFunc("/jobs", adminJobHandler.ListJobs).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/jobs/job", adminJobHandler.GetJob).Methods("GET", "OPTIONS")
+
+    adminQueueHandler := handlers.NewAdminQueueHandler(db, jobQueue)
+    adminRouter.HandleFunc("/queue/stats", adminQueueHandler.GetQueueStats).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/queue/failed", adminQueueHandler.ListFailedJobs).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/queue/failed/job", adminQueueHandler.EditFailedJob).Methods("PUT", "OPTIONS")
+    adminRouter.HandleFunc("/queue/failed/retry", adminQueueHandler.RetryFailedJobs).Methods("POST", "OPTIONS")
+    adminRouter.HandleFunc("/queue/failed/discard", adminQueueHandler.DiscardFailedJobs).Methods("POST", "OPTIONS")
+    adminRouter.HandleFunc("/queue/actions", adminQueueHandler.ListQueueActions).Methods("GET", "OPTIONS")
+
+    adminRefundHandler := handlers.NewAdminRefundHandler(db, paymentService, emailService)
+    adminRouter.HandleFunc("/refunds", adminRefundHandler.ListRefunds).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/refunds", adminRefundHandler.CreateRefund).Methods("POST", "OPTIONS")
+
+    adminReconciliationHandler := handlers.NewAdminReconciliationHandler(db, jobQueue)
+    adminRouter.HandleFunc("/reconciliation/reports", adminReconciliationHandler.ListReports).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/reconciliation/report", adminReconciliationHandler.GetReport).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/reconciliation/run", adminReconciliationHandler.RunReport).Methods("POST", "OPTIONS")
+
+    adminRouter.HandleFunc("/cancellations/stats", subscriptionHandler.GetCancellationStats).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/trials/stats", subscriptionHandler.GetTrialStats).Methods("GET", "OPTIONS")
+
+    adminDunningHandler := handlers.NewAdminDunningHandler(db)
+    adminRouter.HandleFunc("/dunning", adminDunningHandler.ListDunningCases).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/dunning/case", adminDunningHandler.GetDunningCase).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/dunning/retry", adminDunningHandler.RetryDunningCase).Methods("POST", "OPTIONS")
+
+    adminCouponHandler := handlers.NewAdminCouponHandler(db)
+    adminRouter.HandleFunc("/coupons", adminCouponHandler.ListCoupons).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/coupons", adminCouponHandler.CreateCoupon).Methods("POST", "OPTIONS")
+    adminRouter.HandleFunc("/coupons/coupon", adminCouponHandler.GetCoupon).Methods("GET", "OPTIONS")
+    adminRouter.HandleFunc("/coupons/coupon", adminCouponHandler.UpdateCoupon).Methods("PUT", "OPTIONS")
+    adminRouter.HandleFunc("/coupons/coupon", adminCouponHandler.DeactivateCoupon).Methods("DELETE", "OPTIONS")
+    adminRouter.HandleFunc("/coupons/redemptions", adminCouponHandler.ListRedemptions).Methods("GET", "OPTIONS")
+
     // ===========================================
     // ROTAS PÚBLICAS (PARA CHECKOUT E WEBHOOKS)
     // ===========================================
@@ -318,6 +413,7 @@ func main() {
     paymentRouter.Use(timeoutMiddleware(60 * time.Second))
     paymentRouter.HandleFunc("/process-payment", paymentHandler.ProcessPayment).Methods("POST", "OPTIONS")
     paymentRouter.HandleFunc("/check-payment-status", paymentHandler.CheckPaymentStatus).Methods("GET", "OPTIONS")
+    paymentRouter.HandleFunc("/3ds/callback", paymentHandler.ThreeDSCallback).Methods("POST", "OPTIONS")
     paymentRouter.HandleFunc("/reset-checkout-status", paymentHandler.ResetCheckoutStatus).Methods("POST", "OPTIONS")
     paymentRouter.HandleFunc("/generate-checkout-id", paymentHandler.GenerateCheckoutID).Methods("GET")
     paymentRouter.HandleFunc("/update-checkout-id", paymentHandler.UpdateCheckoutID).Methods("POST")
@@ -329,6 +425,7 @@ func main() {
     webhookRouter.HandleFunc("/silent-post", webhookHandler.HandleSilentPost).Methods("POST")
     webhookRouter.HandleFunc("/relay-response", webhookHandler.HandleRelayResponse).Methods("POST")
     webhookRouter.HandleFunc("/subscription-notification", webhookHandler.HandleSubscriptionNotification).Methods("POST")
+    webhookRouter.HandleFunc("/events", webhookHandler.HandleWebhookEvent).Methods("POST") // Webhooks API (JSON)
     webhookRouter.HandleFunc("/store-payment-data", webhookHandler.StoreTemporaryPaymentData).Methods("POST")
     
     // Other public endpoints
@@ -336,6 +433,7 @@ func main() {
     generalRouter.Use(timeoutMiddleware(30 * time.Second))
     generalRouter.HandleFunc("/checkout", checkoutHandler.UpdateCheckout).Methods("POST", "PUT", "OPTIONS")
     generalRouter.HandleFunc("/checkout", checkoutHandler.GetCheckout).Methods("GET", "OPTIONS")
+    generalRouter.HandleFunc("/checkout/coupon", checkoutHandler.ApplyCoupon).Methods("POST", "OPTIONS")
     generalRouter.HandleFunc("/check-email-availability", checkoutHandler.CheckEmailAvailability).Methods("GET", "OPTIONS")
     generalRouter.HandleFunc("/link-account", linkAccountHandler.LinkAccount).Methods("POST", "OPTIONS")
     generalRouter.HandleFunc("/plans", planHandler.GetPlans).Methods("GET", "OPTIONS")
@@ -343,6 +441,7 @@ func main() {
     generalRouter.HandleFunc("/cart", cartHandler.UpdateCart).Methods("PUT", "OPTIONS")
     generalRouter.HandleFunc("/cart", cartHandler.GetCart).Methods("GET", "OPTIONS")
     generalRouter.HandleFunc("/cart/remove", cartHandler.RemoveFromCart).Methods("POST", "OPTIONS")
+    generalRouter.HandleFunc("/cart/coupon", cartHandler.ApplyCoupon).Methods("POST", "DELETE", "OPTIONS")
 
     // Health check endpoint
     startTime := time.Now()
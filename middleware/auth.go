@@ -85,6 +85,33 @@ func RequireMaster() func(http.Handler) http.Handler {
     }
 }
 
+// RequireAdmin libera o acesso apenas aos operadores configurados (ADMIN_USERNAMES).
+// Contas master são de clientes e não dão acesso administrativo.
+func RequireAdmin(usernames []string) func(http.Handler) http.Handler {
+    admins := make(map[string]bool, len(usernames))
+    for _, username := range usernames {
+        admins[username] = true
+    }
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user := GetUserFromContext(r.Context())
+            if user == nil {
+                utils.SendErrorResponse(w, http.StatusInternalServerError, "User not found in context")
+                return
+            }
+
+            if !admins[user.Username] {
+                log.Printf("Non-admin user attempted to access admin endpoint %s: %s", r.URL.Path, user.Username)
+                utils.SendErrorResponse(w, http.StatusForbidden, "Admin access required")
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
 // RequireActiveAccount verifica se a conta está ativa
 func RequireActiveAccount() func(http.Handler) http.Handler {
     return func(next http.Handler) http.Handler {
@@ -120,7 +147,8 @@ func AllowPaymentError() func(http.Handler) http.Handler {
 
             // CORRIGIDO: Permitir acesso para contas normais, master e com erro de pagamento
             // Agora baseado no payment_status em vez de is_active
-            allowedTypes := []string{"normal", "master", "payment_error"}
+            // Contas pausadas entram para consultar a conta e retomar a assinatura
+            allowedTypes := []string{"normal", "master", "payment_error", "paused"}
             isAllowed := false
             for _, allowedType := range allowedTypes {
                 if user.AccountType == allowedType {
@@ -13,13 +13,16 @@ type RefreshTokenRequest struct {
     RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
+// users.is_active enquanto a assinatura da conta está pausada
+const AccountStatusPaused = 3
+
 // AuthUser representa um usuário autenticado
 type AuthUser struct {
     Username    string `json:"username"`
     Email       string `json:"email"`
     IsMaster    bool   `json:"is_master"`
     IsActive    int    `json:"is_active"`
-    AccountType string `json:"account_type"` // "master", "normal", "payment_error", "dea", "inactive"
+    AccountType string `json:"account_type"` // "master", "normal", "payment_error", "paused", "dea", "inactive"
     MfaEnabled  bool   `json:"mfa_enabled"`
 }
 
@@ -81,6 +84,29 @@ type AccountDetailsResponse struct {
     NextBilling       *string                `json:"next_billing"`
     Address           map[string]string      `json:"address"`
     Status            string                 `json:"status"`
+    Subscription      *GatewaySubscription   `json:"subscription,omitempty"`
+}
+
+// GatewaySubscription é a assinatura ARB como está na Authorize.net
+type GatewaySubscription struct {
+    SubscriptionID  string                      `json:"subscription_id"`
+    Status          string                      `json:"status"`
+    Amount          float64                     `json:"amount"`
+    IntervalLength  int                         `json:"interval_length"`
+    IntervalUnit    string                      `json:"interval_unit"`
+    StartDate       string                      `json:"start_date"`
+    NextPaymentDate *string                     `json:"next_payment_date"`
+    PastOccurrences int                         `json:"past_occurrences"`
+    Charges         []GatewaySubscriptionCharge `json:"charges"`
+}
+
+// GatewaySubscriptionCharge é uma cobrança feita pela assinatura ARB
+type GatewaySubscriptionCharge struct {
+    TransactionID string `json:"transaction_id"`
+    Response      string `json:"response"`
+    SubmittedAt   string `json:"submitted_at"`
+    PayNum        int    `json:"pay_num"`
+    AttemptNum    int    `json:"attempt_num"`
 }
 
 // PaymentHistoryItem representa um item do histórico de pagamentos
@@ -16,7 +16,13 @@ type CartResponse struct {
     CartSubtotal        float64           `json:"cart_subtotal"`
     CartDiscount        float64           `json:"cart_discount"`
     ShortfallForDiscount string           `json:"shortfall_for_discount"`
+    CouponCode          string            `json:"coupon_code,omitempty"`
+    CouponDiscount      float64           `json:"coupon_discount"`
+    CouponMessage       string            `json:"coupon_message,omitempty"` // motivo de o cupom não valer
+    Tax                 float64           `json:"tax"`                      // estimado pelo state/zipcode informado
+    TaxRate             float64           `json:"tax_rate"`
     CartTotal           float64           `json:"cart_total"`
+    Currency            string            `json:"currency"`
 }
 
 type CartItemResponse struct {
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Plan struct {
     PlanID      int     `json:"plan_id"`
     PlanName    string  `json:"plan_name"`
@@ -11,6 +13,7 @@ type Plan struct {
     Email       string  `json:"email"`
     IsMaster    int     `json:"is_master"`
     Quantity    int     `json:"quantity"`
+    TrialDays   int     `json:"trial_days,omitempty"` // plans.trial_days (0 = padrão de um mês)
 }
 
 type CheckoutData struct {
@@ -28,8 +31,69 @@ type CheckoutData struct {
     City        string  `json:"city"`
     Street      string  `json:"street"`
     Additional  string  `json:"additional"`
+    Country     string  `json:"country"`  // ISO 3166 alfa-2; vazio = US
+    Currency    string  `json:"currency"` // moeda dos preços e das cobranças
     Status      string  `json:"status"`
     Subtotal    float64 `json:"subtotal"`
     Discount    float64 `json:"discount"`
     Total       float64 `json:"total"`
-}
\ No newline at end of file
+    TrialDays   int     `json:"trial_days,omitempty"` // menor trial_days entre os planos comprados
+
+    // Cupom aplicado ao checkout; CouponDiscount é o desconto por cobrança (já somado em Discount)
+    CouponCode     string  `json:"coupon_code,omitempty"`
+    CouponDiscount float64 `json:"coupon_discount,omitempty"`
+    Coupon         *Coupon `json:"-"`
+
+    // Imposto sobre vendas pelo endereço de cobrança, calculado sobre o valor com
+    // desconto e já somado em Total
+    Tax     float64 `json:"tax"`
+    TaxRate float64 `json:"tax_rate"`
+}
+
+// BillingCountry retorna o país de cobrança do checkout (US se não informado)
+func (c *CheckoutData) BillingCountry() string {
+    if c.Country == "" {
+        return DefaultCountry
+    }
+    return c.Country
+}
+
+// TrialEndDate retorna o fim do trial iniciado em start. Sem trial_days configurado nos
+// planos o trial dura um mês.
+func (c *CheckoutData) TrialEndDate(start time.Time) time.Time {
+    if c.TrialDays > 0 {
+        return start.AddDate(0, 0, c.TrialDays)
+    }
+    return start.AddDate(0, 1, 0)
+}
+// CouponLines retorna os planos do checkout como itens de cobrança para o cálculo do cupom
+func (c *CheckoutData) CouponLines() []CouponLine {
+    lines := make([]CouponLine, 0, len(c.Plans))
+    for _, plan := range c.Plans {
+        amount := plan.Price
+        if plan.Annually == 1 {
+            amount = plan.Price * 10
+        }
+        lines = append(lines, CouponLine{PlanID: plan.PlanID, Amount: amount})
+    }
+    return lines
+}
+
+// PlanIDs retorna os IDs dos planos do checkout
+func (c *CheckoutData) PlanIDs() []int {
+    ids := make([]int, 0, len(c.Plans))
+    for _, plan := range c.Plans {
+        ids = append(ids, plan.PlanID)
+    }
+    return ids
+}
+
+// BillingIntervalMonths retorna o intervalo da assinatura do checkout em meses
+func (c *CheckoutData) BillingIntervalMonths() int {
+    for _, plan := range c.Plans {
+        if plan.Annually == 1 {
+            return 12
+        }
+    }
+    return 1
+}
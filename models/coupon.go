@@ -0,0 +1,101 @@
+package models
+
+import (
+    "math"
+    "strings"
+    "time"
+)
+
+// Tipos de desconto de cupom
+const (
+    CouponTypePercent = "percent" // percentual sobre os planos elegíveis
+    CouponTypeFixed   = "fixed"   // valor fixo por cobrança, limitado aos planos elegíveis
+)
+
+// Contextos em que um cupom é resgatado
+const (
+    CouponContextCheckout = "checkout"
+    CouponContextAddPlans = "add_plans"
+)
+
+// Coupon é um cupom/código promocional. Cycles indica em quantas cobranças o desconto
+// vale (1 = só a primeira); PlanIDs vazio vale para todos os planos. Os limites com
+// valor 0 são ilimitados.
+type Coupon struct {
+    ID             int64      `json:"id"`
+    Code           string     `json:"code"`
+    Description    string     `json:"description"`
+    DiscountType   string     `json:"discount_type"`
+    DiscountValue  float64    `json:"discount_value"`
+    Cycles         int        `json:"cycles"`
+    PlanIDs        []int      `json:"plan_ids"`
+    ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+    MaxRedemptions int        `json:"max_redemptions"`
+    MaxPerCustomer int        `json:"max_per_customer"`
+    TimesRedeemed  int        `json:"times_redeemed"`
+    IsActive       bool       `json:"is_active"`
+    CreatedBy      string     `json:"created_by,omitempty"`
+    CreatedAt      time.Time  `json:"created_at"`
+    UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// CouponLine é um item cobrado a que o cupom pode se aplicar (preço já multiplicado
+// pela quantidade e pelo intervalo)
+type CouponLine struct {
+    PlanID int
+    Amount float64
+}
+
+// NormalizeCouponCode padroniza o código digitado pelo cliente
+func NormalizeCouponCode(code string) string {
+    return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// AppliesToPlan indica se o cupom vale para o plano
+func (c *Coupon) AppliesToPlan(planID int) bool {
+    if len(c.PlanIDs) == 0 {
+        return true
+    }
+    for _, id := range c.PlanIDs {
+        if id == planID {
+            return true
+        }
+    }
+    return false
+}
+
+// IsExpired indica se o cupom já venceu em now
+func (c *Coupon) IsExpired(now time.Time) bool {
+    return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// Discount calcula o desconto do cupom em uma cobrança. O desconto nunca zera a
+// cobrança: a ARB não aceita assinatura de valor zero, então pelo menos $0.01 é cobrado.
+func (c *Coupon) Discount(lines []CouponLine) float64 {
+    var total, eligible float64
+    for _, line := range lines {
+        total += line.Amount
+        if c.AppliesToPlan(line.PlanID) {
+            eligible += line.Amount
+        }
+    }
+
+    if eligible <= 0 {
+        return 0
+    }
+
+    var discount float64
+    switch c.DiscountType {
+    case CouponTypePercent:
+        discount = eligible * c.DiscountValue / 100
+    case CouponTypeFixed:
+        discount = c.DiscountValue
+    }
+
+    discount = math.Min(discount, eligible)
+    discount = math.Min(discount, total-0.01)
+    if discount <= 0 {
+        return 0
+    }
+    return math.Round(discount*100) / 100
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Moeda e país usados quando o checkout não escolhe outros
+const (
+    DefaultCurrency = "USD"
+    DefaultCountry  = "US"
+)
+
+// currencyInfo descreve uma moeda aceita: símbolo dos emails e código ISO 4217 numérico
+// (usado no 3-D Secure)
+type currencyInfo struct {
+    Symbol  string
+    Numeric string
+}
+
+// Moedas com tabela de preços (plan_prices); USD usa o preço de plans
+var currencies = map[string]currencyInfo{
+    "USD": {Symbol: "$", Numeric: "840"},
+    "CAD": {Symbol: "CA$", Numeric: "124"},
+    "EUR": {Symbol: "€", Numeric: "978"},
+    "GBP": {Symbol: "£", Numeric: "826"},
+    "AUD": {Symbol: "A$", Numeric: "036"},
+}
+
+// Moeda de cada país (ISO 3166 alfa-2); países fora da lista pagam em USD
+var countryCurrencies = map[string]string{
+    "US": "USD", "CA": "CAD", "GB": "GBP", "AU": "AUD",
+    "AT": "EUR", "BE": "EUR", "CY": "EUR", "DE": "EUR", "EE": "EUR", "ES": "EUR",
+    "FI": "EUR", "FR": "EUR", "GR": "EUR", "HR": "EUR", "IE": "EUR", "IT": "EUR",
+    "LT": "EUR", "LU": "EUR", "LV": "EUR", "MT": "EUR", "NL": "EUR", "PT": "EUR",
+    "SI": "EUR", "SK": "EUR",
+}
+
+// NormalizeCurrency padroniza o código da moeda (ex.: " usd" -> "USD")
+func NormalizeCurrency(code string) string {
+    return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// IsSupportedCurrency indica se a moeda tem preços e pode ser escolhida no checkout
+func IsSupportedCurrency(code string) bool {
+    _, ok := currencies[NormalizeCurrency(code)]
+    return ok
+}
+
+// CurrencyForCountry retorna a moeda cobrada de clientes do país
+func CurrencyForCountry(country string) string {
+    if currency, ok := countryCurrencies[strings.ToUpper(strings.TrimSpace(country))]; ok {
+        return currency
+    }
+    return DefaultCurrency
+}
+
+// SelectCurrency escolhe a moeda do checkout: a informada pelo cliente ou, sem ela, a do país
+func SelectCurrency(country, currency string) (string, error) {
+    if currency = NormalizeCurrency(currency); currency != "" {
+        if !IsSupportedCurrency(currency) {
+            return "", fmt.Errorf("currency %s is not supported", currency)
+        }
+        return currency, nil
+    }
+    return CurrencyForCountry(country), nil
+}
+
+// CurrencyNumericCode retorna o código ISO 4217 numérico da moeda (USD sem moeda conhecida)
+func CurrencyNumericCode(code string) string {
+    if info, ok := currencies[NormalizeCurrency(code)]; ok {
+        return info.Numeric
+    }
+    return currencies[DefaultCurrency].Numeric
+}
+
+// FormatMoney formata um valor para exibição na moeda (ex.: "$10.00", "€10.00")
+func FormatMoney(amount float64, currency string) string {
+    currency = NormalizeCurrency(currency)
+    if currency == "" {
+        currency = DefaultCurrency
+    }
+    if info, ok := currencies[currency]; ok {
+        return fmt.Sprintf("%s%.2f", info.Symbol, amount)
+    }
+    return fmt.Sprintf("%.2f %s", amount, currency)
+}
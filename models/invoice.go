@@ -7,6 +7,8 @@ type Invoice struct {
     MasterReference string    `json:"master_reference"`
     IsTrial         int       `json:"is_trial"`
     Total           float64   `json:"total"`
+    Tax             float64   `json:"tax"` // parte de Total que é imposto
+    Currency        string    `json:"currency"`
     DueDate         time.Time `json:"due_date"`
     IsPaid          int       `json:"is_paid"`
 }
\ No newline at end of file
@@ -16,6 +16,7 @@ type MasterAccount struct {
     PhoneNumber      string    `json:"phone_number"`
     RenewDate        time.Time `json:"renew_date"`
     TotalPrice       float64   `json:"total_price"`
+    Currency         string    `json:"currency"` // moeda de total_price e das cobranças
     ReferenceUUID    string    `json:"reference_uuid"`
     State            string    `json:"state"`
     City             string    `json:"city"`
@@ -11,6 +11,40 @@ type PaymentRequest struct {
     CustomerEmail string               `json:"email,omitempty"`
     BillingInfo   *types.BillingInfoType `json:"-"` // Alterado de authorizenet.BillingInfoType
     ThreeDSData   *types.ThreeDSData     `json:"threeDSData,omitempty"`
+    // ThreeDSAuth é preenchido pelo servidor após a autenticação 3D Secure (ECI/CAVV)
+    ThreeDSAuth   *types.ThreeDSAuthentication `json:"-"`
+
+    // OpaqueData é o nonce do Accept.js, alternativo a CardNumber/Expiry/CVV.
+    // O nonce só pode ser usado uma vez: o gateway que o consome preenche o customer
+    // profile criado a partir dele e o número mascarado do cartão (ex.: XXXX1111).
+    OpaqueData        *OpaqueData `json:"opaqueData,omitempty"`
+    CustomerProfileID string      `json:"-"`
+    PaymentProfileID  string      `json:"-"`
+    AccountNumber     string      `json:"-"`
+
+    // Moeda da cobrança (a do checkout); vazio = USD
+    Currency string `json:"-"`
+}
+
+// OpaqueData é o par dataDescriptor/dataValue devolvido pelo Accept.js no navegador
+type OpaqueData struct {
+    DataDescriptor string `json:"dataDescriptor"`
+    DataValue      string `json:"dataValue"`
+}
+
+// Valid indica se o nonce veio completo
+func (o *OpaqueData) Valid() bool {
+    return o != nil && o.DataDescriptor != "" && o.DataValue != ""
+}
+
+// HasOpaqueData indica se o pagamento usa um nonce do Accept.js ainda não consumido
+func (p *PaymentRequest) HasOpaqueData() bool {
+    return p.OpaqueData.Valid()
+}
+
+// HasStoredProfile indica se o nonce já foi convertido em customer profile
+func (p *PaymentRequest) HasStoredProfile() bool {
+    return p.CustomerProfileID != "" && p.PaymentProfileID != ""
 }
 
 type CardData struct {
@@ -18,4 +52,17 @@ type CardData struct {
     Expiry string
 }
 
-// Use tipos do novo pacote types
\ No newline at end of file
+// LastFour retorna os 4 últimos dígitos do cartão, seja do número completo ou do
+// número mascarado devolvido pelo gateway (vazio se desconhecido)
+func (p *PaymentRequest) LastFour() string {
+    number := p.CardNumber
+    if number == "" {
+        number = p.AccountNumber
+    }
+    if len(number) < 4 {
+        return ""
+    }
+    return number[len(number)-4:]
+}
+
+// Use tipos do novo pacote types
@@ -12,6 +12,11 @@ type TransactionResponse struct {
     Message       string `json:"message"`
     Error         string `json:"error,omitempty"`
     IsDuplicate   bool   `json:"is_duplicate,omitempty"`
+
+    // Preenchidos quando a autorização usou OpaqueData (Accept.js)
+    CustomerProfileID string `json:"customer_profile_id,omitempty"`
+    PaymentProfileID  string `json:"payment_profile_id,omitempty"`
+    AccountNumber     string `json:"account_number,omitempty"` // mascarado, ex.: XXXX1111
 }
 
 type SubscriptionResponse struct {
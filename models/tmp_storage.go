@@ -3,10 +3,31 @@ package models
 import "time"
 
 type PaymentDataStorage struct {
-	CheckoutID string    `json:"checkout_id"`
-	CardNumber string    `json:"card_number"`
-	CardExpiry string    `json:"card_expiry"`
-	CardCVV    string    `json:"card_cvv"`
-	CardName   string    `json:"card_name"`
-	CreatedAt  time.Time `json:"created_at"`
-}
\ No newline at end of file
+	CheckoutID string `json:"checkout_id"`
+	CardNumber string `json:"card_number"`
+	CardExpiry string `json:"card_expiry"`
+	CardCVV    string `json:"card_cvv"`
+	CardName   string `json:"card_name"`
+	// OpaqueData substitui os dados do cartão quando o checkout usou Accept.js. Depois
+	// de consumido, o nonce dá lugar ao customer profile criado a partir dele.
+	OpaqueData        *OpaqueData `json:"opaque_data,omitempty"`
+	CustomerProfileID string      `json:"customer_profile_id,omitempty"`
+	PaymentProfileID  string      `json:"payment_profile_id,omitempty"`
+	AccountNumber     string      `json:"account_number,omitempty"`
+	CreatedAt         time.Time   `json:"created_at"`
+}
+
+// PaymentRequest monta o request de pagamento a partir dos dados temporários
+func (d *PaymentDataStorage) PaymentRequest() *PaymentRequest {
+	return &PaymentRequest{
+		CardName:          d.CardName,
+		CardNumber:        d.CardNumber,
+		Expiry:            d.CardExpiry,
+		CVV:               d.CardCVV,
+		CheckoutID:        d.CheckoutID,
+		OpaqueData:        d.OpaqueData,
+		CustomerProfileID: d.CustomerProfileID,
+		PaymentProfileID:  d.PaymentProfileID,
+		AccountNumber:     d.AccountNumber,
+	}
+}
@@ -0,0 +1,190 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrJobNotFound indica que o job não está (mais) na fila de falhas
+var ErrJobNotFound = errors.New("job not found in failed queue")
+
+// ErrJobChanged indica que o job mudou na fila de falhas durante a edição
+var ErrJobChanged = errors.New("job changed in failed queue, reload and try again")
+
+// Stats é a profundidade de cada lista da fila
+type Stats struct {
+	Queued     int64 `json:"queued"`
+	Processing int64 `json:"processing"`
+	Delayed    int64 `json:"delayed"`
+	Failed     int64 `json:"failed"`
+}
+
+// FailedJob é um job da fila de falhas com o erro decodificado. Entradas com JSON
+// inválido vêm com Malformed e o conteúdo bruto em Raw.
+type FailedJob struct {
+	Job        *Job   `json:"job,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	FailedAt   string `json:"failed_at,omitempty"`
+	Exhausted  bool   `json:"all_retries_exhausted"`
+	Malformed  bool   `json:"malformed,omitempty"`
+	Raw        string `json:"raw,omitempty"`
+}
+
+// replaceScript troca um item da lista pelo novo conteúdo, desde que ele não tenha
+// mudado desde a leitura. Retorna 1 se trocou.
+//
+// KEYS[1] = lista, ARGV[1] = conteúdo lido, ARGV[2] = novo conteúdo
+var replaceScript = redis.NewScript(`
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+for i, item in ipairs(items) do
+	if item == ARGV[1] then
+		redis.call('LSET', KEYS[1], i - 1, ARGV[2])
+		return 1
+	end
+end
+return 0
+`)
+
+// Stats retorna quantos jobs há em cada lista
+func (q *Queue) Stats(ctx context.Context) (*Stats, error) {
+	pipe := q.client.Pipeline()
+	queued := pipe.LLen(ctx, q.queueName)
+	processing := pipe.LLen(ctx, q.processing)
+	delayed := pipe.ZCard(ctx, q.queueName+":delayed")
+	failed := pipe.LLen(ctx, q.failed)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get queue stats: %v", err)
+	}
+
+	return &Stats{
+		Queued:     queued.Val(),
+		Processing: processing.Val(),
+		Delayed:    delayed.Val(),
+		Failed:     failed.Val(),
+	}, nil
+}
+
+// decodeFailed monta o FailedJob de uma entrada da fila de falhas
+func decodeFailed(jobJSON string) FailedJob {
+	var job Job
+	if err := json.Unmarshal([]byte(jobJSON), &job); err != nil || job.ID == "" {
+		return FailedJob{Malformed: true, Raw: jobJSON}
+	}
+
+	failed := FailedJob{Job: &job}
+	failed.LastError, _ = job.Data["last_error"].(string)
+	failed.ErrorClass, _ = job.Data["error_class"].(string)
+	failed.FailedAt, _ = job.Data["final_failure_at"].(string)
+	failed.Exhausted, _ = job.Data["all_retries_exhausted"].(bool)
+	return failed
+}
+
+// ListFailed pagina a fila de falhas (mais antigos primeiro) e retorna o total da fila
+func (q *Queue) ListFailed(ctx context.Context, offset, limit int) ([]FailedJob, int64, error) {
+	total, err := q.client.LLen(ctx, q.failed).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count failed jobs: %v", err)
+	}
+
+	items, err := q.client.LRange(ctx, q.failed, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list failed jobs: %v", err)
+	}
+
+	jobs := make([]FailedJob, 0, len(items))
+	for _, item := range items {
+		jobs = append(jobs, decodeFailed(item))
+	}
+	return jobs, total, nil
+}
+
+// findFailed busca o job na fila de falhas e retorna também o JSON guardado
+func (q *Queue) findFailed(ctx context.Context, jobID string) (*Job, string, error) {
+	items, err := q.client.LRange(ctx, q.failed, 0, -1).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list failed jobs: %v", err)
+	}
+
+	for _, item := range items {
+		failed := decodeFailed(item)
+		if failed.Job != nil && failed.Job.ID == jobID {
+			return failed.Job, item, nil
+		}
+	}
+	return nil, "", ErrJobNotFound
+}
+
+// GetFailed retorna um job da fila de falhas
+func (q *Queue) GetFailed(ctx context.Context, jobID string) (*Job, error) {
+	job, _, err := q.findFailed(ctx, jobID)
+	return job, err
+}
+
+// FailedJobIDs retorna os IDs de todos os jobs da fila de falhas
+func (q *Queue) FailedJobIDs(ctx context.Context) ([]string, error) {
+	items, err := q.client.LRange(ctx, q.failed, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failed jobs: %v", err)
+	}
+
+	var ids []string
+	for _, item := range items {
+		if failed := decodeFailed(item); failed.Job != nil {
+			ids = append(ids, failed.Job.ID)
+		}
+	}
+	return ids, nil
+}
+
+// UpdateFailedJobData troca os dados de um job da fila de falhas (ex.: corrigir um
+// checkout_id) para que o próximo retry use os dados novos. Retorna o job como estava.
+func (q *Queue) UpdateFailedJobData(ctx context.Context, jobID string, data map[string]interface{}) (*Job, error) {
+	job, jobJSON, err := q.findFailed(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *job
+	updated.Data = data
+	updatedJSON, err := json.Marshal(updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job: %v", err)
+	}
+
+	replaced, err := replaceScript.Run(ctx, q.client, []string{q.failed}, jobJSON, updatedJSON).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update failed job %s: %v", jobID, err)
+	}
+	if replaced == 0 {
+		return nil, ErrJobChanged
+	}
+
+	log.Printf("Updated data of failed job %s of type %s", job.ID, job.Type)
+	return job, nil
+}
+
+// DiscardFailed remove o job da fila de falhas sem processá-lo e retorna o job removido
+func (q *Queue) DiscardFailed(ctx context.Context, jobID string) (*Job, error) {
+	job, jobJSON, err := q.findFailed(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := q.client.LRem(ctx, q.failed, 1, jobJSON).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove job from failed queue: %v", err)
+	}
+	if removed == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	log.Printf("Discarded failed job %s of type %s", job.ID, job.Type)
+	return job, nil
+}
@@ -0,0 +1,128 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// A chave de idempotência de um job (ex.: "delayed_payment:<checkout_id>") fica reservada
+// enquanto o job está pendente, em processamento ou aguardando retry, e por mais
+// CompletedKeyRetention depois de concluído. Enquanto isso, enfileirar outro job com a
+// mesma chave não faz nada.
+const (
+	PendingKeyTTL         = 24 * time.Hour // validade da reserva de um job ainda não concluído
+	CompletedKeyRetention = time.Hour      // por quanto tempo um job concluído bloqueia repetições
+)
+
+// EnqueueOption ajusta um job no momento do Enqueue/EnqueueDelayed
+type EnqueueOption func(*Job)
+
+// WithIdempotencyKey faz o enqueue ser ignorado se já houver um job com a mesma chave
+// pendente, em processamento ou concluído há pouco
+func WithIdempotencyKey(key string) EnqueueOption {
+	return func(job *Job) {
+		job.IdempotencyKey = key
+	}
+}
+
+// enqueueUniqueScript reserva a chave e enfileira o job de forma atômica. Retorna 1 se o
+// job foi enfileirado ou o ID do job que já tem a chave.
+//
+// KEYS[1] = chave de idempotência, KEYS[2] = fila (lista) ou :delayed (sorted set)
+// ARGV[1] = ID do job, ARGV[2] = TTL da reserva (ms), ARGV[3] = JSON do job,
+// ARGV[4] = score no :delayed ("" para a fila principal)
+var enqueueUniqueScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('GET', KEYS[1])
+end
+if ARGV[4] == '' then
+	redis.call('RPUSH', KEYS[2], ARGV[3])
+else
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[3])
+end
+return 1
+`)
+
+// ErrIdempotencyKeyHeld indica que a chave do job pertence a outro job pendente ou
+// concluído há pouco: repetir o job agora o executaria duas vezes
+var ErrIdempotencyKeyHeld = errors.New("idempotency key held by another job")
+
+// retryUniqueScript devolve um job da fila de falhas para a fila principal de forma
+// atômica, reservando a chave de idempotência. Retorna 1 se moveu, 0 se o job não está
+// mais na fila de falhas ou o ID do job que já tem a chave.
+//
+// KEYS[1] = fila de falhas, KEYS[2] = fila, KEYS[3] = chave de idempotência
+// ARGV[1] = JSON na fila de falhas, ARGV[2] = novo JSON, ARGV[3] = ID do job ("" sem
+// chave), ARGV[4] = TTL da reserva (ms)
+var retryUniqueScript = redis.NewScript(`
+if ARGV[3] ~= '' then
+	local holder = redis.call('GET', KEYS[3])
+	if holder and holder ~= ARGV[3] then
+		return holder
+	end
+end
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+if ARGV[3] ~= '' then
+	redis.call('SET', KEYS[3], ARGV[3], 'PX', ARGV[4])
+end
+redis.call('RPUSH', KEYS[2], ARGV[2])
+return 1
+`)
+
+// touchKeyScript muda a validade da chave (0 = apaga) se ela ainda pertencer ao job
+//
+// KEYS[1] = chave de idempotência, ARGV[1] = ID do job, ARGV[2] = nova validade (ms)
+var touchKeyScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+else
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`)
+
+func (q *Queue) idempotencyKey(key string) string {
+	return q.queueName + ":idempotency:" + key
+}
+
+// pushUnique enfileira o job só se a chave de idempotência estiver livre. Retorna false
+// (sem erro) se outro job já tiver a chave.
+func (q *Queue) pushUnique(ctx context.Context, job *Job, jobJSON []byte, target string, score string, ttl time.Duration) (bool, error) {
+	result, err := enqueueUniqueScript.Run(ctx, q.client,
+		[]string{q.idempotencyKey(job.IdempotencyKey), target},
+		job.ID, ttl.Milliseconds(), jobJSON, score).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to push job to queue: %v", err)
+	}
+
+	if existingID, ok := result.(string); ok {
+		log.Printf("Skipped job of type %s: idempotency key %s already held by job %s",
+			job.Type, job.IdempotencyKey, existingID)
+		return false, nil
+	}
+	return true, nil
+}
+
+// touchIdempotencyKey renova (ttl > 0) ou libera (ttl = 0) a chave do job. Falhas só
+// são registradas: a chave expira sozinha.
+func (q *Queue) touchIdempotencyKey(ctx context.Context, job *Job, ttl time.Duration) {
+	if job.IdempotencyKey == "" {
+		return
+	}
+
+	err := touchKeyScript.Run(ctx, q.client,
+		[]string{q.idempotencyKey(job.IdempotencyKey)}, job.ID, ttl.Milliseconds()).Err()
+	if err != nil {
+		log.Printf("Warning: Failed to update idempotency key %s of job %s: %v", job.IdempotencyKey, job.ID, err)
+	}
+}
@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestQueue conecta ao Redis de TEST_REDIS_URL (ex.: redis://localhost:6379/15) com
+// um nome de fila exclusivo, apagado no fim do teste
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+
+	redisURL := os.Getenv("TEST_REDIS_URL")
+	if redisURL == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+
+	name := "test_jobs_" + uuid.New().String()
+	q, err := NewQueue(redisURL, name)
+	if err != nil {
+		t.Fatalf("NewQueue: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, _ := q.client.Keys(ctx, name+"*").Result()
+		if len(keys) > 0 {
+			q.client.Del(ctx, keys...)
+		}
+		q.Close()
+	})
+
+	return q
+}
+
+func queueLength(t *testing.T, q *Queue) (queued, delayed int64) {
+	t.Helper()
+
+	stats, err := q.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	return stats.Queued, stats.Delayed
+}
+
+func TestEnqueueWithIdempotencyKeyIsDeduplicated(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := q.Enqueue(ctx, JobTypeDelayedPayment, map[string]interface{}{"checkout_id": "c1"},
+			WithIdempotencyKey("delayed_payment:c1")); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	// A chave vale também para os jobs agendados
+	if err := q.EnqueueDelayed(ctx, JobTypeDelayedPayment, map[string]interface{}{"checkout_id": "c1"},
+		time.Minute, WithIdempotencyKey("delayed_payment:c1")); err != nil {
+		t.Fatalf("EnqueueDelayed: %v", err)
+	}
+	if err := q.Enqueue(ctx, JobTypeDelayedPayment, map[string]interface{}{"checkout_id": "c2"},
+		WithIdempotencyKey("delayed_payment:c2")); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	if queued, delayed := queueLength(t, q); queued != 2 || delayed != 0 {
+		t.Errorf("queued = %d, delayed = %d; want 2 and 0", queued, delayed)
+	}
+}
+
+func TestEnqueueWithoutKeyIsNotDeduplicated(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := q.Enqueue(ctx, JobTypeSyncSubscriptionAmount, map[string]interface{}{"master_reference": "m1"}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	if queued, _ := queueLength(t, q); queued != 2 {
+		t.Errorf("queued = %d, want 2", queued)
+	}
+}
+
+func TestCompletedJobKeepsKeyReserved(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	key := WithIdempotencyKey("cancel_subscription:123")
+
+	if err := q.Enqueue(ctx, JobTypeCancelSubscription, map[string]interface{}{"subscription_id": "123"}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	job, err := q.Dequeue(ctx, time.Second)
+	if err != nil || job == nil {
+		t.Fatalf("Dequeue: job %v, err %v", job, err)
+	}
+	if err := q.CompleteJob(ctx, job); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+
+	// Concluído há pouco: repetir o enqueue não faz nada
+	if err := q.Enqueue(ctx, JobTypeCancelSubscription, map[string]interface{}{"subscription_id": "123"}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if queued, _ := queueLength(t, q); queued != 0 {
+		t.Errorf("queued = %d after completed job, want 0", queued)
+	}
+
+	ttl, err := q.client.PTTL(ctx, q.idempotencyKey("cancel_subscription:123")).Result()
+	if err != nil {
+		t.Fatalf("PTTL: %v", err)
+	}
+	if ttl <= 0 || ttl > CompletedKeyRetention {
+		t.Errorf("key TTL = %s, want up to %s", ttl, CompletedKeyRetention)
+	}
+}
+
+func TestFailedJobReleasesKey(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	key := WithIdempotencyKey("webhook_event:1")
+
+	if err := q.Enqueue(ctx, JobTypeWebhookEvent, map[string]interface{}{"event_id": 1}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	job, err := q.Dequeue(ctx, time.Second)
+	if err != nil || job == nil {
+		t.Fatalf("Dequeue: job %v, err %v", job, err)
+	}
+	if err := q.FailJob(ctx, job, Permanent(errors.New("invalid event_id"))); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+
+	// Na fila de falhas a chave fica livre para um novo enqueue equivalente
+	if err := q.Enqueue(ctx, JobTypeWebhookEvent, map[string]interface{}{"event_id": 1}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.Queued != 1 || stats.Failed != 1 {
+		t.Errorf("queued = %d, failed = %d; want 1 and 1", stats.Queued, stats.Failed)
+	}
+}
+
+func TestRetriedJobKeepsKeyReserved(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	key := WithIdempotencyKey("activation_email:c1")
+
+	if err := q.Enqueue(ctx, JobTypeActivationEmail, map[string]interface{}{"checkout_id": "c1"}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	job, err := q.Dequeue(ctx, time.Second)
+	if err != nil || job == nil {
+		t.Fatalf("Dequeue: job %v, err %v", job, err)
+	}
+	if err := q.FailJob(ctx, job, errors.New("smtp unavailable")); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+
+	// Aguardando retry: o job continua dono da chave
+	if err := q.Enqueue(ctx, JobTypeActivationEmail, map[string]interface{}{"checkout_id": "c1"}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if queued, delayed := queueLength(t, q); queued != 0 || delayed != 1 {
+		t.Errorf("queued = %d, delayed = %d; want 0 and 1", queued, delayed)
+	}
+}
+
+func TestRetryJobRespectsKeyHeldByAnotherJob(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	key := WithIdempotencyKey("webhook_event:2")
+
+	if err := q.Enqueue(ctx, JobTypeWebhookEvent, map[string]interface{}{"event_id": 2}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	failed, err := q.Dequeue(ctx, time.Second)
+	if err != nil || failed == nil {
+		t.Fatalf("Dequeue: job %v, err %v", failed, err)
+	}
+	if err := q.FailJob(ctx, failed, Permanent(errors.New("bad payload"))); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+
+	// Um job novo com a mesma chave entra antes do retry manual
+	if err := q.Enqueue(ctx, JobTypeWebhookEvent, map[string]interface{}{"event_id": 2}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	if err := q.RetryJob(ctx, failed.ID); !errors.Is(err, ErrIdempotencyKeyHeld) {
+		t.Fatalf("RetryJob() error = %v, want ErrIdempotencyKeyHeld", err)
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.Queued != 1 || stats.Failed != 1 {
+		t.Errorf("queued = %d, failed = %d; want the job kept in the failed queue", stats.Queued, stats.Failed)
+	}
+}
+
+func TestRetryJobReservesKey(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	key := WithIdempotencyKey("webhook_event:3")
+
+	if err := q.Enqueue(ctx, JobTypeWebhookEvent, map[string]interface{}{"event_id": 3}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	failed, err := q.Dequeue(ctx, time.Second)
+	if err != nil || failed == nil {
+		t.Fatalf("Dequeue: job %v, err %v", failed, err)
+	}
+	if err := q.FailJob(ctx, failed, Permanent(errors.New("bad payload"))); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+
+	if err := q.RetryJob(ctx, failed.ID); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+	// O job devolvido volta a ser dono da chave
+	if err := q.Enqueue(ctx, JobTypeWebhookEvent, map[string]interface{}{"event_id": 3}, key); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if queued, _ := queueLength(t, q); queued != 1 {
+		t.Errorf("queued = %d, want 1", queued)
+	}
+}
@@ -8,17 +8,30 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 )
 
 type JobType string
 
 const (
-	JobTypeVoidTransaction    JobType = "void_transaction"
-	JobTypeCreateSubscription JobType = "create_subscription"
-	JobTypeProcessPayment     JobType = "process_payment"     
-	JobTypeCreateAccount      JobType = "create_account"
-	JobTypeDelayedPayment     JobType = "delayed_payment"
-	JobTypeActivationEmail    JobType = "activation_email"
+	JobTypeVoidTransaction        JobType = "void_transaction"
+	JobTypeCreateSubscription     JobType = "create_subscription"
+	JobTypeProcessPayment         JobType = "process_payment"
+	JobTypeCreateAccount          JobType = "create_account"
+	JobTypeDelayedPayment         JobType = "delayed_payment"
+	JobTypeActivationEmail        JobType = "activation_email"
+	JobTypeWebhookEvent           JobType = "webhook_event"
+	JobTypeReconcileSettlement    JobType = "reconcile_settlement"
+	JobTypeSweepPaymentData       JobType = "sweep_payment_data"
+	JobTypeCompleteCancellations  JobType = "complete_cancellations"
+	JobTypeApplyAccountCredits    JobType = "apply_account_credits"
+	JobTypeResumeSubscriptions    JobType = "resume_subscriptions"
+	JobTypeStartDunning           JobType = "start_dunning"
+	JobTypeProcessDunning         JobType = "process_dunning"
+	JobTypeTrialReminders         JobType = "trial_reminders"
+	JobTypeSyncSubscriptions      JobType = "sync_subscriptions"
+	JobTypeSyncSubscriptionAmount JobType = "sync_subscription_amount"
+	JobTypeCancelSubscription     JobType = "cancel_subscription"
 )
 
 type Job struct {
@@ -27,13 +40,17 @@ type Job struct {
 	Data       map[string]interface{} `json:"data"`
 	CreatedAt  time.Time             `json:"created_at"`
 	RetryCount int                   `json:"retry_count"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
 }
 
 type Queue struct {
 	client     *redis.Client
 	queueName  string
 	processing string
+	leases     string
 	failed     string
+	policies   map[JobType]RetryPolicy
+	store      JobStore
 }
 
 func NewQueue(redisURL, queueName string) (*Queue, error) {
@@ -54,40 +71,51 @@ func NewQueue(redisURL, queueName string) (*Queue, error) {
 		client:     client,
 		queueName:  queueName,
 		processing: queueName + ":processing",
+		leases:     queueName + ":leases",
 		failed:     queueName + ":failed",
+		policies:   DefaultRetryPolicies(),
 	}, nil
 }
 
-func (q *Queue) Enqueue(ctx context.Context, jobType JobType, data map[string]interface{}) error {
-	job := Job{
-		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+// newJob monta um job com ID único (UUID) e aplica as opções do enqueue
+func newJob(jobType JobType, data map[string]interface{}, opts []EnqueueOption) *Job {
+	job := &Job{
+		ID:        uuid.New().String(),
 		Type:      jobType,
 		Data:      data,
 		CreatedAt: time.Now(),
 	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	return job
+}
+
+func (q *Queue) Enqueue(ctx context.Context, jobType JobType, data map[string]interface{}, opts ...EnqueueOption) error {
+	job := newJob(jobType, data, opts)
 
 	jobJSON, err := json.Marshal(job)
 	if err != nil {
 		return fmt.Errorf("failed to marshal job: %v", err)
 	}
 
-	err = q.client.RPush(ctx, q.queueName, jobJSON).Err()
-	if err != nil {
+	if job.IdempotencyKey != "" {
+		enqueued, err := q.pushUnique(ctx, job, jobJSON, q.queueName, "", PendingKeyTTL)
+		if err != nil || !enqueued {
+			return err
+		}
+	} else if err := q.client.RPush(ctx, q.queueName, jobJSON).Err(); err != nil {
 		return fmt.Errorf("failed to push job to queue: %v", err)
 	}
+	q.recordState(job, JobStateQueued, &job.CreatedAt)
 
 	log.Printf("Enqueued job %s of type %s", job.ID, job.Type)
 	return nil
 }
 
 // EnqueueDelayed adiciona um job para ser processado após um delay específico
-func (q *Queue) EnqueueDelayed(ctx context.Context, jobType JobType, data map[string]interface{}, delay time.Duration) error {
-	job := Job{
-		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
-		Type:      jobType,
-		Data:      data,
-		CreatedAt: time.Now(),
-	}
+func (q *Queue) EnqueueDelayed(ctx context.Context, jobType JobType, data map[string]interface{}, delay time.Duration, opts ...EnqueueOption) error {
+	job := newJob(jobType, data, opts)
 
 	jobJSON, err := json.Marshal(job)
 	if err != nil {
@@ -100,22 +128,29 @@ func (q *Queue) EnqueueDelayed(ctx context.Context, jobType JobType, data map[st
 
 	// Adicionar ao sorted set com score sendo o timestamp de execução
 	delayedQueueName := q.queueName + ":delayed"
-	err = q.client.ZAdd(ctx, delayedQueueName, &redis.Z{
+	if job.IdempotencyKey != "" {
+		enqueued, err := q.pushUnique(ctx, job, jobJSON, delayedQueueName,
+			fmt.Sprintf("%d", executeAt.Unix()), PendingKeyTTL+delay)
+		if err != nil || !enqueued {
+			return err
+		}
+	} else if err := q.client.ZAdd(ctx, delayedQueueName, &redis.Z{
 		Score:  score,
 		Member: jobJSON,
-	}).Err()
-
-	if err != nil {
+	}).Err(); err != nil {
 		return fmt.Errorf("failed to push delayed job to queue: %v", err)
 	}
+	q.recordState(job, JobStateDelayed, &executeAt)
 
 	log.Printf("Enqueued delayed job %s of type %s to execute at %s", 
 		job.ID, job.Type, executeAt.Format("2006-01-02 15:04:05"))
 	return nil
 }
 
+// Dequeue move o próximo job para :processing de forma atômica (BLMOVE) e registra o
+// lease do worker. Se o worker cair antes de concluir, o reaper devolve o job para a fila.
 func (q *Queue) Dequeue(ctx context.Context, timeout time.Duration) (*Job, error) {
-	result, err := q.client.BLPop(ctx, timeout, q.queueName).Result()
+	jobJSON, err := q.client.BLMove(ctx, q.queueName, q.processing, "LEFT", "RIGHT", timeout).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil 
@@ -123,63 +158,73 @@ func (q *Queue) Dequeue(ctx context.Context, timeout time.Duration) (*Job, error
 		return nil, fmt.Errorf("failed to get job from queue: %v", err)
 	}
 
-	if len(result) < 2 {
-		return nil, fmt.Errorf("unexpected BLPOP result format")
-	}
-
 	var job Job
-	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+	if err := json.Unmarshal([]byte(jobJSON), &job); err != nil {
+		// JSON inválido nunca vai ser processado: vai direto para a fila de falhas
+		pipe := q.client.TxPipeline()
+		pipe.LRem(ctx, q.processing, 1, jobJSON)
+		pipe.RPush(ctx, q.failed, jobJSON)
+		if _, pipeErr := pipe.Exec(ctx); pipeErr != nil {
+			log.Printf("Warning: Failed to move malformed job to failed queue: %v", pipeErr)
+		}
 		return nil, fmt.Errorf("failed to unmarshal job: %v", err)
 	}
 
-	err = q.client.RPush(ctx, q.processing, result[1]).Err()
-	if err != nil {
-		log.Printf("Warning: Failed to move job %s to processing queue: %v", job.ID, err)
+	if err := q.acquireLease(ctx, &job); err != nil {
+		// Sem lease o reaper dá ao job um lease de carência antes de devolvê-lo
+		log.Printf("Warning: Failed to register lease of job %s: %v", job.ID, err)
 	}
+	q.recordState(&job, JobStateProcessing, nil)
 
 	return &job, nil
 }
 
 func (q *Queue) CompleteJob(ctx context.Context, job *Job) error {
-	jobJSON, err := json.Marshal(job)
+	found, err := q.removeFromProcessing(ctx, job)
 	if err != nil {
-		return fmt.Errorf("failed to marshal job: %v", err)
+		return err
 	}
 
-	err = q.client.LRem(ctx, q.processing, 1, jobJSON).Err()
-	if err != nil {
-		return fmt.Errorf("failed to remove job from processing queue: %v", err)
+	if !found {
+		log.Printf("Warning: Completed job %s of type %s after its lease expired; it was already requeued", job.ID, job.Type)
+		return nil
 	}
 
+	q.touchIdempotencyKey(ctx, job, CompletedKeyRetention)
+	q.recordState(job, JobStateSucceeded, nil)
+
 	log.Printf("Completed job %s of type %s", job.ID, job.Type)
 	return nil
 }
 
-// CORREÇÃO: Melhorar lógica de retry e controle de email
+// FailJob agenda o retry do job conforme a política do tipo. Erros de classe não
+// repetível, ou o fim das tentativas, levam o job para a fila de falhas.
 func (q *Queue) FailJob(ctx context.Context, job *Job, err error) error {
+	policy := q.RetryPolicy(job.Type)
+	class := Classify(err)
 	job.RetryCount++
 	
 	job.Data["last_error"] = err.Error()
+	job.Data["error_class"] = string(class)
 	job.Data["failed_at"] = time.Now()
-
-	const maxRetries = 5
 	
-	jobJSON, marshalErr := json.Marshal(job)
-	if marshalErr != nil {
-		return fmt.Errorf("failed to marshal job: %v", marshalErr)
-	}
-
-	if err := q.client.LRem(ctx, q.processing, 1, jobJSON).Err(); err != nil {
-		log.Printf("Warning: Failed to remove job %s from processing queue: %v", job.ID, err)
+	found, removeErr := q.removeFromProcessing(ctx, job)
+	if removeErr != nil {
+		log.Printf("Warning: %v", removeErr)
+	} else if !found {
+		// O lease venceu e o reaper já devolveu o job: agendar o retry duplicaria o job
+		log.Printf("Warning: Job %s of type %s failed after its lease expired; it was already requeued", job.ID, job.Type)
+		return nil
 	}
 	
-	// CORREÇÃO: Melhor controle de retry com informação sobre última tentativa
-	if job.RetryCount <= maxRetries {
-		delaySeconds := 15 * (1 << (job.RetryCount - 1))
-		retryTime := time.Now().Add(time.Duration(delaySeconds) * time.Second)
+	retryable := policy.Retryable(class)
+	if retryable && job.RetryCount < policy.MaxAttempts {
+		delay := policy.Delay(job.RetryCount)
+		retryTime := time.Now().Add(delay)
+		isLastAttempt := job.RetryCount == policy.MaxAttempts-1
 		
 		job.Data["next_retry_at"] = retryTime
-		job.Data["is_last_attempt"] = job.RetryCount == maxRetries // CORREÇÃO: Flag para última tentativa
+		job.Data["is_last_attempt"] = isLastAttempt // a próxima execução é a última
 		
 		updatedJobJSON, _ := json.Marshal(job)
 		
@@ -194,10 +239,31 @@ func (q *Queue) FailJob(ctx context.Context, job *Job, err error) error {
 			if err := q.client.RPush(ctx, q.failed, updatedJobJSON).Err(); err != nil {
 				return fmt.Errorf("failed to push job to failed queue: %v", err)
 			}
+			q.recordState(job, JobStateFailed, nil)
+			return nil
+		}
+		q.touchIdempotencyKey(ctx, job, PendingKeyTTL+delay)
+		q.recordState(job, JobStateDelayed, &retryTime)
+		
+		log.Printf("Job %s of type %s scheduled for retry %d/%d in %s (last_attempt: %v)", 
+			job.ID, job.Type, job.RetryCount, policy.MaxAttempts-1, delay.Round(time.Second), isLastAttempt)
+		return nil
+	}
+	
+	// Job na fila de falhas libera a chave: um novo enqueue equivalente volta a valer
+	q.touchIdempotencyKey(ctx, job, 0)
+	
+	if !retryable {
+		job.Data["non_retryable"] = true
+		job.Data["final_failure_at"] = time.Now()
+		finalJobJSON, _ := json.Marshal(job)
+		
+		if err := q.client.RPush(ctx, q.failed, finalJobJSON).Err(); err != nil {
+			return fmt.Errorf("failed to push job to failed queue: %v", err)
 		}
+		q.recordState(job, JobStateFailed, nil)
 		
-		log.Printf("Job %s of type %s scheduled for retry %d/%d in %d seconds (last_attempt: %v)", 
-			job.ID, job.Type, job.RetryCount, maxRetries, delaySeconds, job.RetryCount == maxRetries)
+		log.Printf("Job %s of type %s moved to failed queue without retry (%s error)", job.ID, job.Type, class)
 		return nil
 	}
 	
@@ -209,6 +275,7 @@ func (q *Queue) FailJob(ctx context.Context, job *Job, err error) error {
 	if err := q.client.RPush(ctx, q.failed, finalJobJSON).Err(); err != nil {
 		return fmt.Errorf("failed to push job to failed queue: %v", err)
 	}
+	q.recordState(job, JobStateFailed, nil)
 
 	log.Printf("Job %s of type %s moved to failed queue after %d retries (all attempts exhausted)", job.ID, job.Type, job.RetryCount)
 	return nil
@@ -244,6 +311,9 @@ func (q *Queue) ProcessDelayedJobs(ctx context.Context) error {
 			continue
 		}
 		
+		now := time.Now()
+		q.recordState(&job, JobStateQueued, &now)
+		
 		log.Printf("Moved delayed job %s of type %s to main queue for processing (retry %d)", 
 			job.ID, job.Type, job.RetryCount)
 	}
@@ -265,32 +335,48 @@ func (q *Queue) RetryJob(ctx context.Context, jobID string) error {
 		}
 
 		if job.ID == jobID {
-			if err := q.client.LRem(ctx, q.failed, 1, jobJSON).Err(); err != nil {
-				return fmt.Errorf("failed to remove job from failed queue: %v", err)
-			}
-
 			// CORREÇÃO: Resetar contador de retry para retry manual
 			job.RetryCount = 0
+			if job.Data == nil {
+				job.Data = map[string]interface{}{}
+			}
 			job.Data["manual_retry"] = true
 			job.Data["manual_retry_at"] = time.Now()
 			
 			// Remover flags de falha anterior
 			delete(job.Data, "all_retries_exhausted")
+			delete(job.Data, "non_retryable")
 			delete(job.Data, "final_failure_at")
 			delete(job.Data, "is_last_attempt")
 			
 			updatedJobJSON, _ := json.Marshal(job)
 
-			if err := q.client.RPush(ctx, q.queueName, updatedJobJSON).Err(); err != nil {
-				return fmt.Errorf("failed to push job to main queue: %v", err)
+			// Com chave de idempotência, o job só volta se nenhum outro job a tiver
+			keyOwner := ""
+			if job.IdempotencyKey != "" {
+				keyOwner = job.ID
+			}
+			result, err := retryUniqueScript.Run(ctx, q.client,
+				[]string{q.failed, q.queueName, q.idempotencyKey(job.IdempotencyKey)},
+				jobJSON, updatedJobJSON, keyOwner, PendingKeyTTL.Milliseconds()).Result()
+			if err != nil {
+				return fmt.Errorf("failed to requeue job %s: %v", jobID, err)
+			}
+			if holder, ok := result.(string); ok {
+				return fmt.Errorf("%w: %s is held by job %s", ErrIdempotencyKeyHeld, job.IdempotencyKey, holder)
+			}
+			if moved, _ := result.(int64); moved == 0 {
+				return ErrJobNotFound
 			}
+			now := time.Now()
+			q.recordState(&job, JobStateQueued, &now)
 
 			log.Printf("Manually requeued job %s of type %s (retry count reset)", job.ID, job.Type)
 			return nil
 		}
 	}
 
-	return fmt.Errorf("job %s not found in failed queue", jobID)
+	return ErrJobNotFound
 }
 
 // CORREÇÃO: Nova função para verificar se é a última tentativa baseada nos dados do job
@@ -300,9 +386,8 @@ func (q *Queue) IsLastAttempt(job *Job) bool {
 			return lastAttempt
 		}
 	}
-	// Fallback: verificar se atingiu o máximo de retries
-	const maxRetries = 5
-	return job.RetryCount >= maxRetries
+	// Fallback: verificar se atingiu o máximo de tentativas da política do tipo
+	return job.RetryCount >= q.RetryPolicy(job.Type).MaxAttempts-1
 }
 
 func (q *Queue) Client() *redis.Client {
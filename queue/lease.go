@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Um job em :processing fica reservado (lease) para o worker que o tirou da fila. O worker
+// renova o lease enquanto processa; se ele morrer, o lease vence e o reaper devolve o job
+// para a fila principal.
+const (
+	LeaseTimeout      = 2 * time.Minute  // validade do lease sem heartbeat
+	HeartbeatInterval = 30 * time.Second // intervalo de renovação do lease pelo worker
+)
+
+// ErrLeaseLost indica que o lease do job venceu e ele já foi devolvido para a fila
+var ErrLeaseLost = errors.New("job lease lost")
+
+// removeScript remove de :processing o job com o ID informado, sem depender do JSON
+// atual do job (Data muda durante o processamento). Retorna 1 se o job foi encontrado.
+//
+// KEYS[1] = :processing, KEYS[2] = :leases, ARGV[1] = ID do job
+var removeScript = redis.NewScript(`
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('ZREM', KEYS[2], ARGV[1])
+for _, item in ipairs(items) do
+	local ok, job = pcall(cjson.decode, item)
+	if ok and type(job) == 'table' and tostring(job.id) == ARGV[1] then
+		redis.call('LREM', KEYS[1], 1, item)
+		return 1
+	end
+end
+return 0
+`)
+
+// heartbeatScript renova o lease só se ele ainda existir (o reaper pode já ter devolvido o job)
+//
+// KEYS[1] = :leases, ARGV[1] = ID do job, ARGV[2] = novo vencimento (ms)
+var heartbeatScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// reapScript devolve para a fila principal os jobs de :processing com lease vencido,
+// contando a execução perdida como tentativa. Job que esgotou as tentativas da sua
+// política (worker que cai sempre no mesmo job) vai para :failed e libera a chave de
+// idempotência. Job sem lease (o worker caiu entre o BLMOVE e o registro do lease) ganha
+// um lease de carência; JSON inválido vai para :failed. Retorna os jobs devolvidos e os
+// que foram para :failed.
+//
+// KEYS[1] = :processing, KEYS[2] = :leases, KEYS[3] = fila principal, KEYS[4] = :failed
+// ARGV[1] = agora (ms), ARGV[2] = vencimento do lease de carência (ms),
+// ARGV[3] = JSON {tipo: MaxAttempts}, ARGV[4] = MaxAttempts padrão,
+// ARGV[5] = prefixo das chaves de idempotência, ARGV[6] = agora (RFC 3339)
+var reapScript = redis.NewScript(`
+local requeued = {}
+local failed = {}
+local maxAttempts = cjson.decode(ARGV[3])
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+for _, item in ipairs(items) do
+	local ok, job = pcall(cjson.decode, item)
+	if not ok or type(job) ~= 'table' or job.id == nil then
+		redis.call('LREM', KEYS[1], 1, item)
+		redis.call('RPUSH', KEYS[4], item)
+	else
+		local id = tostring(job.id)
+		local expiry = redis.call('ZSCORE', KEYS[2], id)
+		if not expiry then
+			redis.call('ZADD', KEYS[2], ARGV[2], id)
+		elseif tonumber(expiry) <= tonumber(ARGV[1]) then
+			redis.call('LREM', KEYS[1], 1, item)
+			redis.call('ZREM', KEYS[2], id)
+
+			local max = tonumber(maxAttempts[tostring(job.type)] or ARGV[4])
+			job.retry_count = (tonumber(job.retry_count) or 0) + 1
+			if type(job.data) ~= 'table' then
+				job.data = {}
+			end
+			job.data.last_error = 'job lease expired (worker died or stalled)'
+			job.data.error_class = 'transient'
+
+			if job.retry_count >= max then
+				job.data.all_retries_exhausted = true
+				job.data.final_failure_at = ARGV[6]
+				local encoded = cjson.encode(job)
+				redis.call('RPUSH', KEYS[4], encoded)
+				if job.idempotency_key and job.idempotency_key ~= '' then
+					local key = ARGV[5] .. tostring(job.idempotency_key)
+					if redis.call('GET', key) == id then
+						redis.call('DEL', key)
+					end
+				end
+				table.insert(failed, encoded)
+			else
+				job.data.is_last_attempt = (job.retry_count == max - 1)
+				local encoded = cjson.encode(job)
+				redis.call('RPUSH', KEYS[3], encoded)
+				table.insert(requeued, encoded)
+			end
+		end
+	end
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+return {requeued, failed}
+`)
+
+// leaseExpiry retorna o vencimento (ms) de um lease iniciado agora
+func leaseExpiry() int64 {
+	return time.Now().Add(LeaseTimeout).UnixNano() / int64(time.Millisecond)
+}
+
+// acquireLease registra o lease do job recém-tirado da fila
+func (q *Queue) acquireLease(ctx context.Context, job *Job) error {
+	return q.client.ZAdd(ctx, q.leases, &redis.Z{
+		Score:  float64(leaseExpiry()),
+		Member: job.ID,
+	}).Err()
+}
+
+// Heartbeat renova o lease do job em processamento. Retorna ErrLeaseLost se o lease já
+// venceu e o job voltou para a fila (outro worker pode estar com ele).
+func (q *Queue) Heartbeat(ctx context.Context, job *Job) error {
+	renewed, err := heartbeatScript.Run(ctx, q.client, []string{q.leases}, job.ID, leaseExpiry()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to renew lease of job %s: %v", job.ID, err)
+	}
+	if renewed == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// removeFromProcessing tira o job de :processing pelo ID. Retorna false se ele não
+// estava lá (o lease venceu e o reaper já o devolveu para a fila).
+func (q *Queue) removeFromProcessing(ctx context.Context, job *Job) (bool, error) {
+	removed, err := removeScript.Run(ctx, q.client, []string{q.processing, q.leases}, job.ID).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove job %s from processing queue: %v", job.ID, err)
+	}
+	return removed == 1, nil
+}
+
+// RequeueExpired devolve para a fila principal os jobs cujo lease venceu (worker morto
+// ou travado) e retorna quantos foram devolvidos. Cada lease vencido conta como uma
+// tentativa; o job que esgota as tentativas da política vai para a fila de falhas.
+func (q *Queue) RequeueExpired(ctx context.Context) (int, error) {
+	maxAttempts := make(map[JobType]int, len(q.policies))
+	for jobType, policy := range q.policies {
+		maxAttempts[jobType] = policy.MaxAttempts
+	}
+	maxAttemptsJSON, err := json.Marshal(maxAttempts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal retry policies: %v", err)
+	}
+
+	now := time.Now()
+	result, err := reapScript.Run(ctx, q.client,
+		[]string{q.processing, q.leases, q.queueName, q.failed},
+		now.UnixNano()/int64(time.Millisecond), leaseExpiry(), maxAttemptsJSON,
+		DefaultRetryPolicy.MaxAttempts, q.idempotencyKey(""), now.Format(time.RFC3339Nano)).Slice()
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue expired jobs: %v", err)
+	}
+	if len(result) != 2 {
+		return 0, fmt.Errorf("unexpected reaper result: %v", result)
+	}
+
+	requeued, _ := result[0].([]interface{})
+	for _, item := range requeued {
+		var job Job
+		if err := json.Unmarshal([]byte(fmt.Sprint(item)), &job); err != nil {
+			continue
+		}
+		q.recordState(&job, JobStateQueued, &now)
+		log.Printf("Lease of job %s expired, moved back to main queue (attempt %d)", job.ID, job.RetryCount)
+	}
+
+	failed, _ := result[1].([]interface{})
+	for _, item := range failed {
+		var job Job
+		if err := json.Unmarshal([]byte(fmt.Sprint(item)), &job); err != nil {
+			continue
+		}
+		q.recordState(&job, JobStateFailed, nil)
+		log.Printf("Lease of job %s of type %s expired on its last attempt, moved to failed queue", job.ID, job.Type)
+	}
+
+	return len(requeued), nil
+}
@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// expireLease faz o lease do job vencer, como se o worker tivesse morrido
+func expireLease(t *testing.T, q *Queue, job *Job) {
+	t.Helper()
+
+	if err := q.client.ZAdd(context.Background(), q.leases, &redis.Z{Score: 0, Member: job.ID}).Err(); err != nil {
+		t.Fatalf("ZAdd: %v", err)
+	}
+}
+
+func TestRequeueExpiredCountsAttemptsAndFailsAtTheCap(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+	q.SetRetryPolicy(JobTypeActivationEmail, RetryPolicy{MaxAttempts: 2, Backoff: ConstantBackoff(time.Second)})
+
+	if err := q.Enqueue(ctx, JobTypeActivationEmail, map[string]interface{}{"checkout_id": "c1"},
+		WithIdempotencyKey("activation_email:c1")); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	// Primeira execução perdida: volta para a fila contando a tentativa
+	job, err := q.Dequeue(ctx, time.Second)
+	if err != nil || job == nil {
+		t.Fatalf("Dequeue: job %v, err %v", job, err)
+	}
+	expireLease(t, q, job)
+	if requeued, err := q.RequeueExpired(ctx); err != nil || requeued != 1 {
+		t.Fatalf("RequeueExpired = %d, %v; want 1", requeued, err)
+	}
+
+	job, err = q.Dequeue(ctx, time.Second)
+	if err != nil || job == nil {
+		t.Fatalf("Dequeue: job %v, err %v", job, err)
+	}
+	if job.RetryCount != 1 || !q.IsLastAttempt(job) {
+		t.Errorf("RetryCount = %d, last attempt = %v; want 1 and true", job.RetryCount, q.IsLastAttempt(job))
+	}
+
+	// Segunda execução perdida: esgotou as tentativas e vai para a fila de falhas
+	expireLease(t, q, job)
+	if requeued, err := q.RequeueExpired(ctx); err != nil || requeued != 0 {
+		t.Fatalf("RequeueExpired = %d, %v; want 0", requeued, err)
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.Queued != 0 || stats.Processing != 0 || stats.Failed != 1 {
+		t.Errorf("stats = %+v, want the job only in the failed queue", stats)
+	}
+
+	failed, err := q.GetFailed(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("GetFailed: %v", err)
+	}
+	if exhausted, _ := failed.Data["all_retries_exhausted"].(bool); !exhausted {
+		t.Errorf("failed job data = %v, want all_retries_exhausted", failed.Data)
+	}
+
+	// A chave foi liberada junto com a ida para a fila de falhas
+	if err := q.Enqueue(ctx, JobTypeActivationEmail, map[string]interface{}{"checkout_id": "c1"},
+		WithIdempotencyKey("activation_email:c1")); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if queued, _ := queueLength(t, q); queued != 1 {
+		t.Errorf("queued = %d after the key was released, want 1", queued)
+	}
+}
@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrorClass classifica a falha de um job para decidir se vale tentar de novo
+type ErrorClass string
+
+const (
+	ErrorClassTransient ErrorClass = "transient" // timeout, gateway/rede/banco fora: tenta de novo
+	ErrorClassPermanent ErrorClass = "permanent" // dados do job inválidos: repetir não adianta
+	ErrorClassDeclined  ErrorClass = "declined"  // cartão recusado pelo emissor
+)
+
+// JobError é o erro de um job com a sua classe
+type JobError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *JobError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marca err como falha que não melhora com novas tentativas
+func Permanent(err error) error {
+	return classified(ErrorClassPermanent, err)
+}
+
+// Declined marca err como recusa do cartão
+func Declined(err error) error {
+	return classified(ErrorClassDeclined, err)
+}
+
+// Transient marca err como falha passageira
+func Transient(err error) error {
+	return classified(ErrorClassTransient, err)
+}
+
+func classified(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &JobError{Class: class, Err: err}
+}
+
+// Classify retorna a classe do erro. Erros sem classe são tratados como passageiros,
+// como eram antes da classificação existir.
+func Classify(err error) ErrorClass {
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Class
+	}
+	return ErrorClassTransient
+}
+
+// Backoff retorna a espera antes da tentativa de número retry (1 = primeiro retry)
+type Backoff func(retry int) time.Duration
+
+// ExponentialBackoff dobra a espera a cada retry (base, 2*base, 4*base...), até max
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(retry int) time.Duration {
+		delay := base
+		for i := 1; i < retry && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// ConstantBackoff espera sempre o mesmo tempo entre as tentativas
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(int) time.Duration {
+		return delay
+	}
+}
+
+// RetryPolicy define como um tipo de job é repetido depois de falhar
+type RetryPolicy struct {
+	MaxAttempts  int          // total de execuções, contando a primeira
+	Backoff      Backoff      // espera antes de cada retry
+	Jitter       float64      // variação aleatória da espera (0.2 = ±20%)
+	NonRetryable []ErrorClass // classes de erro que falham o job na hora
+}
+
+// Retryable indica se um erro da classe pode ser tentado de novo
+func (p RetryPolicy) Retryable(class ErrorClass) bool {
+	for _, c := range p.NonRetryable {
+		if c == class {
+			return false
+		}
+	}
+	return true
+}
+
+// Delay retorna a espera antes do retry, com o jitter aplicado
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.Backoff(retry)
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// DefaultRetryPolicy vale para os tipos sem política própria: 5 retries a partir de 15s,
+// dobrando a cada falha
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  6,
+	Backoff:      ExponentialBackoff(15*time.Second, time.Hour),
+	Jitter:       0.2,
+	NonRetryable: []ErrorClass{ErrorClassPermanent},
+}
+
+// scheduledJobPolicy vale para os jobs em lote enfileirados periodicamente pelo worker;
+// o próximo agendamento cobre as falhas persistentes
+var scheduledJobPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	Backoff:      ExponentialBackoff(time.Minute, 10*time.Minute),
+	Jitter:       0.2,
+	NonRetryable: []ErrorClass{ErrorClassPermanent},
+}
+
+// DefaultRetryPolicies retorna as políticas de cada tipo de job
+func DefaultRetryPolicies() map[JobType]RetryPolicy {
+	return map[JobType]RetryPolicy{
+		// O void precisa sair antes do fechamento do lote: retries curtos e frequentes
+		JobTypeVoidTransaction: {
+			MaxAttempts:  8,
+			Backoff:      ExponentialBackoff(15*time.Second, 10*time.Minute),
+			Jitter:       0.1,
+			NonRetryable: []ErrorClass{ErrorClassPermanent},
+		},
+		// Cartão recusado não passa na tentativa seguinte: falha na hora e avisa o cliente
+		JobTypeDelayedPayment: {
+			MaxAttempts:  6,
+			Backoff:      ExponentialBackoff(15*time.Second, time.Hour),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent, ErrorClassDeclined},
+		},
+		JobTypeProcessPayment: {
+			MaxAttempts:  6,
+			Backoff:      ExponentialBackoff(15*time.Second, time.Hour),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent, ErrorClassDeclined},
+		},
+		// Quedas do SMTP costumam durar minutos
+		JobTypeActivationEmail: {
+			MaxAttempts:  8,
+			Backoff:      ExponentialBackoff(time.Minute, 30*time.Minute),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent},
+		},
+		JobTypeWebhookEvent: {
+			MaxAttempts:  8,
+			Backoff:      ExponentialBackoff(30*time.Second, time.Hour),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent},
+		},
+		// A conciliação roda uma vez por dia: vale insistir por mais tempo
+		JobTypeReconcileSettlement: {
+			MaxAttempts:  5,
+			Backoff:      ExponentialBackoff(5*time.Minute, time.Hour),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent},
+		},
+		// Valor da ARB que não pôde ser alterado na requisição: até acertar, a próxima
+		// cobrança sai com o valor antigo
+		JobTypeSyncSubscriptionAmount: {
+			MaxAttempts:  8,
+			Backoff:      ExponentialBackoff(time.Minute, time.Hour),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent},
+		},
+		// ARB substituída que continua ativa cobra o cliente em dobro
+		JobTypeCancelSubscription: {
+			MaxAttempts:  8,
+			Backoff:      ExponentialBackoff(time.Minute, time.Hour),
+			Jitter:       0.2,
+			NonRetryable: []ErrorClass{ErrorClassPermanent},
+		},
+		JobTypeSweepPaymentData:      scheduledJobPolicy,
+		JobTypeCompleteCancellations: scheduledJobPolicy,
+		JobTypeApplyAccountCredits:   scheduledJobPolicy,
+		JobTypeResumeSubscriptions:   scheduledJobPolicy,
+		JobTypeStartDunning:          scheduledJobPolicy,
+		JobTypeProcessDunning:        scheduledJobPolicy,
+		JobTypeTrialReminders:        scheduledJobPolicy,
+		JobTypeSyncSubscriptions:     scheduledJobPolicy,
+	}
+}
+
+// SetRetryPolicy troca a política de um tipo de job. Deve ser chamado antes dos workers
+// começarem a consumir a fila.
+func (q *Queue) SetRetryPolicy(jobType JobType, policy RetryPolicy) {
+	q.policies[jobType] = policy
+}
+
+// RetryPolicy retorna a política do tipo de job (DefaultRetryPolicy se não houver)
+func (q *Queue) RetryPolicy(jobType JobType) RetryPolicy {
+	if policy, ok := q.policies[jobType]; ok {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
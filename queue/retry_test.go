@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	base := errors.New("boom")
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"unclassified", base, ErrorClassTransient},
+		{"transient", Transient(base), ErrorClassTransient},
+		{"permanent", Permanent(base), ErrorClassPermanent},
+		{"declined", Declined(base), ErrorClassDeclined},
+		{"wrapped permanent", fmt.Errorf("processing job: %w", Permanent(base)), ErrorClassPermanent},
+		{"wrapped declined", fmt.Errorf("charge: %w", Declined(base)), ErrorClassDeclined},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifiedKeepsMessageAndCause(t *testing.T) {
+	base := errors.New("invalid checkout_id in job data")
+	err := Permanent(base)
+
+	if err.Error() != base.Error() {
+		t.Errorf("Error() = %q, want %q", err.Error(), base.Error())
+	}
+	if !errors.Is(err, base) {
+		t.Error("classified error does not unwrap to its cause")
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) should be nil")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(15*time.Second, time.Minute)
+
+	want := []time.Duration{15 * time.Second, 30 * time.Second, time.Minute, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := backoff(i + 1); got != w {
+			t.Errorf("retry %d: got %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	policy := RetryPolicy{Backoff: ConstantBackoff(time.Minute), Jitter: 0.2}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(1)
+		if delay < 48*time.Second || delay > 72*time.Second {
+			t.Fatalf("delay %s outside ±20%% of 1m", delay)
+		}
+	}
+}
+
+func TestDefaultRetryPolicies(t *testing.T) {
+	policies := DefaultRetryPolicies()
+
+	// Recusa do cartão não é repetida nos jobs de cobrança
+	for _, jobType := range []JobType{JobTypeDelayedPayment, JobTypeProcessPayment} {
+		policy := policies[jobType]
+		if policy.Retryable(ErrorClassDeclined) {
+			t.Errorf("%s: declined errors should not be retried", jobType)
+		}
+		if !policy.Retryable(ErrorClassTransient) {
+			t.Errorf("%s: transient errors should be retried", jobType)
+		}
+	}
+
+	for jobType, policy := range policies {
+		if policy.Retryable(ErrorClassPermanent) {
+			t.Errorf("%s: permanent errors should not be retried", jobType)
+		}
+		if policy.MaxAttempts < 1 {
+			t.Errorf("%s: MaxAttempts = %d", jobType, policy.MaxAttempts)
+		}
+		if policy.Backoff == nil {
+			t.Errorf("%s: no backoff", jobType)
+		}
+	}
+
+	// A anulação precisa sair antes do fechamento do lote
+	if max := policies[JobTypeVoidTransaction].Backoff(100); max > 10*time.Minute {
+		t.Errorf("void backoff grows to %s, want at most 10m", max)
+	}
+}
+
+func TestQueueRetryPolicyFallsBackToDefault(t *testing.T) {
+	q := &Queue{policies: DefaultRetryPolicies()}
+
+	if got := q.RetryPolicy(JobTypeCreateAccount).MaxAttempts; got != DefaultRetryPolicy.MaxAttempts {
+		t.Errorf("create_account MaxAttempts = %d, want default %d", got, DefaultRetryPolicy.MaxAttempts)
+	}
+
+	q.SetRetryPolicy(JobTypeCreateAccount, RetryPolicy{MaxAttempts: 2, Backoff: ConstantBackoff(time.Second)})
+	if got := q.RetryPolicy(JobTypeCreateAccount).MaxAttempts; got != 2 {
+		t.Errorf("create_account MaxAttempts = %d after SetRetryPolicy, want 2", got)
+	}
+}
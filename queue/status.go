@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"log"
+	"time"
+)
+
+// JobState é a etapa em que o job está
+type JobState string
+
+const (
+	JobStateQueued     JobState = "queued"     // na fila principal, aguardando um worker
+	JobStateDelayed    JobState = "delayed"    // agendado (delay inicial ou retry)
+	JobStateProcessing JobState = "processing" // em execução por um worker
+	JobStateSucceeded  JobState = "succeeded"
+	JobStateFailed     JobState = "failed" // na fila de falhas
+)
+
+// JobStatus é o registro do estado de um job a cada mudança de etapa
+type JobStatus struct {
+	JobID          string
+	Type           JobType
+	State          JobState
+	Attempts       int // execuções iniciadas
+	LastError      string
+	CheckoutID     string
+	RequestID      string
+	IdempotencyKey string
+	RunAt          *time.Time // quando o job fica disponível (queued/delayed)
+}
+
+// JobStore persiste o estado dos jobs para consulta (implementado por database.Connection)
+type JobStore interface {
+	SaveJobStatus(status *JobStatus) error
+}
+
+// SetJobStore liga o registro de estado dos jobs. Sem store, os jobs não são registrados.
+func (q *Queue) SetJobStore(store JobStore) {
+	q.store = store
+}
+
+// recordState registra a nova etapa do job. Falhas do store só são registradas em log:
+// o andamento da fila não depende do histórico.
+func (q *Queue) recordState(job *Job, state JobState, runAt *time.Time) {
+	if q.store == nil {
+		return
+	}
+
+	status := &JobStatus{
+		JobID:          job.ID,
+		Type:           job.Type,
+		State:          state,
+		Attempts:       job.RetryCount,
+		IdempotencyKey: job.IdempotencyKey,
+		RunAt:          runAt,
+	}
+	if state == JobStateProcessing || state == JobStateSucceeded {
+		status.Attempts++
+	}
+	if lastError, ok := job.Data["last_error"].(string); ok {
+		status.LastError = lastError
+	}
+	status.CheckoutID, _ = job.Data["checkout_id"].(string)
+	status.RequestID, _ = job.Data["request_id"].(string)
+
+	if err := q.store.SaveJobStatus(status); err != nil {
+		log.Printf("Warning: Failed to record state %s of job %s: %v", state, job.ID, err)
+	}
+}
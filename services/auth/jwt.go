@@ -284,6 +284,8 @@ func (j *JWTService) determineAccountType(isActive int, isMaster bool, paymentSt
         return "normal"
     case 2:
         return "dea"
+    case models.AccountStatusPaused:
+        return "paused"
     case 9:
         // Manter compatibilidade: se payment_status não está disponível mas is_active = 9
         if paymentStatus == -1 {
@@ -0,0 +1,254 @@
+// services/cardvault/vault.go - Cifragem (AES-GCM) dos dados de cartão em temp_payment_data
+package cardvault
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "database/sql"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/database"
+    "prosecure-payment-api/models"
+)
+
+// DefaultTTL é o tempo de vida padrão dos dados temporários. Cobre o atraso do job
+// de pagamento e todas as suas tentativas (~8 minutos de backoff).
+const DefaultTTL = 2 * time.Hour
+
+// ErrNotFound indica que não há dados para o checkout ou que eles já expiraram
+var ErrNotFound = errors.New("payment data not found or expired")
+
+// Config define as chaves de cifragem. Keys é uma lista "keyID:chaveBase64" separada
+// por vírgulas, com chaves AES-256 (32 bytes). Novos registros usam ActiveKeyID; as
+// demais chaves continuam disponíveis para decifrar registros antigos durante a rotação.
+type Config struct {
+    Keys        string
+    ActiveKeyID string
+    TTL         time.Duration
+}
+
+// Vault grava e lê os dados de cartão temporários, sempre cifrados em repouso
+type Vault struct {
+    db          *database.Connection
+    keys        map[string]cipher.AEAD
+    activeKeyID string
+    ttl         time.Duration
+}
+
+// NewVault cria o vault a partir da configuração de chaves
+func NewVault(db *database.Connection, cfg Config) (*Vault, error) {
+    keys, err := parseKeys(cfg.Keys)
+    if err != nil {
+        return nil, err
+    }
+
+    if cfg.ActiveKeyID == "" {
+        return nil, fmt.Errorf("active card data key ID is required")
+    }
+    if _, ok := keys[cfg.ActiveKeyID]; !ok {
+        return nil, fmt.Errorf("active card data key %q is not configured", cfg.ActiveKeyID)
+    }
+
+    ttl := cfg.TTL
+    if ttl <= 0 {
+        ttl = DefaultTTL
+    }
+
+    return &Vault{
+        db:          db,
+        keys:        keys,
+        activeKeyID: cfg.ActiveKeyID,
+        ttl:         ttl,
+    }, nil
+}
+
+func parseKeys(spec string) (map[string]cipher.AEAD, error) {
+    keys := make(map[string]cipher.AEAD)
+
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+
+        parts := strings.SplitN(entry, ":", 2)
+        if len(parts) != 2 || parts[0] == "" {
+            return nil, fmt.Errorf("invalid card data key entry, expected keyID:base64key")
+        }
+
+        raw, err := base64.StdEncoding.DecodeString(parts[1])
+        if err != nil {
+            return nil, fmt.Errorf("invalid base64 for card data key %s: %v", parts[0], err)
+        }
+        if len(raw) != 32 {
+            return nil, fmt.Errorf("card data key %s must be 32 bytes (AES-256), got %d", parts[0], len(raw))
+        }
+
+        block, err := aes.NewCipher(raw)
+        if err != nil {
+            return nil, fmt.Errorf("error creating cipher for card data key %s: %v", parts[0], err)
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return nil, fmt.Errorf("error creating GCM for card data key %s: %v", parts[0], err)
+        }
+
+        keys[parts[0]] = aead
+    }
+
+    if len(keys) == 0 {
+        return nil, fmt.Errorf("no card data encryption keys configured")
+    }
+
+    return keys, nil
+}
+
+// TTL retorna o tempo de vida dos dados temporários
+func (v *Vault) TTL() time.Duration {
+    return v.ttl
+}
+
+// Store cifra e grava os dados de cartão de um checkout, substituindo os anteriores
+func (v *Vault) Store(data *models.PaymentDataStorage) error {
+    encrypted := &database.EncryptedPaymentData{
+        CheckoutID: data.CheckoutID,
+        KeyID:      v.activeKeyID,
+        CardName:   data.CardName,
+    }
+
+    var err error
+    if encrypted.CardNumber, err = v.encrypt(data.CheckoutID, "card_number", data.CardNumber); err != nil {
+        return err
+    }
+    if encrypted.CardExpiry, err = v.encrypt(data.CheckoutID, "card_expiry", data.CardExpiry); err != nil {
+        return err
+    }
+    if data.CardCVV != "" {
+        if encrypted.CardCVV, err = v.encrypt(data.CheckoutID, "card_cvv", data.CardCVV); err != nil {
+            return err
+        }
+    }
+    if data.OpaqueData.Valid() {
+        encrypted.OpaqueDescriptor = data.OpaqueData.DataDescriptor
+        if encrypted.OpaqueValue, err = v.encrypt(data.CheckoutID, "opaque_value", data.OpaqueData.DataValue); err != nil {
+            return err
+        }
+    }
+
+    return v.db.SaveTempPaymentData(encrypted)
+}
+
+// Load lê e decifra os dados de um checkout dentro do TTL. CardCVV vem vazio se o
+// CVV já foi consumido; OpaqueData vem nil se o nonce já foi convertido em perfil.
+func (v *Vault) Load(checkoutID string) (*models.PaymentDataStorage, error) {
+    encrypted, err := v.db.GetTempPaymentData(checkoutID, v.ttl)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+
+    data := &models.PaymentDataStorage{
+        CheckoutID:        encrypted.CheckoutID,
+        CardName:          encrypted.CardName,
+        CustomerProfileID: encrypted.CustomerProfileID,
+        PaymentProfileID:  encrypted.PaymentProfileID,
+        AccountNumber:     encrypted.AccountNumber,
+        CreatedAt:         encrypted.CreatedAt,
+    }
+
+    if data.CardNumber, err = v.decrypt(encrypted.KeyID, checkoutID, "card_number", encrypted.CardNumber); err != nil {
+        return nil, err
+    }
+    if data.CardExpiry, err = v.decrypt(encrypted.KeyID, checkoutID, "card_expiry", encrypted.CardExpiry); err != nil {
+        return nil, err
+    }
+    if encrypted.CardCVV != "" {
+        if data.CardCVV, err = v.decrypt(encrypted.KeyID, checkoutID, "card_cvv", encrypted.CardCVV); err != nil {
+            return nil, err
+        }
+    }
+    if encrypted.OpaqueValue != "" {
+        data.OpaqueData = &models.OpaqueData{DataDescriptor: encrypted.OpaqueDescriptor}
+        if data.OpaqueData.DataValue, err = v.decrypt(encrypted.KeyID, checkoutID, "opaque_value", encrypted.OpaqueValue); err != nil {
+            return nil, err
+        }
+    }
+
+    return data, nil
+}
+
+// ConsumeCVV apaga o CVV de um checkout. Deve ser chamado logo após o primeiro uso;
+// tentativas seguintes seguem sem CVV.
+func (v *Vault) ConsumeCVV(checkoutID string) error {
+    return v.db.ClearTempPaymentCVV(checkoutID)
+}
+
+// ConsumeOpaqueData apaga o nonce do Accept.js de um checkout e registra o customer
+// profile criado a partir dele, usado pelas tentativas e etapas seguintes
+func (v *Vault) ConsumeOpaqueData(checkoutID string, payment *models.PaymentRequest) error {
+    return v.db.SaveTempPaymentProfile(checkoutID, payment.CustomerProfileID, payment.PaymentProfileID, payment.AccountNumber)
+}
+
+// Delete remove os dados temporários de um checkout
+func (v *Vault) Delete(checkoutID string) error {
+    return v.db.DeleteTempPaymentData(checkoutID)
+}
+
+// Sweep remove definitivamente os registros com mais tempo que o TTL
+func (v *Vault) Sweep() (int64, error) {
+    deleted, err := v.db.DeleteExpiredTempPaymentData(v.ttl)
+    if err != nil {
+        return 0, err
+    }
+
+    if deleted > 0 {
+        log.Printf("Swept %d expired temporary payment data rows", deleted)
+    }
+    return deleted, nil
+}
+
+// encrypt cifra um campo com a chave ativa. O checkout e o nome do campo entram como
+// dados associados, então um ciphertext não pode ser movido para outra linha ou coluna.
+func (v *Vault) encrypt(checkoutID, field, plaintext string) (string, error) {
+    aead := v.keys[v.activeKeyID]
+
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return "", fmt.Errorf("error generating nonce: %v", err)
+    }
+
+    sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(checkoutID+":"+field))
+    return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (v *Vault) decrypt(keyID, checkoutID, field, ciphertext string) (string, error) {
+    aead, ok := v.keys[keyID]
+    if !ok {
+        return "", fmt.Errorf("card data key %q is not configured", keyID)
+    }
+
+    sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+    if err != nil {
+        return "", fmt.Errorf("invalid %s ciphertext: %v", field, err)
+    }
+    if len(sealed) < aead.NonceSize() {
+        return "", fmt.Errorf("invalid %s ciphertext: too short", field)
+    }
+
+    nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+    plaintext, err := aead.Open(nil, nonce, sealed, []byte(checkoutID+":"+field))
+    if err != nil {
+        return "", fmt.Errorf("error decrypting %s: %v", field, err)
+    }
+
+    return string(plaintext), nil
+}
@@ -0,0 +1,145 @@
+package cardvault
+
+import (
+    "bytes"
+    "encoding/base64"
+    "strings"
+    "testing"
+)
+
+func testKey(b byte) string {
+    return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func newTestVault(t *testing.T, keys, activeKeyID string) *Vault {
+    t.Helper()
+
+    vault, err := NewVault(nil, Config{Keys: keys, ActiveKeyID: activeKeyID})
+    if err != nil {
+        t.Fatalf("NewVault: %v", err)
+    }
+    return vault
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+    vault := newTestVault(t, "k1:"+testKey(1), "k1")
+
+    ciphertext, err := vault.encrypt("checkout-1", "card_number", "4111111111111111")
+    if err != nil {
+        t.Fatalf("encrypt: %v", err)
+    }
+    if strings.Contains(ciphertext, "4111111111111111") {
+        t.Fatal("ciphertext contains the plaintext")
+    }
+
+    plaintext, err := vault.decrypt("k1", "checkout-1", "card_number", ciphertext)
+    if err != nil {
+        t.Fatalf("decrypt: %v", err)
+    }
+    if plaintext != "4111111111111111" {
+        t.Errorf("decrypt = %q, want the card number", plaintext)
+    }
+
+    // Nonce aleatório: o mesmo valor nunca gera o mesmo ciphertext
+    again, _ := vault.encrypt("checkout-1", "card_number", "4111111111111111")
+    if again == ciphertext {
+        t.Error("encrypting twice produced the same ciphertext")
+    }
+}
+
+func TestDecryptIsBoundToCheckoutAndField(t *testing.T) {
+    vault := newTestVault(t, "k1:"+testKey(1), "k1")
+
+    ciphertext, err := vault.encrypt("checkout-1", "card_cvv", "123")
+    if err != nil {
+        t.Fatalf("encrypt: %v", err)
+    }
+
+    if _, err := vault.decrypt("k1", "checkout-2", "card_cvv", ciphertext); err == nil {
+        t.Error("ciphertext decrypted for another checkout")
+    }
+    if _, err := vault.decrypt("k1", "checkout-1", "card_number", ciphertext); err == nil {
+        t.Error("ciphertext decrypted as another field")
+    }
+}
+
+func TestDecryptRejectsCorruptedCiphertext(t *testing.T) {
+    vault := newTestVault(t, "k1:"+testKey(1), "k1")
+
+    ciphertext, _ := vault.encrypt("checkout-1", "card_expiry", "12/30")
+    sealed, _ := base64.StdEncoding.DecodeString(ciphertext)
+    sealed[len(sealed)-1] ^= 0xff
+
+    tests := map[string]string{
+        "tampered":   base64.StdEncoding.EncodeToString(sealed),
+        "not base64": "%%%",
+        "too short":  base64.StdEncoding.EncodeToString([]byte("short")),
+    }
+    for name, corrupted := range tests {
+        if _, err := vault.decrypt("k1", "checkout-1", "card_expiry", corrupted); err == nil {
+            t.Errorf("%s ciphertext accepted", name)
+        }
+    }
+}
+
+func TestKeyRotation(t *testing.T) {
+    oldVault := newTestVault(t, "k1:"+testKey(1), "k1")
+    oldCiphertext, err := oldVault.encrypt("checkout-1", "card_number", "4111111111111111")
+    if err != nil {
+        t.Fatalf("encrypt: %v", err)
+    }
+
+    // Durante a rotação a chave nova cifra e a antiga continua decifrando
+    rotated := newTestVault(t, "k1:"+testKey(1)+", k2:"+testKey(2), "k2")
+    plaintext, err := rotated.decrypt("k1", "checkout-1", "card_number", oldCiphertext)
+    if err != nil || plaintext != "4111111111111111" {
+        t.Fatalf("old record not readable after rotation: %q, %v", plaintext, err)
+    }
+
+    newCiphertext, err := rotated.encrypt("checkout-2", "card_number", "5555555555554444")
+    if err != nil {
+        t.Fatalf("encrypt: %v", err)
+    }
+    if _, err := rotated.decrypt("k1", "checkout-2", "card_number", newCiphertext); err == nil {
+        t.Error("new record decrypted with the retired key")
+    }
+
+    // Removida a chave antiga, os registros dela não são mais legíveis
+    retired := newTestVault(t, "k2:"+testKey(2), "k2")
+    if _, err := retired.decrypt("k1", "checkout-1", "card_number", oldCiphertext); err == nil {
+        t.Error("record decrypted after its key was removed")
+    }
+    if plaintext, err := retired.decrypt("k2", "checkout-2", "card_number", newCiphertext); err != nil || plaintext != "5555555555554444" {
+        t.Errorf("new record not readable: %q, %v", plaintext, err)
+    }
+}
+
+func TestNewVaultConfig(t *testing.T) {
+    tests := []struct {
+        name    string
+        cfg     Config
+        wantErr bool
+    }{
+        {"valid", Config{Keys: "k1:" + testKey(1), ActiveKeyID: "k1"}, false},
+        {"no keys", Config{Keys: "", ActiveKeyID: "k1"}, true},
+        {"no active key", Config{Keys: "k1:" + testKey(1)}, true},
+        {"active key not configured", Config{Keys: "k1:" + testKey(1), ActiveKeyID: "k2"}, true},
+        {"missing key ID", Config{Keys: ":" + testKey(1), ActiveKeyID: "k1"}, true},
+        {"invalid base64", Config{Keys: "k1:not-base64!", ActiveKeyID: "k1"}, true},
+        {"short key", Config{Keys: "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), ActiveKeyID: "k1"}, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := NewVault(nil, tt.cfg)
+            if (err != nil) != tt.wantErr {
+                t.Errorf("NewVault() error = %v, wantErr %v", err, tt.wantErr)
+            }
+        })
+    }
+
+    vault, _ := NewVault(nil, Config{Keys: "k1:" + testKey(1), ActiveKeyID: "k1"})
+    if vault.TTL() != DefaultTTL {
+        t.Errorf("TTL = %s, want default %s", vault.TTL(), DefaultTTL)
+    }
+}
@@ -0,0 +1,119 @@
+// Package coupons valida os cupons/códigos promocionais aplicados no carrinho, no
+// checkout e na adição de planos.
+package coupons
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"prosecure-payment-api/database"
+	"prosecure-payment-api/models"
+)
+
+// Motivos de recusa de um cupom, com mensagens prontas para o cliente
+var (
+	ErrNotFound       = errors.New("coupon code not found")
+	ErrInactive       = errors.New("this coupon is no longer active")
+	ErrExpired        = errors.New("this coupon has expired")
+	ErrExhausted      = errors.New("this coupon has reached its redemption limit")
+	ErrCustomerLimit  = errors.New("you have already used this coupon")
+	ErrNotApplicable  = errors.New("this coupon does not apply to the selected plans")
+	ErrNoLongerUsable = errors.New("this coupon can no longer be used, remove it and try again")
+)
+
+// IsRejection indica se err é uma recusa do cupom (e não uma falha de banco)
+func IsRejection(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrInactive) || errors.Is(err, ErrExpired) ||
+		errors.Is(err, ErrExhausted) || errors.Is(err, ErrCustomerLimit) ||
+		errors.Is(err, ErrNotApplicable) || errors.Is(err, ErrNoLongerUsable)
+}
+
+// Service valida cupons contra as regras cadastradas
+type Service struct {
+	db *database.Connection
+}
+
+func NewService(db *database.Connection) *Service {
+	return &Service{db: db}
+}
+
+// Check busca o cupom e confere status, validade, limites e planos. Com email vazio
+// (carrinho anônimo) o limite por cliente fica para o checkout.
+func (s *Service) Check(code, email string, planIDs []int) (*models.Coupon, error) {
+	code = models.NormalizeCouponCode(code)
+	if code == "" {
+		return nil, ErrNotFound
+	}
+
+	coupon, err := s.db.GetCouponByCode(code)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if !coupon.IsActive {
+		return nil, ErrInactive
+	}
+	if coupon.IsExpired(time.Now()) {
+		return nil, ErrExpired
+	}
+	if coupon.MaxRedemptions > 0 && coupon.TimesRedeemed >= coupon.MaxRedemptions {
+		return nil, ErrExhausted
+	}
+
+	applies := false
+	for _, planID := range planIDs {
+		if coupon.AppliesToPlan(planID) {
+			applies = true
+			break
+		}
+	}
+	if !applies {
+		return nil, ErrNotApplicable
+	}
+
+	if email != "" && coupon.MaxPerCustomer > 0 {
+		used, err := s.db.CountCustomerRedemptions(coupon.ID, email)
+		if err != nil {
+			return nil, err
+		}
+		if used >= coupon.MaxPerCustomer {
+			return nil, ErrCustomerLimit
+		}
+	}
+
+	return coupon, nil
+}
+
+// Validate confere os campos de um cupom criado ou alterado pelo admin
+func Validate(coupon *models.Coupon) error {
+	if coupon.Code == "" || len(coupon.Code) > 32 {
+		return fmt.Errorf("code is required and must have at most 32 characters")
+	}
+
+	switch coupon.DiscountType {
+	case models.CouponTypePercent:
+		if coupon.DiscountValue <= 0 || coupon.DiscountValue > 100 {
+			return fmt.Errorf("percent discount must be greater than 0 and at most 100")
+		}
+	case models.CouponTypeFixed:
+		if coupon.DiscountValue <= 0 {
+			return fmt.Errorf("fixed discount must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("discount_type must be %s or %s", models.CouponTypePercent, models.CouponTypeFixed)
+	}
+
+	if coupon.Cycles < 1 {
+		return fmt.Errorf("cycles must be at least 1")
+	}
+	if coupon.MaxRedemptions < 0 || coupon.MaxPerCustomer < 0 {
+		return fmt.Errorf("redemption limits cannot be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,391 @@
+// services/dunning/engine.go - Retentativas das cobranças recorrentes recusadas (dunning)
+package dunning
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prosecure-payment-api/database"
+	"prosecure-payment-api/models"
+	"prosecure-payment-api/services/email"
+	"prosecure-payment-api/services/payment"
+)
+
+// DefaultRetryDays são os dias, contados a partir da falha da ARB, em que a cobrança é
+// tentada de novo. A conta é suspensa depois da última tentativa recusada.
+var DefaultRetryDays = []int{1, 3, 5, 7}
+
+// UpdateCardURL é a página onde o cliente atualiza o cartão
+const UpdateCardURL = "https://prosecurelsp.com/users/index.php"
+
+// Máximo de casos processados por execução
+const batchSize = 100
+
+// Config define o calendário de retentativas
+type Config struct {
+	RetryDays []int
+}
+
+// ParseRetryDays lê uma lista de dias separada por vírgulas (ex.: "1,3,5,7")
+func ParseRetryDays(value string) ([]int, error) {
+	var days []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		day, err := strconv.Atoi(part)
+		if err != nil || day <= 0 {
+			return nil, fmt.Errorf("invalid dunning retry day %q", part)
+		}
+		days = append(days, day)
+	}
+
+	if len(days) == 0 {
+		return nil, fmt.Errorf("no dunning retry days configured")
+	}
+	return days, nil
+}
+
+type Engine struct {
+	db             *database.Connection
+	paymentService *payment.Service
+	emailService   *email.SMTPService
+	retryDays      []int
+}
+
+func NewEngine(db *database.Connection, ps *payment.Service, es *email.SMTPService, cfg Config) *Engine {
+	retryDays := append([]int(nil), cfg.RetryDays...)
+	if len(retryDays) == 0 {
+		retryDays = append(retryDays, DefaultRetryDays...)
+	}
+	sort.Ints(retryDays)
+
+	return &Engine{
+		db:             db,
+		paymentService: ps,
+		emailService:   es,
+		retryDays:      retryDays,
+	}
+}
+
+// Start abre o caso de dunning da assinatura cuja cobrança recorrente falhou, coloca a
+// conta em erro de pagamento e envia o primeiro aviso. Notificações repetidas da mesma
+// falha não abrem um segundo caso.
+func (e *Engine) Start(subscriptionID, failure string) error {
+	masterRef, err := e.db.GetMasterReferenceBySubscription(subscriptionID)
+	if err == sql.ErrNoRows {
+		log.Printf("Subscription %s not found locally, no dunning case opened", subscriptionID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	account, err := e.db.GetDunningAccount(masterRef)
+	if err != nil {
+		return fmt.Errorf("failed to get account %s: %v", masterRef, err)
+	}
+
+	// Sem fatura vencida o valor cobrado é o recorrente, com imposto
+	rate, err := e.db.GetAccountTaxRate(masterRef)
+	if err != nil {
+		log.Printf("Warning: Failed to get tax rate of %s: %v", masterRef, err)
+	}
+
+	now := time.Now()
+	next := now.AddDate(0, 0, e.retryDays[0])
+	dc := &database.DunningCase{
+		MasterReference: masterRef,
+		SubscriptionID:  subscriptionID,
+		Amount:          rate.Gross(account.TotalPrice),
+		StartedAt:       now,
+		NextAttemptAt:   &next,
+	}
+
+	created, err := e.db.OpenDunningCase(dc, failure)
+	if err != nil {
+		return err
+	}
+	if !created {
+		log.Printf("Account %s already in dunning (case %d), ignoring failure of subscription %s",
+			masterRef, dc.ID, subscriptionID)
+		return nil
+	}
+
+	if err := e.sendFailureEmail(account, dc); err != nil {
+		log.Printf("Warning: Failed to send dunning notice for case %d: %v", dc.ID, err)
+	}
+	return nil
+}
+
+// ProcessDue faz as retentativas vencidas. Antes, os casos cuja reserva venceu no meio de
+// uma cobrança vão para revisão manual.
+func (e *Engine) ProcessDue() error {
+	held, err := e.db.HoldUnresolvedDunningCases()
+	if err != nil {
+		log.Printf("Error holding unresolved dunning cases: %v", err)
+	}
+	for _, dc := range held {
+		log.Printf("CRITICAL: Dunning case %d for %s has an unrecorded charge, moved to manual review",
+			dc.ID, dc.MasterReference)
+	}
+
+	cases, err := e.db.GetDueDunningCases(batchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list due dunning cases: %v", err)
+	}
+
+	var failed int
+	for i := range cases {
+		dc := &cases[i]
+
+		claimed, err := e.db.ClaimDunningCase(dc.ID)
+		if err != nil {
+			log.Printf("Error claiming dunning case %d: %v", dc.ID, err)
+			failed++
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := e.retry(dc); err != nil {
+			log.Printf("Error retrying dunning case %d for %s: %v", dc.ID, dc.MasterReference, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to process %d of %d dunning cases", failed, len(cases))
+	}
+	return nil
+}
+
+// retry cobra o customer profile salvo e avança o caso conforme o resultado
+func (e *Engine) retry(dc *database.DunningCase) error {
+	account, err := e.db.GetDunningAccount(dc.MasterReference)
+	if err != nil {
+		return fmt.Errorf("failed to get account: %v", err)
+	}
+
+	dc.Attempts++
+	attempt := &database.DunningAttempt{
+		AttemptNumber: dc.Attempts,
+		Source:        database.DunningAttemptSourceRetry,
+		Amount:        dc.Amount,
+	}
+
+	// A tentativa fica registrada antes da cobrança: se o worker morrer depois dela, o
+	// caso vai para revisão manual em vez de ser cobrado de novo
+	if err := e.db.BeginDunningAttempt(dc, attempt); err != nil {
+		return err
+	}
+
+	profile, err := e.db.GetCustomerProfile(dc.MasterReference)
+	if err != nil {
+		attempt.Error = fmt.Sprintf("no stored payment profile: %v", err)
+	} else {
+		transactionID, err := e.paymentService.ChargeStoredProfile(
+			profile.AuthorizeCustomerProfileID, profile.AuthorizePaymentProfileID, dc.Amount, account.Currency)
+		if err != nil {
+			attempt.Error = err.Error()
+		} else {
+			attempt.TransactionID = transactionID
+			if err := e.db.RecordDunningCharge(attempt); err != nil {
+				log.Printf("CRITICAL: Dunning charge %s for %s captured but not recorded: %v",
+					transactionID, dc.MasterReference, err)
+				return err
+			}
+		}
+	}
+
+	if attempt.Error == "" {
+		return e.recover(dc, attempt, account, profile)
+	}
+
+	attempt.Status = database.DunningAttemptFailed
+	dc.LastError = attempt.Error
+	dc.NextAttemptAt = nil
+
+	suspend := false
+	switch {
+	case dc.Status == database.DunningStatusSuspended:
+		// Conta já suspensa: só uma nova troca de cartão agenda outra tentativa
+	case dc.Attempts >= len(e.retryDays):
+		dc.Status = database.DunningStatusSuspended
+		suspend = true
+	default:
+		next := dc.StartedAt.AddDate(0, 0, e.retryDays[dc.Attempts])
+		if !next.After(time.Now()) {
+			// Tentativa antecipada (ex.: cartão trocado) fora do calendário
+			next = time.Now().AddDate(0, 0, 1)
+		}
+		dc.NextAttemptAt = &next
+	}
+
+	if suspend {
+		// Sem a ARB a Authorize.net não tenta mais cobrar a conta suspensa
+		if err := e.paymentService.CancelSubscription(dc.SubscriptionID); err != nil {
+			log.Printf("Warning: Failed to cancel subscription %s of suspended account %s: %v",
+				dc.SubscriptionID, dc.MasterReference, err)
+		}
+	}
+
+	if err := e.db.SaveDunningAttempt(dc, attempt); err != nil {
+		return err
+	}
+
+	log.Printf("Dunning attempt %d for %s declined (case %d, status %s): %s",
+		attempt.AttemptNumber, dc.MasterReference, dc.ID, dc.Status, attempt.Error)
+
+	if dc.Status == database.DunningStatusSuspended && !suspend {
+		return nil
+	}
+
+	if err := e.sendFailureEmail(account, dc); err != nil {
+		log.Printf("Warning: Failed to send dunning email for case %d: %v", dc.ID, err)
+	}
+	return nil
+}
+
+// recover encerra o caso depois de uma retentativa aprovada. Contas suspensas tiveram a
+// ARB cancelada, então uma nova assinatura é criada a partir do próximo período.
+func (e *Engine) recover(dc *database.DunningCase, attempt *database.DunningAttempt, account *database.DunningAccount, profile *database.CustomerProfileData) error {
+	attempt.Status = database.DunningAttemptSucceeded
+
+	// A troca de cartão pelo fluxo de erro de pagamento já recria a ARB
+	activeID, err := e.db.GetActiveSubscriptionID(dc.MasterReference)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Warning: Failed to check active subscription of %s: %v", dc.MasterReference, err)
+	}
+	resubscribe := dc.Status == database.DunningStatusSuspended && (activeID == "" || activeID == dc.SubscriptionID)
+
+	if resubscribe {
+		intervalMonths := 1
+		if account.IsAnnually {
+			intervalMonths = 12
+		}
+
+		// A ARB não tem campo de imposto: o valor recorrente já o inclui
+		rate, err := e.db.GetAccountTaxRate(dc.MasterReference)
+		if err != nil {
+			log.Printf("Warning: Failed to get tax rate of %s: %v", dc.MasterReference, err)
+		}
+
+		subscriptionID, err := e.paymentService.CreateScheduledSubscription(
+			profile.AuthorizeCustomerProfileID, profile.AuthorizePaymentProfileID,
+			rate.Gross(account.TotalPrice), intervalMonths, time.Now().AddDate(0, intervalMonths, 0))
+		if err != nil {
+			log.Printf("CRITICAL: Recovered account %s charged (transaction %s) but subscription could not be recreated: %v",
+				dc.MasterReference, attempt.TransactionID, err)
+		} else {
+			dc.ResumedSubscriptionID = subscriptionID
+		}
+	}
+
+	dc.Status = database.DunningStatusRecovered
+	dc.NextAttemptAt = nil
+
+	if err := e.db.SaveDunningAttempt(dc, attempt); err != nil {
+		log.Printf("CRITICAL: Dunning charge %s for %s captured but not recorded: %v",
+			attempt.TransactionID, dc.MasterReference, err)
+		if dc.ResumedSubscriptionID != "" {
+			if cancelErr := e.paymentService.CancelSubscription(dc.ResumedSubscriptionID); cancelErr != nil {
+				log.Printf("CRITICAL: Failed to cancel orphan subscription %s: %v", dc.ResumedSubscriptionID, cancelErr)
+			}
+		}
+		return err
+	}
+
+	log.Printf("Dunning case %d for %s recovered on attempt %d (transaction %s)",
+		dc.ID, dc.MasterReference, attempt.AttemptNumber, attempt.TransactionID)
+
+	// Trial que falhou na primeira cobrança e foi recuperado conta como conversão
+	if _, err := e.db.ConvertTrial(dc.MasterReference, attempt.TransactionID, attempt.Amount); err != nil {
+		log.Printf("Warning: Failed to record trial conversion for %s: %v", dc.MasterReference, err)
+	}
+
+	if err := e.sendRecoveryEmail(account, dc); err != nil {
+		log.Printf("Warning: Failed to send dunning recovery email for case %d: %v", dc.ID, err)
+	}
+	return nil
+}
+
+// sendFailureEmail envia o aviso correspondente à etapa do caso. O tom sobe a cada
+// tentativa recusada, até a suspensão.
+func (e *Engine) sendFailureEmail(account *database.DunningAccount, dc *database.DunningCase) error {
+	var subject, title, message string
+	remaining := len(e.retryDays) - dc.Attempts
+	amount := models.FormatMoney(dc.Amount, account.Currency)
+
+	switch {
+	case dc.Status == database.DunningStatusSuspended:
+		subject = "Your Account Has Been Suspended - ProSecureLSP"
+		title = "Account Suspended"
+		message = fmt.Sprintf("We were unable to collect your subscription payment of <strong>%s</strong> after %d attempts, so your account and its users have been suspended.<br><br>"+
+			"Update your payment method to restore access right away.",
+			amount, dc.Attempts+1)
+	case dc.Attempts == 0:
+		subject = "Payment Failed - Action Required - ProSecureLSP"
+		title = "We Couldn't Process Your Payment"
+		message = fmt.Sprintf("Your subscription payment of <strong>%s</strong> was declined.<br><br>"+
+			"We will try again on <strong>%s</strong>. To avoid any interruption, please make sure your card details are up to date.",
+			amount, dc.NextAttemptAt.Format("January 2, 2006"))
+	case remaining == 1:
+		subject = "Final Notice: Your Account Will Be Suspended - ProSecureLSP"
+		title = "Final Notice"
+		message = fmt.Sprintf("Attempt %d of %d to collect your subscription payment of <strong>%s</strong> was declined.<br><br>"+
+			"We will make a final attempt on <strong>%s</strong>. If it fails, your account and its users will be suspended.",
+			dc.Attempts, len(e.retryDays), amount, dc.NextAttemptAt.Format("January 2, 2006"))
+	default:
+		subject = "Payment Still Failing - ProSecureLSP"
+		title = "Your Payment Is Still Failing"
+		message = fmt.Sprintf("Attempt %d of %d to collect your subscription payment of <strong>%s</strong> was declined.<br><br>"+
+			"Next attempt: <strong>%s</strong>. Please update your payment method to keep your account active.",
+			dc.Attempts, len(e.retryDays), amount, dc.NextAttemptAt.Format("January 2, 2006"))
+	}
+
+	body := fmt.Sprintf(`
+		<div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+			<img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+			<h1 style="color:#fff">%s</h1>
+			<div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+				Hi %s,<br><br>
+				%s
+			</div>
+			<a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+			   href="%s">
+				<strong>Update Payment Information</strong>
+			</a>
+		</div>
+	`, title, account.Name, message, UpdateCardURL)
+
+	return e.emailService.SendEmail(account.Email, subject, body)
+}
+
+// sendRecoveryEmail confirma a cobrança aprovada
+func (e *Engine) sendRecoveryEmail(account *database.DunningAccount, dc *database.DunningCase) error {
+	subject := "Payment Received - ProSecureLSP"
+	body := fmt.Sprintf(`
+		<div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+			<img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+			<h1 style="color:#fff">Payment Received</h1>
+			<div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+				Hi %s,<br><br>
+				We successfully collected your subscription payment of <strong>%s</strong>. Your account is in good standing.
+			</div>
+			<a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+			   href="https://prosecurelsp.com/users">
+				<strong>Login to Your Account</strong>
+			</a>
+		</div>
+	`, account.Name, models.FormatMoney(dc.Amount, account.Currency))
+
+	return e.emailService.SendEmail(account.Email, subject, body)
+}
@@ -74,7 +74,7 @@ func (s *AuthorizeNetService) SetupRecurringBilling(payment *models.PaymentReque
         return fmt.Errorf("failed to setup recurring billing: %v", err)
     }
    
-    nextBillingDate := time.Now().AddDate(0, 1, 0)
+    nextBillingDate := checkout.TrialEndDate(time.Now())
     _, err = s.db.Exec(`
         INSERT INTO subscriptions (
             id,
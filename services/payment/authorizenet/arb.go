@@ -74,9 +74,45 @@ func (c *Client) createSubscriptionWithProfile(payment *models.PaymentRequest, c
         }
     }
 
-    startDate := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+    // Desconto do cupom na primeira cobrança (os ciclos seguintes viram créditos de conta)
+    total -= checkout.CouponDiscount
+    // A ARB não tem campo de imposto: o valor recorrente já inclui o imposto
+    total += checkout.Tax
+
+    startDate := checkout.TrialEndDate(time.Now()).Format("2006-01-02")
     refId := c.normalizeRefID(payment.CheckoutID)
-    
+
+    return c.sendProfileSubscription(refId, interval, startDate, total, customerProfileID, paymentProfileID)
+}
+
+// CreateScheduledSubscription cria uma assinatura ARB sobre um customer profile com valor,
+// intervalo e data de início explícitos (ex.: reativar uma assinatura cancelada no fim do período)
+func (c *Client) CreateScheduledSubscription(customerProfileID, paymentProfileID string, amount float64, intervalMonths int, startDate time.Time) (*models.SubscriptionResponse, error) {
+    log.Printf("Creating scheduled ARB subscription for profile %s: $%.2f every %d month(s) starting %s",
+        customerProfileID, amount, intervalMonths, startDate.Format("2006-01-02"))
+
+    if customerProfileID == "" || paymentProfileID == "" {
+        return &models.SubscriptionResponse{
+            Success: false,
+            Message: "Invalid customer profile or payment profile ID",
+        }, nil
+    }
+
+    if intervalMonths <= 0 {
+        intervalMonths = 1
+    }
+
+    interval := IntervalType{
+        Length: intervalMonths,
+        Unit:   "months",
+    }
+    refId := c.normalizeRefID(fmt.Sprintf("SCH-%d", time.Now().Unix()))
+
+    return c.sendProfileSubscription(refId, interval, startDate.Format("2006-01-02"), amount, customerProfileID, paymentProfileID)
+}
+
+// sendProfileSubscription envia o ARBCreateSubscriptionRequest com customer profile
+func (c *Client) sendProfileSubscription(refId string, interval IntervalType, startDate string, total float64, customerProfileID, paymentProfileID string) (*models.SubscriptionResponse, error) {
     // ESTRUTURA CORRETA PARA ARB COM CUSTOMER PROFILE
     request := ARBSubscriptionRequestWithProfile{
         MerchantAuthentication: c.getMerchantAuthentication(),
@@ -212,6 +248,11 @@ func (c *Client) CreateSubscriptionDirect(payment *models.PaymentRequest, checko
             time.Since(startTime), payment.CheckoutID)
     }()
     
+    // Sem dados de cartão depois que o nonce do Accept.js virou perfil
+    if payment.HasStoredProfile() {
+        return c.createSubscriptionWithProfile(payment, checkout, payment.CustomerProfileID, payment.PaymentProfileID)
+    }
+
     log.Printf("Creating subscription with direct card data (legacy method) for checkout: %s", payment.CheckoutID)
     
     var total float64
@@ -233,6 +274,11 @@ func (c *Client) CreateSubscriptionDirect(payment *models.PaymentRequest, checko
         }
     }
 
+    // Desconto do cupom na primeira cobrança (os ciclos seguintes viram créditos de conta)
+    total -= checkout.CouponDiscount
+    // A ARB não tem campo de imposto: o valor recorrente já inclui o imposto
+    total += checkout.Tax
+
     log.Printf("Creating subscription with total amount: %.2f", total)
 
     // Extrair nome e sobrenome
@@ -249,8 +295,8 @@ func (c *Client) CreateSubscriptionDirect(payment *models.PaymentRequest, checko
         log.Printf("Warning: Could not format phone number %s, omitting from request", checkout.PhoneNumber)
     }
 
-    // Definir data de início para um mês após a data atual
-    startDate := time.Now().AddDate(0, 1, 0).Format("2006-01-02") 
+    // Primeira cobrança no fim do trial
+    startDate := checkout.TrialEndDate(time.Now()).Format("2006-01-02")
     
     // CORREÇÃO: Truncar RefID para máximo de 20 caracteres
     refId := payment.CheckoutID
@@ -289,13 +335,7 @@ func (c *Client) CreateSubscriptionDirect(payment *models.PaymentRequest, checko
                 TotalOccurrences: "9999", // Assinatura contínua
             },
             Amount: fmt.Sprintf("%.2f", total),
-            Payment: PaymentType{
-                CreditCard: CreditCardType{
-                    CardNumber:     payment.CardNumber,
-                    ExpirationDate: payment.Expiry,
-                    CardCode:       payment.CVV,
-                },
-            },
+            Payment: *paymentTypeFor(payment),
             Order: OrderType{
                 InvoiceNumber: fmt.Sprintf("INV-%s", time.Now().Format("20060102150405")),
                 Description:   "ProSecure Security Services Subscription",
@@ -0,0 +1,177 @@
+// services/payment/authorizenet/arb_status.go - Consulta de assinaturas ARB (status, agenda e cobranças)
+package authorizenet
+
+import (
+    "fmt"
+    "log"
+    "time"
+)
+
+// Status de assinatura retornados pela ARB
+const (
+    SubscriptionStatusActive     = "active"
+    SubscriptionStatusExpired    = "expired"
+    SubscriptionStatusSuspended  = "suspended"
+    SubscriptionStatusCanceled   = "canceled"
+    SubscriptionStatusTerminated = "terminated"
+)
+
+type arbGetSubscriptionRequest struct {
+    MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
+    SubscriptionID         string                     `json:"subscriptionId"`
+    IncludeTransactions    bool                       `json:"includeTransactions"`
+}
+
+type arbGetSubscriptionResponse struct {
+    Subscription *SubscriptionDetails `json:"subscription"`
+    Messages     MessagesType         `json:"messages"`
+}
+
+type arbGetSubscriptionStatusRequest struct {
+    MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
+    SubscriptionID         string                     `json:"subscriptionId"`
+}
+
+type arbGetSubscriptionStatusResponse struct {
+    Status   string       `json:"status"`
+    Messages MessagesType `json:"messages"`
+}
+
+// SubscriptionSchedule é a agenda de cobranças de uma assinatura ARB
+type SubscriptionSchedule struct {
+    Interval struct {
+        Length int    `json:"length"`
+        Unit   string `json:"unit"` // months ou days
+    } `json:"interval"`
+    StartDate        string `json:"startDate"` // YYYY-MM-DD
+    TotalOccurrences int    `json:"totalOccurrences"`
+    TrialOccurrences int    `json:"trialOccurrences"`
+}
+
+// ARBTransaction é uma cobrança já feita pela assinatura
+type ARBTransaction struct {
+    TransID       string `json:"transId"`
+    Response      string `json:"response"`
+    SubmitTimeUTC string `json:"submitTimeUTC"`
+    PayNum        int    `json:"payNum"`
+    AttemptNum    int    `json:"attemptNum"`
+}
+
+// SubscriptionDetails é a assinatura retornada por ARBGetSubscriptionRequest
+type SubscriptionDetails struct {
+    Name            string               `json:"name"`
+    PaymentSchedule SubscriptionSchedule `json:"paymentSchedule"`
+    Amount          float64              `json:"amount"`
+    TrialAmount     float64              `json:"trialAmount"`
+    Status          string               `json:"status"`
+    ArbTransactions []ARBTransaction     `json:"arbTransactions"`
+}
+
+// occurrence retorna a data da n-ésima cobrança (a partir de 0)
+func (d *SubscriptionDetails) occurrence(start time.Time, n int) time.Time {
+    length := d.PaymentSchedule.Interval.Length
+    if length <= 0 {
+        length = 1
+    }
+    if d.PaymentSchedule.Interval.Unit == "days" {
+        return start.AddDate(0, 0, n*length)
+    }
+    return start.AddDate(0, n*length, 0)
+}
+
+// schedulePosition conta as cobranças agendadas antes de now e retorna a data da próxima.
+// A ARB não informa a próxima data; ela é calculada a partir de startDate e do intervalo.
+func (d *SubscriptionDetails) schedulePosition(now time.Time) (time.Time, int, bool) {
+    start, err := time.Parse("2006-01-02", d.PaymentSchedule.StartDate)
+    if err != nil {
+        return time.Time{}, 0, false
+    }
+
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    total := d.PaymentSchedule.TotalOccurrences
+
+    past := 0
+    for {
+        if total > 0 && past >= total {
+            return time.Time{}, past, false
+        }
+        date := d.occurrence(start, past)
+        if !date.Before(today) {
+            return date, past, true
+        }
+        past++
+    }
+}
+
+// NextPaymentDate retorna a data da próxima cobrança. Assinaturas que não estão ativas
+// ou que já fizeram todas as cobranças não têm próxima data.
+func (d *SubscriptionDetails) NextPaymentDate(now time.Time) (time.Time, bool) {
+    if d.Status != SubscriptionStatusActive {
+        return time.Time{}, false
+    }
+    next, _, ok := d.schedulePosition(now)
+    return next, ok
+}
+
+// PastOccurrences retorna quantas cobranças da agenda já venceram
+func (d *SubscriptionDetails) PastOccurrences(now time.Time) int {
+    _, past, _ := d.schedulePosition(now)
+    return past
+}
+
+// GetSubscription retorna a assinatura ARB com a agenda e as cobranças já feitas
+func (c *Client) GetSubscription(subscriptionID string) (*SubscriptionDetails, error) {
+    if subscriptionID == "" {
+        return nil, fmt.Errorf("subscription ID is required")
+    }
+
+    request := arbGetSubscriptionRequest{
+        MerchantAuthentication: c.getMerchantAuthentication(),
+        SubscriptionID:         subscriptionID,
+        IncludeTransactions:    true,
+    }
+
+    var response arbGetSubscriptionResponse
+    if err := c.sendReportingRequest("ARBGetSubscriptionRequest", map[string]interface{}{
+        "ARBGetSubscriptionRequest": request,
+    }, &response); err != nil {
+        return nil, err
+    }
+
+    if err := checkReportingMessages("ARBGetSubscription", response.Messages); err != nil {
+        return nil, err
+    }
+
+    if response.Subscription == nil {
+        return nil, fmt.Errorf("ARBGetSubscription returned no subscription for %s", subscriptionID)
+    }
+
+    log.Printf("Subscription %s is %s ($%.2f, %d transactions)", subscriptionID,
+        response.Subscription.Status, response.Subscription.Amount, len(response.Subscription.ArbTransactions))
+    return response.Subscription, nil
+}
+
+// GetSubscriptionStatus retorna apenas o status da assinatura ARB
+func (c *Client) GetSubscriptionStatus(subscriptionID string) (string, error) {
+    if subscriptionID == "" {
+        return "", fmt.Errorf("subscription ID is required")
+    }
+
+    request := arbGetSubscriptionStatusRequest{
+        MerchantAuthentication: c.getMerchantAuthentication(),
+        SubscriptionID:         subscriptionID,
+    }
+
+    var response arbGetSubscriptionStatusResponse
+    if err := c.sendReportingRequest("ARBGetSubscriptionStatusRequest", map[string]interface{}{
+        "ARBGetSubscriptionStatusRequest": request,
+    }, &response); err != nil {
+        return "", err
+    }
+
+    if err := checkReportingMessages("ARBGetSubscriptionStatus", response.Messages); err != nil {
+        return "", err
+    }
+
+    return response.Status, nil
+}
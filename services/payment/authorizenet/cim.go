@@ -22,6 +22,13 @@ func (c *Client) CreateCustomerProfile(payment *models.PaymentRequest, checkout
             time.Since(startTime), payment.CheckoutID)
     }()
 
+    // Nonce do Accept.js já convertido em perfil na autorização: reaproveitar o perfil
+    if payment.HasStoredProfile() {
+        log.Printf("Using customer profile created from payment nonce for checkout %s: %s/%s",
+            payment.CheckoutID, payment.CustomerProfileID, payment.PaymentProfileID)
+        return payment.CustomerProfileID, payment.PaymentProfileID, nil
+    }
+
     // Extrair nome e sobrenome
     names := strings.Fields(checkout.Name)
     firstName := names[0]
@@ -76,13 +83,7 @@ func (c *Client) CreateCustomerProfile(payment *models.PaymentRequest, checkout
             Zip:      checkout.ZipCode,
             Country:  "US",
         },
-        Payment: &PaymentType{
-            CreditCard: CreditCardType{
-                CardNumber:     payment.CardNumber,
-                ExpirationDate: payment.Expiry,
-                CardCode:       payment.CVV,
-            },
-        },
+        Payment: paymentTypeFor(payment),
         DefaultPaymentProfile: true,
     }
 
@@ -198,6 +199,13 @@ func (c *Client) CreateCustomerProfile(payment *models.PaymentRequest, checkout
 
     log.Printf("Customer profile created successfully with ID: %s, Payment Profile ID: %s", 
         response.CustomerProfileID, paymentProfileID)
+
+    // O nonce foi consumido pela criação do perfil
+    if payment.HasOpaqueData() {
+        c.storeNonceAccountNumber(payment, response.CustomerProfileID, paymentProfileID)
+        payment.CustomerProfileID = response.CustomerProfileID
+        payment.PaymentProfileID = paymentProfileID
+    }
     
     return response.CustomerProfileID, paymentProfileID, nil
 }
@@ -341,6 +349,10 @@ func (c *Client) UpdateCustomerPaymentProfile(customerProfileID, paymentProfileI
         log.Printf("UpdateCustomerPaymentProfile completed in %v", time.Since(startTime))
     }()
 
+    if payment.HasStoredProfile() && !payment.HasOpaqueData() {
+        return ErrNonceConsumed
+    }
+
     // Extrair nome e sobrenome
     names := strings.Fields(checkout.Name)
     firstName := names[0]
@@ -360,13 +372,7 @@ func (c *Client) UpdateCustomerPaymentProfile(customerProfileID, paymentProfileI
             Zip:       checkout.ZipCode,
             Country:   "US",
         },
-        Payment: &PaymentType{
-            CreditCard: CreditCardType{
-                CardNumber:     payment.CardNumber,
-                ExpirationDate: payment.Expiry,
-                CardCode:       payment.CVV,
-            },
-        },
+        Payment: paymentTypeFor(payment),
         CustomerPaymentProfileID: paymentProfileID, // DEVE VIR POR ÚLTIMO!
     }
 
@@ -428,6 +434,7 @@ func (c *Client) UpdateCustomerPaymentProfile(customerProfileID, paymentProfileI
     }
 
     log.Printf("Customer payment profile updated successfully: %s/%s", customerProfileID, paymentProfileID)
+    c.storeNonceAccountNumber(payment, customerProfileID, paymentProfileID)
     return nil
 }
 
@@ -439,6 +446,10 @@ func (c *Client) CreateCustomerPaymentProfile(customerProfileID string, paymentR
         log.Printf("CreateCustomerPaymentProfile completed in %v", time.Since(startTime))
     }()
 
+    if paymentReq.HasStoredProfile() && !paymentReq.HasOpaqueData() {
+        return "", ErrNonceConsumed
+    }
+
     // Extrair nome e sobrenome
     names := strings.Fields(checkoutData.Name)
     firstName := names[0]
@@ -459,13 +470,7 @@ func (c *Client) CreateCustomerPaymentProfile(customerProfileID string, paymentR
             Zip:       checkoutData.ZipCode,
             Country:   "US",
         },
-        Payment: &PaymentType{
-            CreditCard: CreditCardType{
-                CardNumber:     paymentReq.CardNumber,
-                ExpirationDate: paymentReq.Expiry,
-                CardCode:       paymentReq.CVV,
-            },
-        },
+        Payment: paymentTypeFor(paymentReq),
     }
 
     // Create the request
@@ -528,5 +533,6 @@ func (c *Client) CreateCustomerPaymentProfile(customerProfileID string, paymentR
     }
 
     log.Printf("Successfully created payment profile: %s for customer: %s", response.CustomerPaymentProfileId, customerProfileID)
+    c.storeNonceAccountNumber(paymentReq, customerProfileID, response.CustomerPaymentProfileId)
     return response.CustomerPaymentProfileId, nil
 }
@@ -30,6 +30,7 @@ type Client struct {
     transactionKey string
     merchantID     string
     environment    string
+    endpoint       string // sobrescreve o endpoint do environment (ex.: simulador local)
     client         *http.Client
     transport      *http.Transport
     mutex          sync.Mutex // Para operações concorrentes seguras
@@ -59,7 +60,16 @@ func NewClient(apiLoginID, transactionKey, merchantID, environment string) *Clie
     }
 }
 
+// SetEndpoint aponta o client para outro endpoint request.api (ex.: o simulador
+// em services/payment/authorizenet/simulator). Vazio volta ao endpoint do environment.
+func (c *Client) SetEndpoint(endpoint string) {
+    c.endpoint = endpoint
+}
+
 func (c *Client) getEndpoint() string {
+    if c.endpoint != "" {
+        return c.endpoint
+    }
     if c.environment == "production" {
         return ProductionEndpoint
     }
@@ -108,13 +118,8 @@ func (c *Client) ProcessPayment(req *models.PaymentRequest) (*models.Transaction
     txRequest := transactionRequestType{
         TransactionType: "authOnlyTransaction",
         Amount:         "1.00",
-        Payment: &PaymentType{
-            CreditCard: CreditCardType{
-                CardNumber:     req.CardNumber,
-                ExpirationDate: req.Expiry,
-                CardCode:       req.CVV,
-            },
-        },
+        CurrencyCode:   currencyCode(req.Currency),
+        Payment: paymentTypeFor(req),
         // Adicionar informações do pedido para controle de duplicação
         Order: &OrderType{
             InvoiceNumber: orderID,
@@ -144,6 +149,31 @@ func (c *Client) ProcessPayment(req *models.PaymentRequest) (*models.Transaction
     if req.BillingInfo != nil {
         txRequest.BillTo = req.BillingInfo
     }
+
+    // Nonce do Accept.js: só pode ser usado uma vez, então a própria autorização
+    // cria o customer profile usado depois pelo ARB. Depois de consumido, novas
+    // autorizações usam o perfil criado.
+    if req.HasOpaqueData() {
+        txRequest.Profile = &ProfileTransactionType{CreateProfile: true}
+        log.Printf("Authorizing payment nonce for checkout %s with customer profile creation", req.CheckoutID)
+    } else if req.HasStoredProfile() {
+        txRequest.Payment = nil
+        txRequest.BillTo = nil
+        txRequest.Profile = &ProfileTransactionType{
+            CustomerProfileID: req.CustomerProfileID,
+            PaymentProfile:    &PaymentProfileType{PaymentProfileID: req.PaymentProfileID},
+        }
+        log.Printf("Authorizing customer profile %s/%s for checkout %s", req.CustomerProfileID, req.PaymentProfileID, req.CheckoutID)
+    }
+
+    // 3D Secure: ECI e CAVV da autenticação do portador vão junto com a autorização
+    if req.ThreeDSAuth != nil && req.ThreeDSAuth.CAVV != "" {
+        txRequest.CardholderAuthentication = &CardholderAuthenticationType{
+            AuthenticationIndicator:       req.ThreeDSAuth.ECI,
+            CardholderAuthenticationValue: req.ThreeDSAuth.CAVV,
+        }
+        log.Printf("Authorizing checkout %s with 3D Secure authentication (ECI %s)", req.CheckoutID, req.ThreeDSAuth.ECI)
+    }
     
     // CORREÇÃO: Usar função normalizada para RefID
     refId := c.normalizeRefID(req.CheckoutID)
@@ -303,11 +333,14 @@ func (c *Client) ProcessPayment(req *models.PaymentRequest) (*models.Transaction
 
     log.Printf("Transaction successful with ID: %s", response.TransactionResponse.TransID)
     
-    return &models.TransactionResponse{
+    result := &models.TransactionResponse{
         Success:       true,
         TransactionID: response.TransactionResponse.TransID,
         Message:      response.TransactionResponse.Messages[0].Description,
-    }, nil
+    }
+    storeNonceProfile(req, &response, result)
+
+    return result, nil
 }
 
 func (c *Client) VoidTransaction(transactionID string) error {
@@ -395,13 +428,196 @@ func (c *Client) VoidTransaction(transactionID string) error {
     return nil
 }
 
+// CaptureTransaction captura uma transação previamente autorizada (priorAuthCaptureTransaction).
+// Com amount <= 0 o valor autorizado é capturado integralmente.
+func (c *Client) CaptureTransaction(transactionID string, amount float64) error {
+    startTime := time.Now()
+
+    txRequest := transactionRequestType{
+        TransactionType: "priorAuthCaptureTransaction",
+        RefTransId:     transactionID,
+    }
+    if amount > 0 {
+        txRequest.Amount = fmt.Sprintf("%.2f", amount)
+    }
+
+    wrapper := createTransactionRequestWrapper{
+        CreateTransactionRequest: createTransactionRequest{
+            MerchantAuthentication: c.getMerchantAuthentication(),
+            TransactionRequest:     txRequest,
+        },
+    }
+
+    jsonPayload, err := json.Marshal(wrapper)
+    if err != nil {
+        return fmt.Errorf("error marshaling capture request: %v", err)
+    }
+
+    log.Printf("Sending capture request to Authorize.net for transaction: %s", transactionID)
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(jsonPayload))
+    if err != nil {
+        return fmt.Errorf("error creating capture request: %v", err)
+    }
+
+    httpReq.Header.Set("Content-Type", "application/json")
+    httpReq.Header.Set("Cache-Control", "no-cache")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        log.Printf("HTTP error in capture request: %v", err)
+        return fmt.Errorf("error making capture request: %v", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("error reading capture response body: %v", err)
+    }
+
+    log.Printf("Capture response received in %v for transaction: %s", time.Since(startTime), transactionID)
+
+    cleanBody := strings.TrimPrefix(string(respBody), "\ufeff")
+
+    var response createTransactionResponse
+    if err := json.Unmarshal([]byte(cleanBody), &response); err != nil {
+        return fmt.Errorf("error decoding capture response: %v, response body: %s", err, string(respBody))
+    }
+
+    if response.TransactionResponse.ResponseCode != "1" {
+        if len(response.TransactionResponse.Errors) > 0 {
+            return fmt.Errorf("capture transaction failed: %s", response.TransactionResponse.Errors[0].ErrorText)
+        }
+        if response.Messages.ResultCode == "Error" && len(response.Messages.Message) > 0 {
+            return fmt.Errorf("capture transaction failed: %s (Code: %s)",
+                response.Messages.Message[0].Text, response.Messages.Message[0].Code)
+        }
+        return fmt.Errorf("capture transaction failed with unknown error")
+    }
+
+    log.Printf("Capture successful for transaction ID: %s", transactionID)
+    return nil
+}
+
+// RefundTransaction estorna (total ou parcialmente) uma transação já liquidada.
+// A Authorize.net exige os 4 últimos dígitos do cartão e aceita "XXXX" como validade.
+func (c *Client) RefundTransaction(transactionID string, amount float64, cardLastFour string) (string, error) {
+    startTime := time.Now()
+
+    wrapper := createTransactionRequestWrapper{
+        CreateTransactionRequest: createTransactionRequest{
+            MerchantAuthentication: c.getMerchantAuthentication(),
+            RefID:                 c.normalizeRefID(fmt.Sprintf("RFD-%d", time.Now().Unix())),
+            TransactionRequest: transactionRequestType{
+                TransactionType: "refundTransaction",
+                Amount:         fmt.Sprintf("%.2f", amount),
+                Payment: &PaymentType{
+                    CreditCard: &CreditCardType{
+                        CardNumber:     cardLastFour,
+                        ExpirationDate: "XXXX",
+                    },
+                },
+                RefTransId: transactionID,
+            },
+        },
+    }
+
+    jsonPayload, err := json.Marshal(wrapper)
+    if err != nil {
+        return "", fmt.Errorf("error marshaling refund request: %v", err)
+    }
+
+    log.Printf("Sending refund request to Authorize.net for transaction: %s, amount: $%.2f", transactionID, amount)
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(jsonPayload))
+    if err != nil {
+        return "", fmt.Errorf("error creating refund request: %v", err)
+    }
+
+    httpReq.Header.Set("Content-Type", "application/json")
+    httpReq.Header.Set("Cache-Control", "no-cache")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        log.Printf("HTTP error in refund request: %v", err)
+        return "", fmt.Errorf("error making refund request: %v", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return "", fmt.Errorf("error reading refund response body: %v", err)
+    }
+
+    log.Printf("Refund response received in %v for transaction: %s", time.Since(startTime), transactionID)
+
+    cleanBody := strings.TrimPrefix(string(respBody), "\ufeff")
+
+    var response createTransactionResponse
+    if err := json.Unmarshal([]byte(cleanBody), &response); err != nil {
+        return "", fmt.Errorf("error decoding refund response: %v, response body: %s", err, string(respBody))
+    }
+
+    if response.TransactionResponse.ResponseCode != "1" {
+        message := "refund declined"
+        if len(response.TransactionResponse.Errors) > 0 {
+            // Erro 54: transação ainda não liquidada - deve ser anulada (void) em vez de estornada
+            for _, txErr := range response.TransactionResponse.Errors {
+                log.Printf("Refund error: Code=%s, Text=%s", txErr.ErrorCode, txErr.ErrorText)
+            }
+            message = response.TransactionResponse.Errors[0].ErrorText
+        } else if response.Messages.ResultCode == "Error" && len(response.Messages.Message) > 0 {
+            message = fmt.Sprintf("%s (Code: %s)", response.Messages.Message[0].Text, response.Messages.Message[0].Code)
+        }
+        return "", fmt.Errorf("refund failed: %s", message)
+    }
+
+    log.Printf("Refund successful for transaction %s - refund transaction ID: %s",
+        transactionID, response.TransactionResponse.TransID)
+    return response.TransactionResponse.TransID, nil
+}
+
+// currencyCode retorna o código enviado em currencyCode; USD fica de fora (moeda padrão
+// da conta do gateway). A ARB não tem campo de moeda e cobra na moeda da conta.
+func currencyCode(currency string) string {
+    currency = models.NormalizeCurrency(currency)
+    if currency == models.DefaultCurrency {
+        return ""
+    }
+    return currency
+}
+
+// salesTax monta o item de imposto da transação; nil quando não há imposto
+func salesTax(tax float64) *ExtendedAmountType {
+    if tax <= 0 {
+        return nil
+    }
+    return &ExtendedAmountType{
+        Amount: fmt.Sprintf("%.2f", tax),
+        Name:   "Sales Tax",
+    }
+}
+
 // CORRIGIDO: ChargeCustomerProfile - Incluir CVV na request
-func (c *Client) ChargeCustomerProfile(customerProfileID, paymentProfileID string, amount float64, cvv string) (string, error) {
-    log.Printf("Charging customer profile %s/%s for amount $%.2f with CVV validation", customerProfileID, paymentProfileID, amount)
+func (c *Client) ChargeCustomerProfile(customerProfileID, paymentProfileID string, amount, tax float64, currency, cvv string) (string, error) {
+    log.Printf("Charging customer profile %s/%s for amount %.2f %s (tax %.2f) with CVV validation", customerProfileID, paymentProfileID, amount, currency, tax)
     
     txRequest := transactionRequestType{
         TransactionType: "authCaptureTransaction",
         Amount:         fmt.Sprintf("%.2f", amount),
+        CurrencyCode:   currencyCode(currency),
         Profile: &ProfileTransactionType{
             CustomerProfileID: customerProfileID,
             PaymentProfile: &PaymentProfileType{
@@ -413,6 +629,7 @@ func (c *Client) ChargeCustomerProfile(customerProfileID, paymentProfileID strin
             InvoiceNumber: fmt.Sprintf("ADDPLAN-%d", time.Now().Unix()),
             Description:   "Additional Plans Charge",
         },
+        Tax: salesTax(tax),
     }
 
     wrapper := createTransactionRequestWrapper{
@@ -559,4 +776,70 @@ func (c *Client) UpdateSubscription(subscriptionID string, newAmount float64) er
 
     log.Printf("Successfully updated subscription %s", subscriptionID)
     return nil
-}
\ No newline at end of file
+}
+
+// CancelSubscription cancela uma assinatura ARB. Cancelar uma assinatura já cancelada
+// não é erro (I00002).
+func (c *Client) CancelSubscription(subscriptionID string) error {
+    log.Printf("Cancelling subscription %s", subscriptionID)
+
+    request := ARBCancelSubscriptionRequest{
+        MerchantAuthentication: c.getMerchantAuthentication(),
+        RefID:                 c.normalizeRefID(fmt.Sprintf("CNL-%d", time.Now().Unix())),
+        SubscriptionID:        subscriptionID,
+    }
+
+    jsonPayload, err := json.Marshal(map[string]interface{}{
+        "ARBCancelSubscriptionRequest": request,
+    })
+    if err != nil {
+        return fmt.Errorf("error marshaling cancel subscription request: %v", err)
+    }
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(jsonPayload))
+    if err != nil {
+        return fmt.Errorf("error creating cancel subscription request: %v", err)
+    }
+
+    httpReq.Header.Set("Content-Type", "application/json")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        return fmt.Errorf("error making cancel subscription request: %v", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("error reading cancel subscription response: %v", err)
+    }
+
+    cleanBody := strings.TrimPrefix(string(respBody), "\ufeff")
+
+    var response ARBResponse
+    if err := json.Unmarshal([]byte(cleanBody), &response); err != nil {
+        return fmt.Errorf("error decoding cancel subscription response: %v", err)
+    }
+
+    if response.Messages.ResultCode == "Error" {
+        message := "Subscription cancellation failed"
+        if len(response.Messages.Message) > 0 {
+            message = response.Messages.Message[0].Text
+        }
+        return fmt.Errorf("subscription cancellation failed: %s", message)
+    }
+
+    if len(response.Messages.Message) > 0 && response.Messages.Message[0].Code == "I00002" {
+        log.Printf("Subscription %s was already cancelled", subscriptionID)
+        return nil
+    }
+
+    log.Printf("Successfully cancelled subscription %s", subscriptionID)
+    return nil
+}
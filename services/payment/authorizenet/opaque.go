@@ -0,0 +1,260 @@
+// services/payment/authorizenet/opaque.go - Pagamentos com nonce do Accept.js (opaqueData)
+//
+// O Accept.js tokeniza o cartão no navegador e envia apenas o nonce
+// (dataDescriptor/dataValue) para a API. O nonce vale por 15 minutos e só pode ser
+// usado UMA vez, então a primeira transação que o consome também cria o customer
+// profile (profile.createProfile) e todas as etapas seguintes (ARB, atualização de
+// cartão) usam o perfil criado, nunca o número do cartão.
+package authorizenet
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "prosecure-payment-api/models"
+)
+
+// ErrNonceConsumed indica que o nonce do Accept.js já foi usado e que o cartão só
+// está disponível no customer profile criado a partir dele
+var ErrNonceConsumed = errors.New("payment nonce already used: card is only available through the customer profile created from it")
+
+// paymentTypeFor monta o bloco payment da requisição: o nonce do Accept.js quando
+// presente, senão os dados do cartão
+func paymentTypeFor(req *models.PaymentRequest) *PaymentType {
+    if req.HasOpaqueData() {
+        return &PaymentType{
+            OpaqueData: &OpaqueDataType{
+                DataDescriptor: req.OpaqueData.DataDescriptor,
+                DataValue:      req.OpaqueData.DataValue,
+            },
+        }
+    }
+
+    return &PaymentType{
+        CreditCard: &CreditCardType{
+            CardNumber:     req.CardNumber,
+            ExpirationDate: req.Expiry,
+            CardCode:       req.CVV,
+        },
+    }
+}
+
+// storeNonceProfile registra no request o customer profile criado a partir do nonce
+// na transação e marca o nonce como consumido
+func storeNonceProfile(req *models.PaymentRequest, response *createTransactionResponse, result *models.TransactionResponse) {
+    result.AccountNumber = response.TransactionResponse.AccountNumber
+
+    if !req.HasOpaqueData() {
+        return
+    }
+    req.OpaqueData = nil
+    req.AccountNumber = response.TransactionResponse.AccountNumber
+
+    profile := response.ProfileResponse
+    if profile == nil || profile.Messages.ResultCode != "Ok" ||
+        profile.CustomerProfileID == "" || len(profile.CustomerPaymentProfileIDList) == 0 {
+        if profile != nil && len(profile.Messages.Message) > 0 {
+            log.Printf("Customer profile creation from payment nonce failed for checkout %s: %s (Code: %s)",
+                req.CheckoutID, profile.Messages.Message[0].Text, profile.Messages.Message[0].Code)
+        } else {
+            log.Printf("No customer profile returned for payment nonce on checkout %s", req.CheckoutID)
+        }
+        return
+    }
+
+    req.CustomerProfileID = profile.CustomerProfileID
+    req.PaymentProfileID = profile.CustomerPaymentProfileIDList[0]
+    result.CustomerProfileID = req.CustomerProfileID
+    result.PaymentProfileID = req.PaymentProfileID
+
+    log.Printf("Payment nonce for checkout %s converted to customer profile %s/%s",
+        req.CheckoutID, req.CustomerProfileID, req.PaymentProfileID)
+}
+
+// storeNonceAccountNumber marca o nonce como consumido por uma operação de CIM (criar ou
+// atualizar perfil de pagamento) e busca o número mascarado do cartão no perfil, já que
+// essas respostas não o trazem
+func (c *Client) storeNonceAccountNumber(req *models.PaymentRequest, customerProfileID, paymentProfileID string) {
+    if !req.HasOpaqueData() {
+        return
+    }
+    req.OpaqueData = nil
+
+    accountNumber, err := c.getMaskedCardNumber(customerProfileID, paymentProfileID)
+    if err != nil {
+        log.Printf("Warning: could not read masked card number for profile %s/%s: %v",
+            customerProfileID, paymentProfileID, err)
+        return
+    }
+    req.AccountNumber = accountNumber
+}
+
+// getMaskedCardNumber lê o número mascarado (ex.: XXXX1111) de um perfil de pagamento
+func (c *Client) getMaskedCardNumber(customerProfileID, paymentProfileID string) (string, error) {
+    request := GetCustomerProfileRequestWrapper{
+        GetCustomerProfileRequest: GetCustomerProfileRequest{
+            MerchantAuthentication: c.getMerchantAuthentication(),
+            CustomerProfileID:     customerProfileID,
+        },
+    }
+
+    jsonPayload, err := json.Marshal(request)
+    if err != nil {
+        return "", fmt.Errorf("error marshaling get profile request: %v", err)
+    }
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(jsonPayload))
+    if err != nil {
+        return "", fmt.Errorf("error creating get profile request: %v", err)
+    }
+
+    httpReq.Header.Set("Content-Type", "application/json")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        return "", fmt.Errorf("error making get profile request: %v", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return "", fmt.Errorf("error reading get profile response: %v", err)
+    }
+
+    var response GetCustomerProfileResponse
+    if err := json.Unmarshal([]byte(strings.TrimPrefix(string(respBody), "\ufeff")), &response); err != nil {
+        return "", fmt.Errorf("error decoding get profile response: %v", err)
+    }
+
+    if response.Messages.ResultCode == "Error" {
+        message := "Failed to get customer profile"
+        if len(response.Messages.Message) > 0 {
+            message = response.Messages.Message[0].Text
+        }
+        return "", fmt.Errorf("get customer profile failed: %s", message)
+    }
+
+    for _, pp := range response.Profile.PaymentProfiles {
+        if pp.CustomerPaymentProfileID == paymentProfileID && pp.Payment != nil && pp.Payment.CreditCard != nil {
+            return pp.Payment.CreditCard.CardNumber, nil
+        }
+    }
+
+    return "", fmt.Errorf("payment profile %s not found in customer profile %s", paymentProfileID, customerProfileID)
+}
+
+// CreateSubscriptionFromProfile cria uma assinatura ARB sobre um customer profile já
+// existente (ex.: o perfil criado a partir do nonce do Accept.js)
+func (c *Client) CreateSubscriptionFromProfile(payment *models.PaymentRequest, checkout *models.CheckoutData, customerProfileID, paymentProfileID string) (*models.SubscriptionResponse, error) {
+    return c.createSubscriptionWithProfile(payment, checkout, customerProfileID, paymentProfileID)
+}
+
+// ChargeOpaqueData cobra (authCaptureTransaction) um nonce do Accept.js. Usado quando
+// o cliente informa um novo cartão em vez de confirmar o CVV do perfil.
+func (c *Client) ChargeOpaqueData(opaque *models.OpaqueData, amount, tax float64, currency, description string) (*models.TransactionResponse, error) {
+    if !opaque.Valid() {
+        return nil, fmt.Errorf("payment nonce is required")
+    }
+
+    log.Printf("Charging payment nonce for amount $%.2f", amount)
+
+    wrapper := createTransactionRequestWrapper{
+        CreateTransactionRequest: createTransactionRequest{
+            MerchantAuthentication: c.getMerchantAuthentication(),
+            RefID:                 c.normalizeRefID(fmt.Sprintf("NONCE-%d", time.Now().Unix())),
+            TransactionRequest: transactionRequestType{
+                TransactionType: "authCaptureTransaction",
+                Amount:         fmt.Sprintf("%.2f", amount),
+                CurrencyCode:   currencyCode(currency),
+                Payment: &PaymentType{
+                    OpaqueData: &OpaqueDataType{
+                        DataDescriptor: opaque.DataDescriptor,
+                        DataValue:      opaque.DataValue,
+                    },
+                },
+                Order: &OrderType{
+                    InvoiceNumber: fmt.Sprintf("NONCE-%d", time.Now().Unix()),
+                    Description:   description,
+                },
+                Tax: salesTax(tax),
+            },
+        },
+    }
+
+    jsonPayload, err := json.Marshal(wrapper)
+    if err != nil {
+        return nil, fmt.Errorf("error marshaling nonce charge request: %v", err)
+    }
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(jsonPayload))
+    if err != nil {
+        return nil, fmt.Errorf("error creating nonce charge request: %v", err)
+    }
+
+    httpReq.Header.Set("Content-Type", "application/json")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        return nil, fmt.Errorf("error making nonce charge request: %v", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, fmt.Errorf("error reading nonce charge response: %v", err)
+    }
+
+    cleanBody := strings.TrimPrefix(string(respBody), "\ufeff")
+
+    var response createTransactionResponse
+    if err := json.Unmarshal([]byte(cleanBody), &response); err != nil {
+        return nil, fmt.Errorf("error decoding nonce charge response: %v", err)
+    }
+
+    if response.TransactionResponse.ResponseCode != "1" {
+        message := "Transaction declined"
+        if len(response.TransactionResponse.Errors) > 0 {
+            message = response.TransactionResponse.Errors[0].ErrorText
+        } else if response.Messages.ResultCode == "Error" && len(response.Messages.Message) > 0 {
+            message = response.Messages.Message[0].Text
+        }
+
+        return &models.TransactionResponse{
+            Success: false,
+            Message: message,
+        }, nil
+    }
+
+    log.Printf("Payment nonce charged successfully (Transaction ID: %s)", response.TransactionResponse.TransID)
+
+    message := "This transaction has been approved."
+    if len(response.TransactionResponse.Messages) > 0 {
+        message = response.TransactionResponse.Messages[0].Description
+    }
+
+    return &models.TransactionResponse{
+        Success:       true,
+        TransactionID: response.TransactionResponse.TransID,
+        Message:       message,
+        AccountNumber: response.TransactionResponse.AccountNumber,
+    }, nil
+}
@@ -0,0 +1,207 @@
+package authorizenet
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Limite máximo de transações por página aceito pelo getTransactionList
+const transactionListPageSize = 1000
+
+// Status de transação retornados pela Transaction Reporting API
+const (
+    TransactionStatusSettled       = "settledSuccessfully"
+    TransactionStatusRefundSettled = "refundSettledSuccessfully"
+    TransactionStatusVoided        = "voided"
+    TransactionStatusDeclined      = "declined"
+    TransactionStatusGeneralError  = "generalError"
+)
+
+type getSettledBatchListRequest struct {
+    MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
+    IncludeStatistics      bool                       `json:"includeStatistics"`
+    FirstSettlementDate    string                     `json:"firstSettlementDate"`
+    LastSettlementDate     string                     `json:"lastSettlementDate"`
+}
+
+type getSettledBatchListResponse struct {
+    BatchList []SettledBatch `json:"batchList"`
+    Messages  MessagesType   `json:"messages"`
+}
+
+// SettledBatch é um lote liquidado retornado por getSettledBatchList
+type SettledBatch struct {
+    BatchID             string `json:"batchId"`
+    SettlementTimeUTC   string `json:"settlementTimeUTC"`
+    SettlementTimeLocal string `json:"settlementTimeLocal"`
+    SettlementState     string `json:"settlementState"`
+    PaymentMethod       string `json:"paymentMethod"`
+}
+
+type sortingType struct {
+    OrderBy         string `json:"orderBy"`
+    OrderDescending bool   `json:"orderDescending"`
+}
+
+type pagingType struct {
+    Limit  int `json:"limit"`
+    Offset int `json:"offset"`
+}
+
+type getTransactionListRequest struct {
+    MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
+    BatchID                string                     `json:"batchId"`
+    Sorting                sortingType                `json:"sorting"`
+    Paging                 pagingType                 `json:"paging"`
+}
+
+type getTransactionListResponse struct {
+    Transactions        []TransactionSummary `json:"transactions"`
+    TotalNumInResultSet int                  `json:"totalNumInResultSet"`
+    Messages            MessagesType         `json:"messages"`
+}
+
+// TransactionSummary é uma transação de um lote retornada por getTransactionList
+type TransactionSummary struct {
+    TransID           string  `json:"transId"`
+    SubmitTimeUTC     string  `json:"submitTimeUTC"`
+    TransactionStatus string  `json:"transactionStatus"`
+    InvoiceNumber     string  `json:"invoiceNumber,omitempty"`
+    FirstName         string  `json:"firstName,omitempty"`
+    LastName          string  `json:"lastName,omitempty"`
+    AccountType       string  `json:"accountType,omitempty"`
+    AccountNumber     string  `json:"accountNumber,omitempty"`
+    SettleAmount      float64 `json:"settleAmount"`
+    Subscription      *struct {
+        ID     int `json:"id"`
+        PayNum int `json:"payNum"`
+    } `json:"subscription,omitempty"`
+}
+
+// sendReportingRequest envia uma requisição de consulta (Transaction Reporting API e consultas
+// da ARB) e decodifica a resposta
+func (c *Client) sendReportingRequest(name string, payload interface{}, out interface{}) error {
+    jsonPayload, err := json.Marshal(payload)
+    if err != nil {
+        return fmt.Errorf("error marshaling %s: %v", name, err)
+    }
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(jsonPayload))
+    if err != nil {
+        return fmt.Errorf("error creating %s: %v", name, err)
+    }
+
+    httpReq.Header.Set("Content-Type", "application/json")
+    httpReq.Header.Set("Cache-Control", "no-cache")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        return fmt.Errorf("error making %s: %v", name, err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("error reading %s response body: %v", name, err)
+    }
+
+    cleanBody := strings.TrimPrefix(string(respBody), "\ufeff")
+
+    if err := json.Unmarshal([]byte(cleanBody), out); err != nil {
+        return fmt.Errorf("error decoding %s response: %v, response body: %s", name, err, string(respBody))
+    }
+
+    return nil
+}
+
+func checkReportingMessages(name string, messages MessagesType) error {
+    if messages.ResultCode != "Error" {
+        return nil
+    }
+
+    if len(messages.Message) > 0 {
+        // I00004 (nenhum registro encontrado) vem como Ok; qualquer erro aqui é real
+        return fmt.Errorf("%s failed: %s (Code: %s)", name, messages.Message[0].Text, messages.Message[0].Code)
+    }
+    return fmt.Errorf("%s failed with unknown error", name)
+}
+
+// GetSettledBatchList retorna os lotes liquidados entre as datas informadas (UTC)
+func (c *Client) GetSettledBatchList(firstSettlementDate, lastSettlementDate time.Time) ([]SettledBatch, error) {
+    request := getSettledBatchListRequest{
+        MerchantAuthentication: c.getMerchantAuthentication(),
+        IncludeStatistics:      false,
+        FirstSettlementDate:    firstSettlementDate.UTC().Format("2006-01-02T15:04:05Z"),
+        LastSettlementDate:     lastSettlementDate.UTC().Format("2006-01-02T15:04:05Z"),
+    }
+
+    log.Printf("Getting settled batches from %s to %s", request.FirstSettlementDate, request.LastSettlementDate)
+
+    var response getSettledBatchListResponse
+    if err := c.sendReportingRequest("getSettledBatchListRequest", map[string]interface{}{
+        "getSettledBatchListRequest": request,
+    }, &response); err != nil {
+        return nil, err
+    }
+
+    if err := checkReportingMessages("getSettledBatchList", response.Messages); err != nil {
+        return nil, err
+    }
+
+    log.Printf("Found %d settled batches", len(response.BatchList))
+    return response.BatchList, nil
+}
+
+// GetTransactionList retorna todas as transações de um lote liquidado, paginando
+// de transactionListPageSize em transactionListPageSize
+func (c *Client) GetTransactionList(batchID string) ([]TransactionSummary, error) {
+    var transactions []TransactionSummary
+
+    // O offset da Authorize.net é o número da página, começando em 1
+    for page := 1; ; page++ {
+        request := getTransactionListRequest{
+            MerchantAuthentication: c.getMerchantAuthentication(),
+            BatchID:                batchID,
+            Sorting: sortingType{
+                OrderBy:         "submitTimeUTC",
+                OrderDescending: false,
+            },
+            Paging: pagingType{
+                Limit:  transactionListPageSize,
+                Offset: page,
+            },
+        }
+
+        var response getTransactionListResponse
+        if err := c.sendReportingRequest("getTransactionListRequest", map[string]interface{}{
+            "getTransactionListRequest": request,
+        }, &response); err != nil {
+            return nil, err
+        }
+
+        if err := checkReportingMessages("getTransactionList", response.Messages); err != nil {
+            return nil, err
+        }
+
+        transactions = append(transactions, response.Transactions...)
+
+        if len(response.Transactions) < transactionListPageSize || len(transactions) >= response.TotalNumInResultSet {
+            break
+        }
+    }
+
+    log.Printf("Found %d transactions in batch %s", len(transactions), batchID)
+    return transactions, nil
+}
@@ -0,0 +1,110 @@
+// authorizenet/signature.go
+package authorizenet
+
+import (
+    "crypto/hmac"
+    "crypto/md5"
+    "crypto/sha512"
+    "encoding/hex"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// WebhookSignatureHeader é o header enviado pela Webhooks API (formato "sha512=<hex>")
+const WebhookSignatureHeader = "X-ANET-Signature"
+
+// silentPostHashFields é a ordem de campos usada pela Authorize.net para o x_SHA2_Hash
+// em Silent Post e Relay Response
+var silentPostHashFields = []string{
+    "x_trans_id", "x_test_request", "x_response_code", "x_auth_code", "x_cvv2_resp_code",
+    "x_cavv_response", "x_avs_code", "x_method", "x_account_number", "x_amount",
+    "x_company", "x_first_name", "x_last_name", "x_address", "x_city",
+    "x_state", "x_zip", "x_country", "x_phone", "x_fax",
+    "x_email", "x_ship_to_company", "x_ship_to_first_name", "x_ship_to_last_name", "x_ship_to_address",
+    "x_ship_to_city", "x_ship_to_state", "x_ship_to_zip", "x_ship_to_country", "x_invoice_num",
+}
+
+// VerifyWebhookSignature valida o HMAC-SHA512 do corpo de um evento da Webhooks API.
+// Na Webhooks API a Signature Key é usada como string, sem decodificar o hex.
+func VerifyWebhookSignature(signatureKey string, body []byte, header string) error {
+    if signatureKey == "" {
+        return fmt.Errorf("signature key not configured")
+    }
+    if header == "" {
+        return fmt.Errorf("missing %s header", WebhookSignatureHeader)
+    }
+
+    received := strings.TrimSpace(header)
+    if idx := strings.Index(received, "="); idx >= 0 {
+        if !strings.EqualFold(received[:idx], "sha512") {
+            return fmt.Errorf("unsupported signature algorithm: %s", received[:idx])
+        }
+        received = received[idx+1:]
+    }
+
+    receivedMAC, err := hex.DecodeString(received)
+    if err != nil {
+        return fmt.Errorf("malformed signature: %v", err)
+    }
+
+    mac := hmac.New(sha512.New, []byte(signatureKey))
+    mac.Write(body)
+
+    if !hmac.Equal(receivedMAC, mac.Sum(nil)) {
+        return fmt.Errorf("signature mismatch")
+    }
+
+    return nil
+}
+
+// VerifySilentPostHash valida o hash de um Silent Post / Relay Response.
+// Usa x_SHA2_Hash (HMAC-SHA512 com a Signature Key) e só aceita o x_MD5_Hash legado
+// quando um MD5 Hash Value estiver configurado.
+func VerifySilentPostHash(signatureKey, md5HashValue, apiLoginID string, form url.Values) error {
+    if sha2Hash := form.Get("x_SHA2_Hash"); sha2Hash != "" {
+        if signatureKey == "" {
+            return fmt.Errorf("signature key not configured")
+        }
+
+        key, err := hex.DecodeString(signatureKey)
+        if err != nil {
+            return fmt.Errorf("invalid signature key: %v", err)
+        }
+
+        values := make([]string, 0, len(silentPostHashFields))
+        for _, field := range silentPostHashFields {
+            values = append(values, form.Get(field))
+        }
+        message := "^" + strings.Join(values, "^") + "^"
+
+        receivedMAC, err := hex.DecodeString(sha2Hash)
+        if err != nil {
+            return fmt.Errorf("malformed x_SHA2_Hash: %v", err)
+        }
+
+        mac := hmac.New(sha512.New, key)
+        mac.Write([]byte(message))
+
+        if !hmac.Equal(receivedMAC, mac.Sum(nil)) {
+            return fmt.Errorf("x_SHA2_Hash mismatch")
+        }
+        return nil
+    }
+
+    if md5Hash := form.Get("x_MD5_Hash"); md5Hash != "" {
+        if md5HashValue == "" {
+            return fmt.Errorf("x_MD5_Hash received but no MD5 hash value configured")
+        }
+
+        sum := md5.Sum([]byte(md5HashValue + apiLoginID + form.Get("x_trans_id") + form.Get("x_amount")))
+        expected := hex.EncodeToString(sum[:])
+
+        if !hmac.Equal([]byte(strings.ToLower(md5Hash)), []byte(expected)) {
+            return fmt.Errorf("x_MD5_Hash mismatch")
+        }
+        return nil
+    }
+
+    return fmt.Errorf("unsigned notification: no x_SHA2_Hash or x_MD5_Hash")
+}
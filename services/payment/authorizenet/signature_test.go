@@ -0,0 +1,150 @@
+package authorizenet
+
+import (
+    "crypto/hmac"
+    "crypto/md5"
+    "crypto/sha512"
+    "encoding/hex"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+// Signature Key de teste (128 caracteres hex, como a gerada no painel da Authorize.net)
+var testSignatureKey = strings.Repeat("0123456789ABCDEF", 8)
+
+func webhookSignature(key string, body []byte) string {
+    mac := hmac.New(sha512.New, []byte(key))
+    mac.Write(body)
+    return "sha512=" + strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+    body := []byte(`{"notificationId":"n1","eventType":"net.authorize.payment.authcapture.created"}`)
+    valid := webhookSignature(testSignatureKey, body)
+
+    tests := []struct {
+        name    string
+        key     string
+        body    []byte
+        header  string
+        wantErr bool
+    }{
+        {"valid", testSignatureKey, body, valid, false},
+        {"valid without prefix", testSignatureKey, body, strings.TrimPrefix(valid, "sha512="), false},
+        {"lowercase prefix and hex", testSignatureKey, body, strings.ToLower(valid), false},
+        {"tampered body", testSignatureKey, []byte(strings.Replace(string(body), "n1", "n2", 1)), valid, true},
+        {"wrong key", strings.Repeat("F", 128), body, valid, true},
+        {"missing header", testSignatureKey, body, "", true},
+        {"key not configured", "", body, valid, true},
+        {"unsupported algorithm", testSignatureKey, body, "sha256=" + strings.TrimPrefix(valid, "sha512="), true},
+        {"malformed hex", testSignatureKey, body, "sha512=not-hex", true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := VerifyWebhookSignature(tt.key, tt.body, tt.header)
+            if (err != nil) != tt.wantErr {
+                t.Errorf("VerifyWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func silentPostForm() url.Values {
+    return url.Values{
+        "x_trans_id":       {"60123456789"},
+        "x_test_request":   {"false"},
+        "x_response_code":  {"1"},
+        "x_auth_code":      {"ABC123"},
+        "x_avs_code":       {"Y"},
+        "x_method":         {"CC"},
+        "x_account_number": {"XXXX1111"},
+        "x_amount":         {"29.99"},
+        "x_first_name":     {"Jane"},
+        "x_last_name":      {"Doe"},
+        "x_zip":            {"10001"},
+        "x_invoice_num":    {"INV-1"},
+    }
+}
+
+// signSilentPost calcula o x_SHA2_Hash como a Authorize.net: HMAC-SHA512 com a Signature
+// Key decodificada sobre os campos na ordem documentada, separados por "^"
+func signSilentPost(t *testing.T, key string, form url.Values) string {
+    t.Helper()
+
+    rawKey, err := hex.DecodeString(key)
+    if err != nil {
+        t.Fatalf("invalid test key: %v", err)
+    }
+
+    values := make([]string, 0, len(silentPostHashFields))
+    for _, field := range silentPostHashFields {
+        values = append(values, form.Get(field))
+    }
+
+    mac := hmac.New(sha512.New, rawKey)
+    mac.Write([]byte("^" + strings.Join(values, "^") + "^"))
+    return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifySilentPostSHA2Hash(t *testing.T) {
+    form := silentPostForm()
+    form.Set("x_SHA2_Hash", signSilentPost(t, testSignatureKey, form))
+
+    if err := VerifySilentPostHash(testSignatureKey, "", "login", form); err != nil {
+        t.Fatalf("valid x_SHA2_Hash rejected: %v", err)
+    }
+
+    tampered := silentPostForm()
+    tampered.Set("x_SHA2_Hash", form.Get("x_SHA2_Hash"))
+    tampered.Set("x_amount", "0.01")
+    if err := VerifySilentPostHash(testSignatureKey, "", "login", tampered); err == nil {
+        t.Error("x_SHA2_Hash accepted with tampered amount")
+    }
+
+    if err := VerifySilentPostHash("", "", "login", form); err == nil {
+        t.Error("x_SHA2_Hash accepted without a signature key")
+    }
+
+    if err := VerifySilentPostHash("not-hex", "", "login", form); err == nil {
+        t.Error("x_SHA2_Hash accepted with a malformed signature key")
+    }
+}
+
+func TestVerifySilentPostSHA2HashTakesPrecedence(t *testing.T) {
+    // Com os dois hashes presentes só o x_SHA2_Hash vale, mesmo com o MD5 correto
+    form := silentPostForm()
+    form.Set("x_SHA2_Hash", strings.Repeat("00", sha512.Size))
+    sum := md5.Sum([]byte("secret" + "login" + form.Get("x_trans_id") + form.Get("x_amount")))
+    form.Set("x_MD5_Hash", hex.EncodeToString(sum[:]))
+
+    if err := VerifySilentPostHash(testSignatureKey, "secret", "login", form); err == nil {
+        t.Error("invalid x_SHA2_Hash accepted because of a valid x_MD5_Hash")
+    }
+}
+
+func TestVerifySilentPostMD5Hash(t *testing.T) {
+    form := silentPostForm()
+    sum := md5.Sum([]byte("secret" + "login" + form.Get("x_trans_id") + form.Get("x_amount")))
+    form.Set("x_MD5_Hash", strings.ToUpper(hex.EncodeToString(sum[:])))
+
+    if err := VerifySilentPostHash(testSignatureKey, "secret", "login", form); err != nil {
+        t.Fatalf("valid x_MD5_Hash rejected: %v", err)
+    }
+
+    // O MD5 legado só é aceito com um MD5 Hash Value configurado
+    if err := VerifySilentPostHash(testSignatureKey, "", "login", form); err == nil {
+        t.Error("x_MD5_Hash accepted without an MD5 hash value configured")
+    }
+
+    if err := VerifySilentPostHash(testSignatureKey, "other", "login", form); err == nil {
+        t.Error("x_MD5_Hash accepted with the wrong MD5 hash value")
+    }
+}
+
+func TestVerifySilentPostUnsigned(t *testing.T) {
+    if err := VerifySilentPostHash(testSignatureKey, "secret", "login", silentPostForm()); err == nil {
+        t.Error("unsigned notification accepted")
+    }
+}
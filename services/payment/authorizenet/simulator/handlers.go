@@ -0,0 +1,906 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status internos das transações do simulador
+const (
+	statusAuthorized = "authorized"
+	statusCaptured   = "captured"
+	statusSettled    = "settled"
+	statusVoided     = "voided"
+	statusRefund     = "refund"
+	statusDeclined   = "declined"
+)
+
+// Janela de duplicidade padrão da Authorize.net quando duplicateWindow não é enviado
+const defaultDuplicateWindow = 2 * time.Minute
+
+type transaction struct {
+	id             string
+	status         string
+	amount         float64
+	refundedAmount float64
+	cardNumber     string
+}
+
+type paymentProfile struct {
+	id         string
+	cardNumber string
+	expiry     string
+	billTo     *address
+}
+
+type customerProfile struct {
+	id                 string
+	merchantCustomerID string
+	description        string
+	email              string
+	paymentProfiles    []*paymentProfile
+}
+
+type subscription struct {
+	id                string
+	customerProfileID string
+	paymentProfileID  string
+	cardNumber        string
+	amount            float64
+	intervalLength    int
+	intervalUnit      string
+	startDate         string
+	status            string
+}
+
+type duplicateEntry struct {
+	transID string
+	at      time.Time
+}
+
+type message struct {
+	Code string `json:"code"`
+	Text string `json:"text"`
+}
+
+type messages struct {
+	ResultCode string    `json:"resultCode"`
+	Message    []message `json:"message"`
+}
+
+type creditCard struct {
+	CardNumber     string `json:"cardNumber"`
+	ExpirationDate string `json:"expirationDate"`
+	CardCode       string `json:"cardCode,omitempty"`
+}
+
+type opaqueData struct {
+	DataDescriptor string `json:"dataDescriptor"`
+	DataValue      string `json:"dataValue"`
+}
+
+type paymentType struct {
+	CreditCard *creditCard `json:"creditCard,omitempty"`
+	OpaqueData *opaqueData `json:"opaqueData,omitempty"`
+}
+
+type address struct {
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	Address   string `json:"address,omitempty"`
+	City      string `json:"city,omitempty"`
+	State     string `json:"state,omitempty"`
+	Zip       string `json:"zip,omitempty"`
+	Country   string `json:"country,omitempty"`
+}
+
+func okMessages() messages {
+	return messages{ResultCode: "Ok", Message: []message{{Code: "I00001", Text: "Successful."}}}
+}
+
+func errorResponse(code, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"messages": messages{ResultCode: "Error", Message: []message{{Code: code, Text: text}}},
+	}
+}
+
+func okResponse(fields map[string]interface{}) map[string]interface{} {
+	fields["messages"] = okMessages()
+	return fields
+}
+
+func parseAmount(amount string) float64 {
+	value, _ := strconv.ParseFloat(amount, 64)
+	return value
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func maskCard(cardNumber string) string {
+	if len(cardNumber) < 4 {
+		return "XXXX"
+	}
+	return "XXXX" + cardNumber[len(cardNumber)-4:]
+}
+
+func luhnValid(cardNumber string) bool {
+	if len(cardNumber) < 13 || len(cardNumber) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(cardNumber) - 1; i >= 0; i-- {
+		digit := int(cardNumber[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// expiryValid aceita os formatos da API: MMYY, MM/YY, YYYY-MM e "XXXX" (mascarado)
+func expiryValid(expiry string) bool {
+	if expiry == "XXXX" {
+		return true
+	}
+
+	var expiryTime time.Time
+	var err error
+	switch {
+	case strings.Contains(expiry, "/"):
+		expiryTime, err = time.Parse("01/06", expiry)
+	case strings.Contains(expiry, "-"):
+		expiryTime, err = time.Parse("2006-01", expiry)
+	default:
+		expiryTime, err = time.Parse("0106", expiry)
+	}
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(expiryTime.AddDate(0, 1, 0))
+}
+
+// cardDecline aplica as regras da sandbox. Retorna responseCode e reasonCode, ou "" se aprovado.
+func cardDecline(cardNumber, expiry, cardCode, zip string) (string, string) {
+	switch {
+	case !luhnValid(cardNumber):
+		return "3", "6"
+	case !expiryValid(expiry):
+		return "3", "8"
+	case zip == DeclineZip:
+		return "2", "2"
+	case cardCode == MismatchCardCode:
+		return "2", "44"
+	}
+	return "", ""
+}
+
+// transactionResult monta a resposta de createTransactionRequest
+func transactionResult(transID, responseCode, reasonCode, refTransID string) map[string]interface{} {
+	txResponse := map[string]interface{}{
+		"responseCode":  responseCode,
+		"authCode":      "",
+		"avsResultCode": "P",
+		"cvvResultCode": "",
+		"transId":       transID,
+		"refTransId":    refTransID,
+		"accountNumber": "",
+	}
+
+	if responseCode == "1" {
+		txResponse["authCode"] = "SIM" + transID[len(transID)-3:]
+		txResponse["avsResultCode"] = "Y"
+		txResponse["cvvResultCode"] = "M"
+		txResponse["messages"] = []map[string]string{{"code": reasonCode, "description": reasonTexts[reasonCode]}}
+		return okResponse(map[string]interface{}{"transactionResponse": txResponse})
+	}
+
+	txResponse["errors"] = []map[string]string{{"errorCode": reasonCode, "errorText": reasonTexts[reasonCode]}}
+	return map[string]interface{}{
+		"transactionResponse": txResponse,
+		"messages":            messages{ResultCode: "Error", Message: []message{{Code: "E00027", Text: "The transaction was unsuccessful."}}},
+	}
+}
+
+func (s *Simulator) createTransaction(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		TransactionRequest struct {
+			TransactionType string       `json:"transactionType"`
+			Amount          string       `json:"amount"`
+			Payment         *paymentType `json:"payment"`
+			Profile         *struct {
+				CreateProfile     bool   `json:"createProfile"`
+				CustomerProfileID string `json:"customerProfileId"`
+				PaymentProfile    *struct {
+					PaymentProfileID string `json:"paymentProfileId"`
+					CardCode         string `json:"cardCode"`
+				} `json:"paymentProfile"`
+			} `json:"profile"`
+			RefTransID string `json:"refTransId"`
+			Order      *struct {
+				InvoiceNumber string `json:"invoiceNumber"`
+			} `json:"order"`
+			Customer *struct {
+				Email string `json:"email"`
+			} `json:"customer"`
+			BillTo              *address `json:"billTo"`
+			TransactionSettings *struct {
+				Setting []struct {
+					SettingName  string `json:"settingName"`
+					SettingValue string `json:"settingValue"`
+				} `json:"setting"`
+			} `json:"transactionSettings"`
+		} `json:"transactionRequest"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid createTransactionRequest: %v", err))
+	}
+	txReq := req.TransactionRequest
+
+	if forced != nil {
+		if forced.ResponseCode != "" {
+			id := s.newID()
+			s.transactions[id] = &transaction{id: id, status: statusDeclined, amount: parseAmount(txReq.Amount)}
+			return transactionResult(id, forced.ResponseCode, forced.ReasonCode, txReq.RefTransID)
+		}
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	switch txReq.TransactionType {
+	case "authOnlyTransaction", "authCaptureTransaction":
+		status := statusAuthorized
+		if txReq.TransactionType == "authCaptureTransaction" {
+			status = statusCaptured
+		}
+
+		amount := parseAmount(txReq.Amount)
+		if amount <= 0 {
+			return errorResponse("E00027", "A valid amount is required.")
+		}
+
+		var cardNumber, expiry, cardCode, zip string
+		var card *creditCard
+		switch {
+		case txReq.Payment != nil:
+			var errResp map[string]interface{}
+			if card, errResp = s.resolveCard(txReq.Payment); errResp != nil {
+				return errResp
+			}
+			cardNumber, expiry, cardCode = card.CardNumber, card.ExpirationDate, card.CardCode
+			if txReq.BillTo != nil {
+				zip = txReq.BillTo.Zip
+			}
+		case txReq.Profile != nil && txReq.Profile.PaymentProfile != nil:
+			pp := s.findPaymentProfile(txReq.Profile.CustomerProfileID, txReq.Profile.PaymentProfile.PaymentProfileID)
+			if pp == nil {
+				return errorResponse("E00040", "The record cannot be found.")
+			}
+			cardNumber, expiry, cardCode = pp.cardNumber, pp.expiry, txReq.Profile.PaymentProfile.CardCode
+			if pp.billTo != nil {
+				zip = pp.billTo.Zip
+			}
+		default:
+			return errorResponse("E00027", "Payment information is required.")
+		}
+
+		// Janela de duplicidade: mesmo cartão, valor e invoice
+		window := defaultDuplicateWindow
+		if txReq.TransactionSettings != nil {
+			for _, setting := range txReq.TransactionSettings.Setting {
+				if setting.SettingName == "duplicateWindow" {
+					if seconds, err := strconv.Atoi(setting.SettingValue); err == nil {
+						window = time.Duration(seconds) * time.Second
+					}
+				}
+			}
+		}
+		invoice := ""
+		if txReq.Order != nil {
+			invoice = txReq.Order.InvoiceNumber
+		}
+		duplicateKey := fmt.Sprintf("%s|%s|%.2f|%s", txReq.TransactionType, cardNumber, amount, invoice)
+		if entry, ok := s.duplicates[duplicateKey]; ok && time.Since(entry.at) < window {
+			response := transactionResult(entry.transID, "3", "11", "")
+			txResponse := response["transactionResponse"].(map[string]interface{})
+			txResponse["transId"] = "0"
+			txResponse["errors"] = []map[string]string{{
+				"errorCode":             "11",
+				"errorText":             reasonTexts["11"],
+				"originalTransactionId": entry.transID,
+			}}
+			return response
+		}
+
+		id := s.newID()
+		tx := &transaction{id: id, amount: amount, cardNumber: cardNumber}
+		s.transactions[id] = tx
+		s.duplicates[duplicateKey] = duplicateEntry{transID: id, at: time.Now()}
+
+		if responseCode, reasonCode := cardDecline(cardNumber, expiry, cardCode, zip); responseCode != "" {
+			tx.status = statusDeclined
+			return withAccountNumber(transactionResult(id, responseCode, reasonCode, ""), cardNumber)
+		}
+
+		tx.status = status
+		response := withAccountNumber(transactionResult(id, "1", "1", ""), cardNumber)
+
+		// profile.createProfile: guarda o cartão da transação em um novo customer profile
+		if card != nil && txReq.Profile != nil && txReq.Profile.CreateProfile {
+			email := ""
+			if txReq.Customer != nil {
+				email = txReq.Customer.Email
+			}
+			response["profileResponse"] = s.createProfileFromTransaction(email, card, txReq.BillTo)
+		}
+		return response
+
+	case "priorAuthCaptureTransaction":
+		tx, ok := s.transactions[txReq.RefTransID]
+		if !ok || tx.status != statusAuthorized {
+			return transactionResult("0", "3", "16", txReq.RefTransID)
+		}
+		if amount := parseAmount(txReq.Amount); amount > 0 {
+			if toCents(amount) > toCents(tx.amount) {
+				return transactionResult("0", "3", "47", txReq.RefTransID)
+			}
+			tx.amount = amount
+		}
+		tx.status = statusCaptured
+		return transactionResult(tx.id, "1", "1", txReq.RefTransID)
+
+	case "voidTransaction":
+		tx, ok := s.transactions[txReq.RefTransID]
+		if !ok {
+			return transactionResult("0", "3", "16", txReq.RefTransID)
+		}
+		switch tx.status {
+		case statusVoided:
+			return transactionResult(tx.id, "1", "310", txReq.RefTransID)
+		case statusAuthorized, statusCaptured:
+			tx.status = statusVoided
+			return transactionResult(tx.id, "1", "1", txReq.RefTransID)
+		default:
+			return transactionResult("0", "3", "16", txReq.RefTransID)
+		}
+
+	case "refundTransaction":
+		original, ok := s.transactions[txReq.RefTransID]
+		if !ok || original.status != statusSettled {
+			return transactionResult("0", "3", "54", txReq.RefTransID)
+		}
+		if txReq.Payment == nil || txReq.Payment.CreditCard == nil ||
+			!strings.HasSuffix(original.cardNumber, txReq.Payment.CreditCard.CardNumber) {
+			return transactionResult("0", "3", "54", txReq.RefTransID)
+		}
+		amount := parseAmount(txReq.Amount)
+		if amount <= 0 || toCents(original.refundedAmount+amount) > toCents(original.amount) {
+			return transactionResult("0", "3", "55", txReq.RefTransID)
+		}
+
+		original.refundedAmount += amount
+		id := s.newID()
+		s.transactions[id] = &transaction{id: id, status: statusRefund, amount: amount, cardNumber: original.cardNumber}
+		return transactionResult(id, "1", "1", txReq.RefTransID)
+	}
+
+	return errorResponse("E00003", fmt.Sprintf("Unsupported transactionType %s.", txReq.TransactionType))
+}
+
+type paymentProfileRequest struct {
+	CustomerPaymentProfileID string       `json:"customerPaymentProfileId"`
+	BillTo                   *address     `json:"billTo"`
+	Payment                  *paymentType `json:"payment"`
+}
+
+func (s *Simulator) findPaymentProfile(customerProfileID, paymentProfileID string) *paymentProfile {
+	profile, ok := s.profiles[customerProfileID]
+	if !ok {
+		return nil
+	}
+	for _, pp := range profile.paymentProfiles {
+		if pp.id == paymentProfileID {
+			return pp
+		}
+	}
+	return nil
+}
+
+// resolveCard retorna o cartão do bloco payment. Um nonce do Accept.js é trocado
+// pelo cartão tokenizado e descartado, já que só pode ser usado uma vez.
+func (s *Simulator) resolveCard(payment *paymentType) (*creditCard, map[string]interface{}) {
+	switch {
+	case payment == nil:
+		return nil, errorResponse("E00029", "Payment information is required.")
+	case payment.OpaqueData != nil:
+		card, ok := s.nonces[payment.OpaqueData.DataValue]
+		if !ok || payment.OpaqueData.DataDescriptor != NonceDescriptor {
+			return nil, errorResponse("E00114", "Invalid OTS Token.")
+		}
+		delete(s.nonces, payment.OpaqueData.DataValue)
+		return card, nil
+	case payment.CreditCard != nil:
+		return payment.CreditCard, nil
+	}
+	return nil, errorResponse("E00029", "Payment information is required.")
+}
+
+// withAccountNumber preenche o número mascarado na resposta da transação
+func withAccountNumber(response map[string]interface{}, cardNumber string) map[string]interface{} {
+	if txResponse, ok := response["transactionResponse"].(map[string]interface{}); ok {
+		txResponse["accountNumber"] = maskCard(cardNumber)
+	}
+	return response
+}
+
+// createProfileFromTransaction monta o profileResponse de uma transação com
+// profile.createProfile, criando o perfil quando não há outro com o mesmo email
+func (s *Simulator) createProfileFromTransaction(email string, card *creditCard, billTo *address) map[string]interface{} {
+	if email == "" {
+		return map[string]interface{}{
+			"messages": messages{ResultCode: "Error", Message: []message{{Code: "E00041", Text: "One or more fields in the profile must contain a value."}}},
+		}
+	}
+
+	for _, profile := range s.profiles {
+		if profile.email == email && profile.merchantCustomerID == "" && profile.description == "" {
+			return map[string]interface{}{
+				"messages": messages{ResultCode: "Error", Message: []message{{Code: "E00039", Text: fmt.Sprintf("A duplicate record with ID %s already exists.", profile.id)}}},
+			}
+		}
+	}
+
+	pp := &paymentProfile{
+		id:         s.newID(),
+		cardNumber: card.CardNumber,
+		expiry:     card.ExpirationDate,
+		billTo:     billTo,
+	}
+	profile := &customerProfile{
+		id:              s.newID(),
+		email:           email,
+		paymentProfiles: []*paymentProfile{pp},
+	}
+	s.profiles[profile.id] = profile
+
+	return map[string]interface{}{
+		"messages":                     okMessages(),
+		"customerProfileId":            profile.id,
+		"customerPaymentProfileIdList": []string{pp.id},
+	}
+}
+
+// validateProfileCard aplica validationMode=testMode: só formato e validade do cartão
+func validateProfileCard(card *creditCard) map[string]interface{} {
+	if !luhnValid(card.CardNumber) {
+		return errorResponse("E00027", reasonTexts["6"])
+	}
+	if !expiryValid(card.ExpirationDate) {
+		return errorResponse("E00027", reasonTexts["8"])
+	}
+	return nil
+}
+
+func (s *Simulator) createCustomerProfile(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		Profile struct {
+			MerchantCustomerID string                  `json:"merchantCustomerId"`
+			Description        string                  `json:"description"`
+			Email              string                  `json:"email"`
+			PaymentProfiles    []paymentProfileRequest `json:"paymentProfiles"`
+		} `json:"profile"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid createCustomerProfileRequest: %v", err))
+	}
+
+	cards := make([]*creditCard, len(req.Profile.PaymentProfiles))
+	for i, ppReq := range req.Profile.PaymentProfiles {
+		card, errResp := s.resolveCard(ppReq.Payment)
+		if errResp != nil {
+			return errResp
+		}
+		if errResp := validateProfileCard(card); errResp != nil {
+			return errResp
+		}
+		cards[i] = card
+	}
+
+	// Perfil duplicado: mesmo merchantCustomerId, description e email
+	for _, profile := range s.profiles {
+		if profile.merchantCustomerID == req.Profile.MerchantCustomerID &&
+			profile.description == req.Profile.Description && profile.email == req.Profile.Email {
+			return errorResponse("E00039", fmt.Sprintf("A duplicate record with ID %s already exists.", profile.id))
+		}
+	}
+
+	profile := &customerProfile{
+		id:                 s.newID(),
+		merchantCustomerID: req.Profile.MerchantCustomerID,
+		description:        req.Profile.Description,
+		email:              req.Profile.Email,
+	}
+
+	paymentProfileIDs := []string{}
+	for i, ppReq := range req.Profile.PaymentProfiles {
+		pp := &paymentProfile{
+			id:         s.newID(),
+			cardNumber: cards[i].CardNumber,
+			expiry:     cards[i].ExpirationDate,
+			billTo:     ppReq.BillTo,
+		}
+		profile.paymentProfiles = append(profile.paymentProfiles, pp)
+		paymentProfileIDs = append(paymentProfileIDs, pp.id)
+	}
+	s.profiles[profile.id] = profile
+
+	if forced != nil {
+		if forced.Code == "E00039" && forced.Text == "" {
+			// O perfil "já existia": o client deve recuperá-lo pelo ID da mensagem
+			return errorResponse("E00039", fmt.Sprintf("A duplicate record with ID %s already exists.", profile.id))
+		}
+		delete(s.profiles, profile.id)
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	return okResponse(map[string]interface{}{
+		"customerProfileId":             profile.id,
+		"customerPaymentProfileIdList":  paymentProfileIDs,
+		"customerShippingAddressIdList": []string{},
+		"validationDirectResponseList":  []string{},
+	})
+}
+
+func (s *Simulator) getCustomerProfile(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		CustomerProfileID string `json:"customerProfileId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid getCustomerProfileRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	profile, ok := s.profiles[req.CustomerProfileID]
+	if !ok {
+		return errorResponse("E00040", "The record cannot be found.")
+	}
+
+	paymentProfiles := []map[string]interface{}{}
+	for _, pp := range profile.paymentProfiles {
+		paymentProfiles = append(paymentProfiles, map[string]interface{}{
+			"customerPaymentProfileId": pp.id,
+			"billTo":                   pp.billTo,
+			"payment": map[string]interface{}{
+				"creditCard": map[string]string{
+					"cardNumber":     maskCard(pp.cardNumber),
+					"expirationDate": "XXXX",
+				},
+			},
+		})
+	}
+
+	return okResponse(map[string]interface{}{
+		"profile": map[string]interface{}{
+			"customerProfileId":  profile.id,
+			"merchantCustomerId": profile.merchantCustomerID,
+			"description":        profile.description,
+			"email":              profile.email,
+			"paymentProfiles":    paymentProfiles,
+		},
+	})
+}
+
+func (s *Simulator) createCustomerPaymentProfile(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		CustomerProfileID string                `json:"customerProfileId"`
+		PaymentProfile    paymentProfileRequest `json:"paymentProfile"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid createCustomerPaymentProfileRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	profile, ok := s.profiles[req.CustomerProfileID]
+	if !ok {
+		return errorResponse("E00040", "The record cannot be found.")
+	}
+
+	card, errResp := s.resolveCard(req.PaymentProfile.Payment)
+	if errResp != nil {
+		return errResp
+	}
+	if errResp := validateProfileCard(card); errResp != nil {
+		return errResp
+	}
+
+	cardNumber := card.CardNumber
+	for _, pp := range profile.paymentProfiles {
+		if pp.cardNumber == cardNumber {
+			response := errorResponse("E00039", "A duplicate customer payment profile already exists.")
+			response["customerProfileId"] = profile.id
+			response["customerPaymentProfileId"] = pp.id
+			return response
+		}
+	}
+
+	pp := &paymentProfile{
+		id:         s.newID(),
+		cardNumber: cardNumber,
+		expiry:     card.ExpirationDate,
+		billTo:     req.PaymentProfile.BillTo,
+	}
+	profile.paymentProfiles = append(profile.paymentProfiles, pp)
+
+	return okResponse(map[string]interface{}{
+		"customerProfileId":        profile.id,
+		"customerPaymentProfileId": pp.id,
+	})
+}
+
+func (s *Simulator) updateCustomerPaymentProfile(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		CustomerProfileID string                `json:"customerProfileId"`
+		PaymentProfile    paymentProfileRequest `json:"paymentProfile"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid updateCustomerPaymentProfileRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	pp := s.findPaymentProfile(req.CustomerProfileID, req.PaymentProfile.CustomerPaymentProfileID)
+	if pp == nil {
+		return errorResponse("E00040", "The record cannot be found.")
+	}
+
+	card, errResp := s.resolveCard(req.PaymentProfile.Payment)
+	if errResp != nil {
+		return errResp
+	}
+	if errResp := validateProfileCard(card); errResp != nil {
+		return errResp
+	}
+
+	pp.cardNumber = card.CardNumber
+	pp.expiry = card.ExpirationDate
+	if req.PaymentProfile.BillTo != nil {
+		pp.billTo = req.PaymentProfile.BillTo
+	}
+
+	return okResponse(map[string]interface{}{})
+}
+
+func (s *Simulator) createSubscription(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		Subscription struct {
+			PaymentSchedule struct {
+				Interval struct {
+					Length int    `json:"length"`
+					Unit   string `json:"unit"`
+				} `json:"interval"`
+				StartDate string `json:"startDate"`
+			} `json:"paymentSchedule"`
+			Amount  string       `json:"amount"`
+			Payment *paymentType `json:"payment"`
+			Profile *struct {
+				CustomerProfileID        string `json:"customerProfileId"`
+				CustomerPaymentProfileID string `json:"customerPaymentProfileId"`
+			} `json:"profile"`
+		} `json:"subscription"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid ARBCreateSubscriptionRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	sub := req.Subscription
+	amount := parseAmount(sub.Amount)
+	if amount <= 0 {
+		return errorResponse("E00003", "The 'AnetApi/xml/v1/schema/AnetApiSchema.xsd:amount' element is invalid.")
+	}
+
+	if _, err := time.Parse("2006-01-02", sub.PaymentSchedule.StartDate); err != nil {
+		return errorResponse("E00003", "The 'AnetApi/xml/v1/schema/AnetApiSchema.xsd:startDate' element is invalid.")
+	}
+
+	newSub := &subscription{
+		amount:         amount,
+		intervalLength: sub.PaymentSchedule.Interval.Length,
+		intervalUnit:   sub.PaymentSchedule.Interval.Unit,
+		startDate:      sub.PaymentSchedule.StartDate,
+		status:         "active",
+	}
+
+	switch {
+	case sub.Profile != nil:
+		if s.findPaymentProfile(sub.Profile.CustomerProfileID, sub.Profile.CustomerPaymentProfileID) == nil {
+			return errorResponse("E00040", "The record cannot be found.")
+		}
+		newSub.customerProfileID = sub.Profile.CustomerProfileID
+		newSub.paymentProfileID = sub.Profile.CustomerPaymentProfileID
+	case sub.Payment != nil && sub.Payment.CreditCard != nil:
+		if !luhnValid(sub.Payment.CreditCard.CardNumber) {
+			return errorResponse("E00013", reasonTexts["6"])
+		}
+		if !expiryValid(sub.Payment.CreditCard.ExpirationDate) {
+			return errorResponse("E00018", "Credit Card expires before the start of the subscription.")
+		}
+		newSub.cardNumber = sub.Payment.CreditCard.CardNumber
+	default:
+		return errorResponse("E00029", "Payment information is required.")
+	}
+
+	// ARB recusa assinaturas idênticas (E00012)
+	for _, existing := range s.subscriptions {
+		if existing.status == "active" && existing.customerProfileID == newSub.customerProfileID &&
+			existing.paymentProfileID == newSub.paymentProfileID && existing.cardNumber == newSub.cardNumber &&
+			toCents(existing.amount) == toCents(newSub.amount) && existing.intervalLength == newSub.intervalLength &&
+			existing.intervalUnit == newSub.intervalUnit {
+			return errorResponse("E00012", fmt.Sprintf(
+				"You have submitted a duplicate of Subscription %s. A duplicate subscription will not be created.", existing.id))
+		}
+	}
+
+	newSub.id = s.newID()
+	s.subscriptions[newSub.id] = newSub
+
+	response := okResponse(map[string]interface{}{"subscriptionId": newSub.id})
+	if newSub.customerProfileID != "" {
+		response["profile"] = map[string]string{
+			"customerProfileId":        newSub.customerProfileID,
+			"customerPaymentProfileId": newSub.paymentProfileID,
+		}
+	}
+	return response
+}
+
+func (s *Simulator) updateSubscription(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		SubscriptionID string `json:"subscriptionId"`
+		Subscription   struct {
+			Amount string `json:"amount"`
+		} `json:"subscription"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid ARBUpdateSubscriptionRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	sub, ok := s.subscriptions[req.SubscriptionID]
+	if !ok {
+		return errorResponse("E00035", "The subscription cannot be found.")
+	}
+
+	if sub.status == "canceled" || sub.status == "terminated" {
+		return errorResponse("E00037", "Subscriptions that are canceled cannot be updated.")
+	}
+
+	if req.Subscription.Amount != "" {
+		amount := parseAmount(req.Subscription.Amount)
+		if amount <= 0 {
+			return errorResponse("E00003", "The 'AnetApi/xml/v1/schema/AnetApiSchema.xsd:amount' element is invalid.")
+		}
+		sub.amount = amount
+	}
+
+	return okResponse(map[string]interface{}{})
+}
+
+func (s *Simulator) cancelSubscription(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid ARBCancelSubscriptionRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	sub, ok := s.subscriptions[req.SubscriptionID]
+	if !ok {
+		return errorResponse("E00035", "The subscription cannot be found.")
+	}
+
+	if sub.status == "canceled" {
+		return map[string]interface{}{
+			"messages": messages{ResultCode: "Ok", Message: []message{{Code: "I00002", Text: "The subscription has already been canceled."}}},
+		}
+	}
+
+	sub.status = "canceled"
+	return okResponse(map[string]interface{}{})
+}
+
+func (s *Simulator) getSubscriptionStatus(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid ARBGetSubscriptionStatusRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	sub, ok := s.subscriptions[req.SubscriptionID]
+	if !ok {
+		return errorResponse("E00035", "The subscription cannot be found.")
+	}
+
+	return okResponse(map[string]interface{}{"status": sub.status})
+}
+
+func (s *Simulator) getSubscription(payload json.RawMessage, forced *Behavior) interface{} {
+	var req struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid ARBGetSubscriptionRequest: %v", err))
+	}
+
+	if forced != nil {
+		return errorResponse(forced.Code, forced.Text)
+	}
+
+	sub, ok := s.subscriptions[req.SubscriptionID]
+	if !ok {
+		return errorResponse("E00035", "The subscription cannot be found.")
+	}
+
+	details := map[string]interface{}{
+		"name": "ProSecure Subscription",
+		"paymentSchedule": map[string]interface{}{
+			"interval": map[string]interface{}{
+				"length": sub.intervalLength,
+				"unit":   sub.intervalUnit,
+			},
+			"startDate":        sub.startDate,
+			"totalOccurrences": 9999,
+			"trialOccurrences": 0,
+		},
+		"amount":      sub.amount,
+		"trialAmount": 0,
+		"status":      sub.status,
+	}
+	if sub.customerProfileID != "" {
+		details["profile"] = map[string]interface{}{
+			"customerProfileId": sub.customerProfileID,
+			"paymentProfile": map[string]string{
+				"customerPaymentProfileId": sub.paymentProfileID,
+			},
+		}
+	}
+
+	return okResponse(map[string]interface{}{"subscription": details})
+}
@@ -0,0 +1,363 @@
+// services/payment/authorizenet/simulator/simulator.go - Simulador HTTP da API request.api da Authorize.net
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Nomes das requisições JSON atendidas pelo simulador
+const (
+	AnyRequest                          = ""
+	RequestCreateTransaction            = "createTransactionRequest"
+	RequestCreateCustomerProfile        = "createCustomerProfileRequest"
+	RequestGetCustomerProfile           = "getCustomerProfileRequest"
+	RequestCreateCustomerPaymentProfile = "createCustomerPaymentProfileRequest"
+	RequestUpdateCustomerPaymentProfile = "updateCustomerPaymentProfileRequest"
+	RequestARBCreateSubscription        = "ARBCreateSubscriptionRequest"
+	RequestARBUpdateSubscription        = "ARBUpdateSubscriptionRequest"
+	RequestARBCancelSubscription        = "ARBCancelSubscriptionRequest"
+	RequestARBGetSubscriptionStatus     = "ARBGetSubscriptionStatusRequest"
+	RequestARBGetSubscription           = "ARBGetSubscriptionRequest"
+)
+
+// Valores de teste da sandbox que disparam recusas
+const (
+	DeclineZip       = "46282"
+	MismatchCardCode = "901"
+)
+
+// NonceDescriptor é o dataDescriptor dos nonces emitidos por Tokenize, o mesmo do Accept.js
+const NonceDescriptor = "COMMON.ACCEPT.INAPP.PAYMENT"
+
+// Textos dos response reason codes usados pelo simulador
+var reasonTexts = map[string]string{
+	"1":   "This transaction has been approved.",
+	"2":   "This transaction has been declined.",
+	"6":   "The credit card number is invalid.",
+	"8":   "The credit card has expired.",
+	"11":  "A duplicate transaction has been submitted.",
+	"16":  "The transaction cannot be found.",
+	"44":  "This transaction has been declined.",
+	"47":  "The amount requested for settlement cannot be greater than the original amount authorized.",
+	"54":  "The referenced transaction does not meet the criteria for issuing a credit.",
+	"55":  "The sum of credits against the referenced transaction would exceed the original debit amount.",
+	"310": "This transaction has already been voided.",
+}
+
+// Behavior descreve como o simulador deve responder a uma requisição roteirizada
+type Behavior struct {
+	// Delay atrasa a resposta; acima do RequestTimeout do client simula um timeout
+	Delay time.Duration
+	// StatusCode força um status HTTP de erro (ex.: 503) com corpo vazio
+	StatusCode int
+	// BOM prefixa a resposta com o byte order mark, como a API real
+	BOM bool
+	// Code/Text substituem messages.message[0] por um erro (ex.: E00027, E00039)
+	Code string
+	Text string
+	// ResponseCode/ReasonCode definem transactionResponse em createTransactionRequest
+	ResponseCode string
+	ReasonCode   string
+}
+
+// Decline roteiriza a recusa da próxima transação (E00027, responseCode 2)
+func Decline(reasonCode string) Behavior {
+	if reasonCode == "" {
+		reasonCode = "2"
+	}
+	return Behavior{
+		Code:         "E00027",
+		Text:         "The transaction was unsuccessful.",
+		ResponseCode: "2",
+		ReasonCode:   reasonCode,
+	}
+}
+
+// DuplicateProfile roteiriza um E00039 na próxima criação de perfil CIM. O perfil é
+// criado como se já existisse, para que a consulta seguinte do client o encontre.
+func DuplicateProfile() Behavior {
+	return Behavior{Code: "E00039"}
+}
+
+// Timeout roteiriza uma resposta atrasada
+func Timeout(delay time.Duration) Behavior {
+	return Behavior{Delay: delay}
+}
+
+// BOMPrefixed roteiriza uma resposta normal prefixada com BOM
+func BOMPrefixed() Behavior {
+	return Behavior{BOM: true}
+}
+
+type scriptedBehavior struct {
+	request  string
+	behavior Behavior
+}
+
+// Simulator é um http.Handler que responde ao dialeto JSON do request.api da
+// Authorize.net, mantendo transações, perfis CIM e assinaturas ARB em memória.
+// Pode ser embutido em testes (httptest.NewServer(sim)) ou servido pelo
+// cmd/authnet-simulator.
+type Simulator struct {
+	mutex sync.Mutex
+
+	apiLoginID     string
+	transactionKey string
+	bom            bool
+
+	nextID        int64
+	script        []scriptedBehavior
+	transactions  map[string]*transaction
+	profiles      map[string]*customerProfile
+	subscriptions map[string]*subscription
+	duplicates    map[string]duplicateEntry
+	nonces        map[string]*creditCard
+}
+
+// New cria um simulador vazio que aceita quaisquer credenciais
+func New() *Simulator {
+	return &Simulator{
+		nextID:        500000000,
+		transactions:  make(map[string]*transaction),
+		profiles:      make(map[string]*customerProfile),
+		subscriptions: make(map[string]*subscription),
+		duplicates:    make(map[string]duplicateEntry),
+		nonces:        make(map[string]*creditCard),
+	}
+}
+
+// RequireCredentials faz o simulador recusar (E00007) credenciais diferentes das informadas
+func (s *Simulator) RequireCredentials(apiLoginID, transactionKey string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.apiLoginID = apiLoginID
+	s.transactionKey = transactionKey
+}
+
+// SetBOM liga ou desliga o BOM em todas as respostas
+func (s *Simulator) SetBOM(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bom = enabled
+}
+
+// Enqueue roteiriza o comportamento da próxima requisição do tipo informado
+// (AnyRequest para qualquer tipo). Comportamentos são consumidos em ordem.
+func (s *Simulator) Enqueue(request string, behavior Behavior) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.script = append(s.script, scriptedBehavior{request: request, behavior: behavior})
+}
+
+// Tokenize faz o papel do Accept.js: guarda o cartão e devolve um nonce de uso
+// único (dataValue) para ser enviado como opaqueData com NonceDescriptor
+func (s *Simulator) Tokenize(cardNumber, expiry, cardCode string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value := fmt.Sprintf("sim-nonce-%s", s.newID())
+	s.nonces[value] = &creditCard{CardNumber: cardNumber, ExpirationDate: expiry, CardCode: cardCode}
+	return value
+}
+
+// Settle liquida as transações capturadas, permitindo estorná-las
+func (s *Simulator) Settle() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settled := 0
+	for _, tx := range s.transactions {
+		if tx.status == statusCaptured {
+			tx.status = statusSettled
+			settled++
+		}
+	}
+	return settled
+}
+
+// TransactionStatus retorna o status interno de uma transação
+func (s *Simulator) TransactionStatus(transID string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tx, ok := s.transactions[transID]
+	if !ok {
+		return "", false
+	}
+	return tx.status, true
+}
+
+// SubscriptionAmount retorna o valor e o status de uma assinatura
+func (s *Simulator) SubscriptionAmount(subscriptionID string) (float64, string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return 0, "", false
+	}
+	return sub.amount, sub.status, true
+}
+
+// SetSubscriptionStatus altera o status de uma assinatura fora da API (como uma suspensão
+// ou um cancelamento feito no painel da Authorize.net)
+func (s *Simulator) SetSubscriptionStatus(subscriptionID, status string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return false
+	}
+	sub.status = status
+	return true
+}
+
+func (s *Simulator) newID() string {
+	s.nextID++
+	return strconv.FormatInt(s.nextID, 10)
+}
+
+// nextBehavior remove e retorna o próximo comportamento roteirizado para a requisição
+func (s *Simulator) nextBehavior(request string) (Behavior, bool) {
+	for i, scripted := range s.script {
+		if scripted.request == AnyRequest || scripted.request == request {
+			s.script = append(s.script[:i], s.script[i+1:]...)
+			return scripted.behavior, true
+		}
+	}
+	return Behavior{}, false
+}
+
+// ServeHTTP atende POST /xml/v1/request.api (qualquer caminho é aceito)
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(string(body), "\ufeff")), &envelope); err != nil || len(envelope) != 1 {
+		s.write(w, false, errorResponse("E00003", "The request could not be parsed as JSON."))
+		return
+	}
+
+	var requestName string
+	var payload json.RawMessage
+	for name, raw := range envelope {
+		requestName, payload = name, raw
+	}
+
+	s.mutex.Lock()
+	behavior, scripted := s.nextBehavior(requestName)
+	bom := s.bom || behavior.BOM
+	s.mutex.Unlock()
+
+	if scripted && behavior.Delay > 0 {
+		select {
+		case <-time.After(behavior.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if scripted && behavior.StatusCode != 0 {
+		w.WriteHeader(behavior.StatusCode)
+		return
+	}
+
+	var forced *Behavior
+	if scripted && (behavior.Code != "" || behavior.ResponseCode != "") {
+		forced = &behavior
+	}
+
+	s.mutex.Lock()
+	response := s.dispatch(requestName, payload, forced)
+	s.mutex.Unlock()
+
+	log.Printf("[authnet simulator] %s -> %s", requestName, resultSummary(response))
+	s.write(w, bom, response)
+}
+
+func (s *Simulator) dispatch(requestName string, payload json.RawMessage, forced *Behavior) interface{} {
+	var auth struct {
+		MerchantAuthentication struct {
+			Name           string `json:"name"`
+			TransactionKey string `json:"transactionKey"`
+		} `json:"merchantAuthentication"`
+	}
+	if err := json.Unmarshal(payload, &auth); err != nil {
+		return errorResponse("E00003", fmt.Sprintf("Invalid %s: %v", requestName, err))
+	}
+
+	if s.apiLoginID != "" && (auth.MerchantAuthentication.Name != s.apiLoginID ||
+		auth.MerchantAuthentication.TransactionKey != s.transactionKey) {
+		return errorResponse("E00007", "User authentication failed due to invalid authentication values.")
+	}
+
+	switch requestName {
+	case RequestCreateTransaction:
+		return s.createTransaction(payload, forced)
+	case RequestCreateCustomerProfile:
+		return s.createCustomerProfile(payload, forced)
+	case RequestGetCustomerProfile:
+		return s.getCustomerProfile(payload, forced)
+	case RequestCreateCustomerPaymentProfile:
+		return s.createCustomerPaymentProfile(payload, forced)
+	case RequestUpdateCustomerPaymentProfile:
+		return s.updateCustomerPaymentProfile(payload, forced)
+	case RequestARBCreateSubscription:
+		return s.createSubscription(payload, forced)
+	case RequestARBUpdateSubscription:
+		return s.updateSubscription(payload, forced)
+	case RequestARBCancelSubscription:
+		return s.cancelSubscription(payload, forced)
+	case RequestARBGetSubscriptionStatus:
+		return s.getSubscriptionStatus(payload, forced)
+	case RequestARBGetSubscription:
+		return s.getSubscription(payload, forced)
+	default:
+		return errorResponse("E00044", fmt.Sprintf("Request %s is not supported by the simulator.", requestName))
+	}
+}
+
+func (s *Simulator) write(w http.ResponseWriter, bom bool, response interface{}) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if bom {
+		w.Write([]byte("\ufeff"))
+	}
+	w.Write(data)
+}
+
+func resultSummary(response interface{}) string {
+	if r, ok := response.(map[string]interface{}); ok {
+		if m, ok := r["messages"].(messages); ok && len(m.Message) > 0 {
+			return m.ResultCode + " " + m.Message[0].Code
+		}
+	}
+	return "Ok"
+}
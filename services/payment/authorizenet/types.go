@@ -21,25 +21,42 @@ type merchantAuthenticationType struct {
 type CreditCardType struct {
     CardNumber     string `json:"cardNumber"`
     ExpirationDate string `json:"expirationDate"`
-    CardCode       string `json:"cardCode"`
+    CardCode       string `json:"cardCode,omitempty"`
 }
 
+// OpaqueDataType é o nonce gerado pelo Accept.js (dataDescriptor/dataValue)
+type OpaqueDataType struct {
+    DataDescriptor string `json:"dataDescriptor"`
+    DataValue      string `json:"dataValue"`
+}
+
+// PaymentType leva o cartão OU o nonce do Accept.js, nunca os dois
 type PaymentType struct {
-    CreditCard CreditCardType `json:"creditCard"`
+    CreditCard *CreditCardType `json:"creditCard,omitempty"`
+    OpaqueData *OpaqueDataType `json:"opaqueData,omitempty"`
 }
 
 type transactionRequestType struct {
     TransactionType     string                    `json:"transactionType"`
     Amount             string                    `json:"amount,omitempty"`
+    CurrencyCode       string                    `json:"currencyCode,omitempty"` // vazio = moeda da conta do gateway
     Payment            *PaymentType              `json:"payment,omitempty"`
     Profile            *ProfileTransactionType   `json:"profile,omitempty"`
     RefTransId         string                    `json:"refTransId,omitempty"`
     Order              *OrderType                `json:"order,omitempty"`
+    Tax                *ExtendedAmountType       `json:"tax,omitempty"` // a ordem dos campos segue o schema da API
     Customer           *CustomerType             `json:"customer,omitempty"`
     BillTo             *types.BillingInfoType    `json:"billTo,omitempty"`
+    CardholderAuthentication *CardholderAuthenticationType `json:"cardholderAuthentication,omitempty"`
     TransactionSettings *TransactionSettingsType `json:"transactionSettings,omitempty"`
 }
 
+// CardholderAuthenticationType leva o resultado do 3D Secure (ECI e CAVV)
+type CardholderAuthenticationType struct {
+    AuthenticationIndicator       string `json:"authenticationIndicator"`
+    CardholderAuthenticationValue string `json:"cardholderAuthenticationValue"`
+}
+
 type TransactionSettingsType struct {
     Settings []SettingType `json:"setting,omitempty"`
 }
@@ -73,15 +90,25 @@ type transactionResponse struct {
     CVVResultCode string        `json:"cvvResultCode"`
     TransID       string        `json:"transId"`
     RefTransID    string        `json:"refTransId"`
+    AccountNumber string        `json:"accountNumber,omitempty"` // mascarado, ex.: XXXX1111
+    AccountType   string        `json:"accountType,omitempty"`
     Messages      []MessageType `json:"messages,omitempty"`
     Errors        []ErrorType  `json:"errors,omitempty"`
 }
 
 type createTransactionResponse struct {
-    TransactionResponse transactionResponse `json:"transactionResponse"`
+    TransactionResponse transactionResponse          `json:"transactionResponse"`
+    ProfileResponse     *transactionProfileResponse `json:"profileResponse,omitempty"`
     Messages          MessagesType        `json:"messages"`
 }
 
+// transactionProfileResponse é o resultado de profile.createProfile em uma transação
+type transactionProfileResponse struct {
+    Messages                     MessagesType `json:"messages"`
+    CustomerProfileID            string       `json:"customerProfileId,omitempty"`
+    CustomerPaymentProfileIDList []string     `json:"customerPaymentProfileIdList,omitempty"`
+}
+
 // ARB Types (Original - usando dados de cartão diretos)
 type ARBSubscriptionRequest struct {
     MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
@@ -121,6 +148,13 @@ type OrderType struct {
     Description   string `json:"description"`
 }
 
+// ExtendedAmountType detalha uma parte do valor da transação (ex.: o imposto)
+type ExtendedAmountType struct {
+    Amount      string `json:"amount"`
+    Name        string `json:"name,omitempty"`
+    Description string `json:"description,omitempty"`
+}
+
 type CustomerAddressType struct {
     FirstName string `json:"firstName"`
     LastName  string `json:"lastName"`
@@ -236,7 +270,8 @@ type UpdateCustomerPaymentProfileResponse struct {
 }
 
 type ProfileTransactionType struct {
-    CustomerProfileID string              `json:"customerProfileId"`
+    CreateProfile     bool                `json:"createProfile,omitempty"` // cria o perfil a partir do payment da transação
+    CustomerProfileID string              `json:"customerProfileId,omitempty"`
     PaymentProfile    *PaymentProfileType `json:"paymentProfile,omitempty"`
 }
 
@@ -258,6 +293,13 @@ type ARBUpdateSubscriptionType struct {
     Amount string `json:"amount,omitempty"`
 }
 
+// Types for cancelling ARB subscription
+type ARBCancelSubscriptionRequest struct {
+    MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
+    RefID                 string                    `json:"refId,omitempty"`
+    SubscriptionID        string                    `json:"subscriptionId"`
+}
+
 // Types for creating new payment profiles
 type CreateCustomerPaymentProfileRequest struct {
     MerchantAuthentication merchantAuthenticationType `json:"merchantAuthentication"`
@@ -0,0 +1,196 @@
+// authorizenet/webhooks.go
+package authorizenet
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+)
+
+const (
+    SandboxWebhooksEndpoint    = "https://apitest.authorize.net/rest/v1/webhooks"
+    ProductionWebhooksEndpoint = "https://api.authorize.net/rest/v1/webhooks"
+)
+
+// Tipos de evento da Webhooks API tratados pela aplicação
+const (
+    EventAuthCaptureCreated       = "net.authorize.payment.authcapture.created"
+    EventAuthorizationCreated     = "net.authorize.payment.authorization.created"
+    EventCaptureCreated           = "net.authorize.payment.capture.created"
+    EventPriorAuthCaptureCreated  = "net.authorize.payment.priorAuthCapture.created"
+    EventRefundCreated            = "net.authorize.payment.refund.created"
+    EventVoidCreated              = "net.authorize.payment.void.created"
+    EventFraudHeld                = "net.authorize.payment.fraud.held"
+    EventFraudApproved            = "net.authorize.payment.fraud.approved"
+    EventFraudDeclined            = "net.authorize.payment.fraud.declined"
+    EventSubscriptionCreated      = "net.authorize.customer.subscription.created"
+    EventSubscriptionUpdated      = "net.authorize.customer.subscription.updated"
+    EventSubscriptionSuspended    = "net.authorize.customer.subscription.suspended"
+    EventSubscriptionTerminated   = "net.authorize.customer.subscription.terminated"
+    EventSubscriptionCancelled    = "net.authorize.customer.subscription.cancelled"
+    EventSubscriptionExpiring     = "net.authorize.customer.subscription.expiring"
+    EventSubscriptionExpired      = "net.authorize.customer.subscription.expired"
+    EventSubscriptionFailed       = "net.authorize.customer.subscription.failed"
+)
+
+// DefaultWebhookEventTypes é a lista registrada por padrão pelo comando de gerenciamento
+var DefaultWebhookEventTypes = []string{
+    EventAuthCaptureCreated,
+    EventAuthorizationCreated,
+    EventCaptureCreated,
+    EventPriorAuthCaptureCreated,
+    EventRefundCreated,
+    EventVoidCreated,
+    EventFraudHeld,
+    EventFraudApproved,
+    EventFraudDeclined,
+    EventSubscriptionCreated,
+    EventSubscriptionUpdated,
+    EventSubscriptionSuspended,
+    EventSubscriptionTerminated,
+    EventSubscriptionCancelled,
+    EventSubscriptionExpiring,
+    EventSubscriptionExpired,
+    EventSubscriptionFailed,
+}
+
+// WebhookEvent é o envelope JSON enviado pela Webhooks API
+type WebhookEvent struct {
+    NotificationID string              `json:"notificationId"`
+    EventType      string              `json:"eventType"`
+    EventDate      string              `json:"eventDate"`
+    WebhookID      string              `json:"webhookId"`
+    Payload        WebhookEventPayload `json:"payload"`
+}
+
+// WebhookEventPayload cobre os campos usados dos payloads de transação e de assinatura
+type WebhookEventPayload struct {
+    EntityName          string              `json:"entityName"`
+    ID                  string              `json:"id"`
+    ResponseCode        int                 `json:"responseCode,omitempty"`
+    AuthCode            string              `json:"authCode,omitempty"`
+    AVSResponse         string              `json:"avsResponse,omitempty"`
+    AuthAmount          float64             `json:"authAmount,omitempty"`
+    InvoiceNumber       string              `json:"invoiceNumber,omitempty"`
+    MerchantReferenceID string              `json:"merchantReferenceId,omitempty"`
+    Name                string              `json:"name,omitempty"`
+    Amount              float64             `json:"amount,omitempty"`
+    Status              string              `json:"status,omitempty"`
+    FraudList           []WebhookFraudEntry `json:"fraudList,omitempty"`
+}
+
+type WebhookFraudEntry struct {
+    FraudFilter string `json:"fraudFilter"`
+    FraudAction string `json:"fraudAction"`
+}
+
+// Webhook representa uma assinatura de webhook registrada na Authorize.net
+type Webhook struct {
+    WebhookID  string   `json:"webhookId,omitempty"`
+    Name       string   `json:"name,omitempty"`
+    URL        string   `json:"url"`
+    EventTypes []string `json:"eventTypes"`
+    Status     string   `json:"status"`
+}
+
+func (c *Client) getWebhooksEndpoint() string {
+    if c.environment == "production" {
+        return ProductionWebhooksEndpoint
+    }
+    return SandboxWebhooksEndpoint
+}
+
+// doWebhooksRequest executa uma chamada autenticada (Basic auth) na REST API de webhooks
+func (c *Client) doWebhooksRequest(method, url string, payload interface{}) ([]byte, error) {
+    var body io.Reader
+    if payload != nil {
+        jsonPayload, err := json.Marshal(payload)
+        if err != nil {
+            return nil, fmt.Errorf("error marshaling webhooks request: %v", err)
+        }
+        body = bytes.NewBuffer(jsonPayload)
+    }
+
+    ctx, cancel := c.createRequestContext()
+    defer cancel()
+
+    httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+    if err != nil {
+        return nil, fmt.Errorf("error creating webhooks request: %v", err)
+    }
+
+    httpReq.SetBasicAuth(c.apiLoginID, c.transactionKey)
+    httpReq.Header.Set("Content-Type", "application/json")
+
+    c.mutex.Lock()
+    resp, err := c.client.Do(httpReq)
+    c.mutex.Unlock()
+
+    if err != nil {
+        return nil, fmt.Errorf("error making webhooks request: %v", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, fmt.Errorf("error reading webhooks response: %v", err)
+    }
+
+    cleanBody := []byte(strings.TrimPrefix(string(respBody), "\ufeff"))
+
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return nil, fmt.Errorf("webhooks request failed with status %d: %s", resp.StatusCode, string(cleanBody))
+    }
+
+    return cleanBody, nil
+}
+
+// ListWebhooks retorna os webhooks registrados para a conta
+func (c *Client) ListWebhooks() ([]Webhook, error) {
+    respBody, err := c.doWebhooksRequest("GET", c.getWebhooksEndpoint(), nil)
+    if err != nil {
+        return nil, err
+    }
+
+    var webhooks []Webhook
+    if err := json.Unmarshal(respBody, &webhooks); err != nil {
+        return nil, fmt.Errorf("error decoding webhooks list: %v", err)
+    }
+
+    return webhooks, nil
+}
+
+// CreateWebhook registra um novo webhook apontando para a URL informada
+func (c *Client) CreateWebhook(name, url string, eventTypes []string) (*Webhook, error) {
+    log.Printf("Registering Authorize.net webhook %s for %d event types", url, len(eventTypes))
+
+    request := Webhook{
+        Name:       name,
+        URL:        url,
+        EventTypes: eventTypes,
+        Status:     "active",
+    }
+
+    respBody, err := c.doWebhooksRequest("POST", c.getWebhooksEndpoint(), request)
+    if err != nil {
+        return nil, err
+    }
+
+    var webhook Webhook
+    if err := json.Unmarshal(respBody, &webhook); err != nil {
+        return nil, fmt.Errorf("error decoding created webhook: %v", err)
+    }
+
+    log.Printf("Successfully registered webhook %s", webhook.WebhookID)
+    return &webhook, nil
+}
+
+// DeleteWebhook remove um webhook registrado
+func (c *Client) DeleteWebhook(webhookID string) error {
+    _, err := c.doWebhooksRequest("DELETE", c.getWebhooksEndpoint()+"/"+webhookID, nil)
+    return err
+}
@@ -0,0 +1,1036 @@
+// services/payment/fake/gateway.go - Gateway de pagamento em memória que imita a Authorize.net
+package fake
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prosecure-payment-api/models"
+	"prosecure-payment-api/services/payment"
+	"prosecure-payment-api/services/payment/authorizenet"
+)
+
+var (
+	_ payment.PaymentGateway       = (*Gateway)(nil)
+	_ payment.SettlementReporter   = (*Gateway)(nil)
+	_ payment.SubscriptionReporter = (*Gateway)(nil)
+)
+
+// Códigos de motivo (response reason codes) da Authorize.net reproduzidos pelo fake
+const (
+	ReasonApproved            = "1"
+	ReasonDeclined            = "2"
+	ReasonInvalidCardNumber   = "6"
+	ReasonExpiredCard         = "8"
+	ReasonTransactionNotFound = "16"
+	ReasonCardCodeMismatch    = "44"
+	ReasonCaptureExceedsAuth  = "47"
+	ReasonCreditCriteria      = "54"
+	ReasonCreditExceedsDebit  = "55"
+)
+
+// Valores de teste da sandbox da Authorize.net que disparam recusas
+const (
+	DeclineZip       = "46282" // CEP de cobrança que sempre recusa
+	MismatchCardCode = "901"   // CVV que retorna "N" (não confere)
+)
+
+// Status internos das transações do fake
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusSettled    = "settled"
+	StatusVoided     = "voided"
+	StatusRefund     = "refund"
+	StatusDeclined   = "declined"
+)
+
+// Decline é uma recusa forçada para um número de cartão
+type Decline struct {
+	ReasonCode string
+	Text       string
+}
+
+var declineTexts = map[string]string{
+	ReasonDeclined:            "This transaction has been declined.",
+	ReasonInvalidCardNumber:   "The credit card number is invalid.",
+	ReasonExpiredCard:         "The credit card has expired.",
+	ReasonTransactionNotFound: "The transaction cannot be found.",
+	ReasonCardCodeMismatch:    "This transaction has been declined.",
+	ReasonCaptureExceedsAuth:  "The amount requested for settlement cannot be greater than the original amount authorized.",
+	ReasonCreditCriteria:      "The referenced transaction does not meet the criteria for issuing a credit.",
+	ReasonCreditExceedsDebit:  "The sum of credits against the referenced transaction would exceed the original debit amount.",
+}
+
+// Transaction é uma transação registrada no fake
+type Transaction struct {
+	ID             string
+	Type           string
+	Status         string
+	Amount         float64
+	Tax            float64 // parte de Amount que é imposto
+	Currency       string
+	RefundedAmount float64
+	CardNumber     string
+	RefTransID     string
+	CheckoutID     string
+	BatchID        string
+	SubmittedAt    time.Time
+}
+
+type paymentProfile struct {
+	id         string
+	cardNumber string
+	expiry     string
+	zip        string
+}
+
+type customerProfile struct {
+	id                 string
+	merchantCustomerID string
+	email              string
+	paymentProfiles    []*paymentProfile
+}
+
+// Subscription é uma assinatura ARB registrada no fake
+type Subscription struct {
+	ID                string
+	CustomerProfileID string
+	PaymentProfileID  string
+	Amount            float64
+	IntervalMonths    int
+	StartDate         string
+	Status            string
+}
+
+type settledBatch struct {
+	id           string
+	settledAt    time.Time
+	transactions []string
+}
+
+// nonceCard é o cartão por trás de um nonce gerado por Tokenize
+type nonceCard struct {
+	cardNumber string
+	expiry     string
+	cvv        string
+}
+
+type duplicateEntry struct {
+	transactionID string
+	at            time.Time
+	decline       *Decline // recusa da transação original, repetida na duplicata
+}
+
+// Gateway implementa payment.PaymentGateway (e payment.SettlementReporter e
+// payment.SubscriptionReporter) em memória.
+// Reproduz as regras da sandbox da Authorize.net: recusas por CEP 46282, CVV 901,
+// cartão inválido ou vencido; a janela de duplicidade de transações; e o erro E00039
+// de perfil/perfil de pagamento duplicado no CIM.
+type Gateway struct {
+	mutex sync.Mutex
+
+	// DuplicateWindow é a janela em que autorizações idênticas são tratadas como duplicadas
+	DuplicateWindow time.Duration
+
+	nextID        int64
+	declines      map[string]Decline
+	transactions  map[string]*Transaction
+	profiles      map[string]*customerProfile
+	subscriptions map[string]*Subscription
+	duplicates    map[string]duplicateEntry
+	nonces        map[string]nonceCard
+	batches       []*settledBatch
+}
+
+// NewGateway cria um gateway em memória vazio
+func NewGateway() *Gateway {
+	return &Gateway{
+		DuplicateWindow: authorizenet.DuplicateWindow * time.Second,
+		nextID:          40000000000,
+		declines:        make(map[string]Decline),
+		transactions:    make(map[string]*Transaction),
+		profiles:        make(map[string]*customerProfile),
+		subscriptions:   make(map[string]*Subscription),
+		duplicates:      make(map[string]duplicateEntry),
+		nonces:          make(map[string]nonceCard),
+	}
+}
+
+// Tokenize faz o papel do Accept.js: troca um cartão por um nonce de uso único
+func (g *Gateway) Tokenize(cardNumber, expiry, cvv string) *models.OpaqueData {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	value := fmt.Sprintf("fake-nonce-%s", g.newID())
+	g.nonces[value] = nonceCard{cardNumber: cardNumber, expiry: expiry, cvv: cvv}
+
+	return &models.OpaqueData{
+		DataDescriptor: "COMMON.ACCEPT.INAPP.PAYMENT",
+		DataValue:      value,
+	}
+}
+
+// consumeNonce resolve e invalida um nonce; a Authorize.net recusa nonces reutilizados
+// ou desconhecidos com E00114 (Invalid OTS Token)
+func (g *Gateway) consumeNonce(opaque *models.OpaqueData) (nonceCard, error) {
+	card, ok := g.nonces[opaque.DataValue]
+	if !ok {
+		return nonceCard{}, fmt.Errorf("Invalid OTS Token. (Code: E00114)")
+	}
+	delete(g.nonces, opaque.DataValue)
+	return card, nil
+}
+
+// paymentCard resolve o cartão de um PaymentRequest: o nonce (consumido e trocado pelo
+// número mascarado), o perfil criado a partir de um nonce ou os dados do cartão
+func (g *Gateway) paymentCard(req *models.PaymentRequest) (nonceCard, error) {
+	if req.HasOpaqueData() {
+		card, err := g.consumeNonce(req.OpaqueData)
+		req.OpaqueData = nil
+		if err == nil {
+			req.AccountNumber = "XXXX" + lastFour(card.cardNumber)
+		}
+		return card, err
+	}
+	if req.HasStoredProfile() {
+		pp := g.findPaymentProfile(req.CustomerProfileID, req.PaymentProfileID)
+		if pp == nil {
+			return nonceCard{}, fmt.Errorf("The record cannot be found.")
+		}
+		return nonceCard{cardNumber: pp.cardNumber, expiry: pp.expiry}, nil
+	}
+	return nonceCard{cardNumber: req.CardNumber, expiry: req.Expiry, cvv: req.CVV}, nil
+}
+
+// DeclineCard força a recusa de todas as transações com o número de cartão informado
+func (g *Gateway) DeclineCard(cardNumber, reasonCode string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	text, ok := declineTexts[reasonCode]
+	if !ok {
+		text = declineTexts[ReasonDeclined]
+	}
+	g.declines[cardNumber] = Decline{ReasonCode: reasonCode, Text: text}
+}
+
+// Transaction retorna uma cópia da transação registrada
+func (g *Gateway) Transaction(transactionID string) (Transaction, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok {
+		return Transaction{}, false
+	}
+	return *tx, true
+}
+
+// Subscription retorna uma cópia da assinatura registrada
+func (g *Gateway) Subscription(subscriptionID string) (Subscription, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionID]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *sub, true
+}
+
+// Settle liquida todas as transações capturadas em um novo lote, como o fechamento
+// diário da Authorize.net. Retorna o ID do lote (vazio se não havia nada a liquidar).
+func (g *Gateway) Settle() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	batch := &settledBatch{id: g.newID(), settledAt: time.Now().UTC()}
+	for _, tx := range g.transactions {
+		if tx.Status == StatusCaptured || (tx.Status == StatusRefund && tx.BatchID == "") {
+			if tx.Status == StatusCaptured {
+				tx.Status = StatusSettled
+			}
+			tx.BatchID = batch.id
+			batch.transactions = append(batch.transactions, tx.ID)
+		}
+	}
+
+	if len(batch.transactions) == 0 {
+		return ""
+	}
+
+	g.batches = append(g.batches, batch)
+	return batch.id
+}
+
+func (g *Gateway) newID() string {
+	g.nextID++
+	return strconv.FormatInt(g.nextID, 10)
+}
+
+// checkCard aplica as regras de recusa da sandbox a um cartão
+func (g *Gateway) checkCard(cardNumber, expiry, cvv, zip string) *Decline {
+	if decline, ok := g.declines[cardNumber]; ok {
+		return &decline
+	}
+
+	if !luhnValid(cardNumber) {
+		return &Decline{ReasonInvalidCardNumber, declineTexts[ReasonInvalidCardNumber]}
+	}
+
+	if expiry != "XXXX" && !expiryValid(expiry) {
+		return &Decline{ReasonExpiredCard, declineTexts[ReasonExpiredCard]}
+	}
+
+	if zip == DeclineZip {
+		return &Decline{ReasonDeclined, declineTexts[ReasonDeclined]}
+	}
+
+	if cvv == MismatchCardCode {
+		return &Decline{ReasonCardCodeMismatch, declineTexts[ReasonCardCodeMismatch]}
+	}
+
+	return nil
+}
+
+// ProcessPayment autoriza $1.00 (authOnlyTransaction), como o authorizenet.Client
+func (g *Gateway) ProcessPayment(req *models.PaymentRequest) (*models.TransactionResponse, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	const amount = 1.00
+
+	zip := ""
+	if req.BillingInfo != nil {
+		zip = req.BillingInfo.Zip
+	}
+
+	// Com nonce, a autorização também cria o customer profile (profile.createProfile)
+	createProfile := req.HasOpaqueData()
+	card, err := g.paymentCard(req)
+	if err != nil {
+		return &models.TransactionResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	// Janela de duplicidade: mesmo cartão, valor e checkout dentro da janela
+	duplicateKey := fmt.Sprintf("%s|%.2f|%s", card.cardNumber, amount, req.CheckoutID)
+	if entry, ok := g.duplicates[duplicateKey]; ok && time.Since(entry.at) < g.DuplicateWindow {
+		log.Printf("[fake gateway] Duplicate transaction within window, original: %s", entry.transactionID)
+		// A duplicata repete a resposta original: um cartão recusado continua recusado
+		if entry.decline != nil {
+			return &models.TransactionResponse{
+				Success: false,
+				Message: entry.decline.Text,
+			}, nil
+		}
+		return &models.TransactionResponse{
+			Success:       true,
+			TransactionID: entry.transactionID,
+			Message:       "Transaction previously processed",
+			IsDuplicate:   true,
+		}, nil
+	}
+
+	tx := &Transaction{
+		ID:          g.newID(),
+		Type:        "authOnlyTransaction",
+		Amount:      amount,
+		Currency:    currencyOrDefault(req.Currency),
+		CardNumber:  card.cardNumber,
+		CheckoutID:  req.CheckoutID,
+		SubmittedAt: time.Now().UTC(),
+	}
+	g.transactions[tx.ID] = tx
+
+	decline := g.checkCard(card.cardNumber, card.expiry, card.cvv, zip)
+	g.duplicates[duplicateKey] = duplicateEntry{transactionID: tx.ID, at: time.Now(), decline: decline}
+
+	if decline != nil {
+		tx.Status = StatusDeclined
+		log.Printf("[fake gateway] Transaction %s declined: reason %s", tx.ID, decline.ReasonCode)
+		return &models.TransactionResponse{
+			Success: false,
+			Message: decline.Text,
+		}, nil
+	}
+
+	tx.Status = StatusAuthorized
+	resp := &models.TransactionResponse{
+		Success:       true,
+		TransactionID: tx.ID,
+		Message:       "This transaction has been approved.",
+		AccountNumber: "XXXX" + lastFour(card.cardNumber),
+	}
+
+	if createProfile {
+		profile := g.newProfile(req.CheckoutID, req.CustomerEmail, card, zip)
+		req.CustomerProfileID = profile.id
+		req.PaymentProfileID = profile.paymentProfiles[0].id
+		resp.CustomerProfileID = req.CustomerProfileID
+		resp.PaymentProfileID = req.PaymentProfileID
+	}
+
+	return resp, nil
+}
+
+// newProfile registra um perfil CIM com um único perfil de pagamento
+func (g *Gateway) newProfile(merchantCustomerID, email string, card nonceCard, zip string) *customerProfile {
+	if len(merchantCustomerID) > 20 {
+		merchantCustomerID = merchantCustomerID[:20]
+	}
+
+	profile := &customerProfile{
+		id:                 g.newID(),
+		merchantCustomerID: merchantCustomerID,
+		email:              email,
+	}
+	profile.paymentProfiles = append(profile.paymentProfiles, &paymentProfile{
+		id:         g.newID(),
+		cardNumber: card.cardNumber,
+		expiry:     card.expiry,
+		zip:        zip,
+	})
+	g.profiles[profile.id] = profile
+
+	return profile
+}
+
+// CaptureTransaction captura uma autorização (priorAuthCaptureTransaction)
+func (g *Gateway) CaptureTransaction(transactionID string, amount float64) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok || tx.Status != StatusAuthorized {
+		return fmt.Errorf("capture transaction failed: %s", declineTexts[ReasonTransactionNotFound])
+	}
+
+	if amount > 0 {
+		if toCents(amount) > toCents(tx.Amount) {
+			return fmt.Errorf("capture transaction failed: %s", declineTexts[ReasonCaptureExceedsAuth])
+		}
+		tx.Amount = amount
+	}
+
+	tx.Status = StatusCaptured
+	return nil
+}
+
+// VoidTransaction anula uma transação ainda não liquidada
+func (g *Gateway) VoidTransaction(transactionID string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok {
+		return fmt.Errorf("void transaction failed: %s", declineTexts[ReasonTransactionNotFound])
+	}
+
+	switch tx.Status {
+	case StatusAuthorized, StatusCaptured, StatusVoided:
+		// Anular uma transação já anulada é aceito pela Authorize.net
+		tx.Status = StatusVoided
+		return nil
+	default:
+		return fmt.Errorf("void transaction failed: %s", declineTexts[ReasonTransactionNotFound])
+	}
+}
+
+// RefundTransaction estorna uma transação liquidada (refundTransaction)
+func (g *Gateway) RefundTransaction(transactionID string, amount float64, cardLastFour string) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	original, ok := g.transactions[transactionID]
+	if !ok {
+		return "", fmt.Errorf("refund failed: %s", declineTexts[ReasonTransactionNotFound])
+	}
+
+	if original.Status != StatusSettled {
+		return "", fmt.Errorf("refund failed: %s", declineTexts[ReasonCreditCriteria])
+	}
+
+	if !strings.HasSuffix(original.CardNumber, cardLastFour) {
+		return "", fmt.Errorf("refund failed: %s", declineTexts[ReasonCreditCriteria])
+	}
+
+	if toCents(original.RefundedAmount+amount) > toCents(original.Amount) {
+		return "", fmt.Errorf("refund failed: %s", declineTexts[ReasonCreditExceedsDebit])
+	}
+
+	original.RefundedAmount += amount
+
+	refund := &Transaction{
+		ID:          g.newID(),
+		Type:        "refundTransaction",
+		Status:      StatusRefund,
+		Amount:      amount,
+		CardNumber:  original.CardNumber,
+		RefTransID:  original.ID,
+		SubmittedAt: time.Now().UTC(),
+	}
+	g.transactions[refund.ID] = refund
+
+	return refund.ID, nil
+}
+
+// CreateCustomerProfile cria um perfil CIM com um perfil de pagamento. Assim como o
+// authorizenet.Client, um E00039 (perfil duplicado) é resolvido retornando o perfil existente.
+func (g *Gateway) CreateCustomerProfile(payment *models.PaymentRequest, checkout *models.CheckoutData) (string, string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Nonce já convertido em perfil na autorização
+	if payment.HasStoredProfile() {
+		return payment.CustomerProfileID, payment.PaymentProfileID, nil
+	}
+
+	fromNonce := payment.HasOpaqueData()
+	card, err := g.paymentCard(payment)
+	if err != nil {
+		return "", "", fmt.Errorf("customer profile creation failed: %v", err)
+	}
+
+	// validationMode=testMode: só valida o formato do cartão, sem autorização
+	if !luhnValid(card.cardNumber) || !expiryValid(card.expiry) {
+		return "", "", fmt.Errorf("customer profile creation failed: %s", declineTexts[ReasonInvalidCardNumber])
+	}
+
+	merchantCustomerID := payment.CheckoutID
+	if len(merchantCustomerID) > 20 {
+		merchantCustomerID = merchantCustomerID[:20]
+	}
+
+	for _, profile := range g.profiles {
+		if profile.merchantCustomerID == merchantCustomerID && profile.email == checkout.Email {
+			log.Printf("[fake gateway] E00039: A duplicate record with ID %s already exists.", profile.id)
+			paymentProfileID := ""
+			if len(profile.paymentProfiles) > 0 {
+				paymentProfileID = profile.paymentProfiles[0].id
+			}
+			return profile.id, paymentProfileID, nil
+		}
+	}
+
+	profile := g.newProfile(merchantCustomerID, checkout.Email, card, checkout.ZipCode)
+	pp := profile.paymentProfiles[0]
+
+	if fromNonce {
+		payment.CustomerProfileID = profile.id
+		payment.PaymentProfileID = pp.id
+	}
+
+	return profile.id, pp.id, nil
+}
+
+// CreateCustomerPaymentProfile adiciona um perfil de pagamento a um perfil CIM existente
+func (g *Gateway) CreateCustomerPaymentProfile(customerProfileID string, payment *models.PaymentRequest, checkout *models.CheckoutData) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	profile, ok := g.profiles[customerProfileID]
+	if !ok {
+		return "", fmt.Errorf("create payment profile failed: The record cannot be found.")
+	}
+
+	if payment.HasStoredProfile() && !payment.HasOpaqueData() {
+		return "", authorizenet.ErrNonceConsumed
+	}
+
+	card, err := g.paymentCard(payment)
+	if err != nil {
+		return "", fmt.Errorf("create payment profile failed: %v", err)
+	}
+
+	if !luhnValid(card.cardNumber) || !expiryValid(card.expiry) {
+		return "", fmt.Errorf("create payment profile failed: %s", declineTexts[ReasonInvalidCardNumber])
+	}
+
+	for _, pp := range profile.paymentProfiles {
+		if pp.cardNumber == card.cardNumber {
+			return "", fmt.Errorf("create payment profile failed: A duplicate customer payment profile already exists.")
+		}
+	}
+
+	pp := &paymentProfile{
+		id:         g.newID(),
+		cardNumber: card.cardNumber,
+		expiry:     card.expiry,
+		zip:        checkout.ZipCode,
+	}
+	profile.paymentProfiles = append(profile.paymentProfiles, pp)
+
+	return pp.id, nil
+}
+
+// UpdateCustomerPaymentProfile troca o cartão de um perfil de pagamento
+func (g *Gateway) UpdateCustomerPaymentProfile(customerProfileID, paymentProfileID string, payment *models.PaymentRequest, checkout *models.CheckoutData) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	pp := g.findPaymentProfile(customerProfileID, paymentProfileID)
+	if pp == nil {
+		return fmt.Errorf("update payment profile failed: The record cannot be found.")
+	}
+
+	if payment.HasStoredProfile() && !payment.HasOpaqueData() {
+		return authorizenet.ErrNonceConsumed
+	}
+
+	card, err := g.paymentCard(payment)
+	if err != nil {
+		return fmt.Errorf("update payment profile failed: %v", err)
+	}
+
+	if !luhnValid(card.cardNumber) || !expiryValid(card.expiry) {
+		return fmt.Errorf("update payment profile failed: %s", declineTexts[ReasonInvalidCardNumber])
+	}
+
+	pp.cardNumber = card.cardNumber
+	pp.expiry = card.expiry
+	pp.zip = checkout.ZipCode
+	return nil
+}
+
+func (g *Gateway) findPaymentProfile(customerProfileID, paymentProfileID string) *paymentProfile {
+	profile, ok := g.profiles[customerProfileID]
+	if !ok {
+		return nil
+	}
+
+	for _, pp := range profile.paymentProfiles {
+		if pp.id == paymentProfileID {
+			return pp
+		}
+	}
+	return nil
+}
+
+// ChargeCustomerProfile cobra (authCaptureTransaction) um perfil de pagamento
+func (g *Gateway) ChargeCustomerProfile(customerProfileID, paymentProfileID string, amount, tax float64, currency, cvv string) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	pp := g.findPaymentProfile(customerProfileID, paymentProfileID)
+	if pp == nil {
+		return "", fmt.Errorf("charge failed: The record cannot be found.")
+	}
+
+	tx := &Transaction{
+		ID:          g.newID(),
+		Type:        "authCaptureTransaction",
+		Amount:      amount,
+		Tax:         tax,
+		Currency:    currencyOrDefault(currency),
+		CardNumber:  pp.cardNumber,
+		SubmittedAt: time.Now().UTC(),
+	}
+	g.transactions[tx.ID] = tx
+
+	if decline := g.checkCard(pp.cardNumber, pp.expiry, cvv, pp.zip); decline != nil {
+		tx.Status = StatusDeclined
+		if decline.ReasonCode == ReasonCardCodeMismatch {
+			return "", fmt.Errorf("CVV verification failed: %s", decline.Text)
+		}
+		return "", fmt.Errorf("charge declined: %s", decline.Text)
+	}
+
+	tx.Status = StatusCaptured
+	return tx.ID, nil
+}
+
+// ChargeOpaqueData cobra (authCaptureTransaction) um nonce gerado por Tokenize
+func (g *Gateway) ChargeOpaqueData(opaque *models.OpaqueData, amount, tax float64, currency, description string) (*models.TransactionResponse, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !opaque.Valid() {
+		return nil, fmt.Errorf("payment nonce is required")
+	}
+
+	card, err := g.consumeNonce(opaque)
+	if err != nil {
+		return &models.TransactionResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	tx := &Transaction{
+		ID:          g.newID(),
+		Type:        "authCaptureTransaction",
+		Amount:      amount,
+		Tax:         tax,
+		Currency:    currencyOrDefault(currency),
+		CardNumber:  card.cardNumber,
+		SubmittedAt: time.Now().UTC(),
+	}
+	g.transactions[tx.ID] = tx
+
+	if decline := g.checkCard(card.cardNumber, card.expiry, card.cvv, ""); decline != nil {
+		tx.Status = StatusDeclined
+		return &models.TransactionResponse{
+			Success: false,
+			Message: decline.Text,
+		}, nil
+	}
+
+	tx.Status = StatusCaptured
+	return &models.TransactionResponse{
+		Success:       true,
+		TransactionID: tx.ID,
+		Message:       "This transaction has been approved.",
+		AccountNumber: "XXXX" + lastFour(card.cardNumber),
+	}, nil
+}
+
+// CreateSubscription cria um perfil CIM e uma assinatura ARB sobre ele
+func (g *Gateway) CreateSubscription(payment *models.PaymentRequest, checkout *models.CheckoutData) (*models.SubscriptionResponse, error) {
+	customerProfileID, paymentProfileID, err := g.CreateCustomerProfile(payment, checkout)
+	if err != nil {
+		return &models.SubscriptionResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to create customer profile: %v", err),
+		}, nil
+	}
+
+	return g.createSubscription(customerProfileID, paymentProfileID, checkout), nil
+}
+
+// CreateSubscriptionDirect cria uma assinatura ARB com os dados do cartão
+func (g *Gateway) CreateSubscriptionDirect(payment *models.PaymentRequest, checkout *models.CheckoutData) (*models.SubscriptionResponse, error) {
+	if payment.HasStoredProfile() {
+		return g.CreateSubscriptionFromProfile(payment, checkout, payment.CustomerProfileID, payment.PaymentProfileID)
+	}
+
+	g.mutex.Lock()
+	decline := g.checkCard(payment.CardNumber, payment.Expiry, "", checkout.ZipCode)
+	g.mutex.Unlock()
+
+	if decline != nil {
+		return &models.SubscriptionResponse{
+			Success: false,
+			Message: decline.Text,
+		}, nil
+	}
+
+	return g.createSubscription("", "card:"+lastFour(payment.CardNumber), checkout), nil
+}
+
+// CreateSubscriptionFromProfile cria uma assinatura ARB sobre um perfil CIM existente
+func (g *Gateway) CreateSubscriptionFromProfile(payment *models.PaymentRequest, checkout *models.CheckoutData, customerProfileID, paymentProfileID string) (*models.SubscriptionResponse, error) {
+	g.mutex.Lock()
+	pp := g.findPaymentProfile(customerProfileID, paymentProfileID)
+	g.mutex.Unlock()
+
+	if pp == nil {
+		return &models.SubscriptionResponse{
+			Success: false,
+			Message: "Invalid customer profile or payment profile ID",
+		}, nil
+	}
+
+	return g.createSubscription(customerProfileID, paymentProfileID, checkout), nil
+}
+
+func (g *Gateway) createSubscription(customerProfileID, paymentProfileID string, checkout *models.CheckoutData) *models.SubscriptionResponse {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Mesmo cálculo do authorizenet.Client: planos anuais cobram 10 meses a cada 12
+	var total float64
+	intervalMonths := 1
+	for _, plan := range checkout.Plans {
+		if plan.Annually == 1 {
+			intervalMonths = 12
+			total += plan.Price * 10
+		} else {
+			total += plan.Price
+		}
+	}
+	total -= checkout.CouponDiscount
+	total += checkout.Tax
+
+	if total <= 0 {
+		return &models.SubscriptionResponse{
+			Success: false,
+			Message: "The element 'amount' is invalid.",
+		}
+	}
+
+	// ARB recusa assinaturas idênticas (E00012)
+	for _, sub := range g.subscriptions {
+		if sub.Status == "active" && sub.CustomerProfileID == customerProfileID &&
+			sub.PaymentProfileID == paymentProfileID && toCents(sub.Amount) == toCents(total) &&
+			sub.IntervalMonths == intervalMonths {
+			return &models.SubscriptionResponse{
+				Success: false,
+				Message: fmt.Sprintf("You have submitted a duplicate of Subscription %s. A duplicate subscription will not be created.", sub.ID),
+			}
+		}
+	}
+
+	sub := &Subscription{
+		ID:                g.newID(),
+		CustomerProfileID: customerProfileID,
+		PaymentProfileID:  paymentProfileID,
+		Amount:            total,
+		IntervalMonths:    intervalMonths,
+		StartDate:         checkout.TrialEndDate(time.Now()).Format("2006-01-02"),
+		Status:            "active",
+	}
+	g.subscriptions[sub.ID] = sub
+
+	return &models.SubscriptionResponse{
+		Success:        true,
+		SubscriptionID: sub.ID,
+		Message:        "Subscription created successfully with customer profile",
+	}
+}
+
+// UpdateSubscription altera o valor de uma assinatura ARB
+func (g *Gateway) UpdateSubscription(subscriptionID string, newAmount float64) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionID]
+	if !ok {
+		return fmt.Errorf("subscription update failed: The subscription cannot be found.")
+	}
+
+	if sub.Status == "canceled" {
+		return fmt.Errorf("subscription update failed: Subscriptions that are canceled cannot be updated.")
+	}
+
+	sub.Amount = newAmount
+	return nil
+}
+
+// CreateScheduledSubscription cria uma assinatura ARB com valor, intervalo e início explícitos
+func (g *Gateway) CreateScheduledSubscription(customerProfileID, paymentProfileID string, amount float64, intervalMonths int, startDate time.Time) (*models.SubscriptionResponse, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.findPaymentProfile(customerProfileID, paymentProfileID) == nil {
+		return &models.SubscriptionResponse{
+			Success: false,
+			Message: "Invalid customer profile or payment profile ID",
+		}, nil
+	}
+
+	if amount <= 0 {
+		return &models.SubscriptionResponse{
+			Success: false,
+			Message: "The element 'amount' is invalid.",
+		}, nil
+	}
+
+	if intervalMonths <= 0 {
+		intervalMonths = 1
+	}
+
+	sub := &Subscription{
+		ID:                g.newID(),
+		CustomerProfileID: customerProfileID,
+		PaymentProfileID:  paymentProfileID,
+		Amount:            amount,
+		IntervalMonths:    intervalMonths,
+		StartDate:         startDate.Format("2006-01-02"),
+		Status:            "active",
+	}
+	g.subscriptions[sub.ID] = sub
+
+	return &models.SubscriptionResponse{
+		Success:        true,
+		SubscriptionID: sub.ID,
+		Message:        "Subscription created successfully with customer profile",
+	}, nil
+}
+
+// CancelSubscription cancela uma assinatura ARB; cancelar de novo não é erro
+func (g *Gateway) CancelSubscription(subscriptionID string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionID]
+	if !ok {
+		return fmt.Errorf("subscription cancellation failed: The subscription cannot be found.")
+	}
+
+	sub.Status = "canceled"
+	return nil
+}
+
+// SetSubscriptionStatus altera o status de uma assinatura como se a mudança viesse do
+// gateway (ex.: suspensa ou cancelada pelo painel da Authorize.net)
+func (g *Gateway) SetSubscriptionStatus(subscriptionID, status string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionID]
+	if !ok {
+		return false
+	}
+	sub.Status = status
+	return true
+}
+
+// GetSubscription retorna a assinatura ARB no formato do ARBGetSubscriptionRequest.
+// O fake não executa as cobranças recorrentes, então a lista de cobranças vem vazia.
+func (g *Gateway) GetSubscription(subscriptionID string) (*authorizenet.SubscriptionDetails, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionID]
+	if !ok {
+		return nil, fmt.Errorf("ARBGetSubscription failed: The subscription cannot be found. (Code: E00035)")
+	}
+
+	details := &authorizenet.SubscriptionDetails{
+		Name:   "ProSecure Subscription",
+		Amount: sub.Amount,
+		Status: sub.Status,
+	}
+	details.PaymentSchedule.Interval.Length = sub.IntervalMonths
+	details.PaymentSchedule.Interval.Unit = "months"
+	details.PaymentSchedule.StartDate = sub.StartDate
+	details.PaymentSchedule.TotalOccurrences = 9999
+	return details, nil
+}
+
+// GetSubscriptionStatus retorna o status de uma assinatura ARB
+func (g *Gateway) GetSubscriptionStatus(subscriptionID string) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	sub, ok := g.subscriptions[subscriptionID]
+	if !ok {
+		return "", fmt.Errorf("ARBGetSubscriptionStatus failed: The subscription cannot be found. (Code: E00035)")
+	}
+	return sub.Status, nil
+}
+
+// GetSettledBatchList retorna os lotes criados por Settle no intervalo informado
+func (g *Gateway) GetSettledBatchList(firstSettlementDate, lastSettlementDate time.Time) ([]authorizenet.SettledBatch, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	batches := []authorizenet.SettledBatch{}
+	for _, batch := range g.batches {
+		if batch.settledAt.Before(firstSettlementDate) || batch.settledAt.After(lastSettlementDate) {
+			continue
+		}
+		batches = append(batches, authorizenet.SettledBatch{
+			BatchID:           batch.id,
+			SettlementTimeUTC: batch.settledAt.Format("2006-01-02T15:04:05Z"),
+			SettlementState:   "settledSuccessfully",
+			PaymentMethod:     "creditCard",
+		})
+	}
+
+	return batches, nil
+}
+
+// GetTransactionList retorna as transações de um lote criado por Settle
+func (g *Gateway) GetTransactionList(batchID string) ([]authorizenet.TransactionSummary, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for _, batch := range g.batches {
+		if batch.id != batchID {
+			continue
+		}
+
+		transactions := []authorizenet.TransactionSummary{}
+		for _, id := range batch.transactions {
+			tx := g.transactions[id]
+			status := authorizenet.TransactionStatusSettled
+			if tx.Type == "refundTransaction" {
+				status = authorizenet.TransactionStatusRefundSettled
+			}
+			transactions = append(transactions, authorizenet.TransactionSummary{
+				TransID:           tx.ID,
+				SubmitTimeUTC:     tx.SubmittedAt.Format("2006-01-02T15:04:05Z"),
+				TransactionStatus: status,
+				AccountNumber:     "XXXX" + lastFour(tx.CardNumber),
+				SettleAmount:      tx.Amount,
+			})
+		}
+		return transactions, nil
+	}
+
+	return nil, fmt.Errorf("getTransactionList failed: The record cannot be found. (Code: E00040)")
+}
+
+func luhnValid(cardNumber string) bool {
+	if len(cardNumber) < 13 || len(cardNumber) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(cardNumber) - 1; i >= 0; i-- {
+		digit := int(cardNumber[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// expiryValid aceita MM/YY, MMYY ou YYYY-MM
+func expiryValid(expiry string) bool {
+	var expiryTime time.Time
+	var err error
+
+	switch {
+	case strings.Contains(expiry, "/"):
+		expiryTime, err = time.Parse("01/06", expiry)
+	case strings.Contains(expiry, "-"):
+		expiryTime, err = time.Parse("2006-01", expiry)
+	default:
+		expiryTime, err = time.Parse("0106", expiry)
+	}
+	if err != nil {
+		return false
+	}
+
+	// Válido até o último dia do mês de vencimento
+	return time.Now().Before(expiryTime.AddDate(0, 1, 0))
+}
+
+func lastFour(cardNumber string) string {
+	if len(cardNumber) < 4 {
+		return cardNumber
+	}
+	return cardNumber[len(cardNumber)-4:]
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// currencyOrDefault registra a moeda da transação como o gateway: sem currencyCode vale USD
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return models.DefaultCurrency
+	}
+	return models.NormalizeCurrency(currency)
+}
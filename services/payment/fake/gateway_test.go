@@ -0,0 +1,249 @@
+package fake
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"prosecure-payment-api/models"
+	"prosecure-payment-api/types"
+)
+
+const testCard = "4111111111111111"
+
+func validExpiry() string {
+	return time.Now().AddDate(2, 0, 0).Format("01/06")
+}
+
+func cardRequest(checkoutID, cardNumber, expiry, cvv, zip string) *models.PaymentRequest {
+	return &models.PaymentRequest{
+		CardName:    "Jane Doe",
+		CardNumber:  cardNumber,
+		CVV:         cvv,
+		Expiry:      expiry,
+		CheckoutID:  checkoutID,
+		BillingInfo: &types.BillingInfoType{Zip: zip},
+	}
+}
+
+func TestProcessPaymentSandboxDeclines(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         *models.PaymentRequest
+		wantSuccess bool
+		wantMessage string
+	}{
+		{"approved", cardRequest("c1", testCard, validExpiry(), "123", "10001"), true, ""},
+		{"invalid card number", cardRequest("c2", "4111111111111112", validExpiry(), "123", "10001"), false, declineTexts[ReasonInvalidCardNumber]},
+		{"expired card", cardRequest("c3", testCard, "01/20", "123", "10001"), false, declineTexts[ReasonExpiredCard]},
+		{"decline zip", cardRequest("c4", testCard, validExpiry(), "123", DeclineZip), false, declineTexts[ReasonDeclined]},
+		{"cvv mismatch", cardRequest("c5", testCard, validExpiry(), MismatchCardCode, "10001"), false, declineTexts[ReasonCardCodeMismatch]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGateway()
+
+			resp, err := g.ProcessPayment(tt.req)
+			if err != nil {
+				t.Fatalf("ProcessPayment: %v", err)
+			}
+			if resp.Success != tt.wantSuccess {
+				t.Fatalf("Success = %v, want %v (%s)", resp.Success, tt.wantSuccess, resp.Message)
+			}
+			if tt.wantMessage != "" && resp.Message != tt.wantMessage {
+				t.Errorf("Message = %q, want %q", resp.Message, tt.wantMessage)
+			}
+			if resp.Success && resp.AccountNumber != "XXXX1111" {
+				t.Errorf("AccountNumber = %q, want XXXX1111", resp.AccountNumber)
+			}
+		})
+	}
+}
+
+func TestDeclineCard(t *testing.T) {
+	g := NewGateway()
+	g.DeclineCard(testCard, ReasonDeclined)
+
+	resp, err := g.ProcessPayment(cardRequest("c1", testCard, validExpiry(), "123", "10001"))
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if resp.Success {
+		t.Error("forced decline approved")
+	}
+}
+
+func TestDuplicateWindow(t *testing.T) {
+	g := NewGateway()
+
+	first, _ := g.ProcessPayment(cardRequest("c1", testCard, validExpiry(), "123", "10001"))
+	second, _ := g.ProcessPayment(cardRequest("c1", testCard, validExpiry(), "123", "10001"))
+
+	if !second.IsDuplicate || second.TransactionID != first.TransactionID {
+		t.Errorf("second authorization = %+v, want duplicate of %s", second, first.TransactionID)
+	}
+
+	// A duplicata de uma recusa continua recusada
+	declined, _ := g.ProcessPayment(cardRequest("c2", testCard, validExpiry(), "123", DeclineZip))
+	resubmitted, _ := g.ProcessPayment(cardRequest("c2", testCard, validExpiry(), "123", DeclineZip))
+	if declined.Success || resubmitted.Success {
+		t.Errorf("declined card approved on resubmission: first %+v, second %+v", declined, resubmitted)
+	}
+
+	// Fora da janela a autorização é nova
+	g.DuplicateWindow = 0
+	third, _ := g.ProcessPayment(cardRequest("c1", testCard, validExpiry(), "123", "10001"))
+	if third.IsDuplicate || third.TransactionID == first.TransactionID {
+		t.Errorf("authorization outside the window reported as duplicate: %+v", third)
+	}
+}
+
+func TestNonceIsSingleUse(t *testing.T) {
+	g := NewGateway()
+	nonce := g.Tokenize(testCard, validExpiry(), "123")
+
+	req := &models.PaymentRequest{CheckoutID: "c1", OpaqueData: nonce}
+	resp, err := g.ProcessPayment(req)
+	if err != nil || !resp.Success {
+		t.Fatalf("ProcessPayment with nonce: %+v, %v", resp, err)
+	}
+	// Com nonce a autorização cria o perfil usado nas cobranças seguintes
+	if resp.CustomerProfileID == "" || resp.PaymentProfileID == "" {
+		t.Error("no customer profile created from the nonce")
+	}
+
+	reused := &models.PaymentRequest{CheckoutID: "c2", OpaqueData: &models.OpaqueData{
+		DataDescriptor: nonce.DataDescriptor,
+		DataValue:      nonce.DataValue,
+	}}
+	resp, err = g.ProcessPayment(reused)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if resp.Success || !strings.Contains(resp.Message, "E00114") {
+		t.Errorf("reused nonce: %+v, want E00114", resp)
+	}
+}
+
+func TestCaptureSettleAndRefund(t *testing.T) {
+	g := NewGateway()
+	nonce := g.Tokenize(testCard, validExpiry(), "123")
+
+	auth, _ := g.ProcessPayment(&models.PaymentRequest{CheckoutID: "c1", OpaqueData: nonce})
+	transID, err := g.ChargeCustomerProfile(auth.CustomerProfileID, auth.PaymentProfileID, 50, 0, "USD", "")
+	if err != nil {
+		t.Fatalf("ChargeCustomerProfile: %v", err)
+	}
+
+	// Só transações liquidadas podem ser estornadas
+	if _, err := g.RefundTransaction(transID, 10, "1111"); err == nil {
+		t.Error("refund of unsettled transaction accepted")
+	}
+
+	if batchID := g.Settle(); batchID == "" {
+		t.Fatal("Settle returned no batch")
+	}
+	if tx, _ := g.Transaction(transID); tx.Status != StatusSettled {
+		t.Fatalf("status after Settle = %s, want %s", tx.Status, StatusSettled)
+	}
+
+	if _, err := g.RefundTransaction(transID, 10, "0000"); err == nil {
+		t.Error("refund accepted with the wrong card")
+	}
+	if _, err := g.RefundTransaction(transID, 30, "1111"); err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if _, err := g.RefundTransaction(transID, 20.01, "1111"); err == nil {
+		t.Error("refund over the remaining balance accepted")
+	}
+	if _, err := g.RefundTransaction(transID, 20, "1111"); err != nil {
+		t.Errorf("refund of the remaining balance: %v", err)
+	}
+}
+
+func TestVoidTransaction(t *testing.T) {
+	g := NewGateway()
+
+	auth, _ := g.ProcessPayment(cardRequest("c1", testCard, validExpiry(), "123", "10001"))
+	if err := g.VoidTransaction(auth.TransactionID); err != nil {
+		t.Fatalf("VoidTransaction: %v", err)
+	}
+	// Anular de novo é aceito, como na Authorize.net
+	if err := g.VoidTransaction(auth.TransactionID); err != nil {
+		t.Errorf("second VoidTransaction: %v", err)
+	}
+	if err := g.CaptureTransaction(auth.TransactionID, 1); err == nil {
+		t.Error("capture of voided transaction accepted")
+	}
+}
+
+func TestCaptureCannotExceedAuthorization(t *testing.T) {
+	g := NewGateway()
+
+	auth, _ := g.ProcessPayment(cardRequest("c1", testCard, validExpiry(), "123", "10001"))
+	if err := g.CaptureTransaction(auth.TransactionID, 1.01); err == nil {
+		t.Error("capture over the authorized amount accepted")
+	}
+	if err := g.CaptureTransaction(auth.TransactionID, 1); err != nil {
+		t.Errorf("CaptureTransaction: %v", err)
+	}
+}
+
+func TestScheduledSubscriptionLifecycle(t *testing.T) {
+	g := NewGateway()
+	nonce := g.Tokenize(testCard, validExpiry(), "123")
+	auth, _ := g.ProcessPayment(&models.PaymentRequest{CheckoutID: "c1", OpaqueData: nonce})
+
+	start := time.Now().AddDate(0, 1, 0)
+	resp, err := g.CreateScheduledSubscription(auth.CustomerProfileID, auth.PaymentProfileID, 32.47, 12, start)
+	if err != nil || !resp.Success {
+		t.Fatalf("CreateScheduledSubscription: %+v, %v", resp, err)
+	}
+
+	details, err := g.GetSubscription(resp.SubscriptionID)
+	if err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+	if details.Amount != 32.47 || details.PaymentSchedule.Interval.Length != 12 ||
+		details.PaymentSchedule.StartDate != start.Format("2006-01-02") {
+		t.Errorf("subscription = %+v", details)
+	}
+
+	if err := g.UpdateSubscription(resp.SubscriptionID, 20); err != nil {
+		t.Fatalf("UpdateSubscription: %v", err)
+	}
+	if sub, _ := g.Subscription(resp.SubscriptionID); sub.Amount != 20 {
+		t.Errorf("amount after update = %.2f, want 20", sub.Amount)
+	}
+
+	// Cancelar de novo não é erro: o retry do cancelamento depende disso
+	for i := 0; i < 2; i++ {
+		if err := g.CancelSubscription(resp.SubscriptionID); err != nil {
+			t.Fatalf("CancelSubscription #%d: %v", i+1, err)
+		}
+	}
+	if status, _ := g.GetSubscriptionStatus(resp.SubscriptionID); status != "canceled" {
+		t.Errorf("status = %s, want canceled", status)
+	}
+
+	if err := g.CancelSubscription("missing"); err == nil {
+		t.Error("cancelling an unknown subscription succeeded")
+	}
+}
+
+func TestScheduledSubscriptionRequiresProfileAndAmount(t *testing.T) {
+	g := NewGateway()
+
+	resp, _ := g.CreateScheduledSubscription("1", "2", 10, 1, time.Now())
+	if resp.Success {
+		t.Error("subscription created for an unknown profile")
+	}
+
+	nonce := g.Tokenize(testCard, validExpiry(), "123")
+	auth, _ := g.ProcessPayment(&models.PaymentRequest{CheckoutID: "c1", OpaqueData: nonce})
+	resp, _ = g.CreateScheduledSubscription(auth.CustomerProfileID, auth.PaymentProfileID, 0, 1, time.Now())
+	if resp.Success {
+		t.Error("subscription created with zero amount")
+	}
+}
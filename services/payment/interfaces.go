@@ -1,15 +1,74 @@
 package payment
 
 import (
+    "time"
+
     "prosecure-payment-api/models"
+    "prosecure-payment-api/services/payment/authorizenet"
 )
 type PaymentProcessor interface {
     ProcessPayment(models.PaymentRequest, models.CheckoutData) (models.TransactionResponse, error)
     ValidateCard(models.PaymentRequest) bool
     SetupRecurringBilling(models.PaymentRequest, models.CheckoutData) error
 }
+
+// PaymentGateway é o contrato de um gateway de pagamento usado pelo Service.
+// *authorizenet.Client é a implementação de produção; fake.Gateway é a implementação
+// em memória para testes offline.
+//
+// Recusas de transação voltam como TransactionResponse/SubscriptionResponse com
+// Success = false; erros são reservados para falhas de comunicação ou de requisição.
+//
+// Um PaymentRequest com OpaqueData (Accept.js) é convertido em customer profile na
+// primeira transação; o gateway preenche CustomerProfileID/PaymentProfileID e limpa
+// OpaqueData, e as chamadas seguintes usam o perfil.
 type PaymentGateway interface {
-    ProcessInitialCharge(models.PaymentRequest) (models.TransactionResponse, error)
-    VoidTransaction(string) error
-    SetupRecurringBilling(models.PaymentRequest, models.CheckoutData) error
-}
\ No newline at end of file
+    // Transações
+    ProcessPayment(req *models.PaymentRequest) (*models.TransactionResponse, error) // autorização de $1
+    CaptureTransaction(transactionID string, amount float64) error
+    VoidTransaction(transactionID string) error
+    RefundTransaction(transactionID string, amount float64, cardLastFour string) (string, error)
+    ChargeOpaqueData(opaque *models.OpaqueData, amount, tax float64, currency, description string) (*models.TransactionResponse, error) // nonce do Accept.js; amount já inclui tax
+
+    // Customer profiles (CIM)
+    CreateCustomerProfile(payment *models.PaymentRequest, checkout *models.CheckoutData) (string, string, error)
+    CreateCustomerPaymentProfile(customerProfileID string, payment *models.PaymentRequest, checkout *models.CheckoutData) (string, error)
+    UpdateCustomerPaymentProfile(customerProfileID, paymentProfileID string, payment *models.PaymentRequest, checkout *models.CheckoutData) error
+    ChargeCustomerProfile(customerProfileID, paymentProfileID string, amount, tax float64, currency, cvv string) (string, error) // amount já inclui tax
+
+    // Assinaturas recorrentes (ARB)
+    CreateSubscription(payment *models.PaymentRequest, checkout *models.CheckoutData) (*models.SubscriptionResponse, error)
+    CreateSubscriptionDirect(payment *models.PaymentRequest, checkout *models.CheckoutData) (*models.SubscriptionResponse, error)
+    CreateSubscriptionFromProfile(payment *models.PaymentRequest, checkout *models.CheckoutData, customerProfileID, paymentProfileID string) (*models.SubscriptionResponse, error)
+    CreateScheduledSubscription(customerProfileID, paymentProfileID string, amount float64, intervalMonths int, startDate time.Time) (*models.SubscriptionResponse, error)
+    UpdateSubscription(subscriptionID string, newAmount float64) error
+    CancelSubscription(subscriptionID string) error
+}
+
+// SettlementReporter é implementado por gateways que expõem os lotes liquidados
+// (Transaction Reporting API da Authorize.net)
+type SettlementReporter interface {
+    GetSettledBatchList(firstSettlementDate, lastSettlementDate time.Time) ([]authorizenet.SettledBatch, error)
+    GetTransactionList(batchID string) ([]authorizenet.TransactionSummary, error)
+}
+
+// SubscriptionReporter é implementado por gateways que consultam as assinaturas ARB
+// (status, agenda e cobranças feitas)
+type SubscriptionReporter interface {
+    GetSubscription(subscriptionID string) (*authorizenet.SubscriptionDetails, error)
+    GetSubscriptionStatus(subscriptionID string) (string, error)
+}
+
+// WebhookManager é implementado por gateways que permitem gerenciar webhooks
+type WebhookManager interface {
+    ListWebhooks() ([]authorizenet.Webhook, error)
+    CreateWebhook(name, url string, eventTypes []string) (*authorizenet.Webhook, error)
+    DeleteWebhook(webhookID string) error
+}
+
+var (
+    _ PaymentGateway       = (*authorizenet.Client)(nil)
+    _ SettlementReporter   = (*authorizenet.Client)(nil)
+    _ SubscriptionReporter = (*authorizenet.Client)(nil)
+    _ WebhookManager       = (*authorizenet.Client)(nil)
+)
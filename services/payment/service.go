@@ -11,16 +11,27 @@ import (
 )
 
 type Service struct {
-    client *authorizenet.Client
-    cache  *sync.Map // Para cache de validações de cartão
+    gateway PaymentGateway
+    cache   *sync.Map // Para cache de validações de cartão
 }
 
-// NewPaymentService cria um novo serviço de pagamento com cache e timeouts otimizados
-func NewPaymentService(apiLoginID, transactionKey, merchantID, environment string) *Service {
+// NewPaymentService cria um novo serviço de pagamento com cache e timeouts otimizados.
+// endpoint vazio usa o endpoint padrão do environment (sandbox ou produção).
+func NewPaymentService(apiLoginID, transactionKey, merchantID, environment, endpoint string) *Service {
     client := authorizenet.NewClient(apiLoginID, transactionKey, merchantID, environment)
+    if endpoint != "" {
+        log.Printf("Authorize.net endpoint overridden: %s", endpoint)
+        client.SetEndpoint(endpoint)
+    }
+    return NewPaymentServiceWithGateway(client)
+}
+
+// NewPaymentServiceWithGateway cria um serviço de pagamento sobre um gateway qualquer
+// (ex.: fake.Gateway para rodar o checkout offline)
+func NewPaymentServiceWithGateway(gateway PaymentGateway) *Service {
     return &Service{
-        client: client,
-        cache:  &sync.Map{},
+        gateway: gateway,
+        cache:   &sync.Map{},
     }
 }
 
@@ -34,12 +45,12 @@ func (s *Service) ProcessInitialAuthorization(payment *models.PaymentRequest) (*
             time.Since(startTime), payment.CheckoutID)
     }()
 
-    if !s.ValidateCard(payment) {
+    if !s.validateStoredCard(payment) {
         return nil, errors.New("invalid card data: please check card number, expiration date and CVV")
     }
 
     // Processo de cobrança inicial com timeout reduzido
-    resp, err := s.client.ProcessPayment(payment)
+    resp, err := s.gateway.ProcessPayment(payment)
     if err != nil {
         log.Printf("Error processing payment: %v", err)
         return nil, fmt.Errorf("payment processing failed: %v", err)
@@ -72,7 +83,7 @@ func (s *Service) ProcessPayment(payment *models.PaymentRequest, checkout *model
     }
 
     // Processo de cobrança inicial
-    resp, err := s.client.ProcessPayment(payment)
+    resp, err := s.gateway.ProcessPayment(payment)
     if err != nil {
         log.Printf("Error processing payment: %v", err)
         return nil, fmt.Errorf("payment processing failed: %v", err)
@@ -85,7 +96,7 @@ func (s *Service) ProcessPayment(payment *models.PaymentRequest, checkout *model
 
     // Void da transação
     log.Printf("Payment successful, voiding transaction: %s", resp.TransactionID)
-    if err := s.client.VoidTransaction(resp.TransactionID); err != nil {
+    if err := s.gateway.VoidTransaction(resp.TransactionID); err != nil {
         log.Printf("Error voiding transaction: %v", err)
         return nil, fmt.Errorf("failed to void initial transaction: %v", err)
     }
@@ -96,7 +107,7 @@ func (s *Service) ProcessPayment(payment *models.PaymentRequest, checkout *model
     if err != nil {
         log.Printf("Error setting up recurring billing: %v", err)
         // Tentar anular a transação novamente para garantir que não esteja pendente
-        if voidErr := s.client.VoidTransaction(resp.TransactionID); voidErr != nil {
+        if voidErr := s.gateway.VoidTransaction(resp.TransactionID); voidErr != nil {
             log.Printf("Error voiding transaction after recurring billing failure: %v", voidErr)
         }
         return nil, fmt.Errorf("failed to setup recurring billing: %v", err)
@@ -115,12 +126,73 @@ func (s *Service) VoidTransaction(transactionID string) error {
             time.Since(startTime), transactionID)
     }()
     
-    return s.client.VoidTransaction(transactionID)
+    return s.gateway.VoidTransaction(transactionID)
+}
+
+// CaptureTransaction captura uma autorização prévia (amount <= 0 captura o valor autorizado)
+func (s *Service) CaptureTransaction(transactionID string, amount float64) error {
+    log.Printf("Capturing transaction: %s", transactionID)
+
+    if transactionID == "" {
+        return fmt.Errorf("transaction ID is required")
+    }
+
+    return s.gateway.CaptureTransaction(transactionID, amount)
 }
 
-// ValidateCard verifica se os dados do cartão são válidos
+// RefundTransaction estorna total ou parcialmente uma transação liquidada
+func (s *Service) RefundTransaction(transactionID string, amount float64, cardLastFour string) (string, error) {
+    log.Printf("Refunding $%.2f of transaction: %s", amount, transactionID)
+
+    if transactionID == "" {
+        return "", fmt.Errorf("transaction ID is required")
+    }
+
+    if amount <= 0 {
+        return "", fmt.Errorf("invalid refund amount: %.2f", amount)
+    }
+
+    if len(cardLastFour) != 4 {
+        return "", fmt.Errorf("card last four digits are required for refunds")
+    }
+
+    startTime := time.Now()
+    defer func() {
+        log.Printf("Refund took %v for transaction ID: %s", time.Since(startTime), transactionID)
+    }()
+
+    return s.gateway.RefundTransaction(transactionID, amount, cardLastFour)
+}
+
+// ValidateCard verifica se os dados do cartão são válidos, exigindo o CVV
 // Implementa caching para evitar revalidações idênticas
 func (s *Service) ValidateCard(payment *models.PaymentRequest) bool {
+    return s.validateCard(payment, true)
+}
+
+// validateStoredCard valida dados lidos de temp_payment_data pelo worker. O CVV é
+// apagado após o primeiro uso, então as tentativas seguintes chegam sem ele.
+func (s *Service) validateStoredCard(payment *models.PaymentRequest) bool {
+    return s.validateCard(payment, false)
+}
+
+func (s *Service) validateCard(payment *models.PaymentRequest, requireCVV bool) bool {
+    // Accept.js: o cartão foi validado no navegador e a API só recebe o nonce (ou o
+    // perfil criado a partir dele)
+    if payment.HasOpaqueData() || payment.HasStoredProfile() {
+        return true
+    }
+    if payment.OpaqueData != nil {
+        log.Printf("Incomplete opaque data: dataDescriptor and dataValue are required")
+        return false
+    }
+
+    // O CVV não entra na chave de cache, então é verificado antes dela
+    if (requireCVV || payment.CVV != "") && (len(payment.CVV) < 3 || len(payment.CVV) > 4) {
+        log.Printf("Invalid CVV length: %d", len(payment.CVV))
+        return false
+    }
+
     // Gerar uma chave de cache (usando apenas 4 últimos dígitos do cartão para evitar armazenar PCI)
     var lastFour string
     if len(payment.CardNumber) > 4 {
@@ -139,11 +211,6 @@ func (s *Service) ValidateCard(payment *models.PaymentRequest) bool {
         log.Printf("Invalid card number length: %d", len(payment.CardNumber))
         return false
     }
-   
-    if len(payment.CVV) < 3 || len(payment.CVV) > 4 {
-        log.Printf("Invalid CVV length: %d", len(payment.CVV))
-        return false
-    }
 
     if !validateExpiry(payment.Expiry) {
         log.Printf("Invalid expiry date: %s", payment.Expiry)
@@ -167,7 +234,7 @@ func (s *Service) ValidateCard(payment *models.PaymentRequest) bool {
 
 // SetupRecurringBilling configura cobrança recorrente USANDO CUSTOMER PROFILE
 func (s *Service) SetupRecurringBilling(payment *models.PaymentRequest, checkout *models.CheckoutData) (string, error) {
-    if !s.ValidateCard(payment) {
+    if !s.validateStoredCard(payment) {
         return "", errors.New("invalid card data for recurring billing setup")
     }
 
@@ -180,7 +247,7 @@ func (s *Service) SetupRecurringBilling(payment *models.PaymentRequest, checkout
     log.Printf("Setting up recurring billing with customer profile for checkout ID: %s", payment.CheckoutID)
 
     // NOVO: Usar o método que cria customer profile + subscription
-    subscriptionResp, err := s.client.CreateSubscription(payment, checkout)
+    subscriptionResp, err := s.gateway.CreateSubscription(payment, checkout)
     if err != nil {
         return "", fmt.Errorf("failed to setup recurring billing with customer profile: %v", err)
     }
@@ -195,7 +262,7 @@ func (s *Service) SetupRecurringBilling(payment *models.PaymentRequest, checkout
 
 // SetupRecurringBillingDirect configura cobrança recorrente SEM CUSTOMER PROFILE (método legado)
 func (s *Service) SetupRecurringBillingDirect(payment *models.PaymentRequest, checkout *models.CheckoutData) (string, error) {
-    if !s.ValidateCard(payment) {
+    if !s.validateStoredCard(payment) {
         return "", errors.New("invalid card data for recurring billing setup")
     }
 
@@ -208,7 +275,7 @@ func (s *Service) SetupRecurringBillingDirect(payment *models.PaymentRequest, ch
     log.Printf("Setting up recurring billing with direct card data for checkout ID: %s", payment.CheckoutID)
 
     // Usar o método legado que usa dados de cartão diretos
-    subscriptionResp, err := s.client.CreateSubscriptionDirect(payment, checkout)
+    subscriptionResp, err := s.gateway.CreateSubscriptionDirect(payment, checkout)
     if err != nil {
         return "", fmt.Errorf("failed to setup recurring billing (direct method): %v", err)
     }
@@ -221,9 +288,36 @@ func (s *Service) SetupRecurringBillingDirect(payment *models.PaymentRequest, ch
     return subscriptionResp.SubscriptionID, nil // CORRIGIDO: Retorna o subscription ID
 }
 
+// SetupRecurringBillingWithProfile cria a assinatura ARB sobre um customer profile já
+// existente, sem reenviar dados de cartão (ex.: perfil criado a partir do nonce do Accept.js)
+func (s *Service) SetupRecurringBillingWithProfile(payment *models.PaymentRequest, checkout *models.CheckoutData, customerProfileID, paymentProfileID string) (string, error) {
+    if customerProfileID == "" || paymentProfileID == "" {
+        return "", errors.New("customer profile and payment profile IDs are required")
+    }
+
+    startTime := time.Now()
+    defer func() {
+        log.Printf("Subscription setup (existing profile) took %v for checkout ID: %s",
+            time.Since(startTime), payment.CheckoutID)
+    }()
+
+    subscriptionResp, err := s.gateway.CreateSubscriptionFromProfile(payment, checkout, customerProfileID, paymentProfileID)
+    if err != nil {
+        return "", fmt.Errorf("failed to setup recurring billing with existing profile: %v", err)
+    }
+
+    if !subscriptionResp.Success {
+        return "", fmt.Errorf("subscription creation failed: %s", subscriptionResp.Message)
+    }
+
+    log.Printf("Successfully created subscription %s on profile %s/%s",
+        subscriptionResp.SubscriptionID, customerProfileID, paymentProfileID)
+    return subscriptionResp.SubscriptionID, nil
+}
+
 // CreateCustomerProfile cria um customer profile na Authorize.net
 func (s *Service) CreateCustomerProfile(payment *models.PaymentRequest, checkout *models.CheckoutData) (string, string, error) {
-    if !s.ValidateCard(payment) {
+    if !s.validateStoredCard(payment) {
         return "", "", errors.New("invalid card data for customer profile creation")
     }
 
@@ -235,7 +329,7 @@ func (s *Service) CreateCustomerProfile(payment *models.PaymentRequest, checkout
 
     log.Printf("Creating customer profile for checkout ID: %s", payment.CheckoutID)
 
-    customerProfileID, paymentProfileID, err := s.client.CreateCustomerProfile(payment, checkout)
+    customerProfileID, paymentProfileID, err := s.gateway.CreateCustomerProfile(payment, checkout)
     if err != nil {
         return "", "", fmt.Errorf("failed to create customer profile: %v", err)
     }
@@ -260,7 +354,7 @@ func (s *Service) UpdateCustomerPaymentProfile(customerProfileID, paymentProfile
 
     log.Printf("Updating customer payment profile: %s/%s", customerProfileID, paymentProfileID)
 
-    err := s.client.UpdateCustomerPaymentProfile(customerProfileID, paymentProfileID, payment, checkout)
+    err := s.gateway.UpdateCustomerPaymentProfile(customerProfileID, paymentProfileID, payment, checkout)
     if err != nil {
         return fmt.Errorf("failed to update customer payment profile: %v", err)
     }
@@ -270,8 +364,8 @@ func (s *Service) UpdateCustomerPaymentProfile(customerProfileID, paymentProfile
 }
 
 // CORRIGIDO: ChargeCustomerProfile - Agora aceita CVV e valida
-func (s *Service) ChargeCustomerProfile(customerProfileID, paymentProfileID string, amount float64, cvv string) (string, error) {
-    log.Printf("Charging customer profile %s/%s amount: $%.2f with CVV validation", customerProfileID, paymentProfileID, amount)
+func (s *Service) ChargeCustomerProfile(customerProfileID, paymentProfileID string, amount, tax float64, currency, cvv string) (string, error) {
+    log.Printf("Charging customer profile %s/%s amount: $%.2f (tax $%.2f) with CVV validation", customerProfileID, paymentProfileID, amount, tax)
     
     if amount <= 0 {
         return "", fmt.Errorf("invalid amount: %.2f", amount)
@@ -288,7 +382,47 @@ func (s *Service) ChargeCustomerProfile(customerProfileID, paymentProfileID stri
     }()
     
     // Enviar CVV para Authorize.net para validação
-    return s.client.ChargeCustomerProfile(customerProfileID, paymentProfileID, amount, cvv)
+    return s.gateway.ChargeCustomerProfile(customerProfileID, paymentProfileID, amount, tax, currency, cvv)
+}
+
+// ChargeStoredProfile cobra o perfil de pagamento salvo sem CVV. Usado apenas em cobranças
+// iniciadas pelo sistema (ex.: retentativas de recorrência), quando o cliente não está presente.
+func (s *Service) ChargeStoredProfile(customerProfileID, paymentProfileID string, amount float64, currency string) (string, error) {
+    log.Printf("Charging stored customer profile %s/%s amount: $%.2f", customerProfileID, paymentProfileID, amount)
+
+    if amount <= 0 {
+        return "", fmt.Errorf("invalid amount: %.2f", amount)
+    }
+
+    if customerProfileID == "" || paymentProfileID == "" {
+        return "", fmt.Errorf("customer profile and payment profile IDs are required")
+    }
+
+    return s.gateway.ChargeCustomerProfile(customerProfileID, paymentProfileID, amount, 0, currency, "")
+}
+
+// ChargeOpaqueData cobra um nonce do Accept.js (cartão novo informado no navegador)
+func (s *Service) ChargeOpaqueData(opaque *models.OpaqueData, amount, tax float64, currency, description string) (string, error) {
+    log.Printf("Charging payment nonce amount: $%.2f", amount)
+
+    if amount <= 0 {
+        return "", fmt.Errorf("invalid amount: %.2f", amount)
+    }
+
+    if !opaque.Valid() {
+        return "", fmt.Errorf("invalid opaque data: dataDescriptor and dataValue are required")
+    }
+
+    resp, err := s.gateway.ChargeOpaqueData(opaque, amount, tax, currency, description)
+    if err != nil {
+        return "", err
+    }
+
+    if !resp.Success {
+        return "", fmt.Errorf("charge declined: %s", resp.Message)
+    }
+
+    return resp.TransactionID, nil
 }
 
 // UpdateSubscriptionAmount atualiza o valor de uma subscription ARB
@@ -308,7 +442,36 @@ func (s *Service) UpdateSubscriptionAmount(subscriptionID string, newAmount floa
         log.Printf("Subscription update took %v", time.Since(startTime))
     }()
     
-    return s.client.UpdateSubscription(subscriptionID, newAmount)
+    return s.gateway.UpdateSubscription(subscriptionID, newAmount)
+}
+
+// CancelSubscription cancela uma subscription ARB (sem novas cobranças)
+func (s *Service) CancelSubscription(subscriptionID string) error {
+    if subscriptionID == "" {
+        return fmt.Errorf("subscription ID is required")
+    }
+
+    log.Printf("Cancelling subscription %s", subscriptionID)
+    return s.gateway.CancelSubscription(subscriptionID)
+}
+
+// CreateScheduledSubscription cria uma subscription ARB sobre o customer profile com
+// valor, intervalo (em meses) e data da primeira cobrança definidos pelo chamador
+func (s *Service) CreateScheduledSubscription(customerProfileID, paymentProfileID string, amount float64, intervalMonths int, startDate time.Time) (string, error) {
+    if amount <= 0 {
+        return "", fmt.Errorf("invalid amount: %.2f", amount)
+    }
+
+    resp, err := s.gateway.CreateScheduledSubscription(customerProfileID, paymentProfileID, amount, intervalMonths, startDate)
+    if err != nil {
+        return "", err
+    }
+
+    if !resp.Success {
+        return "", fmt.Errorf("subscription creation failed: %s", resp.Message)
+    }
+
+    return resp.SubscriptionID, nil
 }
 
 // Função helper para validar o algoritmo de Luhn para números de cartão
@@ -348,7 +511,7 @@ func (s *Service) CreateCustomerPaymentProfile(customerProfileID string, payment
             time.Since(startTime), customerProfileID)
     }()
     
-    paymentProfileID, err := s.client.CreateCustomerPaymentProfile(customerProfileID, paymentReq, checkoutData)
+    paymentProfileID, err := s.gateway.CreateCustomerPaymentProfile(customerProfileID, paymentReq, checkoutData)
     if err != nil {
         log.Printf("Failed to create customer payment profile for %s: %v", customerProfileID, err)
         return "", fmt.Errorf("failed to create customer payment profile: %v", err)
@@ -378,4 +541,86 @@ func validateExpiry(expiry string) bool {
     )
    
     return expiryTime.After(currentTime)
-}
\ No newline at end of file
+}
+// ListWebhooks lista os webhooks registrados na Authorize.net
+func (s *Service) ListWebhooks() ([]authorizenet.Webhook, error) {
+    manager, ok := s.gateway.(WebhookManager)
+    if !ok {
+        return nil, fmt.Errorf("payment gateway does not support webhooks")
+    }
+    return manager.ListWebhooks()
+}
+
+// RegisterWebhook registra um webhook para os eventos informados (ou a lista padrão)
+func (s *Service) RegisterWebhook(name, url string, eventTypes []string) (*authorizenet.Webhook, error) {
+    if url == "" {
+        return nil, fmt.Errorf("webhook url is required")
+    }
+
+    if len(eventTypes) == 0 {
+        eventTypes = authorizenet.DefaultWebhookEventTypes
+    }
+
+    manager, ok := s.gateway.(WebhookManager)
+    if !ok {
+        return nil, fmt.Errorf("payment gateway does not support webhooks")
+    }
+    return manager.CreateWebhook(name, url, eventTypes)
+}
+
+// DeleteWebhook remove um webhook registrado na Authorize.net
+func (s *Service) DeleteWebhook(webhookID string) error {
+    if webhookID == "" {
+        return fmt.Errorf("webhook ID is required")
+    }
+    manager, ok := s.gateway.(WebhookManager)
+    if !ok {
+        return fmt.Errorf("payment gateway does not support webhooks")
+    }
+    return manager.DeleteWebhook(webhookID)
+}
+
+// GetSettledBatchList retorna os lotes liquidados no intervalo informado
+func (s *Service) GetSettledBatchList(from, to time.Time) ([]authorizenet.SettledBatch, error) {
+    reporter, ok := s.gateway.(SettlementReporter)
+    if !ok {
+        return nil, fmt.Errorf("payment gateway does not support settlement reporting")
+    }
+    return reporter.GetSettledBatchList(from, to)
+}
+
+// GetTransactionList retorna as transações de um lote liquidado
+func (s *Service) GetTransactionList(batchID string) ([]authorizenet.TransactionSummary, error) {
+    if batchID == "" {
+        return nil, fmt.Errorf("batch ID is required")
+    }
+    reporter, ok := s.gateway.(SettlementReporter)
+    if !ok {
+        return nil, fmt.Errorf("payment gateway does not support settlement reporting")
+    }
+    return reporter.GetTransactionList(batchID)
+}
+
+// GetSubscription retorna a assinatura ARB como está no gateway
+func (s *Service) GetSubscription(subscriptionID string) (*authorizenet.SubscriptionDetails, error) {
+    if subscriptionID == "" {
+        return nil, fmt.Errorf("subscription ID is required")
+    }
+    reporter, ok := s.gateway.(SubscriptionReporter)
+    if !ok {
+        return nil, fmt.Errorf("payment gateway does not support subscription queries")
+    }
+    return reporter.GetSubscription(subscriptionID)
+}
+
+// GetSubscriptionStatus retorna o status da assinatura ARB no gateway
+func (s *Service) GetSubscriptionStatus(subscriptionID string) (string, error) {
+    if subscriptionID == "" {
+        return "", fmt.Errorf("subscription ID is required")
+    }
+    reporter, ok := s.gateway.(SubscriptionReporter)
+    if !ok {
+        return "", fmt.Errorf("payment gateway does not support subscription queries")
+    }
+    return reporter.GetSubscriptionStatus(subscriptionID)
+}
@@ -0,0 +1,233 @@
+// services/reconciliation/reconciler.go - Conciliação dos lotes liquidados da Authorize.net com a tabela transactions
+package reconciliation
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"prosecure-payment-api/database"
+	"prosecure-payment-api/services/payment"
+	"prosecure-payment-api/services/payment/authorizenet"
+)
+
+type Reconciler struct {
+	db             *database.Connection
+	paymentService *payment.Service
+}
+
+func NewReconciler(db *database.Connection, ps *payment.Service) *Reconciler {
+	return &Reconciler{
+		db:             db,
+		paymentService: ps,
+	}
+}
+
+// settledTransaction é uma transação liquidada junto com o lote de origem
+type settledTransaction struct {
+	batchID string
+	authorizenet.TransactionSummary
+}
+
+// Run gera o relatório de conciliação para o dia do relatório (UTC): busca os lotes
+// liquidados via getSettledBatchList, as transações de cada lote via getTransactionList
+// e confere cada uma com o registro local pelo transaction_id.
+func (r *Reconciler) Run(report *database.ReconciliationReport) error {
+	day, err := time.Parse("2006-01-02", report.ReportDate)
+	if err != nil {
+		return fmt.Errorf("invalid report date %s: %v", report.ReportDate, err)
+	}
+
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	to := from.Add(24*time.Hour - time.Second)
+
+	batches, err := r.paymentService.GetSettledBatchList(from, to)
+	if err != nil {
+		return fmt.Errorf("error getting settled batches: %v", err)
+	}
+
+	var settled []settledTransaction
+	for _, batch := range batches {
+		transactions, err := r.paymentService.GetTransactionList(batch.BatchID)
+		if err != nil {
+			return fmt.Errorf("error getting transactions for batch %s: %v", batch.BatchID, err)
+		}
+		for _, tx := range transactions {
+			settled = append(settled, settledTransaction{batchID: batch.BatchID, TransactionSummary: tx})
+		}
+	}
+
+	report.BatchCount = len(batches)
+	report.SettledCount = len(settled)
+	report.MatchedCount = 0
+	report.SettledTotal = 0
+	report.RefundedTotal = 0
+
+	items, err := r.match(report, settled)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.CompleteReconciliationReport(report, items); err != nil {
+		return err
+	}
+
+	log.Printf("Reconciliation report %d (%s): %d batches, %d settled, %d matched, %d mismatches",
+		report.ID, report.ReportDate, report.BatchCount, report.SettledCount, report.MatchedCount, len(items))
+	return nil
+}
+
+func (r *Reconciler) match(report *database.ReconciliationReport, settled []settledTransaction) ([]database.ReconciliationItem, error) {
+	var chargeIDs, refundIDs, subscriptionIDs []string
+	for _, tx := range settled {
+		if tx.TransactionStatus == authorizenet.TransactionStatusRefundSettled {
+			refundIDs = append(refundIDs, tx.TransID)
+		} else {
+			chargeIDs = append(chargeIDs, tx.TransID)
+		}
+		if tx.Subscription != nil {
+			subscriptionIDs = append(subscriptionIDs, strconv.Itoa(tx.Subscription.ID))
+		}
+	}
+
+	localCharges, err := r.db.GetTransactionsByIDs(chargeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	localRefunds, err := r.db.GetRefundsByTransactionIDs(refundIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	knownSubscriptions, err := r.db.GetKnownSubscriptionIDs(subscriptionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []database.ReconciliationItem{}
+	for _, tx := range settled {
+		switch tx.TransactionStatus {
+		case authorizenet.TransactionStatusSettled:
+			report.SettledTotal += tx.SettleAmount
+		case authorizenet.TransactionStatusRefundSettled:
+			report.RefundedTotal += tx.SettleAmount
+		}
+
+		var item *database.ReconciliationItem
+		if tx.TransactionStatus == authorizenet.TransactionStatusRefundSettled {
+			item = checkRefund(tx, localRefunds)
+		} else {
+			item = checkCharge(tx, localCharges, knownSubscriptions)
+		}
+
+		if item == nil {
+			report.MatchedCount++
+			continue
+		}
+		items = append(items, *item)
+	}
+
+	report.SettledTotal = math.Round(report.SettledTotal*100) / 100
+	report.RefundedTotal = math.Round(report.RefundedTotal*100) / 100
+	return items, nil
+}
+
+// checkCharge confere uma cobrança liquidada. Retorna nil quando confere.
+func checkCharge(tx settledTransaction, local map[string]database.LocalTransaction, knownSubscriptions map[string]bool) *database.ReconciliationItem {
+	row, found := local[tx.TransID]
+	if !found {
+		// Cobranças recorrentes da ARB não são gravadas em transactions; conferem
+		// se a assinatura é conhecida
+		if tx.Subscription != nil && knownSubscriptions[strconv.Itoa(tx.Subscription.ID)] &&
+			tx.TransactionStatus == authorizenet.TransactionStatusSettled {
+			return nil
+		}
+
+		details := "settled transaction has no local record"
+		if tx.Subscription != nil {
+			details = fmt.Sprintf("ARB charge for unknown subscription %d (payment %d)", tx.Subscription.ID, tx.Subscription.PayNum)
+		}
+		return newItem(tx, database.ReconciliationMissingLocally, nil, details)
+	}
+
+	if tx.TransactionStatus == authorizenet.TransactionStatusVoided {
+		if row.Status != "voided" {
+			return newItem(tx, database.ReconciliationUnexpectedlyVoided, &row,
+				fmt.Sprintf("voided at gateway but %s locally", row.Status))
+		}
+		return nil
+	}
+
+	if tx.TransactionStatus != authorizenet.TransactionStatusSettled {
+		if row.Status == "captured" || row.Status == "partially_refunded" {
+			return newItem(tx, database.ReconciliationStatusMismatch, &row,
+				fmt.Sprintf("gateway status %s but %s locally", tx.TransactionStatus, row.Status))
+		}
+		return nil
+	}
+
+	switch row.Status {
+	case "captured", "partially_refunded", "refunded":
+	case "voided":
+		// Ex.: autorização de verificação de $1 cujo void falhou e acabou liquidada
+		return newItem(tx, database.ReconciliationStatusMismatch, &row, "settled at gateway but voided locally")
+	default:
+		return newItem(tx, database.ReconciliationStatusMismatch, &row,
+			fmt.Sprintf("settled at gateway but %s locally", row.Status))
+	}
+
+	if !sameAmount(tx.SettleAmount, row.Amount) {
+		return newItem(tx, database.ReconciliationAmountMismatch, &row,
+			fmt.Sprintf("settled $%.2f, recorded $%.2f", tx.SettleAmount, row.Amount))
+	}
+
+	return nil
+}
+
+// checkRefund confere um estorno liquidado com a tabela refunds. Retorna nil quando confere.
+func checkRefund(tx settledTransaction, local map[string]database.LocalTransaction) *database.ReconciliationItem {
+	row, found := local[tx.TransID]
+	if !found {
+		return newItem(tx, database.ReconciliationMissingLocally, nil, "settled refund has no local record")
+	}
+
+	if row.Status != database.RefundStatusApproved {
+		return newItem(tx, database.ReconciliationStatusMismatch, &row,
+			fmt.Sprintf("refund settled at gateway but %s locally", row.Status))
+	}
+
+	if !sameAmount(tx.SettleAmount, row.Amount) {
+		return newItem(tx, database.ReconciliationAmountMismatch, &row,
+			fmt.Sprintf("refund settled $%.2f, recorded $%.2f", tx.SettleAmount, row.Amount))
+	}
+
+	return nil
+}
+
+func newItem(tx settledTransaction, issue string, local *database.LocalTransaction, details string) *database.ReconciliationItem {
+	item := &database.ReconciliationItem{
+		BatchID:       tx.batchID,
+		TransactionID: tx.TransID,
+		Issue:         issue,
+		SettledStatus: tx.TransactionStatus,
+		SettledAmount: tx.SettleAmount,
+		SubmittedAt:   tx.SubmitTimeUTC,
+		Details:       details,
+	}
+
+	if local != nil {
+		amount := local.Amount
+		item.LocalStatus = local.Status
+		item.LocalAmount = &amount
+	}
+
+	return item
+}
+
+// sameAmount compara valores em centavos para evitar erros de ponto flutuante
+func sameAmount(a, b float64) bool {
+	return math.Round(a*100) == math.Round(b*100)
+}
@@ -0,0 +1,78 @@
+// services/reconciliation/subscriptions.go - Conferência do status das assinaturas com a ARB
+package reconciliation
+
+import (
+	"fmt"
+	"log"
+
+	"prosecure-payment-api/services/payment/authorizenet"
+)
+
+// ReconcileSubscriptions confere o status local de até limit assinaturas com o
+// ARBGetSubscriptionStatus e corrige as que divergem do gateway
+func (r *Reconciler) ReconcileSubscriptions(limit int) error {
+	items, err := r.db.GetSubscriptionsToSync(limit)
+	if err != nil {
+		return err
+	}
+
+	var corrected, failed int
+	for i := range items {
+		item := &items[i]
+
+		gatewayStatus, err := r.paymentService.GetSubscriptionStatus(item.SubscriptionID)
+		if err != nil {
+			log.Printf("Error getting gateway status of subscription %s: %v", item.SubscriptionID, err)
+			failed++
+			continue
+		}
+
+		if gatewayStatus == "" || statusMatches(item.Status, gatewayStatus) {
+			if err := r.db.RecordSubscriptionGatewayStatus(item.SubscriptionID, gatewayStatus); err != nil {
+				log.Printf("Error recording gateway status of subscription %s: %v", item.SubscriptionID, err)
+			}
+			continue
+		}
+
+		changed, err := r.db.CorrectSubscriptionStatus(item, gatewayStatus, localStatus(gatewayStatus))
+		if err != nil {
+			log.Printf("Error correcting subscription %s: %v", item.SubscriptionID, err)
+			failed++
+			continue
+		}
+		if changed {
+			corrected++
+		}
+	}
+
+	log.Printf("Subscription sync: %d checked, %d corrected, %d failed", len(items), corrected, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d of %d subscriptions", failed, len(items))
+	}
+	return nil
+}
+
+// localStatus traduz o status da ARB para o usado em subscriptions.status
+func localStatus(gatewayStatus string) string {
+	if gatewayStatus == authorizenet.SubscriptionStatusCanceled {
+		return "cancelled"
+	}
+	return gatewayStatus
+}
+
+// statusMatches diz se o status local corresponde ao do gateway. failed não existe na
+// ARB: a cobrança recusada está em dunning e a ARB continua ativa (ou foi suspensa). Um
+// caso de dunning suspenso cancela a ARB, mas a conta continua suspended localmente.
+func statusMatches(local, gatewayStatus string) bool {
+	switch {
+	case local == localStatus(gatewayStatus):
+		return true
+	case local == "failed":
+		return gatewayStatus == authorizenet.SubscriptionStatusActive ||
+			gatewayStatus == authorizenet.SubscriptionStatusSuspended
+	case local == "suspended":
+		return gatewayStatus == authorizenet.SubscriptionStatusCanceled
+	}
+	return false
+}
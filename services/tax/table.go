@@ -0,0 +1,70 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Table é o motor baseado em tabela: a alíquota do ZIP (estado + local) tem precedência
+// sobre a do estado. O arquivo tem o formato
+//
+//	{
+//	  "states":    {"TX": 6.25, "CA": 7.25},
+//	  "zip_codes": {"78701": 8.25, "90001": 10.25}
+//	}
+type Table struct {
+	States   map[string]float64 `json:"states"`
+	ZipCodes map[string]float64 `json:"zip_codes"`
+}
+
+// LoadTable lê a tabela de alíquotas do arquivo
+func LoadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tax rates file %s: %v", path, err)
+	}
+
+	var table Table
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("error parsing tax rates file %s: %v", path, err)
+	}
+
+	states := make(map[string]float64, len(table.States))
+	for state, percent := range table.States {
+		if percent < 0 {
+			return nil, fmt.Errorf("invalid tax rate %.3f for state %s", percent, state)
+		}
+		states[strings.ToUpper(strings.TrimSpace(state))] = percent
+	}
+	zipCodes := make(map[string]float64, len(table.ZipCodes))
+	for zip, percent := range table.ZipCodes {
+		if percent < 0 {
+			return nil, fmt.Errorf("invalid tax rate %.3f for ZIP %s", percent, zip)
+		}
+		zipCodes[normalizeZip(zip)] = percent
+	}
+	table.States = states
+	table.ZipCodes = zipCodes
+
+	log.Printf("Loaded tax rates for %d states and %d ZIP codes from %s", len(states), len(zipCodes), path)
+	return &table, nil
+}
+
+// Lookup retorna a alíquota do ZIP ou, sem ele na tabela, a do estado
+func (t *Table) Lookup(addr Address) Rate {
+	if zip := normalizeZip(addr.ZipCode); zip != "" {
+		if percent, ok := t.ZipCodes[zip]; ok {
+			return Rate{Percent: percent, Jurisdiction: "ZIP " + zip}
+		}
+	}
+
+	state := strings.ToUpper(strings.TrimSpace(addr.State))
+	if percent, ok := t.States[state]; ok {
+		return Rate{Percent: percent, Jurisdiction: state}
+	}
+
+	return Rate{}
+}
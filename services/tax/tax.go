@@ -0,0 +1,70 @@
+// Package tax calcula o imposto sobre vendas pelo endereço de cobrança do cliente
+package tax
+
+import (
+	"math"
+	"strings"
+)
+
+// Config configura o motor de impostos. Sem RatesFile nenhum imposto é cobrado.
+type Config struct {
+	RatesFile string // TAX_RATES_FILE: tabela de alíquotas por estado e ZIP (JSON)
+}
+
+// Address é o endereço de cobrança usado para escolher a alíquota
+type Address struct {
+	State   string
+	City    string
+	ZipCode string
+}
+
+// Rate é a alíquota que vale para um endereço
+type Rate struct {
+	Percent      float64 `json:"percent"`
+	Jurisdiction string  `json:"jurisdiction,omitempty"` // ex.: "TX" ou "ZIP 78701"
+}
+
+// Amount calcula o imposto sobre base, arredondado em centavos
+func (r Rate) Amount(base float64) float64 {
+	if r.Percent <= 0 || base <= 0 {
+		return 0
+	}
+	return math.Round(base*r.Percent) / 100
+}
+
+// Gross retorna base somado ao imposto
+func (r Rate) Gross(base float64) float64 {
+	return math.Round((base+r.Amount(base))*100) / 100
+}
+
+// Engine escolhe a alíquota de um endereço
+type Engine interface {
+	Lookup(addr Address) Rate
+}
+
+// None é o motor usado sem tabela de alíquotas: nenhum imposto
+type None struct{}
+
+func (None) Lookup(Address) Rate {
+	return Rate{}
+}
+
+// New cria o motor configurado
+func New(cfg Config) (Engine, error) {
+	if cfg.RatesFile == "" {
+		return None{}, nil
+	}
+	return LoadTable(cfg.RatesFile)
+}
+
+// normalizeZip reduz o ZIP aos 5 primeiros dígitos (ZIP+4 vale pelo ZIP)
+func normalizeZip(zip string) string {
+	zip = strings.TrimSpace(zip)
+	if i := strings.IndexAny(zip, "- "); i >= 0 {
+		zip = zip[:i]
+	}
+	if len(zip) > 5 {
+		zip = zip[:5]
+	}
+	return zip
+}
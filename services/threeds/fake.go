@@ -0,0 +1,120 @@
+// services/threeds/fake.go - 3DS Server em memória para desenvolvimento (PAYMENT_GATEWAY=fake)
+package threeds
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"prosecure-payment-api/types"
+)
+
+// Cartões de teste do provider fake (mesmos finais dos cartões de teste do Cardinal)
+const (
+	FakeFrictionlessFailedSuffix = "1018" // transStatus N sem desafio
+	FakeChallengeSuffix          = "1091" // exige desafio
+)
+
+// FakeACSURL é a URL devolvida nos desafios do provider fake
+const FakeACSURL = "https://acs.fake.invalid/challenge"
+
+// Fake autentica sem rede: cartões terminados em FakeChallengeSuffix pedem desafio,
+// em FakeFrictionlessFailedSuffix são recusados e os demais passam sem desafio
+type Fake struct {
+	mutex   sync.Mutex
+	pending map[string]bool
+}
+
+// NewFake cria o provider fake
+func NewFake() *Fake {
+	return &Fake{pending: make(map[string]bool)}
+}
+
+// Lookup decide o resultado pelo final do cartão
+func (f *Fake) Lookup(req *LookupRequest) (*Result, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	transID := uuid.New().String()
+
+	switch {
+	case strings.HasSuffix(req.CardNumber, FakeChallengeSuffix):
+		f.pending[transID] = true
+		creq := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(
+			`{"threeDSServerTransID":%q,"messageType":"CReq","messageVersion":"2.2.0","challengeWindowSize":"05"}`, transID)))
+		return &Result{
+			TransID: transID,
+			Status:  StatusChallenge,
+			Version: "2.2.0",
+			Challenge: &types.ThreeDSResponse{
+				AcsUrl:  FakeACSURL,
+				Payload: creq,
+				TransID: transID,
+			},
+		}, nil
+	case strings.HasSuffix(req.CardNumber, FakeFrictionlessFailedSuffix):
+		return &Result{TransID: transID, Status: StatusFailed, Version: "2.2.0", Reason: "01"}, nil
+	}
+
+	return authenticatedResult(transID), nil
+}
+
+// Authenticate conclui um desafio iniciado por Lookup com o transStatus do CRes
+func (f *Fake) Authenticate(callback *types.ThreeDSCallback) (*Result, error) {
+	cres, err := decodeChallengeResponse(callback)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.pending[callback.TransID] {
+		return nil, fmt.Errorf("unknown or already completed 3DS transaction %s", callback.TransID)
+	}
+	delete(f.pending, callback.TransID)
+
+	if cres.TransStatus != StatusAuthenticated {
+		return &Result{TransID: callback.TransID, Status: StatusFailed, Version: "2.2.0"}, nil
+	}
+	return authenticatedResult(callback.TransID), nil
+}
+
+// CompleteChallenge monta o callback que o ACS enviaria ao final do desafio.
+// Com approve=false o portador "falha" na autenticação.
+func (f *Fake) CompleteChallenge(transID string, approve bool) *types.ThreeDSCallback {
+	status := StatusAuthenticated
+	if !approve {
+		status = StatusFailed
+	}
+
+	return &types.ThreeDSCallback{
+		TransID: transID,
+		Status:  status,
+		Payload: encodeChallengeResponse(&challengeResponse{
+			ThreeDSServerTransID: transID,
+			ACSTransID:           uuid.New().String(),
+			MessageType:          "CRes",
+			MessageVersion:       "2.2.0",
+			TransStatus:          status,
+		}),
+	}
+}
+
+func authenticatedResult(transID string) *Result {
+	cavv := make([]byte, 20)
+	rand.Read(cavv)
+
+	return &Result{
+		TransID:   transID,
+		Status:    StatusAuthenticated,
+		ECI:       "05",
+		CAVV:      base64.StdEncoding.EncodeToString(cavv),
+		DSTransID: uuid.New().String(),
+		Version:   "2.2.0",
+	}
+}
@@ -0,0 +1,223 @@
+// services/threeds/server.go - Client HTTP do 3DS Server (API JSON com campos do EMV 3DS)
+package threeds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"prosecure-payment-api/types"
+)
+
+// Server fala com um 3DS Server externo: POST /authenticate inicia a autenticação
+// (AReq) e POST /results devolve o resultado final de um desafio (RReq)
+type Server struct {
+	baseURL         string
+	apiKey          string
+	notificationURL string
+	client          *http.Client
+}
+
+// NewServer cria o client do 3DS Server
+func NewServer(cfg Config) *Server {
+	return &Server{
+		baseURL:         strings.TrimRight(cfg.ServerURL, "/"),
+		apiKey:          cfg.APIKey,
+		notificationURL: cfg.NotificationURL,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type authenticateRequest struct {
+	ThreeDSRequestorTransID string `json:"threeDSRequestorTransID"`
+	MessageVersion          string `json:"messageVersion,omitempty"`
+	MessageCategory         string `json:"messageCategory"`
+	DeviceChannel           string `json:"deviceChannel"`
+	AcctNumber              string `json:"acctNumber"`
+	CardExpiryDate          string `json:"cardExpiryDate"` // YYMM
+	PurchaseAmount          string `json:"purchaseAmount"` // em centavos
+	PurchaseCurrency        string `json:"purchaseCurrency"`
+	PurchaseExponent        string `json:"purchaseExponent"`
+	PurchaseDate            string `json:"purchaseDate"` // YYYYMMDDHHMMSS (UTC)
+	Email                   string `json:"email,omitempty"`
+	CardholderName          string `json:"cardholderName,omitempty"`
+	BillAddrLine1           string `json:"billAddrLine1,omitempty"`
+	BillAddrCity            string `json:"billAddrCity,omitempty"`
+	BillAddrState           string `json:"billAddrState,omitempty"`
+	BillAddrPostCode        string `json:"billAddrPostCode,omitempty"`
+	BrowserUserAgent        string `json:"browserUserAgent,omitempty"`
+	NotificationURL         string `json:"notificationURL"`
+}
+
+type resultsRequest struct {
+	ThreeDSServerTransID string `json:"threeDSServerTransID"`
+}
+
+// serverResponse é a resposta de /authenticate e /results
+type serverResponse struct {
+	ThreeDSServerTransID string `json:"threeDSServerTransID"`
+	TransStatus          string `json:"transStatus"`
+	TransStatusReason    string `json:"transStatusReason"`
+	ECI                  string `json:"eci"`
+	AuthenticationValue  string `json:"authenticationValue"`
+	DSTransID            string `json:"dsTransID"`
+	MessageVersion       string `json:"messageVersion"`
+	ACSURL               string `json:"acsURL"`
+	CReq                 string `json:"creq"`
+	ErrorCode            string `json:"errorCode"`
+	ErrorDescription     string `json:"errorDescription"`
+}
+
+func (r *serverResponse) result() *Result {
+	result := &Result{
+		TransID:   r.ThreeDSServerTransID,
+		Status:    r.TransStatus,
+		ECI:       r.ECI,
+		CAVV:      r.AuthenticationValue,
+		DSTransID: r.DSTransID,
+		Version:   r.MessageVersion,
+		Reason:    r.TransStatusReason,
+	}
+	if r.TransStatus == StatusChallenge {
+		result.Challenge = &types.ThreeDSResponse{
+			AcsUrl:  r.ACSURL,
+			Payload: r.CReq,
+			TransID: r.ThreeDSServerTransID,
+		}
+	}
+	return result
+}
+
+// Lookup inicia a autenticação (AReq) de um cartão
+func (s *Server) Lookup(req *LookupRequest) (*Result, error) {
+	expiry, err := cardExpiryDate(req.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	body := authenticateRequest{
+		ThreeDSRequestorTransID: req.CheckoutID,
+		MessageCategory:         "01", // pagamento
+		DeviceChannel:           "02", // navegador
+		AcctNumber:              req.CardNumber,
+		CardExpiryDate:          expiry,
+		PurchaseAmount:          fmt.Sprintf("%d", int64(req.Amount*100+0.5)),
+		PurchaseCurrency:        purchaseCurrency(req.Currency),
+		PurchaseExponent:        "2",
+		PurchaseDate:            time.Now().UTC().Format("20060102150405"),
+		Email:                   req.Email,
+		NotificationURL:         s.notificationURL,
+	}
+	if req.Browser != nil {
+		body.MessageVersion = req.Browser.Version
+		body.BrowserUserAgent = req.Browser.Browser
+	}
+	if req.BillingInfo != nil {
+		body.CardholderName = strings.TrimSpace(req.BillingInfo.FirstName + " " + req.BillingInfo.LastName)
+		body.BillAddrLine1 = req.BillingInfo.Address
+		body.BillAddrCity = req.BillingInfo.City
+		body.BillAddrState = req.BillingInfo.State
+		body.BillAddrPostCode = req.BillingInfo.Zip
+	}
+
+	response, err := s.post("/authenticate", body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.result()
+	if result.Status == StatusChallenge && (result.Challenge.AcsUrl == "" || result.Challenge.Payload == "") {
+		return nil, fmt.Errorf("3DS server requested a challenge without ACS URL or CReq")
+	}
+
+	log.Printf("3DS lookup for checkout %s: transStatus=%s (3DS Server trans %s)", req.CheckoutID, result.Status, result.TransID)
+	return result, nil
+}
+
+// Authenticate confere o CRes do callback e busca o resultado final no 3DS Server
+func (s *Server) Authenticate(callback *types.ThreeDSCallback) (*Result, error) {
+	cres, err := decodeChallengeResponse(callback)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.post("/results", resultsRequest{ThreeDSServerTransID: callback.TransID})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.ThreeDSServerTransID != callback.TransID {
+		return nil, fmt.Errorf("3DS server returned results for transaction %s, not %s", response.ThreeDSServerTransID, callback.TransID)
+	}
+
+	result := response.result()
+	if result.Status != cres.TransStatus {
+		log.Printf("Warning: 3DS challenge %s: CRes transStatus %s differs from 3DS server result %s",
+			callback.TransID, cres.TransStatus, result.Status)
+	}
+
+	log.Printf("3DS challenge %s completed: transStatus=%s", callback.TransID, result.Status)
+	return result, nil
+}
+
+func (s *Server) post(path string, payload interface{}) (*serverResponse, error) {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling 3DS request: %v", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", s.baseURL+path, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating 3DS request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-API-Key", s.apiKey)
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling 3DS server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading 3DS server response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("3DS server returned HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var response serverResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("error decoding 3DS server response: %v", err)
+	}
+
+	if response.ErrorCode != "" {
+		return nil, fmt.Errorf("3DS server error %s: %s", response.ErrorCode, response.ErrorDescription)
+	}
+
+	return &response, nil
+}
+
+// cardExpiryDate converte MM/YY para o YYMM do EMV 3DS
+func cardExpiryDate(expiry string) (string, error) {
+	parsed, err := time.Parse("01/06", expiry)
+	if err != nil {
+		return "", fmt.Errorf("invalid card expiry %q", expiry)
+	}
+	return parsed.Format("0601"), nil
+}
+
+// purchaseCurrency retorna a moeda da compra; sem moeda informada vale USD
+func purchaseCurrency(numeric string) string {
+	if numeric == "" {
+		return "840"
+	}
+	return numeric
+}
@@ -0,0 +1,148 @@
+// services/threeds/threeds.go - Autenticação 3-D Secure (EMV 3DS 2.x) antes da autorização
+//
+// O fluxo é:
+//  1. Lookup envia os dados do cartão e do navegador ao 3DS Server. O resultado é
+//     frictionless (Y/A, já com ECI e CAVV), desafio (C, com a URL do ACS) ou recusa.
+//  2. No desafio o navegador é redirecionado ao ACS, que devolve o CRes para o callback.
+//  3. Authenticate confere o CRes e busca no 3DS Server o resultado final (RReq), que é
+//     a fonte confiável: o status enviado pelo navegador nunca é usado sozinho.
+package threeds
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"prosecure-payment-api/types"
+)
+
+// transStatus do EMV 3DS
+const (
+	StatusAuthenticated = "Y"
+	StatusAttempted     = "A"
+	StatusChallenge     = "C"
+	StatusFailed        = "N"
+	StatusUnavailable   = "U"
+	StatusRejected      = "R"
+)
+
+// Modos de exigência do 3DS (THREEDS_MODE)
+const (
+	ModeOff      = "off"      // nunca autenticar
+	ModeOptional = "optional" // autenticar quando o checkout envia threeDSData.enabled
+	ModeAlways   = "always"   // autenticar todo checkout com cartão
+)
+
+// Config define o 3DS Server. Sem ServerURL o 3DS fica desligado (exceto com o
+// gateway fake, que usa o provider em memória).
+type Config struct {
+	Mode            string
+	ServerURL       string
+	APIKey          string
+	NotificationURL string // URL pública do callback (POST /api/3ds/callback)
+}
+
+// LookupRequest são os dados enviados ao 3DS Server para iniciar a autenticação
+type LookupRequest struct {
+	CheckoutID  string
+	CardNumber  string
+	Expiry      string
+	Amount      float64
+	Currency    string // ISO 4217 numérico (ex.: "840" = USD)
+	Email       string
+	BillingInfo *types.BillingInfoType
+	Browser     *types.ThreeDSData
+}
+
+// Result é o resultado de uma etapa da autenticação
+type Result struct {
+	TransID   string // threeDSServerTransID, referência do callback
+	Status    string
+	ECI       string
+	CAVV      string
+	DSTransID string
+	Version   string
+	Reason    string // transStatusReason, quando houver
+
+	// Challenge vem preenchido quando Status == StatusChallenge
+	Challenge *types.ThreeDSResponse
+}
+
+// Authenticated indica se a autenticação permite seguir com a autorização
+// (autenticado ou tentativa registrada pelo Directory Server)
+func (r *Result) Authenticated() bool {
+	return r.Status == StatusAuthenticated || r.Status == StatusAttempted
+}
+
+// Authentication converte o resultado nos dados enviados junto com a autorização
+func (r *Result) Authentication() *types.ThreeDSAuthentication {
+	return &types.ThreeDSAuthentication{
+		Status:    r.Status,
+		ECI:       r.ECI,
+		CAVV:      r.CAVV,
+		DSTransID: r.DSTransID,
+		Version:   r.Version,
+	}
+}
+
+// Provider é o 3DS Server usado pelo checkout
+type Provider interface {
+	// Lookup inicia a autenticação de um cartão
+	Lookup(req *LookupRequest) (*Result, error)
+	// Authenticate confere o callback do ACS e retorna o resultado final do desafio
+	Authenticate(callback *types.ThreeDSCallback) (*Result, error)
+}
+
+// Required indica se o checkout deve passar pelo 3DS no modo configurado
+func Required(mode string, browser *types.ThreeDSData) bool {
+	switch mode {
+	case ModeAlways:
+		return true
+	case ModeOptional:
+		return browser != nil && browser.Enabled
+	}
+	return false
+}
+
+// challengeResponse é o CRes que o ACS envia (base64url) ao final do desafio
+type challengeResponse struct {
+	ThreeDSServerTransID string `json:"threeDSServerTransID"`
+	ACSTransID           string `json:"acsTransID"`
+	MessageType          string `json:"messageType"`
+	MessageVersion       string `json:"messageVersion"`
+	TransStatus          string `json:"transStatus"`
+}
+
+// decodeChallengeResponse decodifica o CRes do callback e confere se pertence à
+// transação informada
+func decodeChallengeResponse(callback *types.ThreeDSCallback) (*challengeResponse, error) {
+	if callback == nil || callback.TransID == "" || callback.Payload == "" {
+		return nil, fmt.Errorf("transId and payload are required")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(callback.Payload, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge response encoding: %v", err)
+	}
+
+	var cres challengeResponse
+	if err := json.Unmarshal(raw, &cres); err != nil {
+		return nil, fmt.Errorf("invalid challenge response: %v", err)
+	}
+
+	if cres.MessageType != "CRes" {
+		return nil, fmt.Errorf("unexpected message type %q in challenge response", cres.MessageType)
+	}
+	if cres.ThreeDSServerTransID != callback.TransID {
+		return nil, fmt.Errorf("challenge response belongs to transaction %s, not %s", cres.ThreeDSServerTransID, callback.TransID)
+	}
+
+	return &cres, nil
+}
+
+// encodeChallengeResponse monta um CRes em base64url (usado pelo provider fake)
+func encodeChallengeResponse(cres *challengeResponse) string {
+	raw, _ := json.Marshal(cres)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,491 @@
+// services/webhook/processor.go - Processamento das notificações da Authorize.net gravadas em webhook_events
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"prosecure-payment-api/database"
+	"prosecure-payment-api/models"
+	"prosecure-payment-api/queue"
+	"prosecure-payment-api/services/cardvault"
+	"prosecure-payment-api/services/payment/authorizenet"
+)
+
+// Origens das notificações gravadas na caixa de entrada
+const (
+	SourceSilentPost               = "silent_post"
+	SourceRelayResponse            = "relay_response"
+	SourceSubscriptionNotification = "subscription_notification"
+	SourceWebhookEvent             = "webhook_event"
+)
+
+type Processor struct {
+	db        *database.Connection
+	queue     *queue.Queue
+	cardVault *cardvault.Vault
+}
+
+func NewProcessor(db *database.Connection, q *queue.Queue, cv *cardvault.Vault) *Processor {
+	return &Processor{
+		db:        db,
+		queue:     q,
+		cardVault: cv,
+	}
+}
+
+// Process executa um evento da caixa de entrada. Um erro retornado faz o job ser
+// reprocessado pela fila.
+func (p *Processor) Process(event *database.WebhookEvent) error {
+	switch event.Source {
+	case SourceSilentPost, SourceRelayResponse:
+		form, err := url.ParseQuery(event.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid form payload: %v", err)
+		}
+		return p.processNotification(form.Get("x_trans_id"), form.Get("x_response_code"), form.Get("x_ref_id"))
+
+	case SourceSubscriptionNotification:
+		form, err := url.ParseQuery(event.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid form payload: %v", err)
+		}
+		return p.processSubscriptionNotification(form.Get("x_subscription_id"), form.Get("x_event_type"),
+			form.Get("x_trans_id"), form.Get("x_amount"))
+
+	case SourceWebhookEvent:
+		var webhookEvent authorizenet.WebhookEvent
+		if err := json.Unmarshal([]byte(event.Payload), &webhookEvent); err != nil {
+			return fmt.Errorf("invalid webhook event payload: %v", err)
+		}
+		return p.processWebhookEvent(&webhookEvent)
+
+	default:
+		return fmt.Errorf("unknown webhook event source: %s", event.Source)
+	}
+}
+
+// processNotification processa as notificações e enfileira jobs conforme necessário
+func (p *Processor) processNotification(transactionID, responseCode, checkoutID string) error {
+	// Apenas processa se a transação foi aprovada (código 1)
+	if responseCode != "1" {
+		log.Printf("Transaction %s not approved (response code %s). No background jobs will be queued.",
+			transactionID, responseCode)
+		return nil
+	}
+
+	// Criar um contexto com timeout para as operações
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Se o checkoutID não foi fornecido, tenta buscar do banco de dados
+	if checkoutID == "" {
+		err := p.db.GetDB().QueryRowContext(ctx,
+			"SELECT checkout_id FROM transactions WHERE transaction_id = ? LIMIT 1",
+			transactionID).Scan(&checkoutID)
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				log.Printf("Transaction %s not found in database", transactionID)
+				return nil
+			}
+			return fmt.Errorf("error finding checkout ID for transaction %s: %v", transactionID, err)
+		}
+	}
+
+	// Verificar se a transação já existe no banco de dados
+	var status string
+	var exists bool
+	err := p.db.GetDB().QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM transactions WHERE transaction_id = ?)",
+		transactionID).Scan(&exists)
+
+	if err != nil {
+		return fmt.Errorf("error checking transaction existence: %v", err)
+	}
+
+	if !exists {
+		log.Printf("Transaction %s not found in database. Adding as new transaction.", transactionID)
+
+		// Se a transação não existir no banco, buscar as informações do checkout
+		checkout, err := p.db.GetCheckoutData(checkoutID)
+		if err != nil {
+			return fmt.Errorf("error retrieving checkout data for ID %s: %v", checkoutID, err)
+		}
+
+		// Buscar o master_reference associado ao checkout (se existir)
+		var masterRef string
+		err = p.db.GetDB().QueryRowContext(ctx,
+			`SELECT reference_uuid FROM master_accounts
+			 WHERE username = ? AND email = ? LIMIT 1`,
+			checkout.Username, checkout.Email).Scan(&masterRef)
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				log.Printf("No master account found for checkout %s", checkoutID)
+				return nil
+			}
+			return fmt.Errorf("error retrieving master reference: %v", err)
+		}
+
+		// Registrar a transação no banco de dados
+		_, err = p.db.GetDB().ExecContext(ctx,
+			`INSERT INTO transactions (id, master_reference, checkout_id, amount, status, transaction_id, created_at)
+			 VALUES (UUID(), ?, ?, 1.00, 'authorized', ?, NOW())`,
+			masterRef, checkoutID, transactionID)
+
+		if err != nil {
+			return fmt.Errorf("error registering transaction in database: %v", err)
+		}
+
+		log.Printf("Successfully registered transaction %s for checkout %s", transactionID, checkoutID)
+	} else {
+		// Se a transação já existe, verificar o status
+		err := p.db.GetDB().QueryRowContext(ctx,
+			"SELECT status FROM transactions WHERE transaction_id = ?",
+			transactionID).Scan(&status)
+
+		if err != nil {
+			return fmt.Errorf("error checking transaction status: %v", err)
+		}
+
+		// Se a transação já foi processada (void ou falha), não processa novamente
+		if status != "authorized" {
+			log.Printf("Transaction %s has already been processed (status=%s). Skipping.",
+				transactionID, status)
+			return nil
+		}
+	}
+
+	log.Printf("Processing notification for transaction %s, checkout %s", transactionID, checkoutID)
+
+	// Enfileirar job para anular a transação (void)
+	err = p.queue.Enqueue(ctx, queue.JobTypeVoidTransaction, map[string]interface{}{
+		"transaction_id": transactionID,
+		"checkout_id":    checkoutID,
+	}, queue.WithIdempotencyKey("void_transaction:"+transactionID))
+
+	if err != nil {
+		return fmt.Errorf("error enqueueing void transaction job: %v", err)
+	}
+	log.Printf("Successfully queued void job for transaction %s", transactionID)
+
+	// Buscar os dados necessários para configurar a assinatura recorrente
+	checkout, err := p.db.GetCheckoutData(checkoutID)
+	if err != nil {
+		log.Printf("Error retrieving checkout data for ID %s: %v", checkoutID, err)
+		return nil
+	}
+
+	// Os dados do cartão ficam cifrados em temp_payment_data; o job de assinatura os lê
+	// de lá, então o payload do job não carrega dados de cartão
+	if _, err := p.cardVault.Load(checkoutID); err != nil {
+		log.Printf("Error retrieving payment data for checkout %s: %v", checkoutID, err)
+		return nil
+	}
+
+	// Enfileirar job para configurar assinatura recorrente
+	err = p.queue.Enqueue(ctx, queue.JobTypeCreateSubscription, map[string]interface{}{
+		"checkout_id":    checkoutID,
+		"transaction_id": transactionID,
+		"email":          checkout.Email,
+	}, queue.WithIdempotencyKey("create_subscription:"+checkoutID))
+
+	if err != nil {
+		log.Printf("Error enqueueing subscription job: %v", err)
+	} else {
+		log.Printf("Successfully queued subscription job for checkout %s", checkoutID)
+	}
+
+	return nil
+}
+
+// processSubscriptionNotification processa notificações relacionadas a assinaturas
+func (p *Processor) processSubscriptionNotification(subscriptionID, eventType, transactionID, amount string) error {
+	log.Printf("Processing subscription notification for %s: %s", subscriptionID, eventType)
+
+	// Atualizar o status da assinatura no banco de dados
+	var status string
+	switch eventType {
+	case "subscription_cancelled":
+		status = "cancelled"
+	case "subscription_suspended":
+		status = "suspended"
+	case "subscription_terminated":
+		status = "terminated"
+	case "subscription_expired":
+		status = "expired"
+	case "subscription_failed":
+		status = "failed"
+	case "subscription_successful":
+		status = "active"
+	default:
+		log.Printf("Unknown subscription event type: %s", eventType)
+		return nil
+	}
+
+	// Atualizar o status no banco de dados
+	if _, err := p.db.UpdateSubscriptionStatus(subscriptionID, status); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully updated subscription %s status to %s", subscriptionID, status)
+
+	switch eventType {
+	case "subscription_successful":
+		// A primeira cobrança aprovada converte o trial
+		p.endTrial(subscriptionID, func(masterRef string) (bool, error) {
+			value, _ := strconv.ParseFloat(amount, 64)
+			return p.db.ConvertTrial(masterRef, transactionID, value)
+		})
+	case "subscription_failed":
+		// Cobrança recorrente recusada: o dunning faz as retentativas e avisa o cliente
+		p.endTrial(subscriptionID, p.db.ChurnTrial)
+		return p.startDunning(subscriptionID, "recurring payment failed ("+eventType+")")
+	}
+
+	return nil
+}
+
+// endTrial encerra o trial da conta dona da assinatura, se ela ainda estiver em trial.
+// Falhas só são registradas: o status da assinatura já foi atualizado.
+func (p *Processor) endTrial(subscriptionID string, end func(masterRef string) (bool, error)) {
+	masterRef, err := p.db.GetMasterReferenceBySubscription(subscriptionID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error finding account for subscription %s: %v", subscriptionID, err)
+		}
+		return
+	}
+
+	if _, err := end(masterRef); err != nil {
+		log.Printf("Error ending trial of %s: %v", masterRef, err)
+	}
+}
+
+// startDunning enfileira a abertura do caso de dunning da assinatura. Notificações
+// duplicadas da mesma falha são descartadas na abertura do caso.
+func (p *Processor) startDunning(subscriptionID, failure string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := p.queue.Enqueue(ctx, queue.JobTypeStartDunning, map[string]interface{}{
+		"subscription_id": subscriptionID,
+		"failure":         failure,
+	})
+	if err != nil {
+		return fmt.Errorf("error enqueueing dunning for subscription %s: %v", subscriptionID, err)
+	}
+
+	log.Printf("Queued dunning for subscription %s", subscriptionID)
+	return nil
+}
+
+// processWebhookEvent direciona o evento da Webhooks API para o handler do tipo correspondente
+func (p *Processor) processWebhookEvent(event *authorizenet.WebhookEvent) error {
+	switch event.EventType {
+	case authorizenet.EventAuthCaptureCreated,
+		authorizenet.EventCaptureCreated,
+		authorizenet.EventPriorAuthCaptureCreated:
+		return p.handlePaymentCaptured(event)
+	case authorizenet.EventAuthorizationCreated:
+		return p.setTransactionStatus(event, "authorized")
+	case authorizenet.EventVoidCreated:
+		return p.setTransactionStatus(event, "voided")
+	case authorizenet.EventRefundCreated:
+		return p.handleRefundCreated(event)
+	case authorizenet.EventFraudHeld:
+		return p.setTransactionStatus(event, "held_for_review")
+	case authorizenet.EventFraudApproved:
+		return p.setTransactionStatus(event, "authorized")
+	case authorizenet.EventFraudDeclined:
+		return p.handlePaymentDeclined(event, "declined")
+	case authorizenet.EventSubscriptionCreated:
+		return p.handleSubscriptionEvent(event, "active", -1)
+	case authorizenet.EventSubscriptionUpdated:
+		return p.handleSubscriptionUpdated(event)
+	case authorizenet.EventSubscriptionSuspended:
+		return p.handleSubscriptionFailure(event, "suspended")
+	case authorizenet.EventSubscriptionFailed:
+		return p.handleSubscriptionFailure(event, "failed")
+	case authorizenet.EventSubscriptionTerminated:
+		return p.handleSubscriptionEvent(event, "terminated", -1)
+	case authorizenet.EventSubscriptionCancelled:
+		return p.handleSubscriptionEvent(event, "cancelled", -1)
+	case authorizenet.EventSubscriptionExpired:
+		return p.handleSubscriptionEvent(event, "expired", -1)
+	case authorizenet.EventSubscriptionExpiring:
+		// Apenas aviso - a assinatura continua ativa até expirar
+		log.Printf("Subscription %s is expiring soon", event.Payload.ID)
+		return nil
+	default:
+		log.Printf("Ignoring unsupported webhook event type: %s", event.EventType)
+		return nil
+	}
+}
+
+// handlePaymentCaptured marca a transação como capturada e o pagamento do usuário como sucesso
+func (p *Processor) handlePaymentCaptured(event *authorizenet.WebhookEvent) error {
+	if event.Payload.ResponseCode != 1 {
+		return p.handlePaymentDeclined(event, "declined")
+	}
+
+	if err := p.setTransactionStatus(event, "captured"); err != nil {
+		return err
+	}
+
+	email, username, err := p.db.GetMasterUserByTransaction(event.Payload.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("error finding account for transaction %s: %v", event.Payload.ID, err)
+	}
+
+	return p.db.SetPaymentProcessingSuccess(email, username)
+}
+
+// handlePaymentDeclined marca a transação como recusada e o usuário com erro de pagamento
+func (p *Processor) handlePaymentDeclined(event *authorizenet.WebhookEvent, status string) error {
+	if err := p.setTransactionStatus(event, status); err != nil {
+		return err
+	}
+
+	email, username, err := p.db.GetMasterUserByTransaction(event.Payload.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("error finding account for transaction %s: %v", event.Payload.ID, err)
+	}
+
+	return p.db.SetPaymentProcessingFailed(email, username)
+}
+
+// handleRefundCreated confirma o reembolso. O payload traz o ID da transação de reembolso,
+// que é vinculado à transação original na tabela refunds.
+func (p *Processor) handleRefundCreated(event *authorizenet.WebhookEvent) error {
+	log.Printf("Refund %s created for amount $%.2f", event.Payload.ID, event.Payload.AuthAmount)
+
+	if event.Payload.ID == "" {
+		return fmt.Errorf("event %s has no transaction ID", event.NotificationID)
+	}
+
+	rows, err := p.db.ConfirmRefundByTransaction(event.Payload.ID)
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		// Estornos feitos fora da API (ex.: pelo painel da Authorize.net) não têm registro local
+		log.Printf("Refund %s not found locally or already confirmed", event.Payload.ID)
+	}
+
+	return nil
+}
+
+// handleSubscriptionFailure marca a assinatura e o usuário master com erro de pagamento e
+// inicia o dunning
+func (p *Processor) handleSubscriptionFailure(event *authorizenet.WebhookEvent, status string) error {
+	if err := p.handleSubscriptionEvent(event, status, int(models.PaymentStatusFailed)); err != nil {
+		return err
+	}
+
+	p.endTrial(event.Payload.ID, p.db.ChurnTrial)
+	return p.startDunning(event.Payload.ID, "recurring payment "+status+" ("+event.EventType+")")
+}
+
+func (p *Processor) setTransactionStatus(event *authorizenet.WebhookEvent, status string) error {
+	if event.Payload.ID == "" {
+		return fmt.Errorf("event %s has no transaction ID", event.NotificationID)
+	}
+
+	rows, err := p.db.UpdateTransactionStatus(event.Payload.ID, status)
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		log.Printf("Transaction %s not found locally for event %s", event.Payload.ID, event.EventType)
+		return nil
+	}
+
+	log.Printf("Transaction %s status set to %s (%s)", event.Payload.ID, status, event.EventType)
+	return nil
+}
+
+// handleSubscriptionEvent atualiza o status da assinatura e, quando informado (>= 0),
+// o payment_status do usuário master dono da assinatura
+// handleSubscriptionUpdated registra o status informado pelo gateway. O evento chega
+// também em mudanças de valor ou de cartão: nunca reativa uma assinatura suspensa ou em
+// cobrança; só aplica localmente os status que encerram ou suspendem a ARB.
+func (p *Processor) handleSubscriptionUpdated(event *authorizenet.WebhookEvent) error {
+	subscriptionID := event.Payload.ID
+	if subscriptionID == "" {
+		return fmt.Errorf("event %s has no subscription ID", event.NotificationID)
+	}
+
+	gatewayStatus := strings.ToLower(event.Payload.Status)
+	if gatewayStatus == "" {
+		log.Printf("Subscription %s updated without status, nothing to refresh", subscriptionID)
+		return nil
+	}
+
+	if err := p.db.RecordSubscriptionGatewayStatus(subscriptionID, gatewayStatus); err != nil {
+		return err
+	}
+
+	switch gatewayStatus {
+	case authorizenet.SubscriptionStatusSuspended:
+		return p.handleSubscriptionEvent(event, "suspended", -1)
+	case authorizenet.SubscriptionStatusCanceled:
+		return p.handleSubscriptionEvent(event, "cancelled", -1)
+	case authorizenet.SubscriptionStatusTerminated:
+		return p.handleSubscriptionEvent(event, "terminated", -1)
+	case authorizenet.SubscriptionStatusExpired:
+		return p.handleSubscriptionEvent(event, "expired", -1)
+	}
+
+	log.Printf("Subscription %s updated (gateway status %s), local status unchanged", subscriptionID, gatewayStatus)
+	return nil
+}
+
+func (p *Processor) handleSubscriptionEvent(event *authorizenet.WebhookEvent, status string, paymentStatus int) error {
+	subscriptionID := event.Payload.ID
+	if subscriptionID == "" {
+		return fmt.Errorf("event %s has no subscription ID", event.NotificationID)
+	}
+
+	rows, err := p.db.UpdateSubscriptionStatus(subscriptionID, status)
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		log.Printf("Subscription %s not found locally for event %s", subscriptionID, event.EventType)
+		return nil
+	}
+
+	log.Printf("Subscription %s status set to %s (%s)", subscriptionID, status, event.EventType)
+
+	if paymentStatus < 0 {
+		return nil
+	}
+
+	email, username, err := p.db.GetMasterUserBySubscription(subscriptionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("error finding account for subscription %s: %v", subscriptionID, err)
+	}
+
+	return p.db.SetUserPaymentStatus(email, username, paymentStatus)
+}
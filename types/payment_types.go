@@ -31,4 +31,14 @@ type ThreeDSCallback struct {
     TransID  string `json:"transId"`
     Status   string `json:"status"`
     Payload  string `json:"payload"`
-}
\ No newline at end of file
+}
+
+// ThreeDSAuthentication é o resultado da autenticação 3D Secure enviado junto com a
+// autorização (ECI e CAVV comprovam a autenticação para o emissor)
+type ThreeDSAuthentication struct {
+    Status    string `json:"status"`              // transStatus do EMV 3DS (Y, A, N, U, R)
+    ECI       string `json:"eci,omitempty"`
+    CAVV      string `json:"cavv,omitempty"`
+    DSTransID string `json:"dsTransId,omitempty"` // ID da transação no Directory Server
+    Version   string `json:"version,omitempty"`
+}
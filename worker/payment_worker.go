@@ -3,6 +3,7 @@ package worker
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"fmt"
 	"log"
@@ -14,8 +15,15 @@ import (
 	"prosecure-payment-api/database"
 	"prosecure-payment-api/models"
 	"prosecure-payment-api/queue"
+	"prosecure-payment-api/services/cardvault"
+	"prosecure-payment-api/services/dunning"
 	"prosecure-payment-api/services/email"
 	"prosecure-payment-api/services/payment"
+	"prosecure-payment-api/services/payment/authorizenet"
+	"prosecure-payment-api/services/payment/fake"
+	"prosecure-payment-api/services/reconciliation"
+	"prosecure-payment-api/services/tax"
+	"prosecure-payment-api/services/webhook"
 	"prosecure-payment-api/types"
 	"prosecure-payment-api/utils"
 	"github.com/google/uuid"
@@ -27,17 +35,25 @@ type Worker struct {
 	db             *database.Connection
 	paymentService *payment.Service
 	emailService   *email.SMTPService
+	cardVault      *cardvault.Vault
+	webhooks       *webhook.Processor
+	reconciler     *reconciliation.Reconciler
+	dunning        *dunning.Engine
 	shutdown       chan struct{}
 	isRunning      bool
 }
 
 // NewWorker creates a new worker
-func NewWorker(q *queue.Queue, db *database.Connection, ps *payment.Service, es *email.SMTPService) *Worker {
+func NewWorker(q *queue.Queue, db *database.Connection, ps *payment.Service, es *email.SMTPService, cv *cardvault.Vault, dc dunning.Config) *Worker {
 	return &Worker{
 		queue:          q,
 		db:             db,
 		paymentService: ps,
 		emailService:   es,
+		cardVault:      cv,
+		webhooks:       webhook.NewProcessor(db, q, cv),
+		reconciler:     reconciliation.NewReconciler(db, ps),
+		dunning:        dunning.NewEngine(db, ps, es, dc),
 		shutdown:       make(chan struct{}),
 	}
 }
@@ -53,9 +69,594 @@ func (w *Worker) Start(concurrency int) {
 	// Start a goroutine to process delayed jobs
 	go w.processDelayedJobs()
 	
+	// Start a goroutine to requeue jobs whose worker died while processing them
+	go w.reapExpiredJobs()
+	
+	// Start a goroutine to schedule the daily settlement reconciliation
+	go w.scheduleReconciliation()
+	
+	// Start a goroutine to sweep expired temporary payment data
+	go w.scheduleTempPaymentDataSweep()
+	
+	// Start a goroutine to end cancelled subscriptions whose billing period is over
+	go w.scheduleCancellationCompletion()
+	
+	// Start a goroutine to restore subscription amounts after account credits are used
+	go w.scheduleAccountCreditApplication()
+	
+	// Start a goroutine to remind and resume paused subscriptions
+	go w.scheduleSubscriptionResumes()
+	
+	// Start a goroutine to retry failed recurring payments
+	go w.scheduleDunning()
+	
+	// Start a goroutine to remind customers whose trial is about to end
+	go w.scheduleTrialReminders()
+	
+	// Start a goroutine to correct subscription statuses that drifted from the gateway
+	go w.scheduleSubscriptionSync()
+	
 	log.Printf("Started %d worker goroutines and delayed job processor", concurrency)
 }
 
+// Hora (UTC) a partir da qual o dia anterior é conciliado. Os lotes da Authorize.net
+// são liquidados de madrugada no horário do Pacífico.
+const reconciliationHourUTC = 10
+
+// scheduleReconciliation enfileira a conciliação do dia anterior uma vez por dia.
+// O relatório é criado antes de enfileirar, então várias instâncias não duplicam o job.
+func (w *Worker) scheduleReconciliation() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	
+	for {
+		now := time.Now().UTC()
+		if now.Hour() >= reconciliationHourUTC {
+			w.enqueueReconciliation(now.AddDate(0, 0, -1))
+		}
+		
+		select {
+		case <-w.shutdown:
+			log.Println("Reconciliation scheduler shutting down")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) enqueueReconciliation(day time.Time) {
+	report, isNew, err := w.db.CreateReconciliationReport(day)
+	if err != nil {
+		log.Printf("Error creating reconciliation report for %s: %v", day.Format("2006-01-02"), err)
+		return
+	}
+	if !isNew {
+		return
+	}
+	
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	
+	if err := w.queue.Enqueue(ctx, queue.JobTypeReconcileSettlement, map[string]interface{}{
+		"report_id": report.ID,
+	}, queue.WithIdempotencyKey(fmt.Sprintf("reconcile_settlement:%d", report.ID))); err != nil {
+		log.Printf("Error enqueueing reconciliation report %d: %v", report.ID, err)
+	}
+}
+
+// Intervalo entre as varreduras de temp_payment_data
+const tempPaymentDataSweepInterval = 10 * time.Minute
+
+// scheduleTempPaymentDataSweep enfileira periodicamente a remoção dos dados de cartão
+// temporários que passaram do TTL. Jobs duplicados entre instâncias são inofensivos.
+func (w *Worker) scheduleTempPaymentDataSweep() {
+	ticker := time.NewTicker(tempPaymentDataSweepInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Temporary payment data sweeper shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeSweepPaymentData, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing temporary payment data sweep: %v", err)
+			}
+		}
+	}
+}
+
+// processSweepPaymentDataJob apaga definitivamente os dados de cartão expirados
+func (w *Worker) processSweepPaymentDataJob(job *queue.Job) error {
+	if _, err := w.cardVault.Sweep(); err != nil {
+		return fmt.Errorf("failed to sweep temporary payment data: %v", err)
+	}
+	return nil
+}
+
+// Intervalo entre as verificações de cancelamentos cujo período pago terminou
+const cancellationCompletionInterval = time.Hour
+
+// Máximo de cancelamentos concluídos por job
+const cancellationCompletionBatchSize = 100
+
+// scheduleCancellationCompletion enfileira periodicamente a conclusão dos cancelamentos
+// agendados. A conclusão é idempotente, então jobs duplicados entre instâncias são inofensivos.
+func (w *Worker) scheduleCancellationCompletion() {
+	ticker := time.NewTicker(cancellationCompletionInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Cancellation completion scheduler shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeCompleteCancellations, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing cancellation completion: %v", err)
+			}
+		}
+	}
+}
+
+// processCompleteCancellationsJob desativa os usuários das contas canceladas cujo
+// renew_date já passou
+func (w *Worker) processCompleteCancellationsJob(job *queue.Job) error {
+	cancellations, err := w.db.GetDueCancellations(cancellationCompletionBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list due cancellations: %v", err)
+	}
+	
+	var failed int
+	for i := range cancellations {
+		if _, err := w.db.CompleteSubscriptionCancellation(&cancellations[i]); err != nil {
+			log.Printf("Error completing cancellation %d for %s: %v",
+				cancellations[i].ID, cancellations[i].MasterReference, err)
+			failed++
+		}
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("failed to complete %d of %d cancellations", failed, len(cancellations))
+	}
+	return nil
+}
+
+// Intervalo entre as verificações de créditos de conta já abatidos
+const accountCreditInterval = time.Hour
+
+// Máximo de contas processadas por job de créditos
+const accountCreditBatchSize = 100
+
+// scheduleAccountCreditApplication enfileira periodicamente a baixa dos créditos de conta
+// cujo ciclo já foi cobrado
+func (w *Worker) scheduleAccountCreditApplication() {
+	ticker := time.NewTicker(accountCreditInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Account credit scheduler shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeApplyAccountCredits, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing account credit application: %v", err)
+			}
+		}
+	}
+}
+
+// processApplyAccountCreditsJob devolve a ARB ao valor cheio (menos o crédito do ciclo
+// seguinte) das contas cujo ciclo com crédito já passou, e só então dá baixa nos créditos.
+// Se a ARB não puder ser atualizada o crédito continua agendado e o job tenta de novo.
+func (w *Worker) processApplyAccountCreditsJob(job *queue.Job) error {
+	today := time.Now().Truncate(24 * time.Hour)
+	
+	masterRefs, err := w.db.GetAccountsWithAppliedCycles(today, accountCreditBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list account credits: %v", err)
+	}
+	
+	var failed int
+	for _, masterRef := range masterRefs {
+		if err := w.restoreSubscriptionAmount(masterRef); err != nil {
+			log.Printf("Error restoring subscription amount for %s: %v", masterRef, err)
+			failed++
+			continue
+		}
+		
+		if _, err := w.db.MarkAccountCreditsApplied(masterRef, today); err != nil {
+			log.Printf("Error marking account credits applied for %s: %v", masterRef, err)
+			failed++
+		}
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("failed to apply account credits for %d of %d accounts", failed, len(masterRefs))
+	}
+	return nil
+}
+
+func (w *Worker) restoreSubscriptionAmount(masterRef string) error {
+	subscriptionID, err := w.db.GetActiveSubscriptionID(masterRef)
+	if err == sql.ErrNoRows {
+		// Sem assinatura ativa (ex.: cancelada) não há valor a restaurar
+		return nil
+	} else if err != nil {
+		return err
+	}
+	
+	totalPrice, err := w.db.GetAccountTotalPrice(masterRef)
+	if err != nil {
+		return err
+	}
+	
+	credit, err := w.db.GetUpcomingCreditTotal(masterRef)
+	if err != nil {
+		return err
+	}
+	
+	rate, err := w.db.GetAccountTaxRate(masterRef)
+	if err != nil {
+		return err
+	}
+	
+	return w.paymentService.UpdateSubscriptionAmount(subscriptionID, rate.Gross(totalPrice-credit))
+}
+
+// processSyncSubscriptionAmountJob acerta o valor da ARB de uma conta cuja alteração
+// falhou na requisição. O valor é recalculado do estado atual da conta.
+func (w *Worker) processSyncSubscriptionAmountJob(job *queue.Job) error {
+	masterRef, ok := job.Data["master_reference"].(string)
+	if !ok || masterRef == "" {
+		return queue.Permanent(fmt.Errorf("invalid master_reference in subscription amount job data"))
+	}
+	
+	if err := w.restoreSubscriptionAmount(masterRef); err != nil {
+		return fmt.Errorf("failed to sync subscription amount for %s: %v", masterRef, err)
+	}
+	
+	log.Printf("Subscription amount synced for %s", masterRef)
+	return nil
+}
+
+// processCancelSubscriptionJob cancela uma ARB substituída (troca de intervalo, pausa)
+// cujo cancelamento falhou na requisição, para a conta não ser cobrada duas vezes
+func (w *Worker) processCancelSubscriptionJob(job *queue.Job) error {
+	subscriptionID, ok := job.Data["subscription_id"].(string)
+	if !ok || subscriptionID == "" {
+		return queue.Permanent(fmt.Errorf("invalid subscription_id in cancel subscription job data"))
+	}
+	masterRef, _ := job.Data["master_reference"].(string)
+	
+	// Uma tentativa anterior pode ter cancelado no gateway sem receber a resposta
+	status, err := w.paymentService.GetSubscriptionStatus(subscriptionID)
+	if err == nil && status == authorizenet.SubscriptionStatusCanceled {
+		log.Printf("Subscription %s of %s already cancelled", subscriptionID, masterRef)
+		return nil
+	}
+	
+	if err := w.paymentService.CancelSubscription(subscriptionID); err != nil {
+		return fmt.Errorf("failed to cancel subscription %s of %s: %v", subscriptionID, masterRef, err)
+	}
+	
+	log.Printf("Subscription %s of %s cancelled", subscriptionID, masterRef)
+	return nil
+}
+
+// Intervalo entre as verificações de pausas de assinatura
+const subscriptionResumeInterval = time.Hour
+
+// Antecedência do aviso de retomada enviado ao cliente
+const subscriptionResumeReminder = 3 * 24 * time.Hour
+
+// Máximo de pausas processadas por job
+const subscriptionResumeBatchSize = 100
+
+// scheduleSubscriptionResumes enfileira periodicamente os avisos e as retomadas das
+// assinaturas pausadas
+func (w *Worker) scheduleSubscriptionResumes() {
+	ticker := time.NewTicker(subscriptionResumeInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Subscription resume scheduler shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeResumeSubscriptions, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing subscription resumes: %v", err)
+			}
+		}
+	}
+}
+
+// processResumeSubscriptionsJob avisa os clientes cuja pausa termina em breve e retoma as
+// pausas vencidas. A ARB já foi criada com início em renew_date na pausa; aqui só os
+// usuários voltam a ficar ativos.
+func (w *Worker) processResumeSubscriptionsJob(job *queue.Job) error {
+	reminders, err := w.db.GetPausesNeedingReminder(subscriptionResumeReminder, subscriptionResumeBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list pause reminders: %v", err)
+	}
+	
+	for i := range reminders {
+		pause := &reminders[i]
+		// Pausas já vencidas são retomadas abaixo e recebem o aviso de retomada
+		if !pause.ResumeAt.After(time.Now()) {
+			continue
+		}
+		
+		if err := w.sendPauseEmail(pause, false); err != nil {
+			log.Printf("Error sending resume reminder for pause %d: %v", pause.ID, err)
+			continue
+		}
+		
+		if err := w.db.MarkPauseReminderSent(pause.ID); err != nil {
+			log.Printf("Error marking resume reminder for pause %d: %v", pause.ID, err)
+		}
+	}
+	
+	pauses, err := w.db.GetDuePauses(subscriptionResumeBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list due pauses: %v", err)
+	}
+	
+	var failed int
+	for i := range pauses {
+		pause := &pauses[i]
+		if err := w.db.ResumeSubscriptionPause(pause, "", pause.RenewDate); err != nil {
+			log.Printf("Error resuming pause %d for %s: %v", pause.ID, pause.MasterReference, err)
+			failed++
+			continue
+		}
+		
+		log.Printf("Resumed paused subscription for %s (next billing %s)",
+			pause.MasterReference, pause.RenewDate.Format("2006-01-02"))
+		
+		if err := w.sendPauseEmail(pause, true); err != nil {
+			log.Printf("Warning: Failed to send resume email for pause %d: %v", pause.ID, err)
+		}
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("failed to resume %d of %d paused subscriptions", failed, len(pauses))
+	}
+	return nil
+}
+
+// sendPauseEmail envia o aviso de retomada próxima ou a confirmação da retomada
+func (w *Worker) sendPauseEmail(pause *database.SubscriptionPause, resumed bool) error {
+	email, username, err := w.db.GetMasterUserByReference(pause.MasterReference)
+	if err != nil {
+		return fmt.Errorf("failed to get master user: %v", err)
+	}
+	
+	subject := "Your Subscription Resumes Soon - ProSecureLSP"
+	title := "Your Subscription Resumes Soon"
+	message := fmt.Sprintf("Your subscription pause ends on <strong>%s</strong>. Your users get their access back on that date.",
+		pause.ResumeAt.Format("January 2, 2006"))
+	if resumed {
+		subject = "Your Subscription Has Resumed - ProSecureLSP"
+		title = "Welcome Back!"
+		message = "Your subscription pause has ended and your users have their access back."
+	}
+	
+	body := fmt.Sprintf(`
+		<div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+			<img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+			<h1 style="color:#fff">%s</h1>
+			<div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+				Hi %s,<br><br>
+				%s<br><br>
+				<strong>Next Billing Date:</strong> %s
+			</div>
+			<a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+			   href="https://prosecurelsp.com/users">
+				<strong>Login to Your Account</strong>
+			</a>
+		</div>
+	`, title, username, message, pause.RenewDate.Format("January 2, 2006"))
+	
+	return w.emailService.SendEmail(email, subject, body)
+}
+
+// Intervalo entre as verificações de retentativas de dunning
+const dunningInterval = 15 * time.Minute
+
+// scheduleDunning enfileira periodicamente as retentativas vencidas. Cada caso é reservado
+// antes da cobrança, então jobs duplicados entre instâncias são inofensivos.
+func (w *Worker) scheduleDunning() {
+	ticker := time.NewTicker(dunningInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Dunning scheduler shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeProcessDunning, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing dunning retries: %v", err)
+			}
+		}
+	}
+}
+
+// processStartDunningJob abre o caso de dunning de uma assinatura recusada
+func (w *Worker) processStartDunningJob(job *queue.Job) error {
+	subscriptionID, ok := job.Data["subscription_id"].(string)
+	if !ok || subscriptionID == "" {
+		return queue.Permanent(fmt.Errorf("invalid subscription_id in dunning job data"))
+	}
+	failure, _ := job.Data["failure"].(string)
+	
+	return w.dunning.Start(subscriptionID, failure)
+}
+
+// processDunningJob faz as retentativas de dunning vencidas
+func (w *Worker) processDunningJob(job *queue.Job) error {
+	return w.dunning.ProcessDue()
+}
+
+// Intervalo entre as verificações de trials perto do fim
+const trialReminderInterval = time.Hour
+
+// Antecedência do aviso de fim do trial
+const trialReminderLead = 3 * 24 * time.Hour
+
+// Máximo de avisos de fim do trial enviados por job
+const trialReminderBatchSize = 100
+
+// scheduleTrialReminders enfileira periodicamente os avisos de fim do trial
+func (w *Worker) scheduleTrialReminders() {
+	ticker := time.NewTicker(trialReminderInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Trial reminder scheduler shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeTrialReminders, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing trial reminders: %v", err)
+			}
+		}
+	}
+}
+
+// processTrialRemindersJob avisa os clientes cujo trial termina em breve, com o valor da
+// primeira cobrança da ARB
+func (w *Worker) processTrialRemindersJob(job *queue.Job) error {
+	trials, err := w.db.GetTrialsNeedingReminder(trialReminderLead, trialReminderBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to list trials ending soon: %v", err)
+	}
+	
+	var failed int
+	for i := range trials {
+		trial := &trials[i]
+		
+		credit, err := w.db.GetUpcomingCreditTotal(trial.MasterReference)
+		if err != nil {
+			log.Printf("Error getting account credits for %s: %v", trial.MasterReference, err)
+			failed++
+			continue
+		}
+		
+		rate, err := w.db.GetAccountTaxRate(trial.MasterReference)
+		if err != nil {
+			log.Printf("Error getting tax rate for %s: %v", trial.MasterReference, err)
+			failed++
+			continue
+		}
+		
+		amount := rate.Gross(trial.TotalPrice - credit)
+		
+		if err := w.sendTrialReminderEmail(trial, amount); err != nil {
+			log.Printf("Error sending trial reminder to %s: %v", trial.MasterReference, err)
+			failed++
+			continue
+		}
+		
+		if err := w.db.MarkTrialReminderSent(trial.MasterReference); err != nil {
+			log.Printf("Error marking trial reminder for %s: %v", trial.MasterReference, err)
+		}
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("failed to send %d of %d trial reminders", failed, len(trials))
+	}
+	return nil
+}
+
+// sendTrialReminderEmail avisa o fim do trial e o valor que será cobrado
+func (w *Worker) sendTrialReminderEmail(trial *database.TrialReminder, amount float64) error {
+	cycle := "month"
+	if trial.IsAnnually {
+		cycle = "year"
+	}
+	
+	body := fmt.Sprintf(`
+		<div style="text-align: center; background-color: #2C3E50; padding: 50px;">
+			<img src="https://www.prosecurelsp.com/images/logo.png" style="padding-bottom: 30px"/>
+			<h1 style="color:#fff">Your Free Trial Ends Soon</h1>
+			<div style="color:#fff; font-size: 16px; line-height: 1.6; margin: 20px 0;">
+				Hi %s,<br><br>
+				Your ProSecureLSP free trial ends on <strong>%s</strong>.
+				Your subscription will continue automatically and your card on file will be charged on that date.<br><br>
+				<strong>Upcoming Charge:</strong> %s per %s
+			</div>
+			<a style="color:#fff; padding: 15px 30px; background-color: #28a745; text-decoration: none; border-radius: 5px; display: inline-block; margin-top: 20px;"
+			   href="https://prosecurelsp.com/users">
+				<strong>Manage Your Subscription</strong>
+			</a>
+		</div>
+	`, trial.Username, trial.EndsAt.Format("January 2, 2006"), models.FormatMoney(amount, trial.Currency), cycle)
+	
+	return w.emailService.SendEmail(trial.Email, "Your Free Trial Ends Soon - ProSecureLSP", body)
+}
+
+// Intervalo entre as conferências de status das assinaturas com a ARB
+const subscriptionSyncInterval = 6 * time.Hour
+
+// Máximo de assinaturas conferidas por job (uma chamada à ARB por assinatura)
+const subscriptionSyncBatchSize = 200
+
+// scheduleSubscriptionSync enfileira periodicamente a conferência das assinaturas com a ARB
+func (w *Worker) scheduleSubscriptionSync() {
+	ticker := time.NewTicker(subscriptionSyncInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Subscription sync scheduler shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := w.queue.Enqueue(ctx, queue.JobTypeSyncSubscriptions, map[string]interface{}{})
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error enqueueing subscription sync: %v", err)
+			}
+		}
+	}
+}
+
+// processSyncSubscriptionsJob corrige as assinaturas cujo status local divergiu da ARB
+func (w *Worker) processSyncSubscriptionsJob(job *queue.Job) error {
+	return w.reconciler.ReconcileSubscriptions(subscriptionSyncBatchSize)
+}
+
 // processDelayedJobs periodically checks for delayed jobs that are ready to be processed
 func (w *Worker) processDelayedJobs() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -78,6 +679,65 @@ func (w *Worker) processDelayedJobs() {
 	}
 }
 
+// Intervalo entre as buscas por jobs com lease vencido em :processing
+const reapInterval = 30 * time.Second
+
+// reapExpiredJobs devolve periodicamente para a fila os jobs de workers que morreram
+// ou travaram no meio do processamento
+func (w *Worker) reapExpiredJobs() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-w.shutdown:
+			log.Println("Expired job reaper shutting down")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			requeued, err := w.queue.RequeueExpired(ctx)
+			cancel()
+			
+			if err != nil {
+				log.Printf("Error requeueing expired jobs: %v", err)
+			} else if requeued > 0 {
+				log.Printf("Requeued %d job(s) with expired lease", requeued)
+			}
+		}
+	}
+}
+
+// keepLease renova o lease do job até a função retornada ser chamada
+func (w *Worker) keepLease(job *queue.Job) (stop func()) {
+	done := make(chan struct{})
+	
+	go func() {
+		ticker := time.NewTicker(queue.HeartbeatInterval)
+		defer ticker.Stop()
+		
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				err := w.queue.Heartbeat(ctx, job)
+				cancel()
+				
+				if err == queue.ErrLeaseLost {
+					log.Printf("Warning: Lease of job %s lost while processing, it may run twice", job.ID)
+					return
+				}
+				if err != nil {
+					log.Printf("Warning: %v", err)
+				}
+			}
+		}
+	}()
+	
+	return func() { close(done) }
+}
+
 // Stop signals the worker to stop processing jobs
 func (w *Worker) Stop() {
 	if !w.isRunning {
@@ -117,8 +777,10 @@ func (w *Worker) processJobs(workerID int) {
 			
 			log.Printf("Worker %d processing job %s of type %s (retry %d)", workerID, job.ID, job.Type, job.RetryCount)
 			
-			// Process the job
+			// Process the job, keeping its lease alive meanwhile
+			stopLease := w.keepLease(job)
 			jobErr := w.processJob(job)
+			stopLease()
 			if jobErr != nil {
 				log.Printf("Worker %d: Error processing job %s: %v", workerID, job.ID, jobErr)
 				
@@ -144,48 +806,156 @@ func (w *Worker) processJobs(workerID int) {
 			}
 		}
 	}
-}
+}
+
+// processJob processes a single job
+func (w *Worker) processJob(job *queue.Job) error {
+	switch job.Type {
+	case queue.JobTypeVoidTransaction:
+		return w.processVoidTransaction(job)
+	case queue.JobTypeCreateSubscription:
+		return w.processCreateSubscription(job)
+	case queue.JobTypeProcessPayment:
+		return w.processPaymentJob(job)
+	case queue.JobTypeCreateAccount:
+		return w.processCreateAccountJob(job)
+	case queue.JobTypeDelayedPayment:
+		return w.processDelayedPaymentJob(job)
+    case queue.JobTypeActivationEmail:  
+		return w.processActivationEmailJob(job)
+	case queue.JobTypeWebhookEvent:
+		return w.processWebhookEventJob(job)
+	case queue.JobTypeReconcileSettlement:
+		return w.processReconcileSettlementJob(job)
+	case queue.JobTypeSweepPaymentData:
+		return w.processSweepPaymentDataJob(job)
+	case queue.JobTypeCompleteCancellations:
+		return w.processCompleteCancellationsJob(job)
+	case queue.JobTypeApplyAccountCredits:
+		return w.processApplyAccountCreditsJob(job)
+	case queue.JobTypeResumeSubscriptions:
+		return w.processResumeSubscriptionsJob(job)
+	case queue.JobTypeStartDunning:
+		return w.processStartDunningJob(job)
+	case queue.JobTypeProcessDunning:
+		return w.processDunningJob(job)
+	case queue.JobTypeTrialReminders:
+		return w.processTrialRemindersJob(job)
+	case queue.JobTypeSyncSubscriptions:
+		return w.processSyncSubscriptionsJob(job)
+	case queue.JobTypeSyncSubscriptionAmount:
+		return w.processSyncSubscriptionAmountJob(job)
+	case queue.JobTypeCancelSubscription:
+		return w.processCancelSubscriptionJob(job)
+	default:
+		return queue.Permanent(fmt.Errorf("unknown job type: %s", job.Type))
+	}
+}
+
+// processWebhookEventJob processa um evento da caixa de entrada webhook_events.
+// O evento é reivindicado antes do processamento para garantir execução única.
+func (w *Worker) processWebhookEventJob(job *queue.Job) error {
+	eventIDFloat, ok := job.Data["event_id"].(float64)
+	if !ok {
+		return queue.Permanent(fmt.Errorf("invalid event_id in webhook event job data"))
+	}
+	eventID := int64(eventIDFloat)
+
+	claimed, err := w.db.ClaimWebhookEvent(eventID)
+	if err != nil {
+		return err
+	}
+	event, err := w.db.GetWebhookEvent(eventID)
+	if err != nil {
+		return err
+	}
+
+	if !claimed {
+		// Em andamento em outro worker: tentar de novo mais tarde, quando ele terminar
+		// ou quando a reivindicação vencer
+		if event.Status == database.WebhookEventProcessing {
+			return fmt.Errorf("webhook event %d is being processed by another worker", eventID)
+		}
+		log.Printf("Webhook event %d already processed, skipping", eventID)
+		return nil
+	}
+
+	log.Printf("Processing webhook event %d (%s/%s, attempt %d)", event.ID, event.Source, event.EventType, event.Attempts)
+
+	if err := w.webhooks.Process(event); err != nil {
+		if markErr := w.db.MarkWebhookEventFailed(eventID, err.Error()); markErr != nil {
+			log.Printf("Error marking webhook event %d as failed: %v", eventID, markErr)
+		}
+		return fmt.Errorf("webhook event %d failed: %v", eventID, err)
+	}
+
+	if err := w.db.MarkWebhookEventProcessed(eventID); err != nil {
+		log.Printf("Error marking webhook event %d as processed: %v", eventID, err)
+	}
+
+	log.Printf("Webhook event %d processed successfully", eventID)
+	return nil
+}
+
+// processReconcileSettlementJob gera o relatório de conciliação dos lotes liquidados
+func (w *Worker) processReconcileSettlementJob(job *queue.Job) error {
+	reportIDFloat, ok := job.Data["report_id"].(float64)
+	if !ok {
+		return queue.Permanent(fmt.Errorf("invalid report_id in reconciliation job data"))
+	}
+	reportID := int64(reportIDFloat)
+
+	claimed, err := w.db.ClaimReconciliationReport(reportID)
+	if err != nil {
+		return err
+	}
+
+	report, err := w.db.GetReconciliationReport(reportID)
+	if err != nil {
+		return err
+	}
+
+	if !claimed {
+		// Em execução em outro worker: tentar de novo quando ele terminar ou a execução vencer
+		if report.Status == database.ReconciliationRunning {
+			return fmt.Errorf("reconciliation report %d is being generated by another worker", reportID)
+		}
+		log.Printf("Reconciliation report %d already completed, skipping", reportID)
+		return nil
+	}
 
-// processJob processes a single job
-func (w *Worker) processJob(job *queue.Job) error {
-	switch job.Type {
-	case queue.JobTypeVoidTransaction:
-		return w.processVoidTransaction(job)
-	case queue.JobTypeCreateSubscription:
-		return w.processCreateSubscription(job)
-	case queue.JobTypeProcessPayment:
-		return w.processPaymentJob(job)
-	case queue.JobTypeCreateAccount:
-		return w.processCreateAccountJob(job)
-	case queue.JobTypeDelayedPayment:
-		return w.processDelayedPaymentJob(job)
-    case queue.JobTypeActivationEmail:  
-		return w.processActivationEmailJob(job)
-	default:
-		return fmt.Errorf("unknown job type: %s", job.Type)
+	log.Printf("Reconciling settlements for %s (report %d)", report.ReportDate, report.ID)
+
+	if err := w.reconciler.Run(report); err != nil {
+		if markErr := w.db.FailReconciliationReport(reportID, err.Error()); markErr != nil {
+			log.Printf("Error marking reconciliation report %d as failed: %v", reportID, markErr)
+		}
+		return fmt.Errorf("reconciliation report %d failed: %v", reportID, err)
 	}
+
+	return nil
 }
 
 func (w *Worker) processActivationEmailJob(job *queue.Job) error {
 	// Extrair dados do job
 	username, ok := job.Data["username"].(string)
 	if !ok || username == "" {
-		return fmt.Errorf("invalid username in activation email job data")
+		return queue.Permanent(fmt.Errorf("invalid username in activation email job data"))
 	}
 
 	email, ok := job.Data["email"].(string)
 	if !ok || email == "" {
-		return fmt.Errorf("invalid email in activation email job data")
+		return queue.Permanent(fmt.Errorf("invalid email in activation email job data"))
 	}
 
 	customerName, ok := job.Data["customer_name"].(string)
 	if !ok || customerName == "" {
-		return fmt.Errorf("invalid customer_name in activation email job data")
+		return queue.Permanent(fmt.Errorf("invalid customer_name in activation email job data"))
 	}
 
 	activationURL, ok := job.Data["activation_url"].(string)
 	if !ok || activationURL == "" {
-		return fmt.Errorf("invalid activation_url in activation email job data")
+		return queue.Permanent(fmt.Errorf("invalid activation_url in activation email job data"))
 	}
 
 	requestID, _ := job.Data["request_id"].(string)
@@ -251,7 +1021,7 @@ func (w *Worker) processActivationEmailJob(job *queue.Job) error {
 func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
     checkoutID, ok := job.Data["checkout_id"].(string)
     if !ok || checkoutID == "" {
-        return fmt.Errorf("invalid checkout_id in job data")
+        return queue.Permanent(fmt.Errorf("invalid checkout_id in job data"))
     }
     
     requestID, _ := job.Data["request_id"].(string)
@@ -285,73 +1055,26 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
         return fmt.Errorf("failed to get checkout data: %v", err)
     }
     
-    // CORREÇÃO: Melhorar a busca de dados de pagamento temporários com window maior
-    var cardNumber, cardExpiry, cardCVV, cardName string
-    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-    defer cancel()
-    
-    // CORREÇÃO: Aumentar window de busca e adicionar fallbacks
-    searchWindows := []string{
-        "2 HOUR",   // 2 horas (padrão)
-        "4 HOUR",   // 4 horas (para casos de atraso)
-        "8 HOUR",   // 8 horas (para casos extremos)
-        "24 HOUR",  // 24 horas (último recurso)
-    }
-    
-    var paymentDataFound bool
-    for _, window := range searchWindows {
-        err = w.db.GetDB().QueryRowContext(ctx, 
-            fmt.Sprintf(`SELECT card_number, card_expiry, card_cvv, card_name 
-             FROM temp_payment_data 
-             WHERE checkout_id = ? 
-             AND created_at > NOW() - INTERVAL %s
-             ORDER BY created_at DESC
-             LIMIT 1`, window),
-            checkoutID).Scan(&cardNumber, &cardExpiry, &cardCVV, &cardName)
-        
-        if err == nil {
-            paymentDataFound = true
-            log.Printf("[RequestID: %s] Payment data found within %s window", requestID, window)
-            break
-        }
-    }
-    
-    if !paymentDataFound {
+    // Buscar os dados de pagamento temporários (cifrados, válidos até o TTL)
+    paymentData, err := w.cardVault.Load(checkoutID)
+    if err != nil {
         log.Printf("[RequestID: %s] Failed to retrieve payment data: %v", requestID, err)
-        
-        // CORREÇÃO: Verificar se é a última tentativa de forma mais precisa
         isLastAttempt := w.isLastAttempt(job)
-        
-        // CORREÇÃO: Tentar buscar dados de um job anterior para este checkout
-        cardNumber, cardExpiry, cardCVV, cardName, err = w.tryRecoverPaymentDataFromJobHistory(checkoutID)
-        if err != nil {
-            log.Printf("[RequestID: %s] Could not recover payment data from job history: %v", requestID, err)
-            return w.handlePaymentFailure(checkout, requestID, "Payment data not found or expired", isLastAttempt)
-        }
-        
-        log.Printf("[RequestID: %s] Successfully recovered payment data from job history", requestID)
+        return w.handlePaymentFailure(checkout, requestID, "Payment data not found or expired", isLastAttempt)
     }
     
-    // CORREÇÃO: Atualizar TTL dos dados de pagamento para próximas tentativas
-    _, err = w.db.GetDB().ExecContext(ctx,
-        `UPDATE temp_payment_data 
-         SET created_at = NOW() 
-         WHERE checkout_id = ?`,
-        checkoutID)
-    
-    if err != nil {
-        log.Printf("[RequestID: %s] Warning: Could not update payment data TTL: %v", requestID, err)
+    // O CVV é usado apenas nesta execução: apagar já, tentativas seguintes seguem sem ele
+    if paymentData.CardCVV != "" {
+        if err := w.cardVault.ConsumeCVV(checkoutID); err != nil {
+            log.Printf("[RequestID: %s] Warning: Could not delete CVV from temporary payment data: %v", requestID, err)
+        }
     }
     
-    // Criar objeto de requisição de pagamento
-    paymentReq := &models.PaymentRequest{
-        CardName:      cardName,
-        CardNumber:    cardNumber,
-        Expiry:        cardExpiry,
-        CVV:           cardCVV,
-        CheckoutID:    checkoutID,
-        CustomerEmail: checkout.Email,
-    }
+    // Criar objeto de requisição de pagamento (cartão, nonce do Accept.js ou o perfil
+    // já criado a partir do nonce em uma tentativa anterior)
+    paymentReq := paymentData.PaymentRequest()
+    paymentReq.CustomerEmail = checkout.Email
+    usedOpaqueData := paymentReq.HasOpaqueData()
     
     // Adicionar informações de billing
     if checkout.Street != "" {
@@ -362,10 +1085,26 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
             City:        checkout.City,
             State:       checkout.State,
             Zip:         checkout.ZipCode,
-            Country:     "US",
+            Country:     checkout.BillingCountry(),
             PhoneNumber: checkout.PhoneNumber,
         }
     }
+    paymentReq.Currency = checkout.Currency
+    
+    // 3-D Secure: ECI/CAVV da autenticação feita no checkout vão junto com a autorização
+    threeDSAuth, threeDSErr := w.db.GetCheckoutThreeDSAuthentication(checkoutID)
+    if threeDSErr == nil {
+        paymentReq.ThreeDSAuth = &types.ThreeDSAuthentication{
+            Status:    threeDSAuth.Status,
+            ECI:       threeDSAuth.ECI,
+            CAVV:      threeDSAuth.CAVV,
+            DSTransID: threeDSAuth.DSTransID,
+            Version:   threeDSAuth.Version,
+        }
+        log.Printf("[RequestID: %s] Using 3-D Secure authentication %s (transStatus %s)", requestID, threeDSAuth.TransID, threeDSAuth.Status)
+    } else if threeDSErr != sql.ErrNoRows {
+        log.Printf("[RequestID: %s] Warning: Could not load 3-D Secure authentication: %v", requestID, threeDSErr)
+    }
     
     // ETAPA 1: Processar transação teste de $1 (com tentativas)
     log.Printf("[RequestID: %s] Step 1: Processing test transaction", requestID)
@@ -408,6 +1147,12 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
         }
         
         log.Printf("[RequestID: %s] All test transaction attempts failed", requestID)
+        
+        // Recusa do emissor não muda com novas tentativas: falha final, com email ao cliente
+        if transactionErr == nil && resp != nil {
+            return queue.Declined(w.handlePaymentFailure(checkout, requestID, finalError, true))
+        }
+        
         isLastAttempt := w.isLastAttempt(job)
         return w.handlePaymentFailure(checkout, requestID, finalError, isLastAttempt)
     }
@@ -415,6 +1160,14 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
     transactionID := resp.TransactionID
     log.Printf("[RequestID: %s] Test transaction successful: %s", requestID, transactionID)
     
+    // O nonce do Accept.js foi consumido pela autorização: guardar o perfil criado a
+    // partir dele para as etapas e tentativas seguintes
+    if usedOpaqueData {
+        if err := w.cardVault.ConsumeOpaqueData(checkoutID, paymentReq); err != nil {
+            log.Printf("[RequestID: %s] Warning: Could not store customer profile created from payment nonce: %v", requestID, err)
+        }
+    }
+    
     // ETAPA 2: Fazer VOID da transação teste
     log.Printf("[RequestID: %s] Step 2: Voiding test transaction", requestID)
     
@@ -515,6 +1268,13 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
 
     // Se encontrou o master reference, salvar Customer Profile IDs e atualizar subscription
     if masterRef != "" {
+        // Accept.js: o final do cartão só é conhecido após a autorização
+        if paymentReq.CardNumber == "" && paymentReq.AccountNumber != "" {
+            if cardErr := w.db.UpdatePaymentMethodCard(masterRef, paymentReq.AccountNumber); cardErr != nil {
+                log.Printf("[RequestID: %s] Warning: Failed to save masked card: %v", requestID, cardErr)
+            }
+        }
+
         profileSaveErr := w.db.SaveCustomerProfile(masterRef, customerProfileID, paymentProfileID)
         if profileSaveErr != nil {
             log.Printf("[RequestID: %s] Warning: Failed to save customer profile IDs: %v", requestID, profileSaveErr)
@@ -567,6 +1327,13 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
         if updateErr != nil {
             log.Printf("[RequestID: %s] Warning: Failed to update transaction: %v", requestID, updateErr)
         }
+
+        // Registrar na transação o resultado do 3-D Secure usado na autorização
+        if paymentReq.ThreeDSAuth != nil {
+            if threeDSRecordErr := w.db.RecordTransactionThreeDS(masterRef, transactionID, threeDSAuth); threeDSRecordErr != nil {
+                log.Printf("[RequestID: %s] Warning: Failed to record 3-D Secure result: %v", requestID, threeDSRecordErr)
+            }
+        }
     } else {
         log.Printf("[RequestID: %s] Could not find master reference - Customer Profile IDs and subscription not updated", requestID)
     }
@@ -592,21 +1359,11 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
     if statusErr != nil {
         log.Printf("[RequestID: %s] Warning: Failed to update payment status: %v", requestID, statusErr)
     }
-    go func() {
-        time.Sleep(6 * time.Hour) // Aguardar 6 horas antes de limpar
-        cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 5*time.Second)
-        defer cleanupCancel()
-        
-        _, cleanupErr := w.db.GetDB().ExecContext(cleanupCtx,
-            "DELETE FROM temp_payment_data WHERE checkout_id = ? AND created_at < NOW() - INTERVAL 4 HOUR",
-            checkoutID)
-        
-        if cleanupErr != nil {
-            log.Printf("[RequestID: %s] Warning: Failed to clean up temporary payment data: %v", requestID, cleanupErr)
-        } else {
-            log.Printf("[RequestID: %s] Successfully cleaned up temporary payment data", requestID)
-        }
-    }()
+
+    // Customer profile e ARB criados: os dados do cartão não são mais necessários
+    if cleanupErr := w.cardVault.Delete(checkoutID); cleanupErr != nil {
+        log.Printf("[RequestID: %s] Warning: Failed to clean up temporary payment data: %v", requestID, cleanupErr)
+    }
     
     // ETAPA 6: ENVIAR EMAIL DE INVOICE (apenas após sucesso completo)
     log.Printf("[RequestID: %s] Step 6: Sending invoice email after successful payment processing", requestID)
@@ -640,23 +1397,9 @@ func (w *Worker) processDelayedPaymentJob(job *queue.Job) error {
     return nil
 }
 
-func (w *Worker) tryRecoverPaymentDataFromJobHistory(checkoutID string) (string, string, string, string, error) {
-    log.Printf("Attempting to recover payment data from job history for checkout: %s", checkoutID)
-    
-    return "", "", "", "", fmt.Errorf("payment data recovery not implemented - data may have expired")
-}
-
-// CORREÇÃO: Nova função para verificar se é a última tentativa
+// isLastAttempt indica se esta é a última execução do job pela política de retry do tipo
 func (w *Worker) isLastAttempt(job *queue.Job) bool {
-    const maxRetries = 5 // Mesmo valor definido em queue/jobs_queue.go
-    
-    if isLast, exists := job.Data["is_last_attempt"]; exists {
-        if lastAttempt, ok := isLast.(bool); ok {
-            return lastAttempt
-        }
-    }
-    
-    return job.RetryCount >= maxRetries
+    return w.queue.IsLastAttempt(job)
 }
 
 // handlePaymentFailure - Trata falhas de pagamento, enviando email apenas se sendEmail = true
@@ -709,11 +1452,7 @@ func (w *Worker) handlePaymentFailure(checkout *models.CheckoutData, requestID,
         }
         
         // Limpar dados de cartão temporários apenas na última tentativa
-        _, cleanupErr := w.db.GetDB().ExecContext(ctx,
-            "DELETE FROM temp_payment_data WHERE checkout_id = ?",
-            checkout.ID)
-        
-        if cleanupErr != nil {
+        if cleanupErr := w.cardVault.Delete(checkout.ID); cleanupErr != nil {
             log.Printf("[RequestID: %s] Warning: Failed to clean up temporary payment data: %v", requestID, cleanupErr)
         }
     } else {
@@ -747,7 +1486,7 @@ func (w *Worker) generatePaymentFailureEmail(customerName, errorMessage string)
 func (w *Worker) processVoidTransaction(job *queue.Job) error {
 	transactionID, ok := job.Data["transaction_id"].(string)
 	if !ok || transactionID == "" {
-		return fmt.Errorf("invalid transaction_id in job data")
+		return queue.Permanent(fmt.Errorf("invalid transaction_id in job data"))
 	}
 	
 	log.Printf("Voiding transaction %s", transactionID)
@@ -759,7 +1498,7 @@ func (w *Worker) processVoidTransaction(job *queue.Job) error {
 func (w *Worker) processPaymentJob(job *queue.Job) error {
     checkoutID, ok := job.Data["checkout_id"].(string)
     if !ok || checkoutID == "" {
-        return fmt.Errorf("invalid checkout_id in job data")
+        return queue.Permanent(fmt.Errorf("invalid checkout_id in job data"))
     }
     
     requestID, _ := job.Data["request_id"].(string)
@@ -776,31 +1515,26 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
     }
     
     // Obter os dados do cartão armazenados temporariamente
-    var cardNumber, cardExpiry, cardCVV, cardName string
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-    
-    err = w.db.GetDB().QueryRowContext(ctx, 
-        `SELECT card_number, card_expiry, card_cvv, card_name 
-         FROM temp_payment_data 
-         WHERE checkout_id = ? 
-         AND created_at > NOW() - INTERVAL 1 HOUR`,
-        checkoutID).Scan(&cardNumber, &cardExpiry, &cardCVV, &cardName)
-    
+    paymentData, err := w.cardVault.Load(checkoutID)
     if err != nil {
         return fmt.Errorf("failed to retrieve payment data: %v", err)
     }
     
-    // Criar objeto de requisição de pagamento
-    paymentReq := &models.PaymentRequest{
-        CardName:      cardName,
-        CardNumber:    cardNumber,
-        Expiry:        cardExpiry,
-        CVV:           cardCVV,
-        CheckoutID:    checkoutID,
-        CustomerEmail: checkout.Email,
+    // O CVV é usado apenas nesta execução
+    if paymentData.CardCVV != "" {
+        if err := w.cardVault.ConsumeCVV(checkoutID); err != nil {
+            log.Printf("[RequestID: %s] Warning: Could not delete CVV from temporary payment data: %v", requestID, err)
+        }
     }
     
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    // Criar objeto de requisição de pagamento
+    paymentReq := paymentData.PaymentRequest()
+    paymentReq.CustomerEmail = checkout.Email
+    usedOpaqueData := paymentReq.HasOpaqueData()
+    
     // Adicionar informações de billing se disponíveis
     if checkout.Street != "" {
         paymentReq.BillingInfo = &types.BillingInfoType{
@@ -810,34 +1544,49 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
             City:        checkout.City,
             State:       checkout.State,
             Zip:         checkout.ZipCode,
-            Country:     "US",
+            Country:     checkout.BillingCountry(),
             PhoneNumber: checkout.PhoneNumber,
         }
     }
+    paymentReq.Currency = checkout.Currency
     
-    // Processar o pagamento (com retries)
     var resp *models.TransactionResponse
     var processErr error
     
-    for attempt := 0; attempt < 3; attempt++ {
-        if attempt > 0 {
-            log.Printf("[RequestID: %s] Retry payment attempt %d", requestID, attempt+1)
-            time.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
-        }
-        
-        resp, processErr = w.paymentService.ProcessInitialAuthorization(paymentReq)
-        if processErr == nil && (resp == nil || !resp.Success) {
-            processErr = fmt.Errorf("payment unsuccessful: %s", resp.Message)
+    // A conta só é criada depois da cobrança: o resgate do cupom é reservado antes, para
+    // que um cupom esgotado nesse meio tempo não deixe o cliente cobrado e sem conta
+    if checkout.Coupon != nil {
+        reserved, err := w.db.ReserveCheckoutCoupon(checkout)
+        if err != nil {
+            return fmt.Errorf("failed to reserve coupon: %v", err)
         }
-        
-        if processErr == nil {
-            break
+        if !reserved {
+            processErr = fmt.Errorf("coupon %s can no longer be used", checkout.Coupon.Code)
         }
-        
-        // Se não for um erro de timeout, não faz mais tentativas
-        if !strings.Contains(processErr.Error(), "deadline exceeded") && 
-           !strings.Contains(processErr.Error(), "timeout") {
-            break
+    }
+    
+    // Processar o pagamento (com retries)
+    if processErr == nil {
+        for attempt := 0; attempt < 3; attempt++ {
+            if attempt > 0 {
+                log.Printf("[RequestID: %s] Retry payment attempt %d", requestID, attempt+1)
+                time.Sleep(time.Duration(math.Pow(2, float64(attempt))) * time.Second)
+            }
+            
+            resp, processErr = w.paymentService.ProcessInitialAuthorization(paymentReq)
+            if processErr == nil && (resp == nil || !resp.Success) {
+                processErr = fmt.Errorf("payment unsuccessful: %s", resp.Message)
+            }
+            
+            if processErr == nil {
+                break
+            }
+            
+            // Se não for um erro de timeout, não faz mais tentativas
+            if !strings.Contains(processErr.Error(), "deadline exceeded") && 
+               !strings.Contains(processErr.Error(), "timeout") {
+                break
+            }
         }
     }
     
@@ -854,6 +1603,13 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
         transactionID = resp.TransactionID
         log.Printf("[RequestID: %s] Payment processed successfully: %s", requestID, transactionID)
         
+        // Nonce do Accept.js consumido: o job de assinatura usa o perfil criado a partir dele
+        if usedOpaqueData {
+            if err := w.cardVault.ConsumeOpaqueData(checkoutID, paymentReq); err != nil {
+                log.Printf("[RequestID: %s] Warning: Could not store customer profile created from payment nonce: %v", requestID, err)
+            }
+        }
+        
         // Enfileirar jobs subsequentes (void, subscription, account creation)
         ctxJobs := context.Background()
         
@@ -862,22 +1618,18 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
             "transaction_id": transactionID,
             "checkout_id":    checkoutID,
             "request_id":     requestID,
-        })
+        }, queue.WithIdempotencyKey("void_transaction:"+transactionID))
         if err != nil {
             log.Printf("[RequestID: %s] Failed to enqueue void transaction job: %v", requestID, err)
         }
         
-        // Subscription job
+        // Subscription job (os dados do cartão são lidos de temp_payment_data, não do payload)
         err = w.queue.Enqueue(ctxJobs, queue.JobTypeCreateSubscription, map[string]interface{}{
             "checkout_id":    checkoutID,
             "transaction_id": transactionID,
-            "card_number":    cardNumber,
-            "card_expiry":    cardExpiry, 
-            "card_cvv":       cardCVV,
-            "card_name":      cardName,
             "email":          checkout.Email,
             "request_id":     requestID,
-        })
+        }, queue.WithIdempotencyKey("create_subscription:"+checkoutID))
         if err != nil {
             log.Printf("[RequestID: %s] Failed to enqueue subscription job: %v", requestID, err)
         }
@@ -886,12 +1638,8 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
         err = w.queue.Enqueue(ctxJobs, queue.JobTypeCreateAccount, map[string]interface{}{
             "checkout_id":    checkoutID,
             "transaction_id": transactionID,
-            "card_number":    cardNumber,
-            "card_expiry":    cardExpiry, 
-            "card_cvv":       cardCVV,
-            "card_name":      cardName,
             "request_id":     requestID,
-        })
+        }, queue.WithIdempotencyKey("create_account:"+checkoutID))
         if err != nil {
             log.Printf("[RequestID: %s] Failed to enqueue account creation job: %v", requestID, err)
         }
@@ -917,15 +1665,16 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
         log.Printf("[RequestID: %s] Failed to store payment result: %v", requestID, err)
     }
     
-    // Se o pagamento falhou, limpar os dados temporários
+    // Se o pagamento falhou, limpar os dados temporários e devolver o cupom reservado
     if status == "failed" {
-        _, err = w.db.GetDB().ExecContext(ctx,
-            "DELETE FROM temp_payment_data WHERE checkout_id = ?",
-            checkoutID)
-        
-        if err != nil {
+        if err := w.cardVault.Delete(checkoutID); err != nil {
             log.Printf("[RequestID: %s] Warning: Failed to clean up temporary payment data: %v", requestID, err)
         }
+        if checkout.Coupon != nil {
+            if err := w.db.ReleaseCheckoutCoupon(checkoutID); err != nil {
+                log.Printf("[RequestID: %s] Error releasing coupon reservation: %v", requestID, err)
+            }
+        }
     }
     
     return nil
@@ -935,12 +1684,12 @@ func (w *Worker) processPaymentJob(job *queue.Job) error {
 func (w *Worker) processCreateAccountJob(job *queue.Job) error {
     checkoutID, ok := job.Data["checkout_id"].(string)
     if !ok || checkoutID == "" {
-        return fmt.Errorf("invalid checkout_id in job data")
+        return queue.Permanent(fmt.Errorf("invalid checkout_id in job data"))
     }
     
     transactionID, ok := job.Data["transaction_id"].(string)
     if !ok || transactionID == "" {
-        return fmt.Errorf("invalid transaction_id in job data")
+        return queue.Permanent(fmt.Errorf("invalid transaction_id in job data"))
     }
     
     requestID, _ := job.Data["request_id"].(string)
@@ -989,13 +1738,19 @@ func (w *Worker) processCreateAccountJob(job *queue.Job) error {
         return fmt.Errorf("failed to get checkout data: %v", err)
     }
     
-    // Extrair dados do cartão do job
-    cardNumber, _ := job.Data["card_number"].(string)
-    cardExpiry, _ := job.Data["card_expiry"].(string)
+    // Dados do cartão gravados no checkout (o payload do job não carrega dados de cartão)
+    paymentData, err := w.cardVault.Load(checkoutID)
+    if err != nil {
+        return fmt.Errorf("failed to retrieve payment data: %v", err)
+    }
     
+    // Checkouts com Accept.js só têm o número mascarado devolvido na autorização
     cardData := &models.CardData{
-        Number: cardNumber,
-        Expiry: cardExpiry,
+        Number: paymentData.CardNumber,
+        Expiry: paymentData.CardExpiry,
+    }
+    if cardData.Number == "" {
+        cardData.Number = paymentData.AccountNumber
     }
     
     log.Printf("[RequestID: %s] Creating account for checkout %s", requestID, checkoutID)
@@ -1006,18 +1761,8 @@ func (w *Worker) processCreateAccountJob(job *queue.Job) error {
         return fmt.Errorf("error creating account: %v", err)
     }
     
-    // Limpar dados de cartão temporários depois de criar a conta
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-    
-    _, err = w.db.GetDB().ExecContext(ctx,
-        "DELETE FROM temp_payment_data WHERE checkout_id = ?",
-        checkoutID)
-    
-    if err != nil {
-        log.Printf("[RequestID: %s] Warning: Failed to clean up temporary payment data: %v", requestID, err)
-    }
-    
+    // Os dados temporários não são apagados aqui: o job de assinatura ainda precisa deles.
+    // Ele os remove ao concluir; caso contrário o sweeper apaga após o TTL.
     log.Printf("[RequestID: %s] Successfully created account for checkout %s", requestID, checkoutID)
     return nil
 }
@@ -1042,6 +1787,10 @@ func (w *Worker) createAccountsAndNotify(checkout *models.CheckoutData, cardData
     firstName := names[0]
     lastName := strings.Join(names[1:], " ")
 
+    // O trial dura o trial_days dos planos comprados
+    trialStart := time.Now()
+    trialEnd := checkout.TrialEndDate(trialStart)
+
     // Preparar a conta master
     masterAccount := &models.MasterAccount{
         Name:             firstName,
@@ -1060,7 +1809,8 @@ func (w *Worker) createAccountsAndNotify(checkout *models.CheckoutData, cardData
         Plan:            checkout.PlanID,
         PurchasedPlans:  checkout.PlansJSON,
         SimultaneousUsers: len(checkout.Plans),
-        RenewDate:       time.Now().AddDate(0, 1, 0),
+        RenewDate:       trialEnd,
+        Currency:        checkout.Currency,
     }
 
     // Calcular preço total
@@ -1107,6 +1857,7 @@ func (w *Worker) createAccountsAndNotify(checkout *models.CheckoutData, cardData
         MasterReference: masterUUID,
         IsTrial:        1,
         Total:          0,
+        Currency:       checkout.Currency,
         DueDate:        time.Now(),
         IsPaid:         1,
     }
@@ -1116,11 +1867,13 @@ func (w *Worker) createAccountsAndNotify(checkout *models.CheckoutData, cardData
         return fmt.Errorf("failed to create trial invoice: %v", err)
     }
 
-    futureDate := time.Now().AddDate(0, 1, 0)
+    futureDate := trialEnd
     futureInvoice := &models.Invoice{
         MasterReference: masterUUID,
         IsTrial:        0,
-        Total:          total,
+        Total:          total - checkout.CouponDiscount + checkout.Tax,
+        Tax:            checkout.Tax,
+        Currency:       checkout.Currency,
         DueDate:        futureDate,
         IsPaid:         0,
     }
@@ -1142,6 +1895,24 @@ func (w *Worker) createAccountsAndNotify(checkout *models.CheckoutData, cardData
         return fmt.Errorf("failed to save subscription: %v", err)
     }
 
+    if err := tx.SaveAccountTrial(masterUUID, trialStart, trialEnd); err != nil {
+        tx.Rollback()
+        return fmt.Errorf("failed to save account trial: %v", err)
+    }
+
+    // Cupom do checkout: completa o resgate reservado antes da cobrança
+    if checkout.Coupon != nil {
+        redeemed, err := tx.RedeemCheckoutCoupon(checkout, masterUUID, trialEnd)
+        if err != nil {
+            tx.Rollback()
+            return fmt.Errorf("failed to redeem coupon: %v", err)
+        }
+        if !redeemed {
+            tx.Rollback()
+            return fmt.Errorf("coupon %s can no longer be used", checkout.Coupon.Code)
+        }
+    }
+
     // Commit da transação
     if err := tx.Commit(); err != nil {
         return fmt.Errorf("failed to commit transaction: %v", err)
@@ -1176,7 +1947,7 @@ func (w *Worker) createAccountsAndNotify(checkout *models.CheckoutData, cardData
         "customer_name":  checkout.Name,
         "activation_url": activationURL,
         "request_id":     fmt.Sprintf("worker-activation-%s", masterUUID),
-    }, activationDelay)
+    }, activationDelay, queue.WithIdempotencyKey("activation_email:"+checkout.Username))
     
     if err != nil {
         log.Printf("Warning: Failed to enqueue activation email job: %v", err)
@@ -1249,10 +2020,10 @@ func (w *Worker) generateInvoiceEmail(checkout *models.CheckoutData) string {
         plansTable += fmt.Sprintf(`
             <tr>
                 <td>%s</td>
-                <td>$%.2f</td>
+                <td>%s</td>
             </tr>`, 
             plan.PlanName, 
-            planPrice,
+            models.FormatMoney(planPrice, checkout.Currency),
         )
     }
     plansTable += `
@@ -1261,11 +2032,19 @@ func (w *Worker) generateInvoiceEmail(checkout *models.CheckoutData) string {
 
     // Seção de totais mais clara
     totalsSection := fmt.Sprintf(`
-        <p><strong>Subtotal:</strong> $%.2f</p>
-        <p><strong>Discount:</strong> $%.2f</p>
-        <p><strong>Validation charge (refunded):</strong> $0.01</p>
-        <p style="font-size: 18px; font-weight: bold; color: #28a745;"><strong>Total Paid:</strong> $0.01</p>
-    `, total, total-0.01)
+        <p><strong>Subtotal:</strong> %s</p>
+        <p><strong>Discount:</strong> %s</p>
+        <p><strong>Validation charge (refunded):</strong> %s</p>
+        <p style="font-size: 18px; font-weight: bold; color: #28a745;"><strong>Total Paid:</strong> %s</p>
+    `, models.FormatMoney(total, checkout.Currency), models.FormatMoney(total-0.01, checkout.Currency),
+        models.FormatMoney(0.01, checkout.Currency), models.FormatMoney(0.01, checkout.Currency))
+
+    // Imposto sobre vendas: cobrado junto com a primeira cobrança da assinatura
+    if checkout.Tax > 0 {
+        totalsSection += fmt.Sprintf(`
+        <p><strong>Sales Tax (%.3g%%):</strong> %s, included in your first charge of %s</p>
+    `, checkout.TaxRate, models.FormatMoney(checkout.Tax, checkout.Currency), models.FormatMoney(checkout.Total, checkout.Currency))
+    }
 
     footer := fmt.Sprintf(
         "Thank you %s for choosing our services. If you have any questions, please contact our support team.",
@@ -1288,234 +2067,149 @@ func (w *Worker) generateInvoiceEmail(checkout *models.CheckoutData) string {
 // processCreateSubscription sets up a recurring billing subscription
 func (w *Worker) processCreateSubscription(job *queue.Job) error {
 	log.Printf("Processing subscription creation job %s", job.ID)
-	
+
 	// Extrair dados do job com verificações de tipo
 	checkoutID, ok := job.Data["checkout_id"].(string)
 	if !ok || checkoutID == "" {
-		return fmt.Errorf("invalid checkout_id in job data")
+		return queue.Permanent(fmt.Errorf("invalid checkout_id in job data"))
 	}
-	
+
 	// Obter dados do checkout
 	checkout, err := w.db.GetCheckoutData(checkoutID)
 	if err != nil {
 		return fmt.Errorf("failed to get checkout data: %v", err)
 	}
-	
-	// Extrair os dados do cartão com verificações de segurança
-	var cardName, cardNumber, expiry, cvv, email string
-	
-	// Verificar cada campo individualmente com fallbacks seguros
-	if cardNameVal, ok := job.Data["card_name"]; ok && cardNameVal != nil {
-		if cardNameStr, ok := cardNameVal.(string); ok {
-			cardName = cardNameStr
-		}
+
+	// O email vem do job; na falta dele, usa o do checkout
+	email := checkout.Email
+	if emailStr, ok := job.Data["email"].(string); ok && emailStr != "" {
+		email = emailStr
 	}
-	
-	if cardNumberVal, ok := job.Data["card_number"]; ok && cardNumberVal != nil {
-		if cardNumberStr, ok := cardNumberVal.(string); ok {
-			cardNumber = cardNumberStr
-		}
+
+	// Os dados do cartão ficam cifrados em temp_payment_data; payloads de job não
+	// carregam dados de cartão. O CVV normalmente já foi consumido pela autorização.
+	paymentData, err := w.cardVault.Load(checkoutID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve payment data: %v", err)
 	}
-	
-	if expiryVal, ok := job.Data["card_expiry"]; ok && expiryVal != nil {
-		if expiryStr, ok := expiryVal.(string); ok {
-			expiry = expiryStr
-		}
+
+	// Checkouts com Accept.js não têm cartão: usam o perfil criado a partir do nonce
+	hasCard := paymentData.CardName != "" && paymentData.CardNumber != "" && paymentData.CardExpiry != ""
+	if !hasCard && (paymentData.CustomerProfileID == "" || paymentData.PaymentProfileID == "") {
+		return fmt.Errorf("insufficient payment data for subscription creation")
 	}
-	
-	if cvvVal, ok := job.Data["card_cvv"]; ok && cvvVal != nil {
-		if cvvStr, ok := cvvVal.(string); ok {
-			cvv = cvvStr
-		}
+
+	// Criar objeto de requisição de pagamento com os dados obtidos
+	paymentRequest := paymentData.PaymentRequest()
+	paymentRequest.CustomerEmail = email
+
+	log.Printf("Setting up subscription for checkout %s", checkoutID)
+
+	// CORREÇÃO: Verificar se o username não é muito longo para subscription
+	if len(checkout.Username) > 30 { // Limitar username para evitar nome de subscription muito longo
+		log.Printf("Warning: Username '%s' is very long (%d chars), may cause subscription name issues",
+			checkout.Username, len(checkout.Username))
 	}
-	
-	if emailVal, ok := job.Data["email"]; ok && emailVal != nil {
-		if emailStr, ok := emailVal.(string); ok {
-			email = emailStr
-		} else {
-			// Se não conseguir extrair o email do job, use o do checkout
-			email = checkout.Email
-		}
-	} else {
-		// Fallback para o email do checkout
-		email = checkout.Email
+
+	// Configurar a assinatura recorrente
+	subscriptionID, err := w.paymentService.SetupRecurringBilling(paymentRequest, checkout)
+	if err != nil {
+		return fmt.Errorf("failed to setup recurring billing: %v", err)
 	}
-	
-	// Verificar se temos todos os dados necessários
-	if cardName == "" || cardNumber == "" || expiry == "" || cvv == "" {
-		log.Printf("Missing card information in job data. Attempting to retrieve from database.")
-		
-		// Verificar se a tabela existe
-		var tableExists int
-		tableCheckCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		
-		// Esta consulta funciona em MySQL/MariaDB
-		err := w.db.GetDB().QueryRowContext(tableCheckCtx, 
-			"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'temp_payment_data'").Scan(&tableExists)
-		
-		if err != nil || tableExists == 0 {
-			log.Printf("Error checking temp_payment_data table existence: %v", err)
-			
-			// Tentar criar a tabela
-			_, err = w.db.GetDB().ExecContext(tableCheckCtx, `
-				CREATE TABLE IF NOT EXISTS temp_payment_data (
-					checkout_id VARCHAR(36) PRIMARY KEY,
-					card_number VARCHAR(19) NOT NULL,
-					card_expiry VARCHAR(5) NOT NULL,
-					card_cvv VARCHAR(4) NOT NULL,
-					card_name VARCHAR(255) NOT NULL,
-					created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-				)
-			`)
-			
-			if err != nil {
-				log.Printf("Failed to create temp_payment_data table: %v", err)
-				return fmt.Errorf("insufficient payment data and could not create table: %v", err)
-			}
-			
-			log.Printf("Created temp_payment_data table")
-			return fmt.Errorf("insufficient payment data and temp_payment_data table was just created - please try again")
-		}
-		
-		// A tabela existe, tentar recuperar os dados
-		dataCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		
-		var tempCardName, tempCardNumber, tempExpiry, tempCVV string
-		err = w.db.GetDB().QueryRowContext(dataCtx, 
-			"SELECT card_name, card_number, card_expiry, card_cvv FROM temp_payment_data WHERE checkout_id = ?",checkoutID).Scan(&tempCardName, &tempCardNumber, &tempExpiry, &tempCVV)
-		
-			if err != nil {
-				log.Printf("Error retrieving payment data from temp_payment_data: %v", err)
-				return fmt.Errorf("failed to retrieve payment data from database: %v", err)
-			}
-			
-			// Usar os dados recuperados
-			cardName = tempCardName
-			cardNumber = tempCardNumber
-			expiry = tempExpiry
-			cvv = tempCVV
-		}
-		
-		// Verificar se ainda temos dados insuficientes
-		if cardName == "" || cardNumber == "" || expiry == "" || cvv == "" {
-			missingFields := ""
-			if cardName == "" {
-				missingFields += "cardName "
-			}
-			if cardNumber == "" {
-				missingFields += "cardNumber "
-			}
-			if expiry == "" {
-				missingFields += "expiry "
-			}
-			if cvv == "" {
-				missingFields += "cvv"
-			}
-			return fmt.Errorf("insufficient payment data for subscription creation. Fields missing: %s", missingFields)
-		}
-		
-		// Criar objeto de requisição de pagamento com os dados obtidos
-		paymentRequest := &models.PaymentRequest{
-			CardName:      cardName,
-			CardNumber:    cardNumber,
-			Expiry:        expiry,
-			CVV:           cvv,
-			CheckoutID:    checkoutID,
-			CustomerEmail: email,
-		}
-		
-		log.Printf("Setting up subscription for checkout %s", checkoutID)
-		
-		// CORREÇÃO: Verificar se o username não é muito longo para subscription
-		if len(checkout.Username) > 30 { // Limitar username para evitar nome de subscription muito longo
-			log.Printf("Warning: Username '%s' is very long (%d chars), may cause subscription name issues", 
-				checkout.Username, len(checkout.Username))
-		}
-		
-		// Configurar a assinatura recorrente
-		subscriptionID, err := w.paymentService.SetupRecurringBilling(paymentRequest, checkout)
-        if err != nil {
-            return fmt.Errorf("failed to setup recurring billing: %v", err)
-        }
-        
-        log.Printf("Successfully set up subscription with ID: %s for checkout %s", subscriptionID, checkoutID)
-        
-        // Atualizar o status da assinatura no banco de dados
-        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-        defer cancel()
-        
-        // Buscar o master_reference associado ao checkout
-        var masterRef string
-        err = w.db.GetDB().QueryRowContext(ctx, 
-            "SELECT master_reference FROM transactions WHERE checkout_id = ? LIMIT 1", 
-            checkoutID).Scan(&masterRef)
-        
-        if err != nil {
-            return fmt.Errorf("failed to get master reference: %v", err)
-        }
-        
-        // CORRIGIDO: Atualizar a assinatura com o ID da assinatura da Authorize.net
-        _, err = w.db.GetDB().ExecContext(ctx, 
-            `UPDATE subscriptions 
+
+	log.Printf("Successfully set up subscription with ID: %s for checkout %s", subscriptionID, checkoutID)
+
+	// Atualizar o status da assinatura no banco de dados
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Buscar o master_reference associado ao checkout
+	var masterRef string
+	err = w.db.GetDB().QueryRowContext(ctx,
+		"SELECT master_reference FROM transactions WHERE checkout_id = ? LIMIT 1",
+		checkoutID).Scan(&masterRef)
+
+	if err != nil {
+		return fmt.Errorf("failed to get master reference: %v", err)
+	}
+
+	// CORRIGIDO: Atualizar a assinatura com o ID da assinatura da Authorize.net
+	_, err = w.db.GetDB().ExecContext(ctx,
+		`UPDATE subscriptions 
              SET status = 'active', 
                  subscription_id = ?,
                  updated_at = NOW()
-             WHERE master_reference = ?`, 
-            subscriptionID, masterRef)
-        
-        if err != nil {
-            return fmt.Errorf("failed to update subscription status: %v", err)
-        }
-        
-        log.Printf("Successfully updated subscription status to active with ID: %s for checkout %s", subscriptionID, checkoutID)
-		
-		log.Printf("Successfully set up subscription for checkout %s", checkoutID)
-		
-		// Limpar os dados temporários do cartão depois de processar com sucesso
-		cleanCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		
-		_, err = w.db.GetDB().ExecContext(cleanCtx,
-			"DELETE FROM temp_payment_data WHERE checkout_id = ?",
-			checkoutID)
-		
-		if err != nil {
-			log.Printf("Warning: Failed to clean up temporary payment data: %v", err)
-		}
-		
-		return nil
+             WHERE master_reference = ?`,
+		subscriptionID, masterRef)
+
+	if err != nil {
+		return fmt.Errorf("failed to update subscription status: %v", err)
 	}
-	
-	// Start a worker with configuration
-	func StartWorker(cfg *config.Config, concurrency int) (*Worker, error) {
-		// Connect to database
-		db, err := database.NewConnection(cfg.Database)
-		if err != nil {
-			return nil, fmt.Errorf("failed to connect to database: %v", err)
-		}
-		
-		// Create payment service
-		paymentService := payment.NewPaymentService(
+
+	log.Printf("Successfully updated subscription status to active with ID: %s for checkout %s", subscriptionID, checkoutID)
+
+	log.Printf("Successfully set up subscription for checkout %s", checkoutID)
+
+	// Limpar os dados temporários do cartão depois de processar com sucesso
+	if err := w.cardVault.Delete(checkoutID); err != nil {
+		log.Printf("Warning: Failed to clean up temporary payment data: %v", err)
+	}
+
+	return nil
+}
+
+// Start a worker with configuration
+func StartWorker(cfg *config.Config, concurrency int) (*Worker, error) {
+	if cfg.AuthNet.Gateway == "fake" && cfg.AuthNet.Environment == "production" {
+		return nil, fmt.Errorf("PAYMENT_GATEWAY=fake cannot be used with AUTHNET_ENVIRONMENT=production")
+	}
+
+	// Connect to database
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	// Create payment service
+	var paymentService *payment.Service
+	if cfg.AuthNet.Gateway == "fake" {
+		paymentService = payment.NewPaymentServiceWithGateway(fake.NewGateway())
+	} else {
+		paymentService = payment.NewPaymentService(
 			cfg.AuthNet.APILoginID,
 			cfg.AuthNet.TransactionKey,
 			cfg.AuthNet.MerchantID,
 			cfg.AuthNet.Environment,
+			cfg.AuthNet.Endpoint,
 		)
-		
-		// Create email service
-		emailService := email.NewSMTPService(cfg.SMTP)
-		
-		// Connect to Redis queue
-		queue, err := queue.NewQueue(cfg.Redis.URL, "payment_jobs")
-		if err != nil {
-			return nil, fmt.Errorf("failed to connect to Redis: %v", err)
-		}
-		
-		// Create and start worker
-		worker := NewWorker(queue, db, paymentService, emailService)
-		worker.Start(concurrency)
-		
-		return worker, nil
-	}
\ No newline at end of file
+	}
+
+	// Create email service
+	emailService := email.NewSMTPService(cfg.SMTP)
+
+	// Create card data vault
+	cardVault, err := cardvault.NewVault(db, cfg.CardData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize card data vault: %v", err)
+	}
+
+	// Sales tax engine: cobranças de pro-rata e recorrência calculam imposto pelo endereço
+	taxEngine, err := tax.New(cfg.Tax)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tax rates: %v", err)
+	}
+	db.SetTaxEngine(taxEngine)
+
+	// Connect to Redis queue
+	queue, err := queue.NewQueue(cfg.Redis.URL, "payment_jobs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	queue.SetJobStore(db)
+
+	// Create and start worker
+	worker := NewWorker(queue, db, paymentService, emailService, cardVault, cfg.Dunning)
+	worker.Start(concurrency)
+
+	return worker, nil
+}
@@ -0,0 +1,23 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+
+	"prosecure-payment-api/config"
+)
+
+func TestStartWorkerRefusesFakeGatewayInProduction(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AuthNet.Gateway = "fake"
+	cfg.AuthNet.Environment = "production"
+
+	// A checagem vem antes de qualquer conexão: não precisa de banco nem Redis
+	worker, err := StartWorker(cfg, 1)
+	if err == nil || worker != nil {
+		t.Fatalf("StartWorker() = %v, %v; want an error", worker, err)
+	}
+	if !strings.Contains(err.Error(), "PAYMENT_GATEWAY=fake") {
+		t.Errorf("error = %q, want it to name PAYMENT_GATEWAY=fake", err)
+	}
+}